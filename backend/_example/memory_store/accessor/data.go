@@ -25,6 +25,7 @@ type MemData struct {
 	posts     map[string][]store.Comment // key is siteID
 	metaUsers map[string]metaUser        // key is userID
 	metaPosts map[store.Locator]metaPost // key is post's locator
+	aliases   map[store.Locator]string   // key is alias locator, value is canonical url of the post
 	sync.RWMutex
 }
 
@@ -32,6 +33,10 @@ type metaPost struct {
 	PostURL  string
 	SiteID   string
 	ReadOnly bool
+	Title    string
+	Image    string
+	Created  time.Time
+	Aliases  []string
 }
 
 type metaUser struct {
@@ -50,6 +55,7 @@ func NewMemData() *MemData {
 		posts:     map[string][]store.Comment{},
 		metaUsers: map[string]metaUser{},
 		metaPosts: map[store.Locator]metaPost{},
+		aliases:   map[store.Locator]string{},
 	}
 	return result
 }
@@ -57,6 +63,10 @@ func NewMemData() *MemData {
 // Create new comment
 func (m *MemData) Create(comment store.Comment) (commentID string, err error) {
 
+	m.RLock()
+	comment.Locator = m.resolve(comment.Locator)
+	m.RUnlock()
+
 	if ro, e := m.Flag(engine.FlagRequest{Flag: engine.ReadOnly, Locator: comment.Locator}); e == nil && ro {
 		return "", errors.Errorf("post %s is read-only", comment.Locator.URL)
 	}
@@ -71,6 +81,16 @@ func (m *MemData) Create(comment store.Comment) (commentID string, err error) {
 	}
 	comments = append(comments, comment)
 	m.posts[comment.Locator.SiteID] = comments
+
+	// make post record for the first comment, set missing title
+	meta, ok := m.metaPosts[comment.Locator]
+	if !ok {
+		meta = metaPost{PostURL: comment.Locator.URL, SiteID: comment.Locator.SiteID, Created: comment.Timestamp}
+	}
+	if meta.Title == "" {
+		meta.Title = comment.PostTitle
+	}
+	m.metaPosts[comment.Locator] = meta
 	return comment.ID, nil
 }
 
@@ -80,6 +100,7 @@ func (m *MemData) Find(req engine.FindRequest) (comments []store.Comment, err er
 	defer m.RUnlock()
 
 	comments = []store.Comment{}
+	req.Locator = m.resolve(req.Locator)
 
 	if req.Sort == "" {
 		req.Sort = "time"
@@ -92,6 +113,11 @@ func (m *MemData) Find(req engine.FindRequest) (comments []store.Comment, err er
 			return c.Locator == req.Locator && (req.Since.IsZero() || c.Timestamp.After(req.Since))
 		})
 
+	case req.Locator.SiteID != "" && req.ImageID != "": // find comments referencing the image
+		comments = m.match(m.posts[req.Locator.SiteID], func(c store.Comment) bool {
+			return !c.Deleted && contains(c.Images, req.ImageID)
+		})
+
 	case req.Locator.SiteID != "" && req.Locator.URL == "" && req.UserID == "": // find last comments for site
 		if req.Limit > lastLimit || req.Limit == 0 {
 			req.Limit = lastLimit
@@ -134,7 +160,7 @@ func (m *MemData) Find(req engine.FindRequest) (comments []store.Comment, err er
 func (m *MemData) Get(req engine.GetRequest) (comment store.Comment, err error) {
 	m.RLock()
 	defer m.RUnlock()
-	return m.get(req.Locator, req.CommentID)
+	return m.get(m.resolve(req.Locator), req.CommentID)
 }
 
 // Update updates comment for locator.URL with mutable part of comment
@@ -148,6 +174,7 @@ func (m *MemData) Update(comment store.Comment) error {
 func (m *MemData) Count(req engine.FindRequest) (count int, err error) {
 	m.RLock()
 	defer m.RUnlock()
+	req.Locator = m.resolve(req.Locator)
 
 	switch {
 	case req.Locator.URL != "": // comment's count for post
@@ -170,6 +197,7 @@ func (m *MemData) Info(req engine.InfoRequest) (res []store.PostInfo, err error)
 	m.RLock()
 	defer m.RUnlock()
 	res = []store.PostInfo{}
+	req.Locator = m.resolve(req.Locator)
 
 	if req.Locator.URL != "" { // post info
 		comments := m.match(m.posts[req.Locator.SiteID], func(c store.Comment) bool {
@@ -242,6 +270,9 @@ func (m *MemData) Info(req engine.InfoRequest) (res []store.PostInfo, err error)
 func (m *MemData) Flag(req engine.FlagRequest) (val bool, err error) {
 	m.Lock()
 	defer m.Unlock()
+	if req.UserID == "" {
+		req.Locator = m.resolve(req.Locator)
+	}
 
 	if req.Update == engine.FlagNonSet { // read flag value, no update requested
 		return m.checkFlag(req), nil
@@ -250,6 +281,134 @@ func (m *MemData) Flag(req engine.FlagRequest) (val bool, err error) {
 	return m.setFlag(req)
 }
 
+// Post sets or gets post record by url or alias, or lists all post records of the site
+func (m *MemData) Post(req engine.PostRequest) ([]store.Post, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if req.Update != nil { // save post record
+		post, err := m.setPost(req)
+		if err != nil {
+			return nil, err
+		}
+		return []store.Post{post}, nil
+	}
+
+	if req.Locator.URL == "" { // list of post records for site
+		res := []store.Post{}
+		for _, meta := range m.metaPosts {
+			if meta.SiteID == req.Locator.SiteID {
+				res = append(res, meta.post())
+			}
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i].Locator.URL < res[j].Locator.URL })
+		return res, nil
+	}
+
+	meta, ok := m.metaPosts[m.resolve(req.Locator)]
+	if !ok {
+		return nil, errors.Errorf("no post %s", req.Locator.URL)
+	}
+	return []store.Post{meta.post()}, nil
+}
+
+// Move moves comments, or all comments of the post, to another post of the same site.
+// Removes source post if no comments left in it
+func (m *MemData) Move(req engine.MoveRequest) ([]store.Comment, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if req.Locator.SiteID != req.To.SiteID {
+		return nil, errors.Errorf("can't move comments from site %s to %s", req.Locator.SiteID, req.To.SiteID)
+	}
+	req.Locator, req.To = m.resolve(req.Locator), m.resolve(req.To)
+	if req.Locator.URL == "" || req.To.URL == "" || req.Locator.URL == req.To.URL {
+		return nil, errors.Errorf("invalid move request %+v", req)
+	}
+
+	comments := m.posts[req.Locator.SiteID]
+	ids := map[string]bool{}
+	for _, id := range req.CommentIDs {
+		ids[id] = true
+	}
+	if len(ids) == 0 {
+		for _, c := range m.match(comments, func(c store.Comment) bool { return c.Locator == req.Locator }) {
+			ids[c.ID] = true
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.Errorf("no comments in %s", req.Locator.URL)
+	}
+
+	found := 0
+	for _, c := range comments {
+		if c.Locator == req.Locator && ids[c.ID] {
+			found++
+		}
+		if c.Locator == req.To && ids[c.ID] {
+			return nil, errors.Errorf("comment %s already in %s", c.ID, req.To.URL)
+		}
+	}
+	if found != len(ids) {
+		return nil, errors.Errorf("not all comments found in %s", req.Locator.URL)
+	}
+
+	dst, dstFound := m.metaPosts[req.To]
+	moved := []store.Comment{}
+	left := 0
+	for i, c := range comments {
+		if c.Locator != req.Locator {
+			continue
+		}
+		if !ids[c.ID] {
+			left++
+			continue
+		}
+		c.Locator = req.To
+		if !ids[c.ParentID] {
+			c.ParentID = "" // parent stays in source post
+		}
+		if dst.Title != "" {
+			c.PostTitle = dst.Title
+		}
+		comments[i] = c
+		moved = append(moved, c)
+	}
+	m.posts[req.Locator.SiteID] = comments
+
+	if !dstFound {
+		m.metaPosts[req.To] = metaPost{PostURL: req.To.URL, SiteID: req.To.SiteID, Title: moved[0].PostTitle,
+			Created: moved[0].Timestamp}
+	}
+	if left == 0 {
+		for _, a := range m.metaPosts[req.Locator].Aliases {
+			delete(m.aliases, store.Locator{SiteID: req.Locator.SiteID, URL: a})
+		}
+		delete(m.metaPosts, req.Locator)
+	}
+	return moved, nil
+}
+
+// Images returns ids of images referenced by comments of the site
+func (m *MemData) Images(req engine.FindRequest) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	res := []string{}
+	for _, c := range m.posts[req.Locator.SiteID] {
+		if c.Deleted {
+			continue
+		}
+		for _, id := range c.Images {
+			if !contains(res, id) {
+				res = append(res, id)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
 // ListFlags get list of flagged keys, like blocked & verified user
 // works for full locator (post flags) or with userID
 func (m *MemData) ListFlags(req engine.FlagRequest) (res []interface{}, err error) {
@@ -522,6 +681,66 @@ func (m *MemData) deleteUserDetail(locator store.Locator, userID string, userDet
 	return nil
 }
 
+// setPost saves post record and its aliases, alias can't be the url of another post or alias of another post
+func (m *MemData) setPost(req engine.PostRequest) (store.Post, error) {
+	post := *req.Update
+	post.Locator.SiteID = req.Locator.SiteID
+	if post.Locator.URL == "" {
+		post.Locator.URL = req.Locator.URL
+	}
+	if post.Locator.URL == "" {
+		return post, errors.New("no url for post record")
+	}
+	if v, ok := m.aliases[post.Locator]; ok {
+		return post, errors.Errorf("url %s is alias of post %s", post.Locator.URL, v)
+	}
+
+	aliases := []string{}
+	for _, a := range post.Aliases {
+		if a == "" || a == post.Locator.URL || contains(aliases, a) {
+			continue
+		}
+		aliasLoc := store.Locator{SiteID: post.Locator.SiteID, URL: a}
+		if v, ok := m.aliases[aliasLoc]; ok && v != post.Locator.URL {
+			return post, errors.Errorf("alias %s already used by post %s", a, v)
+		}
+		if _, ok := m.metaPosts[aliasLoc]; ok {
+			return post, errors.Errorf("alias %s is url of another post", a)
+		}
+		aliases = append(aliases, a)
+	}
+
+	prev := m.metaPosts[post.Locator]
+	for _, a := range prev.Aliases {
+		delete(m.aliases, store.Locator{SiteID: post.Locator.SiteID, URL: a})
+	}
+	for _, a := range aliases {
+		m.aliases[store.Locator{SiteID: post.Locator.SiteID, URL: a}] = post.Locator.URL
+	}
+	post.Aliases = nil
+	if len(aliases) > 0 {
+		post.Aliases = aliases
+	}
+	if post.Created.IsZero() {
+		post.Created = prev.Created
+	}
+	if post.Created.IsZero() {
+		post.Created = time.Now()
+	}
+
+	m.metaPosts[post.Locator] = metaPost{PostURL: post.Locator.URL, SiteID: post.Locator.SiteID, ReadOnly: post.ReadOnly,
+		Title: post.Title, Image: post.Image, Created: post.Created, Aliases: post.Aliases}
+	return post, nil
+}
+
+// resolve replaces alias url of the locator with canonical url of the post
+func (m *MemData) resolve(loc store.Locator) store.Locator {
+	if v, ok := m.aliases[loc]; ok {
+		loc.URL = v
+	}
+	return loc
+}
+
 func (m *MemData) get(loc store.Locator, commentID string) (store.Comment, error) {
 	comments := m.match(m.posts[loc.SiteID], func(c store.Comment) bool {
 		return c.Locator == loc && c.ID == commentID
@@ -538,6 +757,7 @@ func (m *MemData) updateComment(comment store.Comment) error {
 		if c.ID == comment.ID && c.Locator == comment.Locator {
 			c.Text = comment.Text
 			c.Orig = comment.Orig
+			c.Images = comment.Images
 			c.Score = comment.Score
 			c.Votes = comment.Votes
 			c.Pin = comment.Pin
@@ -560,3 +780,17 @@ func (m *MemData) match(comments []store.Comment, fn func(c store.Comment) bool)
 	}
 	return res
 }
+
+func (p metaPost) post() store.Post {
+	return store.Post{Locator: store.Locator{SiteID: p.SiteID, URL: p.PostURL}, Title: p.Title, Image: p.Image,
+		Created: p.Created, ReadOnly: p.ReadOnly, Aliases: p.Aliases}
+}
+
+func contains(list []string, val string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
@@ -445,6 +445,77 @@ func TestMemData_FlagReadOnlyPost(t *testing.T) {
 	assert.False(t, val, "url-1 writable")
 }
 
+func TestMemData_Post(t *testing.T) {
+	m := prepMem(t) // adds two comments
+
+	posts, err := m.Post(engine.PostRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(posts))
+	assert.Equal(t, store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, posts[0].Locator, "made by the first comment")
+
+	update := store.Post{Locator: store.Locator{URL: "https://radio-t.com"}, Title: "title",
+		Aliases: []string{"http://radio-t.com", "http://radio-t.com"}}
+	posts, err = m.Post(engine.PostRequest{Locator: store.Locator{SiteID: "radio-t"}, Update: &update})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://radio-t.com"}, posts[0].Aliases)
+
+	res, err := m.Find(engine.FindRequest{Locator: store.Locator{SiteID: "radio-t", URL: "http://radio-t.com"}, Sort: "time"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res), "comments by alias")
+	_, err = m.Create(store.Comment{ID: "id-3", Locator: store.Locator{URL: "http://radio-t.com", SiteID: "radio-t"}})
+	require.NoError(t, err)
+	count, err := m.Count(engine.FindRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	_, err = m.Flag(engine.FlagRequest{Flag: engine.ReadOnly, Locator: store.Locator{SiteID: "radio-t", URL: "http://radio-t.com"},
+		Update: engine.FlagTrue})
+	require.NoError(t, err)
+	posts, err = m.Post(engine.PostRequest{Locator: store.Locator{SiteID: "radio-t", URL: "http://radio-t.com"}})
+	require.NoError(t, err)
+	assert.True(t, posts[0].ReadOnly)
+	assert.Equal(t, "title", posts[0].Title)
+
+	_, err = m.Post(engine.PostRequest{Locator: store.Locator{SiteID: "radio-t"},
+		Update: &store.Post{Locator: store.Locator{URL: "https://radio-t.com/2"}, Aliases: []string{"http://radio-t.com"}}})
+	assert.EqualError(t, err, "alias http://radio-t.com already used by post https://radio-t.com")
+	_, err = m.Post(engine.PostRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/2"}})
+	assert.EqualError(t, err, "no post https://radio-t.com/2")
+
+	posts, err = m.Post(engine.PostRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(posts))
+}
+
+func TestMemData_Move(t *testing.T) {
+	m := prepMem(t) // adds two comments
+
+	src, dst := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/2"}
+	_, err := m.Create(store.Comment{ID: "id-3", ParentID: "id-1", Locator: src, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+
+	moved, err := m.Move(engine.MoveRequest{Locator: src, CommentIDs: []string{"id-3"}, To: dst})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(moved))
+	assert.Equal(t, dst, moved[0].Locator)
+	assert.Equal(t, "", moved[0].ParentID, "parent left in source post")
+	count, err := m.Count(engine.FindRequest{Locator: dst})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	_, err = m.Move(engine.MoveRequest{Locator: src, CommentIDs: []string{"id-3"}, To: dst})
+	assert.Error(t, err)
+
+	moved, err = m.Move(engine.MoveRequest{Locator: src, To: dst})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(moved))
+	count, err = m.Count(engine.FindRequest{Locator: dst})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	_, err = m.Post(engine.PostRequest{Locator: src})
+	assert.Error(t, err, "source post removed")
+}
+
 func TestMemData_FlagVerified(t *testing.T) {
 
 	b := prepMem(t)
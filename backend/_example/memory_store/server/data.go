@@ -105,6 +105,36 @@ func (s *RPC) userDetailHndl(id uint64, params json.RawMessage) (rr jrpc.Respons
 	return jrpc.EncodeResponse(id, value, err)
 }
 
+// postHndl sets or gets post record by url or alias, or lists all post records of the site
+func (s *RPC) postHndl(id uint64, params json.RawMessage) (rr jrpc.Response) {
+	req := engine.PostRequest{}
+	if err := json.Unmarshal(params, &req); err != nil {
+		return jrpc.Response{Error: err.Error()}
+	}
+	posts, err := s.eng.Post(req)
+	return jrpc.EncodeResponse(id, posts, err)
+}
+
+// moveHndl moves comments, or all comments of the post, to another post
+func (s *RPC) moveHndl(id uint64, params json.RawMessage) (rr jrpc.Response) {
+	req := engine.MoveRequest{}
+	if err := json.Unmarshal(params, &req); err != nil {
+		return jrpc.Response{Error: err.Error()}
+	}
+	comments, err := s.eng.Move(req)
+	return jrpc.EncodeResponse(id, comments, err)
+}
+
+// imagesHndl gets ids of images referenced by comments of the site
+func (s *RPC) imagesHndl(id uint64, params json.RawMessage) (rr jrpc.Response) {
+	req := engine.FindRequest{}
+	if err := json.Unmarshal(params, &req); err != nil {
+		return jrpc.Response{Error: err.Error()}
+	}
+	ids, err := s.eng.Images(req)
+	return jrpc.EncodeResponse(id, ids, err)
+}
+
 // deleteHndl delete post(s), user, comment, user details, or everything
 func (s *RPC) deleteHndl(id uint64, params json.RawMessage) (rr jrpc.Response) {
 	req := engine.DeleteRequest{}
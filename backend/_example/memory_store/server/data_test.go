@@ -149,6 +149,55 @@ func TestRPC_infoHndl(t *testing.T) {
 	assert.Equal(t, store.PostInfo{URL: "http://example.com/post1", Count: 1}, i)
 }
 
+func TestRPC_postHndl(t *testing.T) {
+	_, port, teardown := prepTestStore(t)
+	defer teardown()
+	api := fmt.Sprintf("http://localhost:%d/test", port)
+
+	re := engine.RPC{Client: jrpc.Client{API: api, Client: http.Client{Timeout: 1 * time.Second}}}
+
+	c := store.Comment{ID: "123456", Locator: store.Locator{SiteID: "test-site", URL: "http://example.com/post1"},
+		Text: "text 123", User: store.User{ID: "u1", Name: "user1"}, PostTitle: "post title"}
+	_, err := re.Create(c)
+	require.NoError(t, err)
+
+	update := store.Post{Aliases: []string{"https://example.com/post1"}}
+	_, err = re.Post(engine.PostRequest{Locator: store.Locator{SiteID: "test-site", URL: "http://example.com/post1"}, Update: &update})
+	require.NoError(t, err)
+
+	posts, err := re.Post(engine.PostRequest{Locator: store.Locator{SiteID: "test-site", URL: "https://example.com/post1"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(posts))
+	assert.Equal(t, "http://example.com/post1", posts[0].Locator.URL)
+	assert.Equal(t, []string{"https://example.com/post1"}, posts[0].Aliases)
+
+	_, err = re.Post(engine.PostRequest{Locator: store.Locator{SiteID: "test-site", URL: "http://example.com/post2"}})
+	assert.EqualError(t, err, "no post http://example.com/post2")
+}
+
+func TestRPC_moveHndl(t *testing.T) {
+	_, port, teardown := prepTestStore(t)
+	defer teardown()
+	api := fmt.Sprintf("http://localhost:%d/test", port)
+
+	re := engine.RPC{Client: jrpc.Client{API: api, Client: http.Client{Timeout: 1 * time.Second}}}
+
+	c := store.Comment{ID: "123456", Locator: store.Locator{SiteID: "test-site", URL: "http://example.com/post1"},
+		Text: "text 123", User: store.User{ID: "u1", Name: "user1"}}
+	_, err := re.Create(c)
+	require.NoError(t, err)
+
+	to := store.Locator{SiteID: "test-site", URL: "http://example.com/post2"}
+	moved, err := re.Move(engine.MoveRequest{Locator: c.Locator, To: to})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(moved))
+	assert.Equal(t, to, moved[0].Locator)
+
+	count, err := re.Count(engine.FindRequest{Locator: to})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
 func TestRPC_flagHndl(t *testing.T) {
 	_, port, teardown := prepTestStore(t)
 	defer teardown()
@@ -303,3 +352,25 @@ func TestRPC_closeHndl(t *testing.T) {
 	err := re.Close()
 	assert.NoError(t, err)
 }
+
+func TestRPC_imagesHndl(t *testing.T) {
+	_, port, teardown := prepTestStore(t)
+	defer teardown()
+	api := fmt.Sprintf("http://localhost:%d/test", port)
+
+	re := engine.RPC{Client: jrpc.Client{API: api, Client: http.Client{Timeout: 1 * time.Second}}}
+
+	c := store.Comment{ID: "123456", Locator: store.Locator{SiteID: "test-site", URL: "http://example.com/post1"},
+		Text: "text 123", User: store.User{ID: "u1", Name: "user1"}, Images: []string{"u1/pic1.png", "u1/pic2.png"}}
+	_, err := re.Create(c)
+	require.NoError(t, err)
+
+	ids, err := re.Images(engine.FindRequest{Locator: store.Locator{SiteID: "test-site"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u1/pic1.png", "u1/pic2.png"}, ids)
+
+	comments, err := re.Find(engine.FindRequest{Locator: store.Locator{SiteID: "test-site"}, ImageID: "u1/pic2.png"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(comments))
+	assert.Equal(t, "123456", comments[0].ID)
+}
@@ -42,6 +42,9 @@ func (s *RPC) addHandlers() {
 		"flag":        s.flagHndl,
 		"list_flags":  s.listFlagsHndl,
 		"user_detail": s.userDetailHndl,
+		"post":        s.postHndl,
+		"move":        s.moveHndl,
+		"images":      s.imagesHndl,
 		"delete":      s.deleteHndl,
 		"close":       s.closeHndl,
 	})
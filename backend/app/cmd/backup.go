@@ -6,10 +6,13 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
 	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/migrator"
 )
 
 // BackupCommand set of flags and command for export
@@ -18,21 +21,33 @@ type BackupCommand struct {
 	ExportPath  string        `short:"p" long:"path" env:"BACKUP_PATH" default:"./var/backup" description:"export path"`
 	ExportFile  string        `short:"f" long:"file" default:"userbackup-{{.SITE}}-{{.TS}}.gz" description:"file name"`
 	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
+	Format      string        `long:"format" default:"native" choice:"native" choice:"disqus" choice:"wordpress" choice:"archive" description:"export format"` //nolint
 	Timeout     time.Duration `long:"timeout" default:"15m" description:"export (backup) timeout"`
 	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	Encrypt     EncryptGroup  `group:"backup-encrypt" namespace:"backup-encrypt" env-namespace:"BACKUP_ENCRYPT"`
 	CommonOpts
 }
 
 // Execute runs export with ExportCommand parameters, entry point for "export" command
 func (ec *BackupCommand) Execute(args []string) error {
-	log.Printf("[INFO] export to %s, site %s", ec.ExportPath, ec.Site)
-	resetEnv("SECRET", "ADMIN_PASSWD")
+	log.Printf("[INFO] export to %s, site %s, format %s", ec.ExportPath, ec.Site, ec.Format)
+	resetEnv("SECRET", "ADMIN_PASSWD", "BACKUP_ENCRYPT_PASSPHRASE")
 
 	fp := fileParser{site: ec.Site, path: ec.ExportPath, file: ec.ExportFile}
 	fname, err := fp.parse(time.Now())
 	if err != nil {
 		return err
 	}
+	if ec.Format == "archive" && !strings.HasSuffix(fname, ".tar.gz") {
+		fname = strings.TrimSuffix(fname, ".gz") + ".tar.gz"
+	}
+	crypter, err := ec.Encrypt.crypter()
+	if err != nil {
+		return err
+	}
+	if crypter.Enabled() && !strings.HasSuffix(fname, migrator.EncryptedExt) {
+		fname += migrator.EncryptedExt
+	}
 
 	log.Printf("[DEBUG] export file %s", fname)
 
@@ -40,7 +55,11 @@ func (ec *BackupCommand) Execute(args []string) error {
 	client := http.Client{}
 	ctx, cancel := context.WithTimeout(context.Background(), ec.Timeout)
 	defer cancel()
-	exportURL := fmt.Sprintf("%s/api/v1/admin/export?mode=file&site=%s", ec.RemarkURL, ec.Site)
+	mode := "file"
+	if ec.Format != "native" {
+		mode = ec.Format // disqus and wordpress modes make xml file for those systems, archive mode makes tar.gz
+	}
+	exportURL := fmt.Sprintf("%s/api/v1/admin/export?mode=%s&site=%s", ec.RemarkURL, mode, ec.Site)
 	req, err := http.NewRequest(http.MethodGet, exportURL, nil)
 	if err != nil {
 		return errors.Wrapf(err, "can't make export request for %s", exportURL)
@@ -72,9 +91,20 @@ func (ec *BackupCommand) Execute(args []string) error {
 		}
 	}()
 
-	if _, err = io.Copy(fh, resp.Body); err != nil {
+	var w io.WriteCloser = fh
+	if crypter.Enabled() {
+		if w, err = crypter.Encrypt(fh); err != nil {
+			return errors.Wrapf(err, "can't encrypt backup file %s", fname)
+		}
+	}
+	if _, err = io.Copy(w, resp.Body); err != nil {
 		return errors.Wrapf(err, "failed to write backup file %s", fname)
 	}
+	if crypter.Enabled() {
+		if err = w.Close(); err != nil {
+			return errors.Wrapf(err, "can't complete encryption of %s", fname)
+		}
+	}
 
 	log.Printf("[INFO] export completed, file %s", fname)
 	return nil
@@ -11,6 +11,8 @@ import (
 	flags "github.com/jessevdk/go-flags"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/migrator"
 )
 
 func TestBackup_Execute(t *testing.T) {
@@ -35,6 +37,80 @@ func TestBackup_Execute(t *testing.T) {
 	assert.Equal(t, "blah\nblah2\n12345678\n", string(data))
 }
 
+func TestBackup_ExecuteFormat(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/api/v1/admin/export")
+		assert.Equal(t, "disqus", r.URL.Query().Get("mode"))
+		fmt.Fprint(w, "<disqus></disqus>")
+	}))
+	defer ts.Close()
+
+	cmd := BackupCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--path=/tmp", "--file={{.SITE}}-test.xml.gz", "--format=disqus",
+		"--admin-passwd=secret"})
+	require.NoError(t, err)
+	err = cmd.Execute(nil)
+	assert.NoError(t, err)
+	defer os.Remove("/tmp/remark-test.xml.gz")
+
+	data, err := ioutil.ReadFile("/tmp/remark-test.xml.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "<disqus></disqus>", string(data))
+
+	_, err = p.ParseArgs([]string{"--site=remark", "--format=bad", "--admin-passwd=secret"})
+	assert.Error(t, err, "unknown format rejected")
+}
+
+func TestBackup_ExecuteArchive(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/api/v1/admin/export")
+		assert.Equal(t, "archive", r.URL.Query().Get("mode"))
+		fmt.Fprint(w, "archive data")
+	}))
+	defer ts.Close()
+
+	cmd := BackupCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--path=/tmp", "--file={{.SITE}}-test-archive.gz", "--format=archive",
+		"--admin-passwd=secret"})
+	require.NoError(t, err)
+	err = cmd.Execute(nil)
+	assert.NoError(t, err)
+	defer os.Remove("/tmp/remark-test-archive.tar.gz")
+
+	data, err := ioutil.ReadFile("/tmp/remark-test-archive.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "archive data", string(data))
+}
+
+func TestBackup_ExecuteEncrypted(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "blah\nblah2\n12345678\n")
+	}))
+	defer ts.Close()
+
+	cmd := BackupCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--path=/tmp", "--file={{.SITE}}-test-enc.gz", "--admin-passwd=secret",
+		"--backup-encrypt.passphrase=pass"})
+	require.NoError(t, err)
+	require.NoError(t, cmd.Execute(nil))
+	defer os.Remove("/tmp/remark-test-enc.gz.enc")
+
+	fh, err := os.Open("/tmp/remark-test-enc.gz.enc")
+	require.NoError(t, err)
+	defer fh.Close()
+	r, err := (&migrator.Crypter{Passphrase: "pass"}).Decrypt(fh)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "blah\nblah2\n12345678\n", string(data))
+}
+
 func TestBackup_ExecuteFailedStatus(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, r.URL.Path, "/api/v1/admin/export")
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -23,7 +24,7 @@ type CleanupCommand struct {
 	BadWords    []string `short:"w" long:"bword" description:"bad word(s)"`
 	BadUsers    []string `short:"u" long:"buser" description:"bad user(s)"`
 	AdminPasswd string   `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
-	SetTitle    bool     `long:"title" description:"title mode, will not remove comments, but reset titles to page's title'"`
+	SetTitle    bool     `long:"title" description:"title mode, will not remove comments, but reset titles of posts to page's title'"`
 	CommonOpts
 }
 
@@ -43,6 +44,12 @@ func (cc *CleanupCommand) Execute(args []string) error {
 	}
 	log.Printf("[DEBUG] got %d posts", len(posts))
 
+	if cc.SetTitle {
+		cc.procTitles(posts)
+		log.Printf("[INFO] completed, posts=%d", len(posts))
+		return nil
+	}
+
 	totalComments, spamComments := 0, 0
 	for _, post := range posts {
 		comments, e := cc.listComments(post.URL)
@@ -50,21 +57,10 @@ func (cc *CleanupCommand) Execute(args []string) error {
 			continue
 		}
 		totalComments += len(comments)
-
-		if cc.SetTitle {
-			cc.procTitles(comments)
-		} else {
-			spamComments += cc.procSpam(comments)
-
-		}
+		spamComments += cc.procSpam(comments)
 	}
 
-	msg := fmt.Sprintf("comments=%d, spam=%d", totalComments, spamComments)
-	if cc.SetTitle {
-		msg = fmt.Sprintf("comments=%d", totalComments)
-	}
-
-	log.Printf("[INFO] completed, %s", msg)
+	log.Printf("[INFO] completed, comments=%d, spam=%d", totalComments, spamComments)
 	return err
 }
 
@@ -86,11 +82,11 @@ func (cc *CleanupCommand) procSpam(comments []store.Comment) int {
 	return spamComments
 }
 
-func (cc *CleanupCommand) procTitles(comments []store.Comment) {
-	for _, comment := range comments {
+func (cc *CleanupCommand) procTitles(posts []store.PostInfo) {
+	for _, post := range posts {
 		if !cc.Dry {
-			if err := cc.setTitle(comment); err != nil {
-				log.Printf("[WARN] can't set title for comment, %v", err)
+			if err := cc.setTitle(post.URL); err != nil {
+				log.Printf("[WARN] can't set title for post, %v", err)
 			}
 		}
 	}
@@ -211,20 +207,20 @@ func (cc *CleanupCommand) deleteComment(c store.Comment) error {
 	return nil
 }
 
-// setTitle with PUT /admin/title/{id}?site=siteID&url=post-url
-func (cc *CleanupCommand) setTitle(c store.Comment) error {
+// setTitle with PUT /admin/title?site=siteID&url=post-url
+func (cc *CleanupCommand) setTitle(postURL string) error {
 
-	titleURL := fmt.Sprintf("%s/api/v1/admin/title/%s?site=%s&url=%s&format=plain", cc.RemarkURL, c.ID, cc.Site, c.Locator.URL)
+	titleURL := fmt.Sprintf("%s/api/v1/admin/title?site=%s&url=%s", cc.RemarkURL, cc.Site, url.QueryEscape(postURL))
 	req, err := http.NewRequest("PUT", titleURL, nil)
 	if err != nil {
-		return errors.Wrapf(err, "failed to make title request for comment %s, %s", c.ID, c.Locator.URL)
+		return errors.Wrapf(err, "failed to make title request for post %s", postURL)
 	}
 	req.SetBasicAuth("admin", cc.AdminPasswd)
 
 	client := http.Client{}
 	r, err := client.Do(req)
 	if err != nil {
-		return errors.Wrapf(err, "title request failed for comment %s, %s", c.ID, c.Locator.URL)
+		return errors.Wrapf(err, "title request failed for post %s", postURL)
 	}
 	defer func() { _ = r.Body.Close() }()
 	if r.StatusCode != http.StatusOK {
@@ -126,9 +126,9 @@ func TestCleanup_ExecuteSpam(t *testing.T) {
 }
 
 func TestCleanup_ExecuteTitle(t *testing.T) {
-	titledComments := cleanedComments{}
+	titledPosts := cleanedComments{}
 	r := chi.NewRouter()
-	cleanupRoutes(t, r, &titledComments)
+	cleanupRoutes(t, r, &titledPosts)
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
@@ -139,8 +139,8 @@ func TestCleanup_ExecuteTitle(t *testing.T) {
 	require.NoError(t, err)
 	err = cmd.Execute(nil)
 	assert.NoError(t, err)
-	t.Logf("set titles for %+v", titledComments.ids)
-	assert.Equal(t, []string{"/api/v1/admin/title/1", "/api/v1/admin/title/2", "/api/v1/admin/title/3", "/api/v1/admin/title/11"}, titledComments.ids)
+	t.Logf("set titles for %+v", titledPosts.ids)
+	assert.Equal(t, []string{"http://test.com/post1", "http://test.com/post2"}, titledPosts.ids)
 }
 
 func cleanupRoutes(t *testing.T, r *chi.Mux, c *cleanedComments) {
@@ -203,11 +203,12 @@ func cleanupRoutes(t *testing.T, r *chi.Mux, c *cleanedComments) {
 		c.lock.Unlock()
 	}))
 
-	r.HandleFunc("/api/v1/admin/title/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("/api/v1/admin/title", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		require.Equal(t, "PUT", r.Method)
-		t.Log("title for ", r.URL.Path)
+		require.Equal(t, "remark", r.URL.Query().Get("site"))
+		t.Log("title for ", r.URL.Query().Get("url"))
 		c.lock.Lock()
-		c.ids = append(c.ids, r.URL.Path)
+		c.ids = append(c.ids, r.URL.Query().Get("url"))
 		c.lock.Unlock()
 	}))
 
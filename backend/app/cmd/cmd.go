@@ -4,6 +4,8 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -14,6 +16,9 @@ import (
 
 	log "github.com/go-pkgz/lgr"
 	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/jobs"
+	"github.com/umputun/remark/backend/app/migrator"
 )
 
 // CommonOptionsCommander extends flags.Commander with SetCommon
@@ -101,6 +106,34 @@ func resetEnv(envs ...string) {
 	}
 }
 
+// EncryptGroup defines options for encryption of backup files
+type EncryptGroup struct {
+	Passphrase string   `long:"passphrase" env:"PASSPHRASE" description:"passphrase to encrypt and decrypt backups"`
+	Recipients []string `long:"recipient" env:"RECIPIENT" env-delim:"," description:"public key to encrypt backups for"`
+	Identities []string `long:"identity" env:"IDENTITY" env-delim:"," description:"file with private key to decrypt backups"`
+}
+
+// crypter makes Crypter with private keys loaded from identity files. Lines of the file started with # ignored.
+// Passphrase can't be combined with recipients, as in age
+func (g EncryptGroup) crypter() (*migrator.Crypter, error) {
+	if g.Passphrase != "" && len(g.Recipients) > 0 {
+		return nil, errors.New("backup encryption passphrase can't be combined with recipients")
+	}
+	res := &migrator.Crypter{Passphrase: g.Passphrase, Recipients: g.Recipients}
+	for _, fname := range g.Identities {
+		data, err := ioutil.ReadFile(fname) // nolint
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read identity file %s", fname)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				res.Identities = append(res.Identities, line)
+			}
+		}
+	}
+	return res, nil
+}
+
 // responseError returns error with status and response body
 func responseError(resp *http.Response) error {
 	body, e := ioutil.ReadAll(resp.Body)
@@ -110,6 +143,60 @@ func responseError(resp *http.Response) error {
 	return errors.Errorf("error response %q, %s", resp.Status, body)
 }
 
+// jobPollInterval defines how often waitJob checks status of the job
+var jobPollInterval = time.Second
+
+// waitJob polls status of the job started by admin request till the job finished, logging its progress
+// and log lines. Response of the request passed as body, responses without job id (made by older servers)
+// considered completed. Returns error if job failed or canceled
+func waitJob(remarkURL, siteID string, body []byte, passwd string, timeout time.Duration) error {
+	started := struct {
+		Job string `json:"job"`
+	}{}
+	if err := json.Unmarshal(body, &started); err != nil || started.Job == "" {
+		return nil
+	}
+
+	jobURL := fmt.Sprintf("%s/api/v1/admin/jobs/%s?site=%s", remarkURL, started.Job, siteID)
+	deadline := time.Now().Add(timeout)
+	logged, percent := 0, -1
+	for {
+		data, err := adminRequest(http.MethodGet, jobURL, nil, passwd, timeout)
+		if err != nil {
+			return errors.Wrapf(err, "can't get status of job %s", started.Job)
+		}
+		job := jobs.Job{}
+		if err = json.Unmarshal(data, &job); err != nil {
+			return errors.Wrapf(err, "can't decode status of job %s", started.Job)
+		}
+
+		if logged > len(job.Logs) { // old lines dropped by the server
+			logged = 0
+		}
+		for _, line := range job.Logs[logged:] {
+			log.Printf("[INFO] %s", line)
+		}
+		logged = len(job.Logs)
+		if job.Running() && job.Total > 0 {
+			if p := int(job.Done * 100 / job.Total); p != percent {
+				log.Printf("[INFO] %s job %s, %d%% done", job.Kind, job.ID, p)
+				percent = p
+			}
+		}
+
+		switch job.Status {
+		case jobs.StatusCompleted:
+			return nil
+		case jobs.StatusFailed, jobs.StatusCanceled:
+			return errors.Errorf("%s job %s %s, %s", job.Kind, job.ID, job.Status, job.Error)
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("timeout waiting for %s job %s", job.Kind, job.ID)
+		}
+		time.Sleep(jobPollInterval)
+	}
+}
+
 // mkdir -p for all dirs
 func makeDirs(dirs ...string) error {
 	for _, dir := range dirs {
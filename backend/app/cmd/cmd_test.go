@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -37,3 +40,40 @@ func TestExport_ParseFileName(t *testing.T) {
 		assert.Equal(t, tt.res, r, "check #%d", i)
 	}
 }
+
+func TestWaitJob(t *testing.T) {
+	jobPollInterval = 10 * time.Millisecond
+	polls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/admin/jobs/job1", r.URL.Path)
+		assert.Equal(t, "GET", r.Method)
+		user, passwd, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin:secret", user+":"+passwd)
+		polls++
+		switch polls {
+		case 1:
+			fmt.Fprint(w, `{"id":"job1","kind":"remap","status":"running","logs":["line 1"]}`)
+		case 2:
+			fmt.Fprint(w, `{"id":"job1","kind":"remap","status":"running","logs":["line 1","line 2"]}`)
+		default:
+			fmt.Fprint(w, `{"id":"job1","kind":"remap","status":"completed","logs":["line 1","line 2","line 3"]}`)
+		}
+	}))
+	defer ts.Close()
+
+	err := waitJob(ts.URL, "remark", []byte(`{"status":"convert request accepted","job":"job1"}`), "secret", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, polls)
+
+	polls = 0
+	err = waitJob(ts.URL, "remark", []byte(`{"status":"convert request accepted"}`), "secret", time.Second)
+	assert.NoError(t, err, "no job id in response of older server")
+	assert.Equal(t, 0, polls)
+
+	err = waitJob(ts.URL, "remark", []byte(`{"job":"job1"}`), "secret", 10*time.Millisecond)
+	assert.EqualError(t, err, "timeout waiting for remap job job1")
+
+	err = waitJob("http://127.0.0.1:1", "remark", []byte(`{"job":"job1"}`), "secret", time.Second)
+	assert.Error(t, err)
+}
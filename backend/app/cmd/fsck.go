@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark/backend/app/store/engine"
+)
+
+// FsckCommand set of flags and command for integrity check of bolt store. Works with bolt files directly,
+// server should be stopped as bolt file can't be opened by two processes
+type FsckCommand struct {
+	Sites   []string      `long:"site" env:"SITE" default:"remark" description:"site names" env-delim:","`
+	Path    string        `long:"path" env:"STORE_BOLT_PATH" default:"./var" description:"parent dir for bolt files"`
+	Repair  bool          `long:"repair" description:"rebuild last, users, info and aliases buckets if problems found"`
+	Compact bool          `long:"compact" description:"compact bolt files into fresh ones, originals kept as {file}.bak"`
+	Timeout time.Duration `long:"timeout" default:"5s" description:"wait for bolt file lock"`
+	CommonOpts
+}
+
+const fsckCompactTxSize = 64 * 1024 * 1024 // compaction committed by 64M of keys and values
+
+// Execute runs check, repair and compaction of bolt files with FsckCommand parameters, entry point for "fsck" command.
+// Fails if problems found and not repaired
+func (fc *FsckCommand) Execute(args []string) error {
+	log.Printf("[INFO] check bolt store %s, sites %v, repair %v, compact %v", fc.Path, fc.Sites, fc.Repair, fc.Compact)
+	resetEnv("SECRET")
+
+	problems := 0
+	for _, site := range fc.Sites {
+		file := fmt.Sprintf("%s/%s.db", fc.Path, site)
+		report, err := fc.check(site, file)
+		if err != nil {
+			return err
+		}
+		for _, p := range report.Problems {
+			log.Printf("[WARN] %s", p)
+		}
+		log.Printf("[INFO] checked %s, %s", file, report)
+		if !report.Repaired {
+			problems += len(report.Problems)
+		}
+		if fc.Compact {
+			if err = fc.compact(file); err != nil {
+				return err
+			}
+		}
+	}
+
+	if problems > 0 {
+		return errors.Errorf("found %d problems, run with --repair to fix", problems)
+	}
+	log.Printf("[INFO] completed")
+	return nil
+}
+
+// check verifies and repairs, if allowed, bolt file of the site
+func (fc *FsckCommand) check(site, file string) (engine.CheckReport, error) {
+	if _, err := os.Stat(file); err != nil {
+		return engine.CheckReport{}, errors.Wrapf(err, "no bolt file for site %s", site)
+	}
+	b, err := engine.NewBoltDB(bolt.Options{Timeout: fc.Timeout}, engine.BoltSite{SiteID: site, FileName: file})
+	if err != nil {
+		return engine.CheckReport{}, errors.Wrapf(err, "can't open %s, stop the server first", file)
+	}
+	defer func() {
+		if e := b.Close(); e != nil {
+			log.Printf("[WARN] can't close %s, %v", file, e)
+		}
+	}()
+	return b.Check(site, fc.Repair)
+}
+
+// compact copies bolt file to fresh file and replaces original with it, original kept with .bak suffix
+func (fc *FsckCommand) compact(file string) error {
+	dst := file + ".compact"
+	if err := engine.CompactBolt(file, dst, fsckCompactTxSize, fc.Timeout); err != nil {
+		return errors.Wrapf(err, "can't compact %s", file)
+	}
+	srcInfo, err := os.Stat(file)
+	if err != nil {
+		return errors.Wrapf(err, "can't get size of %s", file)
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return errors.Wrapf(err, "can't get size of %s", dst)
+	}
+	if err = os.Rename(file, file+".bak"); err != nil {
+		return errors.Wrapf(err, "can't keep original %s", file)
+	}
+	if err = os.Rename(dst, file); err != nil {
+		return errors.Wrapf(err, "can't replace %s with compacted file", file)
+	}
+	log.Printf("[INFO] compacted %s, %d -> %d bytes, original kept as %s.bak", file, srcInfo.Size(), dstInfo.Size(), file)
+	return nil
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/engine"
+)
+
+func TestFsck_Execute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_fsck")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{SiteID: "remark", FileName: dir + "/remark.db"})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "c1", Text: "text", Timestamp: time.Now(),
+		Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+
+	run := func(opts ...string) error {
+		cmd := FsckCommand{}
+		cmd.SetCommon(CommonOpts{RemarkURL: "http://localhost", SharedSecret: "123456"})
+		p := flags.NewParser(&cmd, flags.Default)
+		_, e := p.ParseArgs(append([]string{"--site=remark", "--path=" + dir, "--timeout=100ms"}, opts...))
+		require.NoError(t, e)
+		return cmd.Execute(nil)
+	}
+
+	assert.NoError(t, run())
+
+	db, err := bolt.Open(dir+"/remark.db", 0600, nil)
+	require.NoError(t, err)
+	err = run()
+	require.Error(t, err, "file locked")
+	assert.Contains(t, err.Error(), "stop the server first")
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("info")).Put([]byte("https://example.com/post"), []byte(`{"url":"https://example.com/post","count":5}`))
+	}))
+	require.NoError(t, db.Close())
+
+	assert.EqualError(t, run(), "found 2 problems, run with --repair to fix", "wrong count and times")
+	assert.NoError(t, run("--repair", "--compact"))
+	_, err = os.Stat(dir + "/remark.db.bak")
+	assert.NoError(t, err, "original kept")
+	_, err = os.Stat(dir + "/remark.db.compact")
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, run(), "repaired")
+
+	b, err = engine.NewBoltDB(bolt.Options{}, engine.BoltSite{SiteID: "remark", FileName: dir + "/remark.db"})
+	require.NoError(t, err)
+	count, err := b.Count(engine.FindRequest{Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	require.NoError(t, b.Close())
+
+	err = run("--site=bad")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no bolt file for site bad")
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/store/image"
+)
+
+// ImagesCommand set of flags and command for report of images not referenced by any comment and their removal
+type ImagesCommand struct {
+	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
+	GC          bool          `long:"gc" description:"remove reclaimable images, report only by default"`
+	Verbose     bool          `short:"v" long:"verbose" description:"list ids of reclaimable images"`
+	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	Timeout     time.Duration `long:"timeout" default:"15m" description:"images gc timeout"`
+	CommonOpts
+}
+
+// Execute runs images report or gc with ImagesCommand parameters, entry point for "images" command
+func (ic *ImagesCommand) Execute(args []string) error {
+	log.Printf("[INFO] images report, site %s, gc %v", ic.Site, ic.GC)
+	resetEnv("SECRET", "ADMIN_PASSWD")
+
+	method := http.MethodGet
+	if ic.GC {
+		method = http.MethodPost
+	}
+
+	client := http.Client{}
+	ctx, cancel := context.WithTimeout(context.Background(), ic.Timeout)
+	defer cancel()
+	gcURL := fmt.Sprintf("%s/api/v1/admin/images/gc?site=%s", ic.RemarkURL, ic.Site)
+	req, err := http.NewRequest(method, gcURL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "can't make images request for %s", gcURL)
+	}
+	req.SetBasicAuth("admin", ic.AdminPasswd)
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "request failed for %s", gcURL)
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] failed to close response, %s", err)
+		}
+	}()
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+
+	report := image.GCReport{}
+	if err = json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return errors.Wrap(err, "can't decode images report")
+	}
+	if ic.Verbose {
+		for _, id := range report.IDs {
+			log.Printf("[INFO] reclaimable image %s", id)
+		}
+	}
+	log.Printf("[INFO] completed, %s", report)
+	return nil
+}
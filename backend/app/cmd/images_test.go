@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImages_Execute(t *testing.T) {
+	methods := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/admin/images/gc", r.URL.Path)
+		assert.Equal(t, "remark", r.URL.Query().Get("site"))
+		user, passwd, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin", user)
+		assert.Equal(t, "secret", passwd)
+		methods = append(methods, r.Method)
+		_, _ = w.Write([]byte(`{"total":2,"total_size":20,"orphaned":1,"reclaimable":1,"reclaimable_size":10,"ids":["user1/pic"]}`))
+	}))
+	defer ts.Close()
+
+	for _, args := range [][]string{{"--site=remark", "--admin-passwd=secret", "-v"},
+		{"--site=remark", "--admin-passwd=secret", "--gc"}} {
+		cmd := ImagesCommand{}
+		cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+		p := flags.NewParser(&cmd, flags.Default)
+		_, err := p.ParseArgs(args)
+		require.NoError(t, err)
+		assert.NoError(t, cmd.Execute(nil))
+	}
+	assert.Equal(t, []string{"GET", "POST"}, methods)
+}
+
+func TestImages_ExecuteFailed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not supported", http.StatusNotImplemented)
+	}))
+	defer ts.Close()
+
+	cmd := ImagesCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--admin-passwd=secret"})
+	require.NoError(t, err)
+	err = cmd.Execute(nil)
+	assert.EqualError(t, err, "error response \"501 Not Implemented\", not supported\n")
+}
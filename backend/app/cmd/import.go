@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -18,11 +21,16 @@ import (
 // ImportCommand set of flags and command for import
 type ImportCommand struct {
 	InputFile   string        `short:"f" long:"file" description:"input file name" required:"true"`
-	Provider    string        `short:"p" long:"provider" default:"disqus" choice:"disqus" choice:"wordpress" description:"import format"` //nolint
+	Provider    string        `short:"p" long:"provider" default:"disqus" choice:"disqus" choice:"wordpress" choice:"commento" choice:"isso" choice:"archive" description:"import format"` //nolint
+	BaseURL     string        `long:"base-url" description:"site url, prepended to page paths for isso import"`
+	UserMapping string        `long:"user-mapping" description:"file mapping disqus users to remark42 user ids"`
 	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
 	Timeout     time.Duration `long:"timeout" default:"15m" description:"import timeout"`
 	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
 	CommonOpts
+
+	query url.Values // extra import params, set by restore
+	input io.Reader  // import data used instead of InputFile content, set by restore
 }
 
 // Execute runs import with ImportCommand parameters, entry point for "import" command
@@ -30,6 +38,14 @@ func (ic *ImportCommand) Execute(args []string) error {
 	log.Printf("[INFO] import %s (%s), site %s", ic.InputFile, ic.Provider, ic.Site)
 	resetEnv("SECRET", "ADMIN_PASSWD")
 
+	if ic.Provider == "isso" && ic.input == nil {
+		// changes not checkpointed yet sit in the write-ahead log next to the database and would be lost
+		if fi, e := os.Stat(ic.InputFile + "-wal"); e == nil && fi.Size() > 0 {
+			return errors.Errorf("isso database %s has not checkpointed changes in %s-wal, stop isso or run "+
+				"\"sqlite3 %s 'PRAGMA wal_checkpoint(TRUNCATE)'\" and import again", ic.InputFile, ic.InputFile, ic.InputFile)
+		}
+	}
+
 	reader, err := ic.reader(ic.InputFile)
 	if err != nil {
 		return errors.Wrapf(err, "can't open import file %s", ic.InputFile)
@@ -39,10 +55,29 @@ func (ic *ImportCommand) Execute(args []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), ic.Timeout)
 	defer cancel()
 	importURL := fmt.Sprintf("%s/api/v1/admin/import?site=%s&provider=%s", ic.RemarkURL, ic.Site, ic.Provider)
+	contentType := ""
+	if ic.UserMapping != "" { // mapping sent along with import file as multipart form
+		mappingFile, e := os.Open(ic.UserMapping)
+		if e != nil {
+			return errors.Wrapf(e, "can't open user mapping file %s", ic.UserMapping)
+		}
+		defer mappingFile.Close() // nolint
+		importURL = fmt.Sprintf("%s/api/v1/admin/import/form?site=%s&provider=%s", ic.RemarkURL, ic.Site, ic.Provider)
+		reader, contentType = ic.formReader(reader, mappingFile)
+	}
+	if ic.BaseURL != "" {
+		importURL += "&base=" + url.QueryEscape(ic.BaseURL)
+	}
+	if len(ic.query) > 0 {
+		importURL += "&" + ic.query.Encode()
+	}
 	req, err := http.NewRequest(http.MethodPost, importURL, reader)
 	if err != nil {
 		return errors.Wrapf(err, "can't make import request for %s", importURL)
 	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 	req.SetBasicAuth("admin", ic.AdminPasswd)
 
 	resp, err := client.Do(req.WithContext(ctx)) // closes request's reader
@@ -63,22 +98,53 @@ func (ic *ImportCommand) Execute(args []string) error {
 		return errors.Wrap(err, "can't get response from importer")
 	}
 
-	log.Printf("[INFO] completed, status=%d, %s", resp.StatusCode, string(body))
+	log.Printf("[INFO] started, status=%d, %s", resp.StatusCode, string(body))
+	if err = waitJob(ic.RemarkURL, ic.Site, body, ic.AdminPasswd, ic.Timeout); err != nil {
+		return err
+	}
+	log.Printf("[INFO] import completed")
 	return nil
 }
 
-// reader returns reader for file. For .gz file wraps with gunzip
+// reader returns reader for file, or for input if set. For .gz file wraps with gunzip, archive sent as is
 func (ic *ImportCommand) reader(inp string) (reader io.Reader, err error) {
-	inpFile, err := os.Open(inp) // nolint
-	if err != nil {
-		return nil, errors.Wrapf(err, "import failed, can't open %s", inp)
+	reader = ic.input
+	if reader == nil {
+		if reader, err = os.Open(inp); err != nil { // nolint
+			return nil, errors.Wrapf(err, "import failed, can't open %s", inp)
+		}
 	}
 
-	reader = inpFile
-	if strings.HasSuffix(ic.InputFile, ".gz") {
-		if reader, err = gzip.NewReader(inpFile); err != nil {
+	if strings.HasSuffix(ic.InputFile, ".gz") && ic.Provider != "archive" {
+		if reader, err = gzip.NewReader(reader); err != nil {
 			return nil, errors.Wrap(err, "can't make gz reader")
 		}
 	}
 	return reader, nil
 }
+
+// formReader streams multipart form with import file and user mapping file, returns reader and content type
+func (ic *ImportCommand) formReader(file, mapping io.Reader) (io.Reader, string) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		err := func() error {
+			fw, err := mw.CreateFormFile("file", filepath.Base(ic.InputFile))
+			if err != nil {
+				return err
+			}
+			if _, err = io.Copy(fw, file); err != nil {
+				return err
+			}
+			if fw, err = mw.CreateFormFile("mapping", filepath.Base(ic.UserMapping)); err != nil {
+				return err
+			}
+			if _, err = io.Copy(fw, mapping); err != nil {
+				return err
+			}
+			return mw.Close()
+		}()
+		_ = pw.CloseWithError(err)
+	}()
+	return pr, mw.FormDataContentType()
+}
@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -47,6 +49,89 @@ func TestImport_Execute(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestImport_ExecuteIsso(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/api/v1/admin/import")
+		assert.Equal(t, "isso", r.URL.Query().Get("provider"))
+		assert.Equal(t, "https://example.com/blog", r.URL.Query().Get("base"))
+		fmt.Fprintln(w, "some response")
+	}))
+	defer ts.Close()
+
+	cmd := ImportCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--file=testdata/import.txt", "--admin-passwd=secret",
+		"--provider=isso", "--base-url=https://example.com/blog"})
+	require.NoError(t, err)
+	err = cmd.Execute(nil)
+	assert.NoError(t, err)
+}
+
+func TestImport_ExecuteIssoWAL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Fail(t, "import request not expected")
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "isso-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbFile := filepath.Join(dir, "comments.db")
+	require.NoError(t, ioutil.WriteFile(dbFile, []byte("db"), 0600))
+	require.NoError(t, ioutil.WriteFile(dbFile+"-wal", []byte("wal"), 0600))
+
+	cmd := ImportCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--file=" + dbFile, "--admin-passwd=secret", "--provider=isso"})
+	require.NoError(t, err)
+	err = cmd.Execute(nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not checkpointed changes")
+}
+
+func TestImport_ExecuteUserMapping(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/api/v1/admin/import/form")
+		assert.Equal(t, "disqus", r.URL.Query().Get("provider"))
+		require.NoError(t, r.ParseMultipartForm(1024*1024))
+		file, _, err := r.FormFile("file")
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(file)
+		assert.NoError(t, err)
+		assert.Equal(t, "blah\nblah2\n12345678\n", string(body))
+		mapping, _, err := r.FormFile("mapping")
+		require.NoError(t, err)
+		body, err = ioutil.ReadAll(mapping)
+		assert.NoError(t, err)
+		assert.Equal(t, "user1 github_user1\n", string(body))
+		fmt.Fprintln(w, "some response")
+	}))
+	defer ts.Close()
+
+	mappingFile := "/tmp/remark-import-mapping.txt"
+	require.NoError(t, ioutil.WriteFile(mappingFile, []byte("user1 github_user1\n"), 0600))
+	defer os.Remove(mappingFile)
+
+	cmd := ImportCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--file=testdata/import.txt.gz", "--admin-passwd=secret",
+		"--user-mapping=" + mappingFile})
+	require.NoError(t, err)
+	err = cmd.Execute(nil)
+	assert.NoError(t, err)
+
+	cmd.UserMapping = "/tmp/no-such-mapping.txt"
+	err = cmd.Execute(nil)
+	assert.Error(t, err)
+}
+
 func TestImport_ExecuteFailed(t *testing.T) {
 
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -116,3 +201,33 @@ func TestImport_ExecuteTimeout(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "deadline exceeded")
 }
+
+func TestImport_ExecuteJob(t *testing.T) {
+	jobPollInterval = 10 * time.Millisecond
+	polls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/admin/import" {
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintln(w, `{"status":"import request accepted","job":"job1"}`)
+			return
+		}
+		assert.Equal(t, "/api/v1/admin/jobs/job1", r.URL.Path)
+		assert.Equal(t, "remark", r.URL.Query().Get("site"))
+		polls++
+		if polls < 3 {
+			fmt.Fprintf(w, `{"id":"job1","kind":"import","status":"running","done":%d,"total":100}`, polls*30)
+			return
+		}
+		fmt.Fprintln(w, `{"id":"job1","kind":"import","status":"failed","error":"import failed, bad record"}`)
+	}))
+	defer ts.Close()
+
+	cmd := ImportCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--file=testdata/import.txt", "--admin-passwd=secret"})
+	require.NoError(t, err)
+	err = cmd.Execute(nil)
+	assert.EqualError(t, err, "import job job1 failed, import failed, bad record")
+	assert.Equal(t, 3, polls)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/migrator"
+)
+
+// KeygenCommand set of flags and command for generation of key pair for backup encryption
+type KeygenCommand struct {
+	Output string `short:"o" long:"output" description:"file for private key, printed if not set"`
+	CommonOpts
+}
+
+// keyRotationGuide printed with the new key
+const keyRotationGuide = `Key rotation:
+  1. replace the old public key in BACKUP_ENCRYPT_RECIPIENT with the new one and restart the server,
+     new backups made for the new key.
+  2. add the new private key file to BACKUP_ENCRYPT_IDENTITY, keep the old one.
+  3. run "rekey", it decrypts existing backups with any identity and encrypts them for current recipients.
+  4. remove the old identity and destroy the old private key once no copies of backups made for it kept elsewhere.
+Passphrase changed the same way, with the previous one passed to "rekey" as --old-passphrase.`
+
+// Execute generates key pair, writes private key to the output file and prints public key with rotation guide
+func (kc *KeygenCommand) Execute(args []string) error {
+	resetEnv("SECRET")
+	key, pub, err := migrator.GenerateKey()
+	if err != nil {
+		return err
+	}
+	data := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), pub, key)
+
+	var w io.Writer = os.Stdout
+	if kc.Output != "" {
+		fh, e := os.OpenFile(kc.Output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if e != nil {
+			return errors.Wrapf(e, "can't create key file %s", kc.Output)
+		}
+		defer func() {
+			if e := fh.Close(); e != nil {
+				log.Printf("[WARN] failed to close %s, %v", kc.Output, e)
+			}
+		}()
+		w = fh
+	}
+	if _, err = io.WriteString(w, data); err != nil {
+		return errors.Wrap(err, "can't write private key")
+	}
+
+	if kc.Output != "" {
+		log.Printf("[INFO] private key saved to %s", kc.Output)
+	}
+	fmt.Printf("public key: %s\n\n%s\n", pub, keyRotationGuide)
+	return nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/migrator"
+)
+
+func TestKeygen_Execute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_keygen")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cmd := KeygenCommand{}
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--output=" + dir + "/backup.key"})
+	require.NoError(t, err)
+	require.NoError(t, cmd.Execute(nil))
+
+	fi, err := os.Stat(dir + "/backup.key")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	crypter, err := EncryptGroup{Identities: []string{dir + "/backup.key"}}.crypter()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(crypter.Identities))
+	pub, err := migrator.PublicKey(crypter.Identities[0])
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(dir + "/backup.key")
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(string(data), "# public key: "+pub+"\n"))
+
+	assert.Error(t, cmd.Execute(nil), "existing key file not overwritten")
+
+	_, err = EncryptGroup{Passphrase: "secret", Recipients: []string{pub}}.crypter()
+	assert.EqualError(t, err, "backup encryption passphrase can't be combined with recipients")
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// NormalizeCommand set of flags and command to change urls of existing comments
+// to normalized ones, with url normalization rules of the server
+type NormalizeCommand struct {
+	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
+	DryRun      bool          `long:"dry-run" description:"show changes normalization would make, without making them"`
+	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	Timeout     time.Duration `long:"timeout" default:"15m" description:"normalize timeout"`
+	CommonOpts
+}
+
+// Execute runs normalization with NormalizeCommand parameters, entry point for "normalize" command
+func (nc *NormalizeCommand) Execute(args []string) error {
+	log.Printf("[INFO] start urls normalization, site %s, dry run %v", nc.Site, nc.DryRun)
+	resetEnv("SECRET", "ADMIN_PASSWD")
+
+	normalizeURL := fmt.Sprintf("%s/api/v1/admin/normalize?site=%s", nc.RemarkURL, nc.Site)
+	if nc.DryRun {
+		normalizeURL += "&dry=1"
+	}
+	body, err := adminPost(normalizeURL, nil, nc.AdminPasswd, nc.Timeout)
+	if err != nil {
+		return err
+	}
+	if nc.DryRun {
+		return logRemapPlan(body)
+	}
+	log.Printf("[INFO] started, %s", string(body))
+	if err = waitJob(nc.RemarkURL, nc.Site, body, nc.AdminPasswd, nc.Timeout); err != nil {
+		return err
+	}
+	log.Printf("[INFO] normalization completed")
+	return nil
+}
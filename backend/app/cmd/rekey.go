@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/migrator"
+)
+
+// RekeyCommand set of flags and command for re-encryption of backup files with current passphrase and recipients
+type RekeyCommand struct {
+	BackupPath    string       `short:"p" long:"path" env:"BACKUP_PATH" default:"./var/backup" description:"backups location"`
+	BackupFiles   string       `short:"f" long:"file" default:"*.enc" description:"backup files pattern"`
+	OldPassphrase string       `long:"old-passphrase" env:"BACKUP_ENCRYPT_OLD_PASSPHRASE" description:"previous passphrase to decrypt backups, current one if not set"`
+	Encrypt       EncryptGroup `group:"backup-encrypt" namespace:"backup-encrypt" env-namespace:"BACKUP_ENCRYPT"`
+	CommonOpts
+}
+
+// Execute decrypts each encrypted backup with old or current passphrase or identities and encrypts it again
+// with current passphrase and recipients. File replaced only after successful re-encryption
+func (rc *RekeyCommand) Execute(args []string) error {
+	resetEnv("SECRET", "BACKUP_ENCRYPT_PASSPHRASE", "BACKUP_ENCRYPT_OLD_PASSPHRASE")
+	enc, err := rc.Encrypt.crypter()
+	if err != nil {
+		return err
+	}
+	if !enc.Enabled() {
+		return errors.New("no passphrase or recipients to encrypt for")
+	}
+	dec := &migrator.Crypter{Passphrase: enc.Passphrase, Identities: enc.Identities}
+	if rc.OldPassphrase != "" {
+		dec.Passphrase = rc.OldPassphrase
+	}
+	enc = &migrator.Crypter{Passphrase: enc.Passphrase, Recipients: enc.Recipients}
+
+	files, err := filepath.Glob(filepath.Join(rc.BackupPath, rc.BackupFiles))
+	if err != nil {
+		return errors.Wrapf(err, "can't list backups %s", rc.BackupFiles)
+	}
+	log.Printf("[INFO] rekey %d files in %s", len(files), rc.BackupPath)
+	failed := 0
+	for _, fname := range files {
+		if !migrator.IsEncryptedFile(fname) {
+			log.Printf("[WARN] %s not encrypted, skipped", fname)
+			continue
+		}
+		if err = rc.rekey(fname, dec, enc); err != nil {
+			log.Printf("[WARN] can't rekey %s, %v", fname, err)
+			failed++
+			continue
+		}
+		log.Printf("[INFO] re-encrypted %s", fname)
+	}
+	if failed > 0 {
+		return errors.Errorf("failed to rekey %d of %d files", failed, len(files))
+	}
+	return nil
+}
+
+// rekey re-encrypts file to temporary file in the same directory and renames it to the original name
+func (rc *RekeyCommand) rekey(fname string, dec, enc *migrator.Crypter) (err error) {
+	src, err := os.Open(fname) // nolint
+	if err != nil {
+		return err
+	}
+	defer src.Close() // nolint
+	r, err := dec.Decrypt(src)
+	if err != nil {
+		return err
+	}
+
+	dst, err := ioutil.TempFile(filepath.Dir(fname), filepath.Base(fname)+".rekey-")
+	if err != nil {
+		return errors.Wrap(err, "can't make temporary file")
+	}
+	defer func() {
+		if err != nil {
+			_ = dst.Close()
+			_ = os.Remove(dst.Name())
+		}
+	}()
+	w, err := enc.Encrypt(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	if fi, e := src.Stat(); e == nil {
+		_ = os.Chmod(dst.Name(), fi.Mode())
+	}
+	return os.Rename(dst.Name(), fname)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/migrator"
+)
+
+func TestRekey_Execute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_rekey")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key1, pub1, err := migrator.GenerateKey()
+	require.NoError(t, err)
+	key2, pub2, err := migrator.GenerateKey()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(dir+"/old.key", []byte("# old key\n"+key1+"\n"), 0600))
+
+	encrypt := func(fname string, c *migrator.Crypter, data string) {
+		buf := &bytes.Buffer{}
+		w, e := c.Encrypt(buf)
+		require.NoError(t, e)
+		_, e = w.Write([]byte(data))
+		require.NoError(t, e)
+		require.NoError(t, w.Close())
+		require.NoError(t, ioutil.WriteFile(fname, buf.Bytes(), 0600))
+	}
+	decrypt := func(fname string, c *migrator.Crypter) (string, error) {
+		fh, e := os.Open(fname)
+		require.NoError(t, e)
+		defer fh.Close()
+		r, e := c.Decrypt(fh)
+		if e != nil {
+			return "", e
+		}
+		data, e := ioutil.ReadAll(r)
+		return string(data), e
+	}
+	encrypt(dir+"/backup-remark-20200101.gz.enc", &migrator.Crypter{Recipients: []string{pub1}}, "data 1")
+	encrypt(dir+"/backup-remark-20200102.gz.enc", &migrator.Crypter{Passphrase: "old"}, "data 2")
+	require.NoError(t, ioutil.WriteFile(dir+"/plain.enc", []byte("plain"), 0600))
+
+	cmd := RekeyCommand{}
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--path=" + dir, "--old-passphrase=old", "--backup-encrypt.recipient=" + pub2,
+		"--backup-encrypt.identity=" + dir + "/old.key"})
+	require.NoError(t, err)
+	require.NoError(t, cmd.Execute(nil))
+
+	for fname, data := range map[string]string{"backup-remark-20200101.gz.enc": "data 1", "backup-remark-20200102.gz.enc": "data 2"} {
+		res, err := decrypt(dir+"/"+fname, &migrator.Crypter{Identities: []string{key2}})
+		require.NoError(t, err, fname)
+		assert.Equal(t, data, res)
+		_, err = decrypt(dir+"/"+fname, &migrator.Crypter{Identities: []string{key1}, Passphrase: "old"})
+		assert.Error(t, err, "old keys can't decrypt %s", fname)
+	}
+	plain, err := ioutil.ReadFile(dir + "/plain.enc")
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(plain), "not encrypted file skipped")
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(files), "no temporary files left")
+
+	// can't decrypt with new key only
+	cmd = RekeyCommand{}
+	p = flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--path=" + dir, "--backup-encrypt.recipient=" + pub2})
+	require.NoError(t, err)
+	assert.EqualError(t, cmd.Execute(nil), "failed to rekey 2 of 3 files")
+
+	cmd = RekeyCommand{}
+	p = flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--path=" + dir})
+	require.NoError(t, err)
+	assert.EqualError(t, cmd.Execute(nil), "no passphrase or recipients to encrypt for")
+}
@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -10,13 +12,17 @@ import (
 
 	log "github.com/go-pkgz/lgr"
 	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/migrator"
 )
 
 // RemapCommand set of flags and command for change linkage between comments to
 // different urls based on given rules (input file)
 type RemapCommand struct {
 	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
-	InputFile   string        `short:"f" long:"file" description:"input file name" required:"true"`
+	InputFile   string        `short:"f" long:"file" description:"input file name"`
+	DryRun      bool          `long:"dry-run" description:"show changes remap would make, without making them"`
+	Rollback    bool          `long:"rollback" description:"restore comments from the last pre-remap backup"`
 	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
 	Timeout     time.Duration `long:"timeout" default:"15m" description:"remap timeout"`
 	CommonOpts
@@ -24,27 +30,71 @@ type RemapCommand struct {
 
 // Execute runs (re)mapper with RemapCommand parameters, entry point for "remap" command
 func (rc *RemapCommand) Execute(args []string) error {
-	log.Printf("[INFO] start remap, site %s, file with rules %s", rc.Site, rc.InputFile)
 	resetEnv("SECRET", "ADMIN_PASSWD")
 
+	if rc.Rollback {
+		log.Printf("[INFO] start remap rollback, site %s", rc.Site)
+		rollbackURL := fmt.Sprintf("%s/api/v1/admin/remap/rollback?site=%s", rc.RemarkURL, rc.Site)
+		body, err := adminPost(rollbackURL, nil, rc.AdminPasswd, rc.Timeout)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] started, %s", string(body))
+		if err = waitJob(rc.RemarkURL, rc.Site, body, rc.AdminPasswd, rc.Timeout); err != nil {
+			return err
+		}
+		log.Printf("[INFO] rollback completed")
+		return nil
+	}
+
+	if rc.InputFile == "" {
+		return errors.New("file with rules required")
+	}
+	log.Printf("[INFO] start remap, site %s, file with rules %s, dry run %v", rc.Site, rc.InputFile, rc.DryRun)
 	rulesReader, err := os.Open(rc.InputFile)
 	if err != nil {
 		return errors.Wrapf(err, "cant open file %s", rc.InputFile)
 	}
+	defer rulesReader.Close()
 
+	remapURL := fmt.Sprintf("%s/api/v1/admin/remap?site=%s", rc.RemarkURL, rc.Site)
+	if rc.DryRun {
+		remapURL += "&dry=1"
+	}
+	body, err := adminPost(remapURL, rulesReader, rc.AdminPasswd, rc.Timeout)
+	if err != nil {
+		return err
+	}
+	if rc.DryRun {
+		return logRemapPlan(body)
+	}
+	log.Printf("[INFO] started, %s", string(body))
+	if err = waitJob(rc.RemarkURL, rc.Site, body, rc.AdminPasswd, rc.Timeout); err != nil {
+		return err
+	}
+	log.Printf("[INFO] remap completed")
+	return nil
+}
+
+// adminPost makes POST request with admin basic auth and returns response body
+func adminPost(url string, body io.Reader, passwd string, timeout time.Duration) ([]byte, error) {
+	return adminRequest(http.MethodPost, url, body, passwd, timeout)
+}
+
+// adminRequest makes request with admin basic auth and returns response body
+func adminRequest(method, url string, body io.Reader, passwd string, timeout time.Duration) ([]byte, error) {
 	client := http.Client{}
-	ctx, cancel := context.WithTimeout(context.Background(), rc.Timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	remapURL := fmt.Sprintf("%s/api/v1/admin/remap?site=%s", rc.RemarkURL, rc.Site)
-	req, err := http.NewRequest(http.MethodPost, remapURL, rulesReader)
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		return errors.Wrapf(err, "can't make remap request for %s", remapURL)
+		return nil, errors.Wrapf(err, "can't make request for %s", url)
 	}
-	req.SetBasicAuth("admin", rc.AdminPasswd)
+	req.SetBasicAuth("admin", passwd)
 
 	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
-		return errors.Wrapf(err, "request failed for %s", remapURL)
+		return nil, errors.Wrapf(err, "request failed for %s", url)
 	}
 	defer func() {
 		if err = resp.Body.Close(); err != nil {
@@ -52,14 +102,32 @@ func (rc *RemapCommand) Execute(args []string) error {
 		}
 	}()
 	if resp.StatusCode >= 300 {
-		return responseError(resp)
+		return nil, responseError(resp)
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	res, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return errors.Wrap(err, "can't get response")
+		return nil, errors.Wrap(err, "can't get response")
 	}
+	return res, nil
+}
 
-	log.Printf("[INFO] completed, status=%d, %s", resp.StatusCode, string(body))
+// logRemapPlan prints remap plan returned by dry run
+func logRemapPlan(body []byte) error {
+	plan := migrator.RemapPlan{}
+	if err := json.Unmarshal(body, &plan); err != nil {
+		return errors.Wrap(err, "can't decode remap plan")
+	}
+	for _, rule := range plan.Rules {
+		log.Printf("[INFO] rule %q, %d posts, %d comments", rule.Rule, len(rule.Posts), rule.Comments)
+		for _, p := range rule.Posts {
+			log.Printf("[INFO]   %s -> %s, %d comments", p.From, p.To, p.Comments)
+		}
+	}
+	for _, c := range plan.Collisions {
+		log.Printf("[WARN] collision, %v merged into %s", c.From, c.To)
+	}
+	log.Printf("[INFO] dry run completed, %d posts and %d comments would move, %d collisions",
+		plan.Posts, plan.Comments, len(plan.Collisions))
 	return nil
 }
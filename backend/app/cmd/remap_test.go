@@ -34,3 +34,42 @@ func TestRemap_Execute(t *testing.T) {
 	err = cmd.Execute(nil)
 	assert.NoError(t, err)
 }
+
+func TestRemap_ExecuteDryRun(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/admin/remap", r.URL.Path)
+		assert.Equal(t, "1", r.URL.Query().Get("dry"))
+		_, _ = w.Write([]byte(`{"rules":[{"rule":"http://oldsite.com* https://newsite.com*",` +
+			`"posts":[{"from":"http://oldsite.com/1","to":"https://newsite.com/1","comments":5}],"comments":5}],` +
+			`"collisions":[{"to":"https://newsite.com/1","from":["http://oldsite.com/1","https://newsite.com/1"]}],` +
+			`"posts":1,"comments":5}`))
+	}))
+	defer ts.Close()
+
+	cmd := RemapCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--file=testdata/remap_urls.txt", "--admin-passwd=secret", "--dry-run"})
+	require.NoError(t, err)
+	assert.NoError(t, cmd.Execute(nil))
+}
+
+func TestRemap_ExecuteRollback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/admin/remap/rollback", r.URL.Path)
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "remark", r.URL.Query().Get("site"))
+		w.WriteHeader(202)
+	}))
+	defer ts.Close()
+
+	cmd := RemapCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--admin-passwd=secret", "--rollback"})
+	require.NoError(t, err)
+	assert.NoError(t, cmd.Execute(nil))
+
+	cmd.Rollback = false
+	assert.EqualError(t, cmd.Execute(nil), "file with rules required")
+}
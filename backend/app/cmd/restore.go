@@ -1,9 +1,19 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/migrator"
 )
 
 // RestoreCommand set of flags and command for restore from backup
@@ -14,14 +24,31 @@ type RestoreCommand struct {
 	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
 	Timeout     time.Duration `long:"timeout" default:"15m" description:"import timeout"`
 	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	Encrypt     EncryptGroup  `group:"backup-encrypt" namespace:"backup-encrypt" env-namespace:"BACKUP_ENCRYPT"`
+
+	URLs      []string `long:"url" description:"restore comments of post url only, merged into the site"`
+	Users     []string `long:"user" description:"restore comments of user id only, merged into the site"`
+	From      string   `long:"from" description:"restore comments made since time (RFC3339 or yyyy-mm-dd) only"`
+	To        string   `long:"to" description:"restore comments made before time (RFC3339 or yyyy-mm-dd) only"`
+	Overwrite bool     `long:"overwrite" description:"replace existing comments on selective restore"`
 	CommonOpts
 }
 
 // Execute runs import with RestoreCommand parameters, entry point for "restore" command
-// uses ImportCommand with constructed full file name
+// uses ImportCommand with constructed full file name. Backup archive with images and avatars detected by content.
+// Encrypted backup decrypted with passphrase or identities if set, otherwise sent as is to be decrypted by server.
+// With url, user, from or to set only selected comments merged into the site, the rest of the site kept as is
 func (rc *RestoreCommand) Execute(args []string) error {
 	log.Printf("[INFO] restore %s, site %s", rc.ImportFile, rc.Site)
-	resetEnv("SECRET", "ADMIN_PASSWD")
+	resetEnv("SECRET", "ADMIN_PASSWD", "BACKUP_ENCRYPT_PASSPHRASE")
+
+	query := rc.selection()
+	if rc.Overwrite && len(query) == 0 {
+		return errors.New("overwrite allowed for selective restore only, set url, user, from or to")
+	}
+	if len(query) > 0 {
+		log.Printf("[INFO] selective restore, %s", query.Encode())
+	}
 
 	fp := fileParser{site: rc.Site, path: rc.ImportPath, file: rc.ImportFile}
 	fname, err := fp.parse(time.Now())
@@ -35,6 +62,104 @@ func (rc *RestoreCommand) Execute(args []string) error {
 		Timeout:     rc.Timeout,
 		AdminPasswd: rc.AdminPasswd,
 		CommonOpts:  rc.CommonOpts,
+		query:       query,
+	}
+
+	if migrator.IsEncryptedFile(fname) && (rc.Encrypt.Passphrase != "" || len(rc.Encrypt.Identities) > 0) {
+		decrypted, e := rc.decrypt(fname)
+		if e != nil {
+			return e
+		}
+		defer decrypted.Close() // nolint
+		// decrypted data streamed to import, never stored on disk
+		head, input := peek(decrypted, archivePeekSize)
+		if isArchive(bytes.NewReader(head)) {
+			importer.Provider = "archive"
+		}
+		importer.InputFile, importer.input = strings.TrimSuffix(fname, migrator.EncryptedExt), input
+		return importer.Execute(args)
+	}
+
+	if migrator.IsEncryptedFile(fname) {
+		log.Printf("[INFO] %s encrypted, no passphrase or identity, sent to be decrypted by server", fname)
+	}
+	if isArchiveFile(fname) {
+		importer.Provider = "archive"
 	}
 	return importer.Execute(args)
 }
+
+// selection returns import params of selective restore, empty for full restore
+func (rc *RestoreCommand) selection() url.Values {
+	query := url.Values{}
+	for _, u := range rc.URLs {
+		query.Add("url", u)
+	}
+	for _, u := range rc.Users {
+		query.Add("user", u)
+	}
+	if rc.From != "" {
+		query.Set("from", rc.From)
+	}
+	if rc.To != "" {
+		query.Set("to", rc.To)
+	}
+	if len(query) > 0 && rc.Overwrite {
+		query.Set("overwrite", "1")
+	}
+	return query
+}
+
+// decrypt opens encrypted backup and returns reader of decrypted data, closing the backup file
+func (rc *RestoreCommand) decrypt(fname string) (io.ReadCloser, error) {
+	crypter, err := rc.Encrypt.crypter()
+	if err != nil {
+		return nil, err
+	}
+	src, err := os.Open(fname) // nolint
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't open %s", fname)
+	}
+	r, err := crypter.Decrypt(src)
+	if err != nil {
+		_ = src.Close()
+		return nil, errors.Wrapf(err, "can't decrypt %s", fname)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, src}, nil
+}
+
+// archivePeekSize is size of data peeked to detect archive, enough for gzip to inflate the first tar header
+const archivePeekSize = 64 * 1024
+
+// peek returns up to size bytes from the start of reader and reader with the whole data
+func peek(r io.Reader, size int) (head []byte, res io.Reader) {
+	br := bufio.NewReaderSize(r, size)
+	head, _ = br.Peek(size) // error means short data, head has all of it
+	return head, br
+}
+
+// isArchiveFile checks if file is tar.gz
+func isArchiveFile(fname string) bool {
+	fh, err := os.Open(fname) // nolint
+	if err != nil {
+		return false
+	}
+	defer fh.Close() // nolint
+	return isArchive(fh)
+}
+
+// isArchive checks if data is tar.gz, i.e. gzip with ustar magic in the first tar header
+func isArchive(r io.Reader) bool {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return false
+	}
+	hdr := make([]byte, 262)
+	if _, err = io.ReadFull(gz, hdr); err != nil {
+		return false
+	}
+	return bytes.HasPrefix(hdr[257:], []byte("ustar"))
+}
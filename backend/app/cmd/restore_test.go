@@ -1,15 +1,20 @@
 package cmd
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 
 	flags "github.com/jessevdk/go-flags"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/migrator"
 )
 
 func TestRestore_Execute(t *testing.T) {
@@ -36,3 +41,152 @@ func TestRestore_Execute(t *testing.T) {
 	err = cmd.Execute(nil)
 	assert.NoError(t, err)
 }
+
+func TestRestore_ExecuteSelective(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/api/v1/admin/import")
+		q := r.URL.Query()
+		assert.Equal(t, "native", q.Get("provider"))
+		assert.Equal(t, []string{"https://remark42.com/demo/", "https://remark42.com/demo?a=1&b=2"}, q["url"])
+		assert.Equal(t, []string{"u1"}, q["user"])
+		assert.Equal(t, "2020-01-02", q.Get("from"))
+		assert.Equal(t, "", q.Get("to"))
+		assert.Equal(t, "1", q.Get("overwrite"))
+		fmt.Fprintln(w, "some response")
+	}))
+	defer ts.Close()
+
+	cmd := RestoreCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--path=testdata", "--file=import.txt", "--admin-passwd=secret",
+		"--url=https://remark42.com/demo/", "--url=https://remark42.com/demo?a=1&b=2", "--user=u1", "--from=2020-01-02", "--overwrite"})
+	require.NoError(t, err)
+	assert.NoError(t, cmd.Execute(nil))
+
+	cmd = RestoreCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p = flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--path=testdata", "--file=import.txt", "--admin-passwd=secret", "--overwrite"})
+	require.NoError(t, err)
+	assert.EqualError(t, cmd.Execute(nil), "overwrite allowed for selective restore only, set url, user, from or to")
+}
+
+func TestRestore_ExecuteArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_restore_archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fh, err := os.Create(dir + "/backup-remark.tar.gz")
+	require.NoError(t, err)
+	gz := gzip.NewWriter(fh)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0600, Size: 2, Typeflag: tar.TypeReg}))
+	_, err = tw.Write([]byte("{}"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, fh.Close())
+	archive, err := ioutil.ReadFile(dir + "/backup-remark.tar.gz")
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/api/v1/admin/import")
+		assert.Equal(t, "archive", r.URL.Query().Get("provider"))
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, archive, body, "archive sent as is")
+		fmt.Fprintln(w, "some response")
+	}))
+	defer ts.Close()
+
+	cmd := RestoreCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--path=" + dir, "--file=backup-{{.SITE}}.tar.gz", "--admin-passwd=secret"})
+	require.NoError(t, err)
+	assert.NoError(t, cmd.Execute(nil))
+
+	// encrypted archive detected after decryption
+	key, pub, err := migrator.GenerateKey()
+	require.NoError(t, err)
+	fh, err = os.Create(dir + "/backup-remark.tar.gz.enc")
+	require.NoError(t, err)
+	w, err := (&migrator.Crypter{Recipients: []string{pub}}).Encrypt(fh)
+	require.NoError(t, err)
+	_, err = w.Write(archive)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, fh.Close())
+	require.NoError(t, ioutil.WriteFile(dir+"/backup.key", []byte(key), 0600))
+	cmd = RestoreCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p = flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--path=" + dir, "--file=backup-{{.SITE}}.tar.gz.enc", "--admin-passwd=secret",
+		"--backup-encrypt.identity=" + dir + "/backup.key"})
+	require.NoError(t, err)
+	assert.NoError(t, cmd.Execute(nil))
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(files), "no decrypted files")
+
+	assert.False(t, isArchiveFile("testdata/import.txt"))
+	assert.False(t, isArchiveFile(dir+"/no-such-file"))
+}
+
+func TestRestore_ExecuteEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_restore_encrypted")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	gzData, err := ioutil.ReadFile("testdata/import.txt.gz")
+	require.NoError(t, err)
+	fh, err := os.Create(dir + "/backup-remark.gz.enc")
+	require.NoError(t, err)
+	w, err := (&migrator.Crypter{Passphrase: "pass"}).Encrypt(fh)
+	require.NoError(t, err)
+	_, err = w.Write(gzData)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, fh.Close())
+	encData, err := ioutil.ReadFile(dir + "/backup-remark.gz.enc")
+	require.NoError(t, err)
+
+	var received []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "native", r.URL.Query().Get("provider"))
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		received = body
+		fmt.Fprintln(w, "some response")
+	}))
+	defer ts.Close()
+
+	// decrypted and unpacked with passphrase
+	cmd := RestoreCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--path=" + dir, "--file=backup-{{.SITE}}.gz.enc", "--admin-passwd=secret",
+		"--backup-encrypt.passphrase=pass"})
+	require.NoError(t, err)
+	require.NoError(t, cmd.Execute(nil))
+	assert.Equal(t, "blah\nblah2\n12345678\n", string(received))
+
+	// sent as is without passphrase
+	cmd = RestoreCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p = flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--path=" + dir, "--file=backup-{{.SITE}}.gz.enc", "--admin-passwd=secret"})
+	require.NoError(t, err)
+	require.NoError(t, cmd.Execute(nil))
+	assert.Equal(t, encData, received)
+
+	// wrong passphrase
+	cmd = RestoreCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p = flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--path=" + dir, "--file=backup-{{.SITE}}.gz.enc", "--admin-passwd=secret",
+		"--backup-encrypt.passphrase=bad"})
+	require.NoError(t, err)
+	assert.Error(t, cmd.Execute(nil))
+}
@@ -10,6 +10,7 @@ import (
 	"os/signal"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -18,6 +19,7 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-pkgz/jrpc"
 	log "github.com/go-pkgz/lgr"
+	"github.com/go-redis/redis/v7"
 	"github.com/kyokomi/emoji"
 	authcache "github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
@@ -29,14 +31,18 @@ import (
 	cache "github.com/go-pkgz/lcw"
 
 	"github.com/umputun/remark/backend/app/email"
+	"github.com/umputun/remark/backend/app/jobs"
 	"github.com/umputun/remark/backend/app/migrator"
 	"github.com/umputun/remark/backend/app/notify"
 	"github.com/umputun/remark/backend/app/rest/api"
 	"github.com/umputun/remark/backend/app/rest/proxy"
+	"github.com/umputun/remark/backend/app/rest/ratelimit"
 	"github.com/umputun/remark/backend/app/store"
 	"github.com/umputun/remark/backend/app/store/admin"
+	"github.com/umputun/remark/backend/app/store/changelog"
 	"github.com/umputun/remark/backend/app/store/engine"
 	"github.com/umputun/remark/backend/app/store/image"
+	"github.com/umputun/remark/backend/app/store/pubsub"
 	"github.com/umputun/remark/backend/app/store/service"
 )
 
@@ -53,12 +59,23 @@ type ServerCommand struct {
 	SSL        SSLGroup        `group:"ssl" namespace:"ssl" env-namespace:"SSL"`
 	Stream     StreamGroup     `group:"stream" namespace:"stream" env-namespace:"STREAM"`
 	ImageProxy ImageProxyGroup `group:"image-proxy" namespace:"image-proxy" env-namespace:"IMAGE_PROXY"`
+	Changes    ChangesGroup    `group:"changes" namespace:"changes" env-namespace:"CHANGES"`
+	Jobs       JobsGroup       `group:"jobs" namespace:"jobs" env-namespace:"JOBS"`
+	Encrypt    EncryptGroup    `group:"backup-encrypt" namespace:"backup-encrypt" env-namespace:"BACKUP_ENCRYPT"`
+	Target     TargetGroup     `group:"backup-target" namespace:"backup-target" env-namespace:"BACKUP_TARGET"`
+	Keep       KeepGroup       `group:"backup-keep" namespace:"backup-keep" env-namespace:"BACKUP_KEEP"`
+	PubSub     PubSubGroup     `group:"pubsub" namespace:"pubsub" env-namespace:"PUBSUB"`
+	RateLimit  RateLimitGroup  `group:"ratelimit" namespace:"ratelimit" env-namespace:"RATELIMIT"`
+	Flood      FloodGroup      `group:"flood" namespace:"flood" env-namespace:"FLOOD"`
+	Previews   PreviewsGroup   `group:"previews" namespace:"previews" env-namespace:"PREVIEWS"`
+	URLs       URLsGroup       `group:"urls" namespace:"urls" env-namespace:"URLS"`
 
 	Sites            []string      `long:"site" env:"SITE" default:"remark" description:"site names" env-delim:","`
 	AnonymousVote    bool          `long:"anon-vote" env:"ANON_VOTE" description:"enable anonymous votes (works only with VOTES_IP enabled)"`
 	AdminPasswd      string        `long:"admin-passwd" env:"ADMIN_PASSWD" default:"" description:"admin basic auth password"`
 	BackupLocation   string        `long:"backup" env:"BACKUP_PATH" default:"./var/backup" description:"backups location"`
 	MaxBackupFiles   int           `long:"max-back" env:"MAX_BACKUP_FILES" default:"10" description:"max backups to keep"`
+	BackupArchive    bool          `long:"backup-archive" env:"BACKUP_ARCHIVE" description:"make backups as tar.gz archive with images and avatars"`
 	LegacyImageProxy bool          `long:"img-proxy" env:"IMG_PROXY" description:"[deprecated, use image-proxy.http2https] enable image proxy"`
 	MaxCommentSize   int           `long:"max-comment" env:"MAX_COMMENT_SIZE" default:"2048" description:"max comment size"`
 	MaxVotes         int           `long:"max-votes" env:"MAX_VOTES" default:"-1" description:"maximum number of votes per comment"`
@@ -109,8 +126,56 @@ type ServerCommand struct {
 
 // ImageProxyGroup defines options group for image proxy
 type ImageProxyGroup struct {
-	HTTP2HTTPS    bool `long:"http2https" env:"HTTP2HTTPS" description:"enable HTTP->HTTPS proxy"`
-	CacheExternal bool `long:"cache-external" env:"CACHE_EXTERNAL" description:"enable caching for external images"`
+	HTTP2HTTPS    bool          `long:"http2https" env:"HTTP2HTTPS" description:"enable HTTP->HTTPS proxy"`
+	CacheExternal bool          `long:"cache-external" env:"CACHE_EXTERNAL" description:"enable caching for external images"`
+	CacheTTL      time.Duration `long:"cache-ttl" env:"CACHE_TTL" default:"720h" description:"remove cached images not requested for this time, never if 0"`
+	CacheMaxSize  int64         `long:"cache-max-size" env:"CACHE_MAX_SIZE" default:"1000000000" description:"max total size of cached images, unlimited if 0"`
+	CacheMaxItems int           `long:"cache-max-items" env:"CACHE_MAX_ITEMS" description:"max number of cached images, unlimited if 0"`
+}
+
+// TargetGroup defines options group for targets keeping copies of automatic backups
+type TargetGroup struct {
+	Type []string `long:"type" env:"TYPE" description:"types of backup targets" choice:"dir" choice:"s3" choice:"sftp" env-delim:","` // nolint
+	Dir  string   `long:"dir" env:"DIR" description:"directory for copies of backups, i.e. mounted network storage"`
+	S3   struct {
+		Endpoint  string `long:"endpoint" env:"ENDPOINT" default:"https://s3.amazonaws.com" description:"s3 endpoint"`
+		Region    string `long:"region" env:"REGION" default:"us-east-1" description:"s3 region"`
+		Bucket    string `long:"bucket" env:"BUCKET" description:"s3 bucket"`
+		AccessKey string `long:"access-key" env:"ACCESS_KEY" description:"s3 access key"`
+		SecretKey string `long:"secret-key" env:"SECRET_KEY" description:"s3 secret key"`
+		Prefix    string `long:"prefix" env:"PREFIX" default:"remark42/backup" description:"prefix for backups in bucket"`
+	} `group:"s3" namespace:"s3" env-namespace:"S3"`
+	SFTP struct {
+		Addr string `long:"addr" env:"ADDR" description:"sftp server, user@host"`
+		Port int    `long:"port" env:"PORT" default:"22" description:"sftp port"`
+		Path string `long:"path" env:"PATH" default:"." description:"directory for backups on sftp server"`
+		Key  string `long:"key" env:"KEY" description:"private key file, default keys of ssh used if not set"`
+	} `group:"sftp" namespace:"sftp" env-namespace:"SFTP"`
+	Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"10m" description:"timeout of backup upload or download"`
+}
+
+// KeepGroup defines options group for retention of automatic backups, grandfather-father-son
+type KeepGroup struct {
+	Daily   int `long:"daily" env:"DAILY" description:"number of daily backups to keep, max-back if nothing set"`
+	Weekly  int `long:"weekly" env:"WEEKLY" description:"number of weekly backups to keep"`
+	Monthly int `long:"monthly" env:"MONTHLY" description:"number of monthly backups to keep"`
+}
+
+// PreviewsGroup defines options group for link previews
+type PreviewsGroup struct {
+	Enabled  bool          `long:"enabled" env:"ENABLED" description:"enable previews of links in comments"`
+	MaxLinks int           `long:"max-links" env:"MAX_LINKS" default:"3" description:"max number of link previews per comment"`
+	Timeout  time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"timeout of linked page download"`
+}
+
+// URLsGroup defines default normalization of post urls and per-site overrides
+type URLsGroup struct {
+	Scheme string   `long:"scheme" env:"SCHEME" description:"force scheme of post urls, http or https"`
+	Hosts  []string `long:"host" env:"HOST" env-delim:"," description:"host alias, alias>canonical"`
+	Slash  string   `long:"slash" env:"SLASH" description:"trailing slash of post urls, add or strip"`
+	Query  []string `long:"query" env:"QUERY" env-delim:"," description:"allowed query params, others dropped"`
+	AMP    bool     `long:"amp" env:"AMP" description:"strip amp path element and amp query param"`
+	Sites  []string `long:"site" env:"SITE" env-delim:";" description:"per-site rules, site:key=value,key=value"`
 }
 
 // AuthGroup defines options group for auth params
@@ -131,7 +196,7 @@ type StoreGroup struct {
 
 // ImageGroup defines options group for store pictures
 type ImageGroup struct {
-	Type string `long:"type" env:"TYPE" description:"type of storage" choice:"fs" choice:"bolt" choice:"rpc" default:"fs"` // nolint
+	Type string `long:"type" env:"TYPE" description:"type of storage" choice:"fs" choice:"bolt" choice:"s3" choice:"rpc" default:"fs"` // nolint
 	FS   struct {
 		Path       string `long:"path" env:"PATH" default:"./var/pictures" description:"images location"`
 		Staging    string `long:"staging" env:"STAGING" default:"./var/pictures.staging" description:"staging location"`
@@ -140,9 +205,33 @@ type ImageGroup struct {
 	Bolt struct {
 		File string `long:"file" env:"FILE" default:"./var/pictures.db" description:"images bolt file location"`
 	} `group:"bolt" namespace:"bolt" env-namespace:"bolt"`
+	S3 struct {
+		Endpoint     string        `long:"endpoint" env:"ENDPOINT" default:"https://s3.amazonaws.com" description:"s3 endpoint"`
+		Region       string        `long:"region" env:"REGION" default:"us-east-1" description:"s3 region"`
+		Bucket       string        `long:"bucket" env:"BUCKET" description:"s3 bucket"`
+		AccessKey    string        `long:"access-key" env:"ACCESS_KEY" description:"s3 access key"`
+		SecretKey    string        `long:"secret-key" env:"SECRET_KEY" description:"s3 secret key"`
+		Prefix       string        `long:"prefix" env:"PREFIX" default:"remark42" description:"prefix for images in bucket"`
+		SignedURLTTL time.Duration `long:"signed-url-ttl" env:"SIGNED_URL_TTL" description:"redirect to signed urls valid for this time, disabled if 0"`
+	} `group:"s3" namespace:"s3" env-namespace:"S3"`
+	GC struct {
+		Interval time.Duration `long:"interval" env:"INTERVAL" description:"interval of orphaned images removal, disabled if 0"`
+		Grace    time.Duration `long:"grace" env:"GRACE" default:"720h" description:"keep orphaned images for this time"`
+	} `group:"gc" namespace:"gc" env-namespace:"GC"`
+	Uploads struct {
+		Type string `long:"type" env:"TYPE" description:"type of uploads tracking" choice:"none" choice:"mem" choice:"bolt" default:"none"` // nolint
+		Bolt struct {
+			File string `long:"file" env:"FILE" default:"./var/uploads.db" description:"uploads bolt file location"`
+		} `group:"bolt" namespace:"bolt" env-namespace:"BOLT"`
+		UserQuota  int64 `long:"user-quota" env:"USER_QUOTA" description:"max size of images uploaded by user to the site, unlimited if 0"`
+		SiteQuota  int64 `long:"site-quota" env:"SITE_QUOTA" description:"max size of images uploaded to the site, unlimited if 0"`
+		DailyLimit int   `long:"daily-limit" env:"DAILY_LIMIT" description:"max number of images uploaded by user in 24h, unlimited if 0"`
+	} `group:"uploads" namespace:"uploads" env-namespace:"UPLOADS"`
 	MaxSize      int      `long:"max-size" env:"MAX_SIZE" default:"5000000" description:"max size of image file"`
 	ResizeWidth  int      `long:"resize-width" env:"RESIZE_WIDTH" default:"2400" description:"width of resized image"`
 	ResizeHeight int      `long:"resize-height" env:"RESIZE_HEIGHT" default:"900" description:"height of resized image"`
+	JPEGQuality  int      `long:"jpeg-quality" env:"JPEG_QUALITY" default:"85" description:"quality of re-encoded jpeg images"`
+	Variants     []int    `long:"variant" env:"VARIANTS" env-delim:"," default:"320" default:"640" default:"1024" description:"allowed widths of resized image variants"` // nolint
 	RPC          RPCGroup `group:"rpc" namespace:"rpc" env-namespace:"RPC"`
 }
 
@@ -161,12 +250,21 @@ type AvatarGroup struct {
 
 // CacheGroup defines options group for cache params
 type CacheGroup struct {
-	Type string `long:"type" env:"TYPE" description:"type of cache" choice:"mem" choice:"none" default:"mem"` // nolint
+	Type string `long:"type" env:"TYPE" description:"type of cache" choice:"mem" choice:"redis" choice:"none" default:"mem"` // nolint
 	Max  struct {
 		Items int   `long:"items" env:"ITEMS" default:"1000" description:"max cached items"`
 		Value int   `long:"value" env:"VALUE" default:"65536" description:"max size of cached value"`
 		Size  int64 `long:"size" env:"SIZE" default:"50000000" description:"max size of total cache"`
 	} `group:"max" namespace:"max" env-namespace:"MAX"`
+	Redis RedisGroup    `group:"redis" namespace:"redis" env-namespace:"REDIS"`
+	TTL   time.Duration `long:"ttl" env:"TTL" default:"5m" description:"ttl for redis cache"`
+}
+
+// RedisGroup defines options for redis connection
+type RedisGroup struct {
+	Addr     string `long:"addr" env:"ADDR" default:"localhost:6379" description:"redis address"`
+	Password string `long:"password" env:"PASSWORD" description:"redis password"`
+	DB       int    `long:"db" env:"DB" default:"0" description:"redis db number"`
 }
 
 // AdminGroup defines options group for admin params
@@ -248,6 +346,51 @@ type StreamGroup struct {
 	MaxActive       int           `long:"max" env:"MAX" default:"500" description:"max number of parallel streams"`
 }
 
+// PubSubGroup defines options for live updates delivery to streams and websockets
+type PubSubGroup struct {
+	Type  string `long:"type" env:"TYPE" description:"type of live updates transport" choice:"none" choice:"local" choice:"redis" default:"local"` // nolint
+	Redis struct {
+		RedisGroup
+		Channel string `long:"channel" env:"CHANNEL" default:"remark42-events" description:"redis channel for events"`
+	} `group:"redis" namespace:"redis" env-namespace:"REDIS"`
+}
+
+// RateLimitGroup defines options for requests rate limiting
+type RateLimitGroup struct {
+	Type  string     `long:"type" env:"TYPE" description:"type of rate limit counters store" choice:"mem" choice:"redis" default:"mem"` // nolint
+	Redis RedisGroup `group:"redis" namespace:"redis" env-namespace:"REDIS"`
+	Rules []string   `long:"rule" env:"RULE" env-delim:";" description:"rate limit rule, [METHOD] path limit/period [ip|user]"`
+	File  string     `long:"file" env:"FILE" description:"file with rate limit rules, one per line"`
+}
+
+// FloodGroup defines default comments posting policy and per-site overrides
+type FloodGroup struct {
+	SlowMode    time.Duration `long:"slow-mode" env:"SLOW_MODE" description:"min interval between user's comments on the same post"`
+	DailyQuota  int           `long:"daily-quota" env:"DAILY_QUOTA" description:"max number of user's comments in 24h"`
+	LinksMinAge time.Duration `long:"links-min-age" env:"LINKS_MIN_AGE" description:"min time since first comment to post links, for unverified users"`
+	MaxLinks    int           `long:"max-links" env:"MAX_LINKS" description:"max number of links in comment"`
+	Duplicates  time.Duration `long:"duplicates" env:"DUPLICATES" description:"reject the same text posted by user within this interval"`
+	Sites       []string      `long:"site" env:"SITE" env-delim:";" description:"per-site policy, site:key=value,key=value"`
+}
+
+// ChangesGroup defines options for change log (feed) of comments
+type ChangesGroup struct {
+	Type string `long:"type" env:"TYPE" description:"type of change log storage" choice:"none" choice:"bolt" choice:"mem" default:"none"` // nolint
+	Bolt struct {
+		File string `long:"file" env:"FILE" default:"./var/changes.db" description:"change log bolt file location"`
+	} `group:"bolt" namespace:"bolt" env-namespace:"BOLT"`
+	Retention time.Duration `long:"retention" env:"RETENTION" default:"720h" description:"how long to keep change records"`
+}
+
+// JobsGroup defines options for history of background jobs, like import, export and remap
+type JobsGroup struct {
+	Type string `long:"type" env:"TYPE" description:"type of jobs storage" choice:"bolt" choice:"mem" default:"bolt"` // nolint
+	Bolt struct {
+		File string `long:"file" env:"FILE" default:"./var/jobs.db" description:"jobs bolt file location"`
+	} `group:"bolt" namespace:"bolt" env-namespace:"BOLT"`
+	Keep int `long:"keep" env:"KEEP" default:"100" description:"finished jobs to keep for each site"`
+}
+
 // RPCGroup defines options for remote modules (plugins)
 type RPCGroup struct {
 	API          string        `long:"api" env:"API" description:"rpc extension api url"`
@@ -268,11 +411,16 @@ type serverApp struct {
 	restSrv       *api.Rest
 	migratorSrv   *api.Migrator
 	exporter      migrator.Exporter
+	crypter       *migrator.Crypter
+	backupTargets []migrator.Target
 	devAuth       *provider.DevAuthServer
 	dataService   *service.DataStore
 	avatarStore   avatar.Store
 	notifyService *notify.Service
 	imageService  *image.Service
+	changeLog     *changelog.Service
+	jobs          *jobs.Service
+	pubSub        *pubsub.Hub
 	authenticator *auth.Service
 	terminated    chan struct{}
 }
@@ -280,7 +428,8 @@ type serverApp struct {
 // Execute is the entry point for "server" command, called by flag parser
 func (s *ServerCommand) Execute(args []string) error {
 	log.Printf("[INFO] start server on port %d", s.Port)
-	resetEnv("SECRET", "AUTH_GOOGLE_CSEC", "AUTH_GITHUB_CSEC", "AUTH_FACEBOOK_CSEC", "AUTH_YANDEX_CSEC", "ADMIN_PASSWD")
+	resetEnv("SECRET", "AUTH_GOOGLE_CSEC", "AUTH_GITHUB_CSEC", "AUTH_FACEBOOK_CSEC", "AUTH_YANDEX_CSEC", "ADMIN_PASSWD",
+		"BACKUP_ENCRYPT_PASSPHRASE", "BACKUP_TARGET_S3_SECRET_KEY")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() { // catch signal and invoke graceful termination
@@ -392,6 +541,46 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 	}
 	log.Printf("[DEBUG] image service for url=%s, ttl=%v", imageService.ImageAPI, imageService.TTL)
 
+	changeLog, err := s.makeChangeLog()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make change log")
+	}
+
+	jobsService, err := s.makeJobs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make jobs service")
+	}
+
+	crypter, err := s.Encrypt.crypter()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make backup encryption")
+	}
+
+	backupTargets, err := s.makeBackupTargets()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make backup targets")
+	}
+
+	pubSub, err := s.makePubSub()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make pubsub")
+	}
+
+	rateLimiter, err := s.makeRateLimiter()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make rate limiter")
+	}
+
+	floodControl, err := s.makeFloodControl()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make flood control")
+	}
+
+	urlNormalizer, err := s.makeURLNormalizer()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make url normalizer")
+	}
+
 	dataService := &service.DataStore{
 		Engine:                 storeEngine,
 		EditDuration:           s.EditDuration,
@@ -400,6 +589,10 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		MaxVotes:               s.MaxVotes,
 		PositiveScore:          s.PositiveScore,
 		ImageService:           imageService,
+		ChangeLog:              changeLog,
+		PubSub:                 pubSub,
+		FloodControl:           floodControl,
+		URLNormalizer:          urlNormalizer,
 		TitleExtractor:         service.NewTitleExtractor(http.Client{Timeout: time.Second * 5}),
 		RestrictedWordsMatcher: service.NewRestrictedWordsMatcher(service.StaticRestrictedWordsLister{Words: s.RestrictedWords}),
 	}
@@ -416,17 +609,40 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		return nil, errors.Wrap(err, "failed to make avatar store")
 	}
 	authenticator := s.makeAuthenticator(dataService, avatarStore, adminStore)
+	rateLimiter.UserFn = func(r *http.Request) (string, error) {
+		claims, _, err := authenticator.TokenService().Get(r)
+		if err != nil || claims.User == nil {
+			return "", err
+		}
+		return claims.User.ID, nil
+	}
 
-	exporter := &migrator.Native{DataStore: dataService}
+	archive := &migrator.Archive{DataStore: dataService, ImageService: imageService, AvatarStore: avatarStore}
+	var exporter migrator.Exporter = &migrator.Native{DataStore: dataService}
+	if s.BackupArchive {
+		exporter = archive
+	}
 
 	migr := &api.Migrator{
 		Cache:             loadingCache,
 		NativeImporter:    &migrator.Native{DataStore: dataService},
-		DisqusImporter:    &migrator.Disqus{DataStore: dataService},
+		DisqusImporter:    &migrator.Disqus{DataStore: dataService, AvatarProxy: authenticator.AvatarProxy()},
 		WordPressImporter: &migrator.WordPress{DataStore: dataService},
+		CommentoImporter:  &migrator.Commento{DataStore: dataService},
+		IssoImporter:      &migrator.Isso{DataStore: dataService},
+		ArchiveImporter:   archive,
 		NativeExporter:    &migrator.Native{DataStore: dataService},
+		DisqusExporter:    &migrator.Disqus{DataStore: dataService},
+		WordPressExporter: &migrator.WordPress{DataStore: dataService},
+		ArchiveExporter:   archive,
 		UrlMapperMaker:    migrator.NewUrlMapper,
+		URLNormalizer:     urlNormalizer,
+		DataStore:         dataService,
+		AvatarProxy:       authenticator.AvatarProxy(),
 		KeyStore:          adminStore,
+		BackupLocation:    s.BackupLocation,
+		Jobs:              jobsService,
+		Crypter:           crypter,
 	}
 
 	var emailNotifications bool
@@ -451,12 +667,26 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		RoutePath:     "/api/v1/img",
 		RemarkURL:     s.RemarkURL,
 		ImageService:  imageService,
+		Fetcher:       proxy.Fetcher{MaxSize: int64(s.Image.MaxSize)},
+	}
+	if s.ImageProxy.CacheExternal {
+		imgProxy.Cache = &proxy.ImageCache{
+			ImageService: imageService,
+			TTL:          s.ImageProxy.CacheTTL,
+			MaxSize:      s.ImageProxy.CacheMaxSize,
+			MaxItems:     s.ImageProxy.CacheMaxItems,
+		}
 	}
 	emojiFmt := store.CommentConverterFunc(func(text string) string { return text })
 	if s.EnableEmoji {
 		emojiFmt = func(text string) string { return emoji.Sprint(text) }
 	}
 	commentFormatter := store.NewCommentFormatter(imgProxy, emojiFmt).WithStyle(s.CodeColor)
+	if s.Previews.Enabled {
+		fetcher := proxy.Fetcher{Timeout: s.Previews.Timeout, MaxSize: 1024 * 1024,
+			ContentTypes: []string{"text/html", "application/xhtml+xml", "application/json", "text/json"}}
+		commentFormatter.WithPreviews(service.NewPreviewExtractor(fetcher, s.Previews.Timeout, s.Previews.MaxLinks))
+	}
 
 	sslConfig, err := s.makeSSLConfig()
 	if err != nil {
@@ -479,10 +709,14 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		SSLConfig:        sslConfig,
 		UpdateLimiter:    s.UpdateLimit,
 		ImageService:     imageService,
+		ChangeLog:        changeLog,
+		RateLimiter:      rateLimiter,
+		Sites:            s.Sites,
 		Streamer: &api.Streamer{
 			TimeOut:   s.Stream.TimeOut,
 			Refresh:   s.Stream.RefreshInterval,
 			MaxActive: int32(s.Stream.MaxActive),
+			Hub:       pubSub,
 		},
 		EmailNotifications: emailNotifications,
 		EmojiEnabled:       s.EnableEmoji,
@@ -511,11 +745,16 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		restSrv:       srv,
 		migratorSrv:   migr,
 		exporter:      exporter,
+		crypter:       crypter,
+		backupTargets: backupTargets,
 		devAuth:       devAuth,
 		dataService:   dataService,
 		avatarStore:   avatarStore,
 		notifyService: notifyService,
 		imageService:  imageService,
+		changeLog:     changeLog,
+		jobs:          jobsService,
+		pubSub:        pubSub,
 		authenticator: authenticator,
 		terminated:    make(chan struct{}),
 	}, nil
@@ -540,6 +779,20 @@ func (a *serverApp) run(ctx context.Context) error {
 	}
 
 	go a.imageService.Cleanup(ctx) // pictures cleanup for staging images
+	if a.Image.GC.Interval > 0 {
+		go a.imageService.RunGC(ctx, a.Image.GC.Interval, func() (map[string]bool, error) {
+			return a.dataService.ImageRefs(a.Sites...) // removal of images not referenced by comments
+		})
+	}
+	if a.restSrv.ImageProxy.Cache != nil {
+		go a.restSrv.ImageProxy.Cache.Run(ctx, time.Hour) // removal of expired cached external images
+	}
+	if a.changeLog != nil {
+		go a.changeLog.Cleanup(ctx, a.Sites...) // removal of expired change records
+	}
+	if a.pubSub != nil {
+		go a.pubSub.Listen(ctx) // events published by other instances
+	}
 
 	a.restSrv.Run(a.Port)
 
@@ -553,6 +806,9 @@ func (a *serverApp) run(ctx context.Context) error {
 	if e := a.avatarStore.Close(); e != nil {
 		log.Printf("[WARN] failed to close avatar store, %s", e)
 	}
+	if e := a.jobs.Close(); e != nil {
+		log.Printf("[WARN] failed to close jobs store, %s", e)
+	}
 	a.notifyService.Close()
 	// call potentially infinite loop with cancellation after a minute as a safeguard
 	minuteCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
@@ -577,6 +833,13 @@ func (a *serverApp) activateBackup(ctx context.Context) {
 			SiteID:         siteID,
 			KeepMax:        a.MaxBackupFiles,
 			Duration:       24 * time.Hour,
+			Archive:        a.BackupArchive,
+			Crypter:        a.crypter,
+			Jobs:           a.jobs,
+			Targets:        a.backupTargets,
+			Retention:      migrator.Retention{Daily: a.Keep.Daily, Weekly: a.Keep.Weekly, Monthly: a.Keep.Monthly},
+			NotifyService:  a.notifyService,
+			AdminEmail:     a.Admin.Shared.Email,
 		}
 		go backup.Do(ctx)
 	}
@@ -632,12 +895,26 @@ func (s *ServerCommand) makeAvatarStore() (avatar.Store, error) {
 
 func (s *ServerCommand) makePicturesStore() (*image.Service, error) {
 	imageServiceParams := image.ServiceParams{
-		ImageAPI:  s.RemarkURL + "/api/v1/picture/",
-		TTL:       5 * s.EditDuration, // add extra time to image TTL for staging
-		MaxSize:   s.Image.MaxSize,
-		MaxHeight: s.Image.ResizeHeight,
-		MaxWidth:  s.Image.ResizeWidth,
+		ImageAPI:      s.RemarkURL + "/api/v1/picture/",
+		TTL:           5 * s.EditDuration, // add extra time to image TTL for staging
+		MaxSize:       s.Image.MaxSize,
+		MaxHeight:     s.Image.ResizeHeight,
+		MaxWidth:      s.Image.ResizeWidth,
+		JPEGQuality:   s.Image.JPEGQuality,
+		VariantWidths: s.Image.Variants,
+		GCGrace:       s.Image.GC.Grace,
+		UploadLimits: image.UploadLimits{
+			UserSize:   s.Image.Uploads.UserQuota,
+			SiteSize:   s.Image.Uploads.SiteQuota,
+			DailyCount: s.Image.Uploads.DailyLimit,
+		},
+	}
+	uploads, err := s.makeUploadsStore()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make uploads store")
 	}
+	imageServiceParams.Uploads = uploads
+
 	switch s.Image.Type {
 	case "bolt":
 		boltImageStore, err := image.NewBoltStorage(s.Image.Bolt.File, bolt.Options{})
@@ -654,6 +931,20 @@ func (s *ServerCommand) makePicturesStore() (*image.Service, error) {
 			Staging:    s.Image.FS.Staging,
 			Partitions: s.Image.FS.Partitions,
 		}, imageServiceParams), nil
+	case "s3":
+		if s.Image.S3.Bucket == "" {
+			return nil, errors.New("s3 bucket not set")
+		}
+		imageServiceParams.SignedURLTTL = s.Image.S3.SignedURLTTL
+		return image.NewService(&image.S3{
+			Endpoint:  s.Image.S3.Endpoint,
+			Region:    s.Image.S3.Region,
+			Bucket:    s.Image.S3.Bucket,
+			AccessKey: s.Image.S3.AccessKey,
+			SecretKey: s.Image.S3.SecretKey,
+			Prefix:    s.Image.S3.Prefix,
+			Client:    http.Client{Timeout: 30 * time.Second},
+		}, imageServiceParams), nil
 	case "rpc":
 		return image.NewService(&image.RPC{
 			Client: jrpc.Client{
@@ -666,6 +957,313 @@ func (s *ServerCommand) makePicturesStore() (*image.Service, error) {
 	return nil, errors.Errorf("unsupported pictures store type %s", s.Image.Type)
 }
 
+// makeUploadsStore creates store of uploaded images records, returns nil if uploads not tracked
+func (s *ServerCommand) makeUploadsStore() (image.UploadStore, error) {
+	log.Printf("[INFO] make uploads store, type=%s", s.Image.Uploads.Type)
+	switch s.Image.Uploads.Type {
+	case "none":
+		if s.Image.Uploads.UserQuota > 0 || s.Image.Uploads.SiteQuota > 0 || s.Image.Uploads.DailyLimit > 0 {
+			return nil, errors.New("upload limits require uploads tracking, set image.uploads.type")
+		}
+		return nil, nil
+	case "mem":
+		return image.NewMemoryUploads(), nil
+	case "bolt":
+		if err := makeDirs(path.Dir(s.Image.Uploads.Bolt.File)); err != nil {
+			return nil, err
+		}
+		return image.NewBoltUploads(s.Image.Uploads.Bolt.File, bolt.Options{})
+	}
+	return nil, errors.Errorf("unsupported uploads store type %s", s.Image.Uploads.Type)
+}
+
+// makePubSub creates hub for live updates, returns nil if live updates disabled and streams should use polling
+func (s *ServerCommand) makePubSub() (*pubsub.Hub, error) {
+	log.Printf("[INFO] make pubsub, type=%s", s.PubSub.Type)
+
+	switch s.PubSub.Type {
+	case "local":
+		return pubsub.NewHub(nil), nil
+	case "redis":
+		client, err := makeRedisClient(s.PubSub.Redis.RedisGroup)
+		if err != nil {
+			return nil, err
+		}
+		return pubsub.NewHub(pubsub.NewRedisTransport(client, s.PubSub.Redis.Channel)), nil
+	case "none", "":
+		return nil, nil
+	}
+	return nil, errors.Errorf("unsupported pubsub type %s", s.PubSub.Type)
+}
+
+// makeRateLimiter creates limiter with counters store and rules from command line and rules file
+func (s *ServerCommand) makeRateLimiter() (*ratelimit.Limiter, error) {
+	log.Printf("[INFO] make rate limiter, type=%s", s.RateLimit.Type)
+
+	res := &ratelimit.Limiter{Secret: s.SharedSecret}
+	switch s.RateLimit.Type {
+	case "mem", "":
+		res.Store = ratelimit.NewMemoryStore()
+	case "redis":
+		client, err := makeRedisClient(s.RateLimit.Redis)
+		if err != nil {
+			return nil, err
+		}
+		res.Store = ratelimit.NewRedisStore(client)
+	default:
+		return nil, errors.Errorf("unsupported rate limit type %s", s.RateLimit.Type)
+	}
+
+	rules := append([]string{}, s.RateLimit.Rules...)
+	if s.RateLimit.File != "" {
+		data, err := ioutil.ReadFile(s.RateLimit.File)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read rate limit rules from %s", s.RateLimit.File)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				rules = append(rules, line)
+			}
+		}
+	}
+	for _, r := range rules {
+		rule, err := ratelimit.ParseRule(r)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[DEBUG] rate limit rule %s", rule)
+		res.Rules = append(res.Rules, rule)
+	}
+	return res, nil
+}
+
+// makeFloodControl creates comments posting policies, returns nil if no limits set
+func (s *ServerCommand) makeFloodControl() (*service.FloodControl, error) {
+	res := &service.FloodControl{Sites: map[string]service.FloodPolicy{}}
+	res.Default = service.FloodPolicy{SlowMode: s.Flood.SlowMode, DailyQuota: s.Flood.DailyQuota,
+		LinksMinAge: s.Flood.LinksMinAge, MaxLinks: s.Flood.MaxLinks, Duplicates: s.Flood.Duplicates}
+
+	for _, site := range s.Flood.Sites {
+		siteID, policy, err := parseFloodPolicy(site, res.Default)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[DEBUG] flood policy for %s: %+v", siteID, policy)
+		res.Sites[siteID] = policy
+	}
+
+	if res.Default == (service.FloodPolicy{}) && len(res.Sites) == 0 {
+		return nil, nil
+	}
+	log.Printf("[INFO] flood policy %+v", res.Default)
+	return res, nil
+}
+
+// parseFloodPolicy parses per-site policy in "site:key=value,key=value" form, missing keys inherited from base
+func parseFloodPolicy(inp string, base service.FloodPolicy) (siteID string, res service.FloodPolicy, err error) {
+	elems := strings.SplitN(inp, ":", 2)
+	if len(elems) != 2 || elems[0] == "" {
+		return "", res, errors.Errorf("invalid flood policy %q, should be site:key=value,key=value", inp)
+	}
+	siteID, res = strings.TrimSpace(elems[0]), base
+	for _, kv := range strings.Split(elems[1], ",") {
+		pair := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(pair) != 2 {
+			return "", res, errors.Errorf("invalid flood policy %q, bad element %q", inp, kv)
+		}
+		switch pair[0] {
+		case "slow-mode":
+			res.SlowMode, err = time.ParseDuration(pair[1])
+		case "daily-quota":
+			res.DailyQuota, err = strconv.Atoi(pair[1])
+		case "links-min-age":
+			res.LinksMinAge, err = time.ParseDuration(pair[1])
+		case "max-links":
+			res.MaxLinks, err = strconv.Atoi(pair[1])
+		case "duplicates":
+			res.Duplicates, err = time.ParseDuration(pair[1])
+		default:
+			return "", res, errors.Errorf("invalid flood policy %q, unknown key %q", inp, pair[0])
+		}
+		if err != nil {
+			return "", res, errors.Wrapf(err, "invalid flood policy %q", inp)
+		}
+	}
+	return siteID, res, nil
+}
+
+// makeURLNormalizer creates normalizer of post urls, returns nil if no rules set
+func (s *ServerCommand) makeURLNormalizer() (*service.URLNormalizer, error) {
+	res := &service.URLNormalizer{Sites: map[string]service.URLPolicy{}}
+	res.Default = service.URLPolicy{Query: s.URLs.Query, AMP: s.URLs.AMP}
+	rules := [][2]string{{"scheme", s.URLs.Scheme}, {"slash", s.URLs.Slash}}
+	for _, h := range s.URLs.Hosts {
+		rules = append(rules, [2]string{"host", h})
+	}
+	for _, r := range rules {
+		if err := setURLRule(&res.Default, r[0], r[1]); err != nil {
+			return nil, errors.Wrap(err, "invalid url rules")
+		}
+	}
+
+	for _, site := range s.URLs.Sites {
+		siteID, policy, err := parseURLPolicy(site, res.Default)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[DEBUG] url normalization for %s: %+v", siteID, policy)
+		res.Sites[siteID] = policy
+	}
+
+	if res.Default.Empty() && len(res.Sites) == 0 {
+		return nil, nil
+	}
+	log.Printf("[INFO] url normalization %+v", res.Default)
+	return res, nil
+}
+
+// parseURLPolicy parses per-site url normalization rules in "site:key=value,key=value" form, missing keys inherited from base
+func parseURLPolicy(inp string, base service.URLPolicy) (siteID string, res service.URLPolicy, err error) {
+	elems := strings.SplitN(inp, ":", 2)
+	if len(elems) != 2 || elems[0] == "" {
+		return "", res, errors.Errorf("invalid url rules %q, should be site:key=value,key=value", inp)
+	}
+	siteID, res = strings.TrimSpace(elems[0]), base
+	if base.Hosts != nil { // copy, to keep base hosts unchanged by site's rules
+		res.Hosts = make(map[string]string, len(base.Hosts))
+		for k, v := range base.Hosts {
+			res.Hosts[k] = v
+		}
+	}
+	for _, kv := range strings.Split(elems[1], ",") {
+		pair := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(pair) != 2 {
+			return "", res, errors.Errorf("invalid url rules %q, bad element %q", inp, kv)
+		}
+		if err = setURLRule(&res, pair[0], pair[1]); err != nil {
+			return "", res, errors.Wrapf(err, "invalid url rules %q", inp)
+		}
+	}
+	return siteID, res, nil
+}
+
+// setURLRule sets single rule of url normalization policy. Host rule is alias>canonical and can be repeated,
+// query rule is list of allowed params separated by |, empty query drops all params
+func setURLRule(p *service.URLPolicy, key, value string) (err error) {
+	switch key {
+	case "scheme":
+		if value != "" && value != "http" && value != "https" {
+			return errors.Errorf("unknown scheme %q", value)
+		}
+		p.Scheme = value
+	case "host":
+		hosts := strings.SplitN(value, ">", 2)
+		if len(hosts) != 2 || hosts[0] == "" || hosts[1] == "" {
+			return errors.Errorf("host %q should be alias>canonical", value)
+		}
+		if p.Hosts == nil {
+			p.Hosts = map[string]string{}
+		}
+		p.Hosts[strings.ToLower(hosts[0])] = strings.ToLower(hosts[1])
+	case "slash":
+		if value != "" && value != "add" && value != "strip" {
+			return errors.Errorf("unknown slash mode %q", value)
+		}
+		p.Slash = value
+	case "query":
+		p.Query = strings.Split(value, "|")
+	case "amp":
+		p.AMP, err = strconv.ParseBool(value)
+	default:
+		return errors.Errorf("unknown key %q", key)
+	}
+	return err
+}
+
+// makeChangeLog creates change log service, returns nil if change log disabled
+func (s *ServerCommand) makeChangeLog() (*changelog.Service, error) {
+	log.Printf("[INFO] make change log, type=%s", s.Changes.Type)
+
+	switch s.Changes.Type {
+	case "bolt":
+		if err := makeDirs(path.Dir(s.Changes.Bolt.File)); err != nil {
+			return nil, err
+		}
+		boltStore, err := changelog.NewBoltStorage(s.Changes.Bolt.File, bolt.Options{Timeout: s.Store.Bolt.Timeout})
+		if err != nil {
+			return nil, err
+		}
+		return changelog.NewService(boltStore, s.Changes.Retention), nil
+	case "mem":
+		return changelog.NewService(changelog.NewMemoryStorage(), s.Changes.Retention), nil
+	case "none", "":
+		return nil, nil
+	}
+	return nil, errors.Errorf("unsupported change log type %s", s.Changes.Type)
+}
+
+// makeJobs creates service running background jobs, with history in bolt or in memory
+func (s *ServerCommand) makeJobs() (*jobs.Service, error) {
+	log.Printf("[INFO] make jobs service, type=%s", s.Jobs.Type)
+
+	switch s.Jobs.Type {
+	case "bolt":
+		if err := makeDirs(path.Dir(s.Jobs.Bolt.File)); err != nil {
+			return nil, err
+		}
+		boltStore, err := jobs.NewBoltStorage(s.Jobs.Bolt.File, bolt.Options{Timeout: s.Store.Bolt.Timeout})
+		if err != nil {
+			return nil, err
+		}
+		return jobs.NewService(boltStore, s.Jobs.Keep), nil
+	case "mem":
+		return jobs.NewService(jobs.NewMemoryStorage(), s.Jobs.Keep), nil
+	}
+	return nil, errors.Errorf("unsupported jobs type %s", s.Jobs.Type)
+}
+
+// makeBackupTargets makes targets for copies of automatic backups, in addition to backup location
+func (s *ServerCommand) makeBackupTargets() ([]migrator.Target, error) {
+	res := []migrator.Target{}
+	for _, t := range s.Target.Type {
+		switch t {
+		case "dir":
+			if s.Target.Dir == "" {
+				return nil, errors.New("backup directory not set")
+			}
+			res = append(res, &migrator.LocalTarget{Location: s.Target.Dir})
+		case "s3":
+			if s.Target.S3.Bucket == "" {
+				return nil, errors.New("s3 bucket not set")
+			}
+			res = append(res, &migrator.S3Target{
+				Endpoint:  s.Target.S3.Endpoint,
+				Region:    s.Target.S3.Region,
+				Bucket:    s.Target.S3.Bucket,
+				AccessKey: s.Target.S3.AccessKey,
+				SecretKey: s.Target.S3.SecretKey,
+				Prefix:    s.Target.S3.Prefix,
+				Client:    http.Client{Timeout: s.Target.Timeout},
+			})
+		case "sftp":
+			if s.Target.SFTP.Addr == "" {
+				return nil, errors.New("sftp server not set")
+			}
+			res = append(res, &migrator.SFTPTarget{
+				Addr:    s.Target.SFTP.Addr,
+				Port:    s.Target.SFTP.Port,
+				Path:    s.Target.SFTP.Path,
+				KeyFile: s.Target.SFTP.Key,
+				Timeout: s.Target.Timeout,
+			})
+		default:
+			return nil, errors.Errorf("unsupported backup target %s", t)
+		}
+	}
+	log.Printf("[INFO] make backup targets %v", res)
+	return res, nil
+}
+
 func (s *ServerCommand) makeAdminStore() (admin.Store, error) {
 	log.Printf("[INFO] make admin store, type=%s", s.Admin.Type)
 
@@ -700,6 +1298,18 @@ func (s *ServerCommand) makeCache() (LoadingCache, error) {
 			return nil, errors.Wrap(err, "cache backend initialization")
 		}
 		return cache.NewScache(backend), nil
+	case "redis":
+		// cache shared by all instances, so flush made by any instance affects everyone
+		client, err := makeRedisClient(s.Cache.Redis)
+		if err != nil {
+			return nil, err
+		}
+		backend, err := cache.NewRedisCache(client, cache.TTL(s.Cache.TTL), cache.MaxValSize(s.Cache.Max.Value),
+			cache.MaxKeys(s.Cache.Max.Items))
+		if err != nil {
+			return nil, errors.Wrap(err, "cache backend initialization")
+		}
+		return cache.NewScache(&redisCache{RedisCache: backend}), nil
 	case "none":
 		return cache.NewScache(&cache.Nop{}), nil
 	}
@@ -991,6 +1601,46 @@ func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Sto
 	return authenticator
 }
 
+// makeRedisClient makes redis client and checks connection
+func makeRedisClient(r RedisGroup) (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{Addr: r.Addr, Password: r.Password, DB: r.DB})
+	if err := client.Ping().Err(); err != nil {
+		_ = client.Close()
+		return nil, errors.Wrapf(err, "can't connect to redis %s", r.Addr)
+	}
+	return client, nil
+}
+
+// redisCache adopts lcw.RedisCache to Scache. Redis returns cached values as strings and Scache expects []byte.
+// Redis errors don't fail the request, value loaded directly in this case.
+type redisCache struct {
+	*cache.RedisCache
+}
+
+// Get gets value by key or load with fn if not found in cache
+func (r *redisCache) Get(key string, fn func() (cache.Value, error)) (cache.Value, error) {
+	loaded, fnErr := false, error(nil)
+	v, err := r.RedisCache.Get(key, func() (cache.Value, error) {
+		loaded = true
+		val, e := fn()
+		fnErr = e
+		return val, e
+	})
+
+	switch {
+	case err != nil && !loaded: // failed to read from redis
+		log.Printf("[WARN] can't get %s from redis cache, %v", key, err)
+		return fn()
+	case err != nil && fnErr == nil: // failed to write to redis
+		log.Printf("[WARN] can't put %s to redis cache, %v", key, err)
+		err = nil
+	}
+	if str, ok := v.(string); ok {
+		return []byte(str), err
+	}
+	return v, err
+}
+
 // authRefreshCache used by authenticator to minimize repeatable token refreshes
 type authRefreshCache struct {
 	*authcache.Cache
@@ -15,12 +15,20 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-pkgz/auth/token"
+	cache "github.com/go-pkgz/lcw"
 	"github.com/jessevdk/go-flags"
+	"github.com/pkg/errors"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/migrator"
+	"github.com/umputun/remark/backend/app/rest/ratelimit"
+	"github.com/umputun/remark/backend/app/store/image"
+	"github.com/umputun/remark/backend/app/store/service"
 )
 
 func TestServerApp(t *testing.T) {
@@ -193,7 +201,7 @@ func TestServerApp_WithSSL(t *testing.T) {
 	_, err := p.ParseArgs([]string{"--admin-passwd=password", "--port=" + strconv.Itoa(port), "--store.bolt.path=/tmp/xyz", "--backup=/tmp",
 		"--avatar.type=bolt", "--avatar.bolt.file=/tmp/ava-test.db", "--notify.type=none",
 		"--ssl.type=static", "--ssl.cert=testdata/cert.pem", "--ssl.key=testdata/key.pem",
-		"--ssl.port=" + strconv.Itoa(sslPort), "--image.fs.path=/tmp"})
+		"--ssl.port=" + strconv.Itoa(sslPort), "--image.fs.path=/tmp", "--jobs.type=mem"})
 	require.NoError(t, err)
 
 	// create app
@@ -246,7 +254,7 @@ func TestServerApp_WithRemote(t *testing.T) {
 	port := chooseRandomUnusedPort()
 	_, err := p.ParseArgs([]string{"--admin-passwd=password", "--cache.type=none",
 		"--store.type=rpc", "--store.rpc.api=http://127.0.0.1",
-		"--port=" + strconv.Itoa(port), "--admin.type=rpc", "--admin.rpc.api=http://127.0.0.1", "--avatar.fs.path=/tmp"})
+		"--port=" + strconv.Itoa(port), "--admin.type=rpc", "--admin.rpc.api=http://127.0.0.1", "--avatar.fs.path=/tmp", "--jobs.type=mem"})
 	require.NoError(t, err)
 	opts.Auth.Github.CSEC, opts.Auth.Github.CID = "csec", "cid"
 	opts.BackupLocation, opts.Image.FS.Path = "/tmp", "/tmp"
@@ -272,6 +280,385 @@ func TestServerApp_WithRemote(t *testing.T) {
 	app.Wait()
 }
 
+func TestServerApp_WithChanges(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.Changes.Type = "bolt"
+		o.Changes.Bolt.File = fmt.Sprintf("/tmp/%d/changes.db", port)
+		o.Changes.Retention = time.Hour
+		return o
+	})
+	defer os.Remove(fmt.Sprintf("/tmp/%d/changes.db", port))
+	require.NotNil(t, app.changeLog)
+
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+
+	client := http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://localhost:%d/api/v1/comment", port),
+		strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark"}}`))
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	req, err = http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/api/v1/admin/changes?site=remark", port), nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"kind":"create"`)
+
+	cancel()
+	app.Wait()
+}
+
+func TestServerApp_ImageProxyCache(t *testing.T) {
+	app, _, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = chooseRandomUnusedPort()
+		return o
+	})
+	defer cancel()
+	assert.Nil(t, app.restSrv.ImageProxy.Cache, "no cache without cache-external")
+	assert.Equal(t, int64(5000000), app.restSrv.ImageProxy.Fetcher.MaxSize)
+	assert.False(t, app.restSrv.ImageProxy.Fetcher.AllowPrivate)
+
+	app, _, cancel = prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = chooseRandomUnusedPort()
+		o.ImageProxy.CacheExternal = true
+		o.ImageProxy.CacheMaxItems = 100
+		return o
+	})
+	defer cancel()
+	require.NotNil(t, app.restSrv.ImageProxy.Cache)
+	assert.Equal(t, 720*time.Hour, app.restSrv.ImageProxy.Cache.TTL)
+	assert.Equal(t, int64(1000000000), app.restSrv.ImageProxy.Cache.MaxSize)
+	assert.Equal(t, 100, app.restSrv.ImageProxy.Cache.MaxItems)
+}
+
+func TestServerApp_MakeChangeLog(t *testing.T) {
+	opts := ServerCommand{}
+	opts.Changes.Type = "none"
+	res, err := opts.makeChangeLog()
+	require.NoError(t, err)
+	assert.Nil(t, res)
+
+	opts.Changes.Type = "mem"
+	res, err = opts.makeChangeLog()
+	require.NoError(t, err)
+	assert.NotNil(t, res)
+
+	opts.Changes.Type = "bad"
+	_, err = opts.makeChangeLog()
+	assert.EqualError(t, err, "unsupported change log type bad")
+}
+
+func TestServerApp_MakeJobs(t *testing.T) {
+	opts := ServerCommand{}
+	opts.Jobs.Type = "mem"
+	res, err := opts.makeJobs()
+	require.NoError(t, err)
+	assert.NotNil(t, res)
+
+	opts.Jobs.Type = "bolt"
+	opts.Jobs.Bolt.File = "/tmp/remark42_test_jobs/jobs.db"
+	opts.Jobs.Keep = 10
+	defer os.RemoveAll("/tmp/remark42_test_jobs")
+	res, err = opts.makeJobs()
+	require.NoError(t, err)
+	assert.Equal(t, 10, res.Keep)
+	assert.NoError(t, res.Close())
+
+	opts.Jobs.Type = "bad"
+	_, err = opts.makeJobs()
+	assert.EqualError(t, err, "unsupported jobs type bad")
+}
+
+func TestServerApp_MakeBackupTargets(t *testing.T) {
+	opts := ServerCommand{}
+	res, err := opts.makeBackupTargets()
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res))
+
+	opts.Target.Type = []string{"dir", "s3", "sftp"}
+	opts.Target.Dir = "/mnt/backup"
+	opts.Target.S3.Bucket = "remark"
+	opts.Target.S3.Prefix = "backup"
+	opts.Target.SFTP.Addr = "user@example.com"
+	opts.Target.SFTP.Path = "/srv/backup"
+	opts.Target.Timeout = time.Minute
+	res, err = opts.makeBackupTargets()
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res))
+	assert.Equal(t, "dir:/mnt/backup", res[0].String())
+	assert.Equal(t, "s3:remark/backup/", res[1].String())
+	assert.Equal(t, "sftp:user@example.com:/srv/backup", res[2].String())
+	assert.Equal(t, time.Minute, res[2].(*migrator.SFTPTarget).Timeout)
+
+	opts.Target.S3.Bucket = ""
+	_, err = opts.makeBackupTargets()
+	assert.EqualError(t, err, "s3 bucket not set")
+}
+
+func TestServerApp_MakePubSub(t *testing.T) {
+	opts := ServerCommand{}
+	opts.PubSub.Type = "none"
+	res, err := opts.makePubSub()
+	require.NoError(t, err)
+	assert.Nil(t, res)
+
+	opts.PubSub.Type = "local"
+	res, err = opts.makePubSub()
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.Nil(t, res.Transport)
+
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+	opts.PubSub.Type = "redis"
+	opts.PubSub.Redis.Addr = srv.Addr()
+	opts.PubSub.Redis.Channel = "events"
+	res, err = opts.makePubSub()
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.NotNil(t, res.Transport)
+	assert.NoError(t, res.Close())
+
+	opts.PubSub.Type = "bad"
+	_, err = opts.makePubSub()
+	assert.EqualError(t, err, "unsupported pubsub type bad")
+}
+
+func TestServerApp_MakeCacheRedis(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	opts := ServerCommand{}
+	p := flags.NewParser(&opts, flags.Default)
+	_, err = p.ParseArgs([]string{"--cache.type=redis", "--cache.redis.addr=" + srv.Addr(), "--cache.ttl=1m"})
+	require.NoError(t, err)
+
+	// two instances sharing the same redis
+	c1, err := opts.makeCache()
+	require.NoError(t, err)
+	c2, err := opts.makeCache()
+	require.NoError(t, err)
+
+	loads := 0
+	loadFn := func(val string) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			loads++
+			return []byte(val), nil
+		}
+	}
+	key1 := cache.NewKey("site").ID("key1").Scopes("site", "https://example.com/1")
+	key2 := cache.NewKey("site").ID("key2").Scopes("site", "https://example.com/2")
+
+	res, err := c1.Get(key1, loadFn("val1"))
+	require.NoError(t, err)
+	assert.Equal(t, "val1", string(res))
+	res, err = c2.Get(key1, loadFn("val1-other"))
+	require.NoError(t, err)
+	assert.Equal(t, "val1", string(res), "cached by another instance")
+	_, err = c1.Get(key2, loadFn("val2"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, loads)
+	assert.Equal(t, 1*time.Minute, srv.TTL(key1.String()))
+
+	c2.Flush(cache.Flusher("site").Scopes("https://example.com/1"))
+	res, err = c1.Get(key1, loadFn("val1-new"))
+	require.NoError(t, err)
+	assert.Equal(t, "val1-new", string(res), "flushed by another instance")
+	res, err = c1.Get(key2, loadFn("val2-new"))
+	require.NoError(t, err)
+	assert.Equal(t, "val2", string(res), "out of flushed scope")
+	assert.Equal(t, 3, loads)
+
+	_, err = c1.Get(key1, func() ([]byte, error) { return nil, errors.New("failed") })
+	assert.NoError(t, err, "cached, not loaded")
+	_, err = c1.Get(cache.NewKey("site").ID("key3"), func() ([]byte, error) { return nil, errors.New("failed") })
+	assert.EqualError(t, err, "failed")
+
+	srv.SetError("redis failed")
+	res, err = c1.Get(key1, loadFn("val1-direct"))
+	require.NoError(t, err, "loaded directly on redis error")
+	assert.Equal(t, "val1-direct", string(res))
+	srv.SetError("")
+
+	opts.Cache.Redis.Addr = "bad address"
+	_, err = opts.makeCache()
+	assert.Error(t, err)
+}
+
+func TestServerApp_PubSubRedisArgs(t *testing.T) {
+	opts := ServerCommand{}
+	p := flags.NewParser(&opts, flags.Default)
+	_, err := p.ParseArgs([]string{"--pubsub.type=redis", "--pubsub.redis.addr=127.0.0.1:1234", "--pubsub.redis.db=2",
+		"--pubsub.redis.channel=ch1"})
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:1234", opts.PubSub.Redis.Addr)
+	assert.Equal(t, 2, opts.PubSub.Redis.DB)
+	assert.Equal(t, "ch1", opts.PubSub.Redis.Channel)
+	assert.Equal(t, "localhost:6379", opts.Cache.Redis.Addr)
+}
+
+func TestServerApp_MakeRateLimiter(t *testing.T) {
+	rulesFile := os.TempDir() + "/remark42-ratelimit.txt"
+	defer os.Remove(rulesFile)
+	require.NoError(t, ioutil.WriteFile(rulesFile, []byte("# comments rate\nPOST /api/v1/comment 5/m user\n\n/api/v1/find 20/s\n"), 0600))
+
+	opts := ServerCommand{}
+	p := flags.NewParser(&opts, flags.Default)
+	_, err := p.ParseArgs([]string{"--ratelimit.rule=PUT /api/v1/comment/* 10/m user", "--ratelimit.file=" + rulesFile})
+	require.NoError(t, err)
+	assert.Equal(t, "mem", opts.RateLimit.Type)
+
+	res, err := opts.makeRateLimiter()
+	require.NoError(t, err)
+	assert.IsType(t, &ratelimit.Memory{}, res.Store)
+	require.Equal(t, 3, len(res.Rules))
+	assert.Equal(t, "PUT /api/v1/comment/* 10/1m0s user", res.Rules[0].String())
+	assert.Equal(t, "POST /api/v1/comment 5/1m0s user", res.Rules[1].String())
+	assert.Equal(t, "/api/v1/find 20/1s ip", res.Rules[2].String())
+
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+	opts = ServerCommand{}
+	_, err = p.ParseArgs([]string{"--ratelimit.type=redis", "--ratelimit.redis.addr=" + srv.Addr()})
+	require.NoError(t, err)
+	res, err = opts.makeRateLimiter()
+	require.NoError(t, err)
+	assert.IsType(t, &ratelimit.Redis{}, res.Store)
+	assert.Equal(t, 0, len(res.Rules))
+
+	opts.RateLimit.Type = "mem"
+	opts.RateLimit.Rules = []string{"POST /api/v1/comment 5"}
+	_, err = opts.makeRateLimiter()
+	assert.EqualError(t, err, `invalid rule "POST /api/v1/comment 5": invalid rate "5", should be limit/period`)
+
+	opts.RateLimit.Rules = nil
+	opts.RateLimit.File = "/tmp/no-such-file-remark42"
+	_, err = opts.makeRateLimiter()
+	assert.Error(t, err)
+}
+
+func TestServerApp_MakeFloodControl(t *testing.T) {
+	opts := ServerCommand{}
+	res, err := opts.makeFloodControl()
+	require.NoError(t, err)
+	assert.Nil(t, res, "no limits set")
+
+	p := flags.NewParser(&opts, flags.Default)
+	_, err = p.ParseArgs([]string{"--flood.slow-mode=30s", "--flood.daily-quota=100",
+		"--flood.site=site1:daily-quota=10,max-links=2", "--flood.site=site2:slow-mode=0s,duplicates=1h,links-min-age=24h"})
+	require.NoError(t, err)
+	res, err = opts.makeFloodControl()
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, service.FloodPolicy{SlowMode: 30 * time.Second, DailyQuota: 100}, res.Policy("remark"))
+	assert.Equal(t, service.FloodPolicy{SlowMode: 30 * time.Second, DailyQuota: 10, MaxLinks: 2}, res.Policy("site1"))
+	assert.Equal(t, service.FloodPolicy{DailyQuota: 100, Duplicates: time.Hour, LinksMinAge: 24 * time.Hour}, res.Policy("site2"))
+
+	for _, bad := range []string{"site1", ":max-links=1", "site1:max-links", "site1:max-links=abc", "site1:blah=1"} {
+		opts.Flood.Sites = []string{bad}
+		_, err = opts.makeFloodControl()
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestServerApp_MakeURLNormalizer(t *testing.T) {
+	opts := ServerCommand{}
+	res, err := opts.makeURLNormalizer()
+	require.NoError(t, err)
+	assert.Nil(t, res, "no rules set")
+
+	p := flags.NewParser(&opts, flags.Default)
+	_, err = p.ParseArgs([]string{"--urls.scheme=https", "--urls.host=www.example.com>example.com", "--urls.slash=strip",
+		"--urls.site=site1:host=AMP.example.com>example.com,query=id|page,amp=true", "--urls.site=site2:scheme=,query="})
+	require.NoError(t, err)
+	res, err = opts.makeURLNormalizer()
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, service.URLPolicy{Scheme: "https", Slash: "strip", Hosts: map[string]string{"www.example.com": "example.com"}},
+		res.Policy("remark"))
+	assert.Equal(t, service.URLPolicy{Scheme: "https", Slash: "strip", Query: []string{"id", "page"}, AMP: true,
+		Hosts: map[string]string{"www.example.com": "example.com", "amp.example.com": "example.com"}}, res.Policy("site1"))
+	assert.Equal(t, service.URLPolicy{Slash: "strip", Query: []string{""}, Hosts: map[string]string{"www.example.com": "example.com"}},
+		res.Policy("site2"))
+	assert.Equal(t, "https://example.com/p/1?id=1", res.URL("site1", "http://amp.example.com/p/1/amp/?id=1&utm_source=x"))
+
+	for _, bad := range []string{"site1", ":slash=add", "site1:slash", "site1:slash=both", "site1:scheme=ftp",
+		"site1:host=example.com", "site1:amp=abc", "site1:blah=1"} {
+		opts.URLs.Sites = []string{bad}
+		_, err = opts.makeURLNormalizer()
+		assert.Error(t, err, bad)
+	}
+	opts.URLs.Sites, opts.URLs.Hosts = nil, []string{"bad-host"}
+	_, err = opts.makeURLNormalizer()
+	assert.Error(t, err)
+}
+
+func TestServerApp_MakePicturesStoreS3(t *testing.T) {
+	opts := ServerCommand{}
+	p := flags.NewParser(&opts, flags.Default)
+	_, err := p.ParseArgs([]string{"--image.type=s3", "--image.s3.endpoint=http://127.0.0.1:9000", "--image.s3.bucket=pics",
+		"--image.s3.access-key=key", "--image.s3.secret-key=secret", "--image.s3.signed-url-ttl=10m"})
+	require.NoError(t, err)
+	assert.Equal(t, "us-east-1", opts.Image.S3.Region)
+	assert.Equal(t, "remark42", opts.Image.S3.Prefix)
+
+	svc, err := opts.makePicturesStore()
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, svc.SignedURLTTL)
+	assert.Equal(t, 720*time.Hour, svc.GCGrace, "default gc grace")
+
+	opts.Image.S3.Bucket = ""
+	_, err = opts.makePicturesStore()
+	assert.EqualError(t, err, "s3 bucket not set")
+}
+
+func TestServerApp_MakeUploadsStore(t *testing.T) {
+	opts := ServerCommand{}
+	p := flags.NewParser(&opts, flags.Default)
+	_, err := p.ParseArgs([]string{"--image.type=fs", "--image.fs.path=/tmp/remark42_test_uploads/pics",
+		"--image.uploads.daily-limit=10"})
+	require.NoError(t, err)
+	assert.Equal(t, "none", opts.Image.Uploads.Type)
+	_, err = opts.makePicturesStore()
+	assert.EqualError(t, err, "failed to make uploads store: upload limits require uploads tracking, set image.uploads.type")
+
+	defer os.RemoveAll("/tmp/remark42_test_uploads")
+	opts.Image.Uploads.Type = "bolt"
+	opts.Image.Uploads.Bolt.File = "/tmp/remark42_test_uploads/uploads.db"
+	opts.Image.Uploads.UserQuota = 1000
+	svc, err := opts.makePicturesStore()
+	require.NoError(t, err)
+	assert.IsType(t, &image.BoltUploads{}, svc.Uploads)
+	assert.Equal(t, image.UploadLimits{UserSize: 1000, DailyCount: 10}, svc.UploadLimits)
+	svc.Close(context.Background())
+
+	opts.Image.Uploads.Type = "mem"
+	svc, err = opts.makePicturesStore()
+	require.NoError(t, err)
+	assert.IsType(t, &image.MemoryUploads{}, svc.Uploads)
+
+	opts.Image.Uploads = ImageGroup{}.Uploads
+	opts.Image.Uploads.Type = "none"
+	svc, err = opts.makePicturesStore()
+	require.NoError(t, err)
+	assert.Nil(t, svc.Uploads)
+}
+
 func TestServerApp_Failed(t *testing.T) {
 	opts := ServerCommand{}
 	opts.SetCommon(CommonOpts{RemarkURL: "https://demo.remark42.com", SharedSecret: "123456"})
@@ -348,7 +735,7 @@ func TestServerApp_MainSignal(t *testing.T) {
 	p := flags.NewParser(&s, flags.Default)
 	port := chooseRandomUnusedPort()
 	args := []string{"test", "--store.bolt.path=/tmp/xyz", "--backup=/tmp", "--avatar.type=bolt",
-		"--avatar.bolt.file=/tmp/ava-test.db", "--port=" + strconv.Itoa(port), "--notify.type=none", "--image.fs.path=/tmp"}
+		"--avatar.bolt.file=/tmp/ava-test.db", "--port=" + strconv.Itoa(port), "--notify.type=none", "--image.fs.path=/tmp", "--jobs.type=mem"}
 	defer os.Remove("/tmp/ava-test.db")
 	_, err := p.ParseArgs(args)
 	require.NoError(t, err)
@@ -639,6 +1026,7 @@ func prepServerApp(t *testing.T, fn func(o ServerCommand) ServerCommand) (*serve
 	require.NoError(t, err)
 	cmd.Avatar.FS.Path, cmd.Avatar.Type, cmd.BackupLocation, cmd.Image.FS.Path = "/tmp", "fs", "/tmp", "/tmp"
 	cmd.Store.Bolt.Path = fmt.Sprintf("/tmp/%d", cmd.Port)
+	cmd.Jobs.Type = "mem"
 	cmd.Store.Bolt.Timeout = 10 * time.Second
 	cmd.Auth.Github.CSEC, cmd.Auth.Github.CID = "csec", "cid"
 	cmd.Auth.Google.CSEC, cmd.Auth.Google.CID = "csec", "cid"
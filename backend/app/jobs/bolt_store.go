@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt implements Store keeping jobs in bolt DB. Each site has its own bucket with job id as a key
+type Bolt struct {
+	db *bolt.DB
+}
+
+// NewBoltStorage makes bolt jobs store
+func NewBoltStorage(fileName string, options bolt.Options) (*Bolt, error) {
+	db, err := bolt.Open(fileName, 0600, &options)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	return &Bolt{db: db}, nil
+}
+
+// Save job to site's bucket, replaces stored job with the same id
+func (b *Bolt) Save(job Job) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists([]byte(job.SiteID))
+		if err != nil {
+			return errors.Wrapf(err, "can't make bucket for %s", job.SiteID)
+		}
+		data, err := json.Marshal(job)
+		if err != nil {
+			return errors.Wrapf(err, "can't marshal job %s", job.ID)
+		}
+		return errors.Wrapf(bkt.Put([]byte(job.ID), data), "can't put job %s", job.ID)
+	})
+}
+
+// Get job by id
+func (b *Bolt) Get(siteID, id string) (job Job, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(siteID))
+		if bkt == nil {
+			return ErrNotFound
+		}
+		data := bkt.Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return errors.Wrapf(json.Unmarshal(data, &job), "can't unmarshal job %s", id)
+	})
+	return job, err
+}
+
+// List returns all jobs of the site, the latest first
+func (b *Bolt) List(siteID string) ([]Job, error) {
+	res := []Job{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(siteID))
+		if bkt == nil {
+			return nil // no jobs for the site yet
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			job := Job{}
+			if err := json.Unmarshal(v, &job); err != nil {
+				return errors.Wrapf(err, "can't unmarshal job %s", string(k))
+			}
+			res = append(res, job)
+			return nil
+		})
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].Started.After(res[j].Started) })
+	return res, err
+}
+
+// Delete jobs by ids
+func (b *Bolt) Delete(siteID string, ids ...string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(siteID))
+		if bkt == nil {
+			return nil
+		}
+		for _, id := range ids {
+			if err := bkt.Delete([]byte(id)); err != nil {
+				return errors.Wrapf(err, "can't delete job %s", id)
+			}
+		}
+		return nil
+	})
+}
+
+// Close bolt store
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,63 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBolt_SaveGetList(t *testing.T) {
+	s, teardown := prepBoltStore(t)
+	defer teardown()
+	checkSaveGetList(t, s)
+}
+
+func TestBolt_Delete(t *testing.T) {
+	s, teardown := prepBoltStore(t)
+	defer teardown()
+	checkDelete(t, s)
+}
+
+func TestBolt_Reopen(t *testing.T) {
+	loc, err := ioutil.TempDir("", "test_jobs_r42")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+	dbFile := path.Join(loc, "jobs.db")
+
+	s, err := NewBoltStorage(dbFile, bolt.Options{})
+	require.NoError(t, err)
+	require.NoError(t, s.Save(Job{ID: "j1", SiteID: "site1", Kind: KindRemap, Status: StatusCompleted,
+		Done: 10, Total: 20, Started: time.Now()}))
+	require.NoError(t, s.Close())
+
+	s, err = NewBoltStorage(dbFile, bolt.Options{})
+	require.NoError(t, err)
+	defer s.Close()
+	job, err := s.Get("site1", "j1")
+	require.NoError(t, err)
+	assert.Equal(t, KindRemap, job.Kind)
+	assert.Equal(t, int64(10), job.Done)
+	assert.Equal(t, int64(20), job.Total)
+}
+
+func TestBolt_NewFailed(t *testing.T) {
+	_, err := NewBoltStorage("/dev/null/bad/jobs.db", bolt.Options{})
+	assert.Error(t, err)
+}
+
+func prepBoltStore(t *testing.T) (s *Bolt, teardown func()) {
+	loc, err := ioutil.TempDir("", "test_jobs_r42")
+	require.NoError(t, err)
+	s, err = NewBoltStorage(path.Join(loc, "jobs.db"), bolt.Options{})
+	require.NoError(t, err)
+	return s, func() {
+		assert.NoError(t, s.Close())
+		assert.NoError(t, os.RemoveAll(loc))
+	}
+}
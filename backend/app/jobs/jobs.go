@@ -0,0 +1,435 @@
+// Package jobs runs long operations, like import, export, remap, cleanup and backup, in background and keeps
+// their status, progress counters and logs. Each job gets unique id and can be canceled while running.
+// Provides Store with bolt and in-memory implementations keeping jobs history. Service object encloses Store
+// and runs the jobs, this is the one consumer should use.
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Kind defines type of the job
+type Kind string
+
+// enum of all job kinds
+const (
+	KindImport  Kind = "import"  // import from native or other system's format, rollback of remap
+	KindExport  Kind = "export"  // export of site's comments
+	KindRemap   Kind = "remap"   // change of comments urls, by rules or url normalization
+	KindCleanup Kind = "cleanup" // removal of unused data, i.e. images gc
+	KindBackup  Kind = "backup"  // automatic backup
+)
+
+// exclusive returns true for jobs changing site's data, only one of them can run for the site at a time
+func (k Kind) exclusive() bool {
+	return k == KindImport || k == KindRemap || k == KindCleanup
+}
+
+// Status of the job
+type Status string
+
+// enum of all job statuses
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// Job describes single background operation
+type Job struct {
+	ID       string    `json:"id"`
+	SiteID   string    `json:"site_id"`
+	Kind     Kind      `json:"kind"`
+	Status   Status    `json:"status"`
+	Done     int64     `json:"done"`            // processed units, bytes for import and export
+	Total    int64     `json:"total,omitempty"` // total units, 0 if unknown
+	Error    string    `json:"error,omitempty"`
+	Logs     []string  `json:"logs,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+// Running returns true if job not finished yet
+func (j Job) Running() bool { return j.Status == StatusRunning }
+
+// Store defines interface for jobs storage
+type Store interface {
+	Save(job Job) error                        // add or replace job
+	Get(siteID, id string) (Job, error)        // get job by id, ErrNotFound if no such job
+	List(siteID string) ([]Job, error)         // all jobs of the site, the latest first
+	Delete(siteID string, ids ...string) error // remove jobs by ids
+	Close() error
+}
+
+// ErrNotFound returned for unknown job
+var ErrNotFound = errors.New("job not found")
+
+// ErrBusy returned on attempt to start exclusive job while another one running for the site
+var ErrBusy = errors.New("another job running for the site")
+
+const maxLogs = 100 // max log lines kept for the job, the latest ones
+
+// Service runs jobs and keeps them in Store. Status of running jobs kept in memory and saved to Store
+// on start, on each log line and on completion.
+type Service struct {
+	Store Store
+	Keep  int // finished jobs kept for each site, 0 keeps all
+
+	lock     sync.Mutex
+	saveLock sync.Mutex       // keeps saves of the running job in order, final save the last
+	active   map[string]*task // running jobs by id
+}
+
+type task struct {
+	job    Job
+	cancel context.CancelFunc
+	done   chan struct{} // closed on completion
+}
+
+// NewService makes jobs service for given store
+func NewService(s Store, keep int) *Service {
+	return &Service{Store: s, Keep: keep, active: map[string]*task{}}
+}
+
+// Start runs fn in background as a job of given kind for the site and returns the job as started.
+// Import, remap and cleanup jobs are exclusive, ErrBusy returned if one of them already running for the site.
+// Context passed to fn canceled on Cancel or on cancellation of ctx.
+func (s *Service) Start(ctx context.Context, siteID string, kind Kind, fn func(ctx context.Context, p *Progress) error) (Job, error) {
+	s.lock.Lock()
+	if s.active == nil {
+		s.active = map[string]*task{}
+	}
+	if kind.exclusive() && s.busy(siteID) {
+		s.lock.Unlock()
+		return Job{}, ErrBusy
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	t := &task{
+		job:    Job{ID: uuid.New().String(), SiteID: siteID, Kind: kind, Status: StatusRunning, Started: time.Now()},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	s.active[t.job.ID] = t
+	job := t.job
+	s.lock.Unlock()
+
+	if err := s.Store.Save(job); err != nil {
+		log.Printf("[WARN] can't save job %s, %v", job.ID, err)
+	}
+	log.Printf("[INFO] started %s job %s for %s", kind, job.ID, siteID)
+
+	go func() {
+		err := fn(jobCtx, &Progress{ctx: jobCtx, svc: s, id: job.ID})
+		s.finish(t, err, jobCtx.Err())
+		cancel()
+	}()
+	return job, nil
+}
+
+// Run starts the job and waits for its completion. Returns finished job and error of the job if failed
+func (s *Service) Run(ctx context.Context, siteID string, kind Kind, fn func(ctx context.Context, p *Progress) error) (Job, error) {
+	job, err := s.Start(ctx, siteID, kind, fn)
+	if err != nil {
+		return job, err
+	}
+	if job, err = s.Wait(context.Background(), siteID, job.ID); err != nil {
+		return job, err
+	}
+	if job.Status != StatusCompleted {
+		return job, errors.Errorf("%s job %s: %s", job.Kind, job.Status, job.Error)
+	}
+	return job, nil
+}
+
+// Get returns job by id, with current progress for running job
+func (s *Service) Get(siteID, id string) (Job, error) {
+	s.lock.Lock()
+	if t, ok := s.active[id]; ok && t.job.SiteID == siteID {
+		job := t.job.copy()
+		s.lock.Unlock()
+		return job, nil
+	}
+	s.lock.Unlock()
+
+	job, err := s.Store.Get(siteID, id)
+	if err != nil {
+		return Job{}, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stale(job), nil
+}
+
+// List returns up to limit jobs of the site, the latest first. 0 limit returns all
+func (s *Service) List(siteID string, limit int) ([]Job, error) {
+	jobs, err := s.Store.List(siteID)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, job := range jobs {
+		if t, ok := s.active[job.ID]; ok {
+			jobs[i] = t.job.copy()
+			continue
+		}
+		jobs[i] = s.stale(job)
+	}
+	return jobs, nil
+}
+
+// Cancel requests cancellation of running job. Job finishes with canceled status as soon as operation
+// notices the cancellation. Operations notice it only while reading input with Progress.Reader, writing
+// output with Progress.Writer or checking the context themselves. I.e. import runs to completion once
+// the input is read, and backup can't be canceled at all
+func (s *Service) Cancel(siteID, id string) (Job, error) {
+	s.lock.Lock()
+	t, ok := s.active[id]
+	if ok && t.job.SiteID == siteID {
+		job := t.job.copy()
+		s.lock.Unlock()
+		t.cancel()
+		log.Printf("[INFO] cancel %s job %s for %s", job.Kind, id, siteID)
+		return job, nil
+	}
+	s.lock.Unlock()
+
+	job, err := s.Get(siteID, id)
+	if err != nil {
+		return Job{}, err
+	}
+	return job, errors.Errorf("job %s not running, %s", id, job.Status)
+}
+
+// Wait blocks till the job finished or ctx done, returns the job as it was at the end of waiting
+func (s *Service) Wait(ctx context.Context, siteID, id string) (Job, error) {
+	s.lock.Lock()
+	t, ok := s.active[id]
+	s.lock.Unlock()
+	if ok {
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+		}
+	}
+	return s.Get(siteID, id)
+}
+
+// Busy returns true if exclusive job running for the site
+func (s *Service) Busy(siteID string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.busy(siteID)
+}
+
+// Close jobs store
+func (s *Service) Close() error {
+	return errors.Wrap(s.Store.Close(), "can't close jobs store")
+}
+
+// busy checks for running exclusive job of the site, lock should be held by caller
+func (s *Service) busy(siteID string) bool {
+	for _, t := range s.active {
+		if t.job.SiteID == siteID && t.job.Kind.exclusive() {
+			return true
+		}
+	}
+	return false
+}
+
+// finish sets final status of the job, saves it and removes old jobs of the site
+func (s *Service) finish(t *task, err, ctxErr error) {
+	s.saveLock.Lock()
+	s.lock.Lock()
+	t.job.Finished = time.Now()
+	switch {
+	case err == nil:
+		t.job.Status = StatusCompleted
+	case ctxErr != nil:
+		t.job.Status, t.job.Error = StatusCanceled, err.Error()
+	default:
+		t.job.Status, t.job.Error = StatusFailed, err.Error()
+	}
+	job := t.job.copy()
+	s.lock.Unlock()
+
+	if e := s.Store.Save(job); e != nil {
+		log.Printf("[WARN] can't save job %s, %v", job.ID, e)
+	}
+	s.lock.Lock()
+	delete(s.active, job.ID)
+	s.lock.Unlock()
+	s.saveLock.Unlock()
+	close(t.done)
+
+	if err != nil {
+		log.Printf("[WARN] %s job %s for %s %s, %v", job.Kind, job.ID, job.SiteID, job.Status, err)
+	} else {
+		log.Printf("[INFO] %s job %s for %s completed in %v", job.Kind, job.ID, job.SiteID, job.Finished.Sub(job.Started))
+	}
+	s.trim(job.SiteID)
+}
+
+// trim removes finished jobs of the site beyond Keep
+func (s *Service) trim(siteID string) {
+	if s.Keep <= 0 {
+		return
+	}
+	jobs, err := s.Store.List(siteID)
+	if err != nil {
+		log.Printf("[WARN] can't list jobs of %s, %v", siteID, err)
+		return
+	}
+	ids, kept := []string{}, 0
+	for _, job := range jobs {
+		if job.Running() {
+			continue
+		}
+		if kept++; kept > s.Keep {
+			ids = append(ids, job.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	if err := s.Store.Delete(siteID, ids...); err != nil {
+		log.Printf("[WARN] can't remove old jobs of %s, %v", siteID, err)
+	}
+}
+
+// stale reports job saved as running, but not active, as failed. Happens if server stopped in the middle of the job.
+// Lock should be held by caller
+func (s *Service) stale(job Job) Job {
+	if job.Running() {
+		if _, ok := s.active[job.ID]; !ok {
+			job.Status, job.Error = StatusFailed, "interrupted"
+		}
+	}
+	return job
+}
+
+// update changes running job with fn under lock, saves the job to store if save set
+func (s *Service) update(id string, save bool, fn func(job *Job)) {
+	if save {
+		s.saveLock.Lock()
+		defer s.saveLock.Unlock()
+	}
+	s.lock.Lock()
+	t, ok := s.active[id]
+	if !ok {
+		s.lock.Unlock()
+		return
+	}
+	fn(&t.job)
+	job := t.job.copy()
+	s.lock.Unlock()
+	if !save {
+		return
+	}
+	if err := s.Store.Save(job); err != nil {
+		log.Printf("[WARN] can't save job %s, %v", id, err)
+	}
+}
+
+func (j Job) copy() Job {
+	j.Logs = append([]string(nil), j.Logs...)
+	return j
+}
+
+// Progress passed to job's function to report progress and log messages
+type Progress struct {
+	ctx context.Context
+	svc *Service
+	id  string
+}
+
+// Add increments processed units counter
+func (p *Progress) Add(n int64) {
+	p.svc.update(p.id, false, func(job *Job) { job.Done += n })
+}
+
+// SetTotal sets total units to process
+func (p *Progress) SetTotal(n int64) {
+	p.svc.update(p.id, false, func(job *Job) { job.Total = n })
+}
+
+// Logf adds message to job's logs and saves the job
+func (p *Progress) Logf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("[INFO] job %s, %s", p.id, msg)
+	p.svc.update(p.id, true, func(job *Job) {
+		job.Logs = append(job.Logs, time.Now().Format("2006-01-02 15:04:05")+" "+msg)
+		if len(job.Logs) > maxLogs {
+			job.Logs = job.Logs[len(job.Logs)-maxLogs:]
+		}
+	})
+}
+
+// Reader wraps r counting read bytes as processed units. Read fails with context error
+// once the job canceled, so operation reading from it stops. For io.ReadSeeker returned reader is
+// io.ReadSeeker as well, with processed units set to the position on seek
+func (p *Progress) Reader(r io.Reader) io.Reader {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return &progressReadSeeker{progressReader: progressReader{r: r, p: p}, s: rs}
+	}
+	return &progressReader{r: r, p: p}
+}
+
+// Writer wraps w counting written bytes as processed units. Write fails with context error
+// once the job canceled
+func (p *Progress) Writer(w io.Writer) io.Writer {
+	return &progressWriter{w: w, p: p}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *Progress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	if err := pr.p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := pr.r.Read(b)
+	pr.p.Add(int64(n))
+	return n, err
+}
+
+type progressReadSeeker struct {
+	progressReader
+	s io.Seeker
+}
+
+func (prs *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := prs.s.Seek(offset, whence)
+	if err == nil {
+		prs.p.svc.update(prs.p.id, false, func(job *Job) { job.Done = pos })
+	}
+	return pos, err
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *Progress
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	if err := pw.p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := pw.w.Write(b)
+	pw.p.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,216 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_RunCompleted(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 0)
+	job, err := svc.Run(context.Background(), "site1", KindImport, func(ctx context.Context, p *Progress) error {
+		p.SetTotal(10)
+		p.Add(4)
+		p.Add(6)
+		p.Logf("imported %d comments", 5)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatusCompleted, job.Status)
+	assert.Equal(t, "site1", job.SiteID)
+	assert.Equal(t, KindImport, job.Kind)
+	assert.Equal(t, int64(10), job.Done)
+	assert.Equal(t, int64(10), job.Total)
+	require.Equal(t, 1, len(job.Logs))
+	assert.True(t, strings.HasSuffix(job.Logs[0], " imported 5 comments"), job.Logs[0])
+	assert.False(t, job.Finished.Before(job.Started))
+
+	stored, err := svc.Store.Get("site1", job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, job, stored, "final state saved")
+}
+
+func TestService_RunFailed(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 0)
+	job, err := svc.Run(context.Background(), "site1", KindRemap, func(ctx context.Context, p *Progress) error {
+		return errors.New("something bad")
+	})
+	require.Error(t, err)
+	assert.Equal(t, "remap job failed: something bad", err.Error())
+	assert.Equal(t, StatusFailed, job.Status)
+	assert.Equal(t, "something bad", job.Error)
+}
+
+func TestService_Busy(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 0)
+	release := make(chan struct{})
+	job, err := svc.Start(context.Background(), "site1", KindImport, func(ctx context.Context, p *Progress) error {
+		<-release
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatusRunning, job.Status)
+	assert.True(t, svc.Busy("site1"))
+	assert.False(t, svc.Busy("site2"))
+
+	_, err = svc.Start(context.Background(), "site1", KindRemap, func(ctx context.Context, p *Progress) error { return nil })
+	assert.Equal(t, ErrBusy, err, "exclusive job rejected")
+
+	_, err = svc.Run(context.Background(), "site1", KindExport, func(ctx context.Context, p *Progress) error { return nil })
+	assert.NoError(t, err, "export allowed along with import")
+	_, err = svc.Run(context.Background(), "site2", KindImport, func(ctx context.Context, p *Progress) error { return nil })
+	assert.NoError(t, err, "import of other site allowed")
+
+	close(release)
+	job, err = svc.Wait(context.Background(), "site1", job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCompleted, job.Status)
+	assert.False(t, svc.Busy("site1"))
+}
+
+func TestService_Cancel(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 0)
+	started := make(chan struct{})
+	job, err := svc.Start(context.Background(), "site1", KindImport, func(ctx context.Context, p *Progress) error {
+		close(started)
+		_, err := ioutil.ReadAll(p.Reader(&endlessReader{}))
+		return err
+	})
+	require.NoError(t, err)
+	<-started
+
+	_, err = svc.Cancel("site2", job.ID)
+	assert.Equal(t, ErrNotFound, err, "job of other site")
+
+	running, err := svc.Cancel("site1", job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusRunning, running.Status)
+
+	job, err = svc.Wait(context.Background(), "site1", job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCanceled, job.Status)
+	assert.Equal(t, "context canceled", job.Error)
+	assert.True(t, job.Done > 0, "progress counted by reader")
+
+	_, err = svc.Cancel("site1", job.ID)
+	assert.EqualError(t, err, "job "+job.ID+" not running, canceled")
+	_, err = svc.Cancel("site1", "bad")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestService_GetList(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 0)
+	release := make(chan struct{})
+	running, err := svc.Start(context.Background(), "site1", KindImport, func(ctx context.Context, p *Progress) error {
+		p.Add(5)
+		<-release
+		return nil
+	})
+	require.NoError(t, err)
+
+	// saved as running by the server stopped in the middle of the job
+	stale := Job{ID: "stale", SiteID: "site1", Kind: KindRemap, Status: StatusRunning, Started: time.Now().Add(-time.Hour)}
+	require.NoError(t, svc.Store.Save(stale))
+
+	assert.Eventually(t, func() bool {
+		job, e := svc.Get("site1", running.ID)
+		return e == nil && job.Done == 5
+	}, time.Second, 10*time.Millisecond, "live progress of running job")
+
+	job, err := svc.Get("site1", "stale")
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, job.Status)
+	assert.Equal(t, "interrupted", job.Error)
+
+	_, err = svc.Get("site1", "bad")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = svc.Get("site2", running.ID)
+	assert.Equal(t, ErrNotFound, err)
+
+	jobs, err := svc.List("site1", 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(jobs))
+	assert.Equal(t, running.ID, jobs[0].ID)
+	assert.Equal(t, StatusRunning, jobs[0].Status)
+	assert.Equal(t, int64(5), jobs[0].Done)
+	assert.Equal(t, StatusFailed, jobs[1].Status)
+
+	jobs, err = svc.List("site1", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+
+	close(release)
+	_, err = svc.Wait(context.Background(), "site1", running.ID)
+	require.NoError(t, err)
+}
+
+func TestService_Keep(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 2)
+	for i := 0; i < 4; i++ {
+		_, err := svc.Run(context.Background(), "site1", KindExport, func(ctx context.Context, p *Progress) error { return nil })
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond) // different start times
+	}
+	jobs, err := svc.List("site1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(jobs), "old jobs removed")
+}
+
+func TestProgress_Writer(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 0)
+	buf := bytes.Buffer{}
+	job, err := svc.Run(context.Background(), "site1", KindExport, func(ctx context.Context, p *Progress) error {
+		_, err := p.Writer(&buf).Write([]byte("some data"))
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "some data", buf.String())
+	assert.Equal(t, int64(9), job.Done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	job, err = svc.Run(ctx, "site1", KindExport, func(ctx context.Context, p *Progress) error {
+		_, err := p.Writer(&buf).Write([]byte("more data"))
+		return err
+	})
+	require.Error(t, err)
+	assert.Equal(t, StatusCanceled, job.Status)
+	assert.Equal(t, "some data", buf.String(), "nothing written after cancellation")
+}
+
+func TestProgress_ReadSeeker(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 0)
+	job, err := svc.Run(context.Background(), "site1", KindImport, func(ctx context.Context, p *Progress) error {
+		r, ok := p.Reader(strings.NewReader("0123456789")).(io.ReadSeeker)
+		require.True(t, ok, "seekable reader kept seekable")
+		if _, err := ioutil.ReadAll(r); err != nil {
+			return err
+		}
+		_, err := r.Seek(4, io.SeekStart)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), job.Done, "done set to position")
+
+	_, err = svc.Run(context.Background(), "site1", KindImport, func(ctx context.Context, p *Progress) error {
+		_, ok := p.Reader(&endlessReader{}).(io.ReadSeeker)
+		assert.False(t, ok)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+type endlessReader struct{}
+
+func (r *endlessReader) Read(b []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return len(b), nil
+}
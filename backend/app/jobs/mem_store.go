@@ -0,0 +1,64 @@
+package jobs
+
+import (
+	"sort"
+	"sync"
+)
+
+// Memory implements Store keeping all jobs in memory. History lost on restart
+type Memory struct {
+	lock sync.RWMutex
+	jobs map[string]map[string]Job // site -> id -> job
+}
+
+// NewMemoryStorage makes in-memory jobs store
+func NewMemoryStorage() *Memory {
+	return &Memory{jobs: map[string]map[string]Job{}}
+}
+
+// Save job, replaces stored job with the same id
+func (m *Memory) Save(job Job) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.jobs[job.SiteID] == nil {
+		m.jobs[job.SiteID] = map[string]Job{}
+	}
+	m.jobs[job.SiteID][job.ID] = job.copy()
+	return nil
+}
+
+// Get job by id
+func (m *Memory) Get(siteID, id string) (Job, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	job, ok := m.jobs[siteID][id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return job.copy(), nil
+}
+
+// List returns all jobs of the site, the latest first
+func (m *Memory) List(siteID string) ([]Job, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	res := make([]Job, 0, len(m.jobs[siteID]))
+	for _, job := range m.jobs[siteID] {
+		res = append(res, job.copy())
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Started.After(res[j].Started) })
+	return res, nil
+}
+
+// Delete jobs by ids
+func (m *Memory) Delete(siteID string, ids ...string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, id := range ids {
+		delete(m.jobs[siteID], id)
+	}
+	return nil
+}
+
+// Close does nothing for memory store
+func (m *Memory) Close() error { return nil }
@@ -0,0 +1,72 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_SaveGetList(t *testing.T) {
+	checkSaveGetList(t, NewMemoryStorage())
+}
+
+func TestMemory_Delete(t *testing.T) {
+	checkDelete(t, NewMemoryStorage())
+}
+
+// checkSaveGetList verifies Store's replace by id, ordering and per-site separation
+func checkSaveGetList(t *testing.T, s Store) {
+	now := time.Now()
+	for i, id := range []string{"j1", "j2", "j3"} {
+		err := s.Save(Job{ID: id, SiteID: "site1", Kind: KindImport, Status: StatusRunning,
+			Started: now.Add(time.Duration(i) * time.Minute)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Save(Job{ID: "j4", SiteID: "site2", Kind: KindExport, Status: StatusCompleted, Started: now}))
+	require.NoError(t, s.Save(Job{ID: "j2", SiteID: "site1", Kind: KindImport, Status: StatusFailed, Error: "err",
+		Logs: []string{"line1"}, Started: now.Add(time.Minute)}))
+
+	job, err := s.Get("site1", "j2")
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, job.Status)
+	assert.Equal(t, "err", job.Error)
+	assert.Equal(t, []string{"line1"}, job.Logs)
+
+	_, err = s.Get("site2", "j2")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = s.Get("bad", "j2")
+	assert.Equal(t, ErrNotFound, err)
+
+	jobs, err := s.List("site1")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(jobs))
+	assert.Equal(t, "j3", jobs[0].ID, "the latest first")
+	assert.Equal(t, "j2", jobs[1].ID)
+	assert.Equal(t, "j1", jobs[2].ID)
+
+	jobs, err = s.List("site2")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(jobs))
+	assert.Equal(t, KindExport, jobs[0].Kind)
+
+	jobs, err = s.List("bad")
+	require.NoError(t, err)
+	assert.NotNil(t, jobs)
+	assert.Equal(t, 0, len(jobs))
+}
+
+// checkDelete verifies removal of jobs by ids
+func checkDelete(t *testing.T, s Store) {
+	for _, id := range []string{"j1", "j2", "j3"} {
+		require.NoError(t, s.Save(Job{ID: id, SiteID: "site1", Started: time.Now()}))
+	}
+	require.NoError(t, s.Delete("site1", "j1", "j3", "unknown"))
+	require.NoError(t, s.Delete("bad", "j1"), "no error for unknown site")
+
+	jobs, err := s.List("site1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(jobs))
+	assert.Equal(t, "j2", jobs[0].ID)
+}
@@ -15,13 +15,18 @@ import (
 
 // Opts with all cli commands and flags
 type Opts struct {
-	ServerCmd  cmd.ServerCommand  `command:"server"`
-	ImportCmd  cmd.ImportCommand  `command:"import"`
-	BackupCmd  cmd.BackupCommand  `command:"backup"`
-	RestoreCmd cmd.RestoreCommand `command:"restore"`
-	AvatarCmd  cmd.AvatarCommand  `command:"avatar"`
-	CleanupCmd cmd.CleanupCommand `command:"cleanup"`
-	RemapCmd   cmd.RemapCommand   `command:"remap"`
+	ServerCmd    cmd.ServerCommand    `command:"server"`
+	ImportCmd    cmd.ImportCommand    `command:"import"`
+	BackupCmd    cmd.BackupCommand    `command:"backup"`
+	RestoreCmd   cmd.RestoreCommand   `command:"restore"`
+	AvatarCmd    cmd.AvatarCommand    `command:"avatar"`
+	CleanupCmd   cmd.CleanupCommand   `command:"cleanup"`
+	RemapCmd     cmd.RemapCommand     `command:"remap"`
+	NormalizeCmd cmd.NormalizeCommand `command:"normalize"`
+	ImagesCmd    cmd.ImagesCommand    `command:"images"`
+	KeygenCmd    cmd.KeygenCommand    `command:"keygen"`
+	RekeyCmd     cmd.RekeyCommand     `command:"rekey"`
+	FsckCmd      cmd.FsckCommand      `command:"fsck"`
 
 	RemarkURL    string `long:"url" env:"REMARK_URL" required:"true" description:"url to remark"`
 	SharedSecret string `long:"secret" env:"SECRET" required:"true" description:"shared secret key"`
@@ -26,7 +26,8 @@ func Test_Main(t *testing.T) {
 
 	port := chooseRandomUnusedPort()
 	os.Args = []string{"test", "server", "--secret=123456", "--store.bolt.path=" + dir, "--backup=/tmp",
-		"--avatar.fs.path=" + dir, "--port=" + strconv.Itoa(port), "--url=https://demo.remark42.com", "--dbg", "--notify.type=none"}
+		"--avatar.fs.path=" + dir, "--port=" + strconv.Itoa(port), "--url=https://demo.remark42.com", "--dbg", "--notify.type=none",
+		"--jobs.bolt.file=" + dir + "/jobs.db"}
 
 	done := make(chan struct{})
 	go func() {
@@ -0,0 +1,470 @@
+package migrator
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/auth/avatar"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/image"
+)
+
+const archiveVersion = 1
+
+// defaultArchiveMaxUnpacked is default limit of total size of files unpacked on import
+const defaultArchiveMaxUnpacked = 4 * 1024 * 1024 * 1024
+
+// ErrPartialImport returned by Archive.Import when comments imported, but some of images or avatars failed
+var ErrPartialImport = errors.New("comments imported, some of images and avatars not restored")
+
+const (
+	archiveManifestName = "manifest.json"
+	archiveCommentsName = "comments.json"
+	archiveImagesDir    = "images/"
+	archiveAvatarsDir   = "avatars/"
+)
+
+// Archive implements exporter and importer for self-contained backup, tar.gz with native export of comments,
+// images referenced by comments, avatars of users and manifest with sha256 checksums of all the files.
+// Manifest written last and verified on import before any change of the site data.
+type Archive struct {
+	DataStore    Store
+	ImageService *image.Service // images not archived if nil
+	AvatarStore  avatar.Store   // avatars not archived if nil
+	MaxUnpacked  int64          // limit of total size of files unpacked on import, defaultArchiveMaxUnpacked if 0
+}
+
+type archiveManifest struct {
+	Version  int           `json:"version"`
+	SiteID   string        `json:"site"`
+	Created  time.Time     `json:"created"`
+	Comments int           `json:"comments"`
+	Files    []archiveFile `json:"files"`
+}
+
+type archiveFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	UserID string `json:"user_id,omitempty"` // owner of avatar, used to put it back
+}
+
+// Export writes tar.gz archive with comments, images and avatars of the site
+func (a *Archive) Export(w io.Writer, siteID string) (size int, err error) {
+	native := Native{DataStore: a.DataStore}
+	sp, err := NewSpool("remark42-archive", func(w io.Writer) (e error) {
+		size, e = native.Export(w, siteID)
+		return e
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't export comments of site %s", siteID)
+	}
+	defer sp.Remove()
+
+	src, err := sp.Reader()
+	if err != nil {
+		return 0, errors.Wrap(err, "can't read exported comments")
+	}
+	images, avatars, err := a.mediaRefs(src)
+	if err != nil {
+		return 0, err
+	}
+	if src, err = sp.Reader(); err != nil {
+		return 0, errors.Wrap(err, "can't read exported comments")
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	m := archiveManifest{Version: archiveVersion, SiteID: siteID, Created: time.Now(), Comments: size}
+
+	add := func(name string, r io.Reader, sz int64, userID string) error {
+		h := sha256.New()
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: sz, ModTime: m.Created, Typeflag: tar.TypeReg}
+		if e := tw.WriteHeader(hdr); e != nil {
+			return errors.Wrapf(e, "can't write header of %s", name)
+		}
+		if _, e := io.Copy(io.MultiWriter(tw, h), r); e != nil {
+			return errors.Wrapf(e, "can't write %s", name)
+		}
+		m.Files = append(m.Files, archiveFile{Name: name, Size: sz, SHA256: hex.EncodeToString(h.Sum(nil)), UserID: userID})
+		return nil
+	}
+
+	if err = add(archiveCommentsName, src, sp.Size(), ""); err != nil {
+		return 0, err
+	}
+
+	for _, id := range images {
+		img, e := a.ImageService.Load(id)
+		if e != nil {
+			log.Printf("[WARN] can't load image %s, skipped, %v", id, e)
+			continue
+		}
+		if err = add(archiveImagesDir+id, bytes.NewReader(img), int64(len(img)), ""); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, id := range sortedKeys(avatars) {
+		data, e := a.loadAvatar(id)
+		if e != nil {
+			log.Printf("[WARN] can't load avatar %s, skipped, %v", id, e)
+			continue
+		}
+		if err = add(archiveAvatarsDir+id, bytes.NewReader(data), int64(len(data)), avatars[id]); err != nil {
+			return 0, err
+		}
+	}
+
+	mdata, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return 0, errors.Wrap(err, "can't marshal manifest")
+	}
+	hdr := &tar.Header{Name: archiveManifestName, Mode: 0600, Size: int64(len(mdata)), ModTime: m.Created, Typeflag: tar.TypeReg}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return 0, errors.Wrap(err, "can't write manifest header")
+	}
+	if _, err = tw.Write(mdata); err != nil {
+		return 0, errors.Wrap(err, "can't write manifest")
+	}
+	if err = tw.Close(); err != nil {
+		return 0, errors.Wrap(err, "can't close tar")
+	}
+	if err = gz.Close(); err != nil {
+		return 0, errors.Wrap(err, "can't close gzip")
+	}
+	log.Printf("[INFO] archived %d comments, %d images and %d avatars of site %s",
+		size, len(images), len(avatars), siteID)
+	return size, nil
+}
+
+// mediaRefs collects ids of images referenced by not deleted comments and avatars of users,
+// returns sorted image ids and map of avatar id to user id
+func (a *Archive) mediaRefs(r io.Reader) (images []string, avatars map[string]string, err error) {
+	avatars = map[string]string{}
+	dec := json.NewDecoder(r)
+	if err = dec.Decode(&meta{}); err != nil {
+		return nil, nil, errors.Wrap(err, "can't decode meta")
+	}
+	seen := map[string]bool{}
+	for {
+		c := store.Comment{}
+		err = dec.Decode(&c)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "can't decode comment")
+		}
+		if a.ImageService != nil && !c.Deleted {
+			ids, e := a.ImageService.PictureRefs(c.Text)
+			if e != nil {
+				log.Printf("[WARN] can't get images of comment %s, %v", c.ID, e)
+			}
+			for _, id := range ids {
+				if !seen[id] {
+					seen[id] = true
+					images = append(images, id)
+				}
+			}
+		}
+		if a.AvatarStore != nil && c.User.ID != "" && strings.Contains(c.User.Picture, "/avatar/") {
+			avatars[path.Base(c.User.Picture)] = c.User.ID
+		}
+	}
+	sort.Strings(images)
+	return images, avatars, nil
+}
+
+func (a *Archive) loadAvatar(id string) ([]byte, error) {
+	rc, _, err := a.AvatarStore.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close() // nolint
+	return ioutil.ReadAll(rc)
+}
+
+// Import restores comments, images and avatars from tar.gz archive made by Export. The whole archive
+// unpacked to encrypted temporary spools and checked against manifest first, so broken archive changes nothing.
+// Comments imported as with Native, failed images and avatars reported after attempt to restore all of them,
+// with ErrPartialImport as comments already replaced.
+func (a *Archive) Import(reader io.Reader, siteID string) (size int, err error) {
+	entries, err := a.unpack(reader)
+	if err != nil {
+		return 0, err
+	}
+	defer removeEntries(entries)
+	m, err := a.verify(entries)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid archive")
+	}
+	log.Printf("[DEBUG] archive of site %s made %s verified, %d files", m.SiteID, m.Created.Format(time.RFC3339), len(m.Files))
+
+	fh, err := entries[archiveCommentsName].spool.Reader()
+	if err != nil {
+		return 0, errors.Wrap(err, "can't open comments")
+	}
+	native := Native{DataStore: a.DataStore}
+	if size, err = native.Import(fh, siteID); err != nil {
+		return 0, errors.Wrapf(err, "can't import comments of site %s", siteID)
+	}
+
+	var media, failed int
+	for _, f := range m.Files {
+		if f.Name == archiveCommentsName {
+			continue
+		}
+		media++
+		if e := a.restoreMedia(f, entries[f.Name].spool); e != nil {
+			log.Printf("[WARN] can't restore %s, %v", f.Name, e)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return size, errors.Wrapf(ErrPartialImport, "failed to restore %d of %d", failed, media)
+	}
+	log.Printf("[INFO] restored %d comments, %d images and avatars of site %s", size, media, siteID)
+	return size, nil
+}
+
+type archiveEntry struct {
+	spool  *Spool // unpacked content
+	size   int64
+	sha256 string
+}
+
+// unpack extracts all regular files of tar.gz to encrypted temporary spools, returns entries by name.
+// Names from the archive never used as paths. Total size of unpacked files limited by MaxUnpacked.
+// Entries should be removed with removeEntries
+func (a *Archive) unpack(reader io.Reader) (map[string]archiveEntry, error) {
+	maxSize := a.MaxUnpacked
+	if maxSize <= 0 {
+		maxSize = defaultArchiveMaxUnpacked
+	}
+	var total int64
+	unpacked := map[string]archiveEntry{}
+	entries, err := walkArchive(reader, func(name string, r io.Reader) (archiveEntry, error) {
+		e, err := a.saveEntry(io.LimitReader(r, maxSize-total+1)) // one byte more to detect the excess
+		unpacked[name] = e
+		if err != nil {
+			return e, err
+		}
+		if total += e.size; total > maxSize {
+			return e, errors.Errorf("unpacked size exceeds %d bytes", maxSize)
+		}
+		return e, nil
+	})
+	if err != nil {
+		removeEntries(unpacked)
+		return nil, err
+	}
+	return entries, nil
+}
+
+// removeEntries removes spools of unpacked entries
+func removeEntries(entries map[string]archiveEntry) {
+	for _, e := range entries {
+		if e.spool != nil {
+			e.spool.Remove()
+		}
+	}
+}
+
+// walkArchive calls fn for each regular file of tar.gz and returns entries made by fn by name
+func walkArchive(reader io.Reader, fn func(name string, r io.Reader) (archiveEntry, error)) (map[string]archiveEntry, error) {
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make gz reader")
+	}
+	defer gz.Close() // nolint
+
+	entries := map[string]archiveEntry{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "can't read archive")
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return nil, errors.Errorf("unexpected archive entry %s", hdr.Name)
+		}
+		if _, ok := entries[hdr.Name]; ok {
+			return nil, errors.Errorf("duplicate archive entry %s", hdr.Name)
+		}
+		e, err := fn(hdr.Name, tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't unpack %s", hdr.Name)
+		}
+		entries[hdr.Name] = e
+	}
+	return entries, nil
+}
+
+// verifyArchive reads tar.gz archive and checks it against manifest, like Import does but without unpacking
+func verifyArchive(reader io.Reader) (m archiveManifest, err error) {
+	var manifest []byte
+	entries, err := walkArchive(reader, func(name string, r io.Reader) (archiveEntry, error) {
+		h := sha256.New()
+		if name == archiveManifestName {
+			data, e := ioutil.ReadAll(io.TeeReader(r, h))
+			manifest = data
+			return archiveEntry{size: int64(len(data)), sha256: hex.EncodeToString(h.Sum(nil))}, e
+		}
+		size, e := io.Copy(h, r)
+		return archiveEntry{size: size, sha256: hex.EncodeToString(h.Sum(nil))}, e
+	})
+	if err != nil {
+		return m, err
+	}
+	if _, ok := entries[archiveManifestName]; !ok {
+		return m, errors.New("no manifest")
+	}
+	return checkManifest(manifest, entries)
+}
+
+func (a *Archive) saveEntry(r io.Reader) (archiveEntry, error) {
+	h := sha256.New()
+	sp, err := NewSpool("remark42-archive-entry", func(w io.Writer) error {
+		_, e := io.Copy(io.MultiWriter(w, h), r)
+		return e
+	})
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	return archiveEntry{spool: sp, size: sp.Size(), sha256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// verify checks unpacked entries against manifest. All listed files must be present with the same size
+// and checksum, no unlisted files allowed
+func (a *Archive) verify(entries map[string]archiveEntry) (m archiveManifest, err error) {
+	me, ok := entries[archiveManifestName]
+	if !ok {
+		return m, errors.New("no manifest")
+	}
+	data, err := readSpool(me.spool)
+	if err != nil {
+		return m, errors.Wrap(err, "can't read manifest")
+	}
+	return checkManifest(data, entries)
+}
+
+// checkManifest decodes manifest and checks entries against it
+func checkManifest(data []byte, entries map[string]archiveEntry) (m archiveManifest, err error) {
+	if err = json.Unmarshal(data, &m); err != nil {
+		return m, errors.Wrap(err, "can't decode manifest")
+	}
+	if m.Version != archiveVersion {
+		return m, errors.Errorf("unexpected archive version %d", m.Version)
+	}
+
+	listed := map[string]bool{archiveManifestName: true}
+	for _, f := range m.Files {
+		if listed[f.Name] {
+			return m, errors.Errorf("duplicate manifest entry %s", f.Name)
+		}
+		listed[f.Name] = true
+		if err = checkArchiveName(f); err != nil {
+			return m, err
+		}
+		e, ok := entries[f.Name]
+		if !ok {
+			return m, errors.Errorf("missing file %s", f.Name)
+		}
+		if e.size != f.Size || e.sha256 != f.SHA256 {
+			return m, errors.Errorf("checksum mismatch for %s", f.Name)
+		}
+	}
+	if !listed[archiveCommentsName] {
+		return m, errors.New("no comments")
+	}
+	for name := range entries {
+		if !listed[name] {
+			return m, errors.Errorf("unexpected file %s", name)
+		}
+	}
+	return m, nil
+}
+
+// checkArchiveName allows comments, images and avatars with clean relative ids only
+func checkArchiveName(f archiveFile) error {
+	id := ""
+	switch {
+	case f.Name == archiveCommentsName:
+		return nil
+	case strings.HasPrefix(f.Name, archiveImagesDir):
+		id = strings.TrimPrefix(f.Name, archiveImagesDir)
+	case strings.HasPrefix(f.Name, archiveAvatarsDir):
+		id = strings.TrimPrefix(f.Name, archiveAvatarsDir)
+		if f.UserID == "" {
+			return errors.Errorf("no user for avatar %s", f.Name)
+		}
+	}
+	if id == "" || path.IsAbs(id) || path.Clean(id) != id || strings.HasPrefix(id, "..") {
+		return errors.Errorf("invalid file name %s", f.Name)
+	}
+	return nil
+}
+
+// restoreMedia puts back image or avatar from unpacked entry
+func (a *Archive) restoreMedia(f archiveFile, sp *Spool) error {
+	data, err := readSpool(sp)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(f.Name, archiveImagesDir) {
+		if a.ImageService == nil {
+			return errors.New("no image service")
+		}
+		return a.ImageService.Restore(strings.TrimPrefix(f.Name, archiveImagesDir), data)
+	}
+
+	if a.AvatarStore == nil {
+		return errors.New("no avatar store")
+	}
+	id, err := a.AvatarStore.Put(f.UserID, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if expected := strings.TrimPrefix(f.Name, archiveAvatarsDir); id != expected {
+		log.Printf("[WARN] avatar of %s restored as %s, expected %s", f.UserID, id, expected)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// readSpool reads all data of the spool
+func readSpool(sp *Spool) ([]byte, error) {
+	r, err := sp.Reader()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,268 @@
+package migrator
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/image"
+)
+
+const archiveTestAPI = "http://127.0.0.1:8080/api/v1/"
+
+func TestArchive_ExportImport(t *testing.T) {
+	arc, teardown := prepArchive(t)
+	defer teardown()
+
+	buf := &bytes.Buffer{}
+	size, err := arc.Export(buf, "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+
+	files := readArchive(t, buf.Bytes())
+	assert.Equal(t, 4, len(files), "comments, image, avatar and manifest")
+	assert.Equal(t, []byte("image data"), files["images/user1/pic.png"])
+	m := archiveManifest{}
+	require.NoError(t, json.Unmarshal(files["manifest.json"], &m))
+	assert.Equal(t, 1, m.Version)
+	assert.Equal(t, "radio-t", m.SiteID)
+	assert.Equal(t, 3, m.Comments)
+	require.Equal(t, 3, len(m.Files))
+	assert.Equal(t, "comments.json", m.Files[0].Name)
+	assert.Equal(t, "images/user1/pic.png", m.Files[1].Name)
+	assert.Equal(t, int64(10), m.Files[1].Size)
+	assert.Equal(t, sha256hex([]byte("image data")), m.Files[1].SHA256)
+	assert.Equal(t, "user1", m.Files[2].UserID)
+	assert.Equal(t, []byte("avatar data"), files[m.Files[2].Name])
+
+	// restore to fresh stores
+	arc2, teardown2 := prepArchive(t)
+	defer teardown2()
+	require.NoError(t, arc2.DataStore.DeleteAll("radio-t"))
+	loc, err := ioutil.TempDir("", "test_archive_restore")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+	arc2.ImageService = image.NewService(&image.FileSystem{Location: loc + "/images", Staging: loc + "/staging", Partitions: 10},
+		image.ServiceParams{ImageAPI: archiveTestAPI + "picture/"})
+	arc2.AvatarStore = avatar.NewLocalFS(loc + "/avatars")
+
+	size, err = arc2.Import(bytes.NewReader(buf.Bytes()), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+
+	comments, err := arc2.DataStore.Find(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, "time", adminUser)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments))
+	img, err := arc2.ImageService.Load("user1/pic.png")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("image data"), img)
+	rc, _, err := arc2.AvatarStore.Get(m.Files[2].Name[len("avatars/"):])
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, []byte("avatar data"), data)
+}
+
+func TestArchive_ImportInvalid(t *testing.T) {
+	arc, teardown := prepArchive(t)
+	defer teardown()
+
+	buf := &bytes.Buffer{}
+	_, err := arc.Export(buf, "radio-t")
+	require.NoError(t, err)
+	orig := buf.Bytes()
+
+	tbl := []struct {
+		name string
+		fn   func(name string, body []byte) (string, []byte)
+		err  string
+	}{
+		{"changed image", func(name string, body []byte) (string, []byte) {
+			if name == "images/user1/pic.png" {
+				return name, []byte("image dat!")
+			}
+			return name, body
+		}, "invalid archive: checksum mismatch for images/user1/pic.png"},
+		{"no manifest", func(name string, body []byte) (string, []byte) {
+			if name == "manifest.json" {
+				return "", nil
+			}
+			return name, body
+		}, "invalid archive: no manifest"},
+		{"missing image", func(name string, body []byte) (string, []byte) {
+			if name == "images/user1/pic.png" {
+				return "", nil
+			}
+			return name, body
+		}, "invalid archive: missing file images/user1/pic.png"},
+		{"unlisted file", func(name string, body []byte) (string, []byte) {
+			if name == "manifest.json" {
+				m := archiveManifest{}
+				require.NoError(t, json.Unmarshal(body, &m))
+				m.Files = append(m.Files[:1], m.Files[2:]...)
+				body, err = json.Marshal(m)
+				require.NoError(t, err)
+			}
+			return name, body
+		}, "invalid archive: unexpected file images/user1/pic.png"},
+		{"bad name", func(name string, body []byte) (string, []byte) {
+			switch name {
+			case "images/user1/pic.png":
+				return "images/../../pic.png", body
+			case "manifest.json":
+				return name, bytes.Replace(body, []byte("images/user1/pic.png"), []byte("images/../../pic.png"), 1)
+			}
+			return name, body
+		}, "invalid archive: invalid file name images/../../pic.png"},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := arc.Import(bytes.NewReader(rewriteArchive(t, orig, tt.fn)), "radio-t")
+			require.EqualError(t, err, tt.err)
+			comments, err := arc.DataStore.Find(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, "time", adminUser)
+			require.NoError(t, err)
+			assert.Equal(t, 2, len(comments), "site data not changed")
+		})
+	}
+
+	_, err = arc.Import(bytes.NewBufferString("not an archive"), "radio-t")
+	assert.Error(t, err)
+}
+
+func TestArchive_ImportMediaFailed(t *testing.T) {
+	arc, teardown := prepArchive(t)
+	defer teardown()
+
+	buf := &bytes.Buffer{}
+	_, err := arc.Export(buf, "radio-t")
+	require.NoError(t, err)
+
+	arc.AvatarStore = nil
+	size, err := arc.Import(buf, "radio-t")
+	assert.EqualError(t, err, "failed to restore 1 of 2: comments imported, some of images and avatars not restored")
+	assert.True(t, errors.Is(err, ErrPartialImport))
+	assert.Equal(t, 3, size, "comments imported")
+}
+
+func TestArchive_ImportTooLarge(t *testing.T) {
+	arc, teardown := prepArchive(t)
+	defer teardown()
+
+	buf := &bytes.Buffer{}
+	_, err := arc.Export(buf, "radio-t")
+	require.NoError(t, err)
+
+	arc.MaxUnpacked = 100
+	_, err = arc.Import(bytes.NewReader(buf.Bytes()), "radio-t")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unpacked size exceeds 100 bytes")
+	comments, err := arc.DataStore.Find(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, "time", adminUser)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments), "site data not changed")
+	files, err := filepath.Glob(filepath.Join(os.TempDir(), "remark42-archive-entry*"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(files), "unpacked files removed")
+
+	arc.MaxUnpacked = 0
+	size, err := arc.Import(bytes.NewReader(buf.Bytes()), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+}
+
+// prepArchive makes archive with two comments of prep, one more with image and avatar
+func prepArchive(t *testing.T) (*Archive, func()) {
+	b, teardown := prep(t)
+	loc, err := ioutil.TempDir("", "test_archive")
+	require.NoError(t, err)
+
+	imgSvc := image.NewService(&image.FileSystem{Location: loc + "/images", Staging: loc + "/staging", Partitions: 10},
+		image.ServiceParams{ImageAPI: archiveTestAPI + "picture/"})
+	require.NoError(t, imgSvc.Restore("user1/pic.png", []byte("image data")))
+	avStore := avatar.NewLocalFS(loc + "/avatars")
+	avatarID, err := avStore.Put("user1", bytes.NewBufferString("avatar data"))
+	require.NoError(t, err)
+
+	_, err = b.Create(store.Comment{
+		ID:        "c-with-image",
+		Text:      `<p>pic <img src="` + archiveTestAPI + `picture/user1/pic.png"/></p>`,
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local),
+		Locator:   store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		User:      store.User{ID: "user1", Name: "user name", Picture: archiveTestAPI + "avatar/" + avatarID},
+	})
+	require.NoError(t, err)
+
+	return &Archive{DataStore: b, ImageService: imgSvc, AvatarStore: avStore}, func() {
+		teardown()
+		_ = os.RemoveAll(loc)
+	}
+}
+
+// readArchive returns content of all files in tar.gz
+func readArchive(t *testing.T, data []byte) map[string][]byte {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	res := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		res[hdr.Name] = body
+	}
+	return res
+}
+
+// rewriteArchive makes copy of tar.gz with files changed by fn, empty name drops the file
+func rewriteArchive(t *testing.T, data []byte, fn func(name string, body []byte) (string, []byte)) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	gzw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gzw)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		name, body := fn(hdr.Name, body)
+		if name == "" {
+			continue
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(body)), Typeflag: tar.TypeReg}))
+		_, err = tw.Write(body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+	return out.Bytes()
+}
+
+func sha256hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
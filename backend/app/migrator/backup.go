@@ -1,17 +1,25 @@
 package migrator
 
 import (
+	"bufio"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"sort"
-	"strings"
+	"path/filepath"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
+	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/jobs"
+	"github.com/umputun/remark/backend/app/notify"
 )
 
 // AutoBackup struct handles daily backups params for siteID
@@ -21,22 +29,30 @@ type AutoBackup struct {
 	SiteID         string
 	KeepMax        int
 	Duration       time.Duration
+	Jobs           *jobs.Service   // runs each backup as a job if set
+	Archive        bool            // exporter makes tar.gz archive, written as is
+	Crypter        *Crypter        // encrypts backup files if enabled
+	Targets        []Target        // copies of backups stored there too
+	Retention      Retention       // kept backups, KeepMax latest daily backups if not set
+	NotifyService  *notify.Service // reports failed backups if set
+	AdminEmail     string          // email for reports of failed backups
 }
 
-// Do runs daily export to local files, keeps up to keepMax backups for given siteID
+// Do runs daily export to local files and copies them to targets, keeps backups for given siteID by retention policy
 func (ab AutoBackup) Do(ctx context.Context) {
-	log.Printf("[INFO] activate auto-backup for %s under %s, duration %s", ab.SiteID, ab.BackupLocation, ab.Duration)
+	log.Printf("[INFO] activate auto-backup for %s under %s, duration %s, targets %v, keep %s",
+		ab.SiteID, ab.BackupLocation, ab.Duration, ab.Targets, ab.retention())
 	tick := time.NewTicker(ab.Duration)
 	log.Printf("[DEBUG] first backup for %s at %s", ab.SiteID, time.Now().Add(ab.Duration))
 
 	for {
 		select {
 		case <-tick.C:
-			if _, err := ab.makeBackup(); err != nil {
+			if err := ab.run(ctx); err != nil {
 				log.Printf("[WARN] auto-backup for %s failed, %s", ab.SiteID, err)
+				ab.alert(err)
 				continue
 			}
-			ab.removeOldBackupFiles()
 			log.Printf("[DEBUG] next backup for %s at %s", ab.SiteID, time.Now().Add(ab.Duration))
 		case <-ctx.Done():
 			log.Printf("[WARN] terminated autobackup for %s", ab.SiteID)
@@ -45,20 +61,98 @@ func (ab AutoBackup) Do(ctx context.Context) {
 	}
 }
 
+// run makes backup, as a backup job of the site if jobs service set
+func (ab AutoBackup) run(ctx context.Context) error {
+	if ab.Jobs == nil {
+		return ab.backup(func(string, ...interface{}) {})
+	}
+	_, err := ab.Jobs.Run(ctx, ab.SiteID, jobs.KindBackup, func(ctx context.Context, p *jobs.Progress) error {
+		return ab.backup(p.Logf)
+	})
+	return err
+}
+
+// backup makes backup file and stores it to all targets. Each stored copy read back and verified, expired backups
+// removed from the target only if the copy is good. Fails if any of targets failed, after trying all of them
+func (ab AutoBackup) backup(logf func(format string, args ...interface{})) error {
+	backupFile, err := ab.makeBackup()
+	if err != nil {
+		return err
+	}
+	logf("created backup file %s", backupFile)
+	sum, err := fileChecksum(backupFile)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(backupFile)
+	errs := new(multierror.Error)
+	for i, t := range ab.targets() {
+		if i > 0 { // the first target is backup location, file made there
+			if err = t.Put(name, backupFile); err != nil {
+				errs = multierror.Append(errs, errors.Wrapf(err, "can't store backup to %s", t))
+				continue
+			}
+		}
+		if err = ab.verify(t, name, sum); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "backup %s on %s is broken", name, t))
+			continue
+		}
+		if i > 0 {
+			logf("stored backup %s to %s", name, t)
+		}
+		ab.removeOldBackups(t)
+	}
+	return errs.ErrorOrNil()
+}
+
+// targets returns backup location followed by other targets
+func (ab AutoBackup) targets() []Target {
+	return append([]Target{&LocalTarget{Location: ab.BackupLocation}}, ab.Targets...)
+}
+
 func (ab AutoBackup) makeBackup() (string, error) {
 	log.Printf("[DEBUG] make backup for %s", ab.SiteID)
-	backupFile := fmt.Sprintf("%s/backup-%s-%s.gz", ab.BackupLocation, ab.SiteID, time.Now().Format("20060102"))
+	ext := "gz"
+	if ab.Archive {
+		ext = "tar.gz"
+	}
+	backupFile := fmt.Sprintf("%s/backup-%s-%s.%s", ab.BackupLocation, ab.SiteID, time.Now().Format("20060102"), ext)
+	if ab.Crypter.Enabled() {
+		backupFile += EncryptedExt
+	}
 	fh, err := os.Create(backupFile)
 	if err != nil {
 		return "", errors.Wrapf(err, "can't create backup file %s", backupFile)
 	}
-	gz := gzip.NewWriter(fh)
 
-	if _, err = ab.Exporter.Export(gz, ab.SiteID); err != nil {
-		return "", errors.Wrapf(err, "export failed for %s", ab.SiteID)
+	var w io.Writer = fh
+	var encWriter io.WriteCloser
+	if ab.Crypter.Enabled() {
+		if encWriter, err = ab.Crypter.Encrypt(fh); err != nil {
+			_ = fh.Close()
+			return "", errors.Wrapf(err, "can't encrypt %s", backupFile)
+		}
+		w = encWriter
 	}
-	if err = gz.Close(); err != nil {
-		return "", errors.Wrapf(err, "can't close gz for %s", backupFile)
+
+	if ab.Archive {
+		if _, err = ab.Exporter.Export(w, ab.SiteID); err != nil {
+			return "", errors.Wrapf(err, "export failed for %s", ab.SiteID)
+		}
+	} else {
+		gz := gzip.NewWriter(w)
+		if _, err = ab.Exporter.Export(gz, ab.SiteID); err != nil {
+			return "", errors.Wrapf(err, "export failed for %s", ab.SiteID)
+		}
+		if err = gz.Close(); err != nil {
+			return "", errors.Wrapf(err, "can't close gz for %s", backupFile)
+		}
+	}
+	if encWriter != nil {
+		if err = encWriter.Close(); err != nil {
+			return "", errors.Wrapf(err, "can't complete encryption of %s", backupFile)
+		}
 	}
 	if err = fh.Close(); err != nil {
 		return "", errors.Wrapf(err, "can't close file handler for %s", backupFile)
@@ -67,28 +161,109 @@ func (ab AutoBackup) makeBackup() (string, error) {
 	return backupFile, nil
 }
 
-func (ab AutoBackup) removeOldBackupFiles() {
-	files, err := ioutil.ReadDir(ab.BackupLocation)
+// verify reads stored backup back and checks its content and checksum. Content decrypted if there is
+// passphrase or identity for it, checked to be valid gzip with json records or archive matching its manifest
+func (ab AutoBackup) verify(t Target, name, sum string) error {
+	rc, err := t.Get(name)
 	if err != nil {
-		log.Printf("[WARN] can't read files in backup directory %s, %s", ab.BackupLocation, err)
-		return
+		return errors.Wrap(err, "can't read backup")
+	}
+	defer rc.Close() // nolint
+
+	h := sha256.New()
+	r := io.TeeReader(rc, h)
+	if err = ab.checkContent(r); err != nil {
+		return err
 	}
-	backFiles := []os.FileInfo{}
-	for _, file := range files {
-		if strings.HasPrefix(file.Name(), "backup-"+ab.SiteID) {
-			backFiles = append(backFiles, file)
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		return errors.Wrap(err, "can't read backup")
+	}
+	if hex.EncodeToString(h.Sum(nil)) != sum {
+		return errors.New("checksum mismatch")
+	}
+	log.Printf("[DEBUG] verified backup %s on %s", name, t)
+	return nil
+}
+
+func (ab AutoBackup) checkContent(r io.Reader) (err error) {
+	br := bufio.NewReader(r)
+	var data io.Reader = br
+	if head, _ := br.Peek(len(encMagic)); IsEncrypted(head) {
+		if ab.Crypter == nil || (ab.Crypter.Passphrase == "" && len(ab.Crypter.Identities) == 0) {
+			return nil // encrypted for recipients only, checksum is all we can verify
+		}
+		if data, err = ab.Crypter.Decrypt(br); err != nil {
+			return err
 		}
 	}
-	sort.Slice(backFiles, func(i int, j int) bool { return backFiles[i].Name() < backFiles[j].Name() })
 
-	if len(backFiles) > ab.KeepMax {
-		for i := 0; i < len(backFiles)-ab.KeepMax; i++ {
-			fpath := ab.BackupLocation + "/" + backFiles[i].Name()
-			if e := os.Remove(fpath); e != nil {
-				log.Printf("[WARN] can't delete %s, %s", fpath, err)
-				continue
-			}
-			log.Printf("[DEBUG] removed %s", fpath)
+	if ab.Archive {
+		_, err = verifyArchive(data)
+		return err
+	}
+	gz, err := gzip.NewReader(data)
+	if err != nil {
+		return errors.Wrap(err, "can't make gz reader")
+	}
+	dec := json.NewDecoder(gz)
+	for n := 1; ; n++ {
+		rec := json.RawMessage{}
+		if err = dec.Decode(&rec); err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "invalid record %d", n)
+		}
+	}
+}
+
+// removeOldBackups removes backups of the site expired by retention policy from the target
+func (ab AutoBackup) removeOldBackups(t Target) {
+	names, err := t.List("backup-" + ab.SiteID + "-")
+	if err != nil {
+		log.Printf("[WARN] can't list backups on %s, %s", t, err)
+		return
+	}
+	for _, name := range ab.retention().Expired(ab.SiteID, names) {
+		if e := t.Remove(name); e != nil {
+			log.Printf("[WARN] can't delete %s on %s, %s", name, t, e)
+			continue
 		}
+		log.Printf("[DEBUG] removed %s on %s", name, t)
+	}
+}
+
+func (ab AutoBackup) retention() Retention {
+	if ab.Retention == (Retention{}) {
+		return Retention{Daily: ab.KeepMax}
+	}
+	return ab.Retention
+}
+
+// alert reports failed backup to administrator
+func (ab AutoBackup) alert(err error) {
+	if ab.NotifyService == nil {
+		return
+	}
+	ab.NotifyService.Submit(notify.Request{
+		Email: ab.AdminEmail,
+		Alert: notify.AlertMetadata{
+			SiteID:  ab.SiteID,
+			Subject: "Backup failed",
+			Text:    fmt.Sprintf("backup of site %s failed at %s\n%v", ab.SiteID, time.Now().Format(time.RFC3339), err),
+		},
+	})
+}
+
+func fileChecksum(file string) (string, error) {
+	fh, err := os.Open(file) // nolint
+	if err != nil {
+		return "", errors.Wrapf(err, "can't open %s", file)
+	}
+	defer fh.Close() // nolint
+	h := sha256.New()
+	if _, err = io.Copy(h, fh); err != nil {
+		return "", errors.Wrapf(err, "can't read %s", file)
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
@@ -1,16 +1,22 @@
 package migrator
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/jobs"
+	"github.com/umputun/remark/backend/app/notify"
 )
 
 func TestBackup_RemoveOldBackupFiles(t *testing.T) {
@@ -29,7 +35,7 @@ func TestBackup_RemoveOldBackupFiles(t *testing.T) {
 	assert.NoError(t, err)
 
 	bk := AutoBackup{BackupLocation: loc, SiteID: "site1", KeepMax: 3}
-	bk.removeOldBackupFiles()
+	bk.removeOldBackups(&LocalTarget{Location: loc})
 	ff, err := ioutil.ReadDir(loc)
 	assert.NoError(t, err)
 	require.Equal(t, 4, len(ff), "should keep 4 files - 3 kept for sit1, and one for site2")
@@ -55,6 +61,49 @@ func TestBackup_MakeBackup(t *testing.T) {
 	assert.Equal(t, int64(52), fi.Size())
 }
 
+func TestBackup_MakeBackupArchive(t *testing.T) {
+	loc := "/tmp/remark-backups.test"
+	defer os.RemoveAll(loc)
+	assert.NoError(t, os.MkdirAll(loc, 0700))
+
+	bk := AutoBackup{BackupLocation: loc, SiteID: "site1", KeepMax: 3, Exporter: &mockExporter{}, Archive: true}
+	fname, err := bk.makeBackup()
+	assert.NoError(t, err)
+	expFile := fmt.Sprintf("/tmp/remark-backups.test/backup-site1-%s.tar.gz", time.Now().Format("20060102"))
+	assert.Equal(t, expFile, fname)
+
+	data, err := ioutil.ReadFile(expFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "some export blah blah 1234567890", string(data), "archive written as is")
+}
+
+func TestBackup_MakeBackupEncrypted(t *testing.T) {
+	defer func(n int) { scryptLogN = n }(scryptLogN)
+	scryptLogN = 10 // fast passphrase for tests
+	loc := "/tmp/remark-backups.test"
+	defer os.RemoveAll(loc)
+	assert.NoError(t, os.MkdirAll(loc, 0700))
+
+	crypter := &Crypter{Passphrase: "secret"}
+	bk := AutoBackup{BackupLocation: loc, SiteID: "site1", KeepMax: 3, Exporter: &mockExporter{}, Crypter: crypter}
+	fname, err := bk.makeBackup()
+	require.NoError(t, err)
+	expFile := fmt.Sprintf("/tmp/remark-backups.test/backup-site1-%s.gz.enc", time.Now().Format("20060102"))
+	assert.Equal(t, expFile, fname)
+	assert.True(t, IsEncryptedFile(expFile))
+
+	fh, err := os.Open(expFile)
+	require.NoError(t, err)
+	defer fh.Close()
+	r, err := crypter.Decrypt(fh)
+	require.NoError(t, err)
+	gz, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "some export blah blah 1234567890", string(data))
+}
+
 func TestBackup_Do(t *testing.T) {
 	loc := "/tmp/remark-backups.test"
 	defer os.RemoveAll(loc)
@@ -75,9 +124,166 @@ func TestBackup_Do(t *testing.T) {
 	assert.Equal(t, int64(52), fi.Size())
 }
 
-type mockExporter struct{}
+func TestBackup_DoWithJobs(t *testing.T) {
+	loc := "/tmp/remark-backups-jobs.test"
+	defer os.RemoveAll(loc)
+	assert.NoError(t, os.MkdirAll(loc, 0700))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Second)
+		cancel()
+	}()
+
+	jobsService := jobs.NewService(jobs.NewMemoryStorage(), 0)
+	bk := AutoBackup{BackupLocation: loc, SiteID: "site1", KeepMax: 3, Exporter: &mockExporter{data: mockNativeExport},
+		Duration: 600 * time.Millisecond, Jobs: jobsService}
+	bk.Do(ctx)
+
+	expFile := fmt.Sprintf("%s/backup-site1-%s.gz", loc, time.Now().Format("20060102"))
+	_, err := os.Lstat(expFile)
+	assert.NoError(t, err)
+
+	list, err := jobsService.List("site1", 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, jobs.KindBackup, list[0].Kind)
+	assert.Equal(t, jobs.StatusCompleted, list[0].Status)
+	assert.Equal(t, []string{"created backup file " + expFile}, trimLogTime(list[0].Logs))
+}
+
+func TestBackup_Targets(t *testing.T) {
+	loc, err := ioutil.TempDir("", "remark-backups-targets")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+
+	copies := &LocalTarget{Location: loc + "/copies"}
+	for _, date := range []string{"20171101", "20171201", "20171202"} {
+		require.NoError(t, os.MkdirAll(copies.Location, 0700))
+		require.NoError(t, ioutil.WriteFile(copies.Location+"/backup-site1-"+date+".gz", []byte("blah"), 0600))
+	}
+	dest := &notify.MockDest{}
+	notifier := notify.NewService(nil, 10, dest)
+	defer notifier.Close()
+
+	bk := AutoBackup{BackupLocation: loc, SiteID: "site1", Exporter: &mockExporter{data: mockNativeExport},
+		Targets: []Target{copies}, Retention: Retention{Daily: 1, Monthly: 2}, NotifyService: notifier, AdminEmail: "admin@example.com"}
+	require.NoError(t, bk.run(context.Background()))
+
+	name := fmt.Sprintf("backup-site1-%s.gz", time.Now().Format("20060102"))
+	names, err := copies.List("backup-site1-")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backup-site1-20171202.gz", name}, names, "copy stored, older monthly and daily removed")
+	orig, err := ioutil.ReadFile(loc + "/" + name)
+	require.NoError(t, err)
+	stored, err := ioutil.ReadFile(copies.Location + "/" + name)
+	require.NoError(t, err)
+	assert.Equal(t, orig, stored)
+
+	// broken target and target returning damaged copy
+	broken := &LocalTarget{Location: loc + "/" + name + "/sub"}
+	damaged := &damagedTarget{LocalTarget: LocalTarget{Location: loc + "/damaged"}}
+	bk.Targets = []Target{broken, damaged, copies}
+	runErr := bk.run(context.Background())
+	require.Error(t, runErr)
+	assert.Contains(t, runErr.Error(), "can't store backup to dir:"+broken.Location)
+	assert.Contains(t, runErr.Error(), "backup "+name+" on dir:"+damaged.Location+" is broken")
+	names, err = copies.List("backup-site1-")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backup-site1-20171202.gz", name}, names, "good target processed")
+
+	bk.alert(runErr)
+	time.Sleep(100 * time.Millisecond)
+	reqs := dest.Get()
+	require.Equal(t, 1, len(reqs))
+	assert.Equal(t, "admin@example.com", reqs[0].Email)
+	assert.Equal(t, "Backup failed", reqs[0].Alert.Subject)
+	assert.Equal(t, "site1", reqs[0].Alert.SiteID)
+	assert.Contains(t, reqs[0].Alert.Text, "can't store backup to dir:"+broken.Location)
+}
+
+func TestBackup_Verify(t *testing.T) {
+	defer func(n int) { scryptLogN = n }(scryptLogN)
+	scryptLogN = 10 // fast passphrase for tests
+	loc, err := ioutil.TempDir("", "remark-backups-verify")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+	target := &LocalTarget{Location: loc}
+
+	_, pub, err := GenerateKey()
+	require.NoError(t, err)
+	arc, teardown := prepArchive(t)
+	defer teardown()
+
+	tbl := []struct {
+		name string
+		bk   AutoBackup
+		err  string
+	}{
+		{"native", AutoBackup{Exporter: &mockExporter{data: mockNativeExport}}, ""},
+		{"not json", AutoBackup{Exporter: &mockExporter{}}, "invalid record 1: invalid character 's' looking for beginning of value"},
+		{"archive", AutoBackup{Exporter: arc, Archive: true}, ""},
+		{"not archive", AutoBackup{Exporter: &mockExporter{}, Archive: true}, "can't make gz reader: gzip: invalid header"},
+		{"encrypted", AutoBackup{Exporter: &mockExporter{data: mockNativeExport}, Crypter: &Crypter{Passphrase: "secret"}}, ""},
+		{"encrypted, not json", AutoBackup{Exporter: &mockExporter{}, Crypter: &Crypter{Passphrase: "secret"}},
+			"invalid record 1: invalid character 's' looking for beginning of value"},
+		{"encrypted archive", AutoBackup{Exporter: arc, Archive: true, Crypter: &Crypter{Passphrase: "secret"}}, ""},
+		{"encrypted for recipient", AutoBackup{Exporter: &mockExporter{}, Crypter: &Crypter{Recipients: []string{pub}}}, ""},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.bk.BackupLocation, tt.bk.SiteID = loc, "radio-t"
+			fname, err := tt.bk.makeBackup()
+			require.NoError(t, err)
+			defer os.Remove(fname)
+			sum, err := fileChecksum(fname)
+			require.NoError(t, err)
+			err = tt.bk.verify(target, filepath.Base(fname), sum)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.EqualError(t, tt.bk.verify(target, filepath.Base(fname), "bad"), "checksum mismatch")
+		})
+	}
+}
+
+// damagedTarget returns changed content of stored files
+type damagedTarget struct {
+	LocalTarget
+}
+
+func (d *damagedTarget) Get(name string) (io.ReadCloser, error) {
+	data, err := ioutil.ReadFile(filepath.Join(d.Location, name))
+	if err != nil {
+		return nil, err
+	}
+	data[len(data)-1]++
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// trimLogTime removes timestamps of job's log lines
+func trimLogTime(logs []string) []string {
+	res := make([]string, 0, len(logs))
+	for _, l := range logs {
+		res = append(res, l[len("2006-01-02 15:04:05 "):])
+	}
+	return res
+}
+
+// mockNativeExport is json records like made by native exporter
+const mockNativeExport = `{"version":1,"users":[],"posts":[]}` + "\n" + `{"id":"c1","text":"comment"}` + "\n"
+
+type mockExporter struct {
+	data string // written data, "some export blah blah 1234567890" if not set
+}
 
 func (mock *mockExporter) Export(w io.Writer, siteID string) (int, error) {
-	_, err := w.Write([]byte("some export blah blah 1234567890"))
+	data := mock.data
+	if data == "" {
+		data = "some export blah blah 1234567890"
+	}
+	_, err := w.Write([]byte(data))
 	return 1000, err
 }
@@ -0,0 +1,135 @@
+package migrator
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/engine"
+	"github.com/umputun/remark/backend/app/store/service"
+)
+
+// Commento implements Importer from commento json export. Commento keeps domain and path of the page only,
+// https scheme assumed for imported urls
+type Commento struct {
+	DataStore Store
+}
+
+type commentoExport struct {
+	Version    int                 `json:"version"`
+	Comments   []commentoComment   `json:"comments"`
+	Commenters []commentoCommenter `json:"commenters"`
+}
+
+type commentoComment struct {
+	CommentHex   string    `json:"commentHex"`
+	Domain       string    `json:"domain"`
+	Path         string    `json:"url"`
+	CommenterHex string    `json:"commenterHex"`
+	Markdown     string    `json:"markdown"`
+	ParentHex    string    `json:"parentHex"`
+	Score        int       `json:"score"`
+	State        string    `json:"state"`
+	CreationDate time.Time `json:"creationDate"`
+	Deleted      bool      `json:"deleted"`
+}
+
+type commentoCommenter struct {
+	CommenterHex string `json:"commenterHex"`
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	Photo        string `json:"photo"`
+	IsModerator  bool   `json:"isModerator"`
+}
+
+const commentoRoot = "root" // parent of top-level comments
+
+// Import comments from commento export and save to store
+func (c *Commento) Import(r io.Reader, siteID string) (size int, err error) {
+	exp := commentoExport{}
+	if err = json.NewDecoder(r).Decode(&exp); err != nil {
+		return 0, errors.Wrap(err, "can't decode commento export")
+	}
+	comments, umetas := c.convert(exp, siteID)
+	return saveComments(c.DataStore, siteID, comments, umetas)
+}
+
+func (c *Commento) convert(exp commentoExport, siteID string) ([]store.Comment, []service.UserMetaData) {
+	commenters := map[string]commentoCommenter{}
+	for _, cr := range exp.Commenters {
+		commenters[cr.CommenterHex] = cr
+	}
+
+	stats := struct {
+		inpComments, rejectedComments int // rejected are deleted or not approved
+	}{}
+
+	commentFormatter := store.NewCommentFormatter()
+	comments := []store.Comment{}
+	parents := map[string]string{}
+	umetas := map[string]service.UserMetaData{}
+	for _, cc := range exp.Comments {
+		stats.inpComments++
+		pid := cc.ParentHex
+		if pid == commentoRoot {
+			pid = ""
+		}
+		parents[cc.CommentHex] = pid
+		if cc.Deleted || (cc.State != "" && cc.State != "approved") {
+			stats.rejectedComments++
+			continue
+		}
+
+		user := store.User{ID: "commento_" + store.EncodeID(cc.CommenterHex), Name: "anonymous"}
+		if cr, ok := commenters[cc.CommenterHex]; ok {
+			user.Name = cr.Name
+			if cr.Photo != "" && cr.Photo != "undefined" {
+				user.Picture = cr.Photo
+			}
+			if cr.Email != "" || cr.IsModerator {
+				umetas[user.ID] = service.UserMetaData{ID: user.ID, Verified: cr.IsModerator,
+					Details: engine.UserDetailEntry{UserID: user.ID, Email: cr.Email}}
+			}
+		}
+
+		comment := store.Comment{
+			ID:        cc.CommentHex,
+			ParentID:  pid,
+			Locator:   store.Locator{SiteID: siteID, URL: commentoURL(cc.Domain, cc.Path)},
+			User:      user,
+			Text:      cc.Markdown,
+			Orig:      cc.Markdown,
+			Score:     cc.Score,
+			Timestamp: cc.CreationDate,
+		}
+		comments = append(comments, commentFormatter.Format(comment))
+	}
+
+	sort.SliceStable(comments, func(i, j int) bool { return comments[i].Timestamp.Before(comments[j].Timestamp) })
+	linkParents(comments, parents)
+
+	res := make([]service.UserMetaData, 0, len(umetas))
+	for _, um := range umetas {
+		res = append(res, um)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	log.Printf("[INFO] converted %d comments, %+v", len(comments), stats)
+	return comments, res
+}
+
+// commentoURL makes page url from domain and path of commento comment
+func commentoURL(domain, path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return "https://" + domain + path
+}
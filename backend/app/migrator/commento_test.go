@@ -0,0 +1,90 @@
+package migrator
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/admin"
+	"github.com/umputun/remark/backend/app/store/engine"
+	"github.com/umputun/remark/backend/app/store/service"
+)
+
+func TestCommento_Import(t *testing.T) {
+	siteID := "testCommento"
+	defer func() { _ = os.Remove("/tmp/remark-test.db") }()
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: "/tmp/remark-test.db", SiteID: siteID})
+	require.NoError(t, err, "create store")
+	dataStore := service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, "")}
+	defer dataStore.Close()
+
+	commento := Commento{DataStore: &dataStore}
+	size, err := commento.Import(strings.NewReader(jsonTestCommento), siteID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+
+	comments, err := dataStore.Find(store.Locator{SiteID: siteID, URL: "https://example.com/blog/post-1"}, "time", adminUser)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(comments))
+
+	c := comments[0]
+	assert.Equal(t, "c1", c.ID)
+	assert.Equal(t, "", c.ParentID)
+	assert.Equal(t, "<p>first <em>comment</em></p>\n", c.Text)
+	assert.Equal(t, "first *comment*", c.Orig)
+	assert.Equal(t, "commento_"+store.EncodeID("u1"), c.User.ID)
+	assert.Equal(t, "User One", c.User.Name)
+	assert.Equal(t, "https://example.com/u1.png", c.User.Picture)
+	assert.Equal(t, 5, c.Score)
+	assert.Equal(t, time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC), c.Timestamp.UTC())
+
+	assert.Equal(t, "c1", comments[1].ParentID)
+	assert.Equal(t, "", comments[1].User.Picture)
+	assert.Equal(t, "c4", comments[2].ID)
+	assert.Equal(t, "c1", comments[2].ParentID, "reply to deleted comment linked to its parent")
+	assert.Equal(t, "anonymous", comments[2].User.Name)
+
+	email, err := dataStore.GetUserEmail(siteID, c.User.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "u1@example.com", email)
+	assert.True(t, dataStore.IsVerified(siteID, c.User.ID), "moderator verified")
+
+	_, err = commento.Import(strings.NewReader("{bad json"), siteID)
+	assert.Error(t, err)
+}
+
+func TestCommento_URL(t *testing.T) {
+	assert.Equal(t, "https://example.com/blog/1", commentoURL("example.com", "/blog/1"))
+	assert.Equal(t, "https://example.com/blog/1", commentoURL("example.com", "blog/1"))
+	assert.Equal(t, "https://example.com", commentoURL("example.com", ""))
+	assert.Equal(t, "http://example.com/1", commentoURL("example.com", "http://example.com/1"))
+}
+
+const jsonTestCommento = `{
+  "version": 1,
+  "comments": [
+    {"commentHex": "c1", "domain": "example.com", "url": "/blog/post-1", "commenterHex": "u1", "markdown": "first *comment*",
+      "html": "<p>first <em>comment</em></p>", "parentHex": "root", "score": 5, "state": "approved",
+      "creationDate": "2020-01-01T10:00:00Z", "direction": 0, "deleted": false},
+    {"commentHex": "c2", "domain": "example.com", "url": "/blog/post-1", "commenterHex": "u2", "markdown": "reply",
+      "parentHex": "c1", "score": 0, "state": "approved", "creationDate": "2020-01-01T11:00:00Z", "deleted": false},
+    {"commentHex": "c3", "domain": "example.com", "url": "/blog/post-1", "commenterHex": "u2", "markdown": "[deleted]",
+      "parentHex": "c1", "score": 0, "state": "approved", "creationDate": "2020-01-01T12:00:00Z", "deleted": true},
+    {"commentHex": "c4", "domain": "example.com", "url": "/blog/post-1", "commenterHex": "anonymous", "markdown": "reply to deleted",
+      "parentHex": "c3", "score": 0, "state": "approved", "creationDate": "2020-01-01T13:00:00Z", "deleted": false},
+    {"commentHex": "c5", "domain": "example.com", "url": "/blog/post-2", "commenterHex": "u2", "markdown": "spam",
+      "parentHex": "root", "score": 0, "state": "flagged", "creationDate": "2020-01-01T14:00:00Z", "deleted": false}
+  ],
+  "commenters": [
+    {"commenterHex": "u1", "email": "u1@example.com", "name": "User One", "link": "https://example.com",
+      "photo": "https://example.com/u1.png", "provider": "commento", "joinDate": "2019-12-01T00:00:00Z", "isModerator": true},
+    {"commenterHex": "u2", "email": "", "name": "User Two", "link": "undefined", "photo": "undefined",
+      "provider": "google", "joinDate": "2019-12-02T00:00:00Z", "isModerator": false}
+  ]
+}`
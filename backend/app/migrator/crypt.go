@@ -0,0 +1,667 @@
+package migrator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encrypted data made in age format (https://age-encryption.org/v1), so backups can be decrypted by age tools too.
+// Header lists file key wrapped with passphrase (scrypt stanza) or for each X25519 recipient and ends with MAC,
+// followed by payload nonce and payload split to chunks, each sealed with ChaCha20-Poly1305. Nonce of the chunk
+// made from its counter and flag of the last chunk, so reordered, truncated or extended payload rejected.
+// Implemented here as age library needs newer go and x/crypto than this module, compatibility with age
+// checked by tests on files made by age and by Crypter
+const (
+	encMagic         = "age-encryption.org/v1\n"
+	encChunkSize     = 64 * 1024
+	encFooter        = "---"
+	encStanzaPrefix  = "-> "
+	encColumns       = 64 // base64 body of the stanza wrapped at 64 columns
+	encX25519        = "X25519"
+	encX25519Info    = "age-encryption.org/v1/X25519"
+	encScrypt        = "scrypt"
+	encScryptLabel   = "age-encryption.org/v1/scrypt"
+	encScryptMaxLogN = 22 // max work factor accepted on decryption
+	encKeyHRP        = "age-secret-key-"
+	encPubHRP        = "age"
+)
+
+// EncryptedExt is the extension added to names of encrypted backup files
+const EncryptedExt = ".enc"
+
+var b64 = base64.RawStdEncoding.Strict()
+
+// scryptLogN is work factor of scrypt for passphrase, the same as age uses
+var scryptLogN = 18
+
+// randReader is the source of keys, salts and nonces, replaced by tests to get reproducible output
+var randReader = rand.Reader
+
+// Crypter encrypts backups with passphrase or for X25519 public keys of recipients, decrypts them with the passphrase
+// or private keys. Keys made by GenerateKey are age keys, i.e. "age1..." public and "AGE-SECRET-KEY-1..." private.
+// Several recipients allow key rotation, backup made for old and new keys can be decrypted by either of them.
+// As in age, passphrase can't be combined with recipients.
+type Crypter struct {
+	Passphrase string   // encrypts and decrypts if set
+	Recipients []string // public keys to encrypt for
+	Identities []string // private keys to decrypt with
+}
+
+// encStanza keeps file key wrapped with key derived from passphrase or from ephemeral key of the recipient
+type encStanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+// Enabled reports if encryption set, i.e. passphrase or recipients defined. Nil Crypter is disabled
+func (c *Crypter) Enabled() bool {
+	return c != nil && (c.Passphrase != "" || len(c.Recipients) > 0)
+}
+
+// Encrypt returns writer encrypting to w. Close of the writer required to complete encrypted data, w not closed
+func (c *Crypter) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if !c.Enabled() {
+		return nil, errors.New("no passphrase or recipients for encryption")
+	}
+	if c.Passphrase != "" && len(c.Recipients) > 0 {
+		return nil, errors.New("passphrase can't be combined with recipients")
+	}
+	fileKey := make([]byte, 16)
+	if _, err := io.ReadFull(randReader, fileKey); err != nil {
+		return nil, errors.Wrap(err, "can't make file key")
+	}
+
+	stanzas := []encStanza{}
+	if c.Passphrase != "" {
+		st, err := scryptStanza(c.Passphrase, fileKey)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, st)
+	}
+	for _, r := range c.Recipients {
+		st, err := x25519Stanza(r, fileKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't encrypt for %s", r)
+		}
+		stanzas = append(stanzas, st)
+	}
+
+	buf := bytes.NewBufferString(encMagic)
+	for _, st := range stanzas {
+		writeStanza(buf, st)
+	}
+	buf.WriteString(encFooter)
+	buf.WriteString(" " + b64.EncodeToString(headerMAC(fileKey, buf.Bytes())) + "\n")
+
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(randReader, nonce); err != nil {
+		return nil, errors.Wrap(err, "can't make payload nonce")
+	}
+	aead, err := chacha20poly1305.New(deriveKey(fileKey, nonce, "payload"))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make cipher")
+	}
+	buf.Write(nonce)
+	if _, err = w.Write(buf.Bytes()); err != nil {
+		return nil, errors.Wrap(err, "can't write header")
+	}
+	return &encWriter{w: w, aead: aead, buf: make([]byte, 0, encChunkSize)}, nil
+}
+
+// Decrypt returns reader decrypting data from r with passphrase or any of private keys
+func (c *Crypter) Decrypt(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(encMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != encMagic {
+		return nil, errors.New("not encrypted data")
+	}
+	stanzas, hdr, mac, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errors.New("can't decrypt, no passphrase or keys")
+	}
+
+	fileKey := c.unwrap(stanzas)
+	if fileKey == nil {
+		return nil, errors.New("can't decrypt, no matching passphrase or key")
+	}
+	if !hmac.Equal(mac, headerMAC(fileKey, hdr)) {
+		return nil, errors.New("can't decrypt, header damaged")
+	}
+	nonce := make([]byte, 16)
+	if _, err = io.ReadFull(br, nonce); err != nil {
+		return nil, errors.Wrap(err, "can't read payload nonce")
+	}
+	aead, err := chacha20poly1305.New(deriveKey(fileKey, nonce, "payload"))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make cipher")
+	}
+	return &decReader{r: br, aead: aead, enc: make([]byte, encChunkSize+aead.Overhead())}, nil
+}
+
+// unwrap returns file key from the first stanza matching passphrase or private keys, nil if nothing matched.
+// Passphrase stanza accepted only as the only stanza of the header
+func (c *Crypter) unwrap(stanzas []encStanza) []byte {
+	for _, st := range stanzas {
+		switch st.Type {
+		case encScrypt:
+			if c.Passphrase == "" || len(stanzas) != 1 {
+				continue
+			}
+			if key, err := scryptUnwrap(c.Passphrase, st); err == nil {
+				return key
+			}
+		case encX25519:
+			for _, id := range c.Identities {
+				if key, err := x25519Unwrap(id, st); err == nil {
+					return key
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// IsEncrypted checks if data starts as encrypted by Crypter
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encMagic))
+}
+
+// IsEncryptedFile checks if file encrypted by Crypter
+func IsEncryptedFile(fname string) bool {
+	fh, err := os.Open(fname) // nolint
+	if err != nil {
+		return false
+	}
+	defer fh.Close() // nolint
+	magic := make([]byte, len(encMagic))
+	if _, err = io.ReadFull(fh, magic); err != nil {
+		return false
+	}
+	return IsEncrypted(magic)
+}
+
+// GenerateKey makes X25519 private key and its public key for encryption of backups
+func GenerateKey() (key, pub string, err error) {
+	id := make([]byte, 32)
+	if _, err = io.ReadFull(randReader, id); err != nil {
+		return "", "", errors.Wrap(err, "can't generate key")
+	}
+	key, err = bech32Encode(encKeyHRP, id)
+	if err != nil {
+		return "", "", errors.Wrap(err, "can't encode key")
+	}
+	key = strings.ToUpper(key)
+	pub, err = PublicKey(key)
+	return key, pub, err
+}
+
+// PublicKey returns public key for private key
+func PublicKey(key string) (string, error) {
+	id, err := parsePrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	var pub [32]byte
+	curve25519.ScalarBaseMult(&pub, id)
+	return bech32Encode(encPubHRP, pub[:])
+}
+
+func parsePrivateKey(key string) (*[32]byte, error) {
+	hrp, data, err := bech32Decode(key)
+	if err != nil || hrp != encKeyHRP || len(data) != 32 {
+		return nil, errors.New("invalid private key")
+	}
+	var res [32]byte
+	copy(res[:], data)
+	return &res, nil
+}
+
+func parsePublicKey(pub string) (*[32]byte, error) {
+	hrp, data, err := bech32Decode(pub)
+	if err != nil || hrp != encPubHRP || len(data) != 32 {
+		return nil, errors.New("invalid public key")
+	}
+	var res [32]byte
+	copy(res[:], data)
+	return &res, nil
+}
+
+// x25519Stanza wraps file key with key agreed between ephemeral key and public key of the recipient
+func x25519Stanza(pub string, fileKey []byte) (encStanza, error) {
+	recipient, err := parsePublicKey(pub)
+	if err != nil {
+		return encStanza{}, err
+	}
+	var ephemeral, share, shared [32]byte
+	if _, err = io.ReadFull(randReader, ephemeral[:]); err != nil {
+		return encStanza{}, errors.Wrap(err, "can't generate ephemeral key")
+	}
+	curve25519.ScalarBaseMult(&share, &ephemeral)
+	curve25519.ScalarMult(&shared, &ephemeral, recipient)
+	if isZero(shared[:]) {
+		return encStanza{}, errors.New("invalid public key")
+	}
+	salt := append(append([]byte{}, share[:]...), recipient[:]...)
+	body, err := wrapKey(deriveKey(shared[:], salt, encX25519Info), fileKey)
+	if err != nil {
+		return encStanza{}, err
+	}
+	return encStanza{Type: encX25519, Args: []string{b64.EncodeToString(share[:])}, Body: body}, nil
+}
+
+func x25519Unwrap(key string, st encStanza) ([]byte, error) {
+	id, err := parsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(st.Args) != 1 {
+		return nil, errors.New("invalid X25519 stanza")
+	}
+	data, err := b64.DecodeString(st.Args[0])
+	if err != nil || len(data) != 32 {
+		return nil, errors.New("invalid X25519 stanza")
+	}
+	var share, shared, recipient [32]byte
+	copy(share[:], data)
+	curve25519.ScalarMult(&shared, id, &share)
+	if isZero(shared[:]) {
+		return nil, errors.New("invalid X25519 stanza")
+	}
+	curve25519.ScalarBaseMult(&recipient, id)
+	salt := append(append([]byte{}, share[:]...), recipient[:]...)
+	return unwrapKey(deriveKey(shared[:], salt, encX25519Info), st.Body)
+}
+
+// scryptStanza wraps file key with key derived from passphrase
+func scryptStanza(passphrase string, fileKey []byte) (encStanza, error) {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(randReader, salt); err != nil {
+		return encStanza{}, errors.Wrap(err, "can't make salt")
+	}
+	kek, err := scrypt.Key([]byte(passphrase), append([]byte(encScryptLabel), salt...), 1<<uint(scryptLogN), 8, 1, 32)
+	if err != nil {
+		return encStanza{}, errors.Wrap(err, "can't derive key from passphrase")
+	}
+	body, err := wrapKey(kek, fileKey)
+	if err != nil {
+		return encStanza{}, err
+	}
+	return encStanza{Type: encScrypt, Args: []string{b64.EncodeToString(salt), strconv.Itoa(scryptLogN)}, Body: body}, nil
+}
+
+func scryptUnwrap(passphrase string, st encStanza) ([]byte, error) {
+	if len(st.Args) != 2 {
+		return nil, errors.New("invalid scrypt stanza")
+	}
+	salt, err := b64.DecodeString(st.Args[0])
+	if err != nil || len(salt) != 16 {
+		return nil, errors.New("invalid scrypt stanza")
+	}
+	logN, err := strconv.Atoi(st.Args[1])
+	if err != nil || logN <= 0 || logN > encScryptMaxLogN || st.Args[1] != strconv.Itoa(logN) {
+		return nil, errors.New("invalid scrypt work factor")
+	}
+	kek, err := scrypt.Key([]byte(passphrase), append([]byte(encScryptLabel), salt...), 1<<uint(logN), 8, 1, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't derive key from passphrase")
+	}
+	return unwrapKey(kek, st.Body)
+}
+
+// wrapKey seals file key with single use key, so zero nonce is safe
+func wrapKey(kek, fileKey []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(kek)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make cipher")
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+func unwrapKey(kek, body []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(kek)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make cipher")
+	}
+	if len(body) != 16+aead.Overhead() {
+		return nil, errors.New("invalid file key size")
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), body, nil)
+}
+
+// deriveKey derives 32 bytes key with HKDF-SHA256
+func deriveKey(secret, salt []byte, info string) []byte {
+	res := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), res); err != nil {
+		panic(err) // can't happen for 32 bytes of sha256 hkdf
+	}
+	return res
+}
+
+// headerMAC returns MAC of the header up to and including footer mark
+func headerMAC(fileKey, hdr []byte) []byte {
+	h := hmac.New(sha256.New, deriveKey(fileKey, nil, "header"))
+	h.Write(hdr) // nolint
+	return h.Sum(nil)
+}
+
+// writeStanza writes stanza line with args followed by base64 body wrapped at 64 columns, the last line
+// of the body always shorter than 64 columns, empty if needed
+func writeStanza(buf *bytes.Buffer, st encStanza) {
+	buf.WriteString(encStanzaPrefix + st.Type)
+	for _, a := range st.Args {
+		buf.WriteString(" " + a)
+	}
+	buf.WriteString("\n")
+	body := b64.EncodeToString(st.Body)
+	for len(body) >= encColumns {
+		buf.WriteString(body[:encColumns] + "\n")
+		body = body[encColumns:]
+	}
+	buf.WriteString(body + "\n")
+}
+
+// readHeader reads stanzas and MAC of the header, returns header bytes covered by MAC
+func readHeader(br *bufio.Reader) (stanzas []encStanza, hdr, mac []byte, err error) {
+	hdrBuf := bytes.NewBufferString(encMagic)
+	readLine := func() (string, error) {
+		line, e := br.ReadString('\n')
+		if e != nil {
+			return "", errors.Wrap(e, "can't read header")
+		}
+		hdrBuf.WriteString(line)
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+
+	for {
+		line, e := readLine()
+		if e != nil {
+			return nil, nil, nil, e
+		}
+		if strings.HasPrefix(line, encFooter+" ") {
+			hdr = hdrBuf.Bytes()[:hdrBuf.Len()-len(line)-1+len(encFooter)]
+			if mac, e = b64.DecodeString(strings.TrimPrefix(line, encFooter+" ")); e != nil || len(mac) != sha256.Size {
+				return nil, nil, nil, errors.New("invalid header MAC")
+			}
+			return stanzas, hdr, mac, nil
+		}
+		if !strings.HasPrefix(line, encStanzaPrefix) {
+			return nil, nil, nil, errors.New("invalid header")
+		}
+		args := strings.Split(strings.TrimPrefix(line, encStanzaPrefix), " ")
+		if args[0] == "" {
+			return nil, nil, nil, errors.New("invalid header stanza")
+		}
+		st := encStanza{Type: args[0], Args: args[1:]}
+		body := ""
+		for {
+			bl, e := readLine()
+			if e != nil {
+				return nil, nil, nil, e
+			}
+			if len(bl) > encColumns {
+				return nil, nil, nil, errors.New("invalid header stanza body")
+			}
+			body += bl
+			if len(bl) < encColumns {
+				break
+			}
+		}
+		if st.Body, e = b64.DecodeString(body); e != nil {
+			return nil, nil, nil, errors.New("invalid header stanza body")
+		}
+		stanzas = append(stanzas, st)
+	}
+}
+
+func isZero(b []byte) bool {
+	return subtle.ConstantTimeCompare(b, make([]byte, len(b))) == 1
+}
+
+// chunkNonce makes nonce of the chunk from its counter and flag of the last chunk
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	err     error
+}
+
+// Write buffers data, full chunk sealed only when more data follows, as the last chunk marked on Close
+func (e *encWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if e.err != nil {
+			return written, e.err
+		}
+		if len(e.buf) == encChunkSize {
+			e.err = e.flush(false)
+			continue
+		}
+		n := copy(e.buf[len(e.buf):encChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, e.err
+}
+
+// Close seals the last chunk
+func (e *encWriter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	e.err = e.flush(true)
+	if e.err == nil {
+		e.err = errors.New("encrypted writer closed")
+		return nil
+	}
+	return e.err
+}
+
+func (e *encWriter) flush(last bool) error {
+	out := e.aead.Seal(nil, chunkNonce(e.counter, last), e.buf, nil)
+	if _, err := e.w.Write(out); err != nil {
+		return errors.Wrap(err, "can't write encrypted data")
+	}
+	e.buf = e.buf[:0]
+	e.counter++
+	return nil
+}
+
+type decReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	enc     []byte
+	buf     []byte
+	counter uint64
+	last    bool
+	err     error
+}
+
+func (d *decReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.last {
+			return 0, io.EOF
+		}
+		d.err = d.next()
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// next reads and opens the next chunk. Short chunk must be the last, full chunk can be the last one too
+func (d *decReader) next() error {
+	n, err := io.ReadFull(d.r, d.enc)
+	switch {
+	case err == io.EOF:
+		return errors.New("truncated encrypted data")
+	case err == io.ErrUnexpectedEOF:
+		return d.open(d.enc[:n], true)
+	case err != nil:
+		return errors.Wrap(err, "can't read encrypted data")
+	}
+	if e := d.open(d.enc, false); e == nil {
+		return nil
+	}
+	if err = d.open(d.enc, true); err != nil {
+		return err
+	}
+	if _, e := io.ReadFull(d.r, make([]byte, 1)); e == nil {
+		return errors.New("unexpected data after the last chunk")
+	}
+	return nil
+}
+
+func (d *decReader) open(data []byte, last bool) error {
+	plain, err := d.aead.Open(d.buf[:0], chunkNonce(d.counter, last), data, nil)
+	if err != nil {
+		return errors.New("can't decrypt, data damaged")
+	}
+	if last && len(plain) == 0 && d.counter > 0 {
+		return errors.New("can't decrypt, data damaged") // empty last chunk allowed for empty payload only
+	}
+	d.buf, d.last = plain, last
+	d.counter++
+	return nil
+}
+
+// bech32 encoding of keys (BIP 173), as used by age
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Gen = []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	res := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		res = append(res, hrp[i]>>5)
+	}
+	res = append(res, 0)
+	for i := 0; i < len(hrp); i++ {
+		res = append(res, hrp[i]&31)
+	}
+	return res
+}
+
+// bech32ConvertBits regroups bits of data, from 8 to 5 bits groups with padding or back without it
+func bech32ConvertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	res := []byte{}
+	maxv := uint32(1)<<to - 1
+	for _, b := range data {
+		if uint32(b)>>from != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<from | uint32(b)
+		bits += from
+		for bits >= to {
+			bits -= to
+			res = append(res, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			res = append(res, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return res, nil
+}
+
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := bech32ConvertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	hrp = strings.ToLower(hrp)
+	chk := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	res := strings.Builder{}
+	res.WriteString(hrp + "1")
+	for _, v := range values {
+		res.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		res.WriteByte(bech32Charset[(chk>>uint(5*(5-i)))&31])
+	}
+	return res.String(), nil
+}
+
+func bech32Decode(s string) (hrp string, data []byte, err error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndex(s, "1")
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator")
+	}
+	hrp = s[:pos]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, errors.New("invalid character")
+		}
+	}
+	values := make([]byte, 0, len(s)-pos-1)
+	for _, c := range s[pos+1:] {
+		v := strings.IndexRune(bech32Charset, c)
+		if v < 0 {
+			return "", nil, errors.New("invalid character")
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+	data, err = bech32ConvertBits(values[:len(values)-6], 5, 8, false)
+	return hrp, data, err
+}
@@ -0,0 +1,209 @@
+package migrator
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrypter_EncryptDecrypt(t *testing.T) {
+	defer func(n int) { scryptLogN = n }(scryptLogN)
+	scryptLogN = 10 // fast passphrase for tests
+
+	key1, pub1, err := GenerateKey()
+	require.NoError(t, err)
+	key2, pub2, err := GenerateKey()
+	require.NoError(t, err)
+	key3, _, err := GenerateKey()
+	require.NoError(t, err)
+
+	tbl := []struct {
+		enc  *Crypter
+		good []*Crypter
+		bad  []*Crypter
+	}{
+		{&Crypter{Recipients: []string{pub1, pub2}},
+			[]*Crypter{{Identities: []string{key1}}, {Identities: []string{key3, key2}}},
+			[]*Crypter{{Identities: []string{key3}}, {Passphrase: "secret"}, {}, nil}},
+		{&Crypter{Passphrase: "secret"},
+			[]*Crypter{{Passphrase: "secret"}, {Passphrase: "secret", Identities: []string{key1}}},
+			[]*Crypter{{Passphrase: "bad"}, {Identities: []string{key1}}, nil}},
+	}
+
+	for i, tt := range tbl {
+		for _, size := range []int{0, 10, encChunkSize - 1, encChunkSize, encChunkSize + 1, 3 * encChunkSize} {
+			data := make([]byte, size)
+			rand.Read(data) // nolint
+			buf := &bytes.Buffer{}
+			w, err := tt.enc.Encrypt(buf)
+			require.NoError(t, err)
+			_, err = w.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			assert.True(t, IsEncrypted(buf.Bytes()))
+			if size >= 100 {
+				assert.False(t, bytes.Contains(buf.Bytes(), data[:100]), "no plain data")
+			}
+
+			for _, dec := range tt.good {
+				r, err := dec.Decrypt(bytes.NewReader(buf.Bytes()))
+				require.NoError(t, err, "case #%d, size %d", i, size)
+				res, err := ioutil.ReadAll(r)
+				require.NoError(t, err, "case #%d, size %d", i, size)
+				assert.Equal(t, data, res, "case #%d, size %d", i, size)
+			}
+
+			for _, dec := range tt.bad {
+				_, err = dec.Decrypt(bytes.NewReader(buf.Bytes()))
+				assert.Error(t, err, "case #%d, size %d", i, size)
+			}
+		}
+	}
+
+	_, err = (&Crypter{Passphrase: "secret", Recipients: []string{pub1}}).Encrypt(&bytes.Buffer{})
+	assert.EqualError(t, err, "passphrase can't be combined with recipients")
+}
+
+func TestCrypter_Damaged(t *testing.T) {
+	key, pub, err := GenerateKey()
+	require.NoError(t, err)
+	c := &Crypter{Recipients: []string{pub}, Identities: []string{key}}
+	data := make([]byte, 2*encChunkSize+100)
+	rand.Read(data) // nolint
+	buf := &bytes.Buffer{}
+	w, err := c.Encrypt(buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	enc := buf.Bytes()
+
+	decrypt := func(inp []byte) error {
+		r, err := c.Decrypt(bytes.NewReader(inp))
+		if err != nil {
+			return err
+		}
+		_, err = ioutil.ReadAll(r)
+		return err
+	}
+	require.NoError(t, decrypt(enc))
+
+	changed := append([]byte{}, enc...)
+	changed[len(changed)-200] ^= 1
+	assert.EqualError(t, decrypt(changed), "can't decrypt, data damaged")
+
+	hdrSize := len(enc) - (2*(encChunkSize+16) + 100 + 16)
+	assert.EqualError(t, decrypt(enc[:hdrSize+encChunkSize+16]), "truncated encrypted data", "last chunk dropped")
+	assert.EqualError(t, decrypt(enc[:len(enc)-10]), "can't decrypt, data damaged")
+	assert.Error(t, decrypt(append(append([]byte{}, enc...), 1, 2, 3)), "data appended")
+	assert.EqualError(t, decrypt([]byte("plain data")), "not encrypted data")
+}
+
+func TestCrypter_Keys(t *testing.T) {
+	key, pub, err := GenerateKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, "AGE-SECRET-KEY-1"), key)
+	assert.True(t, strings.HasPrefix(pub, "age1"), pub)
+	p, err := PublicKey(key)
+	require.NoError(t, err)
+	assert.Equal(t, pub, p)
+
+	_, err = PublicKey("bad")
+	assert.EqualError(t, err, "invalid private key")
+
+	assert.False(t, (&Crypter{}).Enabled())
+	assert.False(t, (*Crypter)(nil).Enabled())
+	_, err = (&Crypter{}).Encrypt(&bytes.Buffer{})
+	assert.EqualError(t, err, "no passphrase or recipients for encryption")
+	_, err = (&Crypter{Recipients: []string{"age1bad"}}).Encrypt(&bytes.Buffer{})
+	assert.EqualError(t, err, "can't encrypt for age1bad: invalid public key")
+	_, err = (&Crypter{Recipients: []string{strings.ToLower(key)}}).Encrypt(&bytes.Buffer{})
+	assert.Error(t, err, "private key is not a recipient")
+
+	// test vectors of BIP 173 and age
+	_, data, err := bech32Decode("A12UEL5L")
+	require.NoError(t, err)
+	assert.Empty(t, data)
+	_, _, err = bech32Decode("A12UEL5l")
+	assert.Error(t, err, "mixed case")
+	_, _, err = bech32Decode("a12uel5m")
+	assert.Error(t, err, "bad checksum")
+	p, err = PublicKey("AGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX")
+	require.NoError(t, err)
+	assert.Equal(t, "age1zvkyg2lqzraa2lnjvqej32nkuu0ues2s82hzrye869xeexvn73equnujwj", p)
+}
+
+// files made by age v1.0.0: age-example.age from age repo, age-x25519.age for two recipients
+// and age-scrypt.age with passphrase
+func TestCrypter_DecryptAge(t *testing.T) {
+	tbl := []struct {
+		file string
+		dec  *Crypter
+		res  []byte
+	}{
+		{"testdata/age-example.age", &Crypter{Identities: []string{"AGE-SECRET-KEY-184JMZMVQH3E6U0PSL869004Y3U2NYV7R30EU99CSEDNPH02YUVFSZW44VU"}},
+			[]byte("Black lives matter.")},
+		{"testdata/age-x25519.age", &Crypter{Identities: []string{ageKey1}}, agePayload(70000)},
+		{"testdata/age-x25519.age", &Crypter{Identities: []string{ageKey2}}, agePayload(70000)},
+		{"testdata/age-scrypt.age", &Crypter{Passphrase: "remark42 passphrase"}, []byte("remark42 backup encrypted by age\n")},
+	}
+	for i, tt := range tbl {
+		data, err := ioutil.ReadFile(tt.file)
+		require.NoError(t, err)
+		assert.True(t, IsEncrypted(data), "case #%d", i)
+		r, err := tt.dec.Decrypt(bytes.NewReader(data))
+		require.NoError(t, err, "case #%d", i)
+		res, err := ioutil.ReadAll(r)
+		require.NoError(t, err, "case #%d", i)
+		assert.Equal(t, tt.res, res, "case #%d", i)
+	}
+}
+
+// testdata/remark-*.age made by Crypter with the same random source and checked to be decrypted by age v1.0.0
+func TestCrypter_EncryptAge(t *testing.T) {
+	defer func(r io.Reader) { randReader = r }(randReader)
+
+	tbl := []struct {
+		file string
+		enc  *Crypter
+		data []byte
+	}{
+		{"testdata/remark-x25519.age", &Crypter{Recipients: []string{agePub1, agePub2}}, agePayload(70000)},
+		{"testdata/remark-scrypt.age", &Crypter{Passphrase: "remark42 passphrase"}, []byte("remark42 backup encrypted by age\n")},
+	}
+	for i, tt := range tbl {
+		randReader = rand.New(rand.NewSource(42)) // nolint
+		buf := &bytes.Buffer{}
+		w, err := tt.enc.Encrypt(buf)
+		require.NoError(t, err)
+		_, err = w.Write(tt.data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		expected, err := ioutil.ReadFile(tt.file)
+		require.NoError(t, err)
+		assert.Equal(t, expected, buf.Bytes(), "case #%d", i)
+	}
+}
+
+const (
+	ageKey1 = "AGE-SECRET-KEY-17RWZCPY377GNWCPTFFCCYRUUVU68GL7UAPGFU0ALSWEXDHXJCJVQ4L7YPS"
+	agePub1 = "age1n5eawwfrl2uvjgaakhrcrtv60pyqgpt0t44xynsu4q4mz3lkya7sdp6vmz"
+	ageKey2 = "AGE-SECRET-KEY-1FPKNY4778NTNQ08XGT4QYPFXH0DM3LFZV253T3WAULKGKRPYZ8WSVDQ6FX"
+	agePub2 = "age16swy5m3v652sllz20udu2u0xrp4hf06n9hexu5lav8h80r54avfsyq7fut"
+)
+
+// agePayload makes data of size n encrypted in age test files
+func agePayload(n int) []byte {
+	res := make([]byte, n)
+	for i := range res {
+		res[i] = byte(i*7 + i/251)
+	}
+	return res
+}
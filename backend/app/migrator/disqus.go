@@ -6,15 +6,19 @@ import (
 	"strings"
 	"time"
 
+	"github.com/go-pkgz/auth/token"
 	log "github.com/go-pkgz/lgr"
 	"github.com/pkg/errors"
 
 	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/service"
 )
 
-// Disqus implements Importer from disqus xml
+// Disqus implements Importer from disqus xml and Exporter to disqus xml
 type Disqus struct {
-	DataStore Store
+	DataStore   Store
+	UserMapping map[string]string // disqus username or email to remark42 user id, optional
+	AvatarProxy AvatarProxy       // stores avatars of disqus users, not imported if nil
 }
 
 type disqusThread struct {
@@ -40,10 +44,19 @@ type disqusComment struct {
 	AuthorEmail    string    `xml:"author>email"`
 	AuthorName     string    `xml:"author>name"`
 	AuthorUserName string    `xml:"author>username"`
+	AuthorAvatar   string    `xml:"author>avatar>permalink"`
 	IP             string    `xml:"ipAddress"`
 	Tid            uid       `xml:"thread"`
 	Pid            uid       `xml:"parent"`
 	IsSpam         bool      `xml:"isSpam"`
+	IsDeleted      bool      `xml:"isDeleted"`
+	Likes          int       `xml:"likes"`
+	Dislikes       int       `xml:"dislikes"`
+}
+
+// disqusMeta collects posts metas of converted threads, filled when comments channel closed
+type disqusMeta struct {
+	posts []service.PostMetaData
 }
 
 type uid struct {
@@ -57,16 +70,30 @@ func (d *Disqus) Import(r io.Reader, siteID string) (size int, err error) {
 		return 0, err
 	}
 
-	commentsCh := d.convert(r, siteID)
+	commentsCh, meta := d.convert(r, siteID)
 	failed, passed := 0, 0
+	imported := map[string]bool{} // urls of posts with imported comments
 	for c := range commentsCh {
-		if _, err = d.DataStore.Create(c); err != nil {
+		if _, err = d.DataStore.Import(c); err != nil {
 			failed++
 			continue
 		}
+		imported[c.Locator.URL] = true
 		passed++
 	}
 
+	pmetas := []service.PostMetaData{}
+	for _, pm := range meta.posts {
+		if imported[pm.URL] {
+			pmetas = append(pmetas, pm)
+		}
+	}
+	if len(pmetas) > 0 {
+		if e := d.DataStore.SetMetas(siteID, nil, pmetas); e != nil {
+			log.Printf("[WARN] can't set posts metas, %v", e)
+		}
+	}
+
 	if failed > 0 {
 		err = errors.Errorf("failed to save %d comments", failed)
 		if passed == 0 {
@@ -80,17 +107,23 @@ func (d *Disqus) Import(r io.Reader, siteID string) (size int, err error) {
 }
 
 // convert disqus stream (xml) from reader and fill channel of comments.
-// runs async and closes channel on completion.
-func (d *Disqus) convert(r io.Reader, siteID string) (ch chan store.Comment) {
+// runs async and closes channel on completion. Spam and deleted comments converted to deleted ones,
+// closed threads collected in returned meta as read-only posts.
+func (d *Disqus) convert(r io.Reader, siteID string) (ch chan store.Comment, meta *disqusMeta) {
 
 	postsMap := map[string]string{} // tid:url
 	decoder := xml.NewDecoder(r)
 	commentsCh := make(chan store.Comment)
+	meta = &disqusMeta{}
+	avatars := map[string]string{} // disqus avatar url:proxied url
 
 	stats := struct {
-		inpThreads, inpComments     int
-		commentsCount, spamComments int
-		failedThreads, failedPosts  int
+		inpThreads, inpComments      int
+		commentsCount, spamComments  int
+		deletedComments, mappedUsers int
+		failedThreads, failedPosts   int
+		failedAvatars                int
+		closedThreads                int
 	}{}
 
 	go func() {
@@ -111,6 +144,10 @@ func (d *Disqus) convert(r io.Reader, siteID string) (ch chan store.Comment) {
 						continue
 					}
 					postsMap[thread.UID] = thread.Link
+					if thread.Closed {
+						stats.closedThreads++
+						meta.posts = append(meta.posts, service.PostMetaData{URL: thread.Link, ReadOnly: true})
+					}
 					continue
 				}
 				if se.Name.Local == "post" {
@@ -121,10 +158,6 @@ func (d *Disqus) convert(r io.Reader, siteID string) (ch chan store.Comment) {
 						stats.failedPosts++
 						continue
 					}
-					if comment.IsSpam {
-						stats.spamComments++
-						continue
-					}
 					c := store.Comment{
 						ID:      comment.UID,
 						Locator: store.Locator{URL: postsMap[comment.Tid.Val], SiteID: siteID},
@@ -136,13 +169,38 @@ func (d *Disqus) convert(r io.Reader, siteID string) (ch chan store.Comment) {
 						Text:      d.cleanText(comment.Message),
 						Timestamp: comment.CreatedAt,
 						ParentID:  comment.Pid.Val,
+						Score:     comment.Likes - comment.Dislikes,
 					}
 					if c.User.ID == "disqus_" { // empty comment.AuthorUserName from disqus
 						c.User.ID = "disqus_" + c.User.Name
 					}
+					if comment.AuthorAvatar != "" && d.AvatarProxy != nil {
+						if _, ok := avatars[comment.AuthorAvatar]; !ok {
+							// stored for disqus user, mapped user keeps own avatar in store
+							u := token.User{ID: c.User.ID, Name: c.User.Name, Picture: comment.AuthorAvatar}
+							url, e := d.AvatarProxy.Put(u)
+							if e != nil {
+								log.Printf("[WARN] can't store avatar %s of %s, %v", comment.AuthorAvatar, c.User.ID, e)
+								stats.failedAvatars++
+							}
+							avatars[comment.AuthorAvatar] = url // failed one not retried, imported without picture
+						}
+						c.User.Picture = avatars[comment.AuthorAvatar]
+					}
+					if id, ok := d.mappedUser(comment); ok {
+						c.User.ID = id
+						stats.mappedUsers++
+					}
 					if c.ID == "" { // no comment.UID
 						c.ID = comment.ID
 					}
+					if comment.IsSpam || comment.IsDeleted {
+						if comment.IsSpam {
+							stats.spamComments++
+						}
+						stats.deletedComments++
+						c.SetDeleted(store.SoftDelete)
+					}
 					commentsCh <- c
 					stats.commentsCount++
 					if stats.commentsCount%1000 == 0 {
@@ -155,7 +213,23 @@ func (d *Disqus) convert(r io.Reader, siteID string) (ch chan store.Comment) {
 		log.Printf("[INFO] converted %d posts, %+v", len(postsMap), stats)
 	}()
 
-	return commentsCh
+	return commentsCh, meta
+}
+
+// mappedUser returns remark42 user id for author of the comment from UserMapping, by username or email.
+// Emails matched case-insensitive.
+func (d *Disqus) mappedUser(comment disqusComment) (string, bool) {
+	if comment.AuthorUserName != "" {
+		if id, ok := d.UserMapping[comment.AuthorUserName]; ok {
+			return id, true
+		}
+	}
+	if comment.AuthorEmail != "" { // emails in mapping lower-cased by LoadUserMapping
+		if id, ok := d.UserMapping[strings.ToLower(comment.AuthorEmail)]; ok {
+			return id, true
+		}
+	}
+	return "", false
 }
 
 func (*Disqus) cleanText(text string) string {
@@ -163,3 +237,128 @@ func (*Disqus) cleanText(text string) string {
 	text = strings.Replace(text, "\t", "", -1)
 	return text
 }
+
+const disqusTimeLayout = "2006-01-02T15:04:05Z"
+
+type disqusExportThread struct {
+	XMLName   xml.Name           `xml:"thread"`
+	UID       string             `xml:"dsq:id,attr"`
+	ID        string             `xml:"id"`
+	Forum     string             `xml:"forum"`
+	Category  disqusExportRef    `xml:"category"`
+	Link      string             `xml:"link"`
+	Title     string             `xml:"title"`
+	Message   string             `xml:"message"`
+	CreatedAt string             `xml:"createdAt"`
+	Author    disqusExportAuthor `xml:"author"`
+	Closed    bool               `xml:"isClosed"`
+	Deleted   bool               `xml:"isDeleted"`
+}
+
+type disqusExportPost struct {
+	XMLName   xml.Name           `xml:"post"`
+	UID       string             `xml:"dsq:id,attr"`
+	ID        string             `xml:"id"`
+	Message   disqusExportText   `xml:"message"`
+	CreatedAt string             `xml:"createdAt"`
+	Deleted   bool               `xml:"isDeleted"`
+	Spam      bool               `xml:"isSpam"`
+	Author    disqusExportAuthor `xml:"author"`
+	IP        string             `xml:"ipAddress"` // always empty, only hash of ip stored
+	Thread    disqusExportRef    `xml:"thread"`
+	Parent    *disqusExportRef   `xml:"parent,omitempty"`
+}
+
+type disqusExportAuthor struct {
+	Email     string `xml:"email,omitempty"`
+	Name      string `xml:"name"`
+	Anonymous bool   `xml:"isAnonymous"`
+	UserName  string `xml:"username,omitempty"`
+}
+
+type disqusExportRef struct {
+	UID string `xml:"dsq:id,attr"`
+}
+
+type disqusExportText struct {
+	Text string `xml:",cdata"`
+}
+
+const disqusExportHeader = `<?xml version="1.0" encoding="utf-8"?>
+<disqus xmlns="http://disqus.com" xmlns:dsq="http://disqus.com/disqus-internals" ` +
+	`xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" ` +
+	`xsi:schemaLocation="http://disqus.com/api/schemas/1.0/disqus.xsd http://disqus.com/api/schemas/1.0/disqus-internals.xsd">
+`
+
+// Export all comments of the site to disqus xml. Threads written first, followed by all comments.
+// Deleted comments marked with isDeleted and comments of blocked users with isSpam
+func (d *Disqus) Export(w io.Writer, siteID string) (size int, err error) {
+	users, posts, err := exportMetas(d.DataStore, siteID)
+	if err != nil {
+		return 0, err
+	}
+	topics, err := d.DataStore.List(siteID, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = io.WriteString(w, disqusExportHeader); err != nil {
+		return 0, errors.Wrap(err, "can't write disqus header")
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("  ", "  ")
+	category := disqusExportRef{UID: "1"}
+	if err = enc.Encode(struct {
+		XMLName   xml.Name `xml:"category"`
+		UID       string   `xml:"dsq:id,attr"`
+		Forum     string   `xml:"forum"`
+		Title     string   `xml:"title"`
+		IsDefault bool     `xml:"isDefault"`
+	}{UID: category.UID, Forum: siteID, Title: "General", IsDefault: true}); err != nil {
+		return 0, errors.Wrap(err, "can't write disqus category")
+	}
+
+	// topics from List sorted in opposite direction, comments of topics kept to be written after all threads
+	topicComments := make([][]store.Comment, len(topics))
+	for i := len(topics) - 1; i >= 0; i-- {
+		topic := topics[i]
+		comments, e := d.DataStore.Find(store.Locator{SiteID: siteID, URL: topic.URL}, "time", adminUser)
+		if e != nil {
+			return 0, e
+		}
+		topicComments[i] = comments
+		thread := disqusExportThread{UID: store.EncodeID(topic.URL), ID: topic.URL, Forum: siteID, Category: category,
+			Link: topic.URL, Title: topic.URL, CreatedAt: topic.FirstTS.UTC().Format(disqusTimeLayout),
+			Author: disqusExportAuthor{Name: siteID, Anonymous: true}, Closed: topic.ReadOnly || posts[topic.URL].ReadOnly}
+		if title := exportTitle(comments); title != "" {
+			thread.Title = title
+		}
+		if err = enc.Encode(thread); err != nil {
+			return 0, errors.Wrapf(err, "can't write disqus thread %s", topic.URL)
+		}
+	}
+
+	for i := len(topics) - 1; i >= 0; i-- {
+		topic := topics[i]
+		for _, c := range topicComments[i] {
+			post := disqusExportPost{UID: c.ID, ID: c.ID, Message: disqusExportText{Text: c.Text},
+				CreatedAt: c.Timestamp.UTC().Format(disqusTimeLayout), Deleted: c.Deleted, Spam: users[c.User.ID].Blocked.Status,
+				Author: disqusExportAuthor{Email: users[c.User.ID].Details.Email, Name: c.User.Name, UserName: c.User.ID,
+					Anonymous: strings.HasPrefix(c.User.ID, "anonymous_")},
+				Thread: disqusExportRef{UID: store.EncodeID(topic.URL)}}
+			if c.ParentID != "" {
+				post.Parent = &disqusExportRef{UID: c.ParentID}
+			}
+			if err = enc.Encode(post); err != nil {
+				return size, errors.Wrapf(err, "can't write disqus post %s", c.ID)
+			}
+			size++
+		}
+	}
+
+	if _, err = io.WriteString(w, "\n</disqus>\n"); err != nil {
+		return size, errors.Wrap(err, "can't write disqus footer")
+	}
+	log.Printf("[DEBUG] exported %d comments of %d threads to disqus xml", size, len(topics))
+	return size, nil
+}
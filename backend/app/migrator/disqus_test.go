@@ -1,11 +1,14 @@
 package migrator
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-pkgz/auth/token"
 	bolt "go.etcd.io/bbolt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -22,14 +25,16 @@ func TestDisqus_Import(t *testing.T) {
 	require.NoError(t, err, "create store")
 	dataStore := service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, "")}
 	defer dataStore.Close()
-	d := Disqus{DataStore: &dataStore}
+	proxy := &avatarProxyMock{err: errors.New("failed")}
+	d := Disqus{DataStore: &dataStore, UserMapping: map[string]string{"mihail.noname@gmail.com": "github_mikhail"},
+		AvatarProxy: proxy}
 	size, err := d.Import(strings.NewReader(xmlTestDisqus), "test")
 	assert.NoError(t, err)
-	assert.Equal(t, 4, size)
+	assert.Equal(t, 5, size)
 
 	last, err := dataStore.Last("test", 10, time.Time{}, adminUser)
 	assert.NoError(t, err)
-	require.Equal(t, 4, len(last), "4 comments imported")
+	require.Equal(t, 4, len(last), "4 comments imported, spam imported as deleted")
 
 	c := last[len(last)-1] // last reverses, get first one
 	assert.True(t, strings.HasPrefix(c.Text, "<p>The quick brown fox"))
@@ -39,6 +44,10 @@ func TestDisqus_Import(t *testing.T) {
 	assert.Equal(t, "Alexander Blah", c.User.Name)
 	assert.Equal(t, "disqus_328c8b68974aef73785f6b38c3d3fedfdf941434", c.User.ID)
 	assert.Equal(t, "2ba6b71dbf9750ae3356cce14cac6c1b1962747c", c.User.IP)
+	assert.Equal(t, "", c.User.Picture, "failed avatar not imported")
+	assert.Equal(t, 1, len(proxy.users), "failed avatar not retried")
+	assert.Equal(t, 3, c.Score)
+	assert.Equal(t, "github_mikhail", last[len(last)-2].User.ID, "user mapped by email, case-insensitive")
 
 	posts, err := dataStore.List("test", 0, 0)
 	assert.NoError(t, err)
@@ -46,18 +55,31 @@ func TestDisqus_Import(t *testing.T) {
 
 	count, err := dataStore.Count(store.Locator{SiteID: "test", URL: "https://radio-t.com/p/2011/03/05/podcast-229/"})
 	assert.NoError(t, err)
-	assert.Equal(t, 2, count)
+	assert.Equal(t, 3, count)
+
+	comments, err := dataStore.Find(store.Locator{SiteID: "test", URL: "https://radio-t.com/p/2011/03/05/podcast-229/"}, "time", adminUser)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(comments))
+	assert.Equal(t, "299986073", comments[2].ID)
+	assert.True(t, comments[2].Deleted, "spam comment imported as deleted")
+	assert.Equal(t, "", comments[2].Text)
+
+	assert.True(t, dataStore.IsReadOnly(store.Locator{SiteID: "test", URL: "https://radio-t.com/p/2011/03/05/podcast-229/"}),
+		"closed thread imported as read-only post")
+	assert.False(t, dataStore.IsReadOnly(store.Locator{SiteID: "test", URL: "http://radio-t.umputun.com/2011/03/229_8880.html"}))
 }
 
 func TestDisqus_Convert(t *testing.T) {
-	d := Disqus{}
-	ch := d.convert(strings.NewReader(xmlTestDisqus), "test")
+	proxy := &avatarProxyMock{}
+	d := Disqus{UserMapping: map[string]string{"facebook-1787732238": "facebook_blah"}, AvatarProxy: proxy}
+	ch, meta := d.convert(strings.NewReader(xmlTestDisqus), "test")
 
 	res := []store.Comment{}
 	for comment := range ch {
 		res = append(res, comment)
 	}
-	require.Equal(t, 4, len(res), "4 comments total, 1 spam excluded, 1 bad excluded")
+	require.Equal(t, 5, len(res), "5 comments total, 1 bad excluded")
+	assert.Equal(t, []service.PostMetaData{{URL: "https://radio-t.com/p/2011/03/05/podcast-229/", ReadOnly: true}}, meta.posts)
 
 	exp0 := store.Comment{
 		ID: "299619020",
@@ -67,13 +89,78 @@ func TestDisqus_Convert(t *testing.T) {
 		},
 		Text: `<p>The quick brown fox jumps over the lazy dog.</p><p><a href="https://https://radio-t.com" rel="nofollow noopener" title="radio-t">some link</a></p>`,
 		User: store.User{
-			Name: "Alexander Blah",
-			ID:   "disqus_328c8b68974aef73785f6b38c3d3fedfdf941434",
-			IP:   "178.178.178.178",
+			Name:    "Alexander Blah",
+			ID:      "facebook_blah",
+			Picture: "http://localhost/api/v1/avatar/" + "disqus_" + store.EncodeID("facebook-1787732238"),
+			IP:      "178.178.178.178",
 		},
+		Score: 3,
 	}
 	exp0.Timestamp, _ = time.Parse("2006-01-02T15:04:05Z", "2011-08-31T15:16:29Z")
 	assert.Equal(t, exp0, res[0])
+	assert.Equal(t, []token.User{{ID: "disqus_" + store.EncodeID("facebook-1787732238"), Name: "Alexander Blah",
+		Picture: "https://disqus.com/api/users/avatars/blah.jpg"}}, proxy.users, "avatar stored for disqus user")
+	assert.Equal(t, "", res[1].User.Picture, "no avatar")
+
+	assert.Equal(t, "299986073", res[4].ID)
+	assert.True(t, res[4].Deleted, "spam converted to deleted")
+	assert.Equal(t, "", res[4].Text)
+	assert.Equal(t, "disqus_"+store.EncodeID("google-2c5d77590123"), res[4].User.ID)
+}
+
+func TestDisqus_Export(t *testing.T) {
+	b, teardown := prep(t) // write 2 comments
+	defer teardown()
+	_, err := b.Create(store.Comment{ID: "reply", ParentID: "efbc17f177ee1a1c0ee6e1e025749966ec071adc", Text: "reply ]]> text",
+		Timestamp: time.Date(2017, 12, 20, 23, 19, 22, 0, time.UTC), PostTitle: "post title",
+		Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, User: store.User{ID: "user2", Name: "user2 name", IP: "ip-hash"}})
+	require.NoError(t, err)
+	require.NoError(t, b.Delete(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "reply", store.SoftDelete))
+	_, err = b.SetUserEmail("radio-t", "user1", "user1@example.com")
+	require.NoError(t, err)
+	require.NoError(t, b.SetBlock("radio-t", "user2", true, time.Hour))
+	require.NoError(t, b.SetReadOnly(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, true))
+
+	d := Disqus{DataStore: b}
+	buf := &bytes.Buffer{}
+	size, err := d.Export(buf, "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+	t.Log(buf.String())
+
+	res := buf.String()
+	assert.True(t, strings.HasPrefix(res, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+`<disqus xmlns="http://disqus.com"`))
+	assert.True(t, strings.HasSuffix(res, "</disqus>\n"))
+	assert.Contains(t, res, `<category dsq:id="1">`)
+	assert.Contains(t, res, `<thread dsq:id="`+store.EncodeID("https://radio-t.com")+`">`)
+	assert.Contains(t, res, "<title>post title</title>")
+	assert.Contains(t, res, "<isClosed>true</isClosed>")
+	assert.Contains(t, res, `<post dsq:id="efbc17f177ee1a1c0ee6e1e025749966ec071adc">`)
+	assert.Contains(t, res, "<createdAt>2017-12-20T23:19:22Z</createdAt>")
+	assert.Contains(t, res, "<email>user1@example.com</email>")
+	assert.Contains(t, res, `<parent dsq:id="efbc17f177ee1a1c0ee6e1e025749966ec071adc"></parent>`)
+	assert.Contains(t, res, "<isDeleted>true</isDeleted>")
+	assert.Contains(t, res, "<isSpam>true</isSpam>")
+	assert.NotContains(t, res, "ip-hash", "hashed ip not exported")
+	assert.True(t, strings.Index(res, "<thread dsq:id") < strings.Index(res, "<post dsq:id"), "threads before posts")
+
+	// exported file readable by disqus importer, spam and deleted comments converted to deleted
+	var comments []store.Comment
+	ch, meta := d.convert(strings.NewReader(res), "radio-t")
+	for c := range ch {
+		comments = append(comments, c)
+	}
+	require.Equal(t, 3, len(comments))
+	assert.Equal(t, "efbc17f177ee1a1c0ee6e1e025749966ec071adc", comments[0].ID)
+	assert.Equal(t, "https://radio-t.com", comments[0].Locator.URL)
+	assert.Equal(t, `some text, <a href="http://radio-t.com" rel="nofollow">link</a>`, comments[0].Text)
+	assert.Equal(t, "user name", comments[0].User.Name)
+	assert.False(t, comments[0].Deleted)
+	assert.Equal(t, "reply", comments[1].ID)
+	assert.Equal(t, "efbc17f177ee1a1c0ee6e1e025749966ec071adc", comments[1].ParentID)
+	assert.True(t, comments[1].Deleted)
+	assert.True(t, comments[2].Deleted)
+	assert.Equal(t, []service.PostMetaData{{URL: "https://radio-t.com/2", ReadOnly: true}}, meta.posts)
 }
 
 var xmlTestDisqus = `<?xml version="1.0" encoding="utf-8"?>
@@ -137,9 +224,14 @@ var xmlTestDisqus = `<?xml version="1.0" encoding="utf-8"?>
 			<name>Alexander Blah</name>
 			<isAnonymous>false</isAnonymous>
 			<username>facebook-1787732238</username>
+			<avatar>
+				<permalink>https://disqus.com/api/users/avatars/blah.jpg</permalink>
+			</avatar>
 		</author>
 		<ipAddress>178.178.178.178</ipAddress>
 		<thread dsq:id="247937687"/>
+		<likes>4</likes>
+		<dislikes>1</dislikes>
 	</post>
 
 	<post dsq:id="299744309">
@@ -151,7 +243,7 @@ var xmlTestDisqus = `<?xml version="1.0" encoding="utf-8"?>
 		<isDeleted>false</isDeleted>
 		<isSpam>false</isSpam>
 		<author>
-			<email>mihail.noname@gmail.com</email>
+			<email>Mihail.Noname@gmail.com</email>
 			<name>mikhail</name>
 			<isAnonymous>false</isAnonymous>
 			<username>mikhail-noname</username>
@@ -228,3 +320,16 @@ var xmlTestDisqus = `<?xml version="1.0" encoding="utf-8"?>
 
 </disqus>
 `
+
+type avatarProxyMock struct {
+	users []token.User
+	err   error
+}
+
+func (m *avatarProxyMock) Put(u token.User) (string, error) {
+	m.users = append(m.users, u)
+	if m.err != nil {
+		return "", m.err
+	}
+	return "http://localhost/api/v1/avatar/" + u.ID, nil
+}
@@ -0,0 +1,155 @@
+package migrator
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/engine"
+	"github.com/umputun/remark/backend/app/store/service"
+)
+
+// Isso implements Importer from isso sqlite database
+type Isso struct {
+	DataStore Store
+	BaseURL   string // site url prepended to thread uris, isso keeps path of the page only
+}
+
+// isso comment modes
+const (
+	issoAccepted = 1
+	issoDeleted  = 4
+)
+
+type issoThread struct {
+	uri   string
+	title string
+}
+
+// Import comments from isso database and save to store
+func (i *Isso) Import(r io.Reader, siteID string) (size int, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't read isso database")
+	}
+	db, err := newSqliteDB(data)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't open isso database")
+	}
+	comments, umetas, err := i.convert(db, siteID)
+	if err != nil {
+		return 0, err
+	}
+	return saveComments(i.DataStore, siteID, comments, umetas)
+}
+
+func (i *Isso) convert(db *sqliteDB, siteID string) ([]store.Comment, []service.UserMetaData, error) {
+	threadRows, err := db.Rows("threads")
+	if err != nil {
+		return nil, nil, err
+	}
+	threads := map[int64]issoThread{}
+	for _, row := range threadRows {
+		id, _ := row["id"].(int64)
+		threads[id] = issoThread{uri: toString(row["uri"]), title: toString(row["title"])}
+	}
+
+	commentRows, err := db.Rows("comments")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats := struct {
+		inpComments, rejectedComments, failedComments int // rejected are deleted or not accepted
+	}{}
+
+	commentFormatter := store.NewCommentFormatter()
+	comments := []store.Comment{}
+	parents := map[string]string{}
+	umetas := map[string]service.UserMetaData{}
+	for _, row := range commentRows {
+		stats.inpComments++
+		id, _ := row["id"].(int64)
+		pid := ""
+		if p, ok := row["parent"].(int64); ok {
+			pid = strconv.FormatInt(p, 10)
+		}
+		parents[strconv.FormatInt(id, 10)] = pid
+
+		if mode, _ := row["mode"].(int64); mode != issoAccepted {
+			stats.rejectedComments++
+			continue
+		}
+		tid, _ := row["tid"].(int64)
+		thread, ok := threads[tid]
+		if !ok {
+			stats.failedComments++
+			log.Printf("[WARN] no thread %d for comment %d", tid, id)
+			continue
+		}
+
+		author, email := toString(row["author"]), toString(row["email"])
+		user := store.User{Name: author, IP: toString(row["remote_addr"])}
+		user.ID = "isso_" + store.EncodeID(author+email)
+		if author == "" {
+			user.Name = "anonymous"
+		}
+		if email != "" {
+			umetas[user.ID] = service.UserMetaData{ID: user.ID, Details: engine.UserDetailEntry{UserID: user.ID, Email: email}}
+		}
+
+		likes, _ := row["likes"].(int64)
+		dislikes, _ := row["dislikes"].(int64)
+		text := toString(row["text"])
+		comment := store.Comment{
+			ID:        strconv.FormatInt(id, 10),
+			ParentID:  pid,
+			Locator:   store.Locator{SiteID: siteID, URL: i.url(thread.uri)},
+			User:      user,
+			Text:      text,
+			Orig:      text,
+			Score:     int(likes - dislikes),
+			Timestamp: issoTime(row["created"]),
+			PostTitle: thread.title,
+		}
+		comments = append(comments, commentFormatter.Format(comment))
+	}
+
+	linkParents(comments, parents)
+
+	res := make([]service.UserMetaData, 0, len(umetas))
+	for _, um := range umetas {
+		res = append(res, um)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	log.Printf("[INFO] converted %d comments, %+v", len(comments), stats)
+	return comments, res, nil
+}
+
+// url makes page url from isso thread uri
+func (i *Isso) url(uri string) string {
+	if i.BaseURL == "" || strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+		return uri
+	}
+	return strings.TrimSuffix(i.BaseURL, "/") + "/" + strings.TrimPrefix(uri, "/")
+}
+
+// issoTime converts isso timestamp, unix time in seconds with fraction
+func issoTime(v interface{}) time.Time {
+	switch ts := v.(type) {
+	case float64:
+		sec, frac := math.Modf(ts)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+	case int64:
+		return time.Unix(ts, 0).UTC()
+	}
+	return time.Time{}
+}
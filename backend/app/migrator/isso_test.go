@@ -0,0 +1,73 @@
+package migrator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/admin"
+	"github.com/umputun/remark/backend/app/store/engine"
+	"github.com/umputun/remark/backend/app/store/service"
+)
+
+func TestIsso_Import(t *testing.T) {
+	siteID := "testIsso"
+	defer func() { _ = os.Remove("/tmp/remark-test.db") }()
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: "/tmp/remark-test.db", SiteID: siteID})
+	require.NoError(t, err, "create store")
+	dataStore := service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, "")}
+	defer dataStore.Close()
+
+	data, err := ioutil.ReadFile("testdata/isso.db")
+	require.NoError(t, err)
+	isso := Isso{DataStore: &dataStore, BaseURL: "https://example.com/"}
+	size, err := isso.Import(bytes.NewReader(data), siteID)
+	require.NoError(t, err)
+	assert.Equal(t, 154, size, "deleted and pending comments skipped")
+
+	post1 := store.Locator{SiteID: siteID, URL: "https://example.com/post/1/"}
+	comments, err := dataStore.Find(post1, "time", adminUser)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(comments))
+
+	c := comments[0]
+	assert.Equal(t, "1", c.ID)
+	assert.Equal(t, "", c.ParentID)
+	assert.Equal(t, "<p><strong>hello</strong> world</p>\n", c.Text)
+	assert.Equal(t, "**hello** world", c.Orig)
+	assert.Equal(t, "Alice", c.User.Name)
+	assert.Equal(t, "isso_"+store.EncodeID("Alicealice@example.com"), c.User.ID)
+	assert.Equal(t, 2, c.Score)
+	assert.Equal(t, "Post 1", c.PostTitle)
+	assert.Equal(t, time.Date(2020, 1, 1, 12, 0, 0, 250000000, time.UTC), c.Timestamp.UTC())
+
+	assert.Equal(t, "1", comments[1].ParentID)
+	assert.Equal(t, "4", comments[2].ID)
+	assert.Equal(t, "1", comments[2].ParentID, "reply to deleted comment linked to its parent")
+	assert.Equal(t, "anonymous", comments[2].User.Name)
+
+	count, err := dataStore.Count(store.Locator{SiteID: siteID, URL: "https://example.com/post/2/"})
+	require.NoError(t, err)
+	assert.Equal(t, 151, count)
+
+	email, err := dataStore.GetUserEmail(siteID, c.User.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", email)
+
+	_, err = isso.Import(bytes.NewBufferString("bad data"), siteID)
+	assert.Error(t, err)
+}
+
+func TestIsso_URL(t *testing.T) {
+	assert.Equal(t, "/post/1", (&Isso{}).url("/post/1"))
+	assert.Equal(t, "https://example.com/post/1", (&Isso{BaseURL: "https://example.com"}).url("/post/1"))
+	assert.Equal(t, "https://example.com/post/1", (&Isso{BaseURL: "https://example.com/"}).url("post/1"))
+	assert.Equal(t, "http://other.com/1", (&Isso{BaseURL: "https://example.com/"}).url("http://other.com/1"))
+}
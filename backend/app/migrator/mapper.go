@@ -55,20 +55,59 @@ func (u *UrlMapper) loadRules(reader io.Reader) error {
 // URL maps given url to another url according loaded url-rules.
 // If not matched returns given url.
 func (u *UrlMapper) URL(url string) string {
+	newURL, _ := u.match(url)
+	return newURL
+}
+
+// Rule returns rule matched given url as "from-url to-url", empty if not matched
+func (u *UrlMapper) Rule(url string) string {
+	_, rule := u.match(url)
+	return rule
+}
+
+func (u *UrlMapper) match(url string) (newURL, rule string) {
 	if newUrl, ok := u.rules[url]; ok {
-		return newUrl
+		return newUrl, url + " " + newUrl
 	}
 	// try to match by prefix
 	for oldUrl, newUrl := range u.rules {
 		if !strings.HasSuffix(oldUrl, "*") {
 			continue
 		}
-		oldUrl = strings.TrimSuffix(oldUrl, "*")
-		newUrl = strings.TrimSuffix(newUrl, "*")
-		if strings.HasPrefix(url, oldUrl) {
-			return newUrl + strings.TrimPrefix(url, oldUrl)
+		oldPrefix := strings.TrimSuffix(oldUrl, "*")
+		newPrefix := strings.TrimSuffix(newUrl, "*")
+		if strings.HasPrefix(url, oldPrefix) {
+			return newPrefix + strings.TrimPrefix(url, oldPrefix), oldUrl + " " + newUrl
 		}
 	}
 	// search failed, return given url
-	return url
+	return url, ""
+}
+
+// LoadUserMapping reads users mapping for import from given reader.
+// Each row holds source user (username or email in imported data) and remark42 user id separated by space,
+// i.e. "john-doe github_ef0f706a79cc24b17bbbb374cd234a691d034128". Empty rows and rows started with # ignored.
+// Emails lower-cased.
+func LoadUserMapping(reader io.Reader) (map[string]string, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]string{}
+	for _, row := range strings.Split(string(data), "\n") {
+		row = strings.TrimSpace(row)
+		if row == "" || strings.HasPrefix(row, "#") {
+			continue
+		}
+		users := strings.Fields(row)
+		if len(users) != 2 {
+			return nil, errors.New("bad row " + row)
+		}
+		if strings.Contains(users[0], "@") { // emails matched case-insensitive
+			users[0] = strings.ToLower(users[0])
+		}
+		res[users[0]] = users[1]
+	}
+	return res, nil
 }
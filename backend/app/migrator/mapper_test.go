@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUrlMapper_URL(t *testing.T) {
@@ -88,3 +89,28 @@ func TestUrlMapper_New(t *testing.T) {
 		}
 	}
 }
+
+func TestUrlMapper_Rule(t *testing.T) {
+	rules := strings.NewReader(`https://radio-t.com* https://www.radio-t.com*
+https://radio-t.com/p/1/ https://www.radio-t.com/p/2/`)
+	mapper, err := NewUrlMapper(rules)
+	require.NoError(t, err)
+	m := mapper.(*UrlMapper)
+
+	assert.Equal(t, "https://radio-t.com/p/1/ https://www.radio-t.com/p/2/", m.Rule("https://radio-t.com/p/1/"))
+	assert.Equal(t, "https://radio-t.com* https://www.radio-t.com*", m.Rule("https://radio-t.com/p/3/"))
+	assert.Equal(t, "", m.Rule("https://other.com/p/1/"))
+}
+
+func TestLoadUserMapping(t *testing.T) {
+	mapping, err := LoadUserMapping(strings.NewReader(`# disqus users
+facebook-1787732238 facebook_1234
+
+  Mikhail@Example.com   github_5678
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"facebook-1787732238": "facebook_1234", "mikhail@example.com": "github_5678"}, mapping)
+
+	_, err = LoadUserMapping(strings.NewReader("user1 id1\nbad-row"))
+	assert.EqualError(t, err, "bad row bad-row")
+}
@@ -1,5 +1,6 @@
 // Package migrator provides import/export functionality. It defines Importer and Exporter interfaces
-// amd implements for disqus (importer only) and "native" remark (both importer and exporter).
+// amd implements for disqus and wordpress (both importer and exporter), commento and isso (importers only)
+// and "native" remark (both importer and exporter). Selective importer merges part of native backup into the site.
 // Also implements AutoBackup scheduler running exports as backups and saving them locally.
 package migrator
 
@@ -7,6 +8,7 @@ import (
 	"io"
 	"os"
 
+	"github.com/go-pkgz/auth/token"
 	log "github.com/go-pkgz/lgr"
 	"github.com/pkg/errors"
 
@@ -29,27 +31,47 @@ type Mapper interface {
 	URL(url string) string
 }
 
+// MapperFunc is an adapter to allow the use of an ordinary functions as Mapper
+type MapperFunc func(url string) string
+
+// URL calls func for given url
+func (f MapperFunc) URL(url string) string {
+	return f(url)
+}
+
 // MapperMaker defines function that reads rules from reader and
 // returns new Mapper with loaded rules. If rules are not valid
 // it returns error.
 type MapperMaker func(reader io.Reader) (Mapper, error)
 
+// AvatarProxy defines interface to store avatars of imported users, implemented by avatar.Proxy.
+// Put returns proxied url of stored avatar.
+type AvatarProxy interface {
+	Put(u token.User) (avatarURL string, err error)
+}
+
 // Store defines minimal interface needed to export and import comments
 type Store interface {
-	Create(comment store.Comment) (commentID string, err error)
+	Import(comment store.Comment) (commentID string, err error)
 	Find(locator store.Locator, sort string, user store.User) ([]store.Comment, error)
+	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
+	Put(locator store.Locator, comment store.Comment) error
+	Delete(locator store.Locator, commentID string, mode store.DeleteMode) error
 	List(siteID string, limit int, skip int) ([]store.PostInfo, error)
 	DeleteAll(siteID string) error
 	Metas(siteID string) (umetas []service.UserMetaData, pmetas []service.PostMetaData, err error)
 	SetMetas(siteID string, umetas []service.UserMetaData, pmetas []service.PostMetaData) error
+	Post(locator store.Locator) (store.Post, error)
 }
 
 // ImportParams defines everything needed to run import
 type ImportParams struct {
-	DataStore Store
-	InputFile string
-	Provider  string
-	SiteID    string
+	DataStore   Store
+	InputFile   string
+	Provider    string
+	SiteID      string
+	BaseURL     string            // site url, for providers keeping page paths only
+	UserMapping map[string]string // imported users to remark42 user ids, disqus only
 }
 
 var adminUser = store.User{Admin: true}
@@ -61,9 +83,13 @@ func ImportComments(p ImportParams) (int, error) {
 	var importer Importer
 	switch p.Provider {
 	case "disqus":
-		importer = &Disqus{DataStore: p.DataStore}
+		importer = &Disqus{DataStore: p.DataStore, UserMapping: p.UserMapping}
 	case "wordpress":
 		importer = &WordPress{DataStore: p.DataStore}
+	case "commento":
+		importer = &Commento{DataStore: p.DataStore}
+	case "isso":
+		importer = &Isso{DataStore: p.DataStore, BaseURL: p.BaseURL}
 	case "native":
 		importer = &Native{DataStore: p.DataStore}
 	default:
@@ -83,3 +109,82 @@ func ImportComments(p ImportParams) (int, error) {
 
 	return importer.Import(fh, p.SiteID)
 }
+
+// linkParents replaces parents of comments not imported, i.e. deleted or not approved, with the nearest
+// imported ancestor. Comments without such ancestor become top-level comments. Parents is a map of
+// all source comments to their parents, including skipped ones
+func linkParents(comments []store.Comment, parents map[string]string) {
+	imported := make(map[string]bool, len(comments))
+	for _, c := range comments {
+		imported[c.ID] = true
+	}
+	for i, c := range comments {
+		pid := c.ParentID
+		for n := 0; pid != "" && !imported[pid] && n < len(parents); n++ {
+			pid = parents[pid]
+		}
+		if !imported[pid] {
+			pid = ""
+		}
+		comments[i].ParentID = pid
+	}
+}
+
+// saveComments replaces all comments of the site with imported comments and sets users metas
+func saveComments(dataStore Store, siteID string, comments []store.Comment, umetas []service.UserMetaData) (int, error) {
+	if err := dataStore.DeleteAll(siteID); err != nil {
+		return 0, err
+	}
+
+	failed, passed := 0, 0
+	for _, c := range comments {
+		if _, err := dataStore.Import(c); err != nil {
+			log.Printf("[DEBUG] can't save comment %s, %v", c.ID, err)
+			failed++
+			continue
+		}
+		passed++
+	}
+	if len(umetas) > 0 {
+		if err := dataStore.SetMetas(siteID, umetas, nil); err != nil {
+			log.Printf("[WARN] can't set users metas, %v", err)
+		}
+	}
+	log.Printf("[DEBUG] imported %d comments to site %s", passed, siteID)
+
+	if failed > 0 {
+		if passed == 0 {
+			return 0, errors.New("import failed")
+		}
+		return passed, errors.Errorf("failed to save %d comments", failed)
+	}
+	return passed, nil
+}
+
+// exportMetas returns users and posts metas of the site by user id and post url,
+// used by exporters to get emails, blocked and read-only status
+func exportMetas(dataStore Store, siteID string) (map[string]service.UserMetaData, map[string]service.PostMetaData, error) {
+	umetas, pmetas, err := dataStore.Metas(siteID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't get meta")
+	}
+	users := make(map[string]service.UserMetaData, len(umetas))
+	for _, um := range umetas {
+		users[um.ID] = um
+	}
+	posts := make(map[string]service.PostMetaData, len(pmetas))
+	for _, pm := range pmetas {
+		posts[pm.URL] = pm
+	}
+	return users, posts, nil
+}
+
+// exportTitle returns post title from the first comment with it
+func exportTitle(comments []store.Comment) string {
+	for _, c := range comments {
+		if c.PostTitle != "" {
+			return c.PostTitle
+		}
+	}
+	return ""
+}
@@ -36,7 +36,7 @@ func TestMigrator_ImportDisqus(t *testing.T) {
 		Provider:  "disqus",
 	})
 	assert.NoError(t, err)
-	assert.Equal(t, 4, size)
+	assert.Equal(t, 5, size, "spam comment imported as deleted")
 
 	last, err := dataStore.Last("test", 10, time.Time{}, store.User{})
 	assert.NoError(t, err)
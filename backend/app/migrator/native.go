@@ -108,6 +108,9 @@ func WithMapper(reader io.Reader, mapper Mapper) io.Reader {
 		}
 		for i := range m.Posts {
 			m.Posts[i].URL = mapper.URL(m.Posts[i].URL)
+			for j := range m.Posts[i].Aliases {
+				m.Posts[i].Aliases[j] = mapper.URL(m.Posts[i].Aliases[j])
+			}
 		}
 		if err = enc.Encode(m); err != nil {
 			return
@@ -128,16 +131,96 @@ func WithMapper(reader io.Reader, mapper Mapper) io.Reader {
 	return r
 }
 
-// Import comments from json strings produced by Remark.Export
+// Import comments from json strings produced by Remark.Export. The whole input validated first, before any change
+// of the site data. Current data of the site saved to temporary backup and restored if import failed.
+// Input read twice, not seekable reader spooled to encrypted temporary file to keep memory usage bounded.
+// Import is not atomic, readers see partially imported site while it runs.
 func (n *Native) Import(reader io.Reader, siteID string) (size int, err error) {
+	open, cleanup, err := n.replayable(reader)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+
+	src, err := open()
+	if err != nil {
+		return 0, err
+	}
+	if err = n.validate(src); err != nil {
+		return 0, err
+	}
+	if src, err = open(); err != nil {
+		return 0, errors.Wrap(err, "can't rewind import data")
+	}
+
+	backup, err := n.backup(siteID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't backup site %s before import", siteID)
+	}
+	defer backup.Remove()
+
+	if size, err = n.importData(src, siteID); err != nil {
+		log.Printf("[WARN] import to site %s failed, restore previous data, %v", siteID, err)
+		if e := n.restore(backup, siteID); e != nil {
+			return 0, errors.Wrapf(err, "import failed, restore failed too (%v)", e)
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+// validate checks all input without changes in store. Meta version, decoding of all comments and duplicate ids
+// reported as errors. References to missing parents, left by hard deletes or old imports, only logged, such
+// comments imported as is.
+func (n *Native) validate(reader io.Reader) error {
 	m := meta{}
 	dec := json.NewDecoder(reader)
-	if err = dec.Decode(&m); err != nil {
-		return 0, errors.Wrapf(err, "failed to import meta for site %s", siteID)
+	if err := dec.Decode(&m); err != nil {
+		return errors.Wrap(err, "can't decode meta")
 	}
-
 	if m.Version != nativeVersion && m.Version != 0 { // this version allows back compatibility with 0 version
-		return 0, errors.Errorf("unexpected import file version %d", m.Version)
+		return errors.Errorf("unexpected import file version %d", m.Version)
+	}
+
+	ids := map[string]bool{}
+	type ref struct{ id, pid string }
+	refs := []ref{} // parents not seen yet, checked on completion
+	for num := 1; ; num++ {
+		comment := store.Comment{}
+		err := dec.Decode(&comment)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "can't decode comment #%d", num)
+		}
+		if comment.ID == "" {
+			continue // id generated on create, can't be referenced
+		}
+		if ids[comment.ID] {
+			return errors.Errorf("duplicate comment id %s", comment.ID)
+		}
+		ids[comment.ID] = true
+		if comment.ParentID != "" && !ids[comment.ParentID] {
+			refs = append(refs, ref{id: comment.ID, pid: comment.ParentID})
+		}
+	}
+
+	for _, r := range refs {
+		if !ids[r.pid] {
+			log.Printf("[WARN] comment %s refers to missing parent %s", r.id, r.pid)
+		}
+	}
+	log.Printf("[DEBUG] validated %d comments", len(ids))
+	return nil
+}
+
+// importData replaces all comments of the site with comments from reader, stops on the first failed comment
+func (n *Native) importData(reader io.Reader, siteID string) (size int, err error) {
+	m := meta{}
+	dec := json.NewDecoder(reader)
+	if err = dec.Decode(&m); err != nil {
+		return 0, errors.Wrapf(err, "failed to import meta for site %s", siteID)
 	}
 
 	if err = n.DataStore.DeleteAll(siteID); err != nil {
@@ -152,7 +235,7 @@ func (n *Native) Import(reader io.Reader, siteID string) (size int, err error) {
 	}
 	grp := syncs.NewSizedGroup(concurrent, syncs.Preemptive)
 
-	for {
+	for atomic.LoadInt64(&failed) == 0 {
 		comment := store.Comment{}
 		err = dec.Decode(&comment)
 		if err == io.EOF {
@@ -163,13 +246,12 @@ func (n *Native) Import(reader io.Reader, siteID string) (size int, err error) {
 
 		if err != nil {
 			atomic.AddInt64(&failed, 1)
-			failed++
-			continue
+			break
 		}
 
 		// write comments in parallel
 		grp.Go(func(context.Context) {
-			if _, e := n.DataStore.Create(comment); e != nil {
+			if _, e := n.DataStore.Import(comment); e != nil {
 				atomic.AddInt64(&failed, 1)
 				log.Printf("[WARN] can't write %+v to store, %s", comment, e)
 				return
@@ -189,7 +271,54 @@ func (n *Native) Import(reader io.Reader, siteID string) (size int, err error) {
 	}
 	log.Printf("[INFO] imported %d comments from %d records", comments, total)
 
-	err = n.DataStore.SetMetas(siteID, m.Users, m.Posts)
+	if err = n.DataStore.SetMetas(siteID, m.Users, m.Posts); err != nil {
+		return int(comments), errors.Wrap(err, "failed to set metas")
+	}
+	return int(comments), nil
+}
 
-	return int(comments), err
+// backup exports current data of the site to temporary spool
+func (n *Native) backup(siteID string) (*Spool, error) {
+	return NewSpool("remark42-import-backup", func(w io.Writer) error {
+		_, err := n.Export(w, siteID)
+		return err
+	})
+}
+
+// restore replaces data of the site with temporary backup made before import
+func (n *Native) restore(backup *Spool, siteID string) error {
+	reader, err := backup.Reader()
+	if err != nil {
+		return errors.Wrap(err, "can't read temporary backup")
+	}
+	size, err := n.importData(reader, siteID)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] restored %d comments of site %s", size, siteID)
+	return nil
+}
+
+// replayable returns func opening input from the start, each call rewinds it. Seekable input rewound in place,
+// other input copied to encrypted temporary spool, removed by returned cleanup func
+func (n *Native) replayable(reader io.Reader) (open func() (io.Reader, error), cleanup func(), err error) {
+	if rs, ok := reader.(io.ReadSeeker); ok {
+		start, e := rs.Seek(0, io.SeekCurrent)
+		if e != nil {
+			return nil, nil, errors.Wrap(e, "can't get import position")
+		}
+		open = func() (io.Reader, error) {
+			_, e := rs.Seek(start, io.SeekStart)
+			return rs, e
+		}
+		return open, func() {}, nil
+	}
+	sp, err := NewSpool("remark42-import", func(w io.Writer) error {
+		_, e := io.Copy(w, reader)
+		return e
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't save import data to temporary file")
+	}
+	return sp.Reader, sp.Remove, nil
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"strings"
@@ -171,14 +172,63 @@ func TestNative_ImportManyWithError(t *testing.T) {
 	b.AdminStore = admin.NewStaticStore("12345", nil, []string{}, "")
 	r := Native{DataStore: b}
 	n, err := r.Import(buf, "radio-t")
-	assert.EqualError(t, err, "failed to save 2 comments")
-	assert.Equal(t, 100, n)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to save")
+	assert.Equal(t, 0, n)
 	comments, err := b.Find(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, "time", store.User{})
 	assert.NoError(t, err)
-	assert.Equal(t, 100, len(comments))
+	require.Equal(t, 1, len(comments), "previous data restored")
+	assert.Equal(t, "efbc17f177ee1a1c0ee6e1e025749966ec071adc", comments[0].ID)
 }
 
 // makes new boltdb, put two records
+func TestNative_ImportValidation(t *testing.T) {
+	b, teardown := prep(t) // write 2 comments
+	defer teardown()
+	b.AdminStore = admin.NewStaticStore("12345", nil, []string{}, "")
+	r := Native{DataStore: b}
+
+	rec := `{"id":"%s","pid":"%s","text":"some text","user":{"name":"user name","id":"user1"},` +
+		`"locator":{"site":"radio-t","url":"https://radio-t.com/new"},"time":"2017-12-20T15:18:22-06:00"}` + "\n"
+	tbl := []struct {
+		name string
+		inp  string
+		err  string
+	}{
+		{"bad meta", "{bad\n", "can't decode meta: invalid character 'b' looking for beginning of object key string"},
+		{"bad version", `{"version":2}` + "\n", "unexpected import file version 2"},
+		{"broken comment", `{"version":1}` + "\n" + fmt.Sprintf(rec, "1", "") + "{\"id\":\"2\", bad\n" + fmt.Sprintf(rec, "3", ""),
+			"can't decode comment #2: invalid character 'b' looking for beginning of object key string"},
+		{"duplicate id", `{"version":1}` + "\n" + fmt.Sprintf(rec, "1", "") + fmt.Sprintf(rec, "2", "1") + fmt.Sprintf(rec, "1", ""),
+			"duplicate comment id 1"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			size, err := r.Import(strings.NewReader(tt.inp), "radio-t")
+			assert.EqualError(t, err, tt.err)
+			assert.Equal(t, 0, size)
+			count, err := b.Count(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"})
+			require.NoError(t, err)
+			assert.Equal(t, 1, count, "site data untouched")
+		})
+	}
+
+	// parent after reply is fine, missing parent kept as is, not seekable input spooled to temp file
+	inp := `{"version":1}` + "\n" + fmt.Sprintf(rec, "2", "1") + fmt.Sprintf(rec, "1", "") + fmt.Sprintf(rec, "3", "missing")
+	size, err := r.Import(io.MultiReader(strings.NewReader(inp)), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+	count, err := b.Count(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, count, "old data replaced")
+	count, err = b.Count(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/new"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	c, err := b.Get(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/new"}, "3", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "missing", c.ParentID)
+}
+
 func prep(t *testing.T) (*service.DataStore, func()) {
 
 	testDb := fmt.Sprintf("/tmp/migrator-%d.db", rand.Intn(999999999))
@@ -0,0 +1,87 @@
+package migrator
+
+import (
+	"sort"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+// RuleMapper is a Mapper able to report which rule mapped the url
+type RuleMapper interface {
+	Mapper
+	Rule(url string) string // rule matched url, empty if not matched
+}
+
+// RemapPlan describes changes remap would make, without making them
+type RemapPlan struct {
+	Rules      []RuleChanges `json:"rules"`
+	Collisions []Collision   `json:"collisions"`
+	Posts      int           `json:"posts"`    // total number of posts to move
+	Comments   int           `json:"comments"` // total number of comments to move
+}
+
+// RuleChanges lists posts moved by the rule
+type RuleChanges struct {
+	Rule     string     `json:"rule"`
+	Posts    []PostMove `json:"posts"`
+	Comments int        `json:"comments"`
+}
+
+// PostMove describes post moved to another url
+type PostMove struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Comments int    `json:"comments"`
+}
+
+// Collision lists urls of posts mapped to the same url, their comments would be merged into one thread.
+// Includes the target post if it exists and isn't moved itself
+type Collision struct {
+	To   string   `json:"to"`
+	From []string `json:"from"`
+}
+
+// PlanRemap makes remap plan for posts. Moves attributed to rules if mapper is RuleMapper,
+// otherwise all moves reported under "*" rule
+func PlanRemap(posts []store.PostInfo, mapper Mapper) RemapPlan {
+	res := RemapPlan{Rules: []RuleChanges{}, Collisions: []Collision{}}
+	rules := map[string]*RuleChanges{}
+	targets := map[string][]string{}
+
+	for _, p := range posts {
+		to := mapper.URL(p.URL)
+		targets[to] = append(targets[to], p.URL)
+		if to == p.URL {
+			continue
+		}
+
+		rule := "*"
+		if rm, ok := mapper.(RuleMapper); ok {
+			rule = rm.Rule(p.URL)
+		}
+		rc, ok := rules[rule]
+		if !ok {
+			rc = &RuleChanges{Rule: rule}
+			rules[rule] = rc
+		}
+		rc.Posts = append(rc.Posts, PostMove{From: p.URL, To: to, Comments: p.Count})
+		rc.Comments += p.Count
+		res.Posts++
+		res.Comments += p.Count
+	}
+
+	for _, rc := range rules {
+		sort.Slice(rc.Posts, func(i, j int) bool { return rc.Posts[i].From < rc.Posts[j].From })
+		res.Rules = append(res.Rules, *rc)
+	}
+	sort.Slice(res.Rules, func(i, j int) bool { return res.Rules[i].Rule < res.Rules[j].Rule })
+
+	for to, from := range targets {
+		if len(from) > 1 {
+			sort.Strings(from)
+			res.Collisions = append(res.Collisions, Collision{To: to, From: from})
+		}
+	}
+	sort.Slice(res.Collisions, func(i, j int) bool { return res.Collisions[i].To < res.Collisions[j].To })
+	return res
+}
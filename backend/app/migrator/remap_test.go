@@ -0,0 +1,55 @@
+package migrator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+func TestPlanRemap(t *testing.T) {
+	rules := strings.NewReader(`https://radio-t.com* https://www.radio-t.com*
+https://radio-t.com/p/old/ https://www.radio-t.com/p/1/
+https://other.com/p/1/ https://other.com/p/2/`)
+	mapper, err := NewUrlMapper(rules)
+	require.NoError(t, err)
+
+	posts := []store.PostInfo{
+		{URL: "https://radio-t.com/p/1/", Count: 5},
+		{URL: "https://radio-t.com/p/2/", Count: 2},
+		{URL: "https://radio-t.com/p/old/", Count: 1},
+		{URL: "https://other.com/p/1/", Count: 3},
+		{URL: "https://other.com/p/2/", Count: 4},
+		{URL: "https://other.com/p/3/", Count: 1},
+	}
+	plan := PlanRemap(posts, mapper)
+	assert.Equal(t, 4, plan.Posts)
+	assert.Equal(t, 11, plan.Comments)
+	assert.Equal(t, []RuleChanges{
+		{Rule: "https://other.com/p/1/ https://other.com/p/2/", Comments: 3,
+			Posts: []PostMove{{From: "https://other.com/p/1/", To: "https://other.com/p/2/", Comments: 3}}},
+		{Rule: "https://radio-t.com* https://www.radio-t.com*", Comments: 7,
+			Posts: []PostMove{
+				{From: "https://radio-t.com/p/1/", To: "https://www.radio-t.com/p/1/", Comments: 5},
+				{From: "https://radio-t.com/p/2/", To: "https://www.radio-t.com/p/2/", Comments: 2},
+			}},
+		{Rule: "https://radio-t.com/p/old/ https://www.radio-t.com/p/1/", Comments: 1,
+			Posts: []PostMove{{From: "https://radio-t.com/p/old/", To: "https://www.radio-t.com/p/1/", Comments: 1}}},
+	}, plan.Rules)
+	assert.Equal(t, []Collision{
+		{To: "https://other.com/p/2/", From: []string{"https://other.com/p/1/", "https://other.com/p/2/"}},
+		{To: "https://www.radio-t.com/p/1/", From: []string{"https://radio-t.com/p/1/", "https://radio-t.com/p/old/"}},
+	}, plan.Collisions)
+
+	// mapper without rules
+	plan = PlanRemap(posts, MapperFunc(func(url string) string { return strings.Replace(url, "/p/", "/post/", 1) }))
+	assert.Equal(t, 6, plan.Posts)
+	assert.Equal(t, 16, plan.Comments)
+	require.Equal(t, 1, len(plan.Rules))
+	assert.Equal(t, "*", plan.Rules[0].Rule)
+	assert.Equal(t, 6, len(plan.Rules[0].Posts))
+	assert.Equal(t, []Collision{}, plan.Collisions)
+}
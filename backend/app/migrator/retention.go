@@ -0,0 +1,82 @@
+package migrator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Retention is grandfather-father-son policy of automatic backups. The latest backup of each of the last Daily days,
+// Weekly ISO weeks and Monthly months with backups is kept, one backup may count for the day, week and month.
+type Retention struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// backupExts are extensions of automatic backup files
+var backupExts = []string{".gz", ".tar.gz", ".gz" + EncryptedExt, ".tar.gz" + EncryptedExt}
+
+// Expired returns names of backups of the site not kept by the policy. Files not named as automatic backups,
+// i.e. backup-{site}-{yyyymmdd}.gz, ignored.
+func (r Retention) Expired(siteID string, names []string) []string {
+	type backup struct {
+		name string
+		date time.Time
+	}
+	backups := []backup{}
+	for _, name := range names {
+		if date, ok := backupDate(siteID, name); ok {
+			backups = append(backups, backup{name: name, date: date})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].date.Equal(backups[j].date) {
+			return backups[i].date.After(backups[j].date)
+		}
+		return backups[i].name > backups[j].name
+	})
+
+	days, weeks, months := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	keep := func(periods map[string]bool, key string, limit int) bool {
+		if periods[key] || len(periods) >= limit {
+			return false
+		}
+		periods[key] = true
+		return true
+	}
+
+	res := []string{}
+	for _, b := range backups {
+		year, week := b.date.ISOWeek()
+		kept := keep(days, b.date.Format("20060102"), r.Daily)
+		kept = keep(weeks, fmt.Sprintf("%d-%02d", year, week), r.Weekly) || kept
+		kept = keep(months, b.date.Format("200601"), r.Monthly) || kept
+		if !kept {
+			res = append(res, b.name)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (r Retention) String() string {
+	return fmt.Sprintf("daily %d, weekly %d, monthly %d", r.Daily, r.Weekly, r.Monthly)
+}
+
+// backupDate parses date of automatic backup from its name
+func backupDate(siteID, name string) (time.Time, bool) {
+	prefix := "backup-" + siteID + "-"
+	if !strings.HasPrefix(name, prefix) || len(name) < len(prefix)+8 {
+		return time.Time{}, false
+	}
+	ext := name[len(prefix)+8:]
+	for _, e := range backupExts {
+		if ext == e {
+			date, err := time.ParseInLocation("20060102", name[len(prefix):len(prefix)+8], time.Local)
+			return date, err == nil
+		}
+	}
+	return time.Time{}, false
+}
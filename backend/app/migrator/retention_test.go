@@ -0,0 +1,50 @@
+package migrator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetention_Expired(t *testing.T) {
+	names := []string{}
+	for d := time.Date(2019, 11, 1, 0, 0, 0, 0, time.Local); d.Before(time.Date(2020, 2, 10, 0, 0, 0, 0, time.Local)); d = d.AddDate(0, 0, 1) {
+		names = append(names, "backup-site1-"+d.Format("20060102")+".gz")
+	}
+	names = append(names, "backup-site1-20200209.tar.gz.enc", "backup-site10-20200101.gz", "backup-site1-20200101.gz.tmp-123",
+		"userbackup-site1-20200101.gz", "backup-site1-2020.gz")
+
+	kept := func(r Retention) []string {
+		expired := map[string]bool{}
+		for _, name := range r.Expired("site1", names) {
+			expired[name] = true
+		}
+		res := []string{}
+		for _, name := range names {
+			if !expired[name] {
+				res = append(res, name)
+			}
+		}
+		return res
+	}
+	others := []string{"backup-site10-20200101.gz", "backup-site1-20200101.gz.tmp-123", "userbackup-site1-20200101.gz", "backup-site1-2020.gz"}
+
+	tbl := []struct {
+		retention Retention
+		kept      []string
+	}{
+		{Retention{Daily: 3}, []string{"backup-site1-20200207.gz", "backup-site1-20200208.gz", "backup-site1-20200209.tar.gz.enc"}},
+		{Retention{Daily: 1, Weekly: 3}, []string{"backup-site1-20200126.gz", "backup-site1-20200202.gz", "backup-site1-20200209.tar.gz.enc"}},
+		{Retention{Daily: 2, Weekly: 2, Monthly: 4}, []string{"backup-site1-20191130.gz", "backup-site1-20191231.gz",
+			"backup-site1-20200131.gz", "backup-site1-20200202.gz", "backup-site1-20200208.gz", "backup-site1-20200209.tar.gz.enc"}},
+		{Retention{Monthly: 12}, []string{"backup-site1-20191130.gz", "backup-site1-20191231.gz", "backup-site1-20200131.gz",
+			"backup-site1-20200209.tar.gz.enc"}},
+		{Retention{}, []string{}},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.retention.String(), func(t *testing.T) {
+			assert.Equal(t, append(tt.kept, others...), kept(tt.retention))
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package migrator
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/service"
+)
+
+// Selection defines comments picked from backup by Selective. Comment selected if it belongs to any of URLs,
+// made by any of Users and its time is within [From, To). Empty criteria match all comments
+type Selection struct {
+	URLs  []string
+	Users []string
+	From  time.Time
+	To    time.Time
+}
+
+// Empty checks if no criteria set
+func (s Selection) Empty() bool {
+	return len(s.URLs) == 0 && len(s.Users) == 0 && s.From.IsZero() && s.To.IsZero()
+}
+
+// Match checks if comment selected
+func (s Selection) Match(c store.Comment) bool {
+	if len(s.URLs) > 0 && !store.Contains(s.URLs, c.Locator.URL) {
+		return false
+	}
+	if len(s.Users) > 0 && !store.Contains(s.Users, c.User.ID) {
+		return false
+	}
+	if !s.From.IsZero() && c.Timestamp.Before(s.From) {
+		return false
+	}
+	if !s.To.IsZero() && !c.Timestamp.Before(s.To) {
+		return false
+	}
+	return true
+}
+
+func (s Selection) String() string {
+	res := []string{}
+	if len(s.URLs) > 0 {
+		res = append(res, fmt.Sprintf("urls %v", s.URLs))
+	}
+	if len(s.Users) > 0 {
+		res = append(res, fmt.Sprintf("users %v", s.Users))
+	}
+	if !s.From.IsZero() {
+		res = append(res, "from "+s.From.Format(time.RFC3339))
+	}
+	if !s.To.IsZero() {
+		res = append(res, "to "+s.To.Format(time.RFC3339))
+	}
+	if len(res) == 0 {
+		return "all"
+	}
+	return strings.Join(res, ", ")
+}
+
+// Selective implements importer restoring selected comments from native backup or backup archive. Unlike Native
+// it merges comments into the site and never removes comments missing in backup. Comment existing on the site kept as is unless
+// Overwrite set, comment deleted on the site always replaced by its copy from backup. With Overwrite comment deleted
+// in backup deleted on the site too, with the same soft or hard mode. Ancestors of selected replies
+// missing on the site restored too, to keep threads complete. Images, avatars and metas of users and posts not restored.
+// Urls of selection and of backup normalized and resolved to canonical urls of the site posts before matching.
+type Selective struct {
+	DataStore     Store
+	Selection     Selection
+	Overwrite     bool
+	URLNormalizer *service.URLNormalizer
+
+	urls map[string]string // url -> canonical url of the post
+}
+
+// Import merges selected comments from reader into the site, returns number of restored comments.
+// Input validated as with Native before any change, gzipped input treated as backup archive
+func (s *Selective) Import(reader io.Reader, siteID string) (size int, err error) {
+	if s.Selection.Empty() {
+		return 0, errors.New("empty selection, use full restore instead")
+	}
+
+	br := bufio.NewReader(reader)
+	var src io.Reader = br
+	if head, e := br.Peek(2); e == nil && head[0] == 0x1f && head[1] == 0x8b {
+		comments, e := s.archiveComments(br)
+		if e != nil {
+			return 0, e
+		}
+		defer comments.Remove()
+		if src, e = comments.Reader(); e != nil {
+			return 0, errors.Wrap(e, "can't open comments")
+		}
+	}
+
+	s.urls = map[string]string{}
+	sel := s.Selection // caller's selection kept as is
+	sel.URLs = make([]string, len(s.Selection.URLs))
+	for i, u := range s.Selection.URLs {
+		sel.URLs[i] = s.canonical(siteID, u)
+	}
+
+	native := Native{}
+	open, cleanup, err := native.replayable(src)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+
+	rs, err := open()
+	if err != nil {
+		return 0, err
+	}
+	if err = native.validate(rs); err != nil {
+		return 0, err
+	}
+	if rs, err = open(); err != nil {
+		return 0, errors.Wrap(err, "can't rewind import data")
+	}
+	ids, err := s.pick(rs, siteID, sel)
+	if err != nil {
+		return 0, err
+	}
+	if rs, err = open(); err != nil {
+		return 0, errors.Wrap(err, "can't rewind import data")
+	}
+	return s.merge(rs, siteID, ids)
+}
+
+// archiveComments unpacks backup archive, verifies it against manifest and returns spool with unpacked comments
+func (s *Selective) archiveComments(reader io.Reader) (*Spool, error) {
+	a := Archive{}
+	entries, err := a.unpack(reader)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = a.verify(entries); err != nil {
+		removeEntries(entries)
+		return nil, errors.Wrap(err, "invalid archive")
+	}
+	comments := entries[archiveCommentsName].spool
+	delete(entries, archiveCommentsName)
+	removeEntries(entries)
+	return comments, nil
+}
+
+// pick returns ids of selected comments and of their ancestors missing or deleted on the site
+func (s *Selective) pick(reader io.Reader, siteID string, sel Selection) (map[string]bool, error) {
+	dec := json.NewDecoder(reader)
+	if err := dec.Decode(&meta{}); err != nil {
+		return nil, errors.Wrap(err, "can't decode meta")
+	}
+
+	parents, locators, ids := map[string]string{}, map[string]store.Locator{}, map[string]bool{}
+	for {
+		c := store.Comment{}
+		err := dec.Decode(&c)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "can't decode comment")
+		}
+		c.Locator.URL = s.canonical(siteID, c.Locator.URL)
+		parents[c.ID], locators[c.ID] = c.ParentID, store.Locator{SiteID: siteID, URL: c.Locator.URL}
+		if sel.Match(c) {
+			ids[c.ID] = true
+		}
+	}
+
+	selected := make([]string, 0, len(ids))
+	for id := range ids {
+		selected = append(selected, id)
+	}
+	for _, id := range selected {
+		for pid := parents[id]; pid != "" && !ids[pid]; pid = parents[pid] {
+			if c, err := s.DataStore.Get(locators[pid], pid, adminUser); err == nil && !c.Deleted {
+				break // the rest of the thread is on the site
+			}
+			ids[pid] = true
+		}
+	}
+	log.Printf("[DEBUG] picked %d comments for %s, %d ancestors", len(ids), sel, len(ids)-len(selected))
+	return ids, nil
+}
+
+// merge creates picked comments missing on the site and replaces deleted ones, or all existing if Overwrite set
+func (s *Selective) merge(reader io.Reader, siteID string, ids map[string]bool) (size int, err error) {
+	dec := json.NewDecoder(reader)
+	if err = dec.Decode(&meta{}); err != nil {
+		return 0, errors.Wrap(err, "can't decode meta")
+	}
+
+	var failed, skipped int
+	for {
+		c := store.Comment{}
+		err = dec.Decode(&c)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return size, errors.Wrap(err, "can't decode comment")
+		}
+		if !ids[c.ID] {
+			continue
+		}
+		c.Locator = store.Locator{SiteID: siteID, URL: s.canonical(siteID, c.Locator.URL)}
+
+		cur, e := s.DataStore.Get(c.Locator, c.ID, adminUser)
+		switch {
+		case e != nil: // not on the site
+			_, e = s.DataStore.Import(c)
+		case s.Overwrite && c.Deleted && !cur.Deleted:
+			e = s.DataStore.Delete(c.Locator, c.ID, deleteMode(c))
+		case s.Overwrite || (cur.Deleted && !c.Deleted):
+			e = s.DataStore.Put(c.Locator, c)
+		default:
+			skipped++
+			continue
+		}
+		if e != nil {
+			log.Printf("[WARN] can't restore comment %s, %v", c.ID, e)
+			failed++
+			continue
+		}
+		size++
+	}
+	log.Printf("[INFO] restored %d comments of site %s, skipped %d existing, %s", size, siteID, skipped, s.Selection)
+
+	if failed > 0 {
+		return size, errors.Errorf("failed to restore %d comments", failed)
+	}
+	return size, nil
+}
+
+// canonical returns normalized url resolved to canonical url of the post if the post known on the site
+func (s *Selective) canonical(siteID, url string) string {
+	if res, ok := s.urls[url]; ok {
+		return res
+	}
+	res := s.URLNormalizer.URL(siteID, url)
+	if post, err := s.DataStore.Post(store.Locator{SiteID: siteID, URL: res}); err == nil {
+		res = post.Locator.URL
+	}
+	s.urls[url] = res
+	return res
+}
+
+// deleteMode returns mode the comment of backup deleted with, hard delete clears the user
+func deleteMode(c store.Comment) store.DeleteMode {
+	if c.User.ID == "deleted" {
+		return store.HardDelete
+	}
+	return store.SoftDelete
+}
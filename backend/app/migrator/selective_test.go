@@ -0,0 +1,221 @@
+package migrator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/service"
+)
+
+func TestSelection(t *testing.T) {
+	c := store.Comment{Locator: store.Locator{URL: "https://radio-t.com"}, User: store.User{ID: "user1"},
+		Timestamp: time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)}
+
+	tbl := []struct {
+		sel   Selection
+		match bool
+		str   string
+	}{
+		{Selection{}, true, "all"},
+		{Selection{URLs: []string{"https://radio-t.com/2", "https://radio-t.com"}}, true, "urls [https://radio-t.com/2 https://radio-t.com]"},
+		{Selection{URLs: []string{"https://radio-t.com/2"}}, false, "urls [https://radio-t.com/2]"},
+		{Selection{URLs: []string{"https://radio-t.com"}, Users: []string{"user2"}}, false, "urls [https://radio-t.com], users [user2]"},
+		{Selection{From: c.Timestamp}, true, "from 2020-01-02T10:00:00Z"},
+		{Selection{To: c.Timestamp}, false, "to 2020-01-02T10:00:00Z"},
+		{Selection{Users: []string{"user1"}, From: c.Timestamp.Add(-time.Hour), To: c.Timestamp.Add(time.Hour)}, true,
+			"users [user1], from 2020-01-02T09:00:00Z, to 2020-01-02T11:00:00Z"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.str, func(t *testing.T) {
+			assert.Equal(t, tt.match, tt.sel.Match(c))
+			assert.Equal(t, tt.str, tt.sel.String())
+			assert.Equal(t, tt.str == "all", tt.sel.Empty())
+		})
+	}
+}
+
+func TestSelective_Import(t *testing.T) {
+	b, teardown := prepSelective(t)
+	defer teardown()
+
+	backup := &bytes.Buffer{}
+	_, err := (&Native{DataStore: b}).Export(backup, "radio-t")
+	require.NoError(t, err)
+
+	// thread efbc <- reply1 <- reply2 damaged, post /2 lost with user2
+	locator := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}
+	require.NoError(t, b.Delete(locator, "efbc17f177ee1a1c0ee6e1e025749966ec071adc", store.SoftDelete))
+	require.NoError(t, b.DeleteUser("radio-t", "user2", store.HardDelete))
+	reply2, err := b.Get(locator, "reply2", adminUser)
+	require.NoError(t, err)
+	reply2.Text = "edited"
+	require.NoError(t, b.Put(locator, reply2))
+
+	sel := Selective{DataStore: b, Selection: Selection{URLs: []string{"https://radio-t.com/2"}}}
+	size, err := sel.Import(bytes.NewReader(backup.Bytes()), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+	comments, err := b.Find(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/2"}, "time", adminUser)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(comments))
+	assert.Equal(t, "some text2", comments[0].Text)
+	assert.Equal(t, "user2", comments[0].User.ID)
+
+	sel = Selective{DataStore: b, Selection: Selection{Users: []string{"user1"}}}
+	size, err = sel.Import(bytes.NewReader(backup.Bytes()), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 2, size, "deleted efbc and its reply1 as ancestor of reply2, reply2 kept")
+	comments, err = b.Find(locator, "time", adminUser)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(comments))
+	assert.Equal(t, `some text, <a href="http://radio-t.com" rel="nofollow">link</a>`, comments[0].Text)
+	assert.False(t, comments[0].Deleted)
+	assert.Equal(t, "reply1", comments[1].ID)
+	assert.Equal(t, "user2", comments[1].User.ID)
+	assert.False(t, comments[1].Deleted)
+	assert.Equal(t, "edited", comments[2].Text)
+	count, err := b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	sel = Selective{DataStore: b, Selection: Selection{From: reply2.Timestamp}, Overwrite: true}
+	size, err = sel.Import(bytes.NewReader(backup.Bytes()), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+	reply2, err = b.Get(locator, "reply2", adminUser)
+	require.NoError(t, err)
+	assert.Equal(t, "reply text2", reply2.Text)
+
+	sel = Selective{DataStore: b}
+	_, err = sel.Import(bytes.NewReader(backup.Bytes()), "radio-t")
+	assert.EqualError(t, err, "empty selection, use full restore instead")
+
+	// reply to missing parent restored as is
+	sel = Selective{DataStore: b, Selection: Selection{Users: []string{"user3"}}}
+	size, err = sel.Import(strings.NewReader(`{"version":1}`+"\n"+`{"id":"c1","pid":"c0","text":"text",`+
+		`"user":{"id":"user3"},"locator":{"site":"radio-t","url":"https://radio-t.com/3"}}`), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+	c1, err := b.Get(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/3"}, "c1", adminUser)
+	require.NoError(t, err)
+	assert.Equal(t, "c0", c1.ParentID)
+}
+
+func TestSelective_ImportNormalized(t *testing.T) {
+	b, teardown := prepSelective(t)
+	defer teardown()
+
+	backup := &bytes.Buffer{}
+	_, err := (&Native{DataStore: b}).Export(backup, "radio-t")
+	require.NoError(t, err)
+	require.NoError(t, b.DeleteUser("radio-t", "user2", store.HardDelete))
+
+	// flood policy not applied to restored comments
+	b.FloodControl = &service.FloodControl{Default: service.FloodPolicy{SlowMode: time.Hour, DailyQuota: 1, Duplicates: time.Hour}}
+	// old url of the post kept as alias
+	_, err = b.UpdatePost(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/2"},
+		store.Post{Aliases: []string{"https://radio-t.com/old"}})
+	require.NoError(t, err)
+	normalizer := &service.URLNormalizer{Default: service.URLPolicy{Hosts: map[string]string{"www.radio-t.com": "radio-t.com"},
+		Slash: "strip"}}
+
+	sel := Selective{DataStore: b, Selection: Selection{URLs: []string{"https://WWW.radio-t.com/old/"}}, URLNormalizer: normalizer}
+	size, err := sel.Import(bytes.NewReader(backup.Bytes()), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 1, size, "url of selection normalized and resolved to the post")
+	assert.Equal(t, []string{"https://WWW.radio-t.com/old/"}, sel.Selection.URLs, "caller's selection not changed")
+
+	sel = Selective{DataStore: b, Selection: Selection{Users: []string{"user2"}}, URLNormalizer: normalizer}
+	size, err = sel.Import(bytes.NewReader(backup.Bytes()), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 1, size, "user's history restored with flood policy set, deleted reply1")
+	comments, err := b.User("radio-t", "user2", 0, 0, adminUser)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments))
+}
+
+func TestSelective_ImportDeleted(t *testing.T) {
+	b, teardown := prepSelective(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}
+	require.NoError(t, b.Delete(locator, "reply1", store.SoftDelete))
+	require.NoError(t, b.Delete(locator, "reply2", store.HardDelete))
+	backup := &bytes.Buffer{}
+	_, err := (&Native{DataStore: b}).Export(backup, "radio-t")
+	require.NoError(t, err)
+
+	// comments restored on the site, deleted in backup
+	for _, id := range []string{"reply1", "reply2"} {
+		c, e := b.Get(locator, id, adminUser)
+		require.NoError(t, e)
+		c.Text, c.Deleted, c.User = "restored", false, store.User{ID: "user2", Name: "user name"}
+		require.NoError(t, b.Put(locator, c))
+	}
+
+	sel := Selective{DataStore: b, Selection: Selection{URLs: []string{"https://radio-t.com"}}}
+	size, err := sel.Import(bytes.NewReader(backup.Bytes()), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 0, size, "live comments kept without overwrite")
+
+	sel.Overwrite = true
+	_, err = sel.Import(bytes.NewReader(backup.Bytes()), "radio-t")
+	require.NoError(t, err)
+	reply1, err := b.Get(locator, "reply1", adminUser)
+	require.NoError(t, err)
+	assert.True(t, reply1.Deleted)
+	assert.Equal(t, "user2", reply1.User.ID, "soft deleted")
+	reply2, err := b.Get(locator, "reply2", adminUser)
+	require.NoError(t, err)
+	assert.True(t, reply2.Deleted)
+	assert.Equal(t, "deleted", reply2.User.ID, "hard deleted")
+	comments, err := b.User("radio-t", "user2", 0, 0, adminUser)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments), "reference of hard deleted comment removed, soft deleted and /2 kept")
+}
+
+func TestSelective_ImportArchive(t *testing.T) {
+	b, teardown := prepSelective(t)
+	defer teardown()
+
+	backup := &bytes.Buffer{}
+	_, err := (&Archive{DataStore: b}).Export(backup, "radio-t")
+	require.NoError(t, err)
+	require.NoError(t, b.DeleteUser("radio-t", "user2", store.HardDelete))
+
+	sel := Selective{DataStore: b, Selection: Selection{Users: []string{"user2"}}}
+	size, err := sel.Import(bytes.NewReader(backup.Bytes()), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+	comments, err := b.User("radio-t", "user2", 0, 0, adminUser)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments))
+	for _, c := range comments {
+		assert.False(t, c.Deleted)
+	}
+
+	data := backup.Bytes()
+	data[len(data)/2] ^= 0xff
+	_, err = sel.Import(bytes.NewReader(data), "radio-t")
+	assert.Error(t, err)
+}
+
+// prepSelective makes site with comments of prep and two replies to the first comment, reply1 <- reply2
+func prepSelective(t *testing.T) (*service.DataStore, func()) {
+	b, teardown := prep(t)
+	ts := time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local)
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{ID: "reply1", ParentID: "efbc17f177ee1a1c0ee6e1e025749966ec071adc", Text: "reply text1",
+		Timestamp: ts, Locator: locator, User: store.User{ID: "user2", Name: "user name"}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "reply2", ParentID: "reply1", Text: "reply text2",
+		Timestamp: ts.Add(time.Second), Locator: locator, User: store.User{ID: "user1", Name: "user name"}})
+	require.NoError(t, err)
+	return b, teardown
+}
@@ -0,0 +1,84 @@
+package migrator
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Spool keeps data in temporary file encrypted for key pair kept in memory only, so temporary copies of site data
+// and decrypted backups never stored on disk in plain. Data written once and can be read any number of times
+type Spool struct {
+	fh      *os.File
+	crypter *Crypter
+	size    int64
+}
+
+// NewSpool makes spool with data written by fill func
+func NewSpool(pattern string, fill func(w io.Writer) error) (*Spool, error) {
+	key, pub, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	fh, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make temporary file")
+	}
+	res := &Spool{fh: fh, crypter: &Crypter{Recipients: []string{pub}, Identities: []string{key}}}
+	if err = res.write(fill); err != nil {
+		res.Remove()
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Spool) write(fill func(w io.Writer) error) error {
+	bw := bufio.NewWriter(s.fh)
+	encWriter, err := s.crypter.Encrypt(bw)
+	if err != nil {
+		return err
+	}
+	if err = fill(&countingWriter{w: encWriter, size: &s.size}); err != nil {
+		return err
+	}
+	if err = encWriter.Close(); err != nil {
+		return errors.Wrapf(err, "can't complete %s", s.fh.Name())
+	}
+	return errors.Wrapf(bw.Flush(), "can't write %s", s.fh.Name())
+}
+
+// Reader returns reader of spooled data from the start. Only one reader can be used at a time
+func (s *Spool) Reader() (io.Reader, error) {
+	if _, err := s.fh.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrapf(err, "can't rewind %s", s.fh.Name())
+	}
+	return s.crypter.Decrypt(s.fh)
+}
+
+// Size returns size of spooled data
+func (s *Spool) Size() int64 {
+	return s.size
+}
+
+// Remove closes and removes spool file
+func (s *Spool) Remove() {
+	_ = s.fh.Close()
+	if err := os.Remove(s.fh.Name()); err != nil {
+		log.Printf("[WARN] can't remove temporary file %s, %v", s.fh.Name(), err)
+	}
+}
+
+type countingWriter struct {
+	w    io.Writer
+	size *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,50 @@
+package migrator
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool(t *testing.T) {
+	data := bytes.Repeat([]byte("some plain data "), encChunkSize/4)
+	sp, err := NewSpool("spool-test", func(w io.Writer) error {
+		_, e := w.Write(data)
+		return e
+	})
+	require.NoError(t, err)
+	fname := sp.fh.Name()
+	assert.Equal(t, int64(len(data)), sp.Size())
+
+	stored, err := ioutil.ReadFile(fname)
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(stored))
+	assert.False(t, bytes.Contains(stored, []byte("some plain data")))
+
+	for i := 0; i < 2; i++ {
+		r, err := sp.Reader()
+		require.NoError(t, err)
+		res, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, data, res, "read #%d", i)
+	}
+
+	sp.Remove()
+	_, err = os.Stat(fname)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSpool_Failed(t *testing.T) {
+	_, err := NewSpool("spool-test-failed", func(w io.Writer) error { return errors.New("fill failed") })
+	assert.EqualError(t, err, "fill failed")
+	files, err := filepath.Glob(filepath.Join(os.TempDir(), "spool-test-failed*"))
+	require.NoError(t, err)
+	assert.Empty(t, files, "temporary file removed")
+}
@@ -0,0 +1,330 @@
+package migrator
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sqliteDB is a minimal read-only reader of sqlite3 database files, enough to read rows of ordinary tables.
+// Implemented here to keep the binary free of cgo, which sqlite drivers need. Indexes, WITHOUT ROWID tables
+// and not checkpointed WAL content are not supported, import command refuses database with non-empty -wal file
+type sqliteDB struct {
+	data     []byte
+	pageSize int
+	usable   int // page size without reserved space
+}
+
+// sqliteRow is a row of the table, column name -> value. Values are int64, float64, string, []byte or nil
+type sqliteRow map[string]interface{}
+
+const sqliteHeader = "SQLite format 3\x00"
+
+func newSqliteDB(data []byte) (*sqliteDB, error) {
+	if len(data) < 100 || string(data[:16]) != sqliteHeader {
+		return nil, errors.New("not a sqlite3 database")
+	}
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || len(data)%pageSize != 0 {
+		return nil, errors.Errorf("invalid sqlite3 page size %d", pageSize)
+	}
+	if enc := binary.BigEndian.Uint32(data[56:60]); enc > 1 {
+		return nil, errors.Errorf("unsupported sqlite3 text encoding %d, only utf-8 supported", enc)
+	}
+	return &sqliteDB{data: data, pageSize: pageSize, usable: pageSize - int(data[20])}, nil
+}
+
+// Rows returns all rows of the table, in rowid order
+func (db *sqliteDB) Rows(table string) ([]sqliteRow, error) {
+	master, err := db.records(1)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read sqlite_master")
+	}
+	for _, rec := range master {
+		if len(rec.values) < 5 || rec.values[0] != "table" || !strings.EqualFold(toString(rec.values[1]), table) {
+			continue
+		}
+		root, ok := rec.values[3].(int64)
+		if !ok {
+			return nil, errors.Errorf("invalid root page of table %s", table)
+		}
+		columns, pk := sqliteColumns(toString(rec.values[4]))
+		recs, err := db.records(int(root))
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read table %s", table)
+		}
+		res := make([]sqliteRow, 0, len(recs))
+		for _, r := range recs {
+			row := sqliteRow{}
+			for i, col := range columns {
+				if i < len(r.values) {
+					row[col] = r.values[i]
+				}
+				if i == pk {
+					row[col] = r.rowid // integer primary key stored as rowid
+				}
+			}
+			res = append(res, row)
+		}
+		return res, nil
+	}
+	return nil, errors.Errorf("no table %s", table)
+}
+
+type sqliteRecord struct {
+	rowid  int64
+	values []interface{}
+}
+
+// records walks table b-tree from root page and returns all records
+func (db *sqliteDB) records(root int) (res []sqliteRecord, err error) {
+	var walk func(pgno, depth int) error
+	walk = func(pgno, depth int) error {
+		if depth > 64 {
+			return errors.New("b-tree too deep")
+		}
+		page, hdr, err := db.page(pgno)
+		if err != nil {
+			return err
+		}
+		ncells := int(binary.BigEndian.Uint16(page[hdr+3:]))
+		if hdr+12+ncells*2 > len(page) {
+			return errors.Errorf("invalid cells count on page %d", pgno)
+		}
+		switch page[hdr] {
+		case 0x0d: // table leaf
+			for i := 0; i < ncells; i++ {
+				rec, err := db.leafCell(page, int(binary.BigEndian.Uint16(page[hdr+8+i*2:])))
+				if err != nil {
+					return errors.Wrapf(err, "page %d, cell %d", pgno, i)
+				}
+				res = append(res, rec)
+			}
+			return nil
+		case 0x05: // table interior
+			for i := 0; i < ncells; i++ {
+				ptr := int(binary.BigEndian.Uint16(page[hdr+12+i*2:]))
+				if ptr+4 > len(page) {
+					return errors.Errorf("invalid cell pointer on page %d", pgno)
+				}
+				if err := walk(int(binary.BigEndian.Uint32(page[ptr:])), depth+1); err != nil {
+					return err
+				}
+			}
+			return walk(int(binary.BigEndian.Uint32(page[hdr+8:])), depth+1)
+		}
+		return errors.Errorf("page %d is not a table b-tree page", pgno)
+	}
+	err = walk(root, 0)
+	return res, err
+}
+
+// page returns page content and offset of b-tree header in it, page 1 starts after the database header
+func (db *sqliteDB) page(pgno int) ([]byte, int, error) {
+	if pgno < 1 || pgno*db.pageSize > len(db.data) {
+		return nil, 0, errors.Errorf("invalid page %d", pgno)
+	}
+	hdr := 0
+	if pgno == 1 {
+		hdr = 100
+	}
+	return db.data[(pgno-1)*db.pageSize : pgno*db.pageSize], hdr, nil
+}
+
+// leafCell parses cell of table leaf page, payload spilled to overflow pages collected
+func (db *sqliteDB) leafCell(page []byte, ptr int) (sqliteRecord, error) {
+	if ptr >= len(page) {
+		return sqliteRecord{}, errors.New("invalid cell pointer")
+	}
+	size, n := sqliteVarint(page[ptr:])
+	ptr += n
+	rowid, rn := sqliteVarint(page[ptr:])
+	ptr += rn
+
+	if n == 0 || size > uint64(len(db.data)) {
+		return sqliteRecord{}, errors.New("invalid payload size") // payload can't be larger than the database
+	}
+	total := int(size)
+	local := db.localPayload(total)
+	if ptr+local > len(page) {
+		return sqliteRecord{}, errors.New("invalid payload size")
+	}
+	payload := make([]byte, 0, total)
+	payload = append(payload, page[ptr:ptr+local]...)
+	if local < total {
+		if ptr+local+4 > len(page) {
+			return sqliteRecord{}, errors.New("invalid overflow pointer")
+		}
+		next := int(binary.BigEndian.Uint32(page[ptr+local:]))
+		for len(payload) < total {
+			ovf, _, err := db.page(next)
+			if err != nil {
+				return sqliteRecord{}, errors.Wrap(err, "overflow page")
+			}
+			chunk := ovf[4:db.usable]
+			if rest := total - len(payload); rest < len(chunk) {
+				chunk = chunk[:rest]
+			}
+			payload = append(payload, chunk...)
+			next = int(binary.BigEndian.Uint32(ovf))
+		}
+	}
+
+	values, err := sqliteValues(payload)
+	return sqliteRecord{rowid: int64(rowid), values: values}, err
+}
+
+// localPayload returns part of the payload stored on b-tree page, the rest goes to overflow pages
+func (db *sqliteDB) localPayload(total int) int {
+	maxLocal := db.usable - 35
+	if total <= maxLocal {
+		return total
+	}
+	minLocal := (db.usable-12)*32/255 - 23
+	k := minLocal + (total-minLocal)%(db.usable-4)
+	if k <= maxLocal {
+		return k
+	}
+	return minLocal
+}
+
+// sqliteValues decodes record, header with serial types followed by values
+func sqliteValues(rec []byte) ([]interface{}, error) {
+	hdrSize, n := sqliteVarint(rec)
+	if n == 0 || hdrSize > uint64(len(rec)) {
+		return nil, errors.New("invalid record header")
+	}
+	types := []uint64{}
+	for pos := n; pos < int(hdrSize); {
+		t, n := sqliteVarint(rec[pos:])
+		if n == 0 {
+			return nil, errors.New("invalid record header")
+		}
+		types = append(types, t)
+		pos += n
+	}
+
+	res := make([]interface{}, 0, len(types))
+	body := rec[hdrSize:]
+	for _, t := range types {
+		size := sqliteValueSize(t)
+		if size > uint64(len(body)) {
+			return nil, errors.New("record truncated")
+		}
+		v := body[:size]
+		body = body[size:]
+		switch {
+		case t == 0:
+			res = append(res, nil)
+		case t >= 1 && t <= 6:
+			i := int64(0)
+			if v[0]&0x80 != 0 {
+				i = -1 // sign extension
+			}
+			for _, b := range v {
+				i = i<<8 | int64(b)
+			}
+			res = append(res, i)
+		case t == 7:
+			res = append(res, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case t == 8 || t == 9:
+			res = append(res, int64(t-8))
+		case t >= 12 && t%2 == 0:
+			res = append(res, append([]byte{}, v...))
+		case t >= 13:
+			res = append(res, string(v))
+		default:
+			return nil, errors.Errorf("invalid serial type %d", t)
+		}
+	}
+	return res, nil
+}
+
+// sqliteValueSize returns size of the value with serial type t, kept as uint64 to be checked against record size
+func sqliteValueSize(t uint64) uint64 {
+	switch {
+	case t <= 4:
+		return t
+	case t == 5:
+		return 6
+	case t == 6 || t == 7:
+		return 8
+	case t >= 12:
+		return (t - 12) / 2
+	}
+	return 0
+}
+
+// sqliteVarint decodes huffman-like varint of sqlite, returns value and number of bytes used, 0 if truncated
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
+
+// sqliteColumns parses column names from CREATE TABLE statement, returns index of INTEGER PRIMARY KEY column or -1
+func sqliteColumns(sql string) (columns []string, pk int) {
+	pk = -1
+	start, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if start < 0 || end <= start {
+		return nil, pk
+	}
+	depth, from := 0, start+1
+	defs := []string{}
+	for i := start + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				defs = append(defs, sql[from:i])
+				from = i + 1
+			}
+		}
+	}
+	defs = append(defs, sql[from:end])
+
+	for _, def := range defs {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "CONSTRAINT":
+			continue // table constraint
+		}
+		if strings.Contains(strings.ToUpper(strings.Join(fields[1:], " ")), "INTEGER PRIMARY KEY") {
+			pk = len(columns)
+		}
+		columns = append(columns, strings.Trim(fields[0], "`\"[]"))
+	}
+	return columns, pk
+}
+
+func toString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	}
+	return ""
+}
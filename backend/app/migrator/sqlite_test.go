@@ -0,0 +1,101 @@
+package migrator
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSqlite_Rows(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/isso.db")
+	require.NoError(t, err)
+	db, err := newSqliteDB(data)
+	require.NoError(t, err)
+
+	threads, err := db.Rows("threads")
+	require.NoError(t, err)
+	assert.Equal(t, []sqliteRow{
+		{"id": int64(1), "uri": "/post/1/", "title": "Post 1"},
+		{"id": int64(2), "uri": "/post/2/", "title": "Post 2"},
+	}, threads)
+
+	comments, err := db.Rows("comments")
+	require.NoError(t, err)
+	require.Equal(t, 156, len(comments), "rows from interior and leaf pages")
+	assert.Equal(t, int64(1), comments[0]["id"])
+	assert.Equal(t, nil, comments[0]["parent"])
+	assert.Equal(t, 1577880000.25, comments[0]["created"])
+	assert.Equal(t, "**hello** world", comments[0]["text"])
+	assert.Equal(t, int64(3), comments[0]["likes"])
+	assert.Equal(t, make([]byte, 8), comments[0]["voters"])
+	assert.Equal(t, strings.Repeat("long ", 600), comments[5]["text"], "payload from overflow pages")
+	assert.Equal(t, int64(156), comments[155]["id"])
+
+	_, err = db.Rows("nope")
+	assert.EqualError(t, err, "no table nope")
+
+	_, err = newSqliteDB([]byte("not a database"))
+	assert.EqualError(t, err, "not a sqlite3 database")
+	_, err = newSqliteDB(data[:1000])
+	assert.Error(t, err)
+}
+
+func TestSqlite_Columns(t *testing.T) {
+	tbl := []struct {
+		sql     string
+		columns []string
+		pk      int
+	}{
+		{"CREATE TABLE t (id INTEGER PRIMARY KEY, uri VARCHAR(256) UNIQUE, title VARCHAR(256))", []string{"id", "uri", "title"}, 0},
+		{"CREATE TABLE t (tid REFERENCES threads(id), id integer primary key, parent INTEGER)", []string{"tid", "id", "parent"}, 1},
+		{"CREATE TABLE t (key VARCHAR, `value` VARCHAR, PRIMARY KEY (key))", []string{"key", "value"}, -1},
+		{"CREATE TABLE t", nil, -1},
+	}
+	for i, tt := range tbl {
+		columns, pk := sqliteColumns(tt.sql)
+		assert.Equal(t, tt.columns, columns, "case #%d", i)
+		assert.Equal(t, tt.pk, pk, "case #%d", i)
+	}
+}
+
+func TestSqlite_Varint(t *testing.T) {
+	tbl := []struct {
+		inp []byte
+		val uint64
+		n   int
+	}{
+		{[]byte{0x7f}, 127, 1},
+		{[]byte{0x81, 0x00}, 128, 2},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 0xffffffffffffffff, 9},
+		{[]byte{0x81}, 0, 0},
+	}
+	for i, tt := range tbl {
+		val, n := sqliteVarint(tt.inp)
+		assert.Equal(t, tt.val, val, "case #%d", i)
+		assert.Equal(t, tt.n, n, "case #%d", i)
+	}
+}
+
+func TestSqlite_Corrupted(t *testing.T) {
+	huge := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	// huge header size
+	_, err := sqliteValues(huge)
+	assert.EqualError(t, err, "invalid record header")
+
+	// huge serial type
+	rec := append([]byte{10}, huge...)
+	_, err = sqliteValues(rec)
+	assert.EqualError(t, err, "record truncated")
+
+	// huge payload size of the cell
+	db := &sqliteDB{data: make([]byte, 1024), pageSize: 512, usable: 512}
+	page := append(append([]byte{}, huge...), 0x01)
+	_, err = db.leafCell(page, 0)
+	assert.EqualError(t, err, "invalid payload size")
+	_, err = db.leafCell([]byte{0x81}, 0)
+	assert.EqualError(t, err, "invalid payload size")
+}
@@ -0,0 +1,87 @@
+package migrator
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Target stores backup files, i.e. local directory, S3 bucket or SFTP server
+type Target interface {
+	fmt.Stringer
+	Put(name, file string) error            // copies local file to the target under name, replaces existing
+	Get(name string) (io.ReadCloser, error) // opens stored file for reading
+	List(prefix string) ([]string, error)   // returns sorted names of stored files with prefix
+	Remove(name string) error
+}
+
+// LocalTarget keeps backups in local directory, i.e. mounted network storage
+type LocalTarget struct {
+	Location string
+}
+
+// Put copies file to temporary file in the location and renames it to the name, so partial copy never visible
+func (l *LocalTarget) Put(name, file string) (err error) {
+	if err = os.MkdirAll(l.Location, 0700); err != nil {
+		return errors.Wrapf(err, "can't make directory %s", l.Location)
+	}
+	src, err := os.Open(file) // nolint
+	if err != nil {
+		return errors.Wrapf(err, "can't open %s", file)
+	}
+	defer src.Close() // nolint
+
+	dst, err := ioutil.TempFile(l.Location, name+".tmp-")
+	if err != nil {
+		return errors.Wrapf(err, "can't make temporary file in %s", l.Location)
+	}
+	defer func() {
+		if err != nil {
+			_ = dst.Close()
+			_ = os.Remove(dst.Name())
+		}
+	}()
+	if _, err = io.Copy(dst, src); err != nil {
+		return errors.Wrapf(err, "can't copy %s to %s", file, l.Location)
+	}
+	if err = dst.Close(); err != nil {
+		return errors.Wrapf(err, "can't close %s", dst.Name())
+	}
+	return os.Rename(dst.Name(), filepath.Join(l.Location, name))
+}
+
+// Get opens file from the location
+func (l *LocalTarget) Get(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.Location, name)) // nolint
+}
+
+// List returns names of files in the location with prefix
+func (l *LocalTarget) List(prefix string) ([]string, error) {
+	files, err := ioutil.ReadDir(l.Location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read directory %s", l.Location)
+	}
+	res := []string{}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasPrefix(f.Name(), prefix) {
+			res = append(res, f.Name())
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Remove deletes file from the location
+func (l *LocalTarget) Remove(name string) error {
+	return os.Remove(filepath.Join(l.Location, name))
+}
+
+func (l *LocalTarget) String() string {
+	return "dir:" + l.Location
+}
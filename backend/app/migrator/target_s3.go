@@ -0,0 +1,170 @@
+package migrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/sigv4"
+)
+
+// S3Target keeps backups in S3 compatible storage, i.e. AWS, MinIO or Ceph, under Prefix of the Bucket.
+// Uses path-style requests, uploads signed with sha256 of the file.
+type S3Target struct {
+	Endpoint  string // i.e. https://s3.amazonaws.com or http://minio:9000
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Client    http.Client
+}
+
+// Put uploads file as the object
+func (s *S3Target) Put(name, file string) error {
+	fh, err := os.Open(file) // nolint
+	if err != nil {
+		return errors.Wrapf(err, "can't open %s", file)
+	}
+	defer fh.Close() // nolint
+	h := sha256.New()
+	size, err := io.Copy(h, fh)
+	if err != nil {
+		return errors.Wrapf(err, "can't read %s", file)
+	}
+	if _, err = fh.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "can't rewind %s", file)
+	}
+
+	req, err := http.NewRequest("PUT", s.objectURL(s.key(name)), fh)
+	if err != nil {
+		return errors.Wrapf(err, "can't make request for %s", name)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(req, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return errors.Wrapf(err, "can't upload %s", name)
+	}
+	return resp.Body.Close()
+}
+
+// Get downloads the object
+func (s *S3Target) Get(name string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", s.objectURL(s.key(name)), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't make request for %s", name)
+	}
+	resp, err := s.do(req, sigv4.EmptyPayload)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't download %s", name)
+	}
+	return resp.Body, nil
+}
+
+// List returns names of objects with prefix, going through all pages of ListObjectsV2
+func (s *S3Target) List(prefix string) ([]string, error) {
+	var listResp struct {
+		Contents []struct {
+			Key string `xml:"Key"`
+		} `xml:"Contents"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+
+	res := []string{}
+	query := url.Values{"list-type": {"2"}, "prefix": {s.key(prefix)}}
+	for {
+		req, err := http.NewRequest("GET", s.objectURL("")+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't make list request")
+		}
+		resp, err := s.do(req, sigv4.EmptyPayload)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't list %s", prefix)
+		}
+		listResp.Contents, listResp.IsTruncated, listResp.NextContinuationToken = nil, false, ""
+		err = xml.NewDecoder(resp.Body).Decode(&listResp)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't decode list of %s", prefix)
+		}
+		for _, obj := range listResp.Contents {
+			if name := strings.TrimPrefix(obj.Key, s.key("")); !strings.Contains(name, "/") {
+				res = append(res, name)
+			}
+		}
+		if !listResp.IsTruncated || listResp.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", listResp.NextContinuationToken)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Remove deletes the object
+func (s *S3Target) Remove(name string) error {
+	req, err := http.NewRequest("DELETE", s.objectURL(s.key(name)), nil)
+	if err != nil {
+		return errors.Wrapf(err, "can't make request for %s", name)
+	}
+	resp, err := s.do(req, sigv4.EmptyPayload)
+	if err != nil {
+		return errors.Wrapf(err, "can't remove %s", name)
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3Target) String() string {
+	return "s3:" + s.Bucket + "/" + s.key("")
+}
+
+// do signs and sends request, returns response with body to close if status is 2xx
+func (s *S3Target) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	signer := sigv4.Signer{AccessKey: s.AccessKey, SecretKey: s.SecretKey, Region: s.Region}
+	signer.Sign(req, payloadHash, time.Now())
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close() // nolint
+	var errResp struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if e := xml.Unmarshal(body, &errResp); e == nil && errResp.Code != "" {
+		return nil, errors.Errorf("%s request failed with %d, %s: %s", req.Method, resp.StatusCode, errResp.Code, errResp.Message)
+	}
+	return nil, errors.Errorf("%s request failed with %d", req.Method, resp.StatusCode)
+}
+
+func (s *S3Target) objectURL(key string) string {
+	u := strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket
+	if key != "" {
+		u += "/" + sigv4.EscapePath(key)
+	}
+	return u
+}
+
+// key returns object key for the name, prefix ends with slash if set
+func (s *S3Target) key(name string) string {
+	if p := strings.Trim(s.Prefix, "/"); p != "" {
+		return p + "/" + name
+	}
+	return name
+}
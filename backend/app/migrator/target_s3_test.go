@@ -0,0 +1,115 @@
+package migrator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/sigv4"
+)
+
+func TestS3Target(t *testing.T) {
+	stub := &s3TargetStub{t: t, objects: map[string][]byte{}}
+	ts := httptest.NewServer(stub)
+	defer ts.Close()
+
+	loc, err := ioutil.TempDir("", "remark-target-s3")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+	require.NoError(t, ioutil.WriteFile(loc+"/src", []byte("backup data"), 0600))
+
+	target := &S3Target{Endpoint: ts.URL, Region: "us-east-1", Bucket: "remark", Prefix: "/backups/", AccessKey: "key",
+		SecretKey: "secret", Client: http.Client{Timeout: time.Second}}
+	assert.Equal(t, "s3:remark/backups/", target.String())
+	testTarget(t, target, loc+"/src")
+	assert.Equal(t, []string{"backups/backup-site1-20200102.gz", "backups/backup-site2-20200102.gz"}, stub.keys())
+
+	target.SecretKey = "bad"
+	err = target.Put("backup-site1-20200103.gz", loc+"/src")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "PUT request failed with 403, SignatureDoesNotMatch")
+}
+
+// s3TargetStub is a minimal in-memory S3 bucket "remark", lists by two objects per page
+type s3TargetStub struct {
+	t       *testing.T
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func (st *s3TargetStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(st.t, err)
+	signer := sigv4.Signer{AccessKey: "key", SecretKey: "secret", Region: "us-east-1"}
+	auth := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), sigv4.Algorithm+" "), ", ")
+	payloadHash := r.Header.Get("x-amz-content-sha256")
+	if len(auth) != 3 || payloadHash != sigv4.HashHex(body) ||
+		strings.TrimPrefix(auth[2], "Signature=") != signer.Signature(r, r.URL.Query(),
+			strings.Split(strings.TrimPrefix(auth[1], "SignedHeaders="), ";"), payloadHash, r.Header.Get("x-amz-date")) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("<Error><Code>SignatureDoesNotMatch</Code><Message>bad signature</Message></Error>"))
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/remark/")
+	switch {
+	case r.URL.Path == "/remark" && r.Method == "GET":
+		st.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("continuation-token"))
+	case r.Method == "PUT":
+		st.objects[key] = body
+	case r.Method == "GET":
+		data, ok := st.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("<Error><Code>NoSuchKey</Code><Message>no such key</Message></Error>"))
+			return
+		}
+		_, _ = w.Write(data)
+	case r.Method == "DELETE":
+		delete(st.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (st *s3TargetStub) list(w http.ResponseWriter, prefix, token string) {
+	keys := []string{}
+	for k := range st.objects {
+		if strings.HasPrefix(k, prefix) && k > token {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	res := "<ListBucketResult>"
+	for i, k := range keys {
+		if i == 2 {
+			res += fmt.Sprintf("<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[1])
+			break
+		}
+		res += "<Contents><Key>" + k + "</Key></Contents>"
+	}
+	_, _ = w.Write([]byte(res + "</ListBucketResult>"))
+}
+
+func (st *s3TargetStub) keys() []string {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	res := []string{}
+	for k := range st.objects {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,152 @@
+package migrator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SFTPTarget keeps backups in the directory of SFTP server. Runs sftp client of OpenSSH in batch mode,
+// so authentication is by key only and the server should be in known hosts.
+type SFTPTarget struct {
+	Addr    string // user@host
+	Port    int
+	Path    string // remote directory
+	KeyFile string // private key, default keys of ssh used if not set
+	Command string // sftp client, "sftp" if not set
+	Timeout time.Duration
+}
+
+// Put uploads file to temporary name and renames it, so partial upload never visible
+func (s *SFTPTarget) Put(name, file string) error {
+	remote, tmp := s.remote(name), s.remote(name+".tmp")
+	cmds := []string{
+		fmt.Sprintf("put %s %s", quoteSFTP(file), quoteSFTP(tmp)),
+		fmt.Sprintf("-rm %s", quoteSFTP(remote)),
+		fmt.Sprintf("rename %s %s", quoteSFTP(tmp), quoteSFTP(remote)),
+	}
+	if _, err := s.run(cmds...); err != nil {
+		return errors.Wrapf(err, "can't upload %s", name)
+	}
+	return nil
+}
+
+// Get downloads file to temporary local file, removed on close of returned reader
+func (s *SFTPTarget) Get(name string) (io.ReadCloser, error) {
+	dir, err := ioutil.TempDir("", "remark42-sftp")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make temporary directory")
+	}
+	local := filepath.Join(dir, "download")
+	if _, err = s.run(fmt.Sprintf("get %s %s", quoteSFTP(s.remote(name)), quoteSFTP(local))); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, errors.Wrapf(err, "can't download %s", name)
+	}
+	fh, err := os.Open(local) // nolint
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, errors.Wrapf(err, "can't open downloaded %s", name)
+	}
+	return &tempFileReader{File: fh, dir: dir}, nil
+}
+
+// List returns names of files in remote directory with prefix
+func (s *SFTPTarget) List(prefix string) ([]string, error) {
+	out, err := s.run(fmt.Sprintf("ls -1 %s", quoteSFTP(s.remote(""))))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list files")
+	}
+	res := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "sftp>") {
+			continue // command echoed in batch mode
+		}
+		if name := path.Base(line); strings.HasPrefix(name, prefix) {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Remove deletes remote file
+func (s *SFTPTarget) Remove(name string) error {
+	if _, err := s.run(fmt.Sprintf("rm %s", quoteSFTP(s.remote(name)))); err != nil {
+		return errors.Wrapf(err, "can't remove %s", name)
+	}
+	return nil
+}
+
+func (s *SFTPTarget) String() string {
+	return "sftp:" + s.Addr + ":" + s.Path
+}
+
+// run executes batch of sftp commands, batch aborted on the first failed command not prefixed by "-"
+func (s *SFTPTarget) run(cmds ...string) ([]byte, error) {
+	command := s.Command
+	if command == "" {
+		command = "sftp"
+	}
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if s.Port > 0 {
+		args = append(args, "-P", strconv.Itoa(s.Port))
+	}
+	if s.KeyFile != "" {
+		args = append(args, "-i", s.KeyFile)
+	}
+	args = append(args, s.Addr)
+
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, command, args...) // nolint
+	cmd.Stdin = strings.NewReader(strings.Join(cmds, "\n") + "\n")
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "sftp failed, %s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (s *SFTPTarget) remote(name string) string {
+	if name == "" && s.Path == "" {
+		return "."
+	}
+	return path.Join(s.Path, name)
+}
+
+// quoteSFTP makes argument of sftp batch command
+func quoteSFTP(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// tempFileReader removes directory of the file on close
+type tempFileReader struct {
+	*os.File
+	dir string
+}
+
+func (t *tempFileReader) Close() error {
+	err := t.File.Close()
+	if e := os.RemoveAll(t.dir); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
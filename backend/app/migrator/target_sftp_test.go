@@ -0,0 +1,56 @@
+package migrator
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSFTP is a stand-in for sftp client, runs batch commands on local files. Echoes commands as sftp does
+// and fails on the first failed command not prefixed by "-". Arguments saved to args file next to the script
+const fakeSFTP = `#!/bin/sh
+echo "$@" > "$(dirname "$0")/args"
+while read -r line; do
+	echo "sftp> $line"
+	ignore=0
+	case "$line" in -*) ignore=1; line="${line#-}";; esac
+	eval "set -- $line"
+	cmd="$1"; shift
+	case "$cmd" in
+		put|get) cp "$1" "$2" ;;
+		rename) mv "$1" "$2" ;;
+		rm) rm "$1" ;;
+		ls) ls "$2" ;;
+		*) false ;;
+	esac
+	if [ $? -ne 0 ] && [ $ignore -eq 0 ]; then
+		echo "command failed: $cmd" >&2
+		exit 1
+	fi
+done
+`
+
+func TestSFTPTarget(t *testing.T) {
+	loc, err := ioutil.TempDir("", "remark-target-sftp")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+	require.NoError(t, ioutil.WriteFile(loc+"/sftp", []byte(fakeSFTP), 0700)) // nolint
+	require.NoError(t, ioutil.WriteFile(loc+"/src", []byte("backup data"), 0600))
+	require.NoError(t, os.MkdirAll(loc+"/remote", 0700))
+
+	target := &SFTPTarget{Addr: "user@example.com", Port: 2222, Path: loc + "/remote", KeyFile: "/keys/id", Command: loc + "/sftp"}
+	assert.Equal(t, "sftp:user@example.com:"+loc+"/remote", target.String())
+	testTarget(t, target, loc+"/src")
+
+	args, err := ioutil.ReadFile(loc + "/args")
+	require.NoError(t, err)
+	assert.Equal(t, "-b - -o BatchMode=yes -P 2222 -i /keys/id user@example.com", strings.TrimSpace(string(args)))
+
+	err = target.Remove("not-found")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "command failed: rm")
+}
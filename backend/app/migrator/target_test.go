@@ -0,0 +1,52 @@
+package migrator
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalTarget(t *testing.T) {
+	loc, err := ioutil.TempDir("", "remark-target")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+	require.NoError(t, ioutil.WriteFile(loc+"/src", []byte("backup data"), 0600))
+
+	target := &LocalTarget{Location: loc + "/dst"}
+	assert.Equal(t, "dir:"+loc+"/dst", target.String())
+	testTarget(t, target, loc+"/src")
+}
+
+// testTarget checks put, get, list and remove of the target
+func testTarget(t *testing.T, target Target, src string) {
+	data, err := ioutil.ReadFile(src)
+	require.NoError(t, err)
+
+	require.NoError(t, target.Put("backup-site1-20200101.gz", src))
+	require.NoError(t, target.Put("backup-site1-20200102.gz", src))
+	require.NoError(t, target.Put("backup-site2-20200102.gz", src))
+	require.NoError(t, target.Put("backup-site1-20200101.gz", src), "replaced")
+
+	names, err := target.List("backup-site1-")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backup-site1-20200101.gz", "backup-site1-20200102.gz"}, names)
+
+	rc, err := target.Get("backup-site1-20200101.gz")
+	require.NoError(t, err)
+	res, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, data, res)
+
+	require.NoError(t, target.Remove("backup-site1-20200101.gz"))
+	names, err = target.List("backup-")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backup-site1-20200102.gz", "backup-site2-20200102.gz"}, names)
+
+	_, err = target.Get("backup-site1-20200101.gz")
+	assert.Error(t, err)
+	assert.Error(t, target.Put("backup-site1-20200103.gz", src+"-not-found"))
+}
@@ -14,7 +14,7 @@ import (
 
 const wpTimeLayout = "2006-01-02 15:04:05"
 
-// WordPress implements Importer from WP xml
+// WordPress implements Importer from WP xml and Exporter to WP xml (WXR)
 type WordPress struct {
 	DataStore Store
 }
@@ -68,7 +68,7 @@ func (w *WordPress) Import(r io.Reader, siteID string) (size int, err error) {
 	commentsCh := w.convert(r, siteID)
 	failed, passed := 0, 0
 	for c := range commentsCh {
-		if _, err = w.DataStore.Create(c); err != nil {
+		if _, err = w.DataStore.Import(c); err != nil {
 			failed++
 			continue
 		}
@@ -155,3 +155,123 @@ func (w *WordPress) convert(r io.Reader, siteID string) chan store.Comment {
 	}()
 	return commentsCh
 }
+
+type wpExportItem struct {
+	XMLName       xml.Name          `xml:"item"`
+	Title         string            `xml:"title"`
+	Link          string            `xml:"link"`
+	GUID          wpExportGUID      `xml:"guid"`
+	PostID        int               `xml:"wp:post_id"`
+	PostDate      string            `xml:"wp:post_date_gmt"`
+	CommentStatus string            `xml:"wp:comment_status"`
+	Status        string            `xml:"wp:status"`
+	PostType      string            `xml:"wp:post_type"`
+	Comments      []wpExportComment `xml:"wp:comment"`
+}
+
+type wpExportGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	URL         string `xml:",chardata"`
+}
+
+type wpExportComment struct {
+	ID          int          `xml:"wp:comment_id"`
+	Author      string       `xml:"wp:comment_author"`
+	AuthorEmail string       `xml:"wp:comment_author_email"`
+	AuthorURL   string       `xml:"wp:comment_author_url"`
+	AuthorIP    string       `xml:"wp:comment_author_IP"` // always empty, only hash of ip stored
+	Date        string       `xml:"wp:comment_date"`
+	DateGMT     string       `xml:"wp:comment_date_gmt"`
+	Content     wpExportText `xml:"wp:comment_content"`
+	Approved    string       `xml:"wp:comment_approved"`
+	Type        string       `xml:"wp:comment_type"`
+	PID         int          `xml:"wp:comment_parent"`
+	UserID      int          `xml:"wp:comment_user_id"`
+}
+
+type wpExportText struct {
+	Text string `xml:",cdata"`
+}
+
+const wpExportHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:excerpt="http://wordpress.org/export/1.2/excerpt/" ` +
+	`xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:wfw="http://wellformedweb.org/CommentAPI/" ` +
+	`xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+`
+
+// Export all comments of the site to WP xml, one item per post. WP needs numeric ids, comments and posts
+// numbered in export order. Deleted comments exported as "trash" and comments of blocked users as "spam"
+func (w *WordPress) Export(wr io.Writer, siteID string) (size int, err error) {
+	users, posts, err := exportMetas(w.DataStore, siteID)
+	if err != nil {
+		return 0, err
+	}
+	topics, err := w.DataStore.List(siteID, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = io.WriteString(wr, wpExportHeader); err != nil {
+		return 0, errors.Wrap(err, "can't write WP header")
+	}
+	enc := xml.NewEncoder(wr)
+	enc.Indent("  ", "  ")
+	if err = enc.Encode(struct {
+		XMLName xml.Name `xml:"title"`
+		Title   string   `xml:",chardata"`
+	}{Title: siteID}); err != nil {
+		return 0, errors.Wrap(err, "can't write WP channel title")
+	}
+	if err = enc.Encode(struct {
+		XMLName xml.Name `xml:"wp:wxr_version"`
+		Version string   `xml:",chardata"`
+	}{Version: "1.2"}); err != nil {
+		return 0, errors.Wrap(err, "can't write WP version")
+	}
+
+	for i := len(topics) - 1; i >= 0; i-- { // topics from List sorted in opposite direction
+		topic := topics[i]
+		comments, e := w.DataStore.Find(store.Locator{SiteID: siteID, URL: topic.URL}, "time", adminUser)
+		if e != nil {
+			return size, e
+		}
+		item := wpExportItem{Title: topic.URL, Link: topic.URL, GUID: wpExportGUID{IsPermaLink: true, URL: topic.URL},
+			PostID: len(topics) - i, PostDate: topic.FirstTS.UTC().Format(wpTimeLayout), CommentStatus: "open",
+			Status: "publish", PostType: "post"}
+		if topic.ReadOnly || posts[topic.URL].ReadOnly {
+			item.CommentStatus = "closed"
+		}
+		if title := exportTitle(comments); title != "" {
+			item.Title = title
+		}
+
+		ids := make(map[string]int, len(comments))
+		for j, c := range comments {
+			ids[c.ID] = size + j + 1
+		}
+		for _, c := range comments {
+			ts := c.Timestamp.UTC().Format(wpTimeLayout)
+			wc := wpExportComment{ID: ids[c.ID], Author: c.User.Name, AuthorEmail: users[c.User.ID].Details.Email,
+				Date: ts, DateGMT: ts, Content: wpExportText{Text: c.Text}, Approved: "1",
+				PID: ids[c.ParentID]}
+			switch {
+			case c.Deleted:
+				wc.Approved = "trash"
+			case users[c.User.ID].Blocked.Status:
+				wc.Approved = "spam"
+			}
+			item.Comments = append(item.Comments, wc)
+		}
+		if err = enc.Encode(item); err != nil {
+			return size, errors.Wrapf(err, "can't write WP item %s", topic.URL)
+		}
+		size += len(comments)
+	}
+
+	if _, err = io.WriteString(wr, "\n</channel>\n</rss>\n"); err != nil {
+		return size, errors.Wrap(err, "can't write WP footer")
+	}
+	log.Printf("[DEBUG] exported %d comments of %d posts to WP xml", size, len(topics))
+	return size, nil
+}
@@ -1,6 +1,7 @@
 package migrator
 
 import (
+	"bytes"
 	"os"
 	"strings"
 	"testing"
@@ -101,6 +102,58 @@ func TestWP_Convert_MD(t *testing.T) {
 	assert.Equal(t, expText, comments[2].Text)
 }
 
+func TestWordPress_Export(t *testing.T) {
+	b, teardown := prep(t) // write 2 comments
+	defer teardown()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{ID: "reply", ParentID: "efbc17f177ee1a1c0ee6e1e025749966ec071adc", Text: "reply ]]> text",
+		Timestamp: time.Date(2017, 12, 20, 23, 19, 22, 0, time.UTC), PostTitle: "post title",
+		Locator: locator, User: store.User{ID: "user1", Name: "user name", IP: "ip-hash"}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "deleted", ParentID: "reply", Text: "to be deleted",
+		Timestamp: time.Date(2017, 12, 20, 23, 20, 22, 0, time.UTC), Locator: locator, User: store.User{ID: "user1", Name: "user name"}})
+	require.NoError(t, err)
+	require.NoError(t, b.Delete(locator, "deleted", store.SoftDelete))
+	_, err = b.SetUserEmail("radio-t", "user1", "user1@example.com")
+	require.NoError(t, err)
+	require.NoError(t, b.SetBlock("radio-t", "user2", true, time.Hour))
+	require.NoError(t, b.SetReadOnly(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, true))
+
+	wp := WordPress{DataStore: b}
+	buf := &bytes.Buffer{}
+	size, err := wp.Export(buf, "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 4, size)
+	t.Log(buf.String())
+
+	res := buf.String()
+	assert.True(t, strings.HasPrefix(res, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+`<rss version="2.0"`))
+	assert.True(t, strings.HasSuffix(res, "</channel>\n</rss>\n"))
+	assert.Contains(t, res, "<wp:wxr_version>1.2</wp:wxr_version>")
+	assert.Contains(t, res, "<title>post title</title>")
+	assert.Contains(t, res, "<wp:comment_status>closed</wp:comment_status>")
+	assert.Contains(t, res, "<wp:comment_author_email>user1@example.com</wp:comment_author_email>")
+	assert.Contains(t, res, "<wp:comment_date_gmt>2017-12-20 23:19:22</wp:comment_date_gmt>")
+	assert.Contains(t, res, "<wp:comment_approved>trash</wp:comment_approved>")
+	assert.Contains(t, res, "<wp:comment_approved>spam</wp:comment_approved>")
+	assert.NotContains(t, res, "ip-hash", "hashed ip not exported")
+
+	// exported file readable by WP importer, deleted and spam comments skipped
+	var comments []store.Comment
+	for c := range wp.convert(strings.NewReader(res), "radio-t") {
+		comments = append(comments, c)
+	}
+	require.Equal(t, 2, len(comments))
+	assert.Equal(t, "1", comments[0].ID)
+	assert.Equal(t, "", comments[0].ParentID)
+	assert.Equal(t, "https://radio-t.com", comments[0].Locator.URL)
+	assert.Equal(t, "user name", comments[0].User.Name)
+	assert.Equal(t, "2", comments[1].ID)
+	assert.Equal(t, "1", comments[1].ParentID)
+	assert.Equal(t, "<p>reply ]]&gt; text</p>\n", comments[1].Text)
+	assert.Equal(t, time.Date(2017, 12, 20, 23, 19, 22, 0, time.UTC), comments[1].Timestamp)
+}
+
 var xmlTestWP = `
 <?xml version="1.0" encoding="UTF-8" ?>
 <rss version="2.0"
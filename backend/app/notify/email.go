@@ -32,6 +32,7 @@ type Email struct {
 	sender     emailprovider.EmailSender
 	msgTmpl    *template.Template // parsed request message template
 	verifyTmpl *template.Template // parsed verification message template
+	alertTmpl  *template.Template // parsed alert message template
 }
 
 // msgTmplData store data for message from request template execution
@@ -61,6 +62,14 @@ type verifyTmplData struct {
 	SubscribeURL string
 }
 
+// alertTmplData store data for alert message template execution
+type alertTmplData struct {
+	Site    string
+	Subject string
+	Text    string
+	Email   string
+}
+
 const (
 	defaultVerificationSubject = "Email verification"
 	defaultEmailTemplate       = `<!DOCTYPE html>
@@ -158,6 +167,22 @@ const (
 	</div>
 </body>
 </html>
+`
+	emailAlertTemplate = `<!DOCTYPE html>
+<html>
+<head>
+	<meta name="viewport" content="width=device-width" />
+	<meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+</head>
+<body>
+	<div style="font-family: Helvetica, Arial, sans-serif; font-size: 18px; width: 100%; max-width: 640px; margin: auto;">
+		<h1 style="position: relative; color: #4fbbd6; margin-top: 0.2em;">Remark42</h1>
+		<p style="color:#000!important;"><b>{{.Subject | html}}</b>{{if .Site}} on site <b>{{.Site | html}}</b>{{end}}</p>
+		<pre style="white-space: pre-wrap; background-color: #eee; padding: 0.5em; border-radius: 0.2em; color:#000!important;">{{.Text | html}}</pre>
+		<p style="margin-top: 2em; font-size: 0.8em; opacity: 0.8;"><i style="color:#000!important;">Sent to {{.Email}}</i></p>
+	</div>
+</body>
+</html>
 `
 )
 
@@ -190,6 +215,9 @@ func NewEmail(emailParams EmailParams, sender emailprovider.EmailSender) (*Email
 	if res.verifyTmpl, err = template.New("messageFromRequest").Parse(res.VerificationTemplate); err != nil {
 		return nil, errors.Wrapf(err, "can't parse verification template")
 	}
+	if res.alertTmpl, err = template.New("alert").Parse(emailAlertTemplate); err != nil {
+		return nil, errors.Wrapf(err, "can't parse alert template")
+	}
 	return &res, err
 }
 
@@ -219,6 +247,13 @@ func (e *Email) Send(ctx context.Context, req Request) (err error) {
 		}
 	}
 
+	if req.Alert.Subject != "" {
+		log.Printf("[DEBUG] send alert via %s, %s", e, req.Alert.Subject)
+		if msg, err = e.buildAlertMessage(req.Alert, req.Email); err != nil {
+			return err
+		}
+	}
+
 	if req.Comment.ID != "" {
 		if req.parent.User.ID == req.Comment.User.ID && !req.ForAdmin {
 			// don't send anything if if user replied to their own comment
@@ -256,6 +291,18 @@ func (e *Email) buildVerificationMessage(user, email, token, site string) (strin
 	return msg.String(), nil
 }
 
+// buildAlertMessage generates alert email message for administrator
+func (e *Email) buildAlertMessage(alert AlertMetadata, email string) (string, error) {
+	msg := bytes.Buffer{}
+	err := e.alertTmpl.Execute(&msg, alertTmplData{Site: alert.SiteID, Subject: alert.Subject, Text: alert.Text, Email: email})
+	if err != nil {
+		return "", errors.Wrapf(err, "error executing template to build alert message")
+	}
+	e.sender.SetSubject(alert.Subject)
+	e.sender.ResetHeaders()
+	return msg.String(), nil
+}
+
 // buildMessageFromRequest generates email message based on Request using e.MsgTemplate
 func (e *Email) buildMessageFromRequest(req Request, forAdmin bool) (string, error) {
 	subject := "New reply to your comment"
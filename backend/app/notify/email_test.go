@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	emailprovider "github.com/umputun/remark/backend/app/email"
 	"github.com/umputun/remark/backend/app/store"
@@ -281,6 +282,32 @@ Date: `)
 	assert.Contains(t, res, `https://example.org/subscribe.html?token=3Dsecret_`)
 }
 
+func TestEmail_SendAlert(t *testing.T) {
+	fakeSmtp := fakeTestSMTP{}
+	email, err := NewEmail(EmailParams{From: "from@example.org"}, emailprovider.NewSMTPSender(&emailprovider.SmtpParams{}, &fakeSmtp))
+	require.NoError(t, err)
+	req := Request{
+		Email: "admin@example.org",
+		Alert: AlertMetadata{SiteID: "remark", Subject: "Backup failed", Text: "can't upload <backup>"},
+	}
+	assert.NoError(t, email.Send(context.TODO(), req))
+	assert.Equal(t, "admin@example.org", fakeSmtp.readRcpt())
+
+	res, err := email.buildAlertMessage(req.Alert, req.Email)
+	require.NoError(t, err)
+	assert.Contains(t, res, "<b>Backup failed</b> on site <b>remark</b>")
+	assert.Contains(t, res, "can&#39;t upload &lt;backup&gt;")
+	res, err = email.sender.(*emailprovider.SMTPSender).BuildMessage(req.Email, res, "text/html")
+	require.NoError(t, err)
+	assert.Contains(t, res, `From: from@example.org
+To: admin@example.org
+Subject: Backup failed
+Content-Transfer-Encoding: quoted-printable
+MIME-version: 1.0
+Content-Type: text/html; charset="UTF-8"
+Date: `)
+}
+
 func Test_emailClient_Create(t *testing.T) {
 	creator := emailprovider.SmtpCreator{}
 	client, err := creator.Create(&emailprovider.SmtpParams{})
@@ -43,6 +43,7 @@ type Request struct {
 	ForAdmin bool          // if set, message supposed to be sent to administrator
 
 	Verification VerificationMetadata // if set sent verification notification
+	Alert        AlertMetadata        // if set sent alert to administrator
 }
 
 // VerificationMetadata required to send notify method verification message
@@ -52,6 +53,13 @@ type VerificationMetadata struct {
 	Token  string
 }
 
+// AlertMetadata required to send alert about server problem, like failed backup, to administrator
+type AlertMetadata struct {
+	SiteID  string
+	Subject string
+	Text    string
+}
+
 const defaultQueueSize = 100
 const uiNav = "#remark42__comment-"
 
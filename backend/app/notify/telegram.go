@@ -86,6 +86,10 @@ func NewTelegram(token string, channelID string, timeout time.Duration, api stri
 
 // Send to telegram channel
 func (t *Telegram) Send(ctx context.Context, req Request) error {
+	if req.Alert.Subject != "" {
+		log.Printf("[DEBUG] send telegram alert to %s, %s", t.channelID, req.Alert.Subject)
+		return t.sendMessage(ctx, fmt.Sprintf("*%s*\n\n```\n%s\n```", req.Alert.Subject, req.Alert.Text))
+	}
 	if req.Comment.ID == "" {
 		// verification request received, send nothing
 		return nil
@@ -95,7 +99,6 @@ func (t *Telegram) Send(ctx context.Context, req Request) error {
 		// as we already sent message on request without this flag set
 		return nil
 	}
-	client := http.Client{Timeout: telegramTimeOut}
 	log.Printf("[DEBUG] send telegram notification to %s, comment id %s", t.channelID, req.Comment.ID)
 
 	from := req.Comment.User.Name
@@ -107,11 +110,16 @@ func (t *Telegram) Send(ctx context.Context, req Request) error {
 	if req.Comment.PostTitle != "" {
 		link = fmt.Sprintf("↦ [%s](%s)", req.Comment.PostTitle, req.Comment.Locator.URL+uiNav+req.Comment.ID)
 	}
+	msg := fmt.Sprintf("%s\n\n%s\n\n%s", from, req.Comment.Orig, link)
+	return t.sendMessage(ctx, html.UnescapeString(msg))
+}
+
+// sendMessage posts markdown message to the channel
+func (t *Telegram) sendMessage(ctx context.Context, msg string) error {
+	client := http.Client{Timeout: telegramTimeOut}
 	u := fmt.Sprintf("%s%s/sendMessage?chat_id=%s&parse_mode=Markdown&disable_web_page_preview=true",
 		t.apiPrefix, t.token, t.channelID)
 
-	msg := fmt.Sprintf("%s\n\n%s\n\n%s", from, req.Comment.Orig, link)
-	msg = html.UnescapeString(msg)
 	body := struct {
 		Text string `json:"text"`
 	}{Text: msg}
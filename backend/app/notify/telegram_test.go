@@ -2,6 +2,7 @@ package notify
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -81,6 +82,30 @@ func TestTelegram_Send(t *testing.T) {
 	require.NoError(t, tb.Send(context.TODO(), Request{}), "Empty Comment doesn't send anything")
 }
 
+func TestTelegram_SendAlert(t *testing.T) {
+	var msg string
+	router := chi.NewRouter()
+	router.Get("/good-token/getMe", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok": true, "result": {"first_name": "comments_test", "is_bot": true}}`))
+	})
+	router.Post("/good-token/sendMessage", func(w http.ResponseWriter, r *http.Request) {
+		body := struct {
+			Text string `json:"text"`
+		}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		msg = body.Text
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	tb, err := NewTelegram("good-token", "remark_test", 2*time.Second, ts.URL+"/")
+	require.NoError(t, err)
+	err = tb.Send(context.TODO(), Request{Alert: AlertMetadata{SiteID: "remark", Subject: "Backup failed", Text: "site remark, can't upload"}})
+	require.NoError(t, err)
+	assert.Equal(t, "*Backup failed*\n\n```\nsite remark, can't upload\n```", msg)
+}
+
 func mockTelegramServer() *httptest.Server {
 	router := chi.NewRouter()
 	router.Get("/good-token/getMe", func(w http.ResponseWriter, r *http.Request) {
@@ -1,9 +1,14 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -13,9 +18,13 @@ import (
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
 
+	"github.com/umputun/remark/backend/app/jobs"
 	"github.com/umputun/remark/backend/app/rest"
 	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/changelog"
 	"github.com/umputun/remark/backend/app/store/engine"
+	"github.com/umputun/remark/backend/app/store/image"
+	"github.com/umputun/remark/backend/app/store/service"
 )
 
 // admin provides router for all requests available for admin users only
@@ -25,8 +34,14 @@ type admin struct {
 	authenticator *auth.Service
 	readOnlyAge   int
 	migrator      *Migrator
+	changeLog     *changelog.Service
+	imageService  *image.Service
+	sites         []string // all sites, images store shared between them
 }
 
+const maxChangesWait = 25 * time.Second // long-poll limit, changes routes have no timeout middleware because of sse streams
+const changesPingInterval = 30 * time.Second
+
 type adminStore interface {
 	Delete(locator store.Locator, commentID string, mode store.DeleteMode) error
 	DeleteUser(siteID string, userID string, mode store.DeleteMode) error
@@ -37,9 +52,24 @@ type adminStore interface {
 	BlockedUsers(siteID string) ([]store.BlockedUser, error)
 	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
 	SetTitle(locator store.Locator, commentID string) (comment store.Comment, err error)
+	SetPostTitle(locator store.Locator) (store.Post, error)
+	Post(locator store.Locator) (store.Post, error)
+	UpdatePost(locator store.Locator, upd store.Post) (store.Post, error)
+	MoveComment(locator store.Locator, commentID string, to store.Locator) ([]store.Comment, error)
+	MergePosts(locator store.Locator, to store.Locator, alias bool) ([]store.Comment, error)
 	SetVerified(siteID string, userID string, status bool) error
 	SetReadOnly(locator store.Locator, status bool) error
 	SetPin(locator store.Locator, commentID string, status bool) error
+	ImageRefs(siteIDs ...string) (map[string]bool, error)
+	ImageComments(imageID string, siteIDs ...string) ([]service.ImageRef, error)
+	ScrubImage(imageID string, siteIDs ...string) ([]service.ImageRef, error)
+}
+
+// uploadInfo is an image uploaded by user with comments referencing it
+type uploadInfo struct {
+	image.Upload
+	URL      string             `json:"url"`
+	Comments []service.ImageRef `json:"comments"`
 }
 
 // DELETE /comment/{id}?site=siteID&url=post-url - removes comment
@@ -201,7 +231,7 @@ func (a *admin) setReadOnlyCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"locator": locator, "read-only": roStatus})
 }
 
-// PUT /title/{id}?site=siteID&url=post-url - set comment PostTitle to page's title
+// PUT /title/{id}?site=siteID&url=post-url - set title of comment's post and all its comments to page's title
 func (a *admin) setTitleCtrl(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
@@ -211,13 +241,112 @@ func (a *admin) setTitleCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't set title", rest.ErrInternal)
 		return
 	}
-	log.Printf("[INFO] set comment's title %s to %q", id, c.PostTitle)
+	log.Printf("[INFO] set title of comment's %s post to %q", id, c.PostTitle)
 
-	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, lastCommentsScope))
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, R.JSON{"id": id, "locator": locator})
 }
 
+// PUT /title?site=siteID&url=post-url - set title of the post and all its comments to page's title
+func (a *admin) setPostTitleCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+
+	post, err := a.dataService.SetPostTitle(locator)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't set title", rest.ErrInternal)
+		return
+	}
+	log.Printf("[INFO] set post's title %s to %q", post.Locator.URL, post.Title)
+
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+	render.JSON(w, r, post)
+}
+
+// GET /post?site=siteID&url=post-url - get post record by url or alias of the post
+func (a *admin) getPostCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+
+	post, err := a.dataService.Post(locator)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get post", rest.ErrPostNotFound)
+		return
+	}
+	render.JSON(w, r, post)
+}
+
+// PUT /post?site=siteID&url=post-url - set title, image and aliases of the post, body is post record
+func (a *admin) updatePostCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+
+	upd := store.Post{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &upd); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind post", rest.ErrDecode)
+		return
+	}
+
+	post, err := a.dataService.UpdatePost(locator, upd)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't update post", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] post %s updated, title %q, aliases %v", post.Locator.URL, post.Title, post.Aliases)
+
+	// cached responses for aliases scoped by site only
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+	render.JSON(w, r, post)
+}
+
+// PUT /move/{id}?site=siteID&url=post-url&to=new-post-url - move comment with all replies to another post
+func (a *admin) moveCommentCtrl(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	to := store.Locator{SiteID: locator.SiteID, URL: r.URL.Query().Get("to")}
+
+	moved, err := a.dataService.MoveComment(locator, id, to)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't move comment", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] comment %s moved from %s to %s with %d replies", id, locator.URL, to.URL, len(moved)-1)
+
+	a.flushMoved(locator, moved)
+	render.JSON(w, r, R.JSON{"id": id, "locator": locator, "to": moved[0].Locator, "moved": len(moved)})
+}
+
+// PUT /merge?site=siteID&url=post-url&to=new-post-url&alias=1 - move all comments of the post to another post,
+// with alias=1 url of the merged post becomes alias of the target post
+func (a *admin) mergePostsCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	to := store.Locator{SiteID: locator.SiteID, URL: r.URL.Query().Get("to")}
+	alias := r.URL.Query().Get("alias") == "1"
+
+	moved, err := a.dataService.MergePosts(locator, to, alias)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't merge posts", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] post %s merged to %s, %d comments moved", locator.URL, to.URL, len(moved))
+
+	a.flushMoved(locator, moved)
+	render.JSON(w, r, R.JSON{"locator": locator, "to": moved[0].Locator, "moved": len(moved), "alias": alias})
+}
+
+// flushMoved flushes cache of the source and target posts, last comments and authors of moved comments
+func (a *admin) flushMoved(locator store.Locator, moved []store.Comment) {
+	scopes := []string{locator.SiteID, locator.URL, lastCommentsScope}
+	seen := map[string]bool{}
+	for _, c := range moved {
+		for _, scope := range []string{c.Locator.URL, c.User.ID} {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(scopes...))
+}
+
 // PUT /verify?site=siteID&url=post-url&ro=1 - set or reset read-only status for the post
 func (a *admin) setVerifyCtrl(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "userid")
@@ -246,3 +375,212 @@ func (a *admin) setPinCtrl(w http.ResponseWriter, r *http.Request) {
 	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL))
 	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "pin": pinStatus})
 }
+
+// GET /images/gc?site=siteID - report of committed images not referenced by comments of any site
+// POST /images/gc?site=siteID - remove such images if older than grace period, responds with the same report
+func (a *admin) imagesGCCtrl(w http.ResponseWriter, r *http.Request) {
+	sites := a.sites
+	if len(sites) == 0 {
+		sites = []string{r.URL.Query().Get("site")}
+	}
+
+	refs, err := a.dataService.ImageRefs(sites...)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get referenced images", rest.ErrInternal)
+		return
+	}
+	if r.Method != http.MethodPost {
+		res, err := a.imageService.GC(r.Context(), refs, true)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusNotImplemented, err, "can't collect images", rest.ErrActionRejected)
+			return
+		}
+		render.JSON(w, r, res)
+		return
+	}
+
+	// removal runs as cleanup job of the site, can be canceled by admin
+	var res image.GCReport
+	_, err = a.migrator.jobs().Run(r.Context(), r.URL.Query().Get("site"), jobs.KindCleanup,
+		func(ctx context.Context, p *jobs.Progress) (e error) {
+			if res, e = a.imageService.GC(ctx, refs, false); e != nil {
+				return e
+			}
+			p.Logf("images gc by admin, %s", res)
+			return nil
+		})
+	if err == jobs.ErrBusy {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "can't collect images", rest.ErrActionRejected)
+		return
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusNotImplemented, err, "can't collect images", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, res)
+}
+
+// GET /images?site=siteID&limit=100 - list recent uploads to the site with comments referencing them
+func (a *admin) imagesCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.imageService.Uploads == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotImplemented, errors.New("uploads tracking disabled"),
+			"can't get uploads", rest.ErrActionRejected)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	uploads, err := a.imageService.Uploads.List(siteID, limit)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get uploads", rest.ErrInternal)
+		return
+	}
+	res := make([]uploadInfo, 0, len(uploads))
+	for _, u := range uploads {
+		refs, err := a.dataService.ImageComments(u.ID, siteID)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get referencing comments", rest.ErrInternal)
+			return
+		}
+		res = append(res, uploadInfo{Upload: u, URL: a.imageService.ImageAPI + u.ID, Comments: refs})
+	}
+	render.JSON(w, r, res)
+}
+
+// DELETE /images/{user}/{id}?site=siteID - removes image from comments of the site referencing it and deletes the image.
+// Rejected for images uploaded to another site.
+func (a *admin) deleteImageCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.imageService.Uploads == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotImplemented, errors.New("uploads tracking disabled"),
+			"can't delete image", rest.ErrActionRejected)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	imageID := chi.URLParam(r, "user") + "/" + chi.URLParam(r, "id")
+
+	upload, err := a.imageService.Uploads.Get(imageID)
+	if err != nil {
+		status, code := http.StatusInternalServerError, rest.ErrInternal
+		if err == image.ErrUploadNotFound {
+			status, code = http.StatusNotFound, rest.ErrAssetNotFound
+		}
+		rest.SendErrorJSON(w, r, status, err, "can't get upload", code)
+		return
+	}
+	if upload.SiteID != siteID {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("image uploaded to another site"),
+			"can't delete image", rest.ErrNoAccess)
+		return
+	}
+
+	scrubbed, err := a.dataService.ScrubImage(imageID, siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't remove image from comments", rest.ErrInternal)
+		return
+	}
+	if err = a.imageService.Delete(imageID); err != nil {
+		log.Printf("[WARN] can't delete image %s, %v", imageID, err)
+	}
+	for _, ref := range scrubbed {
+		a.cache.Flush(cache.Flusher(ref.Locator.SiteID).Scopes(ref.Locator.SiteID, ref.Locator.URL, lastCommentsScope))
+	}
+	log.Printf("[INFO] image %s deleted by admin, removed from %d comments", imageID, len(scrubbed))
+	render.JSON(w, r, R.JSON{"id": imageID, "comments": scrubbed, "deleted": err == nil})
+}
+
+// GET /changes?site=siteID&after=seq&limit=100&wait=10s - list changes made after given sequence number.
+// With wait param request blocks till new changes appear (long-poll). Responds with server-sent events stream
+// if requested with "Accept: text/event-stream", in this case Last-Event-ID header used on reconnect.
+func (a *admin) changesCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.changeLog == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotImplemented, errors.New("change log disabled"),
+			"can't get changes", rest.ErrActionRejected)
+		return
+	}
+
+	siteID := r.URL.Query().Get("site")
+	after := uint64(0)
+	if v := r.URL.Query().Get("after"); v != "" {
+		seq, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse after param", rest.ErrDecode)
+			return
+		}
+		after = seq
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			if seq, err := strconv.ParseUint(v, 10, 64); err == nil {
+				after = seq
+			}
+		}
+		if err := a.streamChanges(r.Context(), w, siteID, after, limit); err != nil {
+			log.Printf("[WARN] changes stream for %s terminated, %v", siteID, err)
+		}
+		return
+	}
+
+	var wait time.Duration
+	if v := r.URL.Query().Get("wait"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			wait = d
+		}
+	}
+	if wait > maxChangesWait {
+		wait = maxChangesWait
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+	changes, err := a.changeLog.Wait(ctx, siteID, after, limit)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get changes", rest.ErrInternal)
+		return
+	}
+
+	last := after // last seq allows consumer to continue from the same position if nothing changed
+	if len(changes) > 0 {
+		last = changes[len(changes)-1].Seq
+	}
+	render.JSON(w, r, R.JSON{"site": siteID, "changes": changes, "last": last})
+}
+
+// streamChanges sends server-sent events with change records as they appear, until context canceled
+func (a *admin) streamChanges(ctx context.Context, w http.ResponseWriter, siteID string, after uint64, limit int) error {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, changesPingInterval)
+		changes, err := a.changeLog.Wait(pingCtx, siteID, after, limit)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil { // request closed by remote client
+			return nil
+		}
+
+		if len(changes) == 0 { // comment line to keep connection alive
+			if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return err
+			}
+		}
+		for _, c := range changes {
+			data, e := json.Marshal(c)
+			if e != nil {
+				return e
+			}
+			if _, e = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", c.Seq, c.Kind, data); e != nil {
+				return e
+			}
+			after = c.Seq
+		}
+		if fw, ok := w.(http.Flusher); ok {
+			fw.Flush()
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
@@ -22,6 +23,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/changelog"
+	"github.com/umputun/remark/backend/app/store/image"
 	"github.com/umputun/remark/backend/app/store/service"
 )
 
@@ -151,6 +154,136 @@ func TestAdmin_Title(t *testing.T) {
 	assert.Equal(t, "post1 blah 123", cr.PostTitle)
 }
 
+func TestAdmin_Post(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	srv.DataService.TitleExtractor = service.NewTitleExtractor(http.Client{Timeout: time.Second})
+	tss := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(`<html><title>post1 blah 123</title><meta property="og:image" content="/1.png"></html>`))
+		assert.NoError(t, err)
+	}))
+	defer tss.Close()
+
+	c1 := store.Comment{Text: "test test #1", User: store.User{ID: "id", Name: "name"},
+		Locator: store.Locator{SiteID: "remark42", URL: tss.URL + "/post1"}}
+	id1 := addComment(t, c1, ts)
+
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/v1/admin/post?site=remark42&url=%s/post1", ts.URL, tss.URL),
+		strings.NewReader(`{"title":"some title","aliases":["`+tss.URL+`/post1/"]}`))
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/v1/admin/post?site=remark42&url=%s/post1/", ts.URL, tss.URL), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	body, code := getWithAdminAuth(t, fmt.Sprintf("%s/api/v1/admin/post?site=remark42&url=%s/post1/", ts.URL, tss.URL))
+	require.Equal(t, http.StatusOK, code)
+	post := store.Post{}
+	require.NoError(t, json.Unmarshal([]byte(body), &post))
+	assert.Equal(t, store.Locator{SiteID: "remark42", URL: tss.URL + "/post1"}, post.Locator, "post by alias")
+	assert.Equal(t, "some title", post.Title)
+	assert.Equal(t, []string{tss.URL + "/post1/"}, post.Aliases)
+
+	body, code = get(t, fmt.Sprintf("%s/api/v1/find?site=remark42&url=%s/post1/", ts.URL, tss.URL))
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, id1, "comments by alias")
+	assert.Contains(t, body, `"title":"some title"`)
+
+	req, err = http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/v1/admin/title?site=remark42&url=%s/post1/", ts.URL, tss.URL), nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	post = store.Post{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&post))
+	assert.Equal(t, "post1 blah 123", post.Title)
+	assert.Equal(t, tss.URL+"/1.png", post.Image)
+
+	body, code = get(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=%s/post1", ts.URL, id1, tss.URL))
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"title":"post1 blah 123"`)
+
+	_, code = getWithAdminAuth(t, fmt.Sprintf("%s/api/v1/admin/post?site=remark42&url=%s/unknown", ts.URL, tss.URL))
+	assert.Equal(t, http.StatusNotFound, code)
+
+	req, err = http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/v1/admin/post?site=remark42&url=%s/post1", ts.URL, tss.URL),
+		strings.NewReader(`{"title":`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAdmin_MoveAndMerge(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1", User: store.User{ID: "id", Name: "name"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	id1 := addComment(t, c1, ts)
+	c2 := store.Comment{Text: "test test #2", ParentID: id1, User: store.User{ID: "id", Name: "name"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	addComment(t, c2, ts)
+	c3 := store.Comment{Text: "test test #3", User: store.User{ID: "id", Name: "name"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	addComment(t, c3, ts)
+
+	// cache filled
+	body, code := get(t, ts.URL+"/api/v1/count?site=remark42&url=https://radio-t.com/blah2")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"count":0,"locator":{"site":"remark42","url":"https://radio-t.com/blah2"}}`+"\n", body)
+
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/move/%s?site=remark42&url=https://radio-t.com/blah1&to=https://radio-t.com/blah2", ts.URL, id1), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := R.JSON{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	assert.Equal(t, 2.0, res["moved"], "comment moved with reply")
+
+	body, code = get(t, ts.URL+"/api/v1/count?site=remark42&url=https://radio-t.com/blah2")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"count":2,"locator":{"site":"remark42","url":"https://radio-t.com/blah2"}}`+"\n", body)
+	body, code = get(t, ts.URL+"/api/v1/count?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"count":1,"locator":{"site":"remark42","url":"https://radio-t.com/blah1"}}`+"\n", body)
+
+	req, err = http.NewRequest(http.MethodPut,
+		ts.URL+"/api/v1/admin/move/bad-id?site=remark42&url=https://radio-t.com/blah1&to=https://radio-t.com/blah2", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// merge the rest
+	req, err = http.NewRequest(http.MethodPut,
+		ts.URL+"/api/v1/admin/merge?site=remark42&url=https://radio-t.com/blah1&to=https://radio-t.com/blah2&alias=1", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	assert.Equal(t, 3, len(comments.Comments), "all comments by alias of merged post")
+
+	req, err = http.NewRequest(http.MethodPut,
+		ts.URL+"/api/v1/admin/merge?site=remark42&url=https://radio-t.com/blah1&to=https://radio-t.com/blah2", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "can't merge post to itself")
+}
+
 func TestAdmin_DeleteUser(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -825,3 +958,263 @@ func TestAdmin_GetUserInfo(t *testing.T) {
 	_, code = getWithAdminAuth(t, fmt.Sprintf("%s/api/v1/admin/user/userX?site=remark42&url=https://radio-t.com/blah", ts.URL))
 	assert.Equal(t, 400, code, "no info about user")
 }
+
+func TestAdmin_Changes(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1", User: store.User{ID: "id", Name: "name"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	id1 := addComment(t, c1, ts)
+	addComment(t, c1, ts)
+
+	req, err := http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/api/v1/admin/comment/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1), nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/changes?site=remark42")
+	require.Equal(t, 200, code, res)
+	changes := struct {
+		Site    string
+		Changes []changelog.Record
+		Last    uint64
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(res), &changes))
+	assert.Equal(t, "remark42", changes.Site)
+	require.Equal(t, 3, len(changes.Changes))
+	assert.Equal(t, changelog.KindCreate, changes.Changes[0].Kind)
+	assert.Equal(t, id1, changes.Changes[0].CommentID)
+	assert.Equal(t, "<p>test test #1</p>\n", changes.Changes[0].Comment.Text)
+	assert.Equal(t, changelog.KindDelete, changes.Changes[2].Kind)
+	assert.Equal(t, uint64(3), changes.Last)
+
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/changes?site=remark42&after=1&limit=1")
+	require.Equal(t, 200, code, res)
+	require.NoError(t, json.Unmarshal([]byte(res), &changes))
+	require.Equal(t, 1, len(changes.Changes))
+	assert.Equal(t, uint64(2), changes.Changes[0].Seq)
+	assert.Equal(t, uint64(2), changes.Last)
+
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/changes?site=remark42&after=3")
+	require.Equal(t, 200, code, res)
+	require.NoError(t, json.Unmarshal([]byte(res), &changes))
+	assert.Equal(t, 0, len(changes.Changes))
+	assert.Equal(t, uint64(3), changes.Last, "last is the same as after if no changes")
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/changes?site=remark42&after=bad")
+	assert.Equal(t, 400, code)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/changes?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+}
+
+func TestAdmin_ChangesWait(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		addComment(t, store.Comment{Text: "test test #1", User: store.User{ID: "id", Name: "name"},
+			Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}, ts)
+	}()
+
+	st := time.Now()
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/changes?site=remark42&wait=3s")
+	require.Equal(t, 200, code, res)
+	assert.True(t, time.Since(st) >= 200*time.Millisecond, "waited for change")
+	assert.True(t, time.Since(st) < 3*time.Second, "returned before wait expired")
+	changes := struct{ Changes []changelog.Record }{}
+	require.NoError(t, json.Unmarshal([]byte(res), &changes))
+	require.Equal(t, 1, len(changes.Changes))
+	assert.Equal(t, changelog.KindCreate, changes.Changes[0].Kind)
+
+	st = time.Now()
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/changes?site=remark42&after=1&wait=100ms")
+	require.Equal(t, 200, code, res)
+	assert.True(t, time.Since(st) >= 100*time.Millisecond)
+	require.NoError(t, json.Unmarshal([]byte(res), &changes))
+	assert.Equal(t, 0, len(changes.Changes))
+}
+
+func TestAdmin_ChangesStream(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1", User: store.User{ID: "id", Name: "name"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	addComment(t, c1, ts)
+	addComment(t, c1, ts)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		addComment(t, c1, ts)
+	}()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/admin/changes?site=remark42", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	rd := bufio.NewReader(resp.Body)
+	ids := []string{}
+	for len(ids) < 2 {
+		line, err := rd.ReadString('\n')
+		require.NoError(t, err)
+		if strings.HasPrefix(line, "id: ") {
+			ids = append(ids, strings.TrimSpace(strings.TrimPrefix(line, "id: ")))
+			line, err = rd.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, "event: create\n", line)
+		}
+	}
+	assert.Equal(t, []string{"2", "3"}, ids, "started after Last-Event-ID and got new change")
+}
+
+func TestAdmin_ChangesDisabled(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.adminRest.changeLog = nil
+
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/changes?site=remark42")
+	assert.Equal(t, 501, code)
+}
+
+func TestAdmin_ImagesGC(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.ImageService.ImageAPI = "https://demo.remark42.com/api/v1/picture/"
+	srv.DataService.ImageService = srv.ImageService
+
+	imgStore := image.FileSystem{Location: os.TempDir() + "/pics-remark42", Partitions: 100,
+		Staging: os.TempDir() + "/pics-remark42/staging"}
+	for _, id := range []string{"user1/pic1.png", "user1/pic2.png"} {
+		_, err := imgStore.SaveWithID(id, []byte("image data"))
+		require.NoError(t, err)
+		require.NoError(t, imgStore.Commit(id))
+	}
+	c := store.Comment{Text: `<img src="https://demo.remark42.com/api/v1/picture/user1/pic1.png">`,
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}, User: store.User{Name: "user1", ID: "user1"}}
+	_, err := srv.DataService.Create(c)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/images/gc?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/images/gc?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	res := image.GCReport{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.Equal(t, image.GCReport{Total: 2, TotalSize: 20, Orphaned: 1, Reclaimable: 1, ReclaimableSize: 10,
+		IDs: []string{"user1/pic2.png"}}, res)
+	_, err = imgStore.Load("user1/pic2.png")
+	assert.NoError(t, err, "report only")
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/images/gc?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res = image.GCReport{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	assert.Equal(t, 1, res.Removed)
+	_, err = imgStore.Load("user1/pic2.png")
+	assert.Error(t, err)
+	_, err = imgStore.Load("user1/pic1.png")
+	assert.NoError(t, err)
+
+	srv.DataService.ImageService = nil
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/images/gc?site=remark42")
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestAdmin_Images(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.ImageService.ImageAPI = "https://demo.remark42.com/api/v1/picture/"
+	srv.DataService.ImageService = srv.ImageService
+
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/images?site=remark42")
+	assert.Equal(t, http.StatusNotImplemented, code, "uploads not tracked")
+
+	srv.ImageService.Uploads = image.NewMemoryUploads()
+	id, err := srv.ImageService.Upload("remark42", "dev", gopherPNG())
+	require.NoError(t, err)
+	imgStore := image.FileSystem{Location: os.TempDir() + "/pics-remark42", Partitions: 100,
+		Staging: os.TempDir() + "/pics-remark42/staging"}
+	require.NoError(t, imgStore.Commit(id))
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	c := store.Comment{Text: `<p>pic <img src="https://demo.remark42.com/api/v1/picture/` + id + `"></p>`,
+		Locator: locator, User: store.User{Name: "dev", ID: "dev"}}
+	commentID, err := srv.DataService.Create(c)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/images?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/images?site=remark42&limit=10")
+	require.Equal(t, http.StatusOK, code, body)
+	res := []uploadInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, id, res[0].ID)
+	assert.Equal(t, "dev", res[0].UserID)
+	assert.Equal(t, "https://demo.remark42.com/api/v1/picture/"+id, res[0].URL)
+	require.Equal(t, 1, len(res[0].Comments))
+	assert.Equal(t, commentID, res[0].Comments[0].CommentID)
+
+	// image uploaded to another site can't be deleted
+	otherID, err := srv.ImageService.Upload("other-site", "dev", gopherPNG())
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/images/"+otherID+"?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	_, err = imgStore.Load(otherID)
+	assert.NoError(t, err, "image of another site kept")
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/images/dev/no-such-image.png?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/images/"+id+"?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	delRes := struct {
+		ID       string             `json:"id"`
+		Comments []service.ImageRef `json:"comments"`
+		Deleted  bool               `json:"deleted"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&delRes))
+	assert.Equal(t, id, delRes.ID)
+	assert.Equal(t, 1, len(delRes.Comments))
+	assert.True(t, delRes.Deleted)
+
+	cc, err := srv.DataService.Get(locator, commentID, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>pic </p>", cc.Text)
+	_, err = imgStore.Load(id)
+	assert.Error(t, err)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/images?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "[]\n", body)
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
 	"fmt"
@@ -8,47 +10,70 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 	cache "github.com/go-pkgz/lcw"
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
 	"github.com/pkg/errors"
 
+	"github.com/umputun/remark/backend/app/jobs"
 	"github.com/umputun/remark/backend/app/migrator"
 	"github.com/umputun/remark/backend/app/rest"
+	"github.com/umputun/remark/backend/app/store/service"
 )
 
-// Migrator rest with import and export controllers
+// Migrator rest with import and export controllers. Import, export and remap run as jobs,
+// with status, progress and cancellation available via jobs controllers
 type Migrator struct {
 	Cache             LoadingCache
 	NativeImporter    migrator.Importer
 	DisqusImporter    migrator.Importer
 	WordPressImporter migrator.Importer
+	CommentoImporter  migrator.Importer
+	IssoImporter      migrator.Importer
+	ArchiveImporter   migrator.Importer
 	NativeExporter    migrator.Exporter
+	DisqusExporter    migrator.Exporter
+	WordPressExporter migrator.Exporter
+	ArchiveExporter   migrator.Exporter
 	UrlMapperMaker    migrator.MapperMaker
+	URLNormalizer     *service.URLNormalizer
+	DataStore         migrator.Store
+	AvatarProxy       migrator.AvatarProxy // stores avatars of disqus users imported with user mapping
 	KeyStore          KeyStore
+	Crypter           *migrator.Crypter // encrypts pre-remap backups if enabled, decrypts imported backups
+	BackupLocation    string            // pre-remap backups location, backups not kept if empty
+	Jobs              *jobs.Service     // runs migration jobs, in-memory service made on first use if not set
 
-	busy map[string]bool
 	lock sync.Mutex
 }
 
+const remapBackupPrefix = "pre-remap"
+const remapBackupsKeep = 5 // max pre-remap backups kept for each site
+const remapBackupTimeFormat = "20060102-150405.000000000"
+
 // KeyStore defines sub-interface for consumers needed just a key
 type KeyStore interface {
 	Key() (key string, err error)
 }
 
-// POST /import?secret=key&site=site-id&provider=disqus|remark|wordpress
-// imports comments from post body.
+// POST /import?secret=key&site=site-id&provider=disqus|remark|wordpress|commento|isso|archive&base=site-url
+// imports comments from post body. base is the site url for isso, keeping page paths only.
+// Native or archive backup restored selectively with url, user, from and to params, see selectiveImporter.
 func (m *Migrator) importCtrl(w http.ResponseWriter, r *http.Request) {
 
 	siteID := r.URL.Query().Get("site")
 
-	if m.isBusy(siteID) {
-		rest.SendErrorJSON(w, r, http.StatusConflict, errors.New("already running"),
-			"import rejected", rest.ErrActionRejected)
+	if m.jobs().Busy(siteID) {
+		rest.SendErrorJSON(w, r, http.StatusConflict, jobs.ErrBusy, "import rejected", rest.ErrActionRejected)
 		return
 	}
 
@@ -58,20 +83,16 @@ func (m *Migrator) importCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go m.runImport(siteID, r.URL.Query().Get("provider"), tmpfile) // import runs in background and sets busy flag for site
-
-	render.Status(r, http.StatusAccepted)
-	render.JSON(w, r, R.JSON{"status": "import request accepted"})
+	m.startImport(w, r, siteID, nil, tmpfile)
 }
 
-// POST /import/form?secret=key&site=site-id&provider=disqus|remark|wordpress
-// imports comments from form body.
+// POST /import/form?secret=key&site=site-id&provider=disqus|remark|wordpress|commento|isso|archive&base=site-url
+// imports comments from form body. Optional form file "mapping" attaches imported disqus users to remark42 users.
 func (m *Migrator) importFormCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
 
-	if m.isBusy(siteID) {
-		rest.SendErrorJSON(w, r, http.StatusConflict, errors.New("already running"),
-			"import rejected", rest.ErrActionRejected)
+	if m.jobs().Busy(siteID) {
+		rest.SendErrorJSON(w, r, http.StatusConflict, jobs.ErrBusy, "import rejected", rest.ErrActionRejected)
 		return
 	}
 
@@ -87,20 +108,54 @@ func (m *Migrator) importFormCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 	defer func() { _ = file.Close() }()
 
+	var userMapping map[string]string
+	if mappingFile, _, e := r.FormFile("mapping"); e == nil {
+		userMapping, err = migrator.LoadUserMapping(mappingFile)
+		_ = mappingFile.Close()
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't load user mapping", rest.ErrDecode)
+			return
+		}
+	}
+
 	tmpfile, err := m.saveTemp(file)
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't save request to temp file", rest.ErrInternal)
 		return
 	}
 
-	go m.runImport(siteID, r.URL.Query().Get("provider"), tmpfile) // import runs in background and sets busy flag for site
+	m.startImport(w, r, siteID, userMapping, tmpfile)
+}
+
+// startImport starts import job for the saved request and responds with job id. Temp file removed by the job
+func (m *Migrator) startImport(w http.ResponseWriter, r *http.Request, siteID string, userMapping map[string]string, tmpfile string) {
+	removeTemp := func() {
+		if e := os.Remove(tmpfile); e != nil {
+			log.Printf("[WARN] failed to remove tmp file %s, %v", tmpfile, e)
+		}
+	}
+	provider, baseURL := r.URL.Query().Get("provider"), r.URL.Query().Get("base")
+	selective, err := m.selectiveImporter(r, siteID)
+	if err != nil {
+		removeTemp()
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid selective restore request", rest.ErrDecode)
+		return
+	}
+	job, err := m.jobs().Start(context.Background(), siteID, jobs.KindImport, func(ctx context.Context, p *jobs.Progress) error {
+		return m.runImport(p, siteID, provider, baseURL, userMapping, selective, tmpfile)
+	})
+	if err != nil {
+		removeTemp()
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "import rejected", rest.ErrActionRejected)
+		return
+	}
 
 	render.Status(r, http.StatusAccepted)
-	render.JSON(w, r, R.JSON{"status": "import request accepted"})
+	render.JSON(w, r, R.JSON{"status": "import request accepted", "job": job.ID})
 }
 
-// GET /wait?site=site-id
-// waits for migration operation (import or remap)
+// GET /wait?site=site-id&job=job-id&timeout=15m
+// waits for migration job (import or remap) given by id, or for all migration jobs of the site if job not set
 func (m *Migrator) waitCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
 	timeOut := time.Minute * 15
@@ -112,8 +167,22 @@ func (m *Migrator) waitCtrl(w http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
 	defer cancel()
+
+	if id := r.URL.Query().Get("job"); id != "" {
+		job, err := m.jobs().Wait(ctx, siteID, id)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get job", rest.ErrActionRejected)
+			return
+		}
+		if job.Running() {
+			render.Status(r, http.StatusGatewayTimeout)
+		}
+		render.JSON(w, r, job)
+		return
+	}
+
 	for {
-		if !m.isBusy(siteID) {
+		if !m.jobs().Busy(siteID) {
 			break
 		}
 		select {
@@ -128,15 +197,33 @@ func (m *Migrator) waitCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"status": "completed", "site_id": siteID})
 }
 
-// GET /export?site=site-id&secret=12345&?mode=file|stream
-// exports all comments for siteID as gz file
+// GET /export?site=site-id&secret=12345&?mode=file|stream|disqus|wordpress|archive
+// exports all comments for siteID as gz file. Modes disqus and wordpress make gz file with xml for those systems,
+// mode archive makes tar.gz with comments, images and avatars
 func (m *Migrator) exportCtrl(w http.ResponseWriter, r *http.Request) {
 
 	siteID := r.URL.Query().Get("site")
+	mode := r.URL.Query().Get("mode")
+
+	exporter, ext := m.NativeExporter, "json"
+	switch mode {
+	case "disqus":
+		exporter, ext = m.DisqusExporter, "disqus.xml"
+	case "wordpress":
+		exporter, ext = m.WordPressExporter, "wordpress.xml"
+	case "archive":
+		exporter = m.ArchiveExporter
+	}
 
 	var writer io.Writer = w
-	if r.URL.Query().Get("mode") == "file" {
-		exportFile := fmt.Sprintf("%s-%s.json.gz", siteID, time.Now().Format("20060102"))
+	if mode == "archive" { // archive is tar.gz already
+		exportFile := fmt.Sprintf("%s-%s.tar.gz", siteID, time.Now().Format("20060102"))
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", "attachment;filename="+exportFile)
+		w.WriteHeader(http.StatusOK)
+	}
+	if mode == "file" || mode == "disqus" || mode == "wordpress" {
+		exportFile := fmt.Sprintf("%s-%s.%s.gz", siteID, time.Now().Format("20060102"), ext)
 		w.Header().Set("Content-Type", "application/gzip")
 		w.Header().Set("Content-Disposition", "attachment;filename="+exportFile)
 		w.WriteHeader(http.StatusOK)
@@ -149,14 +236,58 @@ func (m *Migrator) exportCtrl(w http.ResponseWriter, r *http.Request) {
 		writer = gzWriter
 	}
 
-	if _, err := m.NativeExporter.Export(writer, siteID); err != nil {
+	_, err := m.jobs().Run(r.Context(), siteID, jobs.KindExport, func(ctx context.Context, p *jobs.Progress) error {
+		size, e := exporter.Export(p.Writer(writer), siteID)
+		if e != nil {
+			return e
+		}
+		p.Logf("exported %d comments, mode=%s", size, mode)
+		return nil
+	})
+	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "export failed", rest.ErrInternal)
 		return
 	}
 }
 
-// POST /remap?site=site-id
-// remap urls in comments based on given rules (oldUrl newUrl)
+// GET /jobs?site=site-id&limit=20 - list jobs of the site, the latest first
+func (m *Migrator) jobsCtrl(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	res, err := m.jobs().List(r.URL.Query().Get("site"), limit)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get jobs", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, res)
+}
+
+// GET /jobs/{id}?site=site-id - get job with its status, progress and logs
+func (m *Migrator) jobCtrl(w http.ResponseWriter, r *http.Request) {
+	job, err := m.jobs().Get(r.URL.Query().Get("site"), chi.URLParam(r, "id"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get job", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, job)
+}
+
+// DELETE /jobs/{id}?site=site-id - cancel running job
+func (m *Migrator) cancelJobCtrl(w http.ResponseWriter, r *http.Request) {
+	job, err := m.jobs().Cancel(r.URL.Query().Get("site"), chi.URLParam(r, "id"))
+	if err == jobs.ErrNotFound {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't cancel job", rest.ErrActionRejected)
+		return
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "can't cancel job", rest.ErrActionRejected)
+		return
+	}
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, R.JSON{"status": "cancel request accepted", "job": job.ID})
+}
+
+// POST /remap?site=site-id&dry=1
+// remap urls in comments based on given rules (oldUrl newUrl). With dry=1 responds with changes remap would make
 func (m *Migrator) remapCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
 
@@ -168,84 +299,414 @@ func (m *Migrator) remapCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	// start remap procedure with mapper
-	go func() {
-		m.setBusy(siteID, true)
-		defer m.setBusy(siteID, false)
+	if r.URL.Query().Get("dry") == "1" {
+		m.sendRemapPlan(w, r, siteID, mapper)
+		return
+	}
+	job, err := m.jobs().Start(context.Background(), siteID, jobs.KindRemap, func(ctx context.Context, p *jobs.Progress) error {
+		return m.runRemap(p, siteID, mapper)
+	})
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "remap rejected", rest.ErrActionRejected)
+		return
+	}
 
-		// do export
-		fh, e := ioutil.TempFile("", "remark42_convert")
-		if e != nil {
-			log.Printf("[WARN] failed to make temp file %+v", e)
-			return
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, R.JSON{"status": "convert request accepted", "job": job.ID})
+}
+
+// POST /normalize?site=site-id&dry=1
+// remap urls in comments to normalized ones, with site's url normalization rules. With dry=1 responds with
+// changes normalization would make
+func (m *Migrator) normalizeCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+
+	if m.URLNormalizer == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("no url normalization rules"),
+			"normalize rejected", rest.ErrActionRejected)
+		return
+	}
+
+	mapper := migrator.MapperFunc(func(url string) string { return m.URLNormalizer.URL(siteID, url) })
+	if r.URL.Query().Get("dry") == "1" {
+		m.sendRemapPlan(w, r, siteID, mapper)
+		return
+	}
+	job, err := m.jobs().Start(context.Background(), siteID, jobs.KindRemap, func(ctx context.Context, p *jobs.Progress) error {
+		return m.runRemap(p, siteID, mapper)
+	})
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "normalize rejected", rest.ErrActionRejected)
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, R.JSON{"status": "normalize request accepted", "job": job.ID})
+}
+
+// POST /remap/rollback?site=site-id
+// restores comments from the last pre-remap backup of the site
+func (m *Migrator) remapRollbackCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+
+	backupFile, err := m.lastRemapBackup(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "no pre-remap backup", rest.ErrActionRejected)
+		return
+	}
+	job, err := m.jobs().Start(context.Background(), siteID, jobs.KindImport, func(ctx context.Context, p *jobs.Progress) error {
+		return m.runRollback(p, siteID, backupFile)
+	})
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "rollback rejected", rest.ErrActionRejected)
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, R.JSON{"status": "rollback request accepted", "file": filepath.Base(backupFile), "job": job.ID})
+}
+
+// sendRemapPlan responds with posts and comments remap with mapper would move, and with collisions
+func (m *Migrator) sendRemapPlan(w http.ResponseWriter, r *http.Request, siteID string, mapper migrator.Mapper) {
+	posts, err := m.DataStore.List(siteID, 0, 0)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get posts", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, migrator.PlanRemap(posts, mapper))
+}
+
+// runRemap exports all comments of the site and imports them back with urls changed by mapper.
+// Export kept as pre-remap backup for rollback if backup location set. Runs as remap job,
+// progress counted in bytes of the export read by import
+func (m *Migrator) runRemap(p *jobs.Progress, siteID string, mapper migrator.Mapper) error {
+	// do export
+	sp, backupFile, e := m.makeRemapBackup(siteID)
+	if e != nil {
+		return errors.Wrap(e, "failed to make pre-remap backup")
+	}
+	defer sp.Remove()
+	if backupFile != "" {
+		p.Logf("pre-remap backup %s", filepath.Base(backupFile))
+	}
+
+	p.SetTotal(sp.Size())
+	reader, e := sp.Reader()
+	if e != nil {
+		return errors.Wrap(e, "failed to read pre-remap backup")
+	}
+	gzReader, e := gzip.NewReader(p.Reader(reader))
+	if e != nil {
+		return errors.Wrap(e, "failed to read pre-remap backup")
+	}
+
+	log.Printf("[DEBUG] start import for site=%s", siteID)
+	mappedReader := migrator.WithMapper(gzReader, mapper)
+	count, e := m.NativeImporter.Import(mappedReader, siteID)
+	if e != nil {
+		return errors.Wrap(e, "import failed")
+	}
+
+	m.Cache.Flush(cache.Flusher(siteID).Scopes(siteID))
+	p.Logf("convert request completed. site=%s, comments=%d", siteID, count)
+	return nil
+}
+
+// makeRemapBackup exports all comments of the site, gzipped, to encrypted temporary spool read by import.
+// If backup location set the export kept there too, encrypted if encryption enabled. Returns the spool
+// and name of kept backup, empty if not kept. Old pre-remap backups removed, up to remapBackupsKeep kept
+func (m *Migrator) makeRemapBackup(siteID string) (sp *migrator.Spool, backupFile string, err error) {
+	log.Printf("[DEBUG] start export for site=%s", siteID)
+	sp, err = migrator.NewSpool("remark42_convert", func(w io.Writer) error {
+		gzWriter := gzip.NewWriter(w)
+		if _, e := m.NativeExporter.Export(gzWriter, siteID); e != nil {
+			return errors.Wrapf(e, "export failed for %s", siteID)
 		}
-		defer func() {
-			if e := os.Remove(fh.Name()); e != nil {
-				log.Printf("[WARN] failed to remove temp file %+v", e)
-			}
-		}()
-		log.Printf("[DEBUG] start export for site=%s", siteID)
-		if _, e := m.NativeExporter.Export(fh, siteID); e != nil {
-			log.Printf("[WARN] export failed with %+v", e)
-			return
+		return errors.Wrap(gzWriter.Close(), "can't close gz")
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if m.BackupLocation == "" {
+		return sp, "", nil
+	}
+
+	backupFile = filepath.Join(m.BackupLocation,
+		fmt.Sprintf("%s-%s-%s.gz", remapBackupPrefix, siteID, time.Now().Format(remapBackupTimeFormat)))
+	if m.Crypter.Enabled() {
+		backupFile += migrator.EncryptedExt
+	}
+	if err = m.saveRemapBackup(sp, backupFile); err != nil {
+		sp.Remove()
+		return nil, "", err
+	}
+	m.removeOldRemapBackups(siteID)
+	return sp, backupFile, nil
+}
+
+// saveRemapBackup writes export from the spool to new backup file, encrypted if encryption enabled
+func (m *Migrator) saveRemapBackup(sp *migrator.Spool, fname string) (err error) {
+	src, err := sp.Reader()
+	if err != nil {
+		return errors.Wrap(err, "can't read export")
+	}
+	fh, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600) // nolint
+	if err != nil {
+		return errors.Wrap(err, "failed to make backup file")
+	}
+	defer func() {
+		if e := fh.Close(); e != nil && err == nil {
+			err = errors.Wrapf(e, "can't close %s", fname)
+		}
+		if err != nil {
+			_ = os.Remove(fname)
 		}
+	}()
 
-		if _, e = fh.Seek(0, 0); e != nil {
-			log.Printf("[WARN] failed to seek file %+v", e)
-			return
+	if !m.Crypter.Enabled() {
+		_, err = io.Copy(fh, src)
+		return errors.Wrapf(err, "can't write %s", fname)
+	}
+	encWriter, err := m.Crypter.Encrypt(fh)
+	if err != nil {
+		return errors.Wrapf(err, "can't encrypt %s", fname)
+	}
+	if _, err = io.Copy(encWriter, src); err != nil {
+		return errors.Wrapf(err, "can't write %s", fname)
+	}
+	return errors.Wrapf(encWriter.Close(), "can't complete encryption of %s", fname)
+}
+
+// runRollback imports comments from the pre-remap backup file. Runs as import job
+func (m *Migrator) runRollback(p *jobs.Progress, siteID string, backupFile string) error {
+	p.Logf("rollback site=%s from %s", siteID, filepath.Base(backupFile))
+	fh, err := os.Open(backupFile) // nolint
+	if err != nil {
+		return errors.Wrap(err, "rollback failed")
+	}
+	defer func() { _ = fh.Close() }()
+	if fi, e := fh.Stat(); e == nil {
+		p.SetTotal(fi.Size())
+	}
+
+	var reader io.Reader = p.Reader(fh)
+	if migrator.IsEncryptedFile(backupFile) {
+		if reader, err = m.Crypter.Decrypt(reader); err != nil {
+			return errors.Wrapf(err, "rollback failed, can't decrypt %s", backupFile)
 		}
+	}
+	gzReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return errors.Wrapf(err, "rollback failed, can't read %s", backupFile)
+	}
+	size, err := m.NativeImporter.Import(gzReader, siteID)
+	if err != nil {
+		return errors.Wrap(err, "rollback failed")
+	}
+	m.Cache.Flush(cache.Flusher(siteID).Scopes(siteID))
+	p.Logf("rollback completed. site=%s, comments=%d", siteID, size)
+	return nil
+}
 
-		log.Printf("[DEBUG] start import for site=%s", siteID)
-		mappedReader := migrator.WithMapper(fh, mapper)
-		size, e := m.NativeImporter.Import(mappedReader, siteID)
-		if e != nil {
-			log.Printf("[WARN] import failed with %+v", e)
-			return
+// remapBackups returns pre-remap backups of the site, the oldest first. Only names made by makeRemapBackup
+// for this site matched, i.e. backups of site "a" don't include backups of site "a-b"
+func (m *Migrator) remapBackups(siteID string) ([]string, error) {
+	if m.BackupLocation == "" {
+		return nil, errors.New("backup location not set")
+	}
+	entries, err := ioutil.ReadDir(m.BackupLocation)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list pre-remap backups")
+	}
+	re := regexp.MustCompile("^" + regexp.QuoteMeta(remapBackupPrefix+"-"+siteID+"-") +
+		`(\d{8}-\d{6}(?:\.\d{9})?)\.gz(?:` + regexp.QuoteMeta(migrator.EncryptedExt) + ")?$")
+	type backup struct {
+		file string
+		ts   time.Time
+	}
+	backups := []backup{}
+	for _, e := range entries {
+		match := re.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		ts, err := time.Parse("20060102-150405", match[1]) // fraction of seconds parsed if present
+		if err != nil {
+			continue
 		}
+		backups = append(backups, backup{file: filepath.Join(m.BackupLocation, e.Name()), ts: ts})
+	}
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].ts.Before(backups[j].ts) })
+	files := make([]string, 0, len(backups))
+	for _, b := range backups {
+		files = append(files, b.file)
+	}
+	return files, nil
+}
 
-		m.Cache.Flush(cache.Flusher(siteID).Scopes(siteID))
-		log.Printf("[DEBUG] convert request completed. site=%s, comments=%d", siteID, size)
-	}()
+// lastRemapBackup returns the latest pre-remap backup of the site
+func (m *Migrator) lastRemapBackup(siteID string) (string, error) {
+	files, err := m.remapBackups(siteID)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", errors.Errorf("no pre-remap backups for %s", siteID)
+	}
+	return files[len(files)-1], nil
+}
 
-	render.Status(r, http.StatusAccepted)
-	render.JSON(w, r, R.JSON{"status": "convert request accepted"})
+func (m *Migrator) removeOldRemapBackups(siteID string) {
+	files, err := m.remapBackups(siteID)
+	if err != nil {
+		log.Printf("[WARN] %v", err)
+		return
+	}
+	for i := 0; i < len(files)-remapBackupsKeep; i++ {
+		if e := os.Remove(files[i]); e != nil {
+			log.Printf("[WARN] can't delete %s, %s", files[i], e)
+			continue
+		}
+		log.Printf("[DEBUG] removed %s", files[i])
+	}
+}
+
+// selectiveImporter returns importer merging comments selected by query params into the site, nil if none set.
+// Comments selected by post urls (url, repeated), user ids (user, repeated) and time range [from, to),
+// times in RFC3339 or yyyy-mm-dd format. Existing comments replaced with overwrite=1, skipped otherwise
+func (m *Migrator) selectiveImporter(r *http.Request, siteID string) (*migrator.Selective, error) {
+	query := r.URL.Query()
+	sel := migrator.Selection{Users: query["user"], URLs: query["url"]}
+	var err error
+	if sel.From, err = parseSelectionTime(query.Get("from")); err != nil {
+		return nil, errors.Wrap(err, "bad from")
+	}
+	if sel.To, err = parseSelectionTime(query.Get("to")); err != nil {
+		return nil, errors.Wrap(err, "bad to")
+	}
+	if sel.Empty() {
+		return nil, nil
+	}
+	if provider := query.Get("provider"); provider != "" && provider != "native" && provider != "archive" {
+		return nil, errors.Errorf("selective restore of %s not supported", provider)
+	}
+	return &migrator.Selective{DataStore: m.DataStore, Selection: sel, Overwrite: query.Get("overwrite") == "1",
+		URLNormalizer: m.URLNormalizer}, nil
 }
 
-// runImport reads from tmpfile and import for given siteID and provider
-func (m *Migrator) runImport(siteID string, provider string, tmpfile string) {
-	m.setBusy(siteID, true)
+// parseSelectionTime parses RFC3339 time or local date, empty string is zero time
+func parseSelectionTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
 
+// runImport reads from tmpfile and import for given siteID and provider. Runs as import job, progress counted
+// in bytes of tmpfile. baseURL used by providers without site url in exported data, userMapping maps imported
+// disqus users to remark42 users. Native and archive data merged by selective importer if set.
+// Archive import with comments restored but some of images and avatars failed completed with warning in logs
+func (m *Migrator) runImport(p *jobs.Progress, siteID, provider, baseURL string, userMapping map[string]string,
+	selective *migrator.Selective, tmpfile string) error {
 	defer func() {
-		m.setBusy(siteID, false)
 		if err := os.Remove(tmpfile); err != nil {
 			log.Printf("[WARN] failed to remove tmp file %s, %v", tmpfile, err)
 		}
 	}()
 
+	fh, err := os.Open(tmpfile) // nolint
+	if err != nil {
+		return errors.Wrap(err, "import failed")
+	}
+	defer func() { _ = fh.Close() }()
+	if fi, e := fh.Stat(); e == nil {
+		p.SetTotal(fi.Size())
+	}
+
+	var reader io.Reader = p.Reader(fh)
+	if migrator.IsEncryptedFile(tmpfile) {
+		if reader, provider, err = m.decrypt(reader, provider); err != nil {
+			return errors.Wrap(err, "import failed")
+		}
+		p.Logf("decrypted import data")
+	}
+
 	var importer migrator.Importer
 	switch provider {
 	case "disqus":
 		importer = m.DisqusImporter
+		if len(userMapping) > 0 {
+			importer = &migrator.Disqus{DataStore: m.DataStore, UserMapping: userMapping, AvatarProxy: m.AvatarProxy}
+		}
 	case "wordpress":
 		importer = m.WordPressImporter
+	case "commento":
+		importer = m.CommentoImporter
+	case "isso":
+		importer = m.IssoImporter
+		if baseURL != "" {
+			importer = &migrator.Isso{DataStore: m.DataStore, BaseURL: baseURL}
+		}
+	case "archive":
+		importer = m.ArchiveImporter
 	default:
 		importer = m.NativeImporter
 	}
+	if selective != nil {
+		importer = selective
+		p.Logf("selective restore of %s", selective.Selection)
+	}
 	log.Printf("[DEBUG] import request for site=%s, provider=%s", siteID, provider)
 
-	fh, err := os.Open(tmpfile) // nolint
+	size, err := importer.Import(reader, siteID)
+	if err != nil && !errors.Is(err, migrator.ErrPartialImport) {
+		return errors.Wrap(err, "import failed")
+	}
+	m.Cache.Flush(cache.Flusher(siteID).Scopes(siteID))
 	if err != nil {
-		log.Printf("[WARN] import failed, %v", err)
-		return
+		p.Logf("import request partially completed. site=%s, provider=%s, comments=%d, %v", siteID, provider, size, err)
+		return nil
 	}
+	p.Logf("import request completed. site=%s, provider=%s, comments=%d", siteID, provider, size)
+	return nil
+}
 
-	size, err := importer.Import(fh, siteID)
+// decrypt returns reader decrypted with Crypter. Encrypted backups are gzipped, gzip removed unless data imported
+// as archive. Native import of tar.gz switched to archive provider, as backups made with --backup-archive
+func (m *Migrator) decrypt(r io.Reader, provider string) (io.Reader, string, error) {
+	dec, err := m.Crypter.Decrypt(r)
 	if err != nil {
-		log.Printf("[WARN] import failed, %v", err)
-		return
+		return nil, provider, err
 	}
-	m.Cache.Flush(cache.Flusher(siteID).Scopes(siteID))
-	log.Printf("[DEBUG] import request completed. site=%s, provider=%s, comments=%d", siteID, provider, size)
+	br := bufio.NewReaderSize(dec, 64*1024)
+	head, _ := br.Peek(64 * 1024) // error ignored, shorter data peeked as is
+	if len(head) < 2 || head[0] != 0x1f || head[1] != 0x8b || provider == "archive" {
+		return br, provider, nil
+	}
+	if (provider == "" || provider == "native") && isTarGz(head) {
+		return br, "archive", nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, provider, errors.Wrap(err, "can't read decrypted gz")
+	}
+	return gz, provider, nil
+}
+
+// isTarGz checks for ustar magic of the first tar header in the beginning of gzipped data
+func isTarGz(head []byte) bool {
+	gz, err := gzip.NewReader(bytes.NewReader(head))
+	if err != nil {
+		return false
+	}
+	hdr := make([]byte, 262)
+	if _, err = io.ReadFull(gz, hdr); err != nil {
+		return false
+	}
+	return bytes.HasPrefix(hdr[257:], []byte("ustar"))
 }
 
 // saveTemp reads from reader and saves to temp file
@@ -266,22 +727,12 @@ func (m *Migrator) saveTemp(r io.Reader) (string, error) {
 	return tmpfile.Name(), nil
 }
 
-// isBusy checks busy flag from the map by siteID as key
-func (m *Migrator) isBusy(siteID string) bool {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	if m.busy == nil {
-		m.busy = map[string]bool{}
-	}
-	return m.busy[siteID]
-}
-
-// setBusy sets/resets busy flag to the map by siteID as key
-func (m *Migrator) setBusy(siteID string, status bool) {
+// jobs returns jobs service, makes in-memory one if not set
+func (m *Migrator) jobs() *jobs.Service {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	if m.busy == nil {
-		m.busy = map[string]bool{}
+	if m.Jobs == nil {
+		m.Jobs = jobs.NewService(jobs.NewMemoryStorage(), 0)
 	}
-	m.busy[siteID] = status
+	return m.Jobs
 }
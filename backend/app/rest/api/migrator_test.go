@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,13 +11,18 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/umputun/remark/backend/app/jobs"
+	"github.com/umputun/remark/backend/app/migrator"
 	"github.com/umputun/remark/backend/app/store"
 	"github.com/umputun/remark/backend/app/store/service"
 )
@@ -45,7 +51,7 @@ func TestMigrator_Import(t *testing.T) {
 
 	b, err := ioutil.ReadAll(resp.Body)
 	assert.NoError(t, err)
-	assert.Equal(t, "{\"status\":\"import request accepted\"}\n", string(b))
+	assertAccepted(t, "import request accepted", b)
 
 	waitForMigrationCompletion(t, ts)
 }
@@ -79,11 +85,58 @@ func TestMigrator_ImportForm(t *testing.T) {
 
 	b, err := ioutil.ReadAll(resp.Body)
 	assert.NoError(t, err)
-	assert.Equal(t, "{\"status\":\"import request accepted\"}\n", string(b))
+	assertAccepted(t, "import request accepted", b)
 
 	waitForMigrationCompletion(t, ts)
 }
 
+func TestMigrator_ImportFormUserMapping(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	makeBody := func(mapping string) (*bytes.Buffer, string) {
+		bodyBuf := &bytes.Buffer{}
+		bodyWriter := multipart.NewWriter(bodyBuf)
+		fileWriter, err := bodyWriter.CreateFormFile("file", "disqus.xml")
+		require.NoError(t, err)
+		_, err = io.WriteString(fileWriter, `<?xml version="1.0" encoding="utf-8"?>
+<disqus xmlns="http://disqus.com" xmlns:dsq="http://disqus.com/disqus-internals">
+	<thread dsq:id="1"><link>https://radio-t.com/blah1</link><isClosed>true</isClosed></thread>
+	<post dsq:id="101"><message><![CDATA[<p>test test #1</p>]]></message><createdAt>2018-04-30T01:37:00Z</createdAt>
+		<author><email>dev@example.com</email><name>developer one</name><username>dev-disqus</username></author>
+		<thread dsq:id="1"/><likes>2</likes></post>
+</disqus>`)
+		require.NoError(t, err)
+		mappingWriter, err := bodyWriter.CreateFormFile("mapping", "mapping.txt")
+		require.NoError(t, err)
+		_, err = io.WriteString(mappingWriter, mapping)
+		require.NoError(t, err)
+		require.NoError(t, bodyWriter.Close())
+		return bodyBuf, bodyWriter.FormDataContentType()
+	}
+
+	authts := strings.Replace(ts.URL, "http://", "http://admin:password@", 1)
+	body, contentType := makeBody("bad mapping row")
+	resp, err := http.Post(authts+"/api/v1/admin/import/form?site=remark42&provider=disqus", contentType, body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	body, contentType = makeBody("dev-disqus github_dev\n")
+	resp, err = http.Post(authts+"/api/v1/admin/import/form?site=remark42&provider=disqus", contentType, body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	require.Equal(t, 1, len(comments.Comments))
+	assert.Equal(t, "github_dev", comments.Comments[0].User.ID)
+	assert.Equal(t, 2, comments.Comments[0].Score)
+	assert.True(t, comments.Info.ReadOnly, "closed thread imported as read-only")
+}
+
 func TestMigrator_ImportFromWP(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -101,11 +154,34 @@ func TestMigrator_ImportFromWP(t *testing.T) {
 
 	b, err := ioutil.ReadAll(resp.Body)
 	assert.NoError(t, err)
-	assert.Equal(t, "{\"status\":\"import request accepted\"}\n", string(b))
+	assertAccepted(t, "import request accepted", b)
 
 	waitForMigrationCompletion(t, ts)
 }
 
+func TestMigrator_ImportFromIsso(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	fh, err := os.Open("../../migrator/testdata/isso.db")
+	require.NoError(t, err)
+	defer fh.Close()
+
+	client := &http.Client{Timeout: 1 * time.Second}
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/admin/import?site=remark42&provider=isso&base=https%3A%2F%2Fexample.com", fh)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	waitForMigrationCompletion(t, ts)
+
+	res, code := get(t, ts.URL+"/api/v1/count?site=remark42&url=https://example.com/post/1/")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"count":3,"locator":{"site":"remark42","url":"https://example.com/post/1/"}}`+"\n", res)
+}
+
 func TestMigrator_ImportRejected(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -127,6 +203,61 @@ func TestMigrator_ImportRejected(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 }
 
+func TestMigrator_ImportSelective(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	demo := store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo/"}
+	another := store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo-another/"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "first comment", Timestamp: time.Now(), Locator: demo, User: store.User{ID: "u1"}})
+	require.NoError(t, err)
+	id2, err := srv.DataService.Create(store.Comment{Text: "second comment", Timestamp: time.Now(), Locator: another, User: store.User{ID: "u1"}})
+	require.NoError(t, err)
+	backup := &bytes.Buffer{}
+	_, err = (&migrator.Native{DataStore: srv.DataService}).Export(backup, "remark42")
+	require.NoError(t, err)
+
+	require.NoError(t, srv.DataService.Delete(demo, id1, store.SoftDelete))
+	c2, err := srv.DataService.Get(another, id2, store.User{})
+	require.NoError(t, err)
+	c2.Text = "edited comment"
+	require.NoError(t, srv.DataService.Put(another, c2))
+
+	post := func(query string) *http.Response {
+		client := &http.Client{Timeout: 1 * time.Second}
+		req, e := http.NewRequest("POST", ts.URL+"/api/v1/admin/import?site=remark42&"+query, bytes.NewReader(backup.Bytes()))
+		require.NoError(t, e)
+		req.SetBasicAuth("admin", "password")
+		resp, e := client.Do(req)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	resp := post("provider=native&user=u1&url=https://remark42.com/demo/")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+	c1, err := srv.DataService.Get(demo, id1, store.User{})
+	require.NoError(t, err)
+	assert.False(t, c1.Deleted)
+	assert.Equal(t, "first comment", c1.Text)
+	c2, err = srv.DataService.Get(another, id2, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "edited comment", c2.Text, "other post not changed")
+
+	resp = post("user=u1&from=2000-01-01&overwrite=1")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+	c2, err = srv.DataService.Get(another, id2, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "second comment", c2.Text, "overwritten")
+
+	resp = post("provider=native&from=yesterday")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = post("provider=disqus&user=u1")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
 func TestMigrator_ImportDouble(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -196,7 +327,7 @@ func TestMigrator_ImportWaitExpired(t *testing.T) {
 }
 
 func TestMigrator_Export(t *testing.T) {
-	ts, _, teardown := startupT(t)
+	ts, srv, teardown := startupT(t)
 	defer teardown()
 
 	r := strings.NewReader(`{"version":1} {"id":"2aa0478c-df1b-46b1-b561-03d507cf482c","pid":"","text":"<p>test test #1</p>",
@@ -250,6 +381,49 @@ func TestMigrator_Export(t *testing.T) {
 	assert.Equal(t, 2, strings.Count(string(body), "\"text\""))
 	t.Logf("%s", string(body))
 
+	// check disqus and wordpress modes
+	for _, mode := range []string{"disqus", "wordpress"} {
+		req, err = http.NewRequest("GET", ts.URL+"/api/v1/admin/export?mode="+mode+"&site=remark42", nil)
+		require.NoError(t, err)
+		req.SetBasicAuth("admin", "password")
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+		require.Equal(t, "application/gzip", resp.Header.Get("Content-Type"))
+		assert.Contains(t, resp.Header.Get("Content-Disposition"), "."+mode+".xml.gz")
+
+		ungzReader, err = gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		ungzBody, err = ioutil.ReadAll(ungzReader)
+		assert.NoError(t, err)
+		assert.Contains(t, string(ungzBody), "<p>test test #1</p>")
+		assert.Contains(t, string(ungzBody), "https://radio-t.com/blah2")
+	}
+
+	// check archive mode, exported archive imported back
+	req, err = http.NewRequest("GET", ts.URL+"/api/v1/admin/export?mode=archive&site=remark42", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "application/gzip", resp.Header.Get("Content-Type"))
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), ".tar.gz")
+	archive, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	req, err = http.NewRequest("POST", ts.URL+"/api/v1/admin/import?site=remark42&provider=archive", bytes.NewReader(archive))
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+	comments, err := srv.DataService.Find(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(comments), "comments restored from archive")
+
 	req, err = http.NewRequest("GET", ts.URL+"/api/v1/admin/export?site=remark42", nil)
 	require.NoError(t, err)
 	resp, err = client.Do(req)
@@ -355,6 +529,410 @@ func TestMigrator_RemapReject(t *testing.T) {
 	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 }
 
+func TestMigrator_RemapDryRun(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	for i, u := range []string{"https://remark42.com/demo/", "https://remark42.com/demo/", "https://www.remark42.com/demo/",
+		"https://remark42.com/other/"} {
+		c := store.Comment{Text: fmt.Sprintf("comment %d", i), Timestamp: time.Now(),
+			Locator: store.Locator{SiteID: "remark42", URL: u}, User: store.User{ID: "u1"}}
+		_, err := srv.DataService.Create(c)
+		require.NoError(t, err)
+	}
+
+	resp, err := post(t, ts.URL+"/api/v1/admin/remap?site=remark42&dry=1", "https://remark42.com/* https://www.remark42.com/*")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	plan := migrator.RemapPlan{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&plan))
+	assert.Equal(t, 2, plan.Posts)
+	assert.Equal(t, 3, plan.Comments)
+	require.Equal(t, 1, len(plan.Rules))
+	assert.Equal(t, "https://remark42.com/* https://www.remark42.com/*", plan.Rules[0].Rule)
+	assert.Equal(t, []migrator.PostMove{
+		{From: "https://remark42.com/demo/", To: "https://www.remark42.com/demo/", Comments: 2},
+		{From: "https://remark42.com/other/", To: "https://www.remark42.com/other/", Comments: 1},
+	}, plan.Rules[0].Posts)
+	assert.Equal(t, []migrator.Collision{{To: "https://www.remark42.com/demo/",
+		From: []string{"https://remark42.com/demo/", "https://www.remark42.com/demo/"}}}, plan.Collisions)
+
+	// nothing changed
+	count, err := srv.DataService.Count(store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo/"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	resp, err = post(t, ts.URL+"/api/v1/admin/remap?site=remark42&dry=1", "bad-rule")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestMigrator_RemapRollback(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.Migrator.BackupLocation = "/tmp/remark42-remap-backups"
+	require.NoError(t, os.MkdirAll(srv.Migrator.BackupLocation, 0700))
+	defer os.RemoveAll(srv.Migrator.BackupLocation)
+
+	resp, err := post(t, ts.URL+"/api/v1/admin/remap/rollback?site=remark42", "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "no backups yet")
+
+	c := store.Comment{Text: "comment", Timestamp: time.Now(),
+		Locator: store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo/"}, User: store.User{ID: "u1"}}
+	_, err = srv.DataService.Create(c)
+	require.NoError(t, err)
+
+	resp, err = post(t, ts.URL+"/api/v1/admin/remap?site=remark42", "https://remark42.com/* https://www.remark42.com/*")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+
+	files, err := filepath.Glob(srv.Migrator.BackupLocation + "/pre-remap-remark42-*.gz")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(files), "pre-remap backup made")
+	count, err := srv.DataService.Count(store.Locator{SiteID: "remark42", URL: "https://www.remark42.com/demo/"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	resp, err = post(t, ts.URL+"/api/v1/admin/remap/rollback?site=remark42", "")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+
+	count, err = srv.DataService.Count(store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo/"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "comment back on the original url")
+	count, err = srv.DataService.Count(store.Locator{SiteID: "remark42", URL: "https://www.remark42.com/demo/"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// old backups removed
+	for i := 0; i < remapBackupsKeep+2; i++ {
+		fname := fmt.Sprintf("%s/pre-remap-remark42-20190101-00000%d.gz", srv.Migrator.BackupLocation, i)
+		require.NoError(t, ioutil.WriteFile(fname, []byte("x"), 0600))
+	}
+	srv.Migrator.removeOldRemapBackups("remark42")
+	files, err = filepath.Glob(srv.Migrator.BackupLocation + "/pre-remap-remark42-*.gz")
+	require.NoError(t, err)
+	assert.Equal(t, remapBackupsKeep, len(files))
+	assert.Contains(t, files[len(files)-1], "pre-remap-remark42-2", "the latest kept")
+
+	// backups of another site with the same prefix not touched
+	other := srv.Migrator.BackupLocation + "/pre-remap-remark42-b-20190101-000000.gz"
+	require.NoError(t, ioutil.WriteFile(other, []byte("x"), 0600))
+	latest, err := srv.Migrator.lastRemapBackup("remark42")
+	require.NoError(t, err)
+	assert.Equal(t, files[len(files)-1], latest)
+	for i := 0; i < remapBackupsKeep; i++ {
+		fname := fmt.Sprintf("%s/pre-remap-remark42-20200101-00000%d.gz", srv.Migrator.BackupLocation, i)
+		require.NoError(t, ioutil.WriteFile(fname, []byte("x"), 0600))
+	}
+	srv.Migrator.removeOldRemapBackups("remark42")
+	_, err = os.Stat(other)
+	assert.NoError(t, err, "backup of site remark42-b kept")
+	backups, err := srv.Migrator.remapBackups("remark42-b")
+	require.NoError(t, err)
+	assert.Equal(t, []string{other}, backups)
+}
+
+func TestMigrator_RemapRollbackEncrypted(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	key, pub, err := migrator.GenerateKey()
+	require.NoError(t, err)
+	srv.Migrator.BackupLocation = "/tmp/remark42-remap-backups-enc"
+	srv.Migrator.Crypter = &migrator.Crypter{Recipients: []string{pub}}
+	require.NoError(t, os.MkdirAll(srv.Migrator.BackupLocation, 0700))
+	defer os.RemoveAll(srv.Migrator.BackupLocation)
+
+	c := store.Comment{Text: "comment", Timestamp: time.Now(),
+		Locator: store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo/"}, User: store.User{ID: "u1"}}
+	_, err = srv.DataService.Create(c)
+	require.NoError(t, err)
+
+	resp, err := post(t, ts.URL+"/api/v1/admin/remap?site=remark42", "https://remark42.com/* https://www.remark42.com/*")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+	count, err := srv.DataService.Count(store.Locator{SiteID: "remark42", URL: "https://www.remark42.com/demo/"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "remapped with encryption enabled")
+
+	files, err := ioutil.ReadDir(srv.Migrator.BackupLocation)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files), "only encrypted backup kept")
+	assert.True(t, strings.HasSuffix(files[0].Name(), ".gz.enc"))
+	assert.True(t, migrator.IsEncryptedFile(srv.Migrator.BackupLocation+"/"+files[0].Name()))
+
+	// rollback fails without private key
+	resp, err = post(t, ts.URL+"/api/v1/admin/remap/rollback?site=remark42", "")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+	count, err = srv.DataService.Count(store.Locator{SiteID: "remark42", URL: "https://www.remark42.com/demo/"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "nothing changed")
+
+	srv.Migrator.Crypter.Identities = []string{key}
+	resp, err = post(t, ts.URL+"/api/v1/admin/remap/rollback?site=remark42", "")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+	count, err = srv.DataService.Count(store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo/"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "comment back on the original url")
+}
+
+func TestMigrator_ImportEncrypted(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.Migrator.Crypter = &migrator.Crypter{Passphrase: "secret"}
+
+	// encrypted gz export, as made by auto-backup
+	data := &bytes.Buffer{}
+	_, err := srv.Migrator.NativeExporter.Export(data, "remark42")
+	require.NoError(t, err)
+	data.WriteString(`{"id":"c1","text":"comment","locator":{"site":"remark42","url":"https://remark42.com/demo/"},"user":{"id":"u1"}}` + "\n")
+	buf := &bytes.Buffer{}
+	w, err := srv.Migrator.Crypter.Encrypt(buf)
+	require.NoError(t, err)
+	gz := gzip.NewWriter(w)
+	_, err = gz.Write(data.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, w.Close())
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/admin/import?site=remark42", bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+
+	count, err := srv.DataService.Count(store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo/"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "imported from encrypted backup")
+
+	// encrypted archive, detected by content
+	archive := &bytes.Buffer{}
+	_, err = srv.Migrator.ArchiveExporter.Export(archive, "remark42")
+	require.NoError(t, err)
+	require.NoError(t, srv.DataService.DeleteAll("remark42"))
+	buf.Reset()
+	w, err = srv.Migrator.Crypter.Encrypt(buf)
+	require.NoError(t, err)
+	_, err = w.Write(archive.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	req, err = http.NewRequest("POST", ts.URL+"/api/v1/admin/import?site=remark42", bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+	count, err = srv.DataService.Count(store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo/"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "imported from encrypted archive")
+}
+
+func TestMigrator_Normalize(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	// comments made before normalization rules, with different variants of the post url
+	for i, u := range []string{"http://remark42.com/demo/?utm_source=tw", "https://remark42.com/demo", "https://remark42.com/demo/"} {
+		c := store.Comment{ID: fmt.Sprintf("c%d", i), Text: "comment", Timestamp: time.Now(),
+			Locator: store.Locator{SiteID: "remark42", URL: u}, User: store.User{ID: "u1"}}
+		_, err := srv.DataService.Engine.Create(c)
+		require.NoError(t, err)
+	}
+	_, err := srv.DataService.UpdatePost(store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo/"},
+		store.Post{Aliases: []string{"https://remark42.com/demo-old/"}})
+	require.NoError(t, err)
+
+	resp, err := post(t, ts.URL+"/api/v1/admin/normalize?site=remark42", "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "no normalization rules")
+
+	norm := &service.URLNormalizer{Default: service.URLPolicy{Scheme: "https", Slash: "strip", Query: []string{}}}
+	srv.DataService.URLNormalizer, srv.Migrator.URLNormalizer = norm, norm
+
+	resp, err = post(t, ts.URL+"/api/v1/admin/normalize?site=remark42", "")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+
+	for _, u := range []string{"https://remark42.com/demo", "http://remark42.com/demo/?utm_source=fb", "https://remark42.com/demo-old"} {
+		res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url="+u)
+		require.Equal(t, 200, code)
+		comments := commentsWithInfo{}
+		err = json.Unmarshal([]byte(res), &comments)
+		require.NoError(t, err)
+		assert.Equal(t, 3, comments.Info.Count, u)
+		require.Equal(t, 3, len(comments.Comments), u)
+		assert.Equal(t, "https://remark42.com/demo", comments.Comments[0].Locator.URL)
+	}
+
+	p, err := srv.DataService.Post(store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://remark42.com/demo-old"}, p.Aliases, "aliases normalized and kept")
+}
+
+func TestMigrator_ImportArchivePartial(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	srv.Migrator.ArchiveImporter = partialImporter{}
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/admin/import?site=remark42&provider=archive", strings.NewReader("archive"))
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	accepted := struct{ Job string }{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&accepted))
+	require.NoError(t, resp.Body.Close())
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/wait?site=remark42&job="+accepted.Job)
+	require.Equal(t, http.StatusOK, code, body)
+	job := jobs.Job{}
+	require.NoError(t, json.Unmarshal([]byte(body), &job))
+	assert.Equal(t, jobs.StatusCompleted, job.Status, "comments imported")
+	require.Equal(t, 1, len(job.Logs))
+	assert.Contains(t, job.Logs[0], "import request partially completed. site=remark42, provider=archive, comments=2, "+
+		"failed to restore 1 of 3")
+}
+
+// partialImporter imports comments, failing to restore some of images and avatars
+type partialImporter struct{}
+
+func (partialImporter) Import(io.Reader, string) (int, error) {
+	return 2, errors.Wrap(migrator.ErrPartialImport, "failed to restore 1 of 3")
+}
+
+func TestMigrator_Jobs(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	r := strings.NewReader(`{"version":1} {"id":"2aa0478c-df1b-46b1-b561-03d507cf482c","pid":"","text":"<p>test test #1</p>",
+"user":{"name":"developer one","id":"dev"},"locator":{"site":"remark42","url":"https://radio-t.com/blah1"},
+"score":0,"votes":{},"time":"2018-04-30T01:37:00.849053725-05:00"}`)
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/admin/jobs?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	req, err = http.NewRequest("POST", ts.URL+"/api/v1/admin/import?site=remark42&provider=native", r)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	accepted := struct{ Job string }{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&accepted))
+	require.NoError(t, resp.Body.Close())
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/wait?site=remark42&job="+accepted.Job)
+	require.Equal(t, http.StatusOK, code, body)
+	job := jobs.Job{}
+	require.NoError(t, json.Unmarshal([]byte(body), &job))
+	assert.Equal(t, accepted.Job, job.ID)
+	assert.Equal(t, jobs.KindImport, job.Kind)
+	assert.Equal(t, jobs.StatusCompleted, job.Status)
+	assert.True(t, job.Total > 0)
+	assert.Equal(t, job.Total, job.Done)
+	require.Equal(t, 1, len(job.Logs))
+	assert.Contains(t, job.Logs[0], "import request completed. site=remark42, provider=native, comments=1")
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/jobs/"+accepted.Job+"?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	job = jobs.Job{}
+	require.NoError(t, json.Unmarshal([]byte(body), &job))
+	assert.Equal(t, jobs.StatusCompleted, job.Status)
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/export?mode=stream&site=remark42")
+	require.Equal(t, http.StatusOK, code)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/jobs?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	list := []jobs.Job{}
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	require.Equal(t, 2, len(list))
+	assert.Equal(t, jobs.KindExport, list[0].Kind, "the latest first")
+	assert.Equal(t, accepted.Job, list[1].ID)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/jobs?site=remark42&limit=1")
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	assert.Equal(t, 1, len(list))
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/jobs/bad?site=remark42")
+	assert.Equal(t, http.StatusNotFound, code)
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/wait?site=remark42&job=bad")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	req, err = http.NewRequest("DELETE", ts.URL+"/api/v1/admin/jobs/"+accepted.Job+"?site=remark42", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "completed job can't be canceled")
+}
+
+func TestMigrator_JobCancel(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	started := make(chan struct{})
+	running, err := srv.Migrator.jobs().Start(context.Background(), "remark42", jobs.KindImport,
+		func(ctx context.Context, p *jobs.Progress) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	require.NoError(t, err)
+	<-started
+
+	resp, err := post(t, ts.URL+"/api/v1/admin/remap?site=remark42", "https://remark42.com/* https://www.remark42.com/*")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "rejected while import running")
+
+	req, err := http.NewRequest("DELETE", ts.URL+"/api/v1/admin/jobs/bad?site=remark42", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	req, err = http.NewRequest("DELETE", ts.URL+"/api/v1/admin/jobs/"+running.ID+"?site=remark42", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/wait?site=remark42&job="+running.ID)
+	require.Equal(t, http.StatusOK, code, body)
+	job := jobs.Job{}
+	require.NoError(t, json.Unmarshal([]byte(body), &job))
+	assert.Equal(t, jobs.StatusCanceled, job.Status)
+	waitForMigrationCompletion(t, ts)
+}
+
+func assertAccepted(t *testing.T, status string, body []byte) {
+	res := struct {
+		Status string `json:"status"`
+		Job    string `json:"job"`
+	}{}
+	require.NoError(t, json.Unmarshal(body, &res), string(body))
+	assert.Equal(t, status, res.Status)
+	assert.NotEmpty(t, res.Job, "job id returned")
+}
+
 func waitForMigrationCompletion(t *testing.T, ts *httptest.Server) {
 	client := &http.Client{Timeout: 10 * time.Second}
 	req, err := http.NewRequest("GET", ts.URL+"/api/v1/admin/wait?site=remark42", nil)
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"sync"
+
+	cache "github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+const (
+	previewWorkers   = 4   // comments loading link previews at once
+	previewQueueSize = 100 // comments waiting for link previews, more dropped
+)
+
+// previewWorker makes link previews of comments in background, as linked pages loaded slowly, and stores them
+// with the comment. Loading cancelled and waiting comments dropped on Close
+type previewWorker struct {
+	formatter   *store.CommentFormatter
+	dataService previewStore
+	cache       LoadingCache
+
+	queue  chan store.Comment
+	once   sync.Once
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type previewStore interface {
+	AddPreviews(locator store.Locator, commentID, text string, previews []store.LinkPreview) error
+}
+
+func newPreviewWorker(formatter *store.CommentFormatter, dataService previewStore, cache LoadingCache) *previewWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &previewWorker{formatter: formatter, dataService: dataService, cache: cache,
+		queue: make(chan store.Comment, previewQueueSize), ctx: ctx, cancel: cancel}
+}
+
+// Submit queues comment for link previews, workers started on the first call. Dropped if previews disabled,
+// queue is full or worker closed
+func (p *previewWorker) Submit(comment store.Comment) {
+	if p == nil || !p.formatter.PreviewsEnabled() || p.ctx.Err() != nil {
+		return
+	}
+	p.once.Do(func() {
+		p.wg.Add(previewWorkers)
+		for i := 0; i < previewWorkers; i++ {
+			go p.do()
+		}
+	})
+	select {
+	case p.queue <- comment:
+	default:
+		log.Printf("[WARN] link previews queue is full, previews of comment %s dropped", comment.ID)
+	}
+}
+
+// Close cancels loading of previews and waits for workers to stop
+func (p *previewWorker) Close() {
+	if p == nil {
+		return
+	}
+	p.cancel()
+	p.wg.Wait()
+}
+
+func (p *previewWorker) do() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case comment := <-p.queue:
+			p.add(comment)
+		}
+	}
+}
+
+// add loads previews of the comment and stores them, previews dropped if the comment edited or deleted meanwhile
+func (p *previewWorker) add(comment store.Comment) {
+	previews := p.formatter.Previews(p.ctx, comment.Text)
+	if len(previews) == 0 || p.ctx.Err() != nil {
+		return
+	}
+	if err := p.dataService.AddPreviews(comment.Locator, comment.ID, comment.Text, previews); err != nil {
+		log.Printf("[WARN] can't add link previews to comment %s, %v", comment.ID, err)
+		return
+	}
+	p.cache.Flush(cache.Flusher(comment.Locator.SiteID).
+		Scopes(comment.Locator.SiteID, comment.Locator.URL, lastCommentsScope, comment.User.ID))
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/lcw"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+type blockingPreviewer struct{ started chan struct{} }
+
+func (m blockingPreviewer) Previews(ctx context.Context, _ string) []store.LinkPreview {
+	m.started <- struct{}{}
+	<-ctx.Done()
+	return []store.LinkPreview{{URL: "https://example.com", Title: "partial"}}
+}
+
+type mockPreviewStore struct{ calls int32 }
+
+func (m *mockPreviewStore) AddPreviews(store.Locator, string, string, []store.LinkPreview) error {
+	atomic.AddInt32(&m.calls, 1)
+	return nil
+}
+
+func TestPreviewWorker_Close(t *testing.T) {
+	previewer := blockingPreviewer{started: make(chan struct{}, 10)}
+	ds := &mockPreviewStore{}
+	w := newPreviewWorker(store.NewCommentFormatter().WithPreviews(previewer), ds, lcw.NewScache(lcw.NewNopCache()))
+
+	w.Submit(store.Comment{ID: "c1", Text: "text"})
+	select {
+	case <-previewer.started:
+	case <-time.After(time.Second):
+		require.Fail(t, "previews not started")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		w.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "close not completed")
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&ds.calls), "cancelled previews not stored")
+
+	w.Submit(store.Comment{ID: "c2", Text: "text"})
+	assert.Equal(t, 0, len(w.queue), "closed worker ignores comments")
+}
+
+func TestPreviewWorker_Disabled(t *testing.T) {
+	w := newPreviewWorker(store.NewCommentFormatter(), &mockPreviewStore{}, lcw.NewScache(lcw.NewNopCache()))
+	w.Submit(store.Comment{ID: "c1", Text: "text"})
+	assert.Equal(t, 0, len(w.queue))
+	w.Close()
+
+	var nw *previewWorker
+	nw.Submit(store.Comment{ID: "c1"})
+	nw.Close()
+}
@@ -28,7 +28,9 @@ import (
 	"github.com/umputun/remark/backend/app/notify"
 	"github.com/umputun/remark/backend/app/rest"
 	"github.com/umputun/remark/backend/app/rest/proxy"
+	"github.com/umputun/remark/backend/app/rest/ratelimit"
 	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/changelog"
 	"github.com/umputun/remark/backend/app/store/image"
 	"github.com/umputun/remark/backend/app/store/service"
 )
@@ -46,7 +48,10 @@ type Rest struct {
 	NotifyService    *notify.Service
 	ImageService     *image.Service
 	Streamer         *Streamer
+	ChangeLog        *changelog.Service
+	RateLimiter      *ratelimit.Limiter
 
+	Sites           []string // all served sites
 	AnonVote        bool
 	WebRoot         string
 	RemarkURL       string
@@ -67,6 +72,7 @@ type Rest struct {
 	httpServer  *http.Server
 	lock        sync.Mutex
 
+	previews  *previewWorker // shared by private group, closed when server stopped
 	pubRest   public
 	privRest  private
 	adminRest admin
@@ -90,6 +96,11 @@ type commentsWithInfo struct {
 
 // Run the lister and request's router, activate rest server
 func (s *Rest) Run(port int) {
+	defer func() { // server stopped, cancel link previews before stores closed
+		s.lock.Lock()
+		s.previews.Close()
+		s.lock.Unlock()
+	}()
 	switch s.SSLConfig.SSLMode {
 	case None:
 		log.Printf("[INFO] activate http rest server on port %d", port)
@@ -202,13 +213,13 @@ func (s *Rest) routes() chi.Router {
 
 	router.Group(func(r chi.Router) {
 		r.Use(middleware.Timeout(5 * time.Second))
-		r.Use(logInfoWithBody, tollbooth_chi.LimitHandler(tollbooth.NewLimiter(5, nil)), middleware.NoCache)
+		r.Use(logInfoWithBody, s.rateLimit("auth", 5), middleware.NoCache)
 		r.Mount("/auth", authHandler)
 	})
 
 	router.Group(func(r chi.Router) {
 		r.Use(middleware.Timeout(5 * time.Second))
-		r.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(100, nil)), middleware.NoCache)
+		r.Use(s.rateLimit("avatar", 100), middleware.NoCache)
 		r.Mount("/avatar", avatarHandler)
 	})
 
@@ -216,10 +227,11 @@ func (s *Rest) routes() chi.Router {
 
 	// api routes
 	router.Route("/api/v1", func(rapi chi.Router) {
+		rapi.Use(s.normalizeURL)
 
 		rapi.Group(func(rava chi.Router) {
 			rava.Use(middleware.Timeout(5 * time.Second))
-			rava.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(100, nil)))
+			rava.Use(s.rateLimit("avatar", 100))
 			rava.Use(middleware.NoCache)
 			rava.Mount("/avatar", avatarHandler)
 		})
@@ -227,7 +239,7 @@ func (s *Rest) routes() chi.Router {
 		// open routes
 		rapi.Group(func(ropen chi.Router) {
 			ropen.Use(middleware.Timeout(30 * time.Second))
-			ropen.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
+			ropen.Use(s.rateLimit("open", 10))
 			ropen.Use(authMiddleware.Trace, middleware.NoCache, logInfoWithBody)
 			ropen.Get("/config", s.configCtrl)
 			ropen.Get("/find", s.pubRest.findCommentsCtrl)
@@ -251,16 +263,17 @@ func (s *Rest) routes() chi.Router {
 
 		// open routes, streams, no send timeout
 		rapi.Route("/stream", func(rstream chi.Router) {
-			rstream.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
+			rstream.Use(s.rateLimit("stream", 10))
 			rstream.Use(authMiddleware.Trace, middleware.NoCache, logInfoWithBody)
 			rstream.Get("/info", s.pubRest.infoStreamCtrl)
 			rstream.Get("/last", s.pubRest.lastCommentsStreamCtrl)
+			rstream.Get("/ws", s.pubRest.wsCtrl)
 		})
 
 		// open routes, cached
 		rapi.Group(func(ropen chi.Router) {
 			ropen.Use(middleware.Timeout(30 * time.Second))
-			ropen.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
+			ropen.Use(s.rateLimit("picture", 10))
 			ropen.Use(authMiddleware.Trace, logInfoWithBody)
 			ropen.Get("/picture/{user}/{id}", s.pubRest.loadPictureCtrl)
 		})
@@ -268,7 +281,7 @@ func (s *Rest) routes() chi.Router {
 		// protected routes, require auth
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(30 * time.Second))
-			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
+			rauth.Use(s.rateLimit("user", 10))
 			rauth.Use(authMiddleware.Auth, matchSiteID, middleware.NoCache, logInfoWithBody)
 			rauth.Get("/user", s.privRest.userInfoCtrl)
 			rauth.Get("/userdata", s.privRest.userAllDataCtrl)
@@ -277,7 +290,7 @@ func (s *Rest) routes() chi.Router {
 		// admin routes, require auth and admin users only
 		rapi.Route("/admin", func(radmin chi.Router) {
 			radmin.Use(middleware.Timeout(30 * time.Second))
-			radmin.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
+			radmin.Use(s.rateLimit("admin", 10))
 			radmin.Use(authMiddleware.Auth, authMiddleware.AdminOnly, matchSiteID)
 			radmin.Use(middleware.NoCache, logInfoWithBody)
 
@@ -291,19 +304,41 @@ func (s *Rest) routes() chi.Router {
 			radmin.Get("/blocked", s.adminRest.blockedUsersCtrl)
 			radmin.Put("/readonly", s.adminRest.setReadOnlyCtrl)
 			radmin.Put("/title/{id}", s.adminRest.setTitleCtrl)
+			radmin.Put("/title", s.adminRest.setPostTitleCtrl)
+			radmin.Get("/post", s.adminRest.getPostCtrl)
+			radmin.Put("/post", s.adminRest.updatePostCtrl)
+			radmin.Put("/move/{id}", s.adminRest.moveCommentCtrl)
+			radmin.Put("/merge", s.adminRest.mergePostsCtrl)
+			radmin.Get("/images/gc", s.adminRest.imagesGCCtrl)
+			radmin.Post("/images/gc", s.adminRest.imagesGCCtrl)
+			radmin.Get("/images", s.adminRest.imagesCtrl)
+			radmin.Delete("/images/{user}/{id}", s.adminRest.deleteImageCtrl)
 
 			// migrator
 			radmin.Get("/export", s.adminRest.migrator.exportCtrl)
 			radmin.Post("/import", s.adminRest.migrator.importCtrl)
 			radmin.Post("/import/form", s.adminRest.migrator.importFormCtrl)
 			radmin.Post("/remap", s.adminRest.migrator.remapCtrl)
+			radmin.Post("/remap/rollback", s.adminRest.migrator.remapRollbackCtrl)
+			radmin.Post("/normalize", s.adminRest.migrator.normalizeCtrl)
 			radmin.Get("/wait", s.adminRest.migrator.waitCtrl)
+			radmin.Get("/jobs", s.adminRest.migrator.jobsCtrl)
+			radmin.Get("/jobs/{id}", s.adminRest.migrator.jobCtrl)
+			radmin.Delete("/jobs/{id}", s.adminRest.migrator.cancelJobCtrl)
+		})
+
+		// admin routes, change feed with long-poll and streaming, no send timeout
+		rapi.Route("/admin/changes", func(rchanges chi.Router) {
+			rchanges.Use(s.rateLimit("changes", 10))
+			rchanges.Use(authMiddleware.Auth, authMiddleware.AdminOnly, matchSiteID)
+			rchanges.Use(middleware.NoCache, logInfoWithBody)
+			rchanges.Get("/", s.adminRest.changesCtrl)
 		})
 
 		// protected routes, throttled to 10/s by default, controlled by external UpdateLimiter param
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(10 * time.Second))
-			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(s.updateLimiter(), nil)))
+			rauth.Use(s.rateLimit("update", s.updateLimiter()))
 			rauth.Use(authMiddleware.Auth, matchSiteID)
 			rauth.Use(middleware.NoCache)
 			rauth.Use(logger.New(logger.Log(log.Default()), logger.WithBody, logger.Prefix("[DEBUG]"), logger.IPfn(ipFn)).Handler)
@@ -321,7 +356,7 @@ func (s *Rest) routes() chi.Router {
 		// protected routes, anonymous rejected
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(10 * time.Second))
-			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(s.updateLimiter(), nil)))
+			rauth.Use(s.rateLimit("update", s.updateLimiter()))
 			rauth.Use(authMiddleware.Auth, rejectAnonUser, matchSiteID)
 			rauth.Use(logger.New(logger.Log(log.Default()), logger.Prefix("[DEBUG]"), logger.IPfn(ipFn)).Handler)
 			rauth.Post("/picture", s.privRest.savePictureCtrl)
@@ -332,7 +367,7 @@ func (s *Rest) routes() chi.Router {
 	// open routes on root level
 	router.Group(func(rroot chi.Router) {
 		rroot.Use(middleware.Timeout(10 * time.Second))
-		rroot.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(50, nil)))
+		rroot.Use(s.rateLimit("root", 50))
 		rroot.Get("/index.html", s.pubRest.getStartedCtrl)
 		rroot.Get("/robots.txt", s.pubRest.robotsCtrl)
 		rroot.Get("/email/unsubscribe.html", s.privRest.emailUnsubscribeCtrl)
@@ -340,7 +375,7 @@ func (s *Rest) routes() chi.Router {
 	})
 
 	// file server for static content from /web
-	addFileServer(router, "/web", http.Dir(s.WebRoot), s.Version)
+	addFileServer(router, "/web", http.Dir(s.WebRoot), s.Version, s.rateLimit("web", 20))
 	return router
 }
 
@@ -356,6 +391,7 @@ func (s *Rest) controllerGroups() (public, private, admin, rss) {
 		streamer:         s.Streamer,
 	}
 
+	s.previews = newPreviewWorker(s.CommentFormatter, s.DataService, s.Cache)
 	privGrp := private{
 		dataService:      s.DataService,
 		cache:            s.Cache,
@@ -367,6 +403,8 @@ func (s *Rest) controllerGroups() (public, private, admin, rss) {
 		remarkURL:        s.RemarkURL,
 		adminEmail:       s.AdminEmail,
 		anonVote:         s.AnonVote,
+		urlNormalizer:    s.urlNormalizer(),
+		previews:         s.previews,
 	}
 
 	admGrp := admin{
@@ -375,6 +413,9 @@ func (s *Rest) controllerGroups() (public, private, admin, rss) {
 		cache:         s.Cache,
 		authenticator: s.Authenticator,
 		readOnlyAge:   s.ReadOnlyAge,
+		changeLog:     s.ChangeLog,
+		imageService:  s.ImageService,
+		sites:         s.Sites,
 	}
 
 	rssGrp := rss{
@@ -385,6 +426,15 @@ func (s *Rest) controllerGroups() (public, private, admin, rss) {
 	return pubGrp, privGrp, admGrp, rssGrp
 }
 
+// rateLimit makes middleware limiting requests of the routes group, default rate set in requests per second.
+// Uses configured RateLimiter with its rules and store, or in-memory tollbooth limiter if not set.
+func (s *Rest) rateLimit(name string, perSec float64) func(http.Handler) http.Handler {
+	if s.RateLimiter == nil {
+		return tollbooth_chi.LimitHandler(tollbooth.NewLimiter(perSec, nil))
+	}
+	return s.RateLimiter.Handler(name, ratelimit.PerSecond(perSec))
+}
+
 // updateLimiter returns UpdateLimiter if set, or 10 if not
 func (s *Rest) updateLimiter() float64 {
 	lmt := 10.0
@@ -447,7 +497,7 @@ func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 }
 
 // serves static files from /web or embedded by statik
-func addFileServer(r chi.Router, path string, root http.FileSystem, version string) {
+func addFileServer(r chi.Router, path string, root http.FileSystem, version string, limiter func(http.Handler) http.Handler) {
 
 	var webFS http.Handler
 
@@ -469,7 +519,7 @@ func addFileServer(r chi.Router, path string, root http.FileSystem, version stri
 	}
 	path += "*"
 
-	r.With(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(20, nil)),
+	r.With(limiter,
 		middleware.Timeout(10*time.Second),
 		cacheControl(time.Hour, version),
 	).Get(path, func(w http.ResponseWriter, r *http.Request) {
@@ -571,6 +621,30 @@ func matchSiteID(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// normalizeURL is a middleware replacing post url in query with the normalized one,
+// so all variants of post url share cache entries and streams
+func (s *Rest) normalizeURL(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if postURL := q.Get("url"); postURL != "" {
+			if normURL := s.urlNormalizer().URL(q.Get("site"), postURL); normURL != postURL {
+				q.Set("url", normURL)
+				r.URL.RawQuery = q.Encode()
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// urlNormalizer returns normalizer of post urls, nil if not set
+func (s *Rest) urlNormalizer() *service.URLNormalizer {
+	if s.DataService == nil {
+		return nil
+	}
+	return s.DataService.URLNormalizer
+}
+
 // cacheControl is a middleware setting cache expiration. Using url+version as etag
 func cacheControl(expiration time.Duration, version string) func(http.Handler) http.Handler {
 
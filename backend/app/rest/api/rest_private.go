@@ -41,6 +41,8 @@ type private struct {
 	remarkURL        string
 	adminEmail       string
 	anonVote         bool
+	urlNormalizer    *service.URLNormalizer
+	previews         *previewWorker
 }
 
 type privStore interface {
@@ -74,6 +76,22 @@ const unsubscribeHtml = `<!DOCTYPE html>
 </html>
 `
 
+// floodErrors maps comments rejected by flood policy to response status and error code
+var floodErrors = map[error]struct{ status, code int }{
+	service.ErrSlowMode:         {http.StatusTooManyRequests, rest.ErrSlowMode},
+	service.ErrDailyQuota:       {http.StatusTooManyRequests, rest.ErrDailyQuota},
+	service.ErrLinksNotAllowed:  {http.StatusForbidden, rest.ErrLinksNotAllowed},
+	service.ErrTooManyLinks:     {http.StatusBadRequest, rest.ErrTooManyLinks},
+	service.ErrDuplicateComment: {http.StatusConflict, rest.ErrDuplicateComment},
+}
+
+// uploadErrors maps images rejected by upload limits to response status and error code
+var uploadErrors = map[error]struct{ status, code int }{
+	image.ErrUserQuota:    {http.StatusRequestEntityTooLarge, rest.ErrUserImagesQuota},
+	image.ErrSiteQuota:    {http.StatusRequestEntityTooLarge, rest.ErrSiteImagesQuota},
+	image.ErrDailyUploads: {http.StatusTooManyRequests, rest.ErrDailyImages},
+}
+
 // POST /comment - adds comment, resets all immutable fields
 func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 
@@ -92,6 +110,7 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 
 	comment.PrepareUntrusted() // clean all fields user not supposed to set
+	comment.Locator = s.urlNormalizer.Locator(comment.Locator)
 	comment.User = user
 	comment.User.IP = strings.Split(r.RemoteAddr, ":")[0]
 
@@ -118,6 +137,10 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentValidation)
 		return
 	}
+	if fe, ok := floodErrors[err]; ok {
+		rest.SendErrorJSON(w, r, fe.status, err, "rejected by flood control", fe.code)
+		return
+	}
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't save comment", rest.ErrInternal)
 		return
@@ -130,7 +153,8 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	s.cache.Flush(cache.Flusher(comment.Locator.SiteID).
-		Scopes(comment.Locator.URL, lastCommentsScope, comment.User.ID, comment.Locator.SiteID))
+		Scopes(finalComment.Locator.URL, lastCommentsScope, comment.User.ID, comment.Locator.SiteID))
+	s.previews.Submit(finalComment) // link previews loaded in background and added to the comment
 
 	// user notification
 	if s.notifyService != nil {
@@ -200,6 +224,9 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, user.ID))
+	if !res.Deleted {
+		s.previews.Submit(res)
+	}
 	render.JSON(w, r, res)
 }
 
@@ -258,7 +285,7 @@ func (s *private) voteCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't vote for comment", code)
 		return
 	}
-	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, comment.User.ID))
+	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, comment.Locator.URL, comment.User.ID)) // url may be an alias
 	render.JSON(w, r, R.JSON{"id": comment.ID, "score": comment.Score})
 }
 
@@ -581,7 +608,11 @@ func (s *private) savePictureCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 	defer func() { _ = file.Close() }()
 
-	id, err := s.imageService.Save(user.ID, file)
+	id, err := s.imageService.Upload(user.SiteID, user.ID, file)
+	if ue, ok := uploadErrors[err]; ok {
+		rest.SendErrorJSON(w, r, ue.status, err, "rejected by upload limits", ue.code)
+		return
+	}
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't save image", rest.ErrInternal)
 		return
@@ -3,9 +3,11 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	goimage "image"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
@@ -26,6 +28,7 @@ import (
 	"github.com/umputun/remark/backend/app/notify"
 	"github.com/umputun/remark/backend/app/store"
 	"github.com/umputun/remark/backend/app/store/image"
+	"github.com/umputun/remark/backend/app/store/service"
 )
 
 // gopher png for test, from https://golang.org/src/image/png/example_test.go
@@ -53,6 +56,40 @@ func TestRest_Create(t *testing.T) {
 	assert.True(t, len(c["id"].(string)) > 8)
 }
 
+type mockPreviewer struct{}
+
+func (m mockPreviewer) Previews(context.Context, string) []store.LinkPreview {
+	return []store.LinkPreview{{URL: "https://example.com", Title: "example title"}}
+}
+
+func TestRest_CreateWithPreviews(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.CommentFormatter.WithPreviews(mockPreviewer{})
+
+	resp, err := post(t, ts.URL+"/api/v1/comment",
+		`{"text": "see https://example.com", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode, string(b))
+	c := store.Comment{}
+	require.NoError(t, json.Unmarshal(b, &c))
+	assert.Empty(t, c.Previews, "previews not waited for")
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	assert.Eventually(t, func() bool {
+		stored, e := srv.DataService.Get(locator, c.ID, store.User{})
+		return e == nil && len(stored.Previews) == 1 && stored.Previews[0].Title == "example title"
+	}, time.Second, 10*time.Millisecond, "previews added in background")
+
+	// cached post flushed
+	assert.Eventually(t, func() bool {
+		body, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=plain")
+		return code == http.StatusOK && strings.Contains(body, "example title")
+	}, time.Second, 10*time.Millisecond)
+}
+
 func TestRest_CreateOldPost(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -156,6 +193,34 @@ func TestRest_CreateRejected(t *testing.T) {
 	require.Equal(t, http.StatusForbidden, resp.StatusCode, "reject wrong aud")
 }
 
+func TestRest_CreateFloodControl(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.FloodControl = &service.FloodControl{Default: service.FloodPolicy{SlowMode: time.Minute, MaxLinks: 1}}
+
+	send := func(body string) (code int, resp string) {
+		req, err := http.NewRequest("POST", ts.URL+"/api/v1/comment", strings.NewReader(body))
+		require.NoError(t, err)
+		r, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		defer r.Body.Close()
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		return r.StatusCode, string(b)
+	}
+
+	code, body := send(`{"text": "links http://radio-t.com and http://example.com", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, `"code":22`)
+
+	code, _ = send(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	assert.Equal(t, http.StatusCreated, code)
+	code, body = send(`{"text": "test 456", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Contains(t, body, `"code":19`)
+	assert.Contains(t, body, "too frequent comments on the post")
+}
+
 func TestRest_CreateAndGet(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -895,6 +960,110 @@ func TestRest_SavePictureCtrl(t *testing.T) {
 	assert.Equal(t, 400, resp.StatusCode)
 }
 
+func TestRest_SavePictureLimits(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.ImageService.Uploads = image.NewMemoryUploads()
+	srv.ImageService.UploadLimits = image.UploadLimits{DailyCount: 2}
+
+	savePic := func() (code int, body string) {
+		bodyBuf := &bytes.Buffer{}
+		bodyWriter := multipart.NewWriter(bodyBuf)
+		fileWriter, err := bodyWriter.CreateFormFile("file", "picture.png")
+		require.NoError(t, err)
+		_, err = io.Copy(fileWriter, gopherPNG())
+		require.NoError(t, err)
+		require.NoError(t, bodyWriter.Close())
+
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/picture", bodyBuf)
+		require.NoError(t, err)
+		req.Header.Add("Content-Type", bodyWriter.FormDataContentType())
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(b)
+	}
+
+	for i := 0; i < 2; i++ {
+		code, body := savePic()
+		require.Equal(t, http.StatusOK, code, body)
+	}
+	code, body := savePic()
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Contains(t, body, `"code":26`)
+
+	srv.ImageService.UploadLimits = image.UploadLimits{UserSize: 2 * 1462}
+	code, body = savePic()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Contains(t, body, `"code":24`)
+
+	srv.ImageService.UploadLimits = image.UploadLimits{SiteSize: 2 * 1462}
+	code, body = savePic()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Contains(t, body, `"code":25`)
+
+	uploads, err := srv.ImageService.Uploads.List("remark42", 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(uploads))
+	assert.Equal(t, "dev", uploads[0].UserID)
+	assert.Equal(t, int64(1462), uploads[0].Size)
+}
+
+func TestRest_LoadPictureVariant(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.ImageService.VariantWidths = []int{16}
+
+	id, err := srv.ImageService.Save("user1", gopherPNG())
+	require.NoError(t, err)
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/v1/picture/%s?w=16", ts.URL, id))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"`+id+`_w16"`, resp.Header.Get("Etag"))
+	cfg, _, err := goimage.DecodeConfig(bytes.NewReader(body))
+	require.NoError(t, err)
+	assert.Equal(t, 16, cfg.Width)
+
+	resp, err = http.Get(fmt.Sprintf("%s/api/v1/picture/%s?w=17", ts.URL, id))
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "width not allowed")
+
+	resp, err = http.Get(fmt.Sprintf("%s/api/v1/picture/%s?w=abc", ts.URL, id))
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestRest_LoadPictureSignedURL(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	srv.pubRest.imageService = image.NewService(signedURLStore{Store: &image.FileSystem{Location: "/tmp/remark42/images"}},
+		image.ServiceParams{SignedURLTTL: time.Minute})
+
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(ts.URL + "/api/v1/picture/user1/pic.png")
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "https://s3.example.com/remark/user1/pic.png?sig=123", resp.Header.Get("Location"))
+}
+
+type signedURLStore struct {
+	image.Store
+}
+
+func (s signedURLStore) SignedURL(id string, ttl time.Duration) (string, error) {
+	return "https://s3.example.com/remark/" + id + "?sig=123", nil
+}
+
 func TestRest_CreateWithPictures(t *testing.T) {
 	ts, svc, teardown := startupT(t)
 	defer func() {
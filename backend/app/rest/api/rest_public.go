@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha1" // nolint
 	"encoding/base64"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -17,6 +18,7 @@ import (
 	cache "github.com/go-pkgz/lcw"
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
+	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 
 	"github.com/umputun/remark/backend/app/rest"
@@ -170,35 +172,39 @@ func (s *public) infoStreamCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fn := func() steamEventFn {
+	fn := func() hubEventFn {
 		lastTS := sinceTs
 		lastCount := 0
 
-		return func() (event string, data []byte, upd bool, err error) {
+		return func(changed time.Time) (event string, data []byte, upd bool, err error) {
 			key := cache.NewKey(locator.SiteID).ID(URLKey(r)).Scopes(locator.SiteID, locator.URL)
-			data, err = s.cache.Get(key, func() ([]byte, error) {
+			streamKey := "info:" + locator.SiteID + ":" + locator.URL
+			data, err = s.streamData(key, streamKey, changed, func() ([]byte, error) {
 				info, e := s.dataService.Info(locator, s.readOnlyAge)
 				if e != nil {
 					return nil, e
 				}
-				// cache update used as indication of post update. comparing lastTS for no-cache.
-				// removal won't update lastTS, count check will catch it.
-				if !lastTS.IsZero() && (info.LastTS != lastTS || info.Count != lastCount) {
-					upd = true
-				}
-				lastTS = info.LastTS
-				lastCount = info.Count
 				return encodeJSONWithHTML(info)
 			})
 			if err != nil {
 				return "info", data, false, err
 			}
 
+			info := store.PostInfo{}
+			if err = json.Unmarshal(data, &info); err != nil {
+				return "info", nil, false, errors.Wrap(err, "can't decode post info")
+			}
+			// removal won't update lastTS, count check will catch it
+			if !lastTS.IsZero() && (!info.LastTS.Equal(lastTS) || info.Count != lastCount) {
+				upd = true
+			}
+			lastTS = info.LastTS
+			lastCount = info.Count
 			return "info", data, upd, nil
 		}
 	}
 
-	if e := s.streamer.Activate(r.Context(), fn, w); e != nil {
+	if e := s.streamer.ActivateFor(r.Context(), locator, fn, w); e != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, e, "can't stream", rest.ErrInternal)
 	}
 }
@@ -255,31 +261,61 @@ func (s *public) lastCommentsStreamCtrl(w http.ResponseWriter, r *http.Request)
 		sinceTs = time.Now()
 	}
 
-	fn := func() steamEventFn {
+	fn := func() hubEventFn {
 		sinceTime := sinceTs
-		return func() (event string, data []byte, upd bool, err error) {
-			key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(lastCommentsScope)
-			data, err = s.cache.Get(key, func() ([]byte, error) {
-				comments, e := s.dataService.Last(siteID, 1, sinceTime, rest.GetUserOrEmpty(r))
+		return func(changed time.Time) (event string, data []byte, upd bool, err error) {
+			user := rest.GetUserOrEmpty(r)
+			key := cache.NewKey(siteID).ID("stream-last:" + user.ID).Scopes(lastCommentsScope)
+			data, err = s.streamData(key, "last:"+siteID+":"+user.ID, changed, func() ([]byte, error) {
+				comments, e := s.dataService.Last(siteID, 1, time.Time{}, user)
 				if e != nil {
 					return nil, e
 				}
-				if len(comments) > 0 {
-					sinceTime = comments[0].Timestamp
-					upd = true
-				}
-				sinceTime = time.Now()
 				return encodeJSONWithHTML(comments)
 			})
-			return "last", data, upd, err
+			if err != nil {
+				return "last", data, false, err
+			}
+
+			// the latest comment of the site shared by all streams, sent if made after the previous one
+			comments := []store.Comment{}
+			if err = json.Unmarshal(data, &comments); err != nil {
+				return "last", nil, false, errors.Wrap(err, "can't decode last comments")
+			}
+			if len(comments) > 0 && comments[0].Timestamp.After(sinceTime) {
+				sinceTime = comments[0].Timestamp
+				upd = true
+			}
+			return "last", data, upd, nil
 		}
 	}
 
-	if e := s.streamer.Activate(r.Context(), fn, w); e != nil {
+	if e := s.streamer.ActivateFor(r.Context(), store.Locator{SiteID: siteID}, fn, w); e != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, e, "can't stream", rest.ErrInternal)
 	}
 }
 
+// GET /stream/ws?site=siteID&url=post-url - websocket with create, update, delete and vote events for the site,
+// limited to the post if url defined
+func (s *public) wsCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	log.Printf("[DEBUG] start websocket for %+v", locator)
+
+	if s.streamer.Hub == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotImplemented, errors.New("live updates disabled"),
+			"can't open websocket", rest.ErrActionRejected)
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("not a websocket request"),
+			"can't open websocket", rest.ErrActionRejected)
+		return
+	}
+	if e := s.streamer.ActivateWS(r.Context(), w, r, locator); e != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, e, "can't open websocket", rest.ErrInternal)
+	}
+}
+
 // GET /id/{id}?site=siteID&url=post-url - gets a comment by id
 func (s *public) commentByIDCtrl(w http.ResponseWriter, r *http.Request) {
 
@@ -423,6 +459,21 @@ func (s *public) listCtrl(w http.ResponseWriter, r *http.Request) {
 // GET /picture/{user}/{id} - get picture
 func (s *public) loadPictureCtrl(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "user") + "/" + chi.URLParam(r, "id")
+	if wp := r.URL.Query().Get("w"); wp != "" { // resized variant requested
+		width, err := strconv.Atoi(wp)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "bad width", rest.ErrDecode)
+			return
+		}
+		if id, err = s.imageService.Variant(id, width); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get image variant", rest.ErrAssetNotFound)
+			return
+		}
+	}
+	if u, ok := s.imageService.SignedURL(id); ok {
+		http.Redirect(w, r, u, http.StatusFound) // direct link to the store
+		return
+	}
 	img, err := s.imageService.Load(id)
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get image "+id, rest.ErrAssetNotFound)
@@ -482,6 +533,15 @@ func (s *public) applyView(comments []store.Comment, view string) []store.Commen
 	return comments
 }
 
+// streamData gets stream's data from cache for polling streams. Pushed updates can come before the cache
+// flushed by the change, so data loaded once per change by streamer and shared by streams of streamKey.
+func (s *public) streamData(key cache.Key, streamKey string, changed time.Time, fn func() ([]byte, error)) ([]byte, error) {
+	if s.streamer.Hub != nil {
+		return s.streamer.Load(streamKey, changed, fn)
+	}
+	return s.cache.Get(key, fn)
+}
+
 func (s *public) parseSince(r *http.Request) (time.Time, error) {
 	sinceTs := time.Time{}
 	if since := r.URL.Query().Get("since"); since != "" {
@@ -14,10 +14,12 @@ import (
 
 	cache "github.com/go-pkgz/lcw"
 	R "github.com/go-pkgz/rest"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/pubsub"
 	"github.com/umputun/remark/backend/app/store/service"
 )
 
@@ -608,6 +610,111 @@ func TestRest_InfoStream(t *testing.T) {
 	assert.Equal(t, 500, code)
 }
 
+func TestRest_InfoStreamPushed(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	hub := pubsub.NewHub(nil)
+	srv.DataService.PubSub = hub
+	srv.pubRest.readOnlyAge = 10000000 // make sure we don't hit read-only
+	srv.pubRest.streamer.Hub = hub
+	srv.pubRest.streamer.Refresh = time.Hour // polling should not be used
+	srv.pubRest.streamer.TimeOut = 500 * time.Millisecond
+
+	postComment(t, ts.URL)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			time.Sleep(50 * time.Millisecond)
+			postComment(t, ts.URL)
+		}
+	}()
+
+	st := time.Now()
+	body, code := get(t, ts.URL+"/api/v1/stream/info?site=remark42&url=https://radio-t.com/blah1")
+	assert.Equal(t, 200, code)
+	<-done
+	assert.True(t, time.Since(st) < 2*time.Second)
+
+	recs := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	require.Equal(t, 5*3, len(recs), "5 records, each 2 lines +1 empty line")
+	assert.True(t, strings.Contains(recs[0+1], `"count":2`), recs[0])
+	assert.True(t, strings.Contains(recs[4*3+1], `"count":6`), recs[4])
+
+	_, code = get(t, ts.URL+"/api/v1/stream/info?site=remark42&url=https://radio-t.com/blah123")
+	assert.Equal(t, 500, code)
+}
+
+func TestRest_LastCommentsStreamPushed(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	hub := pubsub.NewHub(nil)
+	srv.DataService.PubSub = hub
+	srv.pubRest.streamer.Hub = hub
+	srv.pubRest.streamer.Refresh = time.Hour
+	srv.pubRest.streamer.TimeOut = 500 * time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			time.Sleep(50 * time.Millisecond)
+			postComment(t, ts.URL)
+		}
+	}()
+
+	body, code := get(t, ts.URL+"/api/v1/stream/last?site=remark42")
+	assert.Equal(t, 200, code)
+	<-done
+
+	recs := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	require.Equal(t, 3*3, len(recs), "3 records, each 2 lines +1 empty line")
+	assert.Equal(t, "event: last", recs[0])
+	assert.True(t, strings.Contains(recs[1], `"orig":"test 123"`), recs[1])
+}
+
+func TestRest_Websocket(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	hub := pubsub.NewHub(nil)
+	srv.DataService.PubSub = hub
+	srv.pubRest.streamer.Hub = hub
+
+	wsURL := strings.Replace(ts.URL, "http://", "ws://", 1) + "/api/v1/stream/ws?site=remark42&url=https://radio-t.com/blah1"
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	connOther, _, err := websocket.DefaultDialer.Dial(strings.Replace(wsURL, "blah1", "blah2", 1), nil)
+	require.NoError(t, err)
+	defer connOther.Close()
+
+	postComment(t, ts.URL)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	event := pubsub.Event{}
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, pubsub.EventCreate, event.Type)
+	assert.Equal(t, "https://radio-t.com/blah1", event.Locator.URL)
+	require.NotNil(t, event.Comment)
+	assert.Equal(t, "<p>test 123</p>\n", event.Comment.Text)
+	assert.Equal(t, "", event.Comment.User.IP)
+
+	require.NoError(t, connOther.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, _, err = connOther.ReadMessage()
+	assert.Error(t, err, "no events for another post")
+
+	// not a websocket request
+	_, code := get(t, ts.URL+"/api/v1/stream/ws?site=remark42")
+	assert.Equal(t, 400, code)
+
+	srv.pubRest.streamer.Hub = nil
+	_, code = get(t, ts.URL+"/api/v1/stream/ws?site=remark42")
+	assert.Equal(t, 501, code)
+}
+
 func TestRest_InfoStreamTooMany(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -31,8 +31,10 @@ import (
 	"github.com/umputun/remark/backend/app/notify"
 	"github.com/umputun/remark/backend/app/rest"
 	"github.com/umputun/remark/backend/app/rest/proxy"
+	"github.com/umputun/remark/backend/app/rest/ratelimit"
 	"github.com/umputun/remark/backend/app/store"
 	adminstore "github.com/umputun/remark/backend/app/store/admin"
+	"github.com/umputun/remark/backend/app/store/changelog"
 	"github.com/umputun/remark/backend/app/store/engine"
 	"github.com/umputun/remark/backend/app/store/image"
 	"github.com/umputun/remark/backend/app/store/service"
@@ -97,6 +99,44 @@ func TestRest_Shutdown(t *testing.T) {
 	<-done
 }
 
+func TestRest_RateLimiter(t *testing.T) {
+	_, srv, teardown := startupT(t)
+	defer teardown()
+
+	srv.RateLimiter = &ratelimit.Limiter{Store: ratelimit.NewMemoryStore(), Rules: []ratelimit.Rule{
+		{Method: "GET", Path: "/api/v1/find", Rate: ratelimit.Rate{Limit: 2, Period: time.Minute}, By: ratelimit.ByIP},
+		{Method: "POST", Path: "/api/v1/comment", Rate: ratelimit.Rate{Limit: 1, Period: time.Minute}, By: ratelimit.ByUser},
+	}}
+	ts := httptest.NewServer(srv.routes())
+	defer ts.Close()
+
+	for i := 0; i < 2; i++ {
+		_, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1")
+		assert.Equal(t, http.StatusOK, code)
+	}
+	resp, err := http.Get(ts.URL + "/api/v1/find?site=remark42&url=https://radio-t.com/blah1")
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "2", resp.Header.Get("X-RateLimit-Limit"))
+
+	// not matched by rules, limited with default rate of the group
+	_, code := get(t, ts.URL+"/api/v1/last/10?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+
+	// per-user rule applied to authenticated user
+	addComment(t, store.Comment{Text: "test 123", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}, ts)
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/comment",
+		strings.NewReader(`{"text": "test 456", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+}
+
 func TestRest_filterComments(t *testing.T) {
 	user := store.User{ID: "user1", Name: "user name 1"}
 	c1 := store.Comment{User: user, Text: "test test #1", Locator: store.Locator{SiteID: "radio-t",
@@ -359,6 +399,7 @@ func startupT(t *testing.T) (ts *httptest.Server, srv *Rest, teardown func()) {
 		AdminStore:             astore,
 		MaxVotes:               service.UnlimitedVotes,
 		RestrictedWordsMatcher: restrictedWordsMatcher,
+		ChangeLog:              changelog.NewService(changelog.NewMemoryStorage(), 0),
 	}
 
 	srv = &Rest{
@@ -386,9 +427,16 @@ func startupT(t *testing.T) (ts *httptest.Server, srv *Rest, teardown func()) {
 		Migrator: &Migrator{
 			DisqusImporter:    &migrator.Disqus{DataStore: dataStore},
 			WordPressImporter: &migrator.WordPress{DataStore: dataStore},
+			CommentoImporter:  &migrator.Commento{DataStore: dataStore},
+			IssoImporter:      &migrator.Isso{DataStore: dataStore},
 			NativeImporter:    &migrator.Native{DataStore: dataStore},
 			NativeExporter:    &migrator.Native{DataStore: dataStore},
+			DisqusExporter:    &migrator.Disqus{DataStore: dataStore},
+			WordPressExporter: &migrator.WordPress{DataStore: dataStore},
+			ArchiveImporter:   &migrator.Archive{DataStore: dataStore},
+			ArchiveExporter:   &migrator.Archive{DataStore: dataStore},
 			UrlMapperMaker:    migrator.NewUrlMapper,
+			DataStore:         dataStore,
 			Cache:             memCache,
 			KeyStore:          astore,
 		},
@@ -397,6 +445,7 @@ func startupT(t *testing.T) (ts *httptest.Server, srv *Rest, teardown func()) {
 			TimeOut:   5 * time.Second,
 			MaxActive: 100,
 		},
+		ChangeLog:     dataStore.ChangeLog,
 		NotifyService: notify.NopService,
 		EmojiEnabled:  true,
 	}
@@ -5,23 +5,59 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
+	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/pubsub"
 )
 
-// Streamer creates endless stream of \n separated json records send to remote client
+// Streamer creates endless stream of \n separated json records send to remote client.
+// With Hub defined updates pushed on published events, otherwise made by polling with Refresh interval.
 type Streamer struct {
 	TimeOut     time.Duration
 	Refresh     time.Duration
 	MaxActive   int32
+	Hub         *pubsub.Hub
 	activeCount int32
+
+	loadsLock sync.Mutex
+	loads     map[string]*streamLoad // shared loads of streams data, by key
+}
+
+// streamLoad is data loaded for all streams of the same key
+type streamLoad struct {
+	started time.Time
+	done    chan struct{}
+	data    []byte
+	err     error
+}
+
+const (
+	wsPingInterval = 30 * time.Second // ping to keep connection alive and detect dead clients
+	wsWriteTimeout = 10 * time.Second
+	streamLoadTTL  = time.Minute // completed shared loads kept for streams lagging behind
+)
+
+// wsUpgrader allows any origin as comments embedded into third-party sites. Upgrade errors reported by caller.
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+	Error:           func(w http.ResponseWriter, r *http.Request, status int, reason error) {},
 }
 
 type steamEventFn func() (event string, data []byte, upd bool, err error)
 
+// hubEventFn is steamEventFn for pushed updates, changed is the time event received, data loaded after it
+// has the change.
+type hubEventFn func(changed time.Time) (event string, data []byte, upd bool, err error)
+
 type steamEventResp struct {
 	data  []byte
 	event string
@@ -32,8 +68,118 @@ type steamEventResp struct {
 // canceled on context or inactivity timeout
 // note: eventFn is a closure needed to allow state management inside eventFn
 func (s *Streamer) Activate(ctx context.Context, eventFn func() steamEventFn, w io.Writer) error {
-	updCh := s.eventsCh(ctx, eventFn())
+	return s.activate(ctx, s.eventsCh(ctx, eventFn()), w)
+}
+
+// ActivateFor is Activate with updates pushed by Hub. eventFn called once on start and on each event
+// published for the site and post (any post if url empty). Falls back to polling if Hub not defined.
+func (s *Streamer) ActivateFor(ctx context.Context, locator store.Locator, eventFn func() hubEventFn, w io.Writer) error {
+	if s.Hub == nil {
+		pollFn := func() steamEventFn {
+			fn := eventFn()
+			return func() (event string, data []byte, upd bool, err error) { return fn(time.Now()) }
+		}
+		return s.Activate(ctx, pollFn, w)
+	}
+	return s.activate(ctx, s.hubEventsCh(ctx, locator, eventFn()), w)
+}
+
+// Load returns data of streams with the same key, loaded once per change and shared by all of them.
+// Load started after changed time reused, otherwise data loaded by fn.
+func (s *Streamer) Load(key string, changed time.Time, fn func() ([]byte, error)) ([]byte, error) {
+	s.loadsLock.Lock()
+	ld, ok := s.loads[key]
+	if ok && !ld.started.Before(changed) {
+		s.loadsLock.Unlock()
+		<-ld.done
+		return ld.data, ld.err
+	}
+
+	if s.loads == nil {
+		s.loads = map[string]*streamLoad{}
+	}
+	for k, l := range s.loads { // drop loads of streams gone
+		select {
+		case <-l.done:
+			if time.Since(l.started) > streamLoadTTL {
+				delete(s.loads, k)
+			}
+		default:
+		}
+	}
+	ld = &streamLoad{started: time.Now(), done: make(chan struct{})}
+	s.loads[key] = ld
+	s.loadsLock.Unlock()
+
+	ld.data, ld.err = fn()
+	close(ld.done)
+	return ld.data, ld.err
+}
+
+// ActivateWS upgrades connection to websocket and sends json events published for the site and post
+// (any post if url empty), till remote client closed connection or context canceled.
+// Returns error only if connection not upgraded, caller responsible for the error response.
+func (s *Streamer) ActivateWS(ctx context.Context, w http.ResponseWriter, r *http.Request, locator store.Locator) error {
+	if s.Hub == nil {
+		return errors.New("no events hub")
+	}
+	count := atomic.AddInt32(&s.activeCount, 1)
+	defer atomic.AddInt32(&s.activeCount, -1)
+	if count > s.MaxActive {
+		return errors.New("too many streams")
+	}
+
+	// subscribe before upgrade, so client gets all events made after connection established
+	sub := s.Hub.Subscribe(locator.SiteID, locator.URL)
+	defer s.Hub.Unsubscribe(sub)
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return errors.Wrap(err, "can't upgrade to websocket")
+	}
+	defer conn.Close()
+
+	// incoming messages ignored, reading needed to process control frames and detect closed connection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, e := conn.NextReader(); e != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[DEBUG] websocket closed, %s", ctx.Err())
+			return nil
+		case <-closed:
+			log.Printf("[DEBUG] websocket closed by remote client")
+			return nil
+		case <-ping.C:
+			if e := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); e != nil {
+				log.Printf("[DEBUG] websocket ping failed, %v", e)
+				return nil
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if e := conn.WriteJSON(event); e != nil {
+				log.Printf("[DEBUG] send to websocket failed, %v", e)
+				return nil
+			}
+		}
+	}
+}
 
+// activate streams updates from updCh to the writer
+func (s *Streamer) activate(ctx context.Context, updCh <-chan steamEventResp, w io.Writer) error {
 	count := atomic.AddInt32(&s.activeCount, 1)
 	defer atomic.AddInt32(&s.activeCount, -1)
 	if count > s.MaxActive {
@@ -101,3 +247,47 @@ func (s *Streamer) eventsCh(ctx context.Context, fn steamEventFn) <-chan steamEv
 	}()
 	return ch
 }
+
+// populate updates to chan on each published event, break on context close
+func (s *Streamer) hubEventsCh(ctx context.Context, locator store.Locator, fn hubEventFn) <-chan steamEventResp {
+	ch := make(chan steamEventResp)
+	sub := s.Hub.Subscribe(locator.SiteID, locator.URL)
+	go func() {
+		defer func() {
+			s.Hub.Unsubscribe(sub)
+			close(ch)
+		}()
+
+		trigger := func() bool {
+			event, resp, upd, err := fn(time.Now())
+			if err != nil {
+				upd, resp = true, nil
+				err = errors.Wrap(err, "can't get stream data")
+			}
+			if !upd {
+				return true
+			}
+			select {
+			case ch <- steamEventResp{event: event, data: resp, err: err}:
+				return err == nil
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if !trigger() { // initial call, sets up fn's state
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done(): // request closed by remote client
+				return
+			case _, ok := <-sub.C:
+				if !ok || !trigger() {
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
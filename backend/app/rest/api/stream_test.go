@@ -4,10 +4,15 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/pubsub"
 )
 
 func TestStream_Timeout(t *testing.T) {
@@ -59,3 +64,76 @@ func TestStream_Cancel(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "event: test\ndata: some data 1\n\nevent: test\ndata: some data 3\n\nevent: test\ndata: some data 5\n\nevent: test\ndata: some data 7\n\nevent: test\ndata: some data 9\n\n", buf.String())
 }
+
+func TestStream_ActivateForHub(t *testing.T) {
+	hub := pubsub.NewHub(nil)
+	s := Streamer{
+		Refresh:   time.Hour, // no polling with hub
+		TimeOut:   200 * time.Millisecond,
+		MaxActive: 10,
+		Hub:       hub,
+	}
+
+	calls := int32(0)
+	eventFn := func() hubEventFn {
+		return func(time.Time) (event string, data []byte, upd bool, err error) {
+			n := atomic.AddInt32(&calls, 1)
+			if n == 1 { // initial call
+				return "test", nil, false, nil
+			}
+			return "test", []byte(fmt.Sprintf("some data %d\n", n)), true, nil
+		}
+	}
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(50 * time.Millisecond)
+			hub.Publish(pubsub.Event{Type: pubsub.EventCreate, Locator: store.Locator{SiteID: "site", URL: "url1"}})
+			hub.Publish(pubsub.Event{Type: pubsub.EventCreate, Locator: store.Locator{SiteID: "site", URL: "url2"}})
+		}
+	}()
+
+	buf := bytes.Buffer{}
+	err := s.ActivateFor(context.Background(), store.Locator{SiteID: "site", URL: "url1"}, eventFn, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "event: test\ndata: some data 2\n\nevent: test\ndata: some data 3\n\nevent: test\ndata: some data 4\n\n", buf.String())
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls), "initial call and one per event for the post")
+}
+
+func TestStream_Load(t *testing.T) {
+	s := Streamer{}
+	calls := int32(0)
+	load := func() ([]byte, error) {
+		n := atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return []byte(fmt.Sprintf("data %d", n)), nil
+	}
+
+	// streams got the same change share one load
+	changed := time.Now()
+	var wg sync.WaitGroup
+	res := make([]string, 5)
+	for i := range res {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, err := s.Load("key", changed, load)
+			assert.NoError(t, err)
+			res[i] = string(data)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, r := range res {
+		assert.Equal(t, "data 1", r)
+	}
+
+	// load started before the change not reused
+	data, err := s.Load("key", time.Now(), load)
+	assert.NoError(t, err)
+	assert.Equal(t, "data 2", string(data))
+
+	data, err = s.Load("key2", changed, load)
+	assert.NoError(t, err)
+	assert.Equal(t, "data 3", string(data), "loaded by key")
+}
@@ -36,6 +36,14 @@ const (
 	ErrVoteMinScore       = 16 // min score reached for the comment
 	ErrActionRejected     = 17 // general error for rejected actions
 	ErrAssetNotFound      = 18 // requested file not found
+	ErrSlowMode           = 19 // too frequent comments on the post
+	ErrDailyQuota         = 20 // daily comments quota exceeded
+	ErrLinksNotAllowed    = 21 // links not allowed for new unverified user
+	ErrTooManyLinks       = 22 // too many links in comment
+	ErrDuplicateComment   = 23 // the same comment already posted
+	ErrUserImagesQuota    = 24 // user's images quota exceeded
+	ErrSiteImagesQuota    = 25 // site's images quota exceeded
+	ErrDailyImages        = 26 // daily images limit exceeded
 )
 
 const errorHtml = `<!DOCTYPE html>
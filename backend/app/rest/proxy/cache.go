@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark/backend/app/store/image"
+)
+
+const cachedImagesPrefix = "cached_images/"
+
+// ImageCache keeps index of external images cached by proxy in the image store. Removes images not requested
+// for TTL and least recently used images above size limits. Index restored from the store on first use,
+// with commit time of the image as the last access. Nil ImageCache keeps cached images forever.
+type ImageCache struct {
+	ImageService *image.Service
+	TTL          time.Duration // remove images not requested for this time, never if 0
+	MaxSize      int64         // max total size of cached images, unlimited if 0
+	MaxItems     int           // max number of cached images, unlimited if 0
+
+	once  sync.Once
+	lock  sync.Mutex
+	lru   *list.List // of *cacheEntry, most recently used in front
+	items map[string]*list.Element
+	size  int64
+}
+
+type cacheEntry struct {
+	id       string
+	size     int64
+	accessed time.Time
+}
+
+// Touch marks cached image as used. Returns false if image expired and should be downloaded again
+func (c *ImageCache) Touch(id string, size int64) bool {
+	if c == nil {
+		return true
+	}
+	c.init()
+	c.lock.Lock()
+	if el, ok := c.items[id]; ok && c.expired(el.Value.(*cacheEntry)) {
+		c.lock.Unlock()
+		return false
+	}
+	evicted := c.put(id, size)
+	c.lock.Unlock()
+	c.remove(evicted)
+	return true
+}
+
+// Add puts newly cached image to the index, removes least recently used images if limits exceeded
+func (c *ImageCache) Add(id string, size int64) {
+	if c == nil {
+		return
+	}
+	c.init()
+	c.lock.Lock()
+	evicted := c.put(id, size)
+	c.lock.Unlock()
+	c.remove(evicted)
+}
+
+// Cleanup removes expired images
+func (c *ImageCache) Cleanup() {
+	if c == nil || c.TTL <= 0 {
+		return
+	}
+	c.init()
+	c.lock.Lock()
+	var expired []string
+	for el := c.lru.Back(); el != nil && c.expired(el.Value.(*cacheEntry)); el = c.lru.Back() {
+		expired = append(expired, c.drop(el))
+	}
+	c.lock.Unlock()
+	c.remove(expired)
+}
+
+// Run removes expired images periodically. Blocking loop, should be called inside of goroutine by consumer
+func (c *ImageCache) Run(ctx context.Context, interval time.Duration) {
+	log.Printf("[INFO] start cached images cleanup, ttl=%v, max size=%d, max items=%d", c.TTL, c.MaxSize, c.MaxItems)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] cached images cleanup terminated, %v", ctx.Err())
+			return
+		case <-time.After(interval):
+			c.Cleanup()
+		}
+	}
+}
+
+// Stats returns number and total size of cached images
+func (c *ImageCache) Stats() (count int, size int64) {
+	c.init()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len(), c.size
+}
+
+// init loads index of cached images from the store
+func (c *ImageCache) init() {
+	c.once.Do(func() {
+		c.lru = list.New()
+		c.items = map[string]*list.Element{}
+		images, err := c.ImageService.List(context.Background())
+		if err != nil {
+			log.Printf("[WARN] can't load cached images, only new images will be evicted, %v", err)
+			return
+		}
+		sort.Slice(images, func(i, j int) bool { return images[i].Modified.Before(images[j].Modified) })
+		for _, img := range images {
+			if !strings.HasPrefix(img.ID, cachedImagesPrefix) {
+				continue
+			}
+			c.items[img.ID] = c.lru.PushFront(&cacheEntry{id: img.ID, size: img.Size, accessed: img.Modified})
+			c.size += img.Size
+		}
+		log.Printf("[DEBUG] loaded %d cached images, size=%d", c.lru.Len(), c.size)
+	})
+}
+
+// put adds or refreshes entry and returns ids evicted by limits, should be called under lock
+func (c *ImageCache) put(id string, size int64) (evicted []string) {
+	if el, ok := c.items[id]; ok {
+		c.drop(el)
+	}
+	c.items[id] = c.lru.PushFront(&cacheEntry{id: id, size: size, accessed: time.Now()})
+	c.size += size
+
+	for c.lru.Len() > 1 && ((c.MaxSize > 0 && c.size > c.MaxSize) || (c.MaxItems > 0 && c.lru.Len() > c.MaxItems)) {
+		evicted = append(evicted, c.drop(c.lru.Back()))
+	}
+	return evicted
+}
+
+// drop removes entry from the index, should be called under lock
+func (c *ImageCache) drop(el *list.Element) string {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.items, e.id)
+	c.size -= e.size
+	return e.id
+}
+
+func (c *ImageCache) expired(e *cacheEntry) bool {
+	return c.TTL > 0 && time.Since(e.accessed) > c.TTL
+}
+
+// remove deletes images from the store
+func (c *ImageCache) remove(ids []string) {
+	for _, id := range ids {
+		if err := c.ImageService.Delete(id); err != nil {
+			log.Printf("[WARN] can't remove cached image %s, %v", id, err)
+			continue
+		}
+		log.Printf("[DEBUG] cached image %s removed", id)
+	}
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/store/image"
+)
+
+func TestImageCache_Evict(t *testing.T) {
+	svc, store, teardown := prepImageCacheTest(t)
+	defer teardown()
+	save := func(id string, age time.Duration) {
+		_, err := store.SaveWithID(id, []byte("12345"))
+		require.NoError(t, err)
+		require.NoError(t, store.Commit(id))
+		ts := time.Now().Add(-age)
+		require.NoError(t, os.Chtimes(store.Location+"/"+id, ts, ts))
+	}
+	save("cached_images/old", 3*time.Hour)
+	save("cached_images/older", 4*time.Hour)
+	save("cached_images/recent", time.Minute)
+	save("user1/pic", 5*time.Hour) // not cached image, ignored
+
+	c := &ImageCache{ImageService: svc, TTL: 2 * time.Hour, MaxItems: 3}
+	count, size := c.Stats()
+	assert.Equal(t, 3, count, "loaded from store")
+	assert.Equal(t, int64(15), size)
+
+	assert.False(t, c.Touch("cached_images/old", 5), "expired")
+	assert.True(t, c.Touch("cached_images/recent", 5))
+
+	c.Cleanup()
+	count, _ = c.Stats()
+	assert.Equal(t, 1, count)
+	_, err := svc.Load("cached_images/old")
+	assert.Error(t, err, "expired removed")
+	_, err = svc.Load("cached_images/older")
+	assert.Error(t, err, "expired removed")
+	_, err = svc.Load("user1/pic")
+	assert.NoError(t, err)
+
+	save("cached_images/new1", 0)
+	c.Add("cached_images/new1", 5)
+	save("cached_images/new2", 0)
+	c.Add("cached_images/new2", 5)
+	assert.True(t, c.Touch("cached_images/recent", 5), "recent is most recently used")
+	save("cached_images/new3", 0)
+	c.Add("cached_images/new3", 5)
+	count, size = c.Stats()
+	assert.Equal(t, 3, count)
+	assert.Equal(t, int64(15), size)
+	_, err = svc.Load("cached_images/new1")
+	assert.Error(t, err, "least recently used evicted")
+	_, err = svc.Load("cached_images/recent")
+	assert.NoError(t, err)
+
+	c.MaxSize = 10
+	save("cached_images/new4", 0)
+	c.Add("cached_images/new4", 5)
+	count, size = c.Stats()
+	assert.Equal(t, 2, count)
+	assert.Equal(t, int64(10), size)
+}
+
+func TestImageCache_Nil(t *testing.T) {
+	var c *ImageCache
+	assert.True(t, c.Touch("cached_images/img", 5))
+	c.Add("cached_images/img", 5)
+	c.Cleanup()
+}
+
+func TestImage_RoutesCacheExpired(t *testing.T) {
+	svc, _, teardown := prepImageCacheTest(t)
+	defer teardown()
+	imgCache := &ImageCache{ImageService: svc, TTL: time.Hour}
+	img := Image{CacheExternal: true, RemarkURL: "https://demo.remark42.com", RoutePath: "/api/v1/proxy",
+		ImageService: svc, Fetcher: Fetcher{AllowPrivate: true}, Cache: imgCache}
+
+	httpSrv := imgHTTPTestsServer(t)
+	defer httpSrv.Close()
+	req := httptest.NewRequest(http.MethodGet, "/?src="+base64.URLEncoding.EncodeToString([]byte(httpSrv.URL+"/image/img1.png")), nil)
+	get := func() int {
+		rr := httptest.NewRecorder()
+		img.Handler(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get())
+	count, size := imgCache.Stats()
+	assert.Equal(t, 1, count)
+	assert.Equal(t, int64(1462), size)
+
+	img.Fetcher.AllowPrivate = false
+	assert.Equal(t, http.StatusOK, get(), "served from cache")
+
+	imgCache.TTL = time.Nanosecond
+	assert.Equal(t, http.StatusForbidden, get(), "expired, download rejected")
+}
+
+func prepImageCacheTest(t *testing.T) (svc *image.Service, store *image.FileSystem, teardown func()) {
+	loc, err := ioutil.TempDir("", "test_image_cache")
+	require.NoError(t, err)
+	store = &image.FileSystem{Location: loc + "/images", Staging: loc + "/staging"} // no partitions, image file at location/id
+	svc = image.NewService(store, image.ServiceParams{MaxSize: 10000, TTL: time.Millisecond})
+	return svc, store, func() { _ = os.RemoveAll(loc) }
+}
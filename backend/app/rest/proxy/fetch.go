@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned by Fetcher for rejected requests and responses, not worth retrying
+var (
+	ErrBlockedAddress   = errors.New("address not allowed")
+	ErrTooLarge         = errors.New("response too large")
+	ErrContentType      = errors.New("content type not allowed")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrSchemeNotAllowed = errors.New("url scheme not allowed")
+	errUnexpectedStatus = errors.New("unexpected response status")
+)
+
+const (
+	defaultFetchMaxSize   = int64(5000000)
+	defaultFetchTimeout   = 30 * time.Second
+	defaultFetchRedirects = 5
+)
+
+// blockedNets are internal and special-purpose networks, external content never fetched from them
+var blockedNets = parseCIDRs(
+	"0.0.0.0/8",      // "this" network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade nat
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local, cloud metadata services
+	"172.16.0.0/12",  // private
+	"192.0.0.0/24",   // ietf protocol assignments
+	"192.168.0.0/16", // private
+	"198.18.0.0/15",  // benchmarking
+	"224.0.0.0/4",    // multicast
+	"240.0.0.0/4",    // reserved and broadcast
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b::/96",   // nat64, embeds ipv4 address
+	"2002::/16",      // 6to4, embeds ipv4 address
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"ff00::/8",       // multicast
+)
+
+// Fetcher downloads external resources referenced by comments. Requests to internal addresses rejected on every
+// connection, including redirects and hostnames resolved to such addresses, responses limited by size and content type.
+type Fetcher struct {
+	Timeout      time.Duration // timeout of a single request, 30s by default
+	MaxSize      int64         // max size of response body, 5M by default
+	MaxRedirects int           // max number of followed redirects, 5 by default
+	ContentTypes []string      // allowed prefixes of response content type, any if empty
+	AllowPrivate bool          // allow internal addresses, for tests and trusted networks only
+}
+
+// Fetch gets the body of resource by url. Returns the body and its content type
+func (f Fetcher) Fetch(ctx context.Context, rawURL string) (body []byte, contentType string, err error) {
+	if err = checkScheme(rawURL); err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to make request for %s", rawURL)
+	}
+	resp, err := f.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.Wrapf(errUnexpectedStatus, "got unsuccessful response status %d while fetching %s",
+			resp.StatusCode, rawURL)
+	}
+	contentType = resp.Header.Get("Content-Type")
+	if !f.allowedType(contentType) {
+		return nil, "", errors.Wrapf(ErrContentType, "%q from %s", contentType, rawURL)
+	}
+	maxSize := f.maxSize()
+	if resp.ContentLength > maxSize {
+		return nil, "", errors.Wrapf(ErrTooLarge, "%d bytes from %s", resp.ContentLength, rawURL)
+	}
+	body, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "unable to read body of %s", rawURL)
+	}
+	if int64(len(body)) > maxSize {
+		return nil, "", errors.Wrapf(ErrTooLarge, "more than %d bytes from %s", maxSize, rawURL)
+	}
+	return body, contentType, nil
+}
+
+// client makes http client checking every dialed address and redirect
+func (f Fetcher) client() *http.Client {
+	timeout := defaultFetchTimeout
+	if f.Timeout > 0 {
+		timeout = f.Timeout
+	}
+	maxRedirects := defaultFetchRedirects
+	if f.MaxRedirects > 0 {
+		maxRedirects = f.MaxRedirects
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	if !f.AllowPrivate {
+		// address checked after dns resolution, so hostnames pointing to internal addresses can't bypass it
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicIP(net.ParseIP(host)) {
+				return errors.Wrapf(ErrBlockedAddress, "%s", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil, // environment proxy would hide the real address from the check
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			DisableKeepAlives:     true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return ErrTooManyRedirects
+			}
+			return checkScheme(req.URL.String())
+		},
+	}
+}
+
+func (f Fetcher) maxSize() int64 {
+	if f.MaxSize > 0 {
+		return f.MaxSize
+	}
+	return defaultFetchMaxSize
+}
+
+func (f Fetcher) allowedType(contentType string) bool {
+	if len(f.ContentTypes) == 0 {
+		return true
+	}
+	contentType = strings.ToLower(contentType)
+	for _, t := range f.ContentTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPermanent checks if fetch error caused by rejection and retry makes no sense
+func isPermanent(err error) bool {
+	for _, e := range []error{ErrBlockedAddress, ErrTooLarge, ErrContentType, ErrTooManyRedirects,
+		ErrSchemeNotAllowed, errUnexpectedStatus} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkScheme(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.Wrapf(err, "can't parse url %s", rawURL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Wrapf(ErrSchemeNotAllowed, "%q", u.Scheme)
+	}
+	return nil
+}
+
+// isPublicIP checks if ip is a routable public address
+func isPublicIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	res := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		res = append(res, n)
+	}
+	return res
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetcher_Fetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/img.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(gopherPNGBytes())
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+		case "/chunked.png": // no content length
+			w.Header().Set("Content-Type", "image/png")
+			for i := 0; i < 10; i++ {
+				_, _ = w.Write(gopherPNGBytes())
+				w.(http.Flusher).Flush()
+			}
+		case "/redirect":
+			n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+			if n == 0 {
+				http.Redirect(w, r, "/img.png", http.StatusFound)
+				return
+			}
+			http.Redirect(w, r, "/redirect?n="+strconv.Itoa(n-1), http.StatusFound)
+		case "/redirect-ftp":
+			http.Redirect(w, r, "ftp://example.com/img.png", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	f := Fetcher{AllowPrivate: true, ContentTypes: []string{"image/"}, MaxSize: 5000, MaxRedirects: 3}
+	ctx := context.Background()
+
+	body, ct, err := f.Fetch(ctx, ts.URL+"/img.png")
+	require.NoError(t, err)
+	assert.Equal(t, 1462, len(body))
+	assert.Equal(t, "image/png", ct)
+
+	_, _, err = f.Fetch(ctx, ts.URL+"/page.html")
+	assert.True(t, errors.Is(err, ErrContentType), err)
+
+	_, _, err = f.Fetch(ctx, ts.URL+"/chunked.png")
+	assert.True(t, errors.Is(err, ErrTooLarge), err)
+	f.MaxSize = 1000
+	_, _, err = f.Fetch(ctx, ts.URL+"/img.png")
+	assert.True(t, errors.Is(err, ErrTooLarge), "rejected by content length, %v", err)
+	f.MaxSize = 0
+
+	_, _, err = f.Fetch(ctx, ts.URL+"/redirect?n=2")
+	assert.NoError(t, err)
+	_, _, err = f.Fetch(ctx, ts.URL+"/redirect?n=3")
+	assert.True(t, errors.Is(err, ErrTooManyRedirects), err)
+	_, _, err = f.Fetch(ctx, ts.URL+"/redirect-ftp")
+	assert.True(t, errors.Is(err, ErrSchemeNotAllowed), err)
+	_, _, err = f.Fetch(ctx, "file:///etc/passwd")
+	assert.True(t, errors.Is(err, ErrSchemeNotAllowed), err)
+
+	_, _, err = f.Fetch(ctx, ts.URL+"/not-found.png")
+	assert.True(t, isPermanent(err), err)
+
+	f.AllowPrivate = false
+	_, _, err = f.Fetch(ctx, ts.URL+"/img.png")
+	assert.True(t, errors.Is(err, ErrBlockedAddress), err)
+	assert.True(t, isPermanent(err))
+	_, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	require.NoError(t, err)
+	_, _, err = f.Fetch(ctx, "http://localhost:"+port+"/img.png")
+	assert.True(t, errors.Is(err, ErrBlockedAddress), "hostname resolved to loopback, %v", err)
+}
+
+func TestFetcher_isPublicIP(t *testing.T) {
+	tbl := []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"93.184.216.34", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"172.32.0.1", true},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"::", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"64:ff9b::7f00:1", false},
+		{"", false},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.public, isPublicIP(net.ParseIP(tt.ip)), tt.ip)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
@@ -29,8 +28,10 @@ type Image struct {
 	RoutePath     string
 	HTTP2HTTPS    bool
 	CacheExternal bool
-	Timeout       time.Duration
+	Timeout       time.Duration // total time of download with retries, 60s by default
 	ImageService  *image.Service
+	Fetcher       Fetcher     // downloads external images, accepts image content types only
+	Cache         *ImageCache // expiration and eviction of cached external images, kept forever if nil
 }
 
 // Convert img src links to proxied links depends on enabled options
@@ -108,15 +109,23 @@ func (p Image) Handler(w http.ResponseWriter, r *http.Request) {
 	}
 	if p.CacheExternal {
 		img, _ = p.ImageService.Load(imgID)
+		if img != nil && !p.Cache.Touch(imgID, int64(len(img))) {
+			img = nil // expired, download fresh copy
+		}
 	}
 	if img == nil {
 		img, err = p.downloadImage(context.Background(), imgURL)
+		if errors.Is(err, ErrBlockedAddress) || errors.Is(err, ErrSchemeNotAllowed) {
+			rest.SendErrorJSON(w, r, http.StatusForbidden, err, "image url not allowed "+imgURL, rest.ErrAssetNotFound)
+			return
+		}
 		if err != nil {
 			rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get image "+imgURL, rest.ErrAssetNotFound)
 			return
 		}
 		if p.CacheExternal {
 			p.cacheImage(bytes.NewReader(img), imgID)
+			p.Cache.Add(imgID, int64(len(img)))
 		}
 	}
 
@@ -147,7 +156,7 @@ func (p Image) cacheImage(r io.Reader, imgID string) {
 	p.ImageService.Submit(func() []string { return []string{id} })
 }
 
-// download an image.
+// download an image, retrying on network errors
 func (p Image) downloadImage(ctx context.Context, imgURL string) ([]byte, error) {
 	log.Printf("[DEBUG] downloading image %s", imgURL)
 
@@ -159,28 +168,31 @@ func (p Image) downloadImage(ctx context.Context, imgURL string) ([]byte, error)
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	client := http.Client{Timeout: 30 * time.Second}
-	var resp *http.Response
+	fetcher := p.Fetcher
+	if len(fetcher.ContentTypes) == 0 {
+		fetcher.ContentTypes = []string{"image/"}
+	}
+	var imgData []byte
+	var rejectErr error
 	err := repeater.NewDefault(5, time.Second).Do(ctx, func() error {
-		var e error
-		req, e := http.NewRequest("GET", imgURL, nil)
-		if e != nil {
-			return errors.Wrapf(e, "failed to make request for %s", imgURL)
+		data, _, e := fetcher.Fetch(ctx, imgURL)
+		if e != nil && isPermanent(e) {
+			rejectErr = e // no retries for rejected requests
+			return nil
 		}
-		resp, e = client.Do(req.WithContext(ctx))
+		imgData = data
 		return e
 	})
+	if rejectErr != nil {
+		return nil, rejectErr
+	}
 	if err != nil {
 		return nil, errors.Wrapf(err, "can't download image %s", imgURL)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("got unsuccessful response status %d while fetching %s", resp.StatusCode, imgURL)
-	}
-
-	imgData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, errors.Errorf("unable to read image body")
+	// content type header can't be trusted, check the data itself
+	if !strings.HasPrefix(http.DetectContentType(imgData), "image/") {
+		return nil, errors.Wrapf(ErrContentType, "not an image in %s", imgURL)
 	}
 	return imgData, nil
 }
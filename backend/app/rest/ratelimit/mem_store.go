@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory implements Store with counters kept in memory, limits not shared between instances
+type Memory struct {
+	lock     sync.Mutex
+	counters map[string]*memCounter
+	lastGC   time.Time
+}
+
+type memCounter struct {
+	count   int64
+	expires time.Time
+}
+
+const memGCInterval = time.Minute
+
+// NewMemoryStore makes in-memory counters store
+func NewMemoryStore() *Memory {
+	return &Memory{counters: map[string]*memCounter{}, lastGC: time.Now()}
+}
+
+// Incr increments counter for the key in current window, returns new value and time left till the window end
+func (m *Memory) Incr(key string, window time.Duration) (count int64, ttl time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastGC) > memGCInterval { // remove expired counters from time to time
+		for k, c := range m.counters {
+			if !now.Before(c.expires) {
+				delete(m.counters, k)
+			}
+		}
+		m.lastGC = now
+	}
+
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.expires) {
+		c = &memCounter{expires: now.Add(window)}
+		m.counters[key] = c
+	}
+	c.count++
+	return c.count, c.expires.Sub(now), nil
+}
@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_Incr(t *testing.T) {
+	m := NewMemoryStore()
+	checkIncr(t, m)
+
+	// expired counters removed on gc
+	m.lastGC = time.Now().Add(-2 * memGCInterval)
+	_, _, err := m.Incr("other", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(m.counters), "expired key2 removed")
+	_, ok := m.counters["key2"]
+	assert.False(t, ok)
+}
+
+// checkIncr verifies store counts requests in window and resets counter after
+func checkIncr(t *testing.T, s Store) {
+	for i := 1; i <= 3; i++ {
+		count, ttl, err := s.Incr("key1", 200*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, int64(i), count)
+		assert.True(t, ttl > 0 && ttl <= 200*time.Millisecond, ttl)
+	}
+	count, _, err := s.Incr("key2", 200*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count, "separate counter")
+
+	time.Sleep(250 * time.Millisecond)
+	count, _, err = s.Incr("key1", 200*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count, "new window")
+}
@@ -0,0 +1,199 @@
+// Package ratelimit limits requests with fixed window counters. Counters kept in Store, in memory for a single
+// instance or in redis to share limits between instances. Limiter applies the first matching Rule, by method and path,
+// or default rate of the routes group. Requests counted per client ip or per user.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/rest"
+	"github.com/umputun/remark/backend/app/store"
+)
+
+// Store defines interface for counters storage
+type Store interface {
+	// Incr increments counter for the key in current window, returns new value and time left till the window end
+	Incr(key string, window time.Duration) (count int64, ttl time.Duration, err error)
+}
+
+// KeyType defines what requests counted together
+type KeyType string
+
+// enum of all key types
+const (
+	ByIP   KeyType = "ip"
+	ByUser KeyType = "user" // falls back to ip for anonymous requests
+)
+
+// Rate is a number of requests allowed in period
+type Rate struct {
+	Limit  int64
+	Period time.Duration
+}
+
+// PerSecond makes rate for given number of requests per second, fractions allowed.
+// Fractional rates above 1 counted over 10s window, i.e. 2.5/s becomes 25/10s
+func PerSecond(perSec float64) Rate {
+	if perSec >= 1 && perSec == math.Trunc(perSec) {
+		return Rate{Limit: int64(perSec), Period: time.Second}
+	}
+	if perSec > 1 {
+		return Rate{Limit: int64(math.Round(perSec * 10)), Period: 10 * time.Second}
+	}
+	return Rate{Limit: 1, Period: time.Duration(float64(time.Second) / perSec)}
+}
+
+// ParseRate parses rate in "limit/period" form, i.e. 10/s, 100/m, 5/10m or 1/2s
+func ParseRate(s string) (Rate, error) {
+	elems := strings.SplitN(s, "/", 2)
+	if len(elems) != 2 {
+		return Rate{}, errors.Errorf("invalid rate %q, should be limit/period", s)
+	}
+	limit, err := strconv.ParseInt(elems[0], 10, 64)
+	if err != nil || limit <= 0 {
+		return Rate{}, errors.Errorf("invalid limit in rate %q", s)
+	}
+	period := elems[1]
+	if period == "s" || period == "m" || period == "h" {
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Rate{}, errors.Errorf("invalid period in rate %q", s)
+	}
+	return Rate{Limit: limit, Period: d}, nil
+}
+
+// String returns rate in "limit/period" form
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.Limit, r.Period)
+}
+
+// Rule defines rate for requests matching method and path
+type Rule struct {
+	Method string // empty matches any method
+	Path   string // exact path or prefix if ends with *
+	Rate   Rate
+	By     KeyType
+}
+
+// ParseRule parses rule in "[METHOD] path limit/period [ip|user]" form, i.e. "POST /api/v1/comment 5/m user"
+func ParseRule(s string) (Rule, error) {
+	fields := strings.Fields(s)
+	res := Rule{By: ByIP}
+	if len(fields) > 0 && !strings.HasPrefix(fields[0], "/") && fields[0] != "*" {
+		res.Method, fields = strings.ToUpper(fields[0]), fields[1:]
+	}
+	if len(fields) < 2 || len(fields) > 3 {
+		return Rule{}, errors.Errorf("invalid rule %q, should be [METHOD] path limit/period [ip|user]", s)
+	}
+	res.Path = fields[0]
+	rate, err := ParseRate(fields[1])
+	if err != nil {
+		return Rule{}, errors.Wrapf(err, "invalid rule %q", s)
+	}
+	res.Rate = rate
+	if len(fields) == 3 {
+		switch KeyType(fields[2]) {
+		case ByIP, ByUser:
+			res.By = KeyType(fields[2])
+		default:
+			return Rule{}, errors.Errorf("invalid key type %q in rule %q", fields[2], s)
+		}
+	}
+	return res, nil
+}
+
+// String returns rule in the same form as parsed
+func (r Rule) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s %s", r.Method, r.Path, r.Rate, r.By))
+}
+
+func (r Rule) match(req *http.Request) bool {
+	if r.Method != "" && r.Method != req.Method {
+		return false
+	}
+	if strings.HasSuffix(r.Path, "*") {
+		return strings.HasPrefix(req.URL.Path, strings.TrimSuffix(r.Path, "*"))
+	}
+	return r.Path == req.URL.Path
+}
+
+// Limiter checks requests against rules and rejects ones over the limit
+type Limiter struct {
+	Store  Store
+	Rules  []Rule
+	Secret string // used to hash ips and user ids in counter keys
+
+	// UserFn returns id of the request's user for "user" rules, limiter runs before auth middleware
+	// and can't rely on user set by it. User from request context used if not set
+	UserFn func(r *http.Request) (userID string, err error)
+}
+
+// Handler makes middleware limiting requests of the routes group. Requests not matched by any rule limited
+// by ip with default rate, separately for each group name.
+func (l *Limiter) Handler(name string, def Rate) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			rule, id := Rule{Rate: def, By: ByIP}, name
+			for i, rl := range l.Rules {
+				if rl.match(r) {
+					rule, id = rl, fmt.Sprintf("rule-%d", i)
+					break
+				}
+			}
+
+			key := "ratelimit:" + id + ":" + store.HashValue(l.subject(r, rule.By), l.Secret)
+			count, ttl, err := l.Store.Incr(key, rule.Rate.Period)
+			if err != nil {
+				log.Printf("[WARN] can't check rate limit for %s, %v", r.URL.Path, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			remaining := rule.Rate.Limit - count
+			if remaining < 0 {
+				remaining = 0
+			}
+			reset := strconv.Itoa(int(math.Ceil(ttl.Seconds())))
+			w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(rule.Rate.Limit, 10))
+			w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+			w.Header().Set("X-RateLimit-Reset", reset)
+			if count > rule.Rate.Limit {
+				w.Header().Set("Retry-After", reset)
+				rest.SendErrorJSON(w, r, http.StatusTooManyRequests, errors.Errorf("rate limit %s exceeded", rule.Rate),
+					"too many requests", rest.ErrActionRejected)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// subject returns user id or client ip the request counted for
+func (l *Limiter) subject(r *http.Request, by KeyType) string {
+	if by == ByUser {
+		if l.UserFn != nil {
+			if id, err := l.UserFn(r); err == nil && id != "" {
+				return "user:" + id
+			}
+		} else if user, err := rest.GetUserInfo(r); err == nil && user.ID != "" {
+			return "user:" + user.ID
+		}
+	}
+	ip := r.RemoteAddr // already set from X-Real-IP or X-Forwarded-For by RealIP middleware
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return "ip:" + ip
+}
@@ -0,0 +1,191 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/rest"
+	"github.com/umputun/remark/backend/app/store"
+)
+
+func TestRate_Parse(t *testing.T) {
+	tbl := []struct {
+		inp  string
+		res  Rate
+		fail bool
+	}{
+		{"10/s", Rate{Limit: 10, Period: time.Second}, false},
+		{"100/m", Rate{Limit: 100, Period: time.Minute}, false},
+		{"5/10m", Rate{Limit: 5, Period: 10 * time.Minute}, false},
+		{"1/2s", Rate{Limit: 1, Period: 2 * time.Second}, false},
+		{"1000/h", Rate{Limit: 1000, Period: time.Hour}, false},
+		{"10", Rate{}, true},
+		{"0/s", Rate{}, true},
+		{"abc/s", Rate{}, true},
+		{"10/xyz", Rate{}, true},
+		{"10/-1s", Rate{}, true},
+	}
+	for i, tt := range tbl {
+		res, err := ParseRate(tt.inp)
+		if tt.fail {
+			assert.Error(t, err, "case #%d", i)
+			continue
+		}
+		require.NoError(t, err, "case #%d", i)
+		assert.Equal(t, tt.res, res, "case #%d", i)
+	}
+}
+
+func TestRate_PerSecond(t *testing.T) {
+	assert.Equal(t, Rate{Limit: 10, Period: time.Second}, PerSecond(10))
+	assert.Equal(t, Rate{Limit: 1, Period: time.Second}, PerSecond(1))
+	assert.Equal(t, Rate{Limit: 1, Period: 4 * time.Second}, PerSecond(0.25))
+	assert.Equal(t, Rate{Limit: 25, Period: 10 * time.Second}, PerSecond(2.5))
+	assert.Equal(t, Rate{Limit: 15, Period: 10 * time.Second}, PerSecond(1.5))
+}
+
+func TestRule_Parse(t *testing.T) {
+	tbl := []struct {
+		inp  string
+		res  Rule
+		fail bool
+	}{
+		{"POST /api/v1/comment 5/m user", Rule{Method: "POST", Path: "/api/v1/comment",
+			Rate: Rate{Limit: 5, Period: time.Minute}, By: ByUser}, false},
+		{"/api/v1/find 20/s", Rule{Path: "/api/v1/find", Rate: Rate{Limit: 20, Period: time.Second}, By: ByIP}, false},
+		{"get /api/v1/*  100/10s ip", Rule{Method: "GET", Path: "/api/v1/*",
+			Rate: Rate{Limit: 100, Period: 10 * time.Second}, By: ByIP}, false},
+		{"POST /api/v1/comment", Rule{}, true},
+		{"POST /api/v1/comment 5/m user extra", Rule{}, true},
+		{"POST /api/v1/comment 5/m site", Rule{}, true},
+		{"POST /api/v1/comment 5/zz", Rule{}, true},
+		{"", Rule{}, true},
+	}
+	for i, tt := range tbl {
+		res, err := ParseRule(tt.inp)
+		if tt.fail {
+			assert.Error(t, err, "case #%d", i)
+			continue
+		}
+		require.NoError(t, err, "case #%d", i)
+		assert.Equal(t, tt.res, res, "case #%d", i)
+	}
+
+	r, err := ParseRule("post /api/v1/comment 5/m user")
+	require.NoError(t, err)
+	assert.Equal(t, "POST /api/v1/comment 5/1m0s user", r.String())
+}
+
+func TestLimiter_Handler(t *testing.T) {
+	rules := []Rule{
+		{Method: "POST", Path: "/api/v1/comment", Rate: Rate{Limit: 2, Period: time.Minute}, By: ByUser},
+		{Path: "/api/v1/find*", Rate: Rate{Limit: 1, Period: time.Minute}, By: ByIP},
+	}
+	lmt := Limiter{Store: NewMemoryStore(), Rules: rules, Secret: "secret"}
+	h := lmt.Handler("test", Rate{Limit: 3, Period: time.Minute})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	send := func(method, path, ip, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":12345"
+		if user != "" {
+			req = rest.SetUserInfo(req, store.User{ID: user, Name: user})
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// per-user rule, counted for each user separately even from the same ip
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, send("POST", "/api/v1/comment", "10.0.0.1", "user1").Code)
+	}
+	w := send("POST", "/api/v1/comment", "10.0.0.1", "user1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Contains(t, w.Body.String(), `"code":17`)
+	w = send("POST", "/api/v1/comment", "10.0.0.1", "user2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "", w.Header().Get("Retry-After"))
+
+	// per-user rule falls back to ip for anonymous
+	assert.Equal(t, http.StatusOK, send("POST", "/api/v1/comment", "10.0.0.2", "").Code)
+	assert.Equal(t, http.StatusOK, send("POST", "/api/v1/comment", "10.0.0.2", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send("POST", "/api/v1/comment", "10.0.0.2", "").Code)
+
+	// prefix rule by ip
+	assert.Equal(t, http.StatusOK, send("GET", "/api/v1/find?site=remark", "10.0.0.1", "user1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send("GET", "/api/v1/find", "10.0.0.1", "user2").Code)
+	assert.Equal(t, http.StatusOK, send("GET", "/api/v1/find", "10.0.0.3", "").Code)
+
+	// not matched, default rate by ip
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, send("GET", "/api/v1/comment", "10.0.0.1", "").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send("GET", "/api/v1/comment", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send("GET", "/api/v1/last/10", "10.0.0.1", "").Code, "same group")
+
+	// another group has own counters for default rate
+	h2 := lmt.Handler("another", Rate{Limit: 3, Period: time.Minute})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("GET", "/api/v1/comment", nil)
+	req.RemoteAddr = "10.0.0.1:12345"
+	w = httptest.NewRecorder()
+	h2.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLimiter_HandlerUserFn(t *testing.T) {
+	rules := []Rule{{Path: "/api/v1/comment", Rate: Rate{Limit: 1, Period: time.Minute}, By: ByUser}}
+	lmt := Limiter{Store: NewMemoryStore(), Rules: rules, UserFn: func(r *http.Request) (string, error) {
+		if u := r.Header.Get("X-User"); u != "" {
+			return u, nil
+		}
+		return "", errors.New("no token")
+	}}
+	h := lmt.Handler("test", Rate{Limit: 10, Period: time.Minute})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(user string) int {
+		req := httptest.NewRequest("POST", "/api/v1/comment", nil)
+		req.RemoteAddr = "10.0.0.1:12345"
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, send("user1"))
+	assert.Equal(t, http.StatusTooManyRequests, send("user1"))
+	assert.Equal(t, http.StatusOK, send("user2"), "counted per user from UserFn")
+	assert.Equal(t, http.StatusOK, send(""), "anonymous counted by ip")
+	assert.Equal(t, http.StatusTooManyRequests, send(""))
+}
+
+func TestLimiter_HandlerStoreError(t *testing.T) {
+	lmt := Limiter{Store: errStore{}}
+	h := lmt.Handler("test", Rate{Limit: 1, Period: time.Minute})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/find", nil))
+		assert.Equal(t, http.StatusOK, w.Code, "failed store doesn't block requests")
+		assert.Equal(t, "ok", w.Body.String())
+	}
+}
+
+type errStore struct{}
+
+func (errStore) Incr(string, time.Duration) (int64, time.Duration, error) {
+	return 0, 0, errors.New("store failed")
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+)
+
+// Redis implements Store with counters kept in redis, limits shared between all instances using the same redis
+type Redis struct {
+	client redis.UniversalClient
+}
+
+// incrScript increments counter and sets expiration for the new one atomically, returns counter and ttl in ms
+var incrScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+// NewRedisStore makes redis counters store
+func NewRedisStore(client redis.UniversalClient) *Redis {
+	return &Redis{client: client}
+}
+
+// Incr increments counter for the key in current window, returns new value and time left till the window end
+func (r *Redis) Incr(key string, window time.Duration) (count int64, ttl time.Duration, err error) {
+	res, err := incrScript.Run(r.client, []string{key}, window.Milliseconds()).Result()
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "can't increment %s", key)
+	}
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return 0, 0, errors.Errorf("unexpected result %v for %s", res, key)
+	}
+	count, _ = vals[0].(int64)
+	ttlMs, _ := vals[1].(int64)
+	if ttlMs < 0 { // key without expiration, shouldn't happen
+		ttlMs = window.Milliseconds()
+	}
+	return count, time.Duration(ttlMs) * time.Millisecond, nil
+}
+
+// Close redis client
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
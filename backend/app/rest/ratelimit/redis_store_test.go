@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedis_Incr(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	r := NewRedisStore(redis.NewClient(&redis.Options{Addr: srv.Addr()}))
+	defer r.Close()
+
+	for i := 1; i <= 3; i++ {
+		count, ttl, e := r.Incr("key1", time.Minute)
+		require.NoError(t, e)
+		assert.Equal(t, int64(i), count)
+		assert.Equal(t, time.Minute, ttl)
+	}
+	count, _, err := r.Incr("key2", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count, "separate counter")
+
+	srv.FastForward(30 * time.Second)
+	count, ttl, err := r.Incr("key1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+	assert.Equal(t, 30*time.Second, ttl, "expiration not extended")
+
+	srv.FastForward(31 * time.Second)
+	count, _, err = r.Incr("key1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count, "new window")
+
+	srv.SetError("redis failed")
+	_, _, err = r.Incr("key1", time.Minute)
+	assert.Error(t, err)
+}
+
+func TestRedis_SharedCounters(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	r1 := NewRedisStore(redis.NewClient(&redis.Options{Addr: srv.Addr()}))
+	r2 := NewRedisStore(redis.NewClient(&redis.Options{Addr: srv.Addr()}))
+	for i, s := range []Store{r1, r2, r1} {
+		count, _, e := s.Incr("key", time.Minute)
+		require.NoError(t, e)
+		assert.Equal(t, int64(i+1), count)
+	}
+	assert.NoError(t, r1.Close())
+	assert.NoError(t, r2.Close())
+}
@@ -0,0 +1,143 @@
+// Package sigv4 signs requests to S3 compatible storage with AWS signature version 4, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signer signs requests with credentials for the region
+type Signer struct {
+	AccessKey string
+	SecretKey string
+	Region    string
+}
+
+const (
+	// Algorithm of the signature
+	Algorithm = "AWS4-HMAC-SHA256"
+	// TimeFormat of x-amz-date
+	TimeFormat = "20060102T150405Z"
+	// UnsignedPayload used as payload hash if payload not signed
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// EmptyPayload is hash of empty payload
+var EmptyPayload = HashHex(nil)
+
+// Sign sets x-amz-date, x-amz-content-sha256 and Authorization headers. All headers set on the request signed.
+func (s Signer) Sign(r *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(TimeFormat)
+	r.Header.Set("x-amz-date", amzDate)
+	r.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := []string{"host"}
+	for k := range r.Header {
+		headers = append(headers, strings.ToLower(k))
+	}
+	sort.Strings(headers)
+
+	sig := s.Signature(r, r.URL.Query(), headers, payloadHash, amzDate)
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		Algorithm, s.AccessKey, s.scope(amzDate), strings.Join(headers, ";"), sig))
+}
+
+// Presign adds query authentication to the request url, making link valid for expires duration
+func (s Signer) Presign(r *http.Request, expires time.Duration, now time.Time) {
+	amzDate := now.UTC().Format(TimeFormat)
+	q := r.URL.Query()
+	q.Set("X-Amz-Algorithm", Algorithm)
+	q.Set("X-Amz-Credential", s.AccessKey+"/"+s.scope(amzDate))
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+	q.Set("X-Amz-Signature", s.Signature(r, q, []string{"host"}, UnsignedPayload, amzDate))
+	r.URL.RawQuery = q.Encode()
+}
+
+// Signature calculates request signature for given query, signed headers (lower case, sorted) and payload hash
+func (s Signer) Signature(r *http.Request, query url.Values, headers []string, payloadHash, amzDate string) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	canonicalHeaders := strings.Builder{}
+	for _, h := range headers {
+		val := host
+		if h != "host" {
+			val = strings.Join(r.Header.Values(h), ",")
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(val) + "\n")
+	}
+
+	canonicalQuery := []string{}
+	for k, vals := range query {
+		if k == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range vals {
+			canonicalQuery = append(canonicalQuery, escape(k)+"="+escape(v))
+		}
+	}
+	sort.Strings(canonicalQuery)
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		EscapePath(r.URL.Path),
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders.String(),
+		strings.Join(headers, ";"),
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{Algorithm, amzDate, s.scope(amzDate), HashHex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), amzDate[:8])
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func (s Signer) scope(amzDate string) string {
+	return amzDate[:8] + "/" + s.Region + "/s3/aws4_request"
+}
+
+// escape encodes everything except unreserved characters, as required by signature v4
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// EscapePath encodes each segment of the path, keeping slashes
+func EscapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	elems := strings.Split(p, "/")
+	for i, e := range elems {
+		elems[i] = escape(e)
+	}
+	return strings.Join(elems, "/")
+}
+
+// HashHex returns hex encoded sha256 of data
+func HashHex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,104 @@
+package changelog
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"github.com/pkg/errors"
+)
+
+// Bolt implements Store keeping records in bolt DB. Each site has its own bucket with
+// big-endian sequence number as a key, so cursor iteration goes in the order of changes.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// NewBoltStorage makes bolt change log store
+func NewBoltStorage(fileName string, options bolt.Options) (*Bolt, error) {
+	db, err := bolt.Open(fileName, 0600, &options)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	return &Bolt{db: db}, nil
+}
+
+// Append record to site's bucket, sequence number made by bucket's NextSequence
+func (b *Bolt) Append(siteID string, rec Record) (Record, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists([]byte(siteID))
+		if err != nil {
+			return errors.Wrapf(err, "can't make bucket for %s", siteID)
+		}
+		if rec.Seq, err = bkt.NextSequence(); err != nil {
+			return errors.Wrapf(err, "can't get sequence for %s", siteID)
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return errors.Wrapf(err, "can't marshal record %d", rec.Seq)
+		}
+		return errors.Wrapf(bkt.Put(seqKey(rec.Seq), data), "can't put record %d", rec.Seq)
+	})
+	return rec, err
+}
+
+// Since returns up to limit records with sequence number greater than after
+func (b *Bolt) Since(siteID string, after uint64, limit int) ([]Record, error) {
+	res := []Record{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(siteID))
+		if bkt == nil {
+			return nil // no changes for the site yet
+		}
+		c := bkt.Cursor()
+		for k, v := c.Seek(seqKey(after + 1)); k != nil; k, v = c.Next() {
+			rec := Record{}
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return errors.Wrapf(err, "can't unmarshal record %d", binary.BigEndian.Uint64(k))
+			}
+			res = append(res, rec)
+			if limit > 0 && len(res) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Cleanup removes records made before given time. Sequence numbers never reused.
+func (b *Bolt) Cleanup(_ context.Context, siteID string, before time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(siteID))
+		if bkt == nil {
+			return nil
+		}
+		c := bkt.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			rec := Record{}
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return errors.Wrapf(err, "can't unmarshal record %d", binary.BigEndian.Uint64(k))
+			}
+			if !rec.Timestamp.Before(before) {
+				break // records ordered by time, nothing older left
+			}
+			if err := c.Delete(); err != nil {
+				return errors.Wrapf(err, "can't delete record %d", rec.Seq)
+			}
+		}
+		return nil
+	})
+}
+
+// Close bolt store
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
@@ -0,0 +1,65 @@
+package changelog
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBolt_AppendSince(t *testing.T) {
+	s, teardown := prepBoltStore(t)
+	defer teardown()
+	checkAppendSince(t, s)
+}
+
+func TestBolt_Cleanup(t *testing.T) {
+	s, teardown := prepBoltStore(t)
+	defer teardown()
+	checkCleanup(t, s)
+}
+
+func TestBolt_Reopen(t *testing.T) {
+	loc, err := ioutil.TempDir("", "test_changes_r42")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+	dbFile := path.Join(loc, "changes.db")
+
+	s, err := NewBoltStorage(dbFile, bolt.Options{})
+	require.NoError(t, err)
+	_, err = s.Append("site1", Record{Kind: KindCreate, CommentID: "id1"})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = NewBoltStorage(dbFile, bolt.Options{})
+	require.NoError(t, err)
+	defer s.Close()
+	rec, err := s.Append("site1", Record{Kind: KindCreate, CommentID: "id2"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), rec.Seq, "sequence continues after reopen")
+
+	recs, err := s.Since("site1", 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(recs))
+	assert.Equal(t, "id1", recs[0].CommentID)
+}
+
+func TestBolt_NewFailed(t *testing.T) {
+	_, err := NewBoltStorage("/dev/null/bad/changes.db", bolt.Options{})
+	assert.Error(t, err)
+}
+
+func prepBoltStore(t *testing.T) (s *Bolt, teardown func()) {
+	loc, err := ioutil.TempDir("", "test_changes_r42")
+	require.NoError(t, err)
+	s, err = NewBoltStorage(path.Join(loc, "changes.db"), bolt.Options{})
+	require.NoError(t, err)
+	return s, func() {
+		assert.NoError(t, s.Close())
+		assert.NoError(t, os.RemoveAll(loc))
+	}
+}
@@ -0,0 +1,165 @@
+// Package changelog keeps ordered log of all changes made to comments, votes and flags, separately for each site.
+// Each record gets monotonically increasing (per site) sequence number, so consumers can follow the log
+// from the last seen position without missing edits and deletes.
+// Provides Store with bolt and in-memory implementations. Service object encloses Store and adds
+// waiting for new records, this is the one consumer should use.
+package changelog
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+// Kind defines type of the change
+type Kind string
+
+// enum of all change kinds
+const (
+	KindCreate Kind = "create" // new comment
+	KindUpdate Kind = "update" // comment edited, pinned or got new title
+	KindDelete Kind = "delete" // comment deleted, or all user's comments deleted if CommentID is empty
+	KindVote   Kind = "vote"   // comment's score changed
+	KindFlag   Kind = "flag"   // flag (read-only, blocked, verified) set or reset for post or user
+	KindReset  Kind = "reset"  // all site's data removed, i.e. on import
+)
+
+// Record is a single entry of the change log
+type Record struct {
+	Seq       uint64         `json:"seq"`
+	Kind      Kind           `json:"kind"`
+	Locator   store.Locator  `json:"locator"`
+	CommentID string         `json:"comment_id,omitempty"`
+	UserID    string         `json:"user_id,omitempty"`
+	Flag      string         `json:"flag,omitempty"`
+	FlagValue bool           `json:"flag_value,omitempty"`
+	Comment   *store.Comment `json:"comment,omitempty"` // comment as it was after the change, for create, update and vote
+	Timestamp time.Time      `json:"time"`
+}
+
+// Store defines interface for change log storage
+type Store interface {
+	Append(siteID string, rec Record) (Record, error)                   // add record, sets Seq and returns stored record
+	Since(siteID string, after uint64, limit int) ([]Record, error)     // get up to limit records with Seq > after
+	Cleanup(ctx context.Context, siteID string, before time.Time) error // remove records made before given time
+	Close() error
+}
+
+const defaultLimit = 100
+const maxLimit = 1000
+
+// Service wraps Store with notification of consumers waiting for new records
+type Service struct {
+	Store     Store
+	Retention time.Duration // records older than this removed by cleanup, 0 keeps records forever
+
+	lock    sync.Mutex
+	waiters map[string]chan struct{} // closed and replaced on each append to site
+}
+
+// NewService makes change log service for given store
+func NewService(s Store, retention time.Duration) *Service {
+	return &Service{Store: s, Retention: retention, waiters: map[string]chan struct{}{}}
+}
+
+// Add appends record to the log and wakes up all waiting consumers. Nil-safe, errors logged only
+// as change log should never break the actual operation.
+func (s *Service) Add(siteID string, rec Record) {
+	if s == nil {
+		return
+	}
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+	if rec.Locator.SiteID == "" {
+		rec.Locator.SiteID = siteID
+	}
+	if _, err := s.Store.Append(siteID, rec); err != nil {
+		log.Printf("[WARN] can't add %s record to change log of %s, %v", rec.Kind, siteID, err)
+		return
+	}
+
+	s.lock.Lock()
+	if ch, ok := s.waiters[siteID]; ok {
+		close(ch)
+		delete(s.waiters, siteID)
+	}
+	s.lock.Unlock()
+}
+
+// Since returns up to limit records made after given sequence number
+func (s *Service) Since(siteID string, after uint64, limit int) ([]Record, error) {
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return s.Store.Since(siteID, after, limit)
+}
+
+// Wait returns records made after given sequence number. If nothing found it blocks till new record
+// appended or context canceled. Returns empty list (and no error) on context cancellation.
+func (s *Service) Wait(ctx context.Context, siteID string, after uint64, limit int) ([]Record, error) {
+	for {
+		ch := s.waitCh(siteID) // get chan before the check to avoid missing appends made in between
+		recs, err := s.Since(siteID, after, limit)
+		if err != nil || len(recs) > 0 {
+			return recs, err
+		}
+		select {
+		case <-ctx.Done():
+			return []Record{}, nil
+		case <-ch:
+		}
+	}
+}
+
+// Cleanup runs periodic removal of records older than Retention for all given sites.
+// Blocking loop, should be called inside of goroutine by consumer
+func (s *Service) Cleanup(ctx context.Context, sites ...string) {
+	if s.Retention <= 0 {
+		return
+	}
+	log.Printf("[INFO] start change log cleanup, retention=%v", s.Retention)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] change log cleanup terminated, %v", ctx.Err())
+			return
+		case <-time.After(time.Hour):
+			for _, siteID := range sites {
+				if err := s.Store.Cleanup(ctx, siteID, time.Now().Add(-s.Retention)); err != nil {
+					log.Printf("[WARN] failed to cleanup change log for %s, %v", siteID, err)
+				}
+			}
+		}
+	}
+}
+
+// Close change log store
+func (s *Service) Close() error {
+	if s == nil {
+		return nil
+	}
+	return errors.Wrap(s.Store.Close(), "can't close change log store")
+}
+
+func (s *Service) waitCh(siteID string) chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.waiters == nil {
+		s.waiters = map[string]chan struct{}{}
+	}
+	ch, ok := s.waiters[siteID]
+	if !ok {
+		ch = make(chan struct{})
+		s.waiters[siteID] = ch
+	}
+	return ch
+}
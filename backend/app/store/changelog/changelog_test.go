@@ -0,0 +1,93 @@
+package changelog
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_AddSince(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), time.Hour)
+	svc.Add("site1", Record{Kind: KindCreate, CommentID: "id1"})
+	svc.Add("site1", Record{Kind: KindVote, CommentID: "id1"})
+
+	recs, err := svc.Since("site1", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(recs))
+	assert.Equal(t, "site1", recs[0].Locator.SiteID, "site set from siteID")
+	assert.False(t, recs[0].Timestamp.IsZero(), "timestamp set")
+	assert.Equal(t, KindVote, recs[1].Kind)
+
+	recs, err = svc.Since("site1", 1, 10000)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(recs))
+
+	var nilSvc *Service
+	nilSvc.Add("site1", Record{Kind: KindCreate}) // should not panic
+	assert.NoError(t, nilSvc.Close())
+}
+
+func TestService_WaitExisting(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 0)
+	svc.Add("site1", Record{Kind: KindCreate, CommentID: "id1"})
+
+	st := time.Now()
+	recs, err := svc.Wait(context.Background(), "site1", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(recs))
+	assert.True(t, time.Since(st) < 100*time.Millisecond, "returned without waiting")
+}
+
+func TestService_WaitNew(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 0)
+	svc.Add("site1", Record{Kind: KindCreate, CommentID: "id1"})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(50 * time.Millisecond)
+		svc.Add("site2", Record{Kind: KindCreate, CommentID: "other"}) // different site, should not wake up
+		time.Sleep(50 * time.Millisecond)
+		svc.Add("site1", Record{Kind: KindDelete, CommentID: "id1"})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	st := time.Now()
+	recs, err := svc.Wait(ctx, "site1", 1, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(recs))
+	assert.Equal(t, KindDelete, recs[0].Kind)
+	assert.Equal(t, uint64(2), recs[0].Seq)
+	assert.True(t, time.Since(st) >= 100*time.Millisecond)
+	wg.Wait()
+}
+
+func TestService_WaitCanceled(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	recs, err := svc.Wait(ctx, "site1", 0, 10)
+	require.NoError(t, err)
+	assert.NotNil(t, recs)
+	assert.Equal(t, 0, len(recs))
+}
+
+func TestService_CleanupNoRetention(t *testing.T) {
+	svc := NewService(NewMemoryStorage(), 0)
+	done := make(chan struct{})
+	go func() {
+		svc.Cleanup(context.Background(), "site1")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cleanup should return immediately without retention")
+	}
+}
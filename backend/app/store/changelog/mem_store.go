@@ -0,0 +1,60 @@
+package changelog
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory implements Store keeping all records in memory. Records lost on restart,
+// intended for tests and single-instance setups where consumers can tolerate a gap.
+type Memory struct {
+	lock    sync.RWMutex
+	records map[string][]Record
+	seqs    map[string]uint64
+}
+
+// NewMemoryStorage makes in-memory change log store
+func NewMemoryStorage() *Memory {
+	return &Memory{records: map[string][]Record{}, seqs: map[string]uint64{}}
+}
+
+// Append record and set next sequence number
+func (m *Memory) Append(siteID string, rec Record) (Record, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.seqs[siteID]++
+	rec.Seq = m.seqs[siteID]
+	m.records[siteID] = append(m.records[siteID], rec)
+	return rec, nil
+}
+
+// Since returns up to limit records with sequence number greater than after
+func (m *Memory) Since(siteID string, after uint64, limit int) ([]Record, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	recs := m.records[siteID]
+	idx := sort.Search(len(recs), func(i int) bool { return recs[i].Seq > after })
+	res := []Record{}
+	for _, r := range recs[idx:] {
+		res = append(res, r)
+		if limit > 0 && len(res) >= limit {
+			break
+		}
+	}
+	return res, nil
+}
+
+// Cleanup removes records made before given time
+func (m *Memory) Cleanup(_ context.Context, siteID string, before time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	recs := m.records[siteID]
+	idx := sort.Search(len(recs), func(i int) bool { return !recs[i].Timestamp.Before(before) })
+	m.records[siteID] = append([]Record{}, recs[idx:]...)
+	return nil
+}
+
+// Close does nothing for memory store
+func (m *Memory) Close() error { return nil }
@@ -0,0 +1,81 @@
+package changelog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+func TestMemory_AppendSince(t *testing.T) {
+	checkAppendSince(t, NewMemoryStorage())
+}
+
+func TestMemory_Cleanup(t *testing.T) {
+	checkCleanup(t, NewMemoryStorage())
+}
+
+// checkAppendSince verifies Store's sequence numbers, paging and per-site separation
+func checkAppendSince(t *testing.T, s Store) {
+	for i := 0; i < 5; i++ {
+		rec, err := s.Append("site1", Record{Kind: KindCreate, CommentID: "id" + string(rune('0'+i)),
+			Locator: store.Locator{SiteID: "site1", URL: "https://example.com"}, Timestamp: time.Now()})
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i+1), rec.Seq)
+	}
+	rec, err := s.Append("site2", Record{Kind: KindDelete, CommentID: "xyz"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), rec.Seq, "sequence separate for each site")
+
+	recs, err := s.Since("site1", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(recs))
+	assert.Equal(t, "id0", recs[0].CommentID)
+	assert.Equal(t, "https://example.com", recs[0].Locator.URL)
+	assert.Equal(t, uint64(5), recs[4].Seq)
+
+	recs, err = s.Since("site1", 2, 2)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(recs))
+	assert.Equal(t, uint64(3), recs[0].Seq)
+	assert.Equal(t, uint64(4), recs[1].Seq)
+
+	recs, err = s.Since("site1", 5, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(recs))
+
+	recs, err = s.Since("site2", 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(recs))
+	assert.Equal(t, KindDelete, recs[0].Kind)
+
+	recs, err = s.Since("bad", 0, 10)
+	require.NoError(t, err)
+	assert.NotNil(t, recs)
+	assert.Equal(t, 0, len(recs))
+}
+
+// checkCleanup verifies removal of old records, sequence numbers should not be reused
+func checkCleanup(t *testing.T, s Store) {
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err := s.Append("site1", Record{Kind: KindVote, Timestamp: now.Add(-time.Duration(4-i) * time.Hour)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Cleanup(context.Background(), "site1", now.Add(-150*time.Minute)))
+	require.NoError(t, s.Cleanup(context.Background(), "bad", now), "no error for unknown site")
+
+	recs, err := s.Since("site1", 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(recs))
+	assert.Equal(t, uint64(3), recs[0].Seq)
+	assert.Equal(t, uint64(4), recs[1].Seq)
+
+	rec, err := s.Append("site1", Record{Kind: KindVote, Timestamp: now})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), rec.Seq)
+}
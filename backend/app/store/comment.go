@@ -2,6 +2,7 @@ package store
 
 import (
 	"html/template"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -27,6 +28,8 @@ type Comment struct {
 	Pin         bool                   `json:"pin,omitempty" bson:"pin,omitempty"`
 	Deleted     bool                   `json:"delete,omitempty" bson:"delete"`
 	PostTitle   string                 `json:"title,omitempty" bson:"title"`
+	Previews    []LinkPreview          `json:"previews,omitempty" bson:"previews,omitempty"` // previews of links from the text
+	Images      []string               `json:"images,omitempty" bson:"images,omitempty"`     // ids of uploaded images referenced by the text
 }
 
 // Locator keeps site and url of the post
@@ -41,6 +44,15 @@ type Edit struct {
 	Summary   string    `json:"summary"`
 }
 
+// LinkPreview describes page linked from the comment, made of OpenGraph tags and oEmbed data of the page
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
 // PostInfo holds summary for given post url
 type PostInfo struct {
 	URL      string    `json:"url"`
@@ -95,6 +107,8 @@ func (c *Comment) SetDeleted(mode DeleteMode) {
 	c.Score = 0
 	c.Votes = map[string]bool{}
 	c.Edit = nil
+	c.Previews = nil
+	c.Images = nil
 	c.Deleted = true
 	c.Pin = false
 
@@ -119,6 +133,11 @@ func (c *Comment) Sanitize() {
 	p.AllowAttrs("class").Matching(regexp.MustCompile(codeSpanClassRegex)).OnElements("span")
 	c.Text = p.Sanitize(c.Text)
 	c.Orig = p.Sanitize(c.Orig)
+	for i, pv := range c.Previews {
+		c.Previews[i] = LinkPreview{URL: c.sanitizeURL(pv.URL), Title: c.escapeHtmlWithSome(pv.Title),
+			Description: c.escapeHtmlWithSome(pv.Description), Image: c.sanitizeURL(pv.Image),
+			SiteName: c.escapeHtmlWithSome(pv.SiteName)}
+	}
 	c.User.ID = template.HTMLEscapeString(c.User.ID)
 	c.User.Name = c.escapeHtmlWithSome(c.User.Name)
 	c.User.Picture = p.Sanitize(c.User.Picture)
@@ -152,3 +171,12 @@ func (c *Comment) escapeHtmlWithSome(inp string) string {
 	res = strings.Replace(res, "&amp;", "&", -1)
 	return res
 }
+
+// sanitizeURL keeps absolute http(s) urls only
+func (c *Comment) sanitizeURL(inp string) string {
+	u, err := url.Parse(strings.TrimSpace(inp))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
@@ -47,6 +47,16 @@ func TestComment_Sanitize(t *testing.T) {
 			inp: Comment{Text: "blah & & 123", User: User{Name: "name <> & ' ` \""}},
 			out: Comment{Text: `blah &amp; &amp; 123`, User: User{Name: "name &lt;&gt; & ' ` \""}},
 		},
+		{
+			inp: Comment{
+				Text:     `<div class="link-preview" onclick="alert(1)"><p class="link-preview">text</p></div>`,
+				Previews: []LinkPreview{{URL: "javascript:alert(1)", Title: "<b>title</b>", Image: "https://example.com/1.png"}},
+			},
+			out: Comment{
+				Text:     `<div><p>text</p></div>`,
+				Previews: []LinkPreview{{Title: "&lt;b&gt;title&lt;/b&gt;", Image: "https://example.com/1.png"}},
+			},
+		},
 	}
 
 	for n, tt := range tbl {
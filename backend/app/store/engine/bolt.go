@@ -22,10 +22,14 @@ import (
 //    value is not full comment but a reference combined from post-url+commentID
 //  - user to comment references in "users" bucket. It used to get comments for user. Key is userID and value
 //    is a nested bucket named userID with kv as ts:reference
+//  - image to comment references in "images" bucket, made from Images of not deleted comments. Key is imageID and
+//    value is a nested bucket named imageID with kv as ts:reference
 //  - users details in "user_details" bucket. Key is userID, value - UserDetailEntry
 //  - blocking info sits in "block" bucket. Key is userID, value - ts
 //  - counts per post to keep number of comments. Key is post url, value - count
-//  - readonly per post to keep status of manually set RO posts. Key is post url, value - ts
+//  - readonly per post to keep status of manually set RO posts. Key is post url, value - ts. Legacy, status kept in post record now
+//  - post records in "post_meta" bucket. Key is canonical post url, value - store.Post
+//  - aliases of posts in "aliases" bucket. Key is alias url, value - canonical post url
 type BoltDB struct {
 	dbs map[string]*bolt.DB
 }
@@ -40,6 +44,9 @@ const (
 	infoBucketName        = "info"
 	readonlyBucketName    = "readonly"
 	verifiedBucketName    = "verified"
+	postMetaBucketName    = "post_meta"
+	aliasesBucketName     = "aliases"
+	imagesBucketName      = "images"
 
 	tsNano = "2006-01-02T15:04:05.000000000Z07:00"
 )
@@ -62,7 +69,8 @@ func NewBoltDB(options bolt.Options, sites ...BoltSite) (*BoltDB, error) {
 
 		// make top-level buckets
 		topBuckets := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName,
-			blocksBucketName, infoBucketName, readonlyBucketName, verifiedBucketName}
+			blocksBucketName, infoBucketName, readonlyBucketName, verifiedBucketName, postMetaBucketName, aliasesBucketName,
+			imagesBucketName}
 		err = db.Update(func(tx *bolt.Tx) error {
 			for _, bktName := range topBuckets {
 				if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
@@ -82,12 +90,13 @@ func NewBoltDB(options bolt.Options, sites ...BoltSite) (*BoltDB, error) {
 	return &result, nil
 }
 
-// Create saves new comment to store. Adds to posts bucket, reference to last and user bucket and increments count bucket
+// Create saves new comment to store. Adds to posts bucket, reference to last, user and images buckets and increments count bucket
 func (b *BoltDB) Create(comment store.Comment) (commentID string, err error) {
 	bdb, err := b.db(comment.Locator.SiteID)
 	if err != nil {
 		return "", err
 	}
+	comment.Locator = b.resolve(bdb, comment.Locator)
 
 	if b.checkFlag(FlagRequest{Locator: comment.Locator, Flag: ReadOnly}) {
 		return "", errors.Errorf("post %s is read-only", comment.Locator.URL)
@@ -127,10 +136,20 @@ func (b *BoltDB) Create(comment store.Comment) (commentID string, err error) {
 			return errors.Wrapf(err, "failed to put user comment %s for %s", comment.ID, comment.User.ID)
 		}
 
+		// add reference to comment to buckets of referenced images
+		if err = b.putImageRefs(tx, comment); err != nil {
+			return err
+		}
+
 		// set info with the count for post url
 		if _, err = b.setInfo(tx, comment); err != nil {
 			return errors.Wrapf(err, "failed to set info for %s", comment.Locator)
 		}
+
+		// make post record for the first comment, set missing title
+		if err = b.touchPost(tx, comment); err != nil {
+			return errors.Wrapf(err, "failed to set post record for %s", comment.Locator)
+		}
 		return nil
 	})
 
@@ -144,6 +163,7 @@ func (b *BoltDB) Get(req GetRequest) (comment store.Comment, err error) {
 	if err != nil {
 		return comment, err
 	}
+	req.Locator = b.resolve(bdb, req.Locator)
 
 	err = bdb.View(func(tx *bolt.Tx) error {
 		bucket, e := b.getPostBucket(tx, req.Locator.URL)
@@ -163,6 +183,7 @@ func (b *BoltDB) Find(req FindRequest) (comments []store.Comment, err error) {
 	if err != nil {
 		return nil, err
 	}
+	req.Locator = b.resolve(bdb, req.Locator)
 
 	switch {
 	case req.Locator.SiteID != "" && req.Locator.URL != "": // find post comments, i.e. for site and url
@@ -184,6 +205,8 @@ func (b *BoltDB) Find(req FindRequest) (comments []store.Comment, err error) {
 				return nil
 			})
 		})
+	case req.Locator.SiteID != "" && req.ImageID != "": // find comments referencing the image
+		comments, err = b.imageComments(req.Locator.SiteID, req.ImageID)
 	case req.Locator.SiteID != "" && req.Locator.URL == "" && req.UserID == "": // find last comments for site
 		comments, err = b.lastComments(req.Locator.SiteID, req.Limit, req.Since)
 	case req.Locator.SiteID != "" && req.UserID != "": // find comments for user
@@ -198,6 +221,9 @@ func (b *BoltDB) Find(req FindRequest) (comments []store.Comment, err error) {
 
 // Flag sets and gets flag values
 func (b *BoltDB) Flag(req FlagRequest) (val bool, err error) {
+	if bdb, e := b.db(req.Locator.SiteID); e == nil && req.UserID == "" {
+		req.Locator = b.resolve(bdb, req.Locator)
+	}
 	if req.Update == FlagNonSet { // read flag value, no update requested
 		return b.checkFlag(req), nil
 	}
@@ -233,30 +259,202 @@ func (b *BoltDB) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
 	}
 }
 
-// Update for locator.URL with mutable part of comment
+// Post sets or gets post record by url or alias, or lists all post records of the site.
+// Posts created before post records introduced have no record till updated, the record made from post info for them.
+func (b *BoltDB) Post(req PostRequest) ([]store.Post, error) {
+	bdb, err := b.db(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Update != nil { // save post record
+		post := *req.Update
+		post.Locator.SiteID = req.Locator.SiteID
+		if post.Locator.URL == "" {
+			post.Locator.URL = req.Locator.URL
+		}
+		if post.Locator.URL == "" {
+			return nil, errors.New("no url for post record")
+		}
+		err = bdb.Update(func(tx *bolt.Tx) (e error) {
+			post, e = b.savePost(tx, post)
+			return e
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []store.Post{post}, nil
+	}
+
+	if req.Locator.URL == "" { // list of post records for site
+		res := []store.Post{}
+		err = bdb.View(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(postMetaBucketName)).ForEach(func(_, v []byte) error {
+				post := store.Post{}
+				if e := json.Unmarshal(v, &post); e != nil {
+					return errors.Wrap(e, "failed to unmarshal")
+				}
+				res = append(res, post)
+				return nil
+			})
+		})
+		return res, err
+	}
+
+	var post store.Post
+	err = bdb.View(func(tx *bolt.Tx) (e error) {
+		post, e = b.loadPost(tx, store.Locator{SiteID: req.Locator.SiteID, URL: b.resolveURL(tx, req.Locator.URL)})
+		return e
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []store.Post{post}, nil
+}
+
+// Update for locator.URL with mutable part of comment, references to images replaced by Images of updated comment
 func (b *BoltDB) Update(comment store.Comment) error {
 
+	bdb, err := b.db(comment.Locator.SiteID)
+	if err != nil {
+		return err
+	}
+	comment.Locator = b.resolve(bdb, comment.Locator)
+
 	getReq := GetRequest{Locator: comment.Locator, CommentID: comment.ID}
+	restored := false
 	if curComment, err := b.Get(getReq); err == nil {
-		// preserve immutable fields
+		// preserve immutable fields, user of deleted comment can be restored by update
 		comment.ParentID = curComment.ParentID
 		comment.Locator = curComment.Locator
 		comment.Timestamp = curComment.Timestamp
-		comment.User = curComment.User
+		if !curComment.Deleted || comment.Deleted {
+			comment.User = curComment.User
+		}
+		restored = curComment.Deleted && !comment.Deleted
 	}
 
-	bdb, err := b.db(comment.Locator.SiteID)
+	return bdb.Update(func(tx *bolt.Tx) error {
+		bucket, e := b.getPostBucket(tx, comment.Locator.URL)
+		if e != nil {
+			return e
+		}
+		curComment := store.Comment{}
+		if e = b.load(bucket, comment.ID, &curComment); e == nil {
+			if e = b.removeImageRefs(tx, curComment); e != nil {
+				return e
+			}
+		}
+		if e = b.save(bucket, comment.ID, comment); e != nil {
+			return e
+		}
+		if e = b.putImageRefs(tx, comment); e != nil {
+			return e
+		}
+		if !restored {
+			return nil
+		}
+		// restored comment, references removed with deleted user put back
+		if _, e = b.count(tx, comment.Locator.URL, 1); e != nil {
+			return errors.Wrapf(e, "failed to update count for %s", comment.Locator)
+		}
+		commentTs, ref := []byte(comment.Timestamp.Format(tsNano)), b.makeRef(comment)
+		if e = tx.Bucket([]byte(lastBucketName)).Put(commentTs, ref); e != nil {
+			return errors.Wrapf(e, "can't put reference %s to %s", ref, lastBucketName)
+		}
+		userBkt, e := b.getUserBucket(tx, comment.User.ID)
+		if e != nil {
+			return errors.Wrapf(e, "can't get bucket %s", comment.User.ID)
+		}
+		if e = userBkt.Put(commentTs, ref); e != nil {
+			return errors.Wrapf(e, "failed to put user comment %s for %s", comment.ID, comment.User.ID)
+		}
+		return nil
+	})
+}
+
+// Move moves comments, or all comments of the post, to another post of the same site. Updates references
+// in "last", "users" and "images" buckets, counts and times of both posts, removes source post if no comments left in it
+func (b *BoltDB) Move(req MoveRequest) (moved []store.Comment, err error) {
+	if req.Locator.SiteID != req.To.SiteID {
+		return nil, errors.Errorf("can't move comments from site %s to %s", req.Locator.SiteID, req.To.SiteID)
+	}
+	bdb, err := b.db(req.Locator.SiteID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	req.Locator, req.To = b.resolve(bdb, req.Locator), b.resolve(bdb, req.To)
+	if req.Locator.URL == "" || req.To.URL == "" || req.Locator.URL == req.To.URL {
+		return nil, errors.Errorf("invalid move request %+v", req)
 	}
 
-	return bdb.Update(func(tx *bolt.Tx) error {
-		bucket, e := b.getPostBucket(tx, comment.Locator.URL)
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		srcBkt, e := b.getPostBucket(tx, req.Locator.URL)
 		if e != nil {
 			return e
 		}
-		return b.save(bucket, comment.ID, comment)
+		ids := req.CommentIDs
+		if len(ids) == 0 {
+			_ = srcBkt.ForEach(func(k, _ []byte) error {
+				ids = append(ids, string(k))
+				return nil
+			})
+		}
+		idsSet := map[string]bool{}
+		for _, id := range ids {
+			idsSet[id] = true
+		}
+
+		dstBkt, e := b.makePostBucket(tx, req.To.URL)
+		if e != nil {
+			return e
+		}
+		dstPost, _ := b.loadPost(tx, req.To)
+
+		for _, id := range ids {
+			comment := store.Comment{}
+			if e = b.load(srcBkt, id, &comment); e != nil {
+				return errors.Wrapf(e, "can't load comment %s from %s", id, req.Locator.URL)
+			}
+			if dstBkt.Get([]byte(id)) != nil {
+				return errors.Errorf("comment %s already in %s", id, req.To.URL)
+			}
+			oldRef := b.makeRef(comment)
+
+			comment.Locator = req.To
+			if !idsSet[comment.ParentID] {
+				comment.ParentID = "" // parent stays in source post
+			}
+			if dstPost.Title != "" {
+				comment.PostTitle = dstPost.Title
+			}
+			if e = b.save(dstBkt, id, comment); e != nil {
+				return errors.Wrapf(e, "failed to put key %s to bucket %s", id, req.To.URL)
+			}
+			if e = srcBkt.Delete([]byte(id)); e != nil {
+				return errors.Wrapf(e, "can't delete key %s from bucket %s", id, req.Locator.URL)
+			}
+			if e = b.moveRefs(tx, comment, oldRef); e != nil {
+				return e
+			}
+			if e = b.moveInfo(tx, req.Locator.URL, comment); e != nil {
+				return errors.Wrapf(e, "failed to set info for %s", req.To)
+			}
+			if e = b.touchPost(tx, comment); e != nil {
+				return errors.Wrapf(e, "failed to set post record for %s", req.To)
+			}
+			moved = append(moved, comment)
+		}
+
+		if k, _ := srcBkt.Cursor().First(); k == nil {
+			return b.removePost(tx, req.Locator.URL)
+		}
+		return b.resetTimes(tx, req.Locator.URL, srcBkt)
 	})
+	if err != nil {
+		return nil, err
+	}
+	return moved, nil
 }
 
 // Count returns number of comments for post or user
@@ -266,6 +464,7 @@ func (b *BoltDB) Count(req FindRequest) (count int, err error) {
 	if err != nil {
 		return 0, err
 	}
+	req.Locator = b.resolve(bdb, req.Locator)
 
 	if req.Locator.URL != "" { // comment's count for post
 		err = bdb.View(func(tx *bolt.Tx) error {
@@ -300,6 +499,7 @@ func (b *BoltDB) Info(req InfoRequest) ([]store.PostInfo, error) {
 	if err != nil {
 		return []store.PostInfo{}, err
 	}
+	req.Locator = b.resolve(bdb, req.Locator)
 
 	if req.Locator.URL != "" { // post info
 		info := store.PostInfo{}
@@ -405,6 +605,7 @@ func (b *BoltDB) Delete(req DeleteRequest) error {
 	if e != nil {
 		return e
 	}
+	req.Locator = b.resolve(bdb, req.Locator)
 
 	switch {
 	case req.UserDetail != "": // delete user detail
@@ -420,6 +621,28 @@ func (b *BoltDB) Delete(req DeleteRequest) error {
 	return errors.Errorf("invalid delete request %+v", req)
 }
 
+// Images returns ids of images referenced by comments of the site
+func (b *BoltDB) Images(req FindRequest) (ids []string, err error) {
+	bdb, err := b.db(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids = []string{}
+	err = bdb.View(func(tx *bolt.Tx) error {
+		imagesBkt := tx.Bucket([]byte(imagesBucketName))
+		return imagesBkt.ForEach(func(k, v []byte) error {
+			if imageBkt := imagesBkt.Bucket(k); v == nil && imageBkt != nil {
+				if ts, _ := imageBkt.Cursor().First(); ts != nil {
+					ids = append(ids, string(k))
+				}
+			}
+			return nil
+		})
+	})
+	return ids, err
+}
+
 // Close boltdb store
 func (b *BoltDB) Close() error {
 	errs := new(multierror.Error)
@@ -543,6 +766,40 @@ func (b *BoltDB) userComments(siteID, userID string, limit, skip int) (comments
 	return comments, err
 }
 
+// imageComments extracts all comments of the site referencing imageID
+// "images" bucket has sub-bucket for each imageID, and keeps it as ts:ref
+func (b *BoltDB) imageComments(siteID, imageID string) (comments []store.Comment, err error) {
+	comments = []store.Comment{}
+
+	bdb, err := b.db(siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bdb.View(func(tx *bolt.Tx) error {
+		imageBkt := tx.Bucket([]byte(imagesBucketName)).Bucket([]byte(imageID))
+		if imageBkt == nil {
+			return nil
+		}
+		return imageBkt.ForEach(func(k, v []byte) error {
+			url, commentID, e := b.parseRef(v)
+			if e != nil {
+				return errors.Wrapf(e, "can't parse reference %s", v)
+			}
+			postBkt, e := b.getPostBucket(tx, url)
+			if e != nil {
+				return nil
+			}
+			comment := store.Comment{}
+			if e = b.load(postBkt, commentID, &comment); e == nil {
+				comments = append(comments, comment)
+			}
+			return nil
+		})
+	})
+	return comments, err
+}
+
 func (b *BoltDB) checkFlag(req FlagRequest) (val bool) {
 
 	bdb, err := b.db(req.Locator.SiteID)
@@ -577,6 +834,13 @@ func (b *BoltDB) checkFlag(req FlagRequest) (val bool) {
 	}
 
 	_ = bdb.View(func(tx *bolt.Tx) error {
+		if req.Flag == ReadOnly && req.UserID == "" {
+			post := store.Post{}
+			if e := b.load(tx.Bucket([]byte(postMetaBucketName)), key, &post); e == nil && post.ReadOnly {
+				val = true
+				return nil
+			}
+		}
 		var bucket *bolt.Bucket
 		if bucket, err = b.flagBucket(tx, req.Flag); err != nil {
 			return err
@@ -599,6 +863,10 @@ func (b *BoltDB) setFlag(req FlagRequest) (res bool, err error) {
 	}
 
 	err = bdb.Update(func(tx *bolt.Tx) error {
+		if req.Flag == ReadOnly && req.UserID == "" {
+			res = req.Update == FlagTrue
+			return b.setReadOnly(tx, req.Locator, res)
+		}
 		var bucket *bolt.Bucket
 		if bucket, err = b.flagBucket(tx, req.Flag); err != nil {
 			return err
@@ -797,6 +1065,22 @@ func (b *BoltDB) deleteComment(bdb *bolt.DB, locator store.Locator, commentID st
 		if e = b.load(postBkt, commentID, &comment); e != nil {
 			return errors.Wrapf(e, "can't load key %s from bucket %s", commentID, locator.URL)
 		}
+		commentTs, ref := []byte(comment.Timestamp.Format(tsNano)), b.makeRef(comment)
+
+		// delete from user's bucket in hard mode, user cleared from comment
+		if userBkt := tx.Bucket([]byte(userBucketName)).Bucket([]byte(comment.User.ID)); mode == store.HardDelete && userBkt != nil {
+			if bytes.Equal(userBkt.Get(commentTs), ref) {
+				if e = userBkt.Delete(commentTs); e != nil {
+					return errors.Wrapf(e, "can't delete key %s from bucket %s", commentTs, comment.User.ID)
+				}
+			}
+		}
+
+		// delete from buckets of referenced images
+		if e = b.removeImageRefs(tx, comment); e != nil {
+			return e
+		}
+
 		// set deleted status and clear fields
 		comment.SetDeleted(mode)
 
@@ -804,10 +1088,12 @@ func (b *BoltDB) deleteComment(bdb *bolt.DB, locator store.Locator, commentID st
 			return errors.Wrapf(e, "can't save deleted comment for key %s from bucket %s", commentID, locator.URL)
 		}
 
-		// delete from "last" bucket
+		// delete from "last" bucket, keyed by comment's ts
 		lastBkt := tx.Bucket([]byte(lastBucketName))
-		if e = lastBkt.Delete([]byte(commentID)); e != nil {
-			return errors.Wrapf(e, "can't delete key %s from bucket %s", commentID, lastBucketName)
+		if bytes.Equal(lastBkt.Get(commentTs), ref) {
+			if e = lastBkt.Delete(commentTs); e != nil {
+				return errors.Wrapf(e, "can't delete key %s from bucket %s", commentTs, lastBucketName)
+			}
 		}
 
 		// decrement comments count for post url
@@ -823,7 +1109,8 @@ func (b *BoltDB) deleteComment(bdb *bolt.DB, locator store.Locator, commentID st
 func (b *BoltDB) deleteAll(bdb *bolt.DB, siteID string) error {
 
 	// delete all buckets except blocked users
-	toDelete := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName, infoBucketName}
+	toDelete := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName, infoBucketName,
+		postMetaBucketName, aliasesBucketName, imagesBucketName}
 
 	// delete top-level buckets
 	err := bdb.Update(func(tx *bolt.Tx) error {
@@ -913,6 +1200,268 @@ func (b *BoltDB) deleteUser(bdb *bolt.DB, siteID string, userID string, mode sto
 	return b.deleteUserDetail(bdb, userID, AllUserDetails)
 }
 
+// resolve replaces alias url of the locator with canonical url of the post
+func (b *BoltDB) resolve(bdb *bolt.DB, locator store.Locator) store.Locator {
+	if locator.URL == "" {
+		return locator
+	}
+	_ = bdb.View(func(tx *bolt.Tx) error {
+		locator.URL = b.resolveURL(tx, locator.URL)
+		return nil
+	})
+	return locator
+}
+
+// resolveURL returns canonical url of the post for alias, or url itself
+func (b *BoltDB) resolveURL(tx *bolt.Tx, url string) string {
+	if v := tx.Bucket([]byte(aliasesBucketName)).Get([]byte(url)); v != nil {
+		return string(v)
+	}
+	return url
+}
+
+// loadPost gets post record, for posts without record makes it from post info and legacy read-only flag
+func (b *BoltDB) loadPost(tx *bolt.Tx, locator store.Locator) (store.Post, error) {
+	post := store.Post{}
+	if err := b.load(tx.Bucket([]byte(postMetaBucketName)), locator.URL, &post); err == nil {
+		post.ReadOnly = post.ReadOnly || tx.Bucket([]byte(readonlyBucketName)).Get([]byte(locator.URL)) != nil
+		return post, nil
+	}
+
+	info := store.PostInfo{}
+	if err := b.load(tx.Bucket([]byte(infoBucketName)), locator.URL, &info); err != nil {
+		return store.Post{}, errors.Errorf("no post %s", locator.URL)
+	}
+	post = store.Post{Locator: locator, Created: info.FirstTS}
+	post.ReadOnly = tx.Bucket([]byte(readonlyBucketName)).Get([]byte(locator.URL)) != nil
+	return post, nil
+}
+
+// savePost saves post record and its aliases. Alias can't be the url of another post or alias of another post.
+// Aliases removed from the record dropped. Should run in update tx
+func (b *BoltDB) savePost(tx *bolt.Tx, post store.Post) (store.Post, error) {
+	metaBkt, aliasesBkt := tx.Bucket([]byte(postMetaBucketName)), tx.Bucket([]byte(aliasesBucketName))
+	if v := aliasesBkt.Get([]byte(post.Locator.URL)); v != nil {
+		return post, errors.Errorf("url %s is alias of post %s", post.Locator.URL, string(v))
+	}
+
+	prev := store.Post{}
+	if err := b.load(metaBkt, post.Locator.URL, &prev); err == nil {
+		if post.Created.IsZero() {
+			post.Created = prev.Created
+		}
+		for _, a := range prev.Aliases {
+			if post.HasURL(a) {
+				continue
+			}
+			if err = aliasesBkt.Delete([]byte(a)); err != nil {
+				return post, errors.Wrapf(err, "failed to delete alias %s", a)
+			}
+		}
+	}
+	if post.Created.IsZero() {
+		post.Created = time.Now()
+	}
+
+	aliases := []string{}
+	for _, a := range post.Aliases {
+		if a == "" || a == post.Locator.URL || store.Contains(aliases, a) {
+			continue
+		}
+		if v := aliasesBkt.Get([]byte(a)); v != nil && string(v) != post.Locator.URL {
+			return post, errors.Errorf("alias %s already used by post %s", a, string(v))
+		}
+		if metaBkt.Get([]byte(a)) != nil || tx.Bucket([]byte(postsBucketName)).Bucket([]byte(a)) != nil {
+			return post, errors.Errorf("alias %s is url of another post", a)
+		}
+		if err := aliasesBkt.Put([]byte(a), []byte(post.Locator.URL)); err != nil {
+			return post, errors.Wrapf(err, "failed to put alias %s", a)
+		}
+		aliases = append(aliases, a)
+	}
+	post.Aliases = aliases
+	if len(post.Aliases) == 0 {
+		post.Aliases = nil
+	}
+	return post, b.save(metaBkt, post.Locator.URL, post)
+}
+
+// touchPost makes post record for the comment if missing and sets post title from the comment if not set yet
+func (b *BoltDB) touchPost(tx *bolt.Tx, comment store.Comment) error {
+	metaBkt := tx.Bucket([]byte(postMetaBucketName))
+	post := store.Post{}
+	if err := b.load(metaBkt, comment.Locator.URL, &post); err != nil {
+		post = store.Post{Locator: comment.Locator, Created: comment.Timestamp}
+	} else if post.Title != "" || comment.PostTitle == "" {
+		return nil
+	}
+	post.Title = comment.PostTitle
+	return b.save(metaBkt, comment.Locator.URL, post)
+}
+
+// setReadOnly sets read-only status in post record, record made if missing. Drops legacy status
+func (b *BoltDB) setReadOnly(tx *bolt.Tx, locator store.Locator, status bool) error {
+	post, err := b.loadPost(tx, locator)
+	if err != nil {
+		post = store.Post{Locator: locator, Created: time.Now()}
+	}
+	post.ReadOnly = status
+	if err = b.save(tx.Bucket([]byte(postMetaBucketName)), locator.URL, post); err != nil {
+		return errors.Wrapf(err, "failed to set read-only for %s", locator.URL)
+	}
+	if err = tx.Bucket([]byte(readonlyBucketName)).Delete([]byte(locator.URL)); err != nil {
+		return errors.Wrapf(err, "failed to clean read-only flag for %s", locator.URL)
+	}
+	return nil
+}
+
+// moveRefs replaces references to moved comment in "last", user's and images buckets. Should run in update tx
+func (b *BoltDB) moveRefs(tx *bolt.Tx, comment store.Comment, oldRef []byte) error {
+	commentTs, ref := []byte(comment.Timestamp.Format(tsNano)), b.makeRef(comment)
+
+	lastBkt := tx.Bucket([]byte(lastBucketName))
+	if bytes.Equal(lastBkt.Get(commentTs), oldRef) {
+		if err := lastBkt.Put(commentTs, ref); err != nil {
+			return errors.Wrapf(err, "can't put reference %s to %s", ref, lastBucketName)
+		}
+	}
+
+	userBkt := tx.Bucket([]byte(userBucketName)).Bucket([]byte(comment.User.ID))
+	if userBkt != nil && bytes.Equal(userBkt.Get(commentTs), oldRef) {
+		if err := userBkt.Put(commentTs, ref); err != nil {
+			return errors.Wrapf(err, "failed to put user comment %s for %s", comment.ID, comment.User.ID)
+		}
+	}
+
+	imagesBkt := tx.Bucket([]byte(imagesBucketName))
+	for _, id := range comment.Images {
+		imageBkt := imagesBkt.Bucket([]byte(id))
+		if imageBkt != nil && bytes.Equal(imageBkt.Get(commentTs), oldRef) {
+			if err := imageBkt.Put(commentTs, ref); err != nil {
+				return errors.Wrapf(err, "failed to put image reference %s for %s", ref, id)
+			}
+		}
+	}
+	return nil
+}
+
+// putImageRefs adds references to the comment to buckets of images referenced by it, deleted comments
+// not referenced. Should run in update tx
+func (b *BoltDB) putImageRefs(tx *bolt.Tx, comment store.Comment) error {
+	if comment.Deleted {
+		return nil
+	}
+	commentTs, ref := []byte(comment.Timestamp.Format(tsNano)), b.makeRef(comment)
+	for _, id := range comment.Images {
+		imageBkt, err := b.getImageBucket(tx, id)
+		if err != nil {
+			return err
+		}
+		if err = imageBkt.Put(commentTs, ref); err != nil {
+			return errors.Wrapf(err, "failed to put image reference %s for %s", ref, id)
+		}
+	}
+	return nil
+}
+
+// removeImageRefs removes references to the comment from buckets of images referenced by it, bucket of the image
+// removed with the last reference. Should run in update tx
+func (b *BoltDB) removeImageRefs(tx *bolt.Tx, comment store.Comment) error {
+	commentTs, ref := []byte(comment.Timestamp.Format(tsNano)), b.makeRef(comment)
+	imagesBkt := tx.Bucket([]byte(imagesBucketName))
+	for _, id := range comment.Images {
+		imageBkt := imagesBkt.Bucket([]byte(id))
+		if imageBkt == nil || !bytes.Equal(imageBkt.Get(commentTs), ref) {
+			continue
+		}
+		if err := imageBkt.Delete(commentTs); err != nil {
+			return errors.Wrapf(err, "can't delete key %s from bucket of image %s", commentTs, id)
+		}
+		if k, _ := imageBkt.Cursor().First(); k == nil {
+			if err := imagesBkt.DeleteBucket([]byte(id)); err != nil {
+				return errors.Wrapf(err, "can't delete bucket of image %s", id)
+			}
+		}
+	}
+	return nil
+}
+
+// moveInfo moves count of the comment from post fromURL to comment's post, deleted comments aren't counted.
+// Info of the target post made if missing. Should run in update tx
+func (b *BoltDB) moveInfo(tx *bolt.Tx, fromURL string, comment store.Comment) error {
+	if !comment.Deleted {
+		if _, err := b.count(tx, fromURL, -1); err != nil {
+			return err
+		}
+	}
+
+	infoBkt := tx.Bucket([]byte(infoBucketName))
+	info := store.PostInfo{}
+	if err := b.load(infoBkt, comment.Locator.URL, &info); err != nil {
+		info = store.PostInfo{URL: comment.Locator.URL, FirstTS: comment.Timestamp, LastTS: comment.Timestamp}
+	}
+	if !comment.Deleted {
+		info.Count++
+	}
+	if comment.Timestamp.Before(info.FirstTS) {
+		info.FirstTS = comment.Timestamp
+	}
+	if comment.Timestamp.After(info.LastTS) {
+		info.LastTS = comment.Timestamp
+	}
+	return b.save(infoBkt, comment.Locator.URL, &info)
+}
+
+// resetTimes sets times of the first and the last comments in info of the post from comments left in its bucket.
+// Should run in update tx
+func (b *BoltDB) resetTimes(tx *bolt.Tx, postURL string, postBkt *bolt.Bucket) error {
+	infoBkt := tx.Bucket([]byte(infoBucketName))
+	info := store.PostInfo{}
+	if err := b.load(infoBkt, postURL, &info); err != nil {
+		info = store.PostInfo{URL: postURL}
+	}
+	info.FirstTS, info.LastTS = time.Time{}, time.Time{}
+	err := postBkt.ForEach(func(k, v []byte) error {
+		comment := store.Comment{}
+		if e := json.Unmarshal(v, &comment); e != nil {
+			return errors.Wrapf(e, "can't unmarshal comment %s", k)
+		}
+		if info.FirstTS.IsZero() || comment.Timestamp.Before(info.FirstTS) {
+			info.FirstTS = comment.Timestamp
+		}
+		if comment.Timestamp.After(info.LastTS) {
+			info.LastTS = comment.Timestamp
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(b.save(infoBkt, postURL, &info), "failed to set info for %s", postURL)
+}
+
+// removePost removes post bucket, info, post record with aliases and legacy read-only flag of the post.
+// Should run in update tx
+func (b *BoltDB) removePost(tx *bolt.Tx, postURL string) error {
+	post := store.Post{}
+	if err := b.load(tx.Bucket([]byte(postMetaBucketName)), postURL, &post); err == nil {
+		for _, a := range post.Aliases {
+			if err = tx.Bucket([]byte(aliasesBucketName)).Delete([]byte(a)); err != nil {
+				return errors.Wrapf(err, "failed to delete alias %s", a)
+			}
+		}
+	}
+	if err := tx.Bucket([]byte(postsBucketName)).DeleteBucket([]byte(postURL)); err != nil {
+		return errors.Wrapf(err, "failed to delete bucket %s", postURL)
+	}
+	for _, bktName := range []string{infoBucketName, postMetaBucketName, readonlyBucketName} {
+		if err := tx.Bucket([]byte(bktName)).Delete([]byte(postURL)); err != nil {
+			return errors.Wrapf(err, "failed to delete %s from %s", postURL, bktName)
+		}
+	}
+	return nil
+}
+
 // getPostBucket return bucket with all comments for postURL
 func (b *BoltDB) getPostBucket(tx *bolt.Tx, postURL string) (*bolt.Bucket, error) {
 	postsBkt := tx.Bucket([]byte(postsBucketName))
@@ -948,6 +1497,16 @@ func (b *BoltDB) getUserBucket(tx *bolt.Tx, userID string) (*bolt.Bucket, error)
 	return userIDBkt, nil
 }
 
+// getImageBucket return bucket with references to comments of imageID, makes it if missing
+func (b *BoltDB) getImageBucket(tx *bolt.Tx, imageID string) (*bolt.Bucket, error) {
+	imagesBkt := tx.Bucket([]byte(imagesBucketName))
+	imageIDBkt, e := imagesBkt.CreateBucketIfNotExists([]byte(imageID))
+	if e != nil {
+		return nil, errors.Wrapf(e, "can't get bucket %s", imageID)
+	}
+	return imageIDBkt, nil
+}
+
 // save marshaled value to key for bucket. Should run in update tx
 func (b *BoltDB) save(bkt *bolt.Bucket, key string, value interface{}) (err error) {
 	if value == nil {
@@ -0,0 +1,458 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+// CheckReport is a result of Check, lists discrepancies of derived buckets with "posts" bucket
+type CheckReport struct {
+	SiteID   string
+	Posts    int
+	Comments int
+	Problems []string
+	Repaired bool
+}
+
+func (r CheckReport) String() string {
+	return fmt.Sprintf("site %s, posts %d, comments %d, problems %d, repaired %v",
+		r.SiteID, r.Posts, r.Comments, len(r.Problems), r.Repaired)
+}
+
+// expected content of derived buckets, made from "posts" bucket and "aliases" made from post records
+type derivedBuckets struct {
+	last    map[string]string            // ts:ref of not deleted comments
+	users   map[string]map[string]string // userID:ts:ref of comments, except hard-deleted
+	images  map[string]map[string]string // imageID:ts:ref of not deleted comments
+	info    map[string]store.PostInfo    // url:info of posts
+	aliases map[string]string            // alias:url of post records
+}
+
+// Check verifies "last", "users", "images" and "info" buckets of the site against "posts" bucket, and "aliases" bucket
+// against post records. References to missing or deleted comments, missing references, wrong counts and times
+// of posts, aliases of missing posts and aliases not in post records reported as problems. With repair set
+// derived buckets rebuilt from "posts" bucket and aliases from post records. Comments can't be decoded and
+// records of posts without comments reported but not changed, as record can be set before the first comment.
+func (b *BoltDB) Check(siteID string, repair bool) (report CheckReport, err error) {
+	bdb, err := b.db(siteID)
+	if err != nil {
+		return report, err
+	}
+	report.SiteID = siteID
+
+	check := func(tx *bolt.Tx) error {
+		expected, e := b.derivedBuckets(tx, &report)
+		if e != nil {
+			return e
+		}
+		b.checkLast(tx, expected, &report)
+		b.checkNested(tx, userBucketName, expected.users, &report)
+		b.checkNested(tx, imagesBucketName, expected.images, &report)
+		b.checkInfo(tx, expected, &report)
+		b.checkAliases(tx, expected, &report)
+		if !repair || len(report.Problems) == 0 {
+			return nil
+		}
+		if e = b.rebuild(tx, expected); e != nil {
+			return e
+		}
+		report.Repaired = true
+		return nil
+	}
+
+	if repair {
+		err = bdb.Update(check)
+	} else {
+		err = bdb.View(check)
+	}
+	return report, errors.Wrapf(err, "can't check site %s", siteID)
+}
+
+// derivedBuckets walks all comments of "posts" bucket and makes expected content of derived buckets
+func (b *BoltDB) derivedBuckets(tx *bolt.Tx, report *CheckReport) (derivedBuckets, error) {
+	res := derivedBuckets{last: map[string]string{}, users: map[string]map[string]string{},
+		images: map[string]map[string]string{}, info: map[string]store.PostInfo{}, aliases: map[string]string{}}
+	postsBkt := tx.Bucket([]byte(postsBucketName))
+	if postsBkt == nil {
+		return res, errors.Errorf("no bucket %s", postsBucketName)
+	}
+
+	err := postsBkt.ForEach(func(k, v []byte) error {
+		postURL := string(k)
+		postBkt := postsBkt.Bucket(k)
+		if v != nil || postBkt == nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("posts: unexpected key %s", postURL))
+			return nil
+		}
+		report.Posts++
+		info := store.PostInfo{URL: postURL}
+		err := postBkt.ForEach(func(id, data []byte) error {
+			comment := store.Comment{}
+			if e := json.Unmarshal(data, &comment); e != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("posts: can't decode comment %s of %s, %v", id, postURL, e))
+				return nil
+			}
+			report.Comments++
+			if info.FirstTS.IsZero() || comment.Timestamp.Before(info.FirstTS) {
+				info.FirstTS = comment.Timestamp
+			}
+			if comment.Timestamp.After(info.LastTS) {
+				info.LastTS = comment.Timestamp
+			}
+
+			// comment located by its bucket and key, reference made from them even if comment's fields differ
+			comment.Locator.URL, comment.ID = postURL, string(id)
+			ts, ref := comment.Timestamp.Format(tsNano), string(b.makeRef(comment))
+			if !comment.Deleted {
+				info.Count++
+				res.last[ts] = ref
+				for _, imageID := range comment.Images {
+					if res.images[imageID] == nil {
+						res.images[imageID] = map[string]string{}
+					}
+					res.images[imageID][ts] = ref
+				}
+			}
+			if comment.User.ID != "" && (!comment.Deleted || comment.User.ID != "deleted") { // hard-deleted has no user
+				if res.users[comment.User.ID] == nil {
+					res.users[comment.User.ID] = map[string]string{}
+				}
+				res.users[comment.User.ID][ts] = ref
+			}
+			return nil
+		})
+		res.info[postURL] = info
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+	return res, b.postAliases(tx, res, report)
+}
+
+// postAliases checks post records and puts their aliases to expected content of "aliases" bucket
+func (b *BoltDB) postAliases(tx *bolt.Tx, expected derivedBuckets, report *CheckReport) error {
+	metaBkt := tx.Bucket([]byte(postMetaBucketName))
+	if metaBkt == nil {
+		return errors.Errorf("no bucket %s", postMetaBucketName)
+	}
+	postsBkt := tx.Bucket([]byte(postsBucketName))
+	return metaBkt.ForEach(func(k, v []byte) error {
+		postURL := string(k)
+		post := store.Post{}
+		if e := json.Unmarshal(v, &post); e != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("post_meta: can't decode record %s, %v", postURL, e))
+			return nil
+		}
+		if postsBkt.Bucket(k) == nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("post_meta: record %s of unknown post", postURL))
+		}
+		for _, a := range post.Aliases {
+			if other, ok := expected.aliases[a]; ok {
+				report.Problems = append(report.Problems, fmt.Sprintf("post_meta: alias %s of %s and %s", a, other, postURL))
+				continue
+			}
+			expected.aliases[a] = postURL
+		}
+		return nil
+	})
+}
+
+// checkLast compares "last" bucket with expected references
+func (b *BoltDB) checkLast(tx *bolt.Tx, expected derivedBuckets, report *CheckReport) {
+	problems := diffRefs(tx.Bucket([]byte(lastBucketName)), expected.last)
+	for _, p := range problems {
+		report.Problems = append(report.Problems, "last: "+p)
+	}
+}
+
+// checkNested compares nested buckets of "users" or "images" bucket with expected references of each user or image
+func (b *BoltDB) checkNested(tx *bolt.Tx, name string, expected map[string]map[string]string, report *CheckReport) {
+	topBkt := tx.Bucket([]byte(name))
+	seen := map[string]bool{}
+	if topBkt != nil {
+		_ = topBkt.ForEach(func(k, v []byte) error {
+			key := string(k)
+			if v != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s: unexpected key %s", name, key))
+				return nil
+			}
+			seen[key] = true
+			for _, p := range diffRefs(topBkt.Bucket(k), expected[key]) {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s/%s: %s", name, key, p))
+			}
+			return nil
+		})
+	}
+	missing := []string{}
+	for key, refs := range expected {
+		if !seen[key] {
+			missing = append(missing, fmt.Sprintf("%s: missing bucket %s with %d references", name, key, len(refs)))
+		}
+	}
+	sort.Strings(missing)
+	report.Problems = append(report.Problems, missing...)
+}
+
+// checkInfo compares counts of "info" bucket with numbers of not deleted comments of posts,
+// and times of the first and the last comments with times of all comments of posts
+func (b *BoltDB) checkInfo(tx *bolt.Tx, expected derivedBuckets, report *CheckReport) {
+	infoBkt := tx.Bucket([]byte(infoBucketName))
+	seen := map[string]bool{}
+	if infoBkt != nil {
+		_ = infoBkt.ForEach(func(k, v []byte) error {
+			postURL := string(k)
+			seen[postURL] = true
+			exp, ok := expected.info[postURL]
+			if !ok {
+				report.Problems = append(report.Problems, fmt.Sprintf("info: ghost entry %s without post", postURL))
+				return nil
+			}
+			info := store.PostInfo{}
+			if err := json.Unmarshal(v, &info); err != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("info: can't decode entry %s, %v", postURL, err))
+				return nil
+			}
+			if info.Count != exp.Count {
+				report.Problems = append(report.Problems, fmt.Sprintf("info: count %d for %s, expected %d",
+					info.Count, postURL, exp.Count))
+			}
+			if !info.FirstTS.Equal(exp.FirstTS) || !info.LastTS.Equal(exp.LastTS) {
+				report.Problems = append(report.Problems, fmt.Sprintf("info: times %s - %s for %s, expected %s - %s",
+					info.FirstTS.Format(tsNano), info.LastTS.Format(tsNano), postURL,
+					exp.FirstTS.Format(tsNano), exp.LastTS.Format(tsNano)))
+			}
+			return nil
+		})
+	}
+	missing := []string{}
+	for postURL := range expected.info {
+		if !seen[postURL] {
+			missing = append(missing, fmt.Sprintf("info: missing entry %s", postURL))
+		}
+	}
+	sort.Strings(missing)
+	report.Problems = append(report.Problems, missing...)
+}
+
+// checkAliases compares "aliases" bucket with aliases of post records
+func (b *BoltDB) checkAliases(tx *bolt.Tx, expected derivedBuckets, report *CheckReport) {
+	aliasesBkt := tx.Bucket([]byte(aliasesBucketName))
+	metaBkt := tx.Bucket([]byte(postMetaBucketName))
+	seen := map[string]bool{}
+	if aliasesBkt != nil {
+		_ = aliasesBkt.ForEach(func(k, v []byte) error {
+			alias := string(k)
+			seen[alias] = true
+			switch exp, ok := expected.aliases[alias]; {
+			case metaBkt.Get(v) == nil && tx.Bucket([]byte(postsBucketName)).Bucket(v) == nil:
+				report.Problems = append(report.Problems, fmt.Sprintf("aliases: alias %s of missing post %s", alias, v))
+			case !ok:
+				report.Problems = append(report.Problems, fmt.Sprintf("aliases: alias %s of %s not in post record", alias, v))
+			case exp != string(v):
+				report.Problems = append(report.Problems, fmt.Sprintf("aliases: alias %s of %s, expected %s", alias, v, exp))
+			}
+			return nil
+		})
+	}
+	missing := []string{}
+	for alias, postURL := range expected.aliases {
+		if !seen[alias] {
+			missing = append(missing, fmt.Sprintf("aliases: missing alias %s of %s", alias, postURL))
+		}
+	}
+	sort.Strings(missing)
+	report.Problems = append(report.Problems, missing...)
+}
+
+// rebuild replaces "last", "users", "images", "info" and "aliases" buckets with expected content
+func (b *BoltDB) rebuild(tx *bolt.Tx, expected derivedBuckets) error {
+	for _, name := range []string{lastBucketName, userBucketName, imagesBucketName, infoBucketName, aliasesBucketName} {
+		if err := tx.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+			return errors.Wrapf(err, "failed to delete top level bucket %s", name)
+		}
+		if _, err := tx.CreateBucket([]byte(name)); err != nil {
+			return errors.Wrapf(err, "failed to create top level bucket %s", name)
+		}
+	}
+
+	lastBkt := tx.Bucket([]byte(lastBucketName))
+	for ts, ref := range expected.last {
+		if err := lastBkt.Put([]byte(ts), []byte(ref)); err != nil {
+			return errors.Wrapf(err, "can't put reference %s to %s", ref, lastBucketName)
+		}
+	}
+	for userID, refs := range expected.users {
+		userBkt, err := b.getUserBucket(tx, userID)
+		if err != nil {
+			return err
+		}
+		for ts, ref := range refs {
+			if err = userBkt.Put([]byte(ts), []byte(ref)); err != nil {
+				return errors.Wrapf(err, "failed to put user comment %s for %s", ref, userID)
+			}
+		}
+	}
+	for imageID, refs := range expected.images {
+		imageBkt, err := b.getImageBucket(tx, imageID)
+		if err != nil {
+			return err
+		}
+		for ts, ref := range refs {
+			if err = imageBkt.Put([]byte(ts), []byte(ref)); err != nil {
+				return errors.Wrapf(err, "failed to put image reference %s for %s", ref, imageID)
+			}
+		}
+	}
+	infoBkt := tx.Bucket([]byte(infoBucketName))
+	for postURL, info := range expected.info {
+		if err := b.save(infoBkt, postURL, info); err != nil {
+			return errors.Wrapf(err, "failed to set info for %s", postURL)
+		}
+	}
+	aliasesBkt := tx.Bucket([]byte(aliasesBucketName))
+	for alias, postURL := range expected.aliases {
+		if err := aliasesBkt.Put([]byte(alias), []byte(postURL)); err != nil {
+			return errors.Wrapf(err, "failed to put alias %s", alias)
+		}
+	}
+	log.Printf("[INFO] rebuilt %s, %s, %s, %s and %s buckets", lastBucketName, userBucketName, imagesBucketName,
+		infoBucketName, aliasesBucketName)
+	return nil
+}
+
+// diffRefs compares ts:ref bucket with expected references, returns problems sorted by ts
+func diffRefs(bkt *bolt.Bucket, expected map[string]string) []string {
+	type problem struct{ ts, msg string }
+	problems := []problem{}
+	seen := map[string]bool{}
+	if bkt != nil {
+		_ = bkt.ForEach(func(k, v []byte) error {
+			ts := string(k)
+			seen[ts] = true
+			switch exp, ok := expected[ts]; {
+			case !ok:
+				problems = append(problems, problem{ts, fmt.Sprintf("dangling reference %s at %s", v, ts)})
+			case !bytes.Equal(v, []byte(exp)):
+				problems = append(problems, problem{ts, fmt.Sprintf("reference %s at %s, expected %s", v, ts, exp)})
+			}
+			return nil
+		})
+	}
+	for ts, ref := range expected {
+		if !seen[ts] {
+			problems = append(problems, problem{ts, fmt.Sprintf("missing reference %s at %s", ref, ts)})
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].ts < problems[j].ts })
+	res := make([]string, len(problems))
+	for i, p := range problems {
+		res[i] = p.msg
+	}
+	return res
+}
+
+// CompactBolt copies all buckets of src bolt file to new dst file, fully packed. Writes committed every txMaxSize
+// bytes of keys and values to limit memory usage, all in one transaction if txMaxSize is 0
+func CompactBolt(src, dst string, txMaxSize int64, timeout time.Duration) (err error) {
+	if _, err = os.Stat(dst); err == nil {
+		return errors.Errorf("compacted file %s already exists", dst)
+	}
+	srcDB, err := bolt.Open(src, 0600, &bolt.Options{ReadOnly: true, Timeout: timeout})
+	if err != nil {
+		return errors.Wrapf(err, "can't open %s", src)
+	}
+	defer srcDB.Close() // nolint
+	dstDB, err := bolt.Open(dst, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return errors.Wrapf(err, "can't open %s", dst)
+	}
+	defer func() {
+		if e := dstDB.Close(); e != nil && err == nil {
+			err = errors.Wrapf(e, "can't close %s", dst)
+		}
+	}()
+
+	tx, err := dstDB.Begin(true)
+	if err != nil {
+		return errors.Wrapf(err, "can't begin transaction for %s", dst)
+	}
+	defer func() { _ = tx.Rollback() }() // no-op for committed
+
+	var size int64
+	err = srcDB.View(func(srcTx *bolt.Tx) error {
+		return srcTx.ForEach(func(name []byte, bkt *bolt.Bucket) error {
+			return walkBucket(bkt, nil, name, nil, bkt.Sequence(), func(path [][]byte, k, v []byte, seq uint64) error {
+				if sz := int64(len(k) + len(v)); txMaxSize > 0 && size+sz > txMaxSize {
+					if e := tx.Commit(); e != nil {
+						return e
+					}
+					if tx, err = dstDB.Begin(true); err != nil {
+						return err
+					}
+					size = 0
+				}
+				size += int64(len(k) + len(v))
+				return copyKey(tx, path, k, v, seq)
+			})
+		})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "can't copy %s to %s", src, dst)
+	}
+	return errors.Wrapf(tx.Commit(), "can't commit %s", dst)
+}
+
+// walkBucket calls fn for key of bucket given by path, and recursively for all keys and nested buckets under it.
+// Value of nested bucket is nil
+func walkBucket(bkt *bolt.Bucket, path [][]byte, k, v []byte, seq uint64,
+	fn func(path [][]byte, k, v []byte, seq uint64) error) error {
+	if err := fn(path, k, v, seq); err != nil {
+		return err
+	}
+	if v != nil {
+		return nil
+	}
+	nested := append(append([][]byte{}, path...), k)
+	return bkt.ForEach(func(k, v []byte) error {
+		if v == nil {
+			child := bkt.Bucket(k)
+			return walkBucket(child, nested, k, nil, child.Sequence(), fn)
+		}
+		return walkBucket(bkt, nested, k, v, bkt.Sequence(), fn)
+	})
+}
+
+// copyKey puts key, or creates nested bucket if v is nil, to the bucket given by path
+func copyKey(tx *bolt.Tx, path [][]byte, k, v []byte, seq uint64) error {
+	if len(path) == 0 { // top level bucket
+		bkt, err := tx.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return bkt.SetSequence(seq)
+	}
+	bkt := tx.Bucket(path[0])
+	for _, name := range path[1:] {
+		bkt = bkt.Bucket(name)
+	}
+	bkt.FillPercent = 1.0
+	if v == nil {
+		nested, err := bkt.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return nested.SetSequence(seq)
+	}
+	return bkt.Put(k, v)
+}
@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+func TestBoltDB_Check(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	// engine operations keep derived buckets consistent
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{ID: "id-3", Text: "text 3", Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2"},
+		Images: []string{"user2/pic1.png"}})
+	require.NoError(t, err)
+	require.NoError(t, b.Delete(DeleteRequest{Locator: locator, CommentID: "id-1", DeleteMode: store.SoftDelete}))
+	require.NoError(t, b.Delete(DeleteRequest{Locator: locator, CommentID: "id-2", DeleteMode: store.HardDelete}))
+	_, err = b.Post(PostRequest{Locator: locator, Update: &store.Post{Aliases: []string{"https://radio-t.com/a1", "https://radio-t.com/a2"}}})
+	require.NoError(t, err)
+	report, err := b.Check("radio-t", false)
+	require.NoError(t, err)
+	assert.Equal(t, CheckReport{SiteID: "radio-t", Posts: 2, Comments: 3}, report)
+	assert.Equal(t, "site radio-t, posts 2, comments 3, problems 0, repaired false", report.String())
+
+	ts1 := time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local).Format(tsNano)
+	ts3 := time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local).Format(tsNano)
+	err = b.dbs["radio-t"].Update(func(tx *bolt.Tx) error {
+		last := tx.Bucket([]byte(lastBucketName))
+		require.NoError(t, last.Put([]byte(ts1), []byte("https://radio-t.com!!id-1")))
+		require.NoError(t, last.Delete([]byte(ts3)))
+		require.NoError(t, tx.Bucket([]byte(userBucketName)).Bucket([]byte("user1")).Put([]byte("ts"), []byte("https://radio-t.com!!id-9")))
+		require.NoError(t, tx.Bucket([]byte(userBucketName)).DeleteBucket([]byte("user2")))
+		require.NoError(t, tx.Bucket([]byte(imagesBucketName)).DeleteBucket([]byte("user2/pic1.png")))
+		imageBkt, e := b.getImageBucket(tx, "user1/pic2.png")
+		require.NoError(t, e)
+		require.NoError(t, imageBkt.Put([]byte(ts1), []byte("https://radio-t.com!!id-1")))
+		_, e = b.count(tx, "https://radio-t.com", 2)
+		require.NoError(t, e)
+		_, e = b.count(tx, "https://radio-t.com/ghost", 1)
+		require.NoError(t, e)
+		info := store.PostInfo{URL: "https://radio-t.com/2", Count: 1, FirstTS: time.Date(2017, 12, 20, 15, 18, 24, 0, time.UTC),
+			LastTS: time.Date(2017, 12, 21, 15, 18, 24, 0, time.UTC)}
+		require.NoError(t, b.save(tx.Bucket([]byte(infoBucketName)), "https://radio-t.com/2", info))
+		require.NoError(t, b.save(tx.Bucket([]byte(postMetaBucketName)), "https://radio-t.com/unknown",
+			store.Post{Locator: store.Locator{URL: "https://radio-t.com/unknown", SiteID: "radio-t"}}))
+		aliases := tx.Bucket([]byte(aliasesBucketName))
+		require.NoError(t, aliases.Delete([]byte("https://radio-t.com/a2")))
+		require.NoError(t, aliases.Put([]byte("https://radio-t.com/a3"), []byte("https://radio-t.com/2")))
+		return aliases.Put([]byte("https://radio-t.com/a4"), []byte("https://radio-t.com/gone"))
+	})
+	require.NoError(t, err)
+
+	expected := []string{
+		"post_meta: record https://radio-t.com/unknown of unknown post",
+		fmt.Sprintf("last: dangling reference https://radio-t.com!!id-1 at %s", ts1),
+		fmt.Sprintf("last: missing reference https://radio-t.com/2!!id-3 at %s", ts3),
+		"users/user1: dangling reference https://radio-t.com!!id-9 at ts",
+		"users: missing bucket user2 with 1 references",
+		fmt.Sprintf("images/user1/pic2.png: dangling reference https://radio-t.com!!id-1 at %s", ts1),
+		"images: missing bucket user2/pic1.png with 1 references",
+		"info: count 2 for https://radio-t.com, expected 0",
+		fmt.Sprintf("info: times 2017-12-20T15:18:24.000000000Z - 2017-12-21T15:18:24.000000000Z for https://radio-t.com/2, "+
+			"expected %s - %s", ts3, ts3),
+		"info: ghost entry https://radio-t.com/ghost without post",
+		"aliases: alias https://radio-t.com/a3 of https://radio-t.com/2 not in post record",
+		"aliases: alias https://radio-t.com/a4 of missing post https://radio-t.com/gone",
+		"aliases: missing alias https://radio-t.com/a2 of https://radio-t.com",
+	}
+	report, err = b.Check("radio-t", false)
+	require.NoError(t, err)
+	assert.Equal(t, expected, report.Problems)
+	assert.False(t, report.Repaired)
+
+	report, err = b.Check("radio-t", true)
+	require.NoError(t, err)
+	assert.Equal(t, expected, report.Problems)
+	assert.True(t, report.Repaired)
+
+	report, err = b.Check("radio-t", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"post_meta: record https://radio-t.com/unknown of unknown post"}, report.Problems,
+		"record of post without comments kept")
+
+	err = b.dbs["radio-t"].Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(postMetaBucketName)).Delete([]byte("https://radio-t.com/unknown"))
+	})
+	require.NoError(t, err)
+	report, err = b.Check("radio-t", true)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+	assert.False(t, report.Repaired, "nothing to repair")
+
+	posts, err := b.Post(PostRequest{Locator: store.Locator{URL: "https://radio-t.com/a2", SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, "https://radio-t.com", posts[0].Locator.URL, "alias restored")
+	_, err = b.Post(PostRequest{Locator: store.Locator{URL: "https://radio-t.com/a4", SiteID: "radio-t"}})
+	assert.Error(t, err, "alias of missing post removed")
+
+	last, err := b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(last))
+	assert.Equal(t, "id-3", last[0].ID)
+	user, err := b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user2"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(user))
+	images, err := b.Images(FindRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user2/pic1.png"}, images)
+	info, err := b.Info(InfoRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(info))
+	assert.Equal(t, store.PostInfo{URL: "https://radio-t.com/2", Count: 1, FirstTS: last[0].Timestamp, LastTS: last[0].Timestamp}, info[0])
+	assert.Equal(t, 0, info[1].Count)
+
+	_, err = b.Check("bad", false)
+	assert.EqualError(t, err, `site "bad" not found`)
+}
+
+func TestCompactBolt(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+	for i := 0; i < 100; i++ {
+		_, err := b.Create(store.Comment{ID: fmt.Sprintf("c-%d", i), Text: "some text", Timestamp: time.Now().Add(time.Duration(i)),
+			Locator: store.Locator{URL: fmt.Sprintf("https://radio-t.com/%d", i%10), SiteID: "radio-t"}, User: store.User{ID: "user1"}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.Close())
+
+	dst := testDb + ".compact"
+	defer os.Remove(dst)
+	require.NoError(t, CompactBolt(testDb, dst, 1024, time.Second))
+	err := CompactBolt(testDb, dst, 0, time.Second)
+	assert.EqualError(t, err, "compacted file "+dst+" already exists")
+
+	srcInfo, err := os.Stat(testDb)
+	require.NoError(t, err)
+	dstInfo, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.True(t, dstInfo.Size() < srcInfo.Size(), "%d < %d", dstInfo.Size(), srcInfo.Size())
+
+	compacted, err := NewBoltDB(bolt.Options{}, BoltSite{FileName: dst, SiteID: "radio-t"})
+	require.NoError(t, err)
+	defer compacted.Close() // nolint
+	report, err := compacted.Check("radio-t", false)
+	require.NoError(t, err)
+	assert.Equal(t, CheckReport{SiteID: "radio-t", Posts: 11, Comments: 102}, report)
+	user, err := compacted.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Limit: 200})
+	require.NoError(t, err)
+	assert.Equal(t, 102, len(user))
+}
@@ -122,6 +122,40 @@ func TestBoltDB_Update(t *testing.T) {
 	assert.EqualError(t, err, `no bucket https://radio-t.com-bad in store`)
 }
 
+func TestBoltDB_UpdateDeleted(t *testing.T) {
+	var b, teardown = prep(t)
+	defer teardown()
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	res, err := b.Find(FindRequest{Locator: locator, Sort: "time"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	orig := res[0]
+
+	err = b.Delete(DeleteRequest{Locator: locator, CommentID: orig.ID, DeleteMode: store.HardDelete})
+	require.NoError(t, err)
+	count, err := b.Count(FindRequest{Locator: locator})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	require.NoError(t, b.Update(orig), "restore deleted comment")
+	comment, err := b.Get(getReq(locator, orig.ID))
+	require.NoError(t, err)
+	assert.False(t, comment.Deleted)
+	assert.Equal(t, orig.Text, comment.Text)
+	assert.Equal(t, orig.User, comment.User, "user of hard-deleted comment restored")
+	count, err = b.Count(FindRequest{Locator: locator})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	require.NoError(t, b.Delete(DeleteRequest{Locator: locator, UserID: orig.User.ID, DeleteMode: store.HardDelete}))
+	require.NoError(t, b.Update(orig), "restore comment of deleted user")
+	res, err = b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: orig.User.ID})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "user's reference restored")
+	assert.Equal(t, orig.ID, res[0].ID)
+}
+
 func TestBoltDB_FindLast(t *testing.T) {
 	var b, teardown = prep(t)
 	defer teardown()
@@ -502,6 +536,267 @@ func TestBolt_FlagReadOnlyPost(t *testing.T) {
 	assert.False(t, val, "nothing ro on wrong site")
 }
 
+func TestBoltDB_Post(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	posts, err := b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(posts))
+	assert.Equal(t, store.Post{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"},
+		Created: time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local).In(posts[0].Created.Location())}, posts[0],
+		"post record made by the first comment")
+
+	_, err = b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/unknown"}})
+	assert.EqualError(t, err, "no post https://radio-t.com/unknown")
+	_, err = b.Post(PostRequest{Locator: store.Locator{SiteID: "bad", URL: "https://radio-t.com"}})
+	assert.EqualError(t, err, `site "bad" not found`)
+
+	update := store.Post{Locator: store.Locator{URL: "https://radio-t.com"}, Title: "title", Image: "https://radio-t.com/img.png",
+		Aliases: []string{"http://radio-t.com", "https://radio-t.com/", "http://radio-t.com", "https://radio-t.com"}}
+	posts, err = b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t"}, Update: &update})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://radio-t.com", "https://radio-t.com/"}, posts[0].Aliases, "deduplicated")
+	assert.False(t, posts[0].Created.IsZero(), "created time kept")
+
+	// comments by alias
+	c := store.Comment{ID: "id-3", Locator: store.Locator{SiteID: "radio-t", URL: "http://radio-t.com"},
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local), User: store.User{ID: "user1"}}
+	_, err = b.Create(c)
+	require.NoError(t, err)
+	comments, err := b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/"}})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(comments), "all comments by alias")
+	assert.Equal(t, "https://radio-t.com", comments[2].Locator.URL, "canonical url stored")
+	count, err := b.Count(FindRequest{Locator: store.Locator{SiteID: "radio-t", URL: "http://radio-t.com"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	res, err := b.Get(GetRequest{Locator: store.Locator{SiteID: "radio-t", URL: "http://radio-t.com"}, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "id-1", res.ID)
+	posts, err = b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/"}})
+	require.NoError(t, err)
+	assert.Equal(t, "title", posts[0].Title)
+
+	// read-only kept in the post record
+	_, err = b.Flag(FlagRequest{Locator: store.Locator{SiteID: "radio-t", URL: "http://radio-t.com"}, Flag: ReadOnly, Update: FlagTrue})
+	require.NoError(t, err)
+	ro, err := b.Flag(FlagRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, Flag: ReadOnly})
+	require.NoError(t, err)
+	assert.True(t, ro)
+	posts, err = b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}})
+	require.NoError(t, err)
+	assert.True(t, posts[0].ReadOnly)
+
+	// conflicting aliases
+	c = store.Comment{ID: "id-4", Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/2"},
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 25, 0, time.Local), User: store.User{ID: "user1"}}
+	_, err = b.Create(c)
+	require.NoError(t, err)
+	_, err = b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t"},
+		Update: &store.Post{Locator: store.Locator{URL: "https://radio-t.com/2"}, Aliases: []string{"http://radio-t.com"}}})
+	assert.EqualError(t, err, "alias http://radio-t.com already used by post https://radio-t.com")
+	_, err = b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t"},
+		Update: &store.Post{Locator: store.Locator{URL: "https://radio-t.com/2"}, Aliases: []string{"https://radio-t.com"}}})
+	assert.EqualError(t, err, "alias https://radio-t.com is url of another post")
+	_, err = b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t"},
+		Update: &store.Post{Locator: store.Locator{URL: "http://radio-t.com"}}})
+	assert.EqualError(t, err, "url http://radio-t.com is alias of post https://radio-t.com")
+
+	// alias removed
+	update.Aliases = []string{"https://radio-t.com/"}
+	_, err = b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t"}, Update: &update})
+	require.NoError(t, err)
+	_, err = b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t", URL: "http://radio-t.com"}})
+	assert.EqualError(t, err, "no post http://radio-t.com")
+
+	posts, err = b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(posts), "all post records")
+}
+
+func TestBoltDB_PostLegacyReadOnly(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	// read-only set before post records
+	err := b.dbs["radio-t"].Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(readonlyBucketName)).Put([]byte("https://radio-t.com"), []byte(time.Now().Format(tsNano)))
+	})
+	require.NoError(t, err)
+	posts, err := b.Post(PostRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}})
+	require.NoError(t, err)
+	assert.True(t, posts[0].ReadOnly)
+
+	_, err = b.Flag(FlagRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, Flag: ReadOnly, Update: FlagFalse})
+	require.NoError(t, err)
+	ro, err := b.Flag(FlagRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, Flag: ReadOnly})
+	require.NoError(t, err)
+	assert.False(t, ro, "legacy flag reset")
+}
+
+func TestBoltDB_Move(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	src, dst := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/2"}
+	for i, parent := range []string{"id-1", "id-3", ""} {
+		c := store.Comment{ID: fmt.Sprintf("id-%d", i+3), ParentID: parent, Text: "reply", Locator: src,
+			Timestamp: time.Date(2017, 12, 20, 15, 18, 24+i, 0, time.Local), User: store.User{ID: "user2"}}
+		_, err := b.Create(c)
+		require.NoError(t, err)
+	}
+	_, err := b.Create(store.Comment{ID: "id-10", Text: "another post", Locator: dst, PostTitle: "post 2",
+		Timestamp: time.Date(2017, 12, 21, 15, 18, 22, 0, time.Local), User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+
+	// move id-1 with replies id-3 and id-4
+	moved, err := b.Move(MoveRequest{Locator: src, CommentIDs: []string{"id-1", "id-3", "id-4"}, To: dst})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(moved))
+	assert.Equal(t, dst, moved[0].Locator)
+	assert.Equal(t, "post 2", moved[0].PostTitle)
+
+	c, err := b.Get(getReq(dst, "id-3"))
+	require.NoError(t, err)
+	assert.Equal(t, "id-1", c.ParentID, "parent moved along")
+	_, err = b.Get(getReq(src, "id-3"))
+	assert.Error(t, err)
+
+	count, err := b.Count(FindRequest{Locator: src})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	count, err = b.Count(FindRequest{Locator: dst})
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	info, err := b.Info(InfoRequest{Locator: dst})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local), info[0].FirstTS.Local())
+	info, err = b.Info(InfoRequest{Locator: src})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2017, 12, 20, 15, 18, 23, 0, time.Local), info[0].FirstTS.Local(), "first moved away")
+	assert.Equal(t, time.Date(2017, 12, 20, 15, 18, 26, 0, time.Local), info[0].LastTS.Local())
+	report, err := b.Check("radio-t", false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+
+	last, err := b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, Sort: "-time"})
+	require.NoError(t, err)
+	for _, l := range last {
+		if l.ID == "id-3" || l.ID == "id-4" || l.ID == "id-1" {
+			assert.Equal(t, dst, l.Locator, "last refs updated for %s", l.ID)
+		}
+	}
+	user, err := b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user2"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(user))
+	for _, u := range user {
+		if u.ID != "id-5" {
+			assert.Equal(t, dst, u.Locator, "user refs updated for %s", u.ID)
+		}
+	}
+
+	// move single comment with parent left in source
+	moved, err = b.Move(MoveRequest{Locator: dst, CommentIDs: []string{"id-4"}, To: src})
+	require.NoError(t, err)
+	assert.Equal(t, "", moved[0].ParentID)
+
+	// errors
+	_, err = b.Move(MoveRequest{Locator: src, CommentIDs: []string{"id-4"}, To: dst})
+	require.NoError(t, err)
+	_, err = b.Move(MoveRequest{Locator: src, CommentIDs: []string{"id-4"}, To: dst})
+	assert.Error(t, err, "no such comment in source")
+	_, err = b.Move(MoveRequest{Locator: src, To: src})
+	assert.Error(t, err)
+	_, err = b.Move(MoveRequest{Locator: src, To: store.Locator{SiteID: "other", URL: "https://radio-t.com/2"}})
+	assert.Error(t, err)
+
+	// merge all, source post removed
+	moved, err = b.Move(MoveRequest{Locator: src, To: dst})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(moved))
+	count, err = b.Count(FindRequest{Locator: dst})
+	require.NoError(t, err)
+	assert.Equal(t, 6, count)
+	posts, err := b.Info(InfoRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(posts))
+	assert.Equal(t, "https://radio-t.com/2", posts[0].URL)
+	_, err = b.Post(PostRequest{Locator: src})
+	assert.Error(t, err, "post record removed")
+}
+
+func TestBoltDB_Images(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	siteLocator := store.Locator{SiteID: "radio-t"}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	ids, err := b.Images(FindRequest{Locator: siteLocator})
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	_, err = b.Create(store.Comment{ID: "id-3", Text: "pics", Images: []string{"user1/pic1.png", "user1/pic2.png"},
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local), Locator: locator, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "id-4", Text: "pic", Images: []string{"user1/pic1.png"},
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 25, 0, time.Local), Locator: locator, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	ids, err = b.Images(FindRequest{Locator: siteLocator})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1/pic1.png", "user1/pic2.png"}, ids)
+	res, err := b.Find(FindRequest{Locator: siteLocator, ImageID: "user1/pic1.png", Sort: "time"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "id-3", res[0].ID)
+	assert.Equal(t, "id-4", res[1].ID)
+
+	// edit replaces references
+	c, err := b.Get(getReq(locator, "id-3"))
+	require.NoError(t, err)
+	c.Text, c.Images = "pic", []string{"user1/pic3.png"}
+	require.NoError(t, b.Update(c))
+	ids, err = b.Images(FindRequest{Locator: siteLocator})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1/pic1.png", "user1/pic3.png"}, ids)
+	res, err = b.Find(FindRequest{Locator: siteLocator, ImageID: "user1/pic1.png"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "id-4", res[0].ID)
+	res, err = b.Find(FindRequest{Locator: siteLocator, ImageID: "user1/pic2.png"})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// moved comment found at new post
+	to := store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}
+	_, err = b.Move(MoveRequest{Locator: locator, CommentIDs: []string{"id-3"}, To: to})
+	require.NoError(t, err)
+	res, err = b.Find(FindRequest{Locator: siteLocator, ImageID: "user1/pic3.png"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, to, res[0].Locator)
+
+	// deleted comments don't reference images
+	require.NoError(t, b.Delete(DeleteRequest{Locator: to, CommentID: "id-3", DeleteMode: store.SoftDelete}))
+	require.NoError(t, b.Delete(DeleteRequest{Locator: siteLocator, UserID: "user2", DeleteMode: store.HardDelete}))
+	ids, err = b.Images(FindRequest{Locator: siteLocator})
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+	c, err = b.Get(getReq(to, "id-3"))
+	require.NoError(t, err)
+	assert.Nil(t, c.Images)
+
+	// restored comment references images again
+	c.Deleted, c.Text, c.Images = false, "pic", []string{"user1/pic3.png"}
+	require.NoError(t, b.Update(c))
+	ids, err = b.Images(FindRequest{Locator: siteLocator})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1/pic3.png"}, ids)
+
+	_, err = b.Images(FindRequest{Locator: store.Locator{SiteID: "bad"}})
+	assert.Error(t, err)
+}
+
 func TestBolt_FlagVerified(t *testing.T) {
 
 	b, teardown := prep(t)
@@ -740,6 +1035,26 @@ func TestBolt_DeleteHard(t *testing.T) {
 	assert.Equal(t, "", res[0].Text)
 	assert.True(t, res[0].Deleted, "marked deleted")
 	assert.Equal(t, store.User{Name: "deleted", ID: "deleted", Picture: "", Admin: false, Blocked: false, IP: ""}, res[0].User)
+
+	// hard-deleted comment removed from user's history, the other comment of the user kept
+	user, err := b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(user))
+	assert.Equal(t, res[1].ID, user[0].ID)
+	report, err := b.Check("radio-t", false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems, "derived buckets consistent")
+
+	// user's reference to another comment with the same time kept
+	_, err = b.Create(store.Comment{ID: "id-3", Text: "text 3", Timestamp: res[1].Timestamp,
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	delReq.CommentID = res[1].ID
+	require.NoError(t, b.Delete(delReq))
+	user, err = b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(user))
+	assert.Equal(t, "id-3", user[0].ID)
 }
 
 func TestBolt_DeleteAll(t *testing.T) {
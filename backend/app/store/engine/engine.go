@@ -28,7 +28,10 @@ type Interface interface {
 	UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) // sets or gets single detail value, or gets all details for requested site.
 	// UserDetail returns list even for single entry request is a compromise in order to have both single detail getting and setting
 	// and all site's details listing under the same function (and not to extend interface by two separate functions).
-	Close() error // close storage engine
+	Post(req PostRequest) ([]store.Post, error)    // sets or gets post record by url or alias, or lists all post records of the site
+	Move(req MoveRequest) ([]store.Comment, error) // move comments, or all comments of the post, to another post
+	Images(req FindRequest) ([]string, error)      // ids of images referenced by comments of the site
+	Close() error                                  // close storage engine
 }
 
 // GetRequest is the input for Get func
@@ -39,10 +42,11 @@ type GetRequest struct {
 
 // FindRequest is the input for all find operations
 type FindRequest struct {
-	Locator store.Locator `json:"locator"`           // lack of URL means site operation
-	UserID  string        `json:"user_id,omitempty"` // presence of UserID treated as user-related find
-	Sort    string        `json:"sort,omitempty"`    // sort order with +/-field syntax
-	Since   time.Time     `json:"since,omitempty"`   // time limit for found results
+	Locator store.Locator `json:"locator"`            // lack of URL means site operation
+	UserID  string        `json:"user_id,omitempty"`  // presence of UserID treated as user-related find
+	ImageID string        `json:"image_id,omitempty"` // presence of ImageID treated as find of comments referencing the image
+	Sort    string        `json:"sort,omitempty"`     // sort order with +/-field syntax
+	Since   time.Time     `json:"since,omitempty"`    // time limit for found results
 	Limit   int           `json:"limit,omitempty"`
 	Skip    int           `json:"skip,omitempty"`
 }
@@ -115,6 +119,21 @@ type UserDetailRequest struct {
 	Update  string        `json:"update,omitempty"` // update value
 }
 
+// PostRequest is the input for both get/set of post records. Locator.URL can be the canonical url or alias of the post,
+// lack of URL means listing of all post records for the site
+type PostRequest struct {
+	Locator store.Locator `json:"locator"`          // post locator
+	Update  *store.Post   `json:"update,omitempty"` // if set, saves the post record, locator of the record used
+}
+
+// MoveRequest is the input for Move operation. Comments with parent not moved along become top-level comments
+// of the target post, source post removed when no comments left in it
+type MoveRequest struct {
+	Locator    store.Locator `json:"locator"`               // post locator comments moved from
+	CommentIDs []string      `json:"comment_ids,omitempty"` // comments to move, all comments of the post if empty
+	To         store.Locator `json:"to"`                    // post locator comments moved to
+}
+
 const (
 	// limits
 	lastLimit = 1000
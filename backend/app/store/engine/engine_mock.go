@@ -145,6 +145,29 @@ func (_m *MockInterface) Get(req GetRequest) (store.Comment, error) {
 	return r0, r1
 }
 
+// Images provides a mock function with given fields: req
+func (_m *MockInterface) Images(req FindRequest) ([]string, error) {
+	ret := _m.Called(req)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(FindRequest) []string); ok {
+		r0 = rf(req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(FindRequest) error); ok {
+		r1 = rf(req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Info provides a mock function with given fields: req
 func (_m *MockInterface) Info(req InfoRequest) ([]store.PostInfo, error) {
 	ret := _m.Called(req)
@@ -191,6 +214,52 @@ func (_m *MockInterface) ListFlags(req FlagRequest) ([]interface{}, error) {
 	return r0, r1
 }
 
+// Move provides a mock function with given fields: req
+func (_m *MockInterface) Move(req MoveRequest) ([]store.Comment, error) {
+	ret := _m.Called(req)
+
+	var r0 []store.Comment
+	if rf, ok := ret.Get(0).(func(MoveRequest) []store.Comment); ok {
+		r0 = rf(req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]store.Comment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(MoveRequest) error); ok {
+		r1 = rf(req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Post provides a mock function with given fields: req
+func (_m *MockInterface) Post(req PostRequest) ([]store.Post, error) {
+	ret := _m.Called(req)
+
+	var r0 []store.Post
+	if rf, ok := ret.Get(0).(func(PostRequest) []store.Post); ok {
+		r0 = rf(req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]store.Post)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(PostRequest) error); ok {
+		r1 = rf(req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Update provides a mock function with given fields: comment
 func (_m *MockInterface) Update(comment store.Comment) error {
 	ret := _m.Called(comment)
@@ -94,6 +94,36 @@ func (r *RPC) UserDetail(req UserDetailRequest) (result []UserDetailEntry, err e
 	return result, err
 }
 
+// Post sets or gets post record by url or alias, or lists all post records of the site
+func (r *RPC) Post(req PostRequest) (posts []store.Post, err error) {
+	resp, err := r.Call("store.post", req)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(*resp.Result, &posts)
+	return posts, err
+}
+
+// Move moves comments, or all comments of the post, to another post
+func (r *RPC) Move(req MoveRequest) (comments []store.Comment, err error) {
+	resp, err := r.Call("store.move", req)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(*resp.Result, &comments)
+	return comments, err
+}
+
+// Images gets ids of images referenced by comments of the site
+func (r *RPC) Images(req FindRequest) (ids []string, err error) {
+	resp, err := r.Call("store.images", req)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(*resp.Result, &ids)
+	return ids, err
+}
+
 // Count gets comments count by user or site
 func (r *RPC) Count(req FindRequest) (count int, err error) {
 	resp, err := r.Call("store.count", req)
@@ -160,6 +160,43 @@ func TestRemote_UserDetailWithErrorResult(t *testing.T) {
 	assert.EqualError(t, err, "failed")
 }
 
+func TestRemote_Post(t *testing.T) {
+	ts := testServer(t, `{"method":"store.post","params":{"locator":{"site":"site","url":"http://example.com/url"}},"id":1}`,
+		`{"result":[{"locator":{"site":"site","url":"http://example.com/url"},"title":"title","created":"2020-01-01T00:00:00Z","aliases":["http://example.com/url/"]}]}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	res, err := c.Post(PostRequest{Locator: store.Locator{SiteID: "site", URL: "http://example.com/url"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Post{{Locator: store.Locator{SiteID: "site", URL: "http://example.com/url"}, Title: "title",
+		Created: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Aliases: []string{"http://example.com/url/"}}}, res)
+}
+
+func TestRemote_Move(t *testing.T) {
+	ts := testServer(t, `{"method":"store.move","params":{"locator":{"site":"site","url":"http://example.com/url"},"comment_ids":["c1"],"to":{"site":"site","url":"http://example.com/url2"}},"id":1}`,
+		`{"result":[{"id":"c1","locator":{"site":"site","url":"http://example.com/url2"}}]}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	res, err := c.Move(MoveRequest{Locator: store.Locator{SiteID: "site", URL: "http://example.com/url"}, CommentIDs: []string{"c1"},
+		To: store.Locator{SiteID: "site", URL: "http://example.com/url2"}})
+	assert.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "c1", res[0].ID)
+	assert.Equal(t, store.Locator{SiteID: "site", URL: "http://example.com/url2"}, res[0].Locator)
+}
+
+func TestRemote_Images(t *testing.T) {
+	ts := testServer(t, `{"method":"store.images","params":{"locator":{"site":"site","url":""},"since":"0001-01-01T00:00:00Z"},"id":1}`,
+		`{"result":["user1/img1.png","user2/img2.png"]}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	res, err := c.Images(FindRequest{Locator: store.Locator{SiteID: "site"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user1/img1.png", "user2/img2.png"}, res)
+}
+
 func TestRemote_Count(t *testing.T) {
 	ts := testServer(t, `{"method":"store.count","params":{"locator":{"url":"http://example.com/url"},"since":"0001-01-01T00:00:00Z"},"id":1}`, `{"result":11}`)
 	defer ts.Close()
@@ -2,6 +2,8 @@ package store
 
 import (
 	"bytes"
+	"context"
+	stdhtml "html"
 	"net/url"
 	"strings"
 
@@ -17,7 +19,13 @@ import (
 // CommentFormatter implements all generic formatting ops on comment
 type CommentFormatter struct {
 	colorScheme string // chroma style name
-	converters []CommentConverter
+	converters  []CommentConverter
+	previewer   LinkPreviewer
+}
+
+// LinkPreviewer makes previews of links from the comment's html
+type LinkPreviewer interface {
+	Previews(ctx context.Context, commentHTML string) []LinkPreview
 }
 
 // CommentConverter defines interface to convert some parts of commentHTML
@@ -45,12 +53,57 @@ func (f *CommentFormatter) WithStyle(colorScheme string) *CommentFormatter {
 	return f
 }
 
+// WithPreviews sets link previewer used by Previews
+func (f *CommentFormatter) WithPreviews(previewer LinkPreviewer) *CommentFormatter {
+	f.previewer = previewer
+	return f
+}
+
 // Format comment fields
 func (f *CommentFormatter) Format(c Comment) Comment {
 	c.Text = f.FormatText(c.Text)
 	return c
 }
 
+// PreviewsEnabled reports if previewer set
+func (f *CommentFormatter) PreviewsEnabled() bool {
+	return f.previewer != nil
+}
+
+// Previews returns previews of links from the formatted comment's html, images of previews passed through
+// converters, i.e. to proxy them. Empty if previewer not set. Linked pages loaded by previewer, so it's slow
+func (f *CommentFormatter) Previews(ctx context.Context, commentHTML string) []LinkPreview {
+	if f.previewer == nil {
+		return nil
+	}
+	res := f.previewer.Previews(ctx, commentHTML)
+	for i := range res {
+		if res[i].Image != "" {
+			res[i].Image = f.convertImage(res[i].Image)
+		}
+	}
+	return res
+}
+
+// convertImage applies converters to image url, converters work on html, so url wrapped with img tag
+func (f *CommentFormatter) convertImage(src string) string {
+	if len(f.converters) == 0 {
+		return src
+	}
+	res := `<img src="` + stdhtml.EscapeString(src) + `"/>`
+	for _, conv := range f.converters {
+		res = conv.Convert(res)
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(res))
+	if err != nil {
+		return src
+	}
+	if converted, ok := doc.Find("img").Attr("src"); ok {
+		return converted
+	}
+	return src
+}
+
 // FormatText converts text with markdown processor, applies external converters and shortens links
 func (f *CommentFormatter) FormatText(txt string) (res string) {
 	if f.colorScheme == "" {
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -68,6 +70,40 @@ func TestFormatter_FormatComment(t *testing.T) {
 	assert.Equal(t, exp, f.Format(comment))
 }
 
+type mockPreviewer struct{ html string }
+
+func (m *mockPreviewer) Previews(_ context.Context, commentHTML string) []LinkPreview {
+	m.html = commentHTML
+	return []LinkPreview{
+		{URL: "https://example.com/1", Title: "title <1>", Description: "desc", Image: "https://example.com/1.png?a=1&b=2", SiteName: "example"},
+		{URL: "https://example.com/2", Title: "title 2"},
+	}
+}
+
+func TestFormatter_Previews(t *testing.T) {
+	previewer := &mockPreviewer{}
+	proxy := CommentConverterFunc(func(text string) string {
+		return strings.Replace(text, `src="https://example.com/`, `src="https://remark42.example.com/api/v1/img?src=`, -1)
+	})
+	f := NewCommentFormatter(mockConverter{}, proxy).WithPreviews(previewer)
+	assert.True(t, f.PreviewsEnabled())
+	res := f.Format(Comment{Text: "see https://example.com/1"})
+	assert.Equal(t, `<p>see <a href="https://example.com/1">https://example.com/1</a></p>`+"\n!converted", res.Text, "no previews")
+	assert.Equal(t, "", previewer.html, "previews not loaded by Format")
+
+	previews := f.Previews(context.Background(), res.Text)
+	assert.Equal(t, res.Text, previewer.html)
+	assert.Equal(t, []LinkPreview{
+		{URL: "https://example.com/1", Title: "title <1>", Description: "desc",
+			Image: "https://remark42.example.com/api/v1/img?src=1.png?a=1&b=2", SiteName: "example"},
+		{URL: "https://example.com/2", Title: "title 2"},
+	}, previews, "converters applied to images")
+
+	f = NewCommentFormatter()
+	assert.False(t, f.PreviewsEnabled())
+	assert.Empty(t, f.Previews(context.Background(), res.Text), "no previewer, no previews")
+}
+
 func TestFormatter_ShortenAutoLinks(t *testing.T) {
 	f := NewCommentFormatter(nil)
 	tbl := []struct {
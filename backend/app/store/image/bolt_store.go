@@ -15,10 +15,11 @@ import (
 const imagesStagedBktName = "imagesStaged"
 const imagesBktName = "images"
 const insertTimeBktName = "insertTimestamps"
+const commitTimeBktName = "commitTimestamps"
 
 // Bolt provides image Store for images keeping data in bolt DB, restricts max size.
-// It uses 3 buckets to manage images data.
-// Two buckets contains image data (staged and committed images). Third bucket holds insertion timestamps.
+// It uses 4 buckets to manage images data.
+// Two buckets contains image data (staged and committed images). Others hold insertion and commit timestamps.
 type Bolt struct {
 	fileName string
 	db       *bolt.DB
@@ -41,7 +42,10 @@ func NewBoltStorage(fileName string, options bolt.Options) (*Bolt, error) {
 		if _, e := tx.CreateBucketIfNotExists([]byte(insertTimeBktName)); e != nil {
 			return errors.Wrapf(e, "failed to create top level bucket %s", insertTimeBktName)
 		}
-		return nil
+		if _, e := tx.CreateBucketIfNotExists([]byte(commitTimeBktName)); e != nil {
+			return errors.Wrapf(e, "failed to create top level bucket %s", commitTimeBktName)
+		}
+		return backfillCommitTime(tx)
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to initialize boltdb db %q buckets", fileName)
@@ -52,20 +56,39 @@ func NewBoltStorage(fileName string, options bolt.Options) (*Bolt, error) {
 	}, nil
 }
 
+// backfillCommitTime sets commit time of images committed before it was recorded to the current time,
+// so such images get full grace period of garbage collection
+func backfillCommitTime(tx *bolt.Tx) error {
+	tsBkt := tx.Bucket([]byte(commitTimeBktName))
+	var ids []string
+	err := tx.Bucket([]byte(imagesBktName)).ForEach(func(k, _ []byte) error {
+		if tsBkt.Get(k) == nil {
+			ids = append(ids, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list images")
+	}
+	now := time.Now()
+	for _, id := range ids {
+		if err = putTimestamp(tsBkt, id, now); err != nil {
+			return errors.Wrapf(err, "failed to set commit time of %s", id)
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("[INFO] commit time set for %d images", len(ids))
+	}
+	return nil
+}
+
 // SaveWithID saves data from a reader, for given id
 func (b *Bolt) SaveWithID(id string, img []byte) (string, error) {
 	err := b.db.Update(func(tx *bolt.Tx) error {
 		if err := tx.Bucket([]byte(imagesStagedBktName)).Put([]byte(id), img); err != nil {
 			return errors.Wrapf(err, "can't put to bucket with %s", id)
 		}
-		tsBuf := &bytes.Buffer{}
-		if err := binary.Write(tsBuf, binary.LittleEndian, time.Now().UnixNano()); err != nil {
-			return errors.Wrapf(err, "can't serialize timestamp for %s", id)
-		}
-		if err := tx.Bucket([]byte(insertTimeBktName)).Put([]byte(id), tsBuf.Bytes()); err != nil {
-			return errors.Wrapf(err, "can't put to bucket with %s", id)
-		}
-		return nil
+		return putTimestamp(tx.Bucket([]byte(insertTimeBktName)), id, time.Now())
 	})
 
 	return id, err
@@ -85,8 +108,10 @@ func (b *Bolt) Commit(id string) error {
 		if data == nil {
 			return errors.Errorf("failed to commit %s, not found in staging", id)
 		}
-		err := tx.Bucket([]byte(imagesBktName)).Put([]byte(id), data)
-		return errors.Wrapf(err, "can't put to bucket with %s", id)
+		if err := tx.Bucket([]byte(imagesBktName)).Put([]byte(id), data); err != nil {
+			return errors.Wrapf(err, "can't put to bucket with %s", id)
+		}
+		return putTimestamp(tx.Bucket([]byte(commitTimeBktName)), id, time.Now())
 	})
 	return err
 }
@@ -147,3 +172,51 @@ func (b *Bolt) Cleanup(_ context.Context, ttl time.Duration) error {
 	})
 	return err
 }
+
+// List committed images with commit time, images committed before it was recorded get the time of the first
+// start with it, see backfillCommitTime
+func (b *Bolt) List(ctx context.Context) ([]Info, error) {
+	res := []Info{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		tsBkt := tx.Bucket([]byte(commitTimeBktName))
+		return tx.Bucket([]byte(imagesBktName)).ForEach(func(k, v []byte) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			info := Info{ID: string(k), Size: int64(len(v))}
+			if tsData := tsBkt.Get(k); tsData != nil {
+				var ts int64
+				if err := binary.Read(bytes.NewReader(tsData), binary.LittleEndian, &ts); err != nil {
+					return errors.Wrapf(err, "failed to deserialize timestamp for %s", k)
+				}
+				info.Modified = time.Unix(0, ts)
+			}
+			res = append(res, info)
+			return nil
+		})
+	})
+	return res, errors.Wrap(err, "failed to list images")
+}
+
+// Delete committed image
+func (b *Bolt) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(imagesBktName))
+		if bkt.Get([]byte(id)) == nil {
+			return errors.Errorf("can't remove image %s, not found", id)
+		}
+		if err := bkt.Delete([]byte(id)); err != nil {
+			return errors.Wrapf(err, "failed to remove image %s", id)
+		}
+		return errors.Wrapf(tx.Bucket([]byte(commitTimeBktName)).Delete([]byte(id)), "failed to remove timestamp for %s", id)
+	})
+}
+
+// putTimestamp stores ts for id to the bucket
+func putTimestamp(bkt *bolt.Bucket, id string, ts time.Time) error {
+	tsBuf := &bytes.Buffer{}
+	if err := binary.Write(tsBuf, binary.LittleEndian, ts.UnixNano()); err != nil {
+		return errors.Wrapf(err, "can't serialize timestamp for %s", id)
+	}
+	return errors.Wrapf(bkt.Put([]byte(id), tsBuf.Bytes()), "can't put to bucket with %s", id)
+}
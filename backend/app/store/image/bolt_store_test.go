@@ -145,3 +145,53 @@ func prepareBoltImageStorageTest(t *testing.T) (svc *Bolt, teardown func()) {
 
 	return svc, teardown
 }
+
+func TestBoltStore_ListDelete(t *testing.T) {
+	svc, teardown := prepareBoltImageStorageTest(t)
+	defer teardown()
+
+	id1, err := svc.Save("user1", gopherPNGBytes())
+	require.NoError(t, err)
+	require.NoError(t, svc.Commit(id1))
+	id2, err := svc.SaveWithID("user2/pic", []byte("blah"))
+	require.NoError(t, err)
+	require.NoError(t, svc.Commit(id2))
+	_, err = svc.Save("user1", gopherPNGBytes()) // staging image, not listed
+	require.NoError(t, err)
+
+	// commit time unknown for images committed before it was recorded
+	err = svc.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(commitTimeBktName)).Delete([]byte(id2))
+	})
+	require.NoError(t, err)
+	list, err := svc.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list))
+	assert.True(t, list[1].Modified.IsZero())
+
+	// commit time set on the next start
+	require.NoError(t, svc.db.Close())
+	svc, err = NewBoltStorage(svc.fileName, bolt.Options{})
+	require.NoError(t, err)
+	defer svc.db.Close()
+
+	list, err = svc.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list))
+	assert.Equal(t, id1, list[0].ID)
+	assert.Equal(t, int64(1462), list[0].Size)
+	assert.WithinDuration(t, time.Now(), list[0].Modified, time.Minute)
+	assert.Equal(t, "user2/pic", list[1].ID)
+	assert.Equal(t, int64(4), list[1].Size)
+	assert.WithinDuration(t, time.Now(), list[1].Modified, time.Minute)
+
+	require.NoError(t, svc.Delete(id1))
+	assertBoltImgNil(t, svc.db, imagesBktName, id1)
+	assertBoltImgNil(t, svc.db, commitTimeBktName, id1)
+	assert.EqualError(t, svc.Delete(id1), "can't remove image "+id1+", not found")
+
+	list, err = svc.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, "user2/pic", list[0].ID)
+}
@@ -121,6 +121,48 @@ func (f *FileSystem) Cleanup(_ context.Context, ttl time.Duration) error {
 	return errors.Wrap(err, "failed to cleanup images")
 }
 
+// List committed images. Ids restored from the path as user/file, partition directory skipped.
+func (f *FileSystem) List(ctx context.Context) ([]Info, error) {
+	res := []Info{}
+	if _, err := os.Stat(f.Location); os.IsNotExist(err) {
+		return res, nil
+	}
+
+	err := filepath.Walk(f.Location, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(f.Location, fpath)
+		if err != nil {
+			return err
+		}
+		elems := strings.Split(filepath.ToSlash(rel), "/")
+		id := elems[len(elems)-1]
+		if len(elems) > 1 && elems[0] != "unknown" {
+			id = elems[0] + "/" + id
+		}
+		res = append(res, Info{ID: id, Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	return res, errors.Wrap(err, "failed to list images")
+}
+
+// Delete committed image
+func (f *FileSystem) Delete(id string) error {
+	file := f.location(f.Location, id)
+	if err := os.Remove(file); err != nil {
+		return errors.Wrapf(err, "failed to remove image %s", id)
+	}
+	_ = os.Remove(path.Dir(file)) // try to remove partition directory
+	return nil
+}
+
 // location gets full path for id by adding partition to the final path in order to keep files in different subdirectories
 // and avoid too many files in a single place.
 // the end result is a full path like this - /tmp/images/user1/92/xxx-yyy.png.
@@ -8,6 +8,7 @@ import (
 	"math/rand"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -253,3 +254,45 @@ func prepareImageTest(t *testing.T) (svc *FileSystem, teardown func()) {
 
 	return svc, teardown
 }
+
+func TestFsStore_ListDelete(t *testing.T) {
+	svc, teardown := prepareImageTest(t)
+	defer teardown()
+
+	list, err := svc.List(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	id1, err := svc.Save("user1", gopherPNGBytes())
+	require.NoError(t, err)
+	require.NoError(t, svc.Commit(id1))
+	id2, err := svc.SaveWithID("user2/pic_w320", []byte("blah"))
+	require.NoError(t, err)
+	require.NoError(t, svc.Commit(id2))
+	_, err = svc.Save("user1", gopherPNGBytes()) // staging image, not listed
+	require.NoError(t, err)
+
+	list, err = svc.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list))
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	assert.Equal(t, id1, list[0].ID)
+	assert.Equal(t, int64(1462), list[0].Size)
+	assert.WithinDuration(t, time.Now(), list[0].Modified, time.Minute)
+	assert.Equal(t, "user2/pic_w320", list[1].ID)
+	assert.Equal(t, int64(4), list[1].Size)
+
+	require.NoError(t, svc.Delete(id1))
+	_, err = svc.Load(id1)
+	assert.Error(t, err)
+	assert.Error(t, svc.Delete(id1), "already removed")
+
+	list, err = svc.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Info{{ID: "user2/pic_w320", Size: 4, Modified: list[0].Modified}}, list)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = svc.List(ctx)
+	assert.Error(t, err)
+}
@@ -0,0 +1,148 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Lister implemented by stores able to enumerate and remove committed images, used by garbage collection
+type Lister interface {
+	List(ctx context.Context) ([]Info, error) // list all committed images
+	Delete(id string) error                   // remove committed image
+}
+
+// Info describes committed image
+type Info struct {
+	ID       string    `json:"id"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"` // time of upload or commit, zero if unknown and treated as now
+}
+
+// GCReport describes committed images and the space reclaimable by removal of images not referenced by any comment
+type GCReport struct {
+	Total           int      `json:"total"`            // number of committed images
+	TotalSize       int64    `json:"total_size"`       // size of committed images
+	Orphaned        int      `json:"orphaned"`         // number of not referenced images, including ones in grace period
+	Reclaimable     int      `json:"reclaimable"`      // number of orphaned images older than grace period
+	ReclaimableSize int64    `json:"reclaimable_size"` // size of reclaimable images
+	Removed         int      `json:"removed"`          // number of removed images, 0 for dry run
+	IDs             []string `json:"ids,omitempty"`    // ids of reclaimable images
+}
+
+func (r GCReport) String() string {
+	return fmt.Sprintf("total=%d (%d bytes), orphaned=%d, reclaimable=%d (%d bytes), removed=%d",
+		r.Total, r.TotalSize, r.Orphaned, r.Reclaimable, r.ReclaimableSize, r.Removed)
+}
+
+// cachedImagesUser is a user part of ids for external images cached by image proxy.
+// Such images referenced by proxy urls and never collected.
+const cachedImagesUser = "cached_images/"
+
+var variantIDRe = regexp.MustCompile(`_w\d+$`)
+
+// PictureRefs gets list of image ids referenced by the comment html. Unlike ExtractPictures it matches path of
+// ImageAPI only, so references kept by comments made before change of remark url are not lost.
+func (s *Service) PictureRefs(commentHTML string) ([]string, error) {
+	return s.extractPictures(commentHTML, s.apiPath())
+}
+
+// RemovePicture removes all references to the image, html img tags and markdown images, from the comment's text
+func (s *Service) RemovePicture(text, id string) string {
+	src := regexp.QuoteMeta(s.apiPath() + id)
+	htmlRe := regexp.MustCompile(`<img\b[^>]*\bsrc="[^"]*` + src + `(\?[^"]*)?"[^>]*>`)
+	mdRe := regexp.MustCompile(`!\[[^\]]*\]\(\s*[^)\s]*` + src + `(\?[^)\s]*)?(\s+"[^"]*")?\s*\)`)
+	return mdRe.ReplaceAllString(htmlRe.ReplaceAllString(text, ""), "")
+}
+
+// apiPath returns path part of ImageAPI
+func (s *Service) apiPath() string {
+	if u, err := url.Parse(s.ImageAPI); err == nil && u.Path != "" {
+		return u.Path
+	}
+	return s.ImageAPI
+}
+
+// List returns all committed images, store should implement Lister
+func (s *Service) List(ctx context.Context) ([]Info, error) {
+	lister, ok := s.store.(Lister)
+	if !ok {
+		return nil, errors.New("image store doesn't support listing")
+	}
+	return lister.List(ctx)
+}
+
+// GC removes committed images not referenced by any comment and older than GCGrace. Variants of the image
+// are referenced by the original one. With dry set makes the report without removal.
+func (s *Service) GC(ctx context.Context, refs map[string]bool, dry bool) (GCReport, error) {
+	lister, ok := s.store.(Lister)
+	if !ok {
+		return GCReport{}, errors.New("image store doesn't support listing")
+	}
+	images, err := lister.List(ctx)
+	if err != nil {
+		return GCReport{}, errors.Wrap(err, "can't list images")
+	}
+
+	res := GCReport{}
+	for _, img := range images {
+		res.Total++
+		res.TotalSize += img.Size
+		if strings.HasPrefix(img.ID, cachedImagesUser) || refs[variantIDRe.ReplaceAllString(img.ID, "")] {
+			continue
+		}
+		res.Orphaned++
+		if img.Modified.IsZero() || time.Since(img.Modified) < s.GCGrace { // unknown time treated as now
+			continue
+		}
+		res.Reclaimable++
+		res.ReclaimableSize += img.Size
+		res.IDs = append(res.IDs, img.ID)
+		if dry {
+			continue
+		}
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		if err = lister.Delete(img.ID); err != nil {
+			log.Printf("[WARN] can't remove orphaned image %s, %v", img.ID, err)
+			continue
+		}
+		log.Printf("[INFO] orphaned image %s removed, size=%d", img.ID, img.Size)
+		s.forgetUpload(img.ID)
+		res.Removed++
+	}
+	return res, nil
+}
+
+// RunGC runs periodic removal of orphaned images, refsFn returns ids of all referenced images.
+// Blocking loop, should be called inside of goroutine by consumer
+func (s *Service) RunGC(ctx context.Context, interval time.Duration, refsFn func() (map[string]bool, error)) {
+	log.Printf("[INFO] start pictures gc, interval=%v, grace=%v", interval, s.GCGrace)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] pictures gc terminated, %v", ctx.Err())
+			return
+		case <-time.After(interval):
+			refs, err := refsFn()
+			if err != nil {
+				log.Printf("[WARN] can't get referenced pictures, %v", err)
+				continue
+			}
+			res, err := s.GC(ctx, refs, false)
+			if err != nil {
+				log.Printf("[WARN] failed to collect pictures, %v", err)
+				continue
+			}
+			log.Printf("[INFO] pictures gc completed, %s", res)
+		}
+	}
+}
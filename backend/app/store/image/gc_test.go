@@ -0,0 +1,148 @@
+package image
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestService_PictureRefs(t *testing.T) {
+	svc := Service{ServiceParams: ServiceParams{ImageAPI: "https://remark42.example.com/api/v1/picture/"}}
+	html := `blah <img src="https://remark42.example.com/api/v1/picture/user1/pic1.png"/> foo
+<img src="http://old.example.com/api/v1/picture/user2/pic2.png?w=320"> <img src="https://example.com/pic3.png">`
+	ids, err := svc.PictureRefs(html)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1/pic1.png", "user2/pic2.png"}, ids)
+
+	ids, err = svc.ExtractPictures(html)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1/pic1.png"}, ids, "matched by full url")
+}
+
+func TestService_GC(t *testing.T) {
+	loc, err := ioutil.TempDir("", "test_image_gc")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+
+	store := &FileSystem{Location: loc + "/images", Staging: loc + "/staging", Partitions: 10}
+	svc := NewService(store, ServiceParams{GCGrace: time.Hour})
+
+	commit := func(id, data string, age time.Duration) {
+		_, e := store.SaveWithID(id, []byte(data))
+		require.NoError(t, e)
+		require.NoError(t, store.Commit(id))
+		ts := time.Now().Add(-age)
+		require.NoError(t, os.Chtimes(store.location(store.Location, id), ts, ts))
+	}
+	commit("user1/referenced", "12345", 2*time.Hour)
+	commit("user1/referenced_w320", "123", 2*time.Hour) // variant of referenced image
+	commit("user1/orphan", "1234567", 2*time.Hour)
+	commit("user1/orphan_w320", "12", 2*time.Hour)
+	commit("user2/fresh-orphan", "1", time.Minute) // in grace period
+	commit("cached_images/external", "1234", 2*time.Hour)
+	_, err = store.Save("user3", []byte("staging")) // not committed, left for cleanup
+	require.NoError(t, err)
+
+	refs := map[string]bool{"user1/referenced": true, "user2/deleted": true}
+	res, err := svc.GC(context.Background(), refs, true)
+	require.NoError(t, err)
+	sort.Strings(res.IDs)
+	assert.Equal(t, GCReport{Total: 6, TotalSize: 22, Orphaned: 3, Reclaimable: 2, ReclaimableSize: 9,
+		IDs: []string{"user1/orphan", "user1/orphan_w320"}}, res)
+	_, err = svc.Load("user1/orphan")
+	assert.NoError(t, err, "dry run, not removed")
+
+	res, err = svc.GC(context.Background(), refs, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Removed)
+	assert.Equal(t, "total=6 (22 bytes), orphaned=3, reclaimable=2 (9 bytes), removed=2", res.String())
+	_, err = svc.Load("user1/orphan")
+	assert.Error(t, err)
+	_, err = svc.Load("user1/orphan_w320")
+	assert.Error(t, err)
+
+	res, err = svc.GC(context.Background(), refs, false)
+	require.NoError(t, err)
+	assert.Equal(t, GCReport{Total: 4, TotalSize: 13, Orphaned: 1}, res)
+
+	svc.GCGrace = 0
+	res, err = svc.GC(context.Background(), map[string]bool{}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Removed, "all but cached removed")
+	_, err = svc.Load("cached_images/external")
+	assert.NoError(t, err)
+}
+
+func TestService_GCUnknownTime(t *testing.T) {
+	store, teardown := prepareBoltImageStorageTest(t)
+	defer teardown()
+	svc := NewService(store, ServiceParams{GCGrace: time.Hour})
+
+	_, err := store.SaveWithID("user1/orphan", []byte("blah"))
+	require.NoError(t, err)
+	require.NoError(t, store.Commit("user1/orphan"))
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(commitTimeBktName)).Delete([]byte("user1/orphan"))
+	})
+	require.NoError(t, err)
+
+	res, err := svc.GC(context.Background(), map[string]bool{}, false)
+	require.NoError(t, err)
+	assert.Equal(t, GCReport{Total: 1, TotalSize: 4, Orphaned: 1}, res, "unknown commit time in grace period")
+}
+
+func TestService_GCNotSupported(t *testing.T) {
+	svc := NewService(&MockStore{}, ServiceParams{})
+	_, err := svc.GC(context.Background(), nil, true)
+	assert.EqualError(t, err, "image store doesn't support listing")
+}
+
+func TestService_RunGC(t *testing.T) {
+	loc, err := ioutil.TempDir("", "test_image_gc")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+
+	store := &FileSystem{Location: loc + "/images", Staging: loc + "/staging", Partitions: 10}
+	svc := NewService(store, ServiceParams{})
+	for _, id := range []string{"user1/pic1", "user1/pic2"} {
+		_, err = store.SaveWithID(id, []byte("blah"))
+		require.NoError(t, err)
+		require.NoError(t, store.Commit(id))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	svc.RunGC(ctx, 10*time.Millisecond, func() (map[string]bool, error) {
+		return map[string]bool{"user1/pic1": true}, nil
+	})
+
+	_, err = svc.Load("user1/pic1")
+	assert.NoError(t, err)
+	_, err = svc.Load("user1/pic2")
+	assert.Error(t, err)
+}
+
+func TestService_RemovePicture(t *testing.T) {
+	svc := Service{ServiceParams: ServiceParams{ImageAPI: "https://remark42.example.com/api/v1/picture/"}}
+	tbl := []struct {
+		text, res string
+	}{
+		{`<p>blah <img src="https://remark42.example.com/api/v1/picture/user1/pic1.png" alt="pic"/> foo</p>`, `<p>blah  foo</p>`},
+		{`<img src="http://old.example.com/api/v1/picture/user1/pic1.png?w=320"><img src="https://remark42.example.com/api/v1/picture/user1/pic2.png">`,
+			`<img src="https://remark42.example.com/api/v1/picture/user1/pic2.png">`},
+		{"blah ![pic](https://remark42.example.com/api/v1/picture/user1/pic1.png) foo", "blah  foo"},
+		{`![](https://remark42.example.com/api/v1/picture/user1/pic1.png?w=640 "title")![](https://example.com/user1/pic1.png)`,
+			"![](https://example.com/user1/pic1.png)"},
+		{"no pictures", "no pictures"},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.res, svc.RemovePicture(tt.text, "user1/pic1.png"), "check #%d", i)
+	}
+}
@@ -1,5 +1,5 @@
 // Package image handles storing, resizing and retrieval of images
-// Provides Store with Save and Load implementations on top of local file system and bolt db.
+// Provides Store with Save and Load implementations on top of local file system, bolt db and s3 compatible storage.
 // Service object encloses Store and add common methods, this is the one consumer should use.
 package image
 
@@ -8,6 +8,7 @@ package image
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
@@ -38,6 +39,8 @@ type Service struct {
 	submitCh chan submitReq
 	once     sync.Once
 	term     int32 // term value used atomically to detect emergency termination
+
+	uploadsLock sync.Mutex
 }
 
 // ServiceParams contains externally adjustable parameters of Service
@@ -47,6 +50,14 @@ type ServiceParams struct {
 	MaxSize   int
 	MaxHeight int
 	MaxWidth  int
+
+	JPEGQuality   int           // quality of re-encoded jpeg images, default 85
+	VariantWidths []int         // allowed widths of resized image variants
+	SignedURLTTL  time.Duration // ttl of direct links to images, used if store supports them
+	GCGrace       time.Duration // orphaned images kept for this time before removal by GC
+
+	Uploads      UploadStore  // records of uploaded images, limits not checked if nil
+	UploadLimits UploadLimits // quotas for images uploaded by users
 }
 
 // To regenerate mock run from this directory:
@@ -66,6 +77,7 @@ type Store interface {
 }
 
 const submitQueueSize = 5000
+const maxVariantHeight = 100000 // variants limited by width only
 
 type submitReq struct {
 	idsFn func() (ids []string)
@@ -97,6 +109,12 @@ func (s *Service) Submit(idsFn func() []string) {
 				for _, id := range req.idsFn() {
 					if err := s.store.Commit(id); err != nil {
 						log.Printf("[WARN] failed to commit image %s", id)
+						continue
+					}
+					if s.Uploads != nil {
+						if err := s.Uploads.Commit(id); err != nil {
+							log.Printf("[WARN] failed to mark upload %s committed, %v", id, err)
+						}
 					}
 				}
 				atomic.StoreInt32(&s.term, 1) // indicates completion of ids commits
@@ -110,7 +128,11 @@ func (s *Service) Submit(idsFn func() []string) {
 
 // ExtractPictures gets list of images from the doc html and convert from urls to ids, i.e. user/pic.png
 func (s *Service) ExtractPictures(commentHTML string) (ids []string, err error) {
+	return s.extractPictures(commentHTML, s.ImageAPI)
+}
 
+// extractPictures gets ids of images with src containing api
+func (s *Service) extractPictures(commentHTML string, api string) (ids []string, err error) {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(commentHTML))
 	if err != nil {
 		return nil, errors.Wrap(err, "can't create document")
@@ -118,7 +140,8 @@ func (s *Service) ExtractPictures(commentHTML string) (ids []string, err error)
 	result := []string{}
 	doc.Find("img").Each(func(i int, sl *goquery.Selection) {
 		if im, ok := sl.Attr("src"); ok {
-			if strings.Contains(im, s.ImageAPI) {
+			im = strings.SplitN(im, "?", 2)[0] // drop variant params
+			if strings.Contains(im, api) {
 				elems := strings.Split(im, "/")
 				if len(elems) >= 2 {
 					id := elems[len(elems)-2] + "/" + elems[len(elems)-1]
@@ -144,6 +167,11 @@ func (s *Service) Cleanup(ctx context.Context) {
 			if err := s.store.Cleanup(ctx, s.TTL); err != nil {
 				log.Printf("[WARN] failed to cleanup, %v", err)
 			}
+			if s.Uploads != nil { // staging images removed, forget uploads never committed
+				if err := s.Uploads.Cleanup(time.Now().Add(-s.TTL)); err != nil {
+					log.Printf("[WARN] failed to cleanup uploads, %v", err)
+				}
+			}
 		}
 	}
 }
@@ -176,6 +204,26 @@ func (s *Service) Close(ctx context.Context) {
 		close(s.submitCh)
 	}
 	s.wg.Wait()
+
+	if s.Uploads != nil {
+		if err := s.Uploads.Close(); err != nil {
+			log.Printf("[WARN] can't close uploads store, %v", err)
+		}
+	}
+}
+
+// SignedURL returns temporary direct link to the image if store supports it and links enabled
+func (s *Service) SignedURL(id string) (string, bool) {
+	signer, ok := s.store.(URLSigner)
+	if !ok || s.SignedURLTTL <= 0 {
+		return "", false
+	}
+	u, err := signer.SignedURL(id, s.SignedURLTTL)
+	if err != nil {
+		log.Printf("[WARN] can't make signed url for %s, %v", id, err)
+		return "", false
+	}
+	return u, true
 }
 
 // Load wraps storage Load function.
@@ -201,6 +249,15 @@ func (s *Service) SaveWithID(id string, r io.Reader) (string, error) {
 	return s.store.SaveWithID(id, img)
 }
 
+// Restore stores image data as is, with given id, and commits it. Used to put back images saved to backup,
+// so validation and resizing skipped
+func (s *Service) Restore(id string, img []byte) error {
+	if _, err := s.store.SaveWithID(id, img); err != nil {
+		return errors.Wrapf(err, "can't save image %s", id)
+	}
+	return errors.Wrapf(s.store.Commit(id), "can't commit image %s", id)
+}
+
 func (s *Service) ImgContentType(img []byte) string {
 	contentType := http.DetectContentType(img)
 	if contentType == "application/octet-stream" {
@@ -210,14 +267,56 @@ func (s *Service) ImgContentType(img []byte) string {
 	return contentType
 }
 
-// prepareImage calls readAndValidateImage and resize on provided image.
+// Variant returns id of the image resized to given width, making and storing it on the first request.
+// Returns original id if image is not wider than requested or can't be resized, i.e. animated gif.
+func (s *Service) Variant(id string, width int) (string, error) {
+	allowed := false
+	for _, w := range s.VariantWidths {
+		if w == width {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", errors.Errorf("image width %d not allowed", width)
+	}
+
+	variantID := fmt.Sprintf("%s_w%d", id, width)
+	if _, err := s.store.Load(variantID); err == nil {
+		return variantID, nil
+	}
+
+	img, err := s.store.Load(id)
+	if err != nil {
+		return "", err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(img))
+	if err != nil || cfg.Width <= width {
+		return id, nil // can't decode or small enough
+	}
+	variant := normalize(img, width, maxVariantHeight, s.JPEGQuality)
+	if bytes.Equal(variant, img) {
+		return id, nil // not resized
+	}
+
+	if _, err = s.store.SaveWithID(variantID, variant); err != nil {
+		return "", errors.Wrapf(err, "can't save variant of %s", id)
+	}
+	if err = s.store.Commit(variantID); err != nil {
+		return "", errors.Wrapf(err, "can't commit variant of %s", id)
+	}
+	log.Printf("[DEBUG] image variant %s made, size=%d", variantID, len(variant))
+	return variantID, nil
+}
+
+// prepareImage calls readAndValidateImage and normalize on provided image.
 func (s *Service) prepareImage(r io.Reader) ([]byte, error) {
 	data, err := readAndValidateImage(r, s.MaxSize)
 	if err != nil {
 		return nil, errors.Wrapf(err, "can't load image")
 	}
 
-	data = resize(data, s.MaxWidth, s.MaxHeight)
+	data = normalize(data, s.MaxWidth, s.MaxHeight, s.JPEGQuality)
 	return data, nil
 }
 
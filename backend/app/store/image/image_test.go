@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"image"
+	"image/jpeg"
 	"io"
 	"io/ioutil"
 	"os"
@@ -88,6 +89,78 @@ func TestService_ExtractPictures(t *testing.T) {
 	assert.Equal(t, "user2/pic3.png", ids[1])
 }
 
+func TestService_ExtractPicturesVariant(t *testing.T) {
+	svc := Service{ServiceParams: ServiceParams{ImageAPI: "/blah/"}}
+	ids, err := svc.ExtractPictures(`<img src="/blah/user1/pic1.png?w=320"/>`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1/pic1.png"}, ids)
+}
+
+func TestService_Variant(t *testing.T) {
+	loc, err := ioutil.TempDir("", "test_image_variants")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+
+	store := &FileSystem{Location: loc + "/images", Staging: loc + "/staging", Partitions: 10}
+	svc := NewService(store, ServiceParams{MaxSize: 100000, VariantWidths: []int{100, 320, 1024}})
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 800, 400)), nil))
+	id, err := svc.Save("user1", &buf)
+	require.NoError(t, err)
+	require.NoError(t, store.Commit(id))
+
+	vid, err := svc.Variant(id, 320)
+	require.NoError(t, err)
+	assert.Equal(t, id+"_w320", vid)
+	img, err := svc.Load(vid)
+	require.NoError(t, err)
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(img))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 320, cfg.Width)
+	assert.Equal(t, 160, cfg.Height)
+
+	// variant made once and reused
+	fi, err := os.Stat(store.location(store.Location, vid))
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	vid, err = svc.Variant(id, 320)
+	require.NoError(t, err)
+	assert.Equal(t, id+"_w320", vid)
+	fi2, err := os.Stat(store.location(store.Location, vid))
+	require.NoError(t, err)
+	assert.Equal(t, fi.ModTime(), fi2.ModTime())
+
+	vid, err = svc.Variant(id, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, id, vid, "original is narrower")
+
+	_, err = svc.Variant(id, 500)
+	assert.EqualError(t, err, "image width 500 not allowed")
+
+	_, err = svc.Variant("user1/no-such-image", 100)
+	assert.Error(t, err)
+}
+
+func TestService_Restore(t *testing.T) {
+	loc, err := ioutil.TempDir("", "test_image_restore")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+
+	store := &FileSystem{Location: loc + "/images", Staging: loc + "/staging", Partitions: 10}
+	svc := NewService(store, ServiceParams{MaxSize: 10, MaxWidth: 32, MaxHeight: 32})
+
+	data := []byte("some image data, larger than max size")
+	require.NoError(t, svc.Restore("user1/img.png", data), "restored as is, no validation")
+	img, err := svc.Load("user1/img.png")
+	require.NoError(t, err)
+	assert.Equal(t, data, img)
+
+	_, err = os.Stat(store.location(store.Staging, "user1/img.png"))
+	assert.True(t, os.IsNotExist(err), "committed, nothing left in staging")
+}
+
 func TestService_ExtractPictures2(t *testing.T) {
 	svc := Service{ServiceParams: ServiceParams{ImageAPI: "https://remark42.radio-t.com/api/v1/picture/"}}
 	html := "<p>TLDR: такое в go пока правильно посчитать трудно. То, что они считают это общее количество go packages в коде." +
@@ -0,0 +1,237 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"net/http"
+
+	log "github.com/go-pkgz/lgr"
+	"golang.org/x/image/draw"
+)
+
+const defaultJPEGQuality = 85
+
+// normalize strips metadata and downsizes image to limits. JPEG kept as JPEG, re-encoded with given quality
+// only if resized or rotated according to EXIF orientation, otherwise metadata segments removed without re-encoding.
+// Animated GIF and formats which can't be decoded returned as is.
+func normalize(data []byte, limitW, limitH, quality int) []byte {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return normalizeJPEG(data, limitW, limitH, quality)
+	case "image/png":
+		return resize(stripPNG(data), limitW, limitH)
+	case "image/gif":
+		if isAnimatedGIF(data) {
+			return data
+		}
+		return resize(data, limitW, limitH)
+	}
+	return data
+}
+
+func normalizeJPEG(data []byte, limitW, limitH, quality int) []byte {
+	if quality <= 0 || quality > 100 {
+		quality = defaultJPEGQuality
+	}
+	orientation := jpegOrientation(data)
+	stripped := stripJPEG(data)
+
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("[WARN] can't decode jpeg config, %s", err)
+		return stripped
+	}
+	w, h := cfg.Width, cfg.Height
+	if orientation >= 5 { // rotated by 90 or 270
+		w, h = h, w
+	}
+	needResize := limitW > 0 && limitH > 0 && (w > limitW || h > limitH)
+	if orientation <= 1 && !needResize {
+		return stripped
+	}
+
+	src, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("[WARN] can't decode jpeg, %s", err)
+		return stripped
+	}
+	var res image.Image = orient(src, orientation)
+	if needResize {
+		newW, newH := getProportionalSizes(w, h, limitW, limitH)
+		m := image.NewRGBA(image.Rect(0, 0, newW, newH))
+		draw.CatmullRom.Scale(m, m.Bounds(), res, res.Bounds(), draw.Src, nil)
+		res = m
+	}
+
+	var out bytes.Buffer
+	if err = jpeg.Encode(&out, res, &jpeg.Options{Quality: quality}); err != nil {
+		log.Printf("[WARN] can't encode jpeg, %s", err)
+		return stripped
+	}
+	return out.Bytes()
+}
+
+// stripJPEG removes EXIF, XMP and other application segments and comments, keeps JFIF, ICC profile and Adobe
+// segment, the last one needed to decode colors of CMYK and YCCK images
+func stripJPEG(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return data // broken structure, leave as is
+		}
+		marker := data[pos+1]
+		if marker == 0xDA { // start of scan, the rest is image data
+			out.Write(data[pos:])
+			return out.Bytes()
+		}
+		segLen := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + segLen
+		if segLen < 2 || end > len(data) {
+			return data
+		}
+		isMeta := marker == 0xFE || (marker >= 0xE1 && marker <= 0xEF && marker != 0xE2 && marker != 0xEE)
+		if !isMeta {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+	return data
+}
+
+// jpegOrientation returns value of EXIF orientation tag, 1 (normal) if not found
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF && data[pos+1] != 0xDA {
+		segLen := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + segLen
+		if segLen < 2 || end > len(data) {
+			return 1
+		}
+		seg := data[pos+4 : end]
+		if data[pos+1] == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		pos = end
+	}
+	return 1
+}
+
+// exifOrientation gets orientation tag from IFD0 of TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			if v := int(order.Uint16(tiff[entry+8 : entry+10])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient transforms image according to EXIF orientation, see https://magnushoff.com/articles/jpeg-orientation/
+func orient(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flip horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 270 clockwise
+				dx, dy = y, w-1-x
+			}
+			si, di := rgba.PixOffset(x, y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], rgba.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// stripPNG removes text, time and EXIF chunks
+func stripPNG(data []byte) []byte {
+	const sigLen = 8
+	if len(data) < sigLen {
+		return data
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:sigLen])
+	pos := sigLen
+	for pos+12 <= len(data) {
+		chunkLen := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := pos + 12 + chunkLen
+		if chunkLen < 0 || end > len(data) {
+			return data
+		}
+		switch string(data[pos+4 : pos+8]) {
+		case "tEXt", "zTXt", "iTXt", "eXIf", "tIME":
+		default:
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+	if pos != len(data) {
+		return data
+	}
+	return out.Bytes()
+}
+
+// isAnimatedGIF checks if gif has more than one frame
+func isAnimatedGIF(data []byte) bool {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	return err == nil && len(g.Image) > 1
+}
@@ -0,0 +1,215 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize_JPEGStripMetadata(t *testing.T) {
+	orig := makeJPEG(t, 40, 20)
+	withMeta := addJPEGSegments(orig, exifSegment(binary.LittleEndian, 1), []byte{0xFF, 0xFE, 0x00, 0x09, 'c', 'o', 'm', 'm', 'e', 'n', 't'})
+	require.Contains(t, string(withMeta), "GPS")
+	require.Contains(t, string(withMeta), "comment")
+
+	res := normalize(withMeta, 2400, 900, 0)
+	assert.Equal(t, orig, res, "metadata removed without re-encoding")
+	assert.Equal(t, orig, normalize(orig, 0, 0, 0))
+}
+
+func TestNormalize_JPEGAdobeCMYK(t *testing.T) {
+	orig, err := ioutil.ReadFile("testdata/cmyk.jpg")
+	require.NoError(t, err)
+	origImg, err := jpeg.Decode(bytes.NewReader(orig))
+	require.NoError(t, err)
+	require.IsType(t, &image.CMYK{}, origImg)
+
+	res := normalize(addJPEGSegments(orig, exifSegment(binary.LittleEndian, 1)), 0, 0, 0)
+	assert.Equal(t, orig, res, "exif removed, adobe segment kept")
+	img, err := jpeg.Decode(bytes.NewReader(res))
+	require.NoError(t, err, "4-component jpeg can't be decoded without adobe segment")
+	assert.Equal(t, origImg.At(50, 50), img.At(50, 50))
+}
+
+func TestNormalize_JPEGOrientation(t *testing.T) {
+	orig := makeJPEG(t, 40, 20) // left half red, right half blue
+
+	tbl := []struct {
+		order       binary.ByteOrder
+		orientation int
+		w, h        int
+		redAt       image.Point
+		blueAt      image.Point
+	}{
+		{binary.LittleEndian, 6, 20, 40, image.Pt(10, 5), image.Pt(10, 35)},  // rotated 90 cw, left side on top
+		{binary.BigEndian, 8, 20, 40, image.Pt(10, 35), image.Pt(10, 5)},     // rotated 270 cw, left side at bottom
+		{binary.LittleEndian, 3, 40, 20, image.Pt(35, 10), image.Pt(5, 10)},  // rotated 180
+		{binary.BigEndian, 2, 40, 20, image.Pt(35, 10), image.Pt(5, 10)},     // flipped horizontally
+		{binary.LittleEndian, 4, 40, 20, image.Pt(5, 10), image.Pt(35, 10)},  // flipped vertically
+		{binary.LittleEndian, 5, 20, 40, image.Pt(10, 5), image.Pt(10, 35)},  // transposed
+		{binary.LittleEndian, 7, 20, 40, image.Pt(10, 35), image.Pt(10, 5)},  // transversed
+		{binary.LittleEndian, 42, 40, 20, image.Pt(5, 10), image.Pt(35, 10)}, // invalid, ignored
+	}
+
+	for i, tt := range tbl {
+		data := addJPEGSegments(orig, exifSegment(tt.order, tt.orientation))
+		res := normalize(data, 0, 0, 95)
+		assert.NotContains(t, string(res), "Exif", "case #%d", i)
+		img, err := jpeg.Decode(bytes.NewReader(res))
+		require.NoError(t, err, "case #%d", i)
+		assert.Equal(t, tt.w, img.Bounds().Dx(), "case #%d", i)
+		assert.Equal(t, tt.h, img.Bounds().Dy(), "case #%d", i)
+		assert.True(t, isRed(img.At(tt.redAt.X, tt.redAt.Y)), "case #%d, %v", i, img.At(tt.redAt.X, tt.redAt.Y))
+		assert.True(t, isBlue(img.At(tt.blueAt.X, tt.blueAt.Y)), "case #%d, %v", i, img.At(tt.blueAt.X, tt.blueAt.Y))
+	}
+}
+
+func TestNormalize_JPEGResize(t *testing.T) {
+	data := addJPEGSegments(makeJPEG(t, 400, 200), exifSegment(binary.BigEndian, 1))
+	res := normalize(data, 100, 100, 90)
+	assert.Equal(t, "image/jpeg", http.DetectContentType(res))
+	assert.NotContains(t, string(res), "Exif")
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(res))
+	require.NoError(t, err)
+	assert.Equal(t, 100, cfg.Width)
+	assert.Equal(t, 50, cfg.Height)
+
+	low := normalize(data, 100, 100, 10)
+	assert.True(t, len(low) < len(res), "lower quality, smaller size %d vs %d", len(low), len(res))
+
+	fh, err := os.Open("testdata/circles.jpg")
+	require.NoError(t, err)
+	defer fh.Close()
+	img, err := readAndValidateImage(fh, 32000)
+	require.NoError(t, err)
+	res = normalize(img, 400, 300, 0)
+	assert.Equal(t, "image/jpeg", http.DetectContentType(res), "jpeg kept as jpeg")
+	cfg, err = jpeg.DecodeConfig(bytes.NewReader(res))
+	require.NoError(t, err)
+	assert.True(t, cfg.Width <= 400 && cfg.Height <= 300, cfg)
+}
+
+func TestNormalize_PNG(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20))))
+	orig := buf.Bytes()
+
+	// insert text chunk after IHDR
+	text := []byte("tEXtComment\x00some private info")
+	chunk := make([]byte, 4, 12+len(text))
+	binary.BigEndian.PutUint32(chunk, uint32(len(text)-4))
+	chunk = append(chunk, text...)
+	chunk = append(chunk, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(chunk[len(chunk)-4:], crc32.ChecksumIEEE(text))
+	withText := append(append(append([]byte{}, orig[:33]...), chunk...), orig[33:]...)
+	_, err := png.Decode(bytes.NewReader(withText))
+	require.NoError(t, err)
+
+	assert.Equal(t, orig, normalize(withText, 2400, 900, 0))
+
+	res := normalize(withText, 20, 20, 0)
+	assert.Equal(t, "image/png", http.DetectContentType(res))
+	cfg, err := png.DecodeConfig(bytes.NewReader(res))
+	require.NoError(t, err)
+	assert.Equal(t, 20, cfg.Width)
+	assert.Equal(t, 10, cfg.Height)
+
+	assert.Equal(t, []byte("broken"), stripPNG([]byte("broken")))
+}
+
+func TestNormalize_GIF(t *testing.T) {
+	pal := color.Palette{color.White, color.Black}
+	anim := &gif.GIF{Image: []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 50, 50), pal),
+		image.NewPaletted(image.Rect(0, 0, 50, 50), pal)}, Delay: []int{10, 10}}
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, anim))
+	assert.Equal(t, buf.Bytes(), normalize(buf.Bytes(), 10, 10, 0), "animated gif preserved")
+
+	buf.Reset()
+	require.NoError(t, gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 50, 50), pal), nil))
+	res := normalize(buf.Bytes(), 10, 10, 0)
+	assert.Equal(t, "image/png", http.DetectContentType(res), "single frame gif resized to png")
+
+	assert.Equal(t, []byte("RIFF1234WEBPVP8 "), normalize([]byte("RIFF1234WEBPVP8 "), 10, 10, 0), "unsupported as is")
+}
+
+func TestNormalize_BrokenJPEG(t *testing.T) {
+	assert.Equal(t, 1, jpegOrientation([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00}))
+	assert.Equal(t, 1, jpegOrientation([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x40, 'E', 'x'}))
+	assert.Equal(t, 1, exifOrientation([]byte("XX123456")))
+	broken := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x40, 'E', 'x'}
+	assert.Equal(t, broken, stripJPEG(broken))
+}
+
+func makeJPEG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}))
+	return buf.Bytes()
+}
+
+// exifSegment makes APP1 segment with orientation tag and gps info pointer
+func exifSegment(order binary.ByteOrder, orientation int) []byte {
+	tiff := make([]byte, 8+2+2*12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 2)
+	order.PutUint16(tiff[10:], 0x8825) // gps info
+	order.PutUint16(tiff[12:], 4)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint32(tiff[18:], 0)
+	order.PutUint16(tiff[22:], 0x0112) // orientation
+	order.PutUint16(tiff[24:], 3)
+	order.PutUint32(tiff[26:], 1)
+	order.PutUint16(tiff[30:], uint16(orientation))
+	tiff = append(tiff, []byte("GPS 55.7558N 37.6173E")...)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func addJPEGSegments(data []byte, segments ...[]byte) []byte {
+	res := append([]byte{}, data[:2]...)
+	for _, s := range segments {
+		res = append(res, s...)
+	}
+	return append(res, data[2:]...)
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xc000 && g < 0x4000 && b < 0x4000
+}
+
+func isBlue(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return b > 0xc000 && r < 0x4000 && g < 0x4000
+}
@@ -56,3 +56,20 @@ func (r *RPC) Cleanup(_ context.Context, ttl time.Duration) error {
 	_, err := r.Call("image.cleanup", ttl)
 	return err
 }
+
+// List committed images of the remote store
+func (r *RPC) List(_ context.Context) ([]Info, error) {
+	resp, err := r.Call("image.list")
+	if err != nil {
+		return nil, err
+	}
+	var res []Info
+	err = json.Unmarshal(*resp.Result, &res)
+	return res, err
+}
+
+// Delete committed image from the remote store
+func (r *RPC) Delete(id string) error {
+	_, err := r.Call("image.delete", id)
+	return err
+}
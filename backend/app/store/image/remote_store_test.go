@@ -88,3 +88,26 @@ func testServer(t *testing.T, req, resp string) *httptest.Server {
 		_, _ = fmt.Fprint(w, resp)
 	}))
 }
+
+func TestRemote_List(t *testing.T) {
+	ts := testServer(t, `{"method":"image.list","id":1}`,
+		`{"result":[{"id":"user1/pic","size":123,"modified":"2020-01-02T03:04:05Z"}],"id":1}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	var l Lister = &c
+	_ = l
+
+	res, err := c.List(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []Info{{ID: "user1/pic", Size: 123, Modified: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}}, res)
+}
+
+func TestRemote_Delete(t *testing.T) {
+	ts := testServer(t, `{"method":"image.delete","params":"gopher_id","id":1}`, `{"id":1}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	err := c.Delete("gopher_id")
+	assert.NoError(t, err)
+}
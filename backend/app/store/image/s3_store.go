@@ -0,0 +1,245 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/sigv4"
+)
+
+// S3 provides image Store for S3 compatible storage, i.e. AWS, MinIO or Ceph. Uses path-style requests.
+// Committed images kept under Prefix/images and staging images under Prefix/staging.
+type S3 struct {
+	Endpoint  string // i.e. https://s3.amazonaws.com or http://minio:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string
+	Client    http.Client
+
+	now func() time.Time // for tests
+}
+
+// URLSigner implemented by stores able to make temporary direct links to images
+type URLSigner interface {
+	SignedURL(id string, ttl time.Duration) (string, error)
+}
+
+const s3ListPageSize = 1000
+
+// errS3NotFound returned for missing objects
+var errS3NotFound = errors.New("not found")
+
+// SaveWithID saves image to staging for given id
+func (s *S3) SaveWithID(id string, img []byte) (string, error) {
+	hdrs := map[string]string{"Content-Type": http.DetectContentType(img)}
+	if _, err := s.request(context.Background(), "PUT", s.stagingKey(id), nil, hdrs, img); err != nil {
+		return "", errors.Wrapf(err, "can't save image %s", id)
+	}
+	log.Printf("[DEBUG] image %s saved to s3 staging, size=%d", id, len(img))
+	return id, nil
+}
+
+// Save image to staging. Returns id as user/uuid
+func (s *S3) Save(userID string, img []byte) (id string, err error) {
+	return s.SaveWithID(path.Join(userID, guid()), img)
+}
+
+// Commit image by copying it from staging to permanent location and removing staging copy
+func (s *S3) Commit(id string) error {
+	log.Printf("[DEBUG] commit image %s", id)
+	src := "/" + s.Bucket + "/" + sigv4.EscapePath(s.stagingKey(id))
+	hdrs := map[string]string{"x-amz-copy-source": src}
+	if _, err := s.request(context.Background(), "PUT", s.imageKey(id), nil, hdrs, nil); err != nil {
+		return errors.Wrapf(err, "failed to commit image %s", id)
+	}
+	if _, err := s.request(context.Background(), "DELETE", s.stagingKey(id), nil, nil, nil); err != nil {
+		log.Printf("[WARN] can't remove staging image %s, %v", id, err)
+	}
+	return nil
+}
+
+// Load image by id, from permanent location or from staging if not committed yet
+func (s *S3) Load(id string) ([]byte, error) {
+	img, err := s.request(context.Background(), "GET", s.imageKey(id), nil, nil, nil)
+	if err == errS3NotFound {
+		img, err = s.request(context.Background(), "GET", s.stagingKey(id), nil, nil, nil)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't load image %s", id)
+	}
+	return img, nil
+}
+
+// Cleanup removes staging images older than ttl
+func (s *S3) Cleanup(ctx context.Context, ttl time.Duration) error {
+	return s.list(ctx, s.stagingPrefix(), func(obj s3ListEntry) error {
+		age := s.timeNow().Sub(obj.LastModified)
+		if age <= ttl+100*time.Millisecond { // delay cleanup triggering to allow commit
+			return nil
+		}
+		log.Printf("[INFO] remove staging image %s, age %v", obj.Key, age)
+		_, err := s.request(ctx, "DELETE", obj.Key, nil, nil, nil)
+		return errors.Wrapf(err, "failed to remove %s", obj.Key)
+	})
+}
+
+// List committed images
+func (s *S3) List(ctx context.Context) ([]Info, error) {
+	res := []Info{}
+	prefix := s.imageKey("") + "/"
+	err := s.list(ctx, prefix, func(obj s3ListEntry) error {
+		res = append(res, Info{ID: strings.TrimPrefix(obj.Key, prefix), Size: obj.Size, Modified: obj.LastModified})
+		return nil
+	})
+	return res, err
+}
+
+// Delete committed image
+func (s *S3) Delete(id string) error {
+	_, err := s.request(context.Background(), "DELETE", s.imageKey(id), nil, nil, nil)
+	return errors.Wrapf(err, "failed to remove image %s", id)
+}
+
+// SignedURL makes temporary direct link to the image, committed or staging
+func (s *S3) SignedURL(id string, ttl time.Duration) (string, error) {
+	key := s.imageKey(id)
+	_, err := s.request(context.Background(), "HEAD", key, nil, nil, nil)
+	if err == errS3NotFound {
+		key = s.stagingKey(id)
+		_, err = s.request(context.Background(), "HEAD", key, nil, nil, nil)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "can't get image %s", id)
+	}
+
+	req, err := http.NewRequest("GET", s.objectURL(key), nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "can't make request for %s", id)
+	}
+	s.signer().Presign(req, ttl, s.timeNow())
+	return req.URL.String(), nil
+}
+
+// request makes signed request for the object key, or for the bucket if key empty, and returns response body
+func (s *S3) request(ctx context.Context, method, key string, query url.Values, hdrs map[string]string, body []byte) ([]byte, error) {
+	u := s.objectURL(key)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't make %s request for %s", method, key)
+	}
+	req = req.WithContext(ctx)
+	for k, v := range hdrs {
+		req.Header.Set(k, v)
+	}
+	s.signer().Sign(req, sigv4.HashHex(body), s.timeNow())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s request for %s failed", method, key)
+	}
+	defer resp.Body.Close() // nolint
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024*1024))
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read response for %s", key)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errS3NotFound
+	}
+	if resp.StatusCode >= 300 {
+		var errResp struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if e := xml.Unmarshal(respBody, &errResp); e == nil && errResp.Code != "" {
+			return nil, errors.Errorf("%s request for %s failed with %d, %s: %s", method, key, resp.StatusCode,
+				errResp.Code, errResp.Message)
+		}
+		return nil, errors.Errorf("%s request for %s failed with %d", method, key, resp.StatusCode)
+	}
+	return respBody, nil
+}
+
+// s3ListEntry is an object entry of ListObjectsV2 response
+type s3ListEntry struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// list calls fn for every object with prefix, going through all pages of ListObjectsV2
+func (s *S3) list(ctx context.Context, prefix string, fn func(obj s3ListEntry) error) error {
+	var listResp struct {
+		Contents              []s3ListEntry `xml:"Contents"`
+		IsTruncated           bool          `xml:"IsTruncated"`
+		NextContinuationToken string        `xml:"NextContinuationToken"`
+	}
+
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "max-keys": {strconv.Itoa(s3ListPageSize)}}
+	for {
+		body, err := s.request(ctx, "GET", "", query, nil, nil)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list %s", prefix)
+		}
+		listResp.Contents, listResp.IsTruncated, listResp.NextContinuationToken = nil, false, ""
+		if err = xml.Unmarshal(body, &listResp); err != nil {
+			return errors.Wrapf(err, "failed to decode list of %s", prefix)
+		}
+		for _, obj := range listResp.Contents {
+			if err = fn(obj); err != nil {
+				return err
+			}
+		}
+		if !listResp.IsTruncated || listResp.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", listResp.NextContinuationToken)
+	}
+}
+
+func (s *S3) objectURL(key string) string {
+	u := strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket
+	if key != "" {
+		u += "/" + sigv4.EscapePath(key)
+	}
+	return u
+}
+
+func (s *S3) imageKey(id string) string {
+	return path.Join(s.Prefix, "images", id)
+}
+
+func (s *S3) stagingKey(id string) string {
+	return path.Join(s.stagingPrefix(), id)
+}
+
+func (s *S3) stagingPrefix() string {
+	return path.Join(s.Prefix, "staging") + "/"
+}
+
+func (s *S3) signer() sigv4.Signer {
+	return sigv4.Signer{AccessKey: s.AccessKey, SecretKey: s.SecretKey, Region: s.Region}
+}
+
+func (s *S3) timeNow() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,356 @@
+package image
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/sigv4"
+)
+
+func TestS3_SaveCommitLoad(t *testing.T) {
+	s3, stub, teardown := prepS3(t)
+	defer teardown()
+
+	id, err := s3.Save("user1", gopherPNGBytes())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(id, "user1/"), id)
+	assert.Equal(t, []string{"pfx/staging/" + id}, stub.keys())
+	assert.Equal(t, "image/png", stub.object("pfx/staging/"+id).contentType)
+
+	img, err := s3.Load(id)
+	require.NoError(t, err, "loaded from staging")
+	assert.Equal(t, gopherPNGBytes(), img)
+
+	require.NoError(t, s3.Commit(id))
+	assert.Equal(t, []string{"pfx/images/" + id}, stub.keys(), "moved from staging")
+	img, err = s3.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, gopherPNGBytes(), img)
+
+	_, err = s3.SaveWithID("user2/some id.png", []byte("blah"))
+	require.NoError(t, err)
+	img, err = s3.Load("user2/some id.png")
+	require.NoError(t, err)
+	assert.Equal(t, "blah", string(img))
+
+	_, err = s3.Load("user1/no-such-image")
+	assert.EqualError(t, err, "can't load image user1/no-such-image: not found")
+	assert.Error(t, s3.Commit("user1/no-such-image"))
+}
+
+func TestS3_Cleanup(t *testing.T) {
+	s3, stub, teardown := prepS3(t)
+	defer teardown()
+	stub.pageSize = 2
+
+	ids := []string{}
+	for i := 0; i < 5; i++ {
+		id, err := s3.Save("user1", []byte(fmt.Sprintf("image %d", i)))
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	require.NoError(t, s3.Commit(ids[0]))
+	stub.setModified("pfx/images/"+ids[0], time.Now().Add(-time.Hour))
+	stub.setModified("pfx/staging/"+ids[1], time.Now().Add(-time.Hour))
+	stub.setModified("pfx/staging/"+ids[3], time.Now().Add(-time.Hour))
+
+	require.NoError(t, s3.Cleanup(context.Background(), time.Minute))
+	expected := []string{"pfx/images/" + ids[0], "pfx/staging/" + ids[2], "pfx/staging/" + ids[4]}
+	sort.Strings(expected)
+	assert.Equal(t, expected, stub.keys())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, s3.Cleanup(ctx, time.Minute))
+}
+
+func TestS3_ListDelete(t *testing.T) {
+	s3, stub, teardown := prepS3(t)
+	defer teardown()
+	stub.pageSize = 2
+
+	ids := []string{}
+	for i := 0; i < 4; i++ {
+		id, err := s3.Save("user1", []byte(fmt.Sprintf("image %d", i)))
+		require.NoError(t, err)
+		require.NoError(t, s3.Commit(id))
+		ids = append(ids, id)
+	}
+	_, err := s3.Save("user2", []byte("staging image"))
+	require.NoError(t, err)
+	sort.Strings(ids)
+	ts := time.Now().Add(-time.Hour).Truncate(time.Second)
+	stub.setModified("pfx/images/"+ids[1], ts)
+
+	list, err := s3.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 4, len(list))
+	for i, info := range list {
+		assert.Equal(t, ids[i], info.ID)
+		assert.Equal(t, int64(7), info.Size)
+	}
+	assert.True(t, ts.Equal(list[1].Modified), list[1].Modified)
+
+	require.NoError(t, s3.Delete(ids[0]))
+	list, err = s3.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(list))
+	assert.Equal(t, ids[1], list[0].ID)
+	assert.NotContains(t, stub.keys(), "pfx/images/"+ids[0])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s3.List(ctx)
+	assert.Error(t, err)
+}
+
+func TestS3_SignedURL(t *testing.T) {
+	s3, _, teardown := prepS3(t)
+	defer teardown()
+
+	id, err := s3.Save("user1", gopherPNGBytes())
+	require.NoError(t, err)
+
+	get := func(u string) (int, []byte) {
+		resp, e := http.Get(u)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		body, e := ioutil.ReadAll(resp.Body)
+		require.NoError(t, e)
+		return resp.StatusCode, body
+	}
+
+	u, err := s3.SignedURL(id, time.Minute)
+	require.NoError(t, err)
+	assert.Contains(t, u, "/pfx/staging/"+id, "staging image")
+	code, body := get(u)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, gopherPNGBytes(), body)
+
+	require.NoError(t, s3.Commit(id))
+	u, err = s3.SignedURL(id, time.Minute)
+	require.NoError(t, err)
+	assert.Contains(t, u, "/pfx/images/"+id)
+	code, _ = get(u)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = get(strings.Replace(u, id, "user1/another", 1))
+	assert.Equal(t, http.StatusForbidden, code, "signature mismatch")
+
+	s3.now = func() time.Time { return time.Now().Add(-2 * time.Minute) }
+	u, err = s3.SignedURL(id, time.Minute)
+	require.NoError(t, err)
+	code, _ = get(u)
+	assert.Equal(t, http.StatusForbidden, code, "expired")
+
+	_, err = s3.SignedURL("user1/no-such-image", time.Minute)
+	assert.Error(t, err)
+
+	svc := NewService(s3, ServiceParams{SignedURLTTL: time.Minute})
+	s3.now = nil
+	u, ok := svc.SignedURL(id)
+	assert.True(t, ok)
+	assert.Contains(t, u, "X-Amz-Signature=")
+	_, ok = svc.SignedURL("user1/no-such-image")
+	assert.False(t, ok)
+	_, ok = NewService(s3, ServiceParams{}).SignedURL(id)
+	assert.False(t, ok, "links disabled")
+	_, ok = NewService(&FileSystem{}, ServiceParams{SignedURLTTL: time.Minute}).SignedURL(id)
+	assert.False(t, ok, "not supported by store")
+}
+
+func TestS3_BadCredentials(t *testing.T) {
+	s3, _, teardown := prepS3(t)
+	defer teardown()
+	s3.SecretKey = "bad"
+
+	_, err := s3.Save("user1", gopherPNGBytes())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed with 403, SignatureDoesNotMatch")
+}
+
+func prepS3(t *testing.T) (s3 *S3, stub *s3Stub, teardown func()) {
+	stub = &s3Stub{t: t, bucket: "remark", objects: map[string]s3Object{}, pageSize: 1000,
+		signer: sigv4.Signer{AccessKey: "key", SecretKey: "secret", Region: "us-east-1"}}
+	ts := httptest.NewServer(stub)
+	s3 = &S3{Endpoint: ts.URL, Region: "us-east-1", Bucket: "remark", AccessKey: "key", SecretKey: "secret",
+		Prefix: "pfx", Client: http.Client{Timeout: time.Second}}
+	return s3, stub, ts.Close
+}
+
+// s3Stub is a minimal in-memory S3 compatible server, verifies signatures
+type s3Stub struct {
+	t        *testing.T
+	bucket   string
+	signer   sigv4.Signer
+	pageSize int
+
+	lock    sync.Mutex
+	objects map[string]s3Object
+}
+
+type s3Object struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+func (st *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(st.t, err)
+	if !st.checkAuth(r, body) {
+		st.sendError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	elems := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if elems[0] != st.bucket {
+		st.sendError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if len(elems) == 1 || elems[1] == "" {
+		st.list(w, r)
+		return
+	}
+	key := elems[1]
+
+	switch r.Method {
+	case "PUT":
+		if src := r.Header.Get("x-amz-copy-source"); src != "" {
+			srcPath, e := url.PathUnescape(src)
+			require.NoError(st.t, e)
+			obj, ok := st.objects[strings.TrimPrefix(srcPath, "/"+st.bucket+"/")]
+			if !ok {
+				st.sendError(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+			obj.modified = time.Now()
+			st.objects[key] = obj
+			_, _ = w.Write([]byte(`<CopyObjectResult><LastModified>` + obj.modified.Format(time.RFC3339) + `</LastModified></CopyObjectResult>`))
+			return
+		}
+		st.objects[key] = s3Object{data: body, contentType: r.Header.Get("Content-Type"), modified: time.Now()}
+	case "GET", "HEAD":
+		obj, ok := st.objects[key]
+		if !ok {
+			st.sendError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		if r.Method == "GET" {
+			_, _ = w.Write(obj.data)
+		}
+	case "DELETE":
+		delete(st.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (st *s3Stub) list(w http.ResponseWriter, r *http.Request) {
+	prefix, token := r.URL.Query().Get("prefix"), r.URL.Query().Get("continuation-token")
+	keys := []string{}
+	for k := range st.objects {
+		if strings.HasPrefix(k, prefix) && k > token {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		LastModified string
+		Size         int
+	}
+	resp := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{}
+	for _, k := range keys {
+		if len(resp.Contents) == st.pageSize {
+			resp.IsTruncated = true
+			resp.NextContinuationToken = resp.Contents[len(resp.Contents)-1].Key
+			break
+		}
+		resp.Contents = append(resp.Contents, content{Key: k, Size: len(st.objects[k].data),
+			LastModified: st.objects[k].modified.UTC().Format("2006-01-02T15:04:05.000Z")})
+	}
+	require.NoError(st.t, xml.NewEncoder(w).Encode(resp))
+}
+
+// checkAuth verifies header or query signature of the request
+func (st *s3Stub) checkAuth(r *http.Request, body []byte) bool {
+	q := r.URL.Query()
+	if sig := q.Get("X-Amz-Signature"); sig != "" {
+		ts, err := time.Parse(sigv4.TimeFormat, q.Get("X-Amz-Date"))
+		require.NoError(st.t, err)
+		expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+		require.NoError(st.t, err)
+		if time.Since(ts) > time.Duration(expires)*time.Second {
+			return false
+		}
+		return sig == st.signer.Signature(r, q, []string{"host"}, sigv4.UnsignedPayload, q.Get("X-Amz-Date"))
+	}
+
+	payloadHash := r.Header.Get("x-amz-content-sha256")
+	if payloadHash != sigv4.HashHex(body) {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	elems := strings.Split(strings.TrimPrefix(auth, sigv4.Algorithm+" "), ", ")
+	if len(elems) != 3 {
+		return false
+	}
+	headers := strings.Split(strings.TrimPrefix(elems[1], "SignedHeaders="), ";")
+	sig := strings.TrimPrefix(elems[2], "Signature=")
+	return sig == st.signer.Signature(r, q, headers, payloadHash, r.Header.Get("x-amz-date"))
+}
+
+func (st *s3Stub) sendError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, strings.ToLower(code))
+}
+
+func (st *s3Stub) keys() []string {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	res := []string{}
+	for k := range st.objects {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (st *s3Stub) object(key string) s3Object {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	return st.objects[key]
+}
+
+func (st *s3Stub) setModified(key string, ts time.Time) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	obj := st.objects[key]
+	obj.modified = ts
+	st.objects[key] = obj
+}
@@ -0,0 +1,145 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Upload limit errors returned by Service.Upload
+var (
+	ErrUserQuota    = errors.New("user's images quota exceeded")
+	ErrSiteQuota    = errors.New("site's images quota exceeded")
+	ErrDailyUploads = errors.New("daily images limit exceeded")
+)
+
+// ErrUploadNotFound returned by UploadStore.Get for unknown upload id
+var ErrUploadNotFound = errors.New("upload not found")
+
+// Upload describes image uploaded by user
+type Upload struct {
+	ID        string    `json:"id"`
+	SiteID    string    `json:"site"`
+	UserID    string    `json:"user_id"`
+	Size      int64     `json:"size"`
+	Timestamp time.Time `json:"time"`
+	Committed bool      `json:"committed"` // false till image moved from staging
+}
+
+// UploadStats summarizes uploads to the site
+type UploadStats struct {
+	SiteSize  int64 // size of all images uploaded to the site
+	UserSize  int64 // size of images uploaded by user to the site
+	UserCount int   // number of images uploaded by user to the site after given time
+}
+
+// UploadStore keeps records of images uploaded by users, used for upload limits and moderation
+type UploadStore interface {
+	Add(u Upload) error                                                // add record of new upload
+	Get(id string) (Upload, error)                                     // record of the upload, ErrUploadNotFound for unknown id
+	Commit(id string) error                                            // mark upload committed, ignores unknown ids
+	Delete(id string) error                                            // remove record, ignores unknown ids
+	List(siteID string, limit int) ([]Upload, error)                   // recent uploads to the site, newest first
+	Stats(siteID, userID string, since time.Time) (UploadStats, error) // totals for the site and the user
+	Cleanup(before time.Time) error                                    // remove records not committed before given time
+	Close() error                                                      // close store
+}
+
+// UploadLimits defines quotas for uploaded images, zero value means no limit
+type UploadLimits struct {
+	UserSize   int64 // max total size of images uploaded by user to the site
+	SiteSize   int64 // max total size of images uploaded to the site
+	DailyCount int   // max number of images uploaded by user to the site in 24h
+}
+
+// Upload validates, resizes and saves image uploaded by user to the site. Checks upload limits if Uploads set
+// and records the upload.
+func (s *Service) Upload(siteID, userID string, r io.Reader) (id string, err error) {
+	img, err := s.prepareImage(r)
+	if err != nil {
+		return "", err
+	}
+	if s.Uploads == nil {
+		return s.store.Save(userID, img)
+	}
+
+	s.uploadsLock.Lock() // check and record in one step, to keep concurrent uploads in limits
+	defer s.uploadsLock.Unlock()
+
+	if err = s.checkUploadLimits(siteID, userID, int64(len(img))); err != nil {
+		return "", err
+	}
+	if id, err = s.store.Save(userID, img); err != nil {
+		return "", err
+	}
+	u := Upload{ID: id, SiteID: siteID, UserID: userID, Size: int64(len(img)), Timestamp: time.Now()}
+	if err = s.Uploads.Add(u); err != nil {
+		log.Printf("[WARN] can't record upload of %s, %v", id, err)
+	}
+	return id, nil
+}
+
+// Delete removes committed image with all its variants
+func (s *Service) Delete(id string) error {
+	lister, ok := s.store.(Lister)
+	if !ok {
+		return errors.New("image store doesn't support removal")
+	}
+	if err := lister.Delete(id); err != nil {
+		return err
+	}
+	for _, w := range s.VariantWidths {
+		_ = lister.Delete(fmt.Sprintf("%s_w%d", id, w)) // variants made on request, may not exist
+	}
+	s.forgetUpload(id)
+	return nil
+}
+
+func (s *Service) checkUploadLimits(siteID, userID string, size int64) error {
+	lim := s.UploadLimits
+	if lim == (UploadLimits{}) {
+		return nil
+	}
+	stats, err := s.Uploads.Stats(siteID, userID, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return errors.Wrapf(err, "can't get uploads of %s", userID)
+	}
+	if lim.DailyCount > 0 && stats.UserCount >= lim.DailyCount {
+		return ErrDailyUploads
+	}
+	if lim.UserSize > 0 && stats.UserSize+size > lim.UserSize {
+		return ErrUserQuota
+	}
+	if lim.SiteSize > 0 && stats.SiteSize+size > lim.SiteSize {
+		return ErrSiteQuota
+	}
+	return nil
+}
+
+// addStats adds upload to the stats of the site and the user
+func addStats(stats UploadStats, u Upload, siteID, userID string, since time.Time) UploadStats {
+	if u.SiteID != siteID {
+		return stats
+	}
+	stats.SiteSize += u.Size
+	if u.UserID == userID {
+		stats.UserSize += u.Size
+		if !u.Timestamp.Before(since) {
+			stats.UserCount++
+		}
+	}
+	return stats
+}
+
+// forgetUpload removes record of removed image
+func (s *Service) forgetUpload(id string) {
+	if s.Uploads == nil {
+		return
+	}
+	if err := s.Uploads.Delete(id); err != nil {
+		log.Printf("[WARN] can't remove upload record %s, %v", id, err)
+	}
+}
@@ -0,0 +1,208 @@
+package image
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"github.com/pkg/errors"
+)
+
+const uploadsBktName = "uploads"
+const uploadIDsBktName = "uploadIDs"
+
+// BoltUploads implements UploadStore keeping records in bolt DB. Uploads bucket has sub-bucket for each site with
+// big-endian timestamp and id as a key, so cursor iteration goes in the order of uploads. Second bucket maps ids
+// to the site and the key.
+type BoltUploads struct {
+	db *bolt.DB
+}
+
+// NewBoltUploads makes bolt uploads store
+func NewBoltUploads(fileName string, options bolt.Options) (*BoltUploads, error) {
+	db, err := bolt.Open(fileName, 0600, &options)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bktName := range []string{uploadsBktName, uploadIDsBktName} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", bktName)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to initialize boltdb db %q buckets", fileName)
+	}
+	return &BoltUploads{db: db}, nil
+}
+
+// Add record of new upload
+func (b *BoltUploads) Add(u Upload) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.Bucket([]byte(uploadsBktName)).CreateBucketIfNotExists([]byte(u.SiteID))
+		if err != nil {
+			return errors.Wrapf(err, "can't make bucket for %s", u.SiteID)
+		}
+		key := uploadKey(u)
+		if err = putUpload(bkt, key, u); err != nil {
+			return err
+		}
+		ref := append([]byte(u.SiteID+"\x00"), key...)
+		return errors.Wrapf(tx.Bucket([]byte(uploadIDsBktName)).Put([]byte(u.ID), ref), "can't put id of %s", u.ID)
+	})
+}
+
+// Get returns record of the upload, ErrUploadNotFound if id not known
+func (b *BoltUploads) Get(id string) (u Upload, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bkt, key := b.locate(tx, id)
+		if bkt == nil {
+			return ErrUploadNotFound
+		}
+		return errors.Wrapf(json.Unmarshal(bkt.Get(key), &u), "can't unmarshal upload %s", id)
+	})
+	return u, err
+}
+
+// Commit marks upload committed
+func (b *BoltUploads) Commit(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt, key := b.locate(tx, id)
+		if bkt == nil {
+			return nil
+		}
+		u := Upload{}
+		if err := json.Unmarshal(bkt.Get(key), &u); err != nil {
+			return errors.Wrapf(err, "can't unmarshal upload %s", id)
+		}
+		u.Committed = true
+		return putUpload(bkt, key, u)
+	})
+}
+
+// Delete record of upload
+func (b *BoltUploads) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt, key := b.locate(tx, id)
+		if bkt == nil {
+			return nil
+		}
+		if err := bkt.Delete(key); err != nil {
+			return errors.Wrapf(err, "can't delete upload %s", id)
+		}
+		return errors.Wrapf(tx.Bucket([]byte(uploadIDsBktName)).Delete([]byte(id)), "can't delete id of %s", id)
+	})
+}
+
+// List returns up to limit recent uploads to the site, newest first. All uploads returned if limit is 0
+func (b *BoltUploads) List(siteID string, limit int) ([]Upload, error) {
+	res := []Upload{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(uploadsBktName)).Bucket([]byte(siteID))
+		if bkt == nil {
+			return nil // no uploads to the site yet
+		}
+		c := bkt.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			u := Upload{}
+			if err := json.Unmarshal(v, &u); err != nil {
+				return errors.Wrapf(err, "can't unmarshal upload %s", k[8:])
+			}
+			res = append(res, u)
+			if limit > 0 && len(res) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Stats returns totals of uploads to the site and uploads made by the user
+func (b *BoltUploads) Stats(siteID, userID string, since time.Time) (UploadStats, error) {
+	res := UploadStats{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(uploadsBktName)).Bucket([]byte(siteID))
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			u := Upload{}
+			if err := json.Unmarshal(v, &u); err != nil {
+				return errors.Wrapf(err, "can't unmarshal upload %s", k[8:])
+			}
+			res = addStats(res, u, siteID, userID, since)
+			return nil
+		})
+	})
+	return res, err
+}
+
+// Cleanup removes records not committed before given time
+func (b *BoltUploads) Cleanup(before time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		uploadsBkt, idsBkt := tx.Bucket([]byte(uploadsBktName)), tx.Bucket([]byte(uploadIDsBktName))
+		return uploadsBkt.ForEach(func(siteID, _ []byte) error {
+			bkt := uploadsBkt.Bucket(siteID)
+			expired := []Upload{}
+			c := bkt.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				u := Upload{}
+				if err := json.Unmarshal(v, &u); err != nil {
+					return errors.Wrapf(err, "can't unmarshal upload %s", k[8:])
+				}
+				if !u.Timestamp.Before(before) {
+					break // records ordered by time, nothing older left
+				}
+				if !u.Committed {
+					expired = append(expired, u)
+				}
+			}
+			for _, u := range expired {
+				if err := bkt.Delete(uploadKey(u)); err != nil {
+					return errors.Wrapf(err, "can't delete upload %s", u.ID)
+				}
+				if err := idsBkt.Delete([]byte(u.ID)); err != nil {
+					return errors.Wrapf(err, "can't delete id of %s", u.ID)
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Close bolt store
+func (b *BoltUploads) Close() error {
+	return b.db.Close()
+}
+
+// locate returns site bucket and key of the upload, nil bucket if id not known
+func (b *BoltUploads) locate(tx *bolt.Tx, id string) (bkt *bolt.Bucket, key []byte) {
+	ref := tx.Bucket([]byte(uploadIDsBktName)).Get([]byte(id))
+	if ref == nil {
+		return nil, nil
+	}
+	for i, c := range ref {
+		if c == 0 {
+			return tx.Bucket([]byte(uploadsBktName)).Bucket(ref[:i]), append([]byte{}, ref[i+1:]...)
+		}
+	}
+	return nil, nil
+}
+
+func uploadKey(u Upload) []byte {
+	key := make([]byte, 8, 8+len(u.ID))
+	binary.BigEndian.PutUint64(key, uint64(u.Timestamp.UnixNano()))
+	return append(key, u.ID...)
+}
+
+func putUpload(bkt *bolt.Bucket, key []byte, u Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal upload %s", u.ID)
+	}
+	return errors.Wrapf(bkt.Put(key, data), "can't put upload %s", u.ID)
+}
@@ -0,0 +1,112 @@
+package image
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryUploads implements UploadStore keeping records in memory, records lost on restart
+type MemoryUploads struct {
+	lock    sync.RWMutex
+	uploads []Upload // ordered by time
+}
+
+// NewMemoryUploads makes in-memory uploads store
+func NewMemoryUploads() *MemoryUploads {
+	return &MemoryUploads{}
+}
+
+// Add record of new upload
+func (m *MemoryUploads) Add(u Upload) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	idx := sort.Search(len(m.uploads), func(i int) bool { return m.uploads[i].Timestamp.After(u.Timestamp) })
+	m.uploads = append(m.uploads, Upload{})
+	copy(m.uploads[idx+1:], m.uploads[idx:])
+	m.uploads[idx] = u
+	return nil
+}
+
+// Get returns record of the upload, ErrUploadNotFound if id not known
+func (m *MemoryUploads) Get(id string) (Upload, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, u := range m.uploads {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return Upload{}, ErrUploadNotFound
+}
+
+// Commit marks upload committed
+func (m *MemoryUploads) Commit(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i := range m.uploads {
+		if m.uploads[i].ID == id {
+			m.uploads[i].Committed = true
+		}
+	}
+	return nil
+}
+
+// Delete record of upload
+func (m *MemoryUploads) Delete(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := m.uploads[:0]
+	for _, u := range m.uploads {
+		if u.ID != id {
+			res = append(res, u)
+		}
+	}
+	m.uploads = res
+	return nil
+}
+
+// List returns up to limit recent uploads to the site, newest first. All uploads returned if limit is 0
+func (m *MemoryUploads) List(siteID string, limit int) ([]Upload, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	res := []Upload{}
+	for i := len(m.uploads) - 1; i >= 0; i-- {
+		if m.uploads[i].SiteID != siteID {
+			continue
+		}
+		res = append(res, m.uploads[i])
+		if limit > 0 && len(res) >= limit {
+			break
+		}
+	}
+	return res, nil
+}
+
+// Stats returns totals of uploads to the site and uploads made by the user
+func (m *MemoryUploads) Stats(siteID, userID string, since time.Time) (UploadStats, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	res := UploadStats{}
+	for _, u := range m.uploads {
+		res = addStats(res, u, siteID, userID, since)
+	}
+	return res, nil
+}
+
+// Cleanup removes records not committed before given time
+func (m *MemoryUploads) Cleanup(before time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := m.uploads[:0]
+	for _, u := range m.uploads {
+		if u.Committed || !u.Timestamp.Before(before) {
+			res = append(res, u)
+		}
+	}
+	m.uploads = res
+	return nil
+}
+
+// Close does nothing for memory store
+func (m *MemoryUploads) Close() error { return nil }
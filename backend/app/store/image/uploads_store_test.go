@@ -0,0 +1,94 @@
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryUploads(t *testing.T) {
+	checkUploadStore(t, NewMemoryUploads())
+}
+
+func TestBoltUploads(t *testing.T) {
+	loc, err := ioutil.TempDir("", "test_uploads_r42")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+
+	st, err := NewBoltUploads(path.Join(loc, "uploads.db"), bolt.Options{})
+	require.NoError(t, err)
+	checkUploadStore(t, st)
+
+	_, err = NewBoltUploads("/dev/null/uploads.db", bolt.Options{})
+	assert.Error(t, err)
+}
+
+// checkUploadStore runs the same checks for any UploadStore implementation
+func checkUploadStore(t *testing.T, st UploadStore) {
+	defer func() { assert.NoError(t, st.Close()) }()
+	now := time.Now()
+
+	uploads := []Upload{
+		{ID: "user1/pic1", SiteID: "site1", UserID: "user1", Size: 100, Timestamp: now.Add(-48 * time.Hour), Committed: true},
+		{ID: "user1/pic2", SiteID: "site1", UserID: "user1", Size: 200, Timestamp: now.Add(-2 * time.Hour)},
+		{ID: "user2/pic3", SiteID: "site1", UserID: "user2", Size: 300, Timestamp: now.Add(-time.Hour)},
+		{ID: "user1/pic4", SiteID: "site2", UserID: "user1", Size: 400, Timestamp: now.Add(-time.Minute)},
+		{ID: "user1/pic5", SiteID: "site1", UserID: "user1", Size: 500, Timestamp: now},
+	}
+	for _, u := range uploads {
+		require.NoError(t, st.Add(u))
+	}
+
+	list, err := st.List("site1", 0)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(list))
+	assert.Equal(t, []string{"user1/pic5", "user2/pic3", "user1/pic2", "user1/pic1"},
+		[]string{list[0].ID, list[1].ID, list[2].ID, list[3].ID}, "newest first")
+	assert.Equal(t, uploads[2].Size, list[1].Size)
+	assert.True(t, uploads[2].Timestamp.Equal(list[1].Timestamp))
+	list, err = st.List("site1", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(list))
+	list, err = st.List("no-such-site", 10)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	stats, err := st.Stats("site1", "user1", now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, UploadStats{SiteSize: 1100, UserSize: 800, UserCount: 2}, stats)
+	stats, err = st.Stats("site2", "user2", now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, UploadStats{SiteSize: 400}, stats)
+
+	u, err := st.Get("user1/pic4")
+	require.NoError(t, err)
+	assert.Equal(t, "site2", u.SiteID)
+	assert.Equal(t, int64(400), u.Size)
+	_, err = st.Get("user9/unknown")
+	assert.Equal(t, ErrUploadNotFound, err)
+
+	require.NoError(t, st.Commit("user2/pic3"))
+	require.NoError(t, st.Commit("user9/unknown"))
+	require.NoError(t, st.Cleanup(now.Add(-30*time.Minute)))
+	list, err = st.List("site1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(list), "not committed pic2 removed")
+	assert.Equal(t, "user2/pic3", list[1].ID)
+	assert.True(t, list[1].Committed)
+	assert.Equal(t, "user1/pic1", list[2].ID)
+
+	require.NoError(t, st.Delete("user1/pic5"))
+	require.NoError(t, st.Delete("user1/pic5"), "already removed")
+	require.NoError(t, st.Commit("user1/pic5"), "removed, ignored")
+	_, err = st.Get("user1/pic5")
+	assert.Equal(t, ErrUploadNotFound, err)
+	stats, err = st.Stats("site1", "user1", now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, UploadStats{SiteSize: 400, UserSize: 100}, stats)
+}
@@ -0,0 +1,124 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_UploadLimits(t *testing.T) {
+	loc, err := ioutil.TempDir("", "test_image_uploads")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+
+	store := &FileSystem{Location: loc + "/images", Staging: loc + "/staging", Partitions: 10}
+	uploads := NewMemoryUploads()
+	svc := NewService(store, ServiceParams{MaxSize: 10000, Uploads: uploads,
+		UploadLimits: UploadLimits{UserSize: 3 * 1462, SiteSize: 4 * 1462, DailyCount: 2}})
+
+	upload := func(siteID, userID string) (string, error) {
+		return svc.Upload(siteID, userID, bytes.NewReader(gopherPNGBytes()))
+	}
+
+	id, err := upload("site1", "user1")
+	require.NoError(t, err)
+	_, err = svc.Load(id)
+	assert.NoError(t, err)
+	_, err = upload("site1", "user1")
+	require.NoError(t, err)
+	_, err = upload("site1", "user1")
+	assert.Equal(t, ErrDailyUploads, err)
+
+	_, err = upload("site2", "user1")
+	assert.NoError(t, err, "limits are per site")
+
+	// older upload counts for storage quota only
+	require.NoError(t, uploads.Add(Upload{ID: "user2/old", SiteID: "site1", UserID: "user2", Size: 3 * 1462,
+		Timestamp: time.Now().Add(-25 * time.Hour)}))
+	_, err = upload("site1", "user2")
+	assert.Equal(t, ErrUserQuota, err)
+	_, err = upload("site1", "user3")
+	assert.Equal(t, ErrSiteQuota, err)
+
+	list, err := uploads.List("site1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(list))
+	assert.Equal(t, id, list[1].ID)
+	assert.Equal(t, int64(1462), list[1].Size)
+
+	_, err = svc.Upload("site1", "user1", bytes.NewReader([]byte("not an image")))
+	assert.Error(t, err)
+
+	svc.UploadLimits = UploadLimits{}
+	_, err = upload("site1", "user3")
+	assert.NoError(t, err, "no limits")
+
+	svc.Uploads = nil
+	_, err = upload("site1", "user1")
+	assert.NoError(t, err, "no uploads store")
+}
+
+func TestService_UploadCommitCleanup(t *testing.T) {
+	loc, err := ioutil.TempDir("", "test_image_uploads")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+
+	store := &FileSystem{Location: loc + "/images", Staging: loc + "/staging", Partitions: 10}
+	uploads := NewMemoryUploads()
+	svc := NewService(store, ServiceParams{MaxSize: 10000, TTL: 50 * time.Millisecond, Uploads: uploads})
+
+	id1, err := svc.Upload("site1", "user1", bytes.NewReader(gopherPNGBytes()))
+	require.NoError(t, err)
+	_, err = svc.Upload("site1", "user1", bytes.NewReader(gopherPNGBytes()))
+	require.NoError(t, err)
+	svc.Submit(func() []string { return []string{id1} })
+	time.Sleep(100 * time.Millisecond)
+	list, err := uploads.List("site1", 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list))
+	assert.True(t, list[1].Committed)
+	assert.False(t, list[0].Committed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	svc.Cleanup(ctx)
+	list, err = uploads.List("site1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{id1}, []string{list[0].ID}, "not committed upload forgotten")
+	assert.Equal(t, 1, len(list))
+}
+
+func TestService_Delete(t *testing.T) {
+	loc, err := ioutil.TempDir("", "test_image_uploads")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+
+	store := &FileSystem{Location: loc + "/images", Staging: loc + "/staging", Partitions: 10}
+	uploads := NewMemoryUploads()
+	svc := NewService(store, ServiceParams{MaxSize: 10000, Uploads: uploads, VariantWidths: []int{320, 640}})
+
+	id, err := svc.Upload("site1", "user1", bytes.NewReader(gopherPNGBytes()))
+	require.NoError(t, err)
+	require.NoError(t, store.Commit(id))
+	_, err = store.SaveWithID(id+"_w320", []byte("variant"))
+	require.NoError(t, err)
+	require.NoError(t, store.Commit(id+"_w320"))
+
+	require.NoError(t, svc.Delete(id))
+	_, err = svc.Load(id)
+	assert.Error(t, err)
+	_, err = svc.Load(id + "_w320")
+	assert.Error(t, err, "variant removed")
+	list, err := uploads.List("site1", 0)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	assert.Error(t, svc.Delete(id), "already removed")
+	assert.EqualError(t, NewService(&MockStore{}, ServiceParams{}).Delete(id), "image store doesn't support removal")
+}
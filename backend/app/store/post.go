@@ -0,0 +1,31 @@
+package store
+
+import (
+	"time"
+)
+
+// Post keeps meta data of the commented page, one record per post. Locator.URL is the canonical url of the post,
+// comments made or requested with any of aliases belong to the same post.
+type Post struct {
+	Locator  Locator   `json:"locator"`
+	Title    string    `json:"title,omitempty"`
+	Image    string    `json:"image,omitempty"` // OpenGraph image of the page
+	Created  time.Time `json:"created"`
+	ReadOnly bool      `json:"read_only,omitempty"`
+	Aliases  []string  `json:"aliases,omitempty"` // other urls of the post
+}
+
+// HasURL checks if url is the canonical url or one of aliases of the post
+func (p Post) HasURL(url string) bool {
+	return p.Locator.URL == url || Contains(p.Aliases, url)
+}
+
+// Contains checks if list of strings has the value
+func Contains(list []string, val string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+// Package pubsub delivers live updates of comments to subscribers, i.e. streams and websocket clients.
+// Hub keeps local subscriptions filtered by site and post. Publishing goes through optional Transport,
+// so events made on one instance reach subscribers of all instances. Without transport events
+// delivered to local subscribers only.
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+// EventType defines type of the event
+type EventType string
+
+// enum of all event types
+const (
+	EventCreate EventType = "create"
+	EventUpdate EventType = "update"
+	EventDelete EventType = "delete"
+	EventVote   EventType = "vote"
+)
+
+// Event is a single change of comment published to subscribers
+type Event struct {
+	Type      EventType      `json:"type"`
+	Locator   store.Locator  `json:"locator"`
+	CommentID string         `json:"comment_id,omitempty"`
+	UserID    string         `json:"user_id,omitempty"` // set for deletion of all user's comments, CommentID empty in this case
+	Comment   *store.Comment `json:"comment,omitempty"`
+	Timestamp time.Time      `json:"time"`
+}
+
+// Transport defines interface to deliver events between instances
+type Transport interface {
+	Publish(e Event) error                            // send event to all instances, including this one
+	Listen(ctx context.Context, fn func(Event)) error // blocking, calls fn for each received event
+	Close() error
+}
+
+const defaultBufferSize = 16
+
+// Hub dispatches published events to matching subscriptions
+type Hub struct {
+	Transport  Transport // optional, local delivery only if nil
+	BufferSize int       // size of subscription's channel, events to slow subscriber dropped if full
+
+	lock sync.RWMutex
+	subs map[string]map[*Subscription]struct{} // site id -> subscriptions
+}
+
+// Subscription gets events for given site, and for given post if URL not empty.
+// C closed on Unsubscribe
+type Subscription struct {
+	C      <-chan Event
+	SiteID string
+	URL    string
+	ch     chan Event
+}
+
+// NewHub makes hub with optional transport
+func NewHub(t Transport) *Hub {
+	return &Hub{Transport: t, BufferSize: defaultBufferSize, subs: map[string]map[*Subscription]struct{}{}}
+}
+
+// Publish event to all subscribers. Nil-safe, errors logged only as live updates should never break
+// the actual operation. Delivered locally if transport failed.
+func (h *Hub) Publish(e Event) {
+	if h == nil {
+		return
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	if h.Transport == nil {
+		h.dispatch(e)
+		return
+	}
+	if err := h.Transport.Publish(e); err != nil {
+		log.Printf("[WARN] can't publish %s event for %+v, %v", e.Type, e.Locator, err)
+		h.dispatch(e)
+	}
+}
+
+// Subscribe makes subscription for site's events, limited to the post if url not empty.
+// Caller should Unsubscribe when done.
+func (h *Hub) Subscribe(siteID, url string) *Subscription {
+	size := h.BufferSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	ch := make(chan Event, size)
+	sub := &Subscription{C: ch, ch: ch, SiteID: siteID, URL: url}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.subs == nil {
+		h.subs = map[string]map[*Subscription]struct{}{}
+	}
+	if _, ok := h.subs[siteID]; !ok {
+		h.subs[siteID] = map[*Subscription]struct{}{}
+	}
+	h.subs[siteID][sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes subscription and closes its channel
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	siteSubs, ok := h.subs[sub.SiteID]
+	if !ok {
+		return
+	}
+	if _, ok = siteSubs[sub]; !ok {
+		return
+	}
+	delete(siteSubs, sub)
+	if len(siteSubs) == 0 {
+		delete(h.subs, sub.SiteID)
+	}
+	close(sub.ch)
+}
+
+// Listen receives events from transport and dispatches them to local subscribers. Blocking loop,
+// should be called inside of goroutine by consumer. Returns immediately if no transport set.
+func (h *Hub) Listen(ctx context.Context) {
+	if h.Transport == nil {
+		return
+	}
+	log.Printf("[INFO] start listening for published events")
+	for {
+		err := h.Transport.Listen(ctx, h.dispatch)
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] listening for published events terminated, %v", ctx.Err())
+			return
+		case <-time.After(time.Second):
+			log.Printf("[WARN] listening for published events interrupted, %v", err)
+		}
+	}
+}
+
+// Close transport
+func (h *Hub) Close() error {
+	if h == nil || h.Transport == nil {
+		return nil
+	}
+	return errors.Wrap(h.Transport.Close(), "can't close pubsub transport")
+}
+
+// dispatch sends event to all matching subscriptions without blocking
+func (h *Hub) dispatch(e Event) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for sub := range h.subs[e.Locator.SiteID] {
+		if sub.URL != "" && e.Locator.URL != "" && sub.URL != e.Locator.URL {
+			continue // event for another post. events without url, i.e. user's comments deletion, go to everyone
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			log.Printf("[DEBUG] subscription for %s %s is full, %s event dropped", sub.SiteID, sub.URL, e.Type)
+		}
+	}
+}
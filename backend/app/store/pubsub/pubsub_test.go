@@ -0,0 +1,138 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+func TestHub_PublishSubscribe(t *testing.T) {
+	h := NewHub(nil)
+	subPost := h.Subscribe("site1", "https://example.com/1")
+	subSite := h.Subscribe("site1", "")
+	subOther := h.Subscribe("site2", "")
+
+	h.Publish(Event{Type: EventCreate, CommentID: "id1", Locator: store.Locator{SiteID: "site1", URL: "https://example.com/1"}})
+	h.Publish(Event{Type: EventVote, CommentID: "id2", Locator: store.Locator{SiteID: "site1", URL: "https://example.com/2"}})
+	h.Publish(Event{Type: EventDelete, UserID: "user1", Locator: store.Locator{SiteID: "site1"}})
+
+	assert.Equal(t, []string{"create:id1", "delete:"}, readEvents(subPost), "post events and events without url")
+	assert.Equal(t, []string{"create:id1", "vote:id2", "delete:"}, readEvents(subSite))
+	assert.Equal(t, []string{}, readEvents(subOther))
+
+	h.Unsubscribe(subPost)
+	_, ok := <-subPost.C
+	assert.False(t, ok, "closed on unsubscribe")
+	h.Unsubscribe(subPost) // second unsubscribe ignored
+	h.Unsubscribe(subSite)
+	h.Unsubscribe(subOther)
+	assert.Equal(t, 0, len(h.subs))
+}
+
+func TestHub_SlowSubscriber(t *testing.T) {
+	h := NewHub(nil)
+	h.BufferSize = 2
+	sub := h.Subscribe("site1", "")
+	for i := 0; i < 5; i++ {
+		h.Publish(Event{Type: EventCreate, Locator: store.Locator{SiteID: "site1"}})
+	}
+	assert.Equal(t, 2, len(readEvents(sub)), "extra events dropped without blocking")
+}
+
+func TestHub_NilSafe(t *testing.T) {
+	var h *Hub
+	h.Publish(Event{Type: EventCreate})
+	assert.NoError(t, h.Close())
+	NewHub(nil).Listen(context.Background()) // returns immediately without transport
+}
+
+func TestHub_WithTransport(t *testing.T) {
+	tr := &mockTransport{events: make(chan Event, 10)}
+	h := NewHub(tr)
+	sub := h.Subscribe("site1", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.Listen(ctx)
+		close(done)
+	}()
+
+	h.Publish(Event{Type: EventCreate, CommentID: "id1", Locator: store.Locator{SiteID: "site1"}})
+	select {
+	case e := <-sub.C:
+		assert.Equal(t, "id1", e.CommentID)
+		assert.False(t, e.Timestamp.IsZero())
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+
+	tr.setFail(true)
+	h.Publish(Event{Type: EventCreate, CommentID: "id2", Locator: store.Locator{SiteID: "site1"}})
+	assert.Equal(t, []string{"create:id2"}, readEvents(sub), "delivered locally on transport failure")
+
+	cancel()
+	<-done
+	assert.NoError(t, h.Close())
+	assert.True(t, tr.closed)
+}
+
+func readEvents(sub *Subscription) []string {
+	res := []string{}
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return res
+			}
+			res = append(res, string(e.Type)+":"+e.CommentID)
+		case <-time.After(50 * time.Millisecond):
+			return res
+		}
+	}
+}
+
+type mockTransport struct {
+	events chan Event
+	lock   sync.Mutex
+	fail   bool
+	closed bool
+}
+
+func (m *mockTransport) Publish(e Event) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.fail {
+		return errors.New("failed")
+	}
+	m.events <- e
+	return nil
+}
+
+func (m *mockTransport) Listen(ctx context.Context, fn func(Event)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-m.events:
+			fn(e)
+		}
+	}
+}
+
+func (m *mockTransport) Close() error {
+	m.closed = true
+	return nil
+}
+
+func (m *mockTransport) setFail(fail bool) {
+	m.lock.Lock()
+	m.fail = fail
+	m.lock.Unlock()
+}
@@ -0,0 +1,64 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+)
+
+// Redis implements Transport with redis pub/sub. All instances publish to and listen on the same channel.
+type Redis struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+// NewRedisTransport makes redis transport for given client and channel name
+func NewRedisTransport(client redis.UniversalClient, channel string) *Redis {
+	return &Redis{client: client, channel: channel}
+}
+
+// Publish event to redis channel
+func (r *Redis) Publish(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal %s event", e.Type)
+	}
+	return errors.Wrapf(r.client.Publish(r.channel, data).Err(), "can't publish to %s", r.channel)
+}
+
+// Listen subscribes to redis channel and calls fn for each received event till context canceled
+func (r *Redis) Listen(ctx context.Context, fn func(Event)) error {
+	ps := r.client.Subscribe(r.channel)
+	defer ps.Close()
+
+	// wait for subscription confirmation, otherwise events published right after Listen call can be lost
+	if _, err := ps.Receive(); err != nil {
+		return errors.Wrapf(err, "can't subscribe to %s", r.channel)
+	}
+
+	ch := ps.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return errors.Errorf("channel %s closed", r.channel)
+			}
+			e := Event{}
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				log.Printf("[WARN] can't unmarshal event from %s, %v", r.channel, err)
+				continue
+			}
+			fn(e)
+		}
+	}
+}
+
+// Close redis client
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,66 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+func TestRedis_TwoInstances(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub1 := NewHub(NewRedisTransport(redis.NewClient(&redis.Options{Addr: srv.Addr()}), "events"))
+	hub2 := NewHub(NewRedisTransport(redis.NewClient(&redis.Options{Addr: srv.Addr()}), "events"))
+	go hub1.Listen(ctx)
+	go hub2.Listen(ctx)
+	waitForSubscribers(t, srv, "events", 2)
+
+	sub1 := hub1.Subscribe("site1", "https://example.com/1")
+	sub2 := hub2.Subscribe("site1", "https://example.com/1")
+
+	c := store.Comment{ID: "id1", Text: "some text", Locator: store.Locator{SiteID: "site1", URL: "https://example.com/1"}}
+	hub1.Publish(Event{Type: EventCreate, CommentID: "id1", Comment: &c, Locator: c.Locator})
+
+	for _, sub := range []*Subscription{sub1, sub2} {
+		select {
+		case e := <-sub.C:
+			assert.Equal(t, EventCreate, e.Type)
+			assert.Equal(t, "id1", e.CommentID)
+			require.NotNil(t, e.Comment)
+			assert.Equal(t, "some text", e.Comment.Text)
+		case <-time.After(time.Second):
+			t.Fatal("event not delivered")
+		}
+	}
+	assert.Equal(t, []string{}, readEvents(sub1), "delivered once")
+
+	// bad payload ignored
+	srv.Publish("events", "{bad json")
+	hub2.Publish(Event{Type: EventVote, CommentID: "id1", Locator: c.Locator})
+	assert.Equal(t, []string{"vote:id1"}, readEvents(sub1))
+
+	assert.NoError(t, hub1.Close())
+	assert.NoError(t, hub2.Close())
+}
+
+func waitForSubscribers(t *testing.T, srv *miniredis.Miniredis, channel string, n int) {
+	for i := 0; i < 100; i++ {
+		if srv.PubSubNumSub(channel)[channel] >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no %d subscribers for %s", n, channel)
+}
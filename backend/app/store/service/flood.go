@@ -0,0 +1,131 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/engine"
+)
+
+// errors returned by Create for comments rejected by flood policy
+var (
+	ErrSlowMode         = errors.New("too frequent comments on the post")
+	ErrDailyQuota       = errors.New("daily comments quota exceeded")
+	ErrLinksNotAllowed  = errors.New("links not allowed for new unverified users")
+	ErrTooManyLinks     = errors.New("too many links in comment")
+	ErrDuplicateComment = errors.New("the same comment already posted")
+)
+
+const floodDuplicatesLookup = 50 // number of last user's comments checked for duplicates
+
+var reLink = regexp.MustCompile(`(?i)<a\s[^>]*href=`)
+
+// FloodPolicy defines limits on comments posting by a user, zero values disable the check
+type FloodPolicy struct {
+	SlowMode    time.Duration // min interval between user's comments on the same post
+	DailyQuota  int           // max number of user's comments in 24h
+	LinksMinAge time.Duration // min time since user's first comment to post links, doesn't apply to verified users
+	MaxLinks    int           // max number of links in comment
+	Duplicates  time.Duration // reject the same text posted by user again within this interval, on any post
+}
+
+// FloodControl keeps posting policies, per site with default one for sites without own policy
+type FloodControl struct {
+	Default FloodPolicy
+	Sites   map[string]FloodPolicy
+}
+
+// Policy returns posting policy for the site
+func (f *FloodControl) Policy(siteID string) FloodPolicy {
+	if p, ok := f.Sites[siteID]; ok {
+		return p
+	}
+	return f.Default
+}
+
+// checkFlood verifies new comment against site's posting policy, admins not restricted
+func (s *DataStore) checkFlood(comment store.Comment) error {
+	if s.FloodControl == nil || comment.User.Admin {
+		return nil
+	}
+	p := s.FloodControl.Policy(comment.Locator.SiteID)
+	siteID, userID := comment.Locator.SiteID, comment.User.ID
+
+	if p.MaxLinks > 0 && countLinks(comment.Text) > p.MaxLinks {
+		return ErrTooManyLinks
+	}
+
+	if p.LinksMinAge > 0 && countLinks(comment.Text) > 0 && !s.IsVerified(siteID, userID) {
+		first, ok := s.firstUserComment(siteID, userID)
+		if !ok || comment.Timestamp.Sub(first.Timestamp) < p.LinksMinAge {
+			return ErrLinksNotAllowed
+		}
+	}
+
+	if p.SlowMode > 0 {
+		req := engine.FindRequest{Locator: comment.Locator, Since: comment.Timestamp.Add(-p.SlowMode)}
+		comments, err := s.Engine.Find(req)
+		if err != nil {
+			log.Printf("[DEBUG] can't get recent comments for %+v, %v", comment.Locator, err)
+		}
+		for _, c := range comments {
+			if c.User.ID == userID {
+				return ErrSlowMode
+			}
+		}
+	}
+
+	if p.DailyQuota > 0 {
+		req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Sort: "-time", Limit: p.DailyQuota}
+		comments, err := s.Engine.Find(req) // user's last comments, newest first
+		if err == nil && len(comments) >= p.DailyQuota &&
+			comments[p.DailyQuota-1].Timestamp.After(comment.Timestamp.Add(-24*time.Hour)) {
+			return ErrDailyQuota
+		}
+	}
+
+	if p.Duplicates > 0 {
+		req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Limit: floodDuplicatesLookup}
+		comments, _ := s.Engine.Find(req) // error means no comments for user
+		text := normalizeText(comment)
+		for _, c := range comments {
+			if c.Timestamp.After(comment.Timestamp.Add(-p.Duplicates)) && normalizeText(c) == text {
+				return ErrDuplicateComment
+			}
+		}
+	}
+	return nil
+}
+
+// firstUserComment returns the oldest comment of the user on the site
+func (s *DataStore) firstUserComment(siteID, userID string) (store.Comment, bool) {
+	count, err := s.Engine.Count(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID})
+	if err != nil || count == 0 {
+		return store.Comment{}, false
+	}
+	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Skip: count - 1, Limit: 1}
+	comments, err := s.Engine.Find(req)
+	if err != nil || len(comments) == 0 {
+		return store.Comment{}, false
+	}
+	return comments[0], true
+}
+
+// countLinks returns number of links in formatted comment text
+func countLinks(text string) int {
+	return len(reLink.FindAllStringIndex(text, -1))
+}
+
+// normalizeText returns original text of the comment in lower case with collapsed spaces
+func normalizeText(c store.Comment) string {
+	text := c.Orig
+	if text == "" {
+		text = c.Text
+	}
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
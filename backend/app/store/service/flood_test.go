@@ -0,0 +1,165 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/admin"
+)
+
+func TestFlood_SlowMode(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"),
+		FloodControl: &FloodControl{Default: FloodPolicy{SlowMode: time.Minute}}}
+
+	post1 := store.Locator{URL: "https://radio-t.com/p1", SiteID: "radio-t"}
+	post2 := store.Locator{URL: "https://radio-t.com/p2", SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{Text: "text 1", User: store.User{ID: "u1"}, Locator: post1})
+	require.NoError(t, err)
+
+	_, err = b.Create(store.Comment{Text: "text 2", User: store.User{ID: "u1"}, Locator: post1})
+	assert.Equal(t, ErrSlowMode, err)
+	_, err = b.Create(store.Comment{Text: "text 2", User: store.User{ID: "u1"}, Locator: post2})
+	assert.NoError(t, err, "another post")
+	_, err = b.Create(store.Comment{Text: "text 2", User: store.User{ID: "u2"}, Locator: post1})
+	assert.NoError(t, err, "another user")
+	_, err = b.Create(store.Comment{Text: "text 3", User: store.User{ID: "u1"}, Locator: post1,
+		Timestamp: time.Now().Add(2 * time.Minute)})
+	assert.NoError(t, err, "after slow mode interval")
+	_, err = b.Create(store.Comment{Text: "text 4", User: store.User{ID: "u1", Admin: true}, Locator: post1})
+	assert.NoError(t, err, "admin not restricted")
+}
+
+func TestFlood_DailyQuota(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"),
+		FloodControl: &FloodControl{Default: FloodPolicy{DailyQuota: 3}, Sites: map[string]FloodPolicy{"other": {}}}}
+
+	loc := store.Locator{URL: "https://radio-t.com/p1", SiteID: "radio-t"}
+	// old comment not counted
+	_, err := b.Create(store.Comment{Text: "text", User: store.User{ID: "u1"}, Locator: loc, Timestamp: time.Now().Add(-25 * time.Hour)})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = b.Create(store.Comment{Text: "text", User: store.User{ID: "u1"}, Locator: loc})
+		require.NoError(t, err)
+	}
+	_, err = b.Create(store.Comment{Text: "text", User: store.User{ID: "u1"}, Locator: loc})
+	assert.Equal(t, ErrDailyQuota, err)
+	_, err = b.Create(store.Comment{Text: "text", User: store.User{ID: "u2"}, Locator: loc})
+	assert.NoError(t, err)
+
+	assert.Equal(t, FloodPolicy{}, b.FloodControl.Policy("other"))
+	assert.Equal(t, FloodPolicy{DailyQuota: 3}, b.FloodControl.Policy("radio-t"))
+}
+
+func TestFlood_Links(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"),
+		FloodControl: &FloodControl{Default: FloodPolicy{MaxLinks: 2, LinksMinAge: time.Hour}}}
+
+	loc := store.Locator{URL: "https://radio-t.com/p1", SiteID: "radio-t"}
+	link := `<a href="https://example.com">link</a> `
+
+	// new user without comments
+	_, err := b.Create(store.Comment{Text: link, User: store.User{ID: "u1"}, Locator: loc})
+	assert.Equal(t, ErrLinksNotAllowed, err)
+	_, err = b.Create(store.Comment{Text: "no links", User: store.User{ID: "u1"}, Locator: loc})
+	assert.NoError(t, err)
+	_, err = b.Create(store.Comment{Text: link, User: store.User{ID: "u1"}, Locator: loc})
+	assert.Equal(t, ErrLinksNotAllowed, err, "first comment too recent")
+
+	// user1 from prepStoreEngine commented long ago
+	_, err = b.Create(store.Comment{Text: link + link, User: store.User{ID: "user1"}, Locator: loc})
+	assert.NoError(t, err)
+	_, err = b.Create(store.Comment{Text: link + link + link, User: store.User{ID: "user1"}, Locator: loc})
+	assert.Equal(t, ErrTooManyLinks, err)
+
+	// verified user allowed to post links
+	require.NoError(t, b.SetVerified("radio-t", "u2", true))
+	_, err = b.Create(store.Comment{Text: link, User: store.User{ID: "u2"}, Locator: loc})
+	assert.NoError(t, err)
+}
+
+func TestFlood_Duplicates(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"),
+		FloodControl: &FloodControl{Default: FloodPolicy{Duplicates: time.Hour}}}
+
+	post1 := store.Locator{URL: "https://radio-t.com/p1", SiteID: "radio-t"}
+	post2 := store.Locator{URL: "https://radio-t.com/p2", SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{Text: "<p>Buy  now</p>", Orig: "Buy  now", User: store.User{ID: "u1"}, Locator: post1})
+	require.NoError(t, err)
+
+	_, err = b.Create(store.Comment{Text: "<p>buy now</p>", Orig: "buy now\n", User: store.User{ID: "u1"}, Locator: post2})
+	assert.Equal(t, ErrDuplicateComment, err)
+	_, err = b.Create(store.Comment{Text: "<p>buy now</p>", Orig: "buy now", User: store.User{ID: "u2"}, Locator: post2})
+	assert.NoError(t, err, "another user")
+	_, err = b.Create(store.Comment{Text: "<p>buy later</p>", Orig: "buy later", User: store.User{ID: "u1"}, Locator: post2})
+	assert.NoError(t, err, "different text")
+	_, err = b.Create(store.Comment{Text: "<p>buy now</p>", Orig: "buy now", User: store.User{ID: "u1"}, Locator: post2,
+		Timestamp: time.Now().Add(2 * time.Hour)})
+	assert.NoError(t, err, "after duplicates interval")
+}
+
+func TestFlood_Disabled(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	loc := store.Locator{URL: "https://radio-t.com/p1", SiteID: "radio-t"}
+	for i := 0; i < 3; i++ {
+		_, err := b.Create(store.Comment{Text: `<a href="https://example.com">link</a>`, User: store.User{ID: "u1"}, Locator: loc})
+		assert.NoError(t, err)
+	}
+}
+
+func TestFlood_ImportNotRestricted(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"),
+		FloodControl: &FloodControl{Default: FloodPolicy{SlowMode: time.Hour, DailyQuota: 1, Duplicates: time.Hour, MaxLinks: 1}}}
+
+	// imported comments with own timestamps, quick replies and links
+	loc := store.Locator{URL: "https://radio-t.com/p1", SiteID: "radio-t"}
+	ts := time.Date(2017, 12, 20, 15, 18, 22, 0, time.UTC)
+	link := `<a href="https://example.com">link</a> `
+	for i := 0; i < 3; i++ {
+		_, err := b.Import(store.Comment{Text: link + link, User: store.User{ID: "u1"}, Locator: loc,
+			Timestamp: ts.Add(time.Duration(i) * time.Second)})
+		require.NoError(t, err)
+	}
+	_, err := b.Create(store.Comment{Text: "text", User: store.User{ID: "u1"}, Locator: loc, Timestamp: ts.Add(time.Minute)})
+	assert.Equal(t, ErrSlowMode, err, "user's post restricted")
+}
+
+func TestFlood_Concurrent(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"),
+		FloodControl: &FloodControl{Default: FloodPolicy{SlowMode: time.Minute}}}
+
+	loc := store.Locator{URL: "https://radio-t.com/p1", SiteID: "radio-t"}
+	var wg sync.WaitGroup
+	var created int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := b.Create(store.Comment{Text: fmt.Sprintf("text %d", i), User: store.User{ID: "u1"}, Locator: loc}); err == nil {
+				atomic.AddInt32(&created, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), created, "only one comment passed slow mode")
+}
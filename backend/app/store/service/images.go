@@ -0,0 +1,168 @@
+package service
+
+import (
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/engine"
+)
+
+// ImageRef is a reference to the image from the comment
+type ImageRef struct {
+	ImageID   string        `json:"-"`
+	CommentID string        `json:"id"`
+	Locator   store.Locator `json:"locator"`
+	UserID    string        `json:"user_id"`
+}
+
+// ImageRefs returns ids of images referenced by comments of all sites, made from images index of the engine.
+// Deleted comments don't reference anything, so images of edited, deleted and purged comments are not included.
+func (s *DataStore) ImageRefs(siteIDs ...string) (map[string]bool, error) {
+	if s.ImageService == nil {
+		return nil, errors.New("image service not defined")
+	}
+	res := map[string]bool{}
+	for _, siteID := range siteIDs {
+		if err := s.indexImages(siteID); err != nil {
+			return nil, err
+		}
+		ids, err := s.Engine.Images(engine.FindRequest{Locator: store.Locator{SiteID: siteID}})
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get referenced images for %s", siteID)
+		}
+		for _, id := range ids {
+			res[id] = true
+		}
+	}
+	return res, nil
+}
+
+// ImageComments returns comments of the sites referencing the image, made from images index of the engine
+func (s *DataStore) ImageComments(imageID string, siteIDs ...string) ([]ImageRef, error) {
+	if s.ImageService == nil {
+		return nil, errors.New("image service not defined")
+	}
+	res := []ImageRef{}
+	for _, siteID := range siteIDs {
+		if err := s.indexImages(siteID); err != nil {
+			return nil, err
+		}
+		comments, err := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, ImageID: imageID, Sort: "time"})
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get comments referencing %s for %s", imageID, siteID)
+		}
+		for _, c := range comments {
+			res = append(res, ImageRef{ImageID: imageID, CommentID: c.ID, Locator: c.Locator, UserID: c.User.ID})
+		}
+	}
+	return res, nil
+}
+
+// ScrubImage removes the image from text of all comments referencing it and returns list of updated comments
+func (s *DataStore) ScrubImage(imageID string, siteIDs ...string) ([]ImageRef, error) {
+	refs, err := s.ImageComments(imageID, siteIDs...)
+	if err != nil {
+		return nil, err
+	}
+	res := []ImageRef{}
+	for _, ref := range refs {
+		c, err := s.Engine.Get(engine.GetRequest{Locator: ref.Locator, CommentID: ref.CommentID})
+		if err != nil {
+			return res, errors.Wrapf(err, "can't get comment %s", ref.CommentID)
+		}
+		c.Text = s.ImageService.RemovePicture(c.Text, imageID)
+		c.Orig = s.ImageService.RemovePicture(c.Orig, imageID)
+		if c.Images, err = s.imageIDs(c.Text); err != nil {
+			return res, errors.Wrapf(err, "can't get images of comment %s", ref.CommentID)
+		}
+		if err = s.Engine.Update(c); err != nil {
+			return res, errors.Wrapf(err, "can't update comment %s", ref.CommentID)
+		}
+		s.logUpdate(c)
+		log.Printf("[INFO] image %s removed from comment %s", imageID, ref.CommentID)
+		res = append(res, ref)
+	}
+	return res, nil
+}
+
+// indexImages sets images of comments saved before images were indexed, once per site. Such comments have no
+// images set even if the text references them, and the images would be collected as orphaned otherwise.
+func (s *DataStore) indexImages(siteID string) error {
+	s.imagesIndex.Lock()
+	defer s.imagesIndex.Unlock()
+	if s.imagesIndex.sites[siteID] {
+		return nil
+	}
+
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return errors.Wrapf(err, "can't get list of posts for %s", siteID)
+	}
+	count := 0
+	for _, post := range posts {
+		n, err := s.indexPostImages(store.Locator{SiteID: siteID, URL: post.URL})
+		if err != nil {
+			return err
+		}
+		count += n
+	}
+	if count > 0 {
+		log.Printf("[INFO] images of %d comments indexed for %s", count, siteID)
+	}
+
+	if s.imagesIndex.sites == nil {
+		s.imagesIndex.sites = map[string]bool{}
+	}
+	s.imagesIndex.sites[siteID] = true
+	return nil
+}
+
+// indexPostImages sets images of the post's comments referencing images without images set, returns number
+// of updated comments
+func (s *DataStore) indexPostImages(locator store.Locator) (count int, err error) {
+	unlock := s.lockPosts(locator.URL)
+	defer unlock()
+
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: locator, Sort: "time"})
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't get comments for %+v", locator)
+	}
+	for _, c := range comments {
+		if c.Deleted || len(c.Images) > 0 {
+			continue
+		}
+		if c.Images, err = s.imageIDs(c.Text); err != nil {
+			return count, errors.Wrapf(err, "can't get images of comment %s", c.ID)
+		}
+		if len(c.Images) == 0 {
+			continue
+		}
+		if err = s.Engine.Update(c); err != nil {
+			return count, errors.Wrapf(err, "can't update comment %s", c.ID)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// imageIDs returns ids of uploaded images referenced by the comment's text without duplicates, nil if there are
+// no such images or image service not defined
+func (s *DataStore) imageIDs(text string) ([]string, error) {
+	if s.ImageService == nil {
+		return nil, nil
+	}
+	ids, err := s.ImageService.PictureRefs(text)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/admin"
+	"github.com/umputun/remark/backend/app/store/engine"
+	"github.com/umputun/remark/backend/app/store/image"
+)
+
+func TestService_ImageRefs(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	imgSvc := image.NewService(&image.MockStore{}, image.ServiceParams{ImageAPI: "https://remark42.example.com/api/v1/picture/"})
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), ImageService: imgSvc}
+
+	create := func(id, url, userID, text string) {
+		_, e := b.Engine.Create(store.Comment{ID: id, Text: text, Timestamp: time.Now(),
+			Locator: store.Locator{URL: url, SiteID: "radio-t"}, User: store.User{ID: userID, Name: userID}})
+		require.NoError(t, e)
+	}
+	create("img-1", "https://radio-t.com", "user1", `<img src="https://remark42.example.com/api/v1/picture/user1/pic1.png"/>`)
+	create("img-2", "https://radio-t.com/2", "user2",
+		`<img src="https://remark42.example.com/api/v1/picture/user2/pic2.png?w=320"/> <img src="https://example.com/pic.png"/>`)
+	create("img-3", "https://radio-t.com/2", "user1", `<img src="http://old.example.com/api/v1/picture/user1/pic3.png"/>`)
+	create("img-4", "https://radio-t.com/3", "user3", `<img src="https://remark42.example.com/api/v1/picture/user3/pic4.png"/>`)
+
+	refs, err := b.ImageRefs("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"user1/pic1.png": true, "user2/pic2.png": true, "user1/pic3.png": true,
+		"user3/pic4.png": true}, refs)
+	c, err := b.Engine.Get(engine.GetRequest{Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, CommentID: "img-2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user2/pic2.png"}, c.Images, "images of comment saved before indexing set")
+
+	_, err = b.EditComment(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, "img-2",
+		EditRequest{Orig: "no pics", Text: "no pics"})
+	require.NoError(t, err)
+	require.NoError(t, b.Delete(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "img-1", store.SoftDelete))
+	require.NoError(t, b.DeleteUser("radio-t", "user3", store.HardDelete))
+
+	refs, err = b.ImageRefs("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"user1/pic3.png": true}, refs)
+
+	_, err = b.ImageRefs("bad-site")
+	assert.Error(t, err)
+
+	b.ImageService = nil
+	_, err = b.ImageRefs("radio-t")
+	assert.EqualError(t, err, "image service not defined")
+}
+
+func TestService_ImageRefsIndex(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	imgStore := image.MockStore{}
+	imgStore.On("Commit", mock.Anything).Return(nil).Maybe()
+	imgSvc := image.NewService(&imgStore, image.ServiceParams{ImageAPI: "https://remark42.example.com/api/v1/picture/"})
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), ImageService: imgSvc}
+
+	locator := store.Locator{URL: "https://radio-t.com/p1", SiteID: "radio-t"}
+	images := func() []string {
+		ids, err := b.Engine.Images(engine.FindRequest{Locator: store.Locator{SiteID: "radio-t"}})
+		require.NoError(t, err)
+		return ids
+	}
+	id, err := b.Create(store.Comment{Locator: locator, User: store.User{ID: "user1", Name: "user1"},
+		Text: `<img src="https://remark42.example.com/api/v1/picture/user1/pic1.png"/>` +
+			`<img src="https://remark42.example.com/api/v1/picture/user1/pic1.png?w=320"/>`})
+	require.NoError(t, err)
+	c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1/pic1.png"}, c.Images)
+	assert.Equal(t, []string{"user1/pic1.png"}, images())
+
+	_, err = b.EditComment(locator, id, EditRequest{Orig: "pic",
+		Text: `<img src="https://remark42.example.com/api/v1/picture/user1/pic2.png"/>`})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1/pic2.png"}, images(), "edit replaces images")
+
+	c, err = b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id})
+	require.NoError(t, err)
+	c.Text, c.Images = "no pics", []string{"user1/pic3.png"}
+	require.NoError(t, b.Put(locator, c))
+	assert.Empty(t, images(), "images of restored comment made from text")
+
+	_, err = b.Create(store.Comment{Locator: locator, User: store.User{ID: "user2", Name: "user2"}, Text: "text",
+		Images: []string{"user1/pic3.png"}})
+	require.NoError(t, err)
+	assert.Empty(t, images(), "images of posted comment made from text")
+
+	id, err = b.Create(store.Comment{Locator: locator, User: store.User{ID: "user2", Name: "user2"},
+		Text: `<img src="https://remark42.example.com/api/v1/picture/user2/pic4.png"/>`})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user2/pic4.png"}, images())
+	require.NoError(t, b.Delete(locator, id, store.SoftDelete))
+	assert.Empty(t, images(), "deleted comment doesn't reference images")
+}
+
+func TestService_ScrubImage(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	imgSvc := image.NewService(&image.MockStore{}, image.ServiceParams{ImageAPI: "https://remark42.example.com/api/v1/picture/"})
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), ImageService: imgSvc}
+
+	create := func(id, url, text, orig string) {
+		_, e := b.Engine.Create(store.Comment{ID: id, Text: text, Orig: orig, Timestamp: time.Now(),
+			Locator: store.Locator{URL: url, SiteID: "radio-t"}, User: store.User{ID: "user1", Name: "user1"}})
+		require.NoError(t, e)
+	}
+	create("img-1", "https://radio-t.com", `<p>pic <img src="https://remark42.example.com/api/v1/picture/user1/pic1.png"/></p>`,
+		"pic ![](https://remark42.example.com/api/v1/picture/user1/pic1.png)")
+	create("img-2", "https://radio-t.com/2", `<img src="https://remark42.example.com/api/v1/picture/user1/pic1.png?w=320"/>`+
+		`<img src="https://remark42.example.com/api/v1/picture/user1/pic2.png"/>`, "")
+
+	refs, err := b.ImageComments("user1/pic1.png", "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(refs))
+	refs, err = b.ImageComments("user1/pic2.png", "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, []ImageRef{{ImageID: "user1/pic2.png", CommentID: "img-2", UserID: "user1",
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}}}, refs)
+	refs, err = b.ImageComments("user1/pic9.png", "radio-t")
+	require.NoError(t, err)
+	assert.Empty(t, refs)
+
+	res, err := b.ScrubImage("user1/pic1.png", "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res))
+
+	c, err := b.Engine.Get(engine.GetRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, CommentID: "img-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>pic </p>", c.Text)
+	assert.Equal(t, "pic ", c.Orig)
+
+	imageRefs, err := b.ImageRefs("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"user1/pic2.png": true}, imageRefs)
+
+	res, err = b.ScrubImage("user1/pic1.png", "radio-t")
+	require.NoError(t, err)
+	assert.Empty(t, res, "nothing to scrub")
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+const (
+	peCacheMaxRecs    = 1000
+	peCacheTTL        = time.Hour
+	peMaxDescription  = 300
+	peDefaultTimeout  = 5 * time.Second
+	peDefaultMaxLinks = 3
+)
+
+// Fetcher gets remote resources by url, returns body and content type
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (body []byte, contentType string, err error)
+}
+
+// PreviewExtractor makes link previews from OpenGraph tags of the linked page, missing title and image
+// taken from oEmbed data of the page if discovered. Previews cached, failed ones too.
+type PreviewExtractor struct {
+	fetcher  Fetcher
+	timeout  time.Duration
+	maxLinks int
+	cache    lcw.LoadingCache
+}
+
+// NewPreviewExtractor makes extractor with cache, making previews for up to maxLinks links of the comment.
+// Pages loaded by fetcher, it should reject internal addresses. If memory cache failed, switching to no-cache
+func NewPreviewExtractor(fetcher Fetcher, timeout time.Duration, maxLinks int) *PreviewExtractor {
+	if timeout <= 0 {
+		timeout = peDefaultTimeout
+	}
+	if maxLinks <= 0 {
+		maxLinks = peDefaultMaxLinks
+	}
+	res := PreviewExtractor{fetcher: fetcher, timeout: timeout, maxLinks: maxLinks}
+	var err error
+	res.cache, err = lcw.NewExpirableCache(lcw.TTL(peCacheTTL), lcw.MaxKeys(peCacheMaxRecs))
+	if err != nil {
+		log.Printf("[WARN] failed to make cache, caching disabled for link previews, %v", err)
+		res.cache = &lcw.Nop{}
+	}
+	return &res
+}
+
+// Previews makes previews for links from the comment's html, links without preview skipped.
+// Links left stop loading when ctx cancelled
+func (p *PreviewExtractor) Previews(ctx context.Context, commentHTML string) []store.LinkPreview {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(commentHTML))
+	if err != nil {
+		return nil
+	}
+	var links []string
+	seen := map[string]bool{}
+	doc.Find("a[href]").Not("pre a, code a").Each(func(_ int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		if len(links) >= p.maxLinks || seen[href] || !isHTTPURL(href) {
+			return
+		}
+		seen[href] = true
+		links = append(links, href)
+	})
+
+	var res []store.LinkPreview
+	for _, link := range links {
+		if ctx.Err() != nil {
+			break
+		}
+		preview, err := p.Get(ctx, link)
+		if err != nil {
+			log.Printf("[DEBUG] no preview for %s, %v", link, err)
+			continue
+		}
+		res = append(res, preview)
+	}
+	return res
+}
+
+// Get makes preview of the page by url. Failed previews cached, but not ones interrupted by ctx
+func (p *PreviewExtractor) Get(ctx context.Context, link string) (store.LinkPreview, error) {
+	v, err := p.cache.Get(link, func() (lcw.Value, error) {
+		preview, err := p.load(ctx, link)
+		if err != nil && ctx.Err() != nil {
+			return nil, errors.Wrapf(ctx.Err(), "preview of %s interrupted", link)
+		}
+		if err != nil {
+			log.Printf("[DEBUG] failed to load preview for %s, %v", link, err)
+			return store.LinkPreview{}, nil // cache failed previews too
+		}
+		return preview, nil
+	})
+	if err != nil {
+		return store.LinkPreview{}, err
+	}
+	preview := v.(store.LinkPreview)
+	if preview.Title == "" {
+		return store.LinkPreview{}, errors.Errorf("no preview for %s", link)
+	}
+	return preview, nil
+}
+
+// pageMeta keeps preview related data of html page
+type pageMeta struct {
+	meta   map[string]string // content of meta tags by property or name
+	title  string            // content of title tag
+	oembed string            // url of json oEmbed data
+}
+
+// oembedData is a subset of oEmbed response fields used for previews
+type oembedData struct {
+	Title        string `json:"title"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+func (p *PreviewExtractor) load(ctx context.Context, link string) (store.LinkPreview, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	body, _, err := p.fetcher.Fetch(ctx, link)
+	if err != nil {
+		return store.LinkPreview{}, errors.Wrapf(err, "failed to load page %s", link)
+	}
+	page, err := p.parse(body)
+	if err != nil {
+		return store.LinkPreview{}, errors.Wrapf(err, "failed to parse page %s", link)
+	}
+
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := strings.TrimSpace(page.meta[k]); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	res := store.LinkPreview{
+		URL:         link,
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		Image:       resolveURL(link, first("og:image", "og:image:url", "og:image:secure_url", "twitter:image")),
+		SiteName:    first("og:site_name"),
+	}
+
+	if (res.Title == "" || res.Image == "") && page.oembed != "" {
+		oe, err := p.loadOembed(ctx, resolveURL(link, page.oembed))
+		if err != nil {
+			log.Printf("[DEBUG] can't get oembed data for %s, %v", link, err)
+		}
+		if res.Title == "" {
+			res.Title = oe.Title
+		}
+		if res.Image == "" {
+			res.Image = resolveURL(link, oe.ThumbnailURL)
+		}
+		if res.SiteName == "" {
+			res.SiteName = oe.ProviderName
+		}
+	}
+
+	if res.Title == "" {
+		res.Title = page.title
+	}
+	if res.Title == "" {
+		return store.LinkPreview{}, errors.Errorf("no title for %s", link)
+	}
+	if res.SiteName == "" {
+		if u, e := url.Parse(link); e == nil {
+			res.SiteName = u.Hostname()
+		}
+	}
+	if r := []rune(res.Description); len(r) > peMaxDescription {
+		res.Description = strings.TrimSpace(string(r[:peMaxDescription])) + "..."
+	}
+	return res, nil
+}
+
+func (p *PreviewExtractor) loadOembed(ctx context.Context, oembedURL string) (oembedData, error) {
+	res := oembedData{}
+	if oembedURL == "" {
+		return res, errors.New("invalid oembed url")
+	}
+	body, _, err := p.fetcher.Fetch(ctx, oembedURL)
+	if err != nil {
+		return res, err
+	}
+	if err = json.Unmarshal(body, &res); err != nil {
+		return res, errors.Wrapf(err, "can't decode oembed data from %s", oembedURL)
+	}
+	return res, nil
+}
+
+// parse gets meta tags, title and oEmbed link from the page, head elements only
+func (p *PreviewExtractor) parse(body []byte) (pageMeta, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return pageMeta{}, err
+	}
+	res := pageMeta{meta: map[string]string{}}
+	walkHTML(doc, func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return false
+		}
+		switch n.Data {
+		case "body":
+			return true
+		case "title":
+			if n.FirstChild != nil && res.title == "" {
+				res.title = strings.TrimSpace(strings.Replace(n.FirstChild.Data, "\n", " ", -1))
+			}
+		case "meta":
+			key := strings.ToLower(htmlAttr(n, "property"))
+			if key == "" {
+				key = strings.ToLower(htmlAttr(n, "name"))
+			}
+			if _, ok := res.meta[key]; key != "" && !ok {
+				res.meta[key] = htmlAttr(n, "content")
+			}
+		case "link":
+			if strings.EqualFold(htmlAttr(n, "type"), "application/json+oembed") && res.oembed == "" {
+				res.oembed = htmlAttr(n, "href")
+			}
+		}
+		return false
+	})
+	return res, nil
+}
+
+func htmlAttr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// resolveURL makes absolute url for the reference from the page, returns empty string for non-http urls
+func resolveURL(base, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	res := b.ResolveReference(r).String()
+	if !isHTTPURL(res) {
+		return ""
+	}
+	return res
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
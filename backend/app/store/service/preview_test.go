@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+type fetcherFunc func(ctx context.Context, url string) ([]byte, string, error)
+
+func (f fetcherFunc) Fetch(ctx context.Context, url string) ([]byte, string, error) {
+	return f(ctx, url)
+}
+
+var httpFetcher = fetcherFunc(func(ctx context.Context, url string) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.Errorf("status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	return body, resp.Header.Get("Content-Type"), err
+})
+
+func TestPreview_Get(t *testing.T) {
+	var hits int32
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/og":
+			_, _ = w.Write([]byte(`<html><head><title>page title</title>
+<meta property="og:title" content="OG title">
+<meta property="og:description" content="OG description">
+<meta property="og:image" content="/img/pic.png">
+<meta property="og:site_name" content="Example">
+</head><body>text</body></html>`))
+		case "/twitter":
+			_, _ = w.Write([]byte(`<html><head><meta name="twitter:title" content="tw title">
+<meta name="description" content="page description"><meta name="twitter:image" content="javascript:alert(1)">
+</head></html>`))
+		case "/oembed-page":
+			_, _ = w.Write([]byte(`<html><head><title>video page</title>
+<link rel="alternate" type="application/json+oembed" href="` + ts.URL + `/oembed?url=video">
+</head><body><meta property="og:title" content="in body, ignored"></body></html>`))
+		case "/oembed":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"type":"video","title":"video title","provider_name":"VideoSite",
+"thumbnail_url":"https://img.example.com/thumb.jpg"}`))
+		case "/title-only":
+			_, _ = w.Write([]byte(`<html><head><title>
+just title</title></head></html>`))
+		case "/no-title":
+			_, _ = w.Write([]byte(`<html><body>nothing</body></html>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	ex := NewPreviewExtractor(httpFetcher, time.Second, 0)
+
+	tbl := []struct {
+		url string
+		res store.LinkPreview
+		err bool
+	}{
+		{ts.URL + "/og", store.LinkPreview{URL: ts.URL + "/og", Title: "OG title", Description: "OG description",
+			Image: ts.URL + "/img/pic.png", SiteName: "Example"}, false},
+		{ts.URL + "/twitter", store.LinkPreview{URL: ts.URL + "/twitter", Title: "tw title",
+			Description: "page description", SiteName: "127.0.0.1"}, false},
+		{ts.URL + "/oembed-page", store.LinkPreview{URL: ts.URL + "/oembed-page", Title: "video title",
+			Image: "https://img.example.com/thumb.jpg", SiteName: "VideoSite"}, false},
+		{ts.URL + "/title-only", store.LinkPreview{URL: ts.URL + "/title-only", Title: "just title", SiteName: "127.0.0.1"}, false},
+		{ts.URL + "/no-title", store.LinkPreview{}, true},
+		{ts.URL + "/not-found", store.LinkPreview{}, true},
+	}
+	for _, tt := range tbl {
+		res, err := ex.Get(context.Background(), tt.url)
+		if tt.err {
+			assert.Error(t, err, tt.url)
+			continue
+		}
+		require.NoError(t, err, tt.url)
+		assert.Equal(t, tt.res, res, tt.url)
+	}
+
+	before := atomic.LoadInt32(&hits)
+	_, err := ex.Get(context.Background(), ts.URL+"/og")
+	assert.NoError(t, err)
+	_, err = ex.Get(context.Background(), ts.URL+"/not-found")
+	assert.Error(t, err)
+	assert.Equal(t, before, atomic.LoadInt32(&hits), "cached, failed previews too")
+}
+
+func TestPreview_Previews(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><head><title>page ` + r.URL.Path[1:] + `</title></head></html>`))
+	}))
+	defer ts.Close()
+
+	ex := NewPreviewExtractor(httpFetcher, time.Second, 2)
+	html := `<p>links <a href="` + ts.URL + `/1">one</a> <a href="` + ts.URL + `/1">dbl</a> <a href="mailto:a@example.com">mail</a>
+<code><a href="` + ts.URL + `/code">code</a></code> <a href="` + ts.URL + `/2">two</a> <a href="` + ts.URL + `/3">three</a></p>`
+	res := ex.Previews(context.Background(), html)
+	require.Equal(t, 2, len(res), "max 2 links")
+	assert.Equal(t, "page 1", res[0].Title)
+	assert.Equal(t, "page 2", res[1].Title)
+
+	assert.Empty(t, ex.Previews(context.Background(), "no links"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Empty(t, ex.Previews(ctx, `<a href="`+ts.URL+`/4">four</a>`), "cancelled")
+	_, err := ex.Get(ctx, ts.URL+"/4")
+	assert.Error(t, err, "cancelled")
+	assert.Equal(t, "page 4", ex.Previews(context.Background(), `<a href="`+ts.URL+`/4">four</a>`)[0].Title,
+		"cancelled preview not cached")
+}
+
+func TestPreview_longDescription(t *testing.T) {
+	desc := ""
+	for i := 0; i < 100; i++ {
+		desc += "blah "
+	}
+	ex := NewPreviewExtractor(fetcherFunc(func(context.Context, string) ([]byte, string, error) {
+		return []byte(`<head><meta property="og:title" content="title"><meta property="og:description" content="` + desc + `"></head>`),
+			"text/html", nil
+	}), time.Second, 1)
+	res, err := ex.Get(context.Background(), "https://example.com/page")
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimSpace(desc[:300])+"...", res.Description)
+	assert.Equal(t, "example.com", res.SiteName)
+}
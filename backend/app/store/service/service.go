@@ -18,8 +18,10 @@ import (
 
 	"github.com/umputun/remark/backend/app/store"
 	"github.com/umputun/remark/backend/app/store/admin"
+	"github.com/umputun/remark/backend/app/store/changelog"
 	"github.com/umputun/remark/backend/app/store/engine"
 	"github.com/umputun/remark/backend/app/store/image"
+	"github.com/umputun/remark/backend/app/store/pubsub"
 )
 
 // DataStore wraps store.Interface with additional methods
@@ -37,6 +39,10 @@ type DataStore struct {
 	TitleExtractor         *TitleExtractor
 	RestrictedWordsMatcher *RestrictedWordsMatcher
 	ImageService           *image.Service
+	ChangeLog              *changelog.Service
+	PubSub                 *pubsub.Hub
+	FloodControl           *FloodControl
+	URLNormalizer          *URLNormalizer // applied to new comments, counts and post metas, api requests normalized by rest
 
 	// granular locks
 	scopedLocks struct {
@@ -45,6 +51,11 @@ type DataStore struct {
 		locks map[string]sync.Locker
 	}
 
+	imagesIndex struct {
+		sync.Mutex
+		sites map[string]bool // sites checked for comments saved before images were indexed
+	}
+
 	repliesCache struct {
 		*cache.Cache
 		once sync.Once
@@ -62,10 +73,12 @@ type UserMetaData struct {
 	Details  engine.UserDetailEntry `json:"details,omitempty"`
 }
 
-// PostMetaData keeps info about post flags
+// PostMetaData keeps info about post flags, OpenGraph image and aliases of the post
 type PostMetaData struct {
-	URL      string `json:"url"`
-	ReadOnly bool   `json:"read_only"`
+	URL      string   `json:"url"`
+	ReadOnly bool     `json:"read_only"`
+	Image    string   `json:"image,omitempty"`
+	Aliases  []string `json:"aliases,omitempty"`
 }
 
 const defaultCommentMaxSize = 2000
@@ -79,27 +92,63 @@ var nonAdminUser = store.User{}
 // ErrRestrictedWordsFound returned in case comment text contains restricted words
 var ErrRestrictedWordsFound = errors.New("comment contains restricted words")
 
-// Create prepares comment and forward to Interface.Create
+// Create verifies comment posted by user against site's flood policy, prepares it and forward to Interface.Create.
+// Check and create made under user's lock, so concurrent posts can't pass the check together.
 func (s *DataStore) Create(comment store.Comment) (commentID string, err error) {
+	comment.Locator = s.URLNormalizer.Locator(comment.Locator)
+	if comment.Timestamp.IsZero() {
+		comment.Timestamp = time.Now()
+	}
+
+	lock := s.getScopedLocks("flood:" + comment.Locator.SiteID + ":" + comment.User.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err = s.checkFlood(comment); err != nil {
+		return "", err
+	}
+	return s.create(comment)
+}
 
+// Import creates comment imported from other system or restored from backup.
+// Bypasses flood policy, comments of the past not limited by it.
+func (s *DataStore) Import(comment store.Comment) (commentID string, err error) {
+	return s.create(comment)
+}
+
+// create prepares comment and forward to Interface.Create
+func (s *DataStore) create(comment store.Comment) (commentID string, err error) {
+
+	comment.Locator = s.URLNormalizer.Locator(comment.Locator)
 	if comment, err = s.prepareNewComment(comment); err != nil {
 		return "", errors.Wrap(err, "failed to prepare comment")
 	}
+	if comment.Images, err = s.imageIDs(comment.Text); err != nil {
+		return "", errors.Wrapf(err, "failed to get images of comment %s", comment.ID)
+	}
 
 	if s.RestrictedWordsMatcher != nil && s.RestrictedWordsMatcher.Match(comment.Locator.SiteID, comment.Text) {
 		return "", ErrRestrictedWordsFound
 	}
 
-	func() { // keep input title and set to extracted if missing
-		if s.TitleExtractor == nil || comment.PostTitle != "" {
+	var page *PageInfo
+	func() { // keep input title, set to post's title or to extracted if missing
+		if comment.PostTitle != "" {
+			return
+		}
+		if post, e := s.Post(comment.Locator); e == nil && post.Title != "" {
+			comment.PostTitle = post.Title
 			return
 		}
-		title, e := s.TitleExtractor.Get(comment.Locator.URL)
+		if s.TitleExtractor == nil {
+			return
+		}
+		info, e := s.TitleExtractor.Page(comment.Locator.URL)
 		if e != nil {
 			log.Printf("[WARN] failed to set title, %v", e)
 			return
 		}
-		comment.PostTitle = title
+		comment.PostTitle, page = info.Title, &info
 	}()
 
 	s.submitImages(comment.Locator, comment.ID)
@@ -107,7 +156,15 @@ func (s *DataStore) Create(comment store.Comment) (commentID string, err error)
 		log.Printf("[WARN] failed to send create event, %s", e)
 	}
 
-	return s.Engine.Create(comment)
+	if commentID, err = s.Engine.Create(comment); err != nil {
+		return commentID, err
+	}
+	if page != nil && page.Image != "" {
+		s.setPostImage(comment.Locator, page.Image)
+	}
+	s.addChange(comment.Locator.SiteID, changelog.Record{Kind: changelog.KindCreate, Locator: comment.Locator,
+		CommentID: comment.ID, UserID: comment.User.ID, Comment: changedComment(comment)})
+	return commentID, nil
 }
 
 // Find wraps engine's Find call and alter results if needed. User used to alter comments
@@ -154,9 +211,16 @@ func (s *DataStore) Get(locator store.Locator, commentID string, user store.User
 }
 
 // Put updates comment, mutable parts only
-func (s *DataStore) Put(locator store.Locator, comment store.Comment) error {
+func (s *DataStore) Put(locator store.Locator, comment store.Comment) (err error) {
 	comment.Locator = locator
-	return s.Engine.Update(comment)
+	if comment.Images, err = s.imageIDs(comment.Text); err != nil {
+		return errors.Wrapf(err, "failed to get images of comment %s", comment.ID)
+	}
+	if err = s.Engine.Update(comment); err != nil {
+		return err
+	}
+	s.logUpdate(comment)
+	return nil
 }
 
 // GetUserEmail gets user email
@@ -249,7 +313,11 @@ func (s *DataStore) prepareNewComment(comment store.Comment) (store.Comment, err
 // DeleteAll removes all data from site
 func (s *DataStore) DeleteAll(siteID string) error {
 	req := engine.DeleteRequest{Locator: store.Locator{SiteID: siteID}}
-	return s.Engine.Delete(req)
+	if err := s.Engine.Delete(req); err != nil {
+		return err
+	}
+	s.addChange(siteID, changelog.Record{Kind: changelog.KindReset, Locator: store.Locator{SiteID: siteID}})
+	return nil
 }
 
 // SetPin pin/un-pin comment as special
@@ -260,7 +328,11 @@ func (s *DataStore) SetPin(locator store.Locator, commentID string, status bool)
 	}
 	comment.Pin = status
 	comment.Locator = locator
-	return s.Engine.Update(comment)
+	if err = s.Engine.Update(comment); err != nil {
+		return err
+	}
+	s.logUpdate(comment)
+	return nil
 }
 
 // VoteReq is the request ot make a vote
@@ -358,7 +430,12 @@ func (s *DataStore) Vote(req VoteReq) (comment store.Comment, err error) {
 
 	comment.Controversy = s.controversy(s.upsAndDowns(comment))
 	comment.Locator = req.Locator
-	return comment, s.Engine.Update(comment)
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, err
+	}
+	s.addChange(comment.Locator.SiteID, changelog.Record{Kind: changelog.KindVote, Locator: comment.Locator,
+		CommentID: comment.ID, UserID: req.UserID, Comment: changedComment(comment)})
+	return comment, nil
 }
 
 func (s *DataStore) isSameIPVote(req VoteReq, userIPHash string, comment store.Comment) bool {
@@ -377,6 +454,30 @@ func (s *DataStore) isSameIPVote(req VoteReq, userIPHash string, comment store.C
 	return false
 }
 
+// AddPreviews sets link previews of the comment, if the text is still the one previews made for.
+// Comment edited or deleted meanwhile left as is
+func (s *DataStore) AddPreviews(locator store.Locator, commentID, text string, previews []store.LinkPreview) error {
+	lock := s.getScopedLocks(locator.URL)
+	lock.Lock()
+	defer lock.Unlock()
+
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return err
+	}
+	if comment.Deleted || comment.Text != text {
+		log.Printf("[DEBUG] comment %s changed, link previews dropped", commentID)
+		return nil
+	}
+	comment.Previews = previews
+	comment.Sanitize()
+	if err = s.Engine.Update(comment); err != nil {
+		return err
+	}
+	s.logUpdate(comment)
+	return nil
+}
+
 // controversy calculates controversial index of votes
 // source - https://github.com/reddit-archive/reddit/blob/master/r2/r2/lib/db/_sorts.pyx#L60
 func (s *DataStore) controversy(ups, downs int) float64 {
@@ -423,7 +524,12 @@ func (s *DataStore) EditComment(locator store.Locator, commentID string, req Edi
 		}
 		comment.Deleted = true
 		delReq := engine.DeleteRequest{Locator: locator, CommentID: commentID, DeleteMode: store.SoftDelete}
-		return comment, s.Engine.Delete(delReq)
+		if err = s.Engine.Delete(delReq); err != nil {
+			return comment, err
+		}
+		s.addChange(locator.SiteID, changelog.Record{Kind: changelog.KindDelete, Locator: locator,
+			CommentID: commentID, UserID: comment.User.ID})
+		return comment, nil
 	}
 
 	if s.RestrictedWordsMatcher != nil && s.RestrictedWordsMatcher.Match(comment.Locator.SiteID, req.Text) {
@@ -432,19 +538,27 @@ func (s *DataStore) EditComment(locator store.Locator, commentID string, req Edi
 
 	comment.Text = req.Text
 	comment.Orig = req.Orig
+	comment.Previews = nil // previews of the new text added later
 	comment.Edit = &store.Edit{
 		Timestamp: time.Now(),
 		Summary:   req.Summary,
 	}
 	comment.Locator = locator
 	comment.Sanitize()
+	if comment.Images, err = s.imageIDs(comment.Text); err != nil {
+		return comment, errors.Wrapf(err, "failed to get images of comment %s", commentID)
+	}
 
 	if e := s.AdminStore.OnEvent(comment.Locator.SiteID, admin.EvUpdate); e != nil {
 		log.Printf("[WARN] failed to send update event, %s", e)
 	}
 
-	err = s.Engine.Update(comment)
-	return comment, err
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, err
+	}
+	s.submitImages(locator, commentID) // commit images added by edit
+	s.logUpdate(comment)
+	return comment, nil
 }
 
 // HasReplies checks if there is any reply to the comments
@@ -515,33 +629,201 @@ func (s *DataStore) UserReplies(siteID, userID string, limit int, duration time.
 	return replies, userName, nil
 }
 
-// SetTitle puts title from the locator.URL page and overwrites any existing title
+// SetTitle puts title from the locator.URL page to the post of the comment and all its comments,
+// overwrites any existing title. Returns updated comment
 func (s *DataStore) SetTitle(locator store.Locator, commentID string) (comment store.Comment, err error) {
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return comment, err
+	}
+	postLocator := store.Locator{SiteID: locator.SiteID, URL: comment.Locator.URL}
+	if _, err = s.SetPostTitle(postLocator); err != nil {
+		return comment, err
+	}
+	return s.Engine.Get(engine.GetRequest{Locator: postLocator, CommentID: commentID})
+}
+
+// SetPostTitle puts title and OpenGraph image from the post's page to the post record, overwrites existing ones.
+// Title of all post's comments updated as well
+func (s *DataStore) SetPostTitle(locator store.Locator) (store.Post, error) {
 	if s.TitleExtractor == nil {
-		return comment, errors.New("no title extractor")
+		return store.Post{}, errors.New("no title extractor")
 	}
+	post, err := s.Post(locator)
+	if err != nil {
+		return post, err
+	}
+	info, err := s.TitleExtractor.Page(post.Locator.URL)
+	if err != nil {
+		return post, errors.Wrapf(err, "can't get title for %s", post.Locator.URL)
+	}
+	post.Title = info.Title
+	if info.Image != "" {
+		post.Image = info.Image
+	}
+	return s.savePost(post)
+}
 
-	comment, err = s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+// Post returns post record by url or alias of the post
+func (s *DataStore) Post(locator store.Locator) (store.Post, error) {
+	posts, err := s.Engine.Post(engine.PostRequest{Locator: locator})
 	if err != nil {
-		return comment, err
+		return store.Post{}, err
+	}
+	if len(posts) == 0 {
+		return store.Post{}, errors.Errorf("no post %s", locator.URL)
 	}
+	return posts[0], nil
+}
 
-	// set title, overwrite the current one
-	title, e := s.TitleExtractor.Get(comment.Locator.URL)
-	if e != nil {
-		return comment, err
+// Posts returns all post records of the site
+func (s *DataStore) Posts(siteID string) ([]store.Post, error) {
+	return s.Engine.Post(engine.PostRequest{Locator: store.Locator{SiteID: siteID}})
+}
+
+// UpdatePost sets title, image and aliases of the post, makes post record if missing. Read-only status,
+// creation time of the post and its title, if not set in update, kept. Title of all post's comments updated as well
+func (s *DataStore) UpdatePost(locator store.Locator, upd store.Post) (store.Post, error) {
+	locator = s.URLNormalizer.Locator(locator)
+	post, err := s.Post(locator)
+	if err != nil {
+		post = store.Post{Locator: locator}
 	}
-	comment.PostTitle = title
-	comment.Locator = locator
-	err = s.Engine.Update(comment)
-	return comment, err
+	if upd.Title != "" {
+		post.Title = upd.Title
+	}
+	post.Image, post.Aliases = upd.Image, nil
+	for _, alias := range upd.Aliases {
+		post.Aliases = append(post.Aliases, s.URLNormalizer.URL(locator.SiteID, alias))
+	}
+	return s.savePost(post)
+}
+
+// savePost saves post record and sets post's title to all comments of the post, under the post's lock
+func (s *DataStore) savePost(post store.Post) (store.Post, error) {
+	posts, err := s.Engine.Post(engine.PostRequest{Locator: store.Locator{SiteID: post.Locator.SiteID}, Update: &post})
+	if err != nil {
+		return post, errors.Wrapf(err, "can't save post %s", post.Locator.URL)
+	}
+	if len(posts) > 0 {
+		post = posts[0]
+	}
+
+	cLock := s.getScopedLocks(post.Locator.URL) // the same lock as used by votes of the post
+	cLock.Lock()
+	defer cLock.Unlock()
+
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: post.Locator})
+	if err != nil {
+		return post, nil // no comments yet
+	}
+	for _, c := range comments {
+		if c.PostTitle == post.Title {
+			continue
+		}
+		c.PostTitle = post.Title
+		if err = s.Engine.Update(c); err != nil {
+			return post, errors.Wrapf(err, "can't set title for comment %s", c.ID)
+		}
+		s.logUpdate(c)
+	}
+	return post, nil
+}
+
+// setPostImage sets OpenGraph image of the post if missing
+func (s *DataStore) setPostImage(locator store.Locator, image string) {
+	post, err := s.Post(locator)
+	if err != nil || post.Image != "" {
+		return
+	}
+	post.Image = image
+	if _, err = s.Engine.Post(engine.PostRequest{Locator: store.Locator{SiteID: locator.SiteID}, Update: &post}); err != nil {
+		log.Printf("[WARN] failed to set image for post %s, %v", locator.URL, err)
+	}
+}
+
+// MoveComment moves the comment with all its replies to another post, the comment becomes top-level there.
+// Returns moved comments
+func (s *DataStore) MoveComment(locator store.Locator, commentID string, to store.Locator) ([]store.Comment, error) {
+	to = s.URLNormalizer.Locator(to)
+	unlock := s.lockPosts(locator.URL, to.URL)
+	defer unlock()
+
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: locator, Sort: "time"})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get comments of %s", locator.URL)
+	}
+	replies, found := map[string][]string{}, false
+	for _, c := range comments {
+		replies[c.ParentID] = append(replies[c.ParentID], c.ID)
+		found = found || c.ID == commentID
+	}
+	if !found {
+		return nil, errors.Errorf("no comment %s in %s", commentID, locator.URL)
+	}
+
+	ids := []string{commentID}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, replies[ids[i]]...)
+	}
+	return s.move(engine.MoveRequest{Locator: locator, CommentIDs: ids, To: to})
+}
+
+// MergePosts moves all comments of the post to another post, merged post removed. Aliases of merged post
+// become aliases of the target post, with alias set the url of merged post as well. Returns moved comments
+func (s *DataStore) MergePosts(locator store.Locator, to store.Locator, alias bool) ([]store.Comment, error) {
+	to = s.URLNormalizer.Locator(to)
+	unlock := s.lockPosts(locator.URL, to.URL)
+	defer unlock()
+
+	var aliases []string
+	if src, err := s.Post(locator); err == nil {
+		aliases = src.Aliases
+	}
+	moved, err := s.move(engine.MoveRequest{Locator: locator, To: to})
+	if err != nil {
+		return moved, err
+	}
+	if alias {
+		aliases = append(aliases, s.URLNormalizer.URL(locator.SiteID, locator.URL))
+	}
+	if len(aliases) == 0 {
+		return moved, nil
+	}
+	post, err := s.Post(moved[0].Locator)
+	if err != nil {
+		return moved, err
+	}
+	post.Aliases = append(post.Aliases, aliases...)
+	if _, err = s.Engine.Post(engine.PostRequest{Locator: post.Locator, Update: &post}); err != nil {
+		return moved, errors.Wrapf(err, "can't add aliases %v to %s", aliases, post.Locator.URL)
+	}
+	return moved, nil
+}
+
+// move moves comments with engine and logs them as deleted from the source post and created in the target one
+func (s *DataStore) move(req engine.MoveRequest) ([]store.Comment, error) {
+	req.To = s.URLNormalizer.Locator(req.To)
+	moved, err := s.Engine.Move(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(moved) == 0 {
+		return nil, errors.Errorf("no comments moved from %s", req.Locator.URL)
+	}
+	for _, c := range moved {
+		s.addChange(req.Locator.SiteID, changelog.Record{Kind: changelog.KindDelete, Locator: req.Locator, CommentID: c.ID})
+		s.addChange(c.Locator.SiteID, changelog.Record{Kind: changelog.KindCreate, Locator: c.Locator,
+			CommentID: c.ID, UserID: c.User.ID, Comment: changedComment(c)})
+	}
+	return moved, nil
 }
 
 // Counts returns postID+count list for given comments
 func (s *DataStore) Counts(siteID string, postIDs []string) ([]store.PostInfo, error) {
 	res := []store.PostInfo{}
 	for _, p := range postIDs {
-		req := engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: p}}
+		req := engine.FindRequest{Locator: s.URLNormalizer.Locator(store.Locator{SiteID: siteID, URL: p})}
 		if c, err := s.Engine.Count(req); err == nil {
 			res = append(res, store.PostInfo{URL: p, Count: c})
 		}
@@ -596,9 +878,12 @@ func (s *DataStore) SetReadOnly(locator store.Locator, status bool) error {
 		roStatus = engine.FlagTrue
 
 	}
-	req := engine.FlagRequest{Locator: locator, Flag: engine.ReadOnly, Update: roStatus}
-	_, err := s.Engine.Flag(req)
-	return err
+	req := engine.FlagRequest{Locator: s.URLNormalizer.Locator(locator), Flag: engine.ReadOnly, Update: roStatus}
+	if _, err := s.Engine.Flag(req); err != nil {
+		return err
+	}
+	s.logFlag(req.Locator, "", engine.ReadOnly, status)
+	return nil
 }
 
 // IsVerified checks if user verified
@@ -615,8 +900,11 @@ func (s *DataStore) SetVerified(siteID string, userID string, status bool) error
 		roStatus = engine.FlagTrue
 	}
 	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Flag: engine.Verified, Update: roStatus}
-	_, err := s.Engine.Flag(req)
-	return err
+	if _, err := s.Engine.Flag(req); err != nil {
+		return err
+	}
+	s.logFlag(req.Locator, userID, engine.Verified, status)
+	return nil
 }
 
 // IsBlocked checks if user blocked
@@ -634,8 +922,11 @@ func (s *DataStore) SetBlock(siteID string, userID string, status bool, ttl time
 	}
 	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID,
 		Flag: engine.Blocked, Update: roStatus, TTL: ttl}
-	_, err := s.Engine.Flag(req)
-	return err
+	if _, err := s.Engine.Flag(req); err != nil {
+		return err
+	}
+	s.logFlag(req.Locator, userID, engine.Blocked, status)
+	return nil
 }
 
 // BlockedUsers returns list with all blocked users for given siteID
@@ -669,13 +960,21 @@ func (s *DataStore) Delete(locator store.Locator, commentID string, mode store.D
 		log.Printf("[WARN] failed to send delete event, %s", e)
 	}
 	req := engine.DeleteRequest{Locator: locator, CommentID: commentID, DeleteMode: mode}
-	return s.Engine.Delete(req)
+	if err := s.Engine.Delete(req); err != nil {
+		return err
+	}
+	s.addChange(locator.SiteID, changelog.Record{Kind: changelog.KindDelete, Locator: locator, CommentID: commentID})
+	return nil
 }
 
 // DeleteUser removes all comments from user
 func (s *DataStore) DeleteUser(siteID string, userID string, mode store.DeleteMode) error {
 	req := engine.DeleteRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, DeleteMode: mode}
-	return s.Engine.Delete(req)
+	if err := s.Engine.Delete(req); err != nil {
+		return err
+	}
+	s.addChange(siteID, changelog.Record{Kind: changelog.KindDelete, Locator: req.Locator, UserID: userID})
+	return nil
 }
 
 // List of commented posts
@@ -707,6 +1006,27 @@ func (s *DataStore) Metas(siteID string) (umetas []UserMetaData, pmetas []PostMe
 		}
 	}
 
+	// add images and aliases of post records, titles restored from comments
+	records, err := s.Posts(siteID)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "can't get post records for %s", siteID)
+	}
+	for _, r := range records {
+		if r.Image == "" && len(r.Aliases) == 0 {
+			continue
+		}
+		found := false
+		for i := range pmetas {
+			if pmetas[i].URL == r.Locator.URL {
+				pmetas[i].Image, pmetas[i].Aliases, found = r.Image, r.Aliases, true
+				break
+			}
+		}
+		if !found {
+			pmetas = append(pmetas, PostMetaData{URL: r.Locator.URL, Image: r.Image, Aliases: r.Aliases})
+		}
+	}
+
 	// set users meta, key is userID
 	m := map[string]UserMetaData{}
 
@@ -771,6 +1091,9 @@ func (s *DataStore) SetMetas(siteID string, umetas []UserMetaData, pmetas []Post
 		if pm.ReadOnly {
 			errs = multierror.Append(errs, s.SetReadOnly(store.Locator{SiteID: siteID, URL: pm.URL}, true))
 		}
+		if pm.Image != "" || len(pm.Aliases) > 0 {
+			errs = multierror.Append(errs, s.setPostMeta(store.Locator{SiteID: siteID, URL: pm.URL}, pm))
+		}
 	}
 
 	// save users metas
@@ -792,6 +1115,23 @@ func (s *DataStore) SetMetas(siteID string, umetas []UserMetaData, pmetas []Post
 	return errs.ErrorOrNil()
 }
 
+// setPostMeta sets image and aliases of the post record, makes the record if missing
+func (s *DataStore) setPostMeta(locator store.Locator, pm PostMetaData) error {
+	locator = s.URLNormalizer.Locator(locator)
+	post, err := s.Post(locator)
+	if err != nil {
+		post = store.Post{Locator: locator}
+	}
+	post.Image, post.Aliases = pm.Image, nil
+	for _, alias := range pm.Aliases {
+		post.Aliases = append(post.Aliases, s.URLNormalizer.URL(locator.SiteID, alias))
+	}
+	if _, err = s.Engine.Post(engine.PostRequest{Locator: store.Locator{SiteID: locator.SiteID}, Update: &post}); err != nil {
+		return errors.Wrapf(err, "can't set meta of post %s", locator.URL)
+	}
+	return nil
+}
+
 // User gets comment for given userID on siteID
 func (s *DataStore) User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error) {
 	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID,
@@ -821,7 +1161,61 @@ func (s *DataStore) Last(siteID string, limit int, since time.Time, user store.U
 
 // Close store service
 func (s *DataStore) Close() error {
-	return s.Engine.Close()
+	errs := new(multierror.Error)
+	errs = multierror.Append(errs, s.Engine.Close())
+	errs = multierror.Append(errs, s.ChangeLog.Close())
+	errs = multierror.Append(errs, s.PubSub.Close())
+	return errs.ErrorOrNil()
+}
+
+// addChange adds record to the change log and publishes comment's changes to live updates subscribers
+func (s *DataStore) addChange(siteID string, rec changelog.Record) {
+	s.ChangeLog.Add(siteID, rec)
+
+	event := pubsub.Event{Locator: rec.Locator, CommentID: rec.CommentID, Timestamp: rec.Timestamp}
+	switch rec.Kind {
+	case changelog.KindCreate:
+		event.Type = pubsub.EventCreate
+	case changelog.KindUpdate:
+		event.Type = pubsub.EventUpdate
+	case changelog.KindDelete:
+		event.Type = pubsub.EventDelete
+	case changelog.KindVote:
+		event.Type = pubsub.EventVote
+	default:
+		return // flags and reset are not interesting for live updates
+	}
+	if rec.CommentID == "" {
+		event.UserID = rec.UserID // all user's comments deleted
+	}
+	if rec.Comment != nil {
+		c := s.alterComment(*rec.Comment, nonAdminUser) // subscribers are public, see the comment as public api shows it
+		event.Comment = &c
+	}
+	if event.Locator.SiteID == "" {
+		event.Locator.SiteID = siteID
+	}
+	s.PubSub.Publish(event)
+}
+
+// logUpdate adds update record for the comment to the change log
+func (s *DataStore) logUpdate(c store.Comment) {
+	s.addChange(c.Locator.SiteID, changelog.Record{Kind: changelog.KindUpdate, Locator: c.Locator,
+		CommentID: c.ID, UserID: c.User.ID, Comment: changedComment(c)})
+}
+
+// logFlag adds flag record for the post or the user to the change log
+func (s *DataStore) logFlag(locator store.Locator, userID string, flag engine.Flag, status bool) {
+	s.addChange(locator.SiteID, changelog.Record{Kind: changelog.KindFlag, Locator: locator,
+		UserID: userID, Flag: string(flag), FlagValue: status})
+}
+
+// changedComment makes a copy of the comment for change log with voters and their ips dropped
+func changedComment(c store.Comment) *store.Comment {
+	c.Votes = nil
+	c.VotedIPs = nil
+	c.Vote = 0
+	return &c
 }
 
 func (s *DataStore) upsAndDowns(c store.Comment) (ups, downs int) {
@@ -835,6 +1229,27 @@ func (s *DataStore) upsAndDowns(c store.Comment) (ups, downs int) {
 	return ups, downs
 }
 
+// lockPosts takes scoped locks of posts in order of urls, so concurrent calls can't deadlock.
+// Returns func releasing the locks
+func (s *DataStore) lockPosts(urls ...string) (unlock func()) {
+	sorted := append([]string{}, urls...)
+	sort.Strings(sorted)
+	locks := []sync.Locker{}
+	for i, u := range sorted {
+		if i > 0 && u == sorted[i-1] {
+			continue
+		}
+		lock := s.getScopedLocks(u)
+		lock.Lock()
+		locks = append(locks, lock)
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
+
 // getScopedLocks pull lock from the map if found or create a new one
 func (s *DataStore) getScopedLocks(id string) (lock sync.Locker) {
 	s.scopedLocks.Do(func() { s.scopedLocks.locks = map[string]sync.Locker{} })
@@ -24,8 +24,10 @@ import (
 
 	"github.com/umputun/remark/backend/app/store"
 	"github.com/umputun/remark/backend/app/store/admin"
+	"github.com/umputun/remark/backend/app/store/changelog"
 	"github.com/umputun/remark/backend/app/store/engine"
 	"github.com/umputun/remark/backend/app/store/image"
+	"github.com/umputun/remark/backend/app/store/pubsub"
 )
 
 func TestService_CreateFromEmpty(t *testing.T) {
@@ -198,6 +200,174 @@ func TestService_SetTitle(t *testing.T) {
 	require.EqualError(t, err, "no title extractor")
 }
 
+func TestService_Post(t *testing.T) {
+	tss := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(`<html><head><title>post title</title><meta property="og:image" content="/img.png"></head></html>`))
+		assert.NoError(t, err)
+	}))
+	defer tss.Close()
+
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"),
+		TitleExtractor: NewTitleExtractor(http.Client{Timeout: 5 * time.Second})}
+
+	// title and image of the new post extracted
+	comment := store.Comment{Text: "text", User: store.User{ID: "user", Name: "name"},
+		Locator: store.Locator{URL: tss.URL + "/post1", SiteID: "radio-t"}}
+	_, err := b.Create(comment)
+	require.NoError(t, err)
+	post, err := b.Post(store.Locator{URL: tss.URL + "/post1", SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, "post title", post.Title)
+	assert.Equal(t, tss.URL+"/img.png", post.Image)
+
+	// title and aliases of existing post set
+	post, err = b.UpdatePost(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		store.Post{Title: "new title", Aliases: []string{"https://radio-t.com/"}})
+	require.NoError(t, err)
+	assert.Equal(t, store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, post.Locator)
+	assert.Equal(t, "new title", post.Title)
+	comments, err := b.Find(store.Locator{URL: "https://radio-t.com/", SiteID: "radio-t"}, "time", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(comments))
+	assert.Equal(t, "new title", comments[0].PostTitle, "title of comments updated")
+	assert.Equal(t, "new title", comments[1].PostTitle, "title of comments updated")
+
+	// new comment by alias takes post's title
+	comment = store.Comment{Text: "text", User: store.User{ID: "user", Name: "name"},
+		Locator: store.Locator{URL: "https://radio-t.com/", SiteID: "radio-t"}}
+	id, err := b.Create(comment)
+	require.NoError(t, err)
+	c, err := b.Get(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, id, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "new title", c.PostTitle)
+	assert.Equal(t, "https://radio-t.com", c.Locator.URL)
+
+	// read-only status kept
+	require.NoError(t, b.SetReadOnly(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, true))
+	post, err = b.UpdatePost(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, store.Post{Title: "title 2"})
+	require.NoError(t, err)
+	assert.True(t, post.ReadOnly)
+	assert.Empty(t, post.Aliases, "aliases removed")
+
+	// title kept by update without title
+	post, err = b.UpdatePost(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, store.Post{Image: "https://radio-t.com/img.png"})
+	require.NoError(t, err)
+	assert.Equal(t, "title 2", post.Title)
+	assert.Equal(t, "https://radio-t.com/img.png", post.Image)
+	c, err = b.Get(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, id, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "title 2", c.PostTitle)
+
+	// title extracted for the post
+	_, err = b.UpdatePost(store.Locator{URL: tss.URL + "/post1", SiteID: "radio-t"}, store.Post{Title: "something"})
+	require.NoError(t, err)
+	post, err = b.SetPostTitle(store.Locator{URL: tss.URL + "/post1", SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, "post title", post.Title)
+	assert.Equal(t, tss.URL+"/img.png", post.Image)
+
+	_, err = b.SetPostTitle(store.Locator{URL: tss.URL + "/unknown", SiteID: "radio-t"})
+	assert.Error(t, err)
+
+	posts, err := b.Posts("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(posts))
+}
+
+func TestService_MoveComment(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	src, dst := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}
+	for i, parent := range []string{"id-1", "id-3", "id-2"} {
+		_, err := b.Create(store.Comment{ID: fmt.Sprintf("id-%d", i+3), ParentID: parent, Text: "reply",
+			Locator: src, User: store.User{ID: "user2"}})
+		require.NoError(t, err)
+	}
+
+	moved, err := b.MoveComment(src, "id-1", dst)
+	require.NoError(t, err)
+	ids := []string{}
+	for _, c := range moved {
+		ids = append(ids, c.ID)
+	}
+	assert.ElementsMatch(t, []string{"id-1", "id-3", "id-4"}, ids, "comment moved with replies")
+
+	comments, err := b.Find(dst, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(comments))
+	comments, err = b.Find(src, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments))
+
+	_, err = b.MoveComment(src, "id-1", dst)
+	assert.EqualError(t, err, "no comment id-1 in https://radio-t.com")
+
+	// moves in opposite directions locked in the same order
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _ = b.MoveComment(dst, "id-1", src)
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = b.MoveComment(src, "id-1", dst)
+		}()
+	}
+	wg.Wait()
+	count, err := b.Count(src)
+	require.NoError(t, err)
+	count2, err := b.Count(dst)
+	require.NoError(t, err)
+	assert.Equal(t, 5, count+count2, "all comments kept")
+}
+
+func TestService_MergePosts(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	src, dst := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{Text: "text", Locator: dst, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	_, err = b.UpdatePost(src, store.Post{Aliases: []string{"https://radio-t.com/old"}})
+	require.NoError(t, err)
+
+	moved, err := b.MergePosts(src, dst, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(moved))
+
+	count, err := b.Count(dst)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	post, err := b.Post(src)
+	require.NoError(t, err)
+	assert.Equal(t, dst, post.Locator, "merged post url is alias now")
+	assert.Equal(t, []string{"https://radio-t.com/old", "https://radio-t.com"}, post.Aliases, "aliases of merged post kept")
+
+	// aliases moved without alias of merged post url
+	third := store.Locator{URL: "https://radio-t.com/3", SiteID: "radio-t"}
+	_, err = b.Create(store.Comment{Text: "text", Locator: third, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	_, err = b.UpdatePost(third, store.Post{Aliases: []string{"https://radio-t.com/3-old"}})
+	require.NoError(t, err)
+	_, err = b.MergePosts(third, dst, false)
+	require.NoError(t, err)
+	post, err = b.Post(store.Locator{URL: "https://radio-t.com/3-old", SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, dst, post.Locator)
+	_, err = b.Post(third)
+	assert.Error(t, err, "url of merged post not alias")
+
+	_, err = b.MergePosts(store.Locator{URL: "https://radio-t.com/4", SiteID: "radio-t"}, dst, false)
+	assert.Error(t, err)
+}
+
 func TestService_Vote(t *testing.T) {
 
 	eng, teardown := prepStoreEngine(t)
@@ -633,6 +803,38 @@ func TestService_EditComment(t *testing.T) {
 	assert.NoError(t, err, "allow second edit")
 }
 
+func TestService_AddPreviews(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	previews := []store.LinkPreview{{URL: "https://example.com", Title: "<b>title</b>", Image: "javascript:alert(1)"}}
+
+	res, err := b.Last("radio-t", 0, time.Time{}, store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+
+	require.NoError(t, b.AddPreviews(locator, res[0].ID, res[0].Text, previews))
+	c, err := b.Engine.Get(getReq(locator, res[0].ID))
+	require.NoError(t, err)
+	assert.Equal(t, res[0].Text, c.Text, "text not changed")
+	assert.Equal(t, []store.LinkPreview{{URL: "https://example.com", Title: "&lt;b&gt;title&lt;/b&gt;"}}, c.Previews, "sanitized")
+
+	// edited meanwhile
+	require.NoError(t, b.AddPreviews(locator, res[1].ID, "old text", previews))
+	c, err = b.Engine.Get(getReq(locator, res[1].ID))
+	require.NoError(t, err)
+	assert.Empty(t, c.Previews, "previews dropped")
+
+	assert.Error(t, b.AddPreviews(locator, "no-such-id", "", previews))
+
+	// edit drops previews of the old text
+	b.EditDuration = time.Hour * 24 * 365 * 10
+	c, err = b.EditComment(locator, res[0].ID, EditRequest{Orig: "new", Text: "new"})
+	require.NoError(t, err)
+	assert.Empty(t, c.Previews)
+}
+
 func TestService_DeleteComment(t *testing.T) {
 
 	eng, teardown := prepStoreEngine(t)
@@ -654,6 +856,93 @@ func TestService_DeleteComment(t *testing.T) {
 	t.Logf("%+v", c)
 }
 
+func TestService_ChangeLog(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	changeLog := changelog.NewService(changelog.NewMemoryStorage(), 0)
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), ChangeLog: changeLog, MaxVotes: -1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	id, err := b.Create(store.Comment{Text: "text", User: store.User{IP: "192.168.1.1", ID: "user", Name: "name"}, Locator: locator})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: id, UserID: "user2", Val: true})
+	require.NoError(t, err)
+	_, err = b.EditComment(locator, id, EditRequest{Text: "edited", Orig: "edited"})
+	require.NoError(t, err)
+	require.NoError(t, b.SetReadOnly(locator, true))
+	require.NoError(t, b.Delete(locator, id, store.SoftDelete))
+
+	recs, err := changeLog.Since("radio-t", 0, 100)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(recs))
+
+	assert.Equal(t, changelog.KindCreate, recs[0].Kind)
+	assert.Equal(t, id, recs[0].CommentID)
+	assert.Equal(t, "text", recs[0].Comment.Text)
+
+	assert.Equal(t, changelog.KindVote, recs[1].Kind)
+	assert.Equal(t, "user2", recs[1].UserID)
+	assert.Equal(t, 1, recs[1].Comment.Score)
+	assert.Nil(t, recs[1].Comment.Votes, "votes not exposed")
+
+	assert.Equal(t, changelog.KindUpdate, recs[2].Kind)
+	assert.Equal(t, "edited", recs[2].Comment.Text)
+
+	assert.Equal(t, changelog.KindFlag, recs[3].Kind)
+	assert.Equal(t, string(engine.ReadOnly), recs[3].Flag)
+	assert.True(t, recs[3].FlagValue)
+
+	assert.Equal(t, changelog.KindDelete, recs[4].Kind)
+	assert.Equal(t, id, recs[4].CommentID)
+	for i, r := range recs {
+		assert.Equal(t, uint64(i+1), r.Seq)
+		assert.Equal(t, "radio-t", r.Locator.SiteID)
+	}
+}
+
+func TestService_PubSub(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	hub := pubsub.NewHub(nil)
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), PubSub: hub, MaxVotes: -1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	sub := hub.Subscribe("radio-t", "https://radio-t.com")
+	defer hub.Unsubscribe(sub)
+
+	require.NoError(t, b.SetVerified("radio-t", "user", true))
+	id, err := b.Create(store.Comment{Text: "text", User: store.User{IP: "192.168.1.1", ID: "user", Name: "name"}, Locator: locator})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: id, UserID: "user2", Val: true})
+	require.NoError(t, err)
+	require.NoError(t, b.SetReadOnly(locator, true)) // flags not published
+	require.NoError(t, b.DeleteUser("radio-t", "user", store.SoftDelete))
+
+	events := []pubsub.Event{}
+	for len(events) < 3 {
+		select {
+		case e := <-sub.C:
+			events = append(events, e)
+		case <-time.After(time.Second):
+			t.Fatalf("only %d events received", len(events))
+		}
+	}
+	assert.Equal(t, pubsub.EventCreate, events[0].Type)
+	assert.Equal(t, id, events[0].CommentID)
+	require.NotNil(t, events[0].Comment)
+	assert.Equal(t, "text", events[0].Comment.Text)
+	assert.Equal(t, "", events[0].Comment.User.IP, "ip hidden")
+	assert.True(t, events[0].Comment.User.Verified, "altered as public api does")
+
+	assert.Equal(t, pubsub.EventVote, events[1].Type)
+	assert.Equal(t, 1, events[1].Comment.Score)
+	assert.Nil(t, events[1].Comment.Votes, "voters hidden")
+
+	assert.Equal(t, pubsub.EventDelete, events[2].Type)
+	assert.Equal(t, "", events[2].CommentID)
+	assert.Equal(t, "user", events[2].UserID)
+	assert.Equal(t, "radio-t", events[2].Locator.SiteID)
+}
+
 func TestService_EditCommentDurationFailed(t *testing.T) {
 
 	eng, teardown := prepStoreEngine(t)
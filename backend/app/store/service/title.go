@@ -17,7 +17,7 @@ const (
 	teCacheTTL     = 15 * time.Minute
 )
 
-// TitleExtractor gets html title from remote page, cached
+// TitleExtractor gets html title and OpenGraph image from remote page, cached
 type TitleExtractor struct {
 	client http.Client
 	cache  lcw.LoadingCache
@@ -37,8 +37,20 @@ func NewTitleExtractor(client http.Client) *TitleExtractor {
 	return &res
 }
 
+// PageInfo keeps title and OpenGraph image of the page
+type PageInfo struct {
+	Title string
+	Image string
+}
+
 // Get page for url and return title
 func (t *TitleExtractor) Get(url string) (string, error) {
+	info, err := t.Page(url)
+	return info.Title, err
+}
+
+// Page gets page for url and returns its title and OpenGraph image
+func (t *TitleExtractor) Page(url string) (PageInfo, error) {
 	client := http.Client{Timeout: t.client.Timeout, Transport: t.client.Transport}
 	b, err := t.cache.Get(url, func() (lcw.Value, error) {
 		resp, err := client.Get(url)
@@ -54,49 +66,76 @@ func (t *TitleExtractor) Get(url string) (string, error) {
 			return nil, errors.Errorf("can't load page %s, code %d", url, resp.StatusCode)
 		}
 
-		title, ok := t.getTitle(resp.Body)
+		info, ok := t.getPage(resp.Body)
 		if !ok {
 			return nil, errors.Errorf("can't get title for %s", url)
 		}
-		return title, nil
+		info.Image = resolveURL(url, info.Image)
+		return info, nil
 	})
 
-	// on error save result (empty string) to cache too and return "" title
+	// on error save result (empty info) to cache too and return "" title
 	if err != nil {
-		_, _ = t.cache.Get(url, func() (lcw.Value, error) { return "", nil })
-		return "", err
+		_, _ = t.cache.Get(url, func() (lcw.Value, error) { return PageInfo{}, nil })
+		return PageInfo{}, err
 	}
 
-	return b.(string), nil
+	return b.(PageInfo), nil
 }
 
 // get title from body reader, traverse recursively
 func (t *TitleExtractor) getTitle(r io.Reader) (string, bool) {
+	info, ok := t.getPage(r)
+	return info.Title, ok
+}
+
+// get title and OpenGraph image from body reader
+func (t *TitleExtractor) getPage(r io.Reader) (PageInfo, bool) {
 	doc, err := html.Parse(r)
 	if err != nil {
 		log.Printf("[WARN] can't get header, %+v", err)
-		return "", false
+		return PageInfo{}, false
 	}
-	return t.traverse(doc)
+	title, ok := t.traverse(doc)
+	return PageInfo{Title: title, Image: t.ogImage(doc)}, ok
 }
 
 func (t *TitleExtractor) isTitleElement(n *html.Node) bool {
 	return n.Type == html.ElementNode && n.Data == "title"
 }
 
-func (t *TitleExtractor) traverse(n *html.Node) (string, bool) {
-	if t.isTitleElement(n) {
-		title := n.FirstChild.Data
-		title = strings.Replace(title, "\n", "", -1)
-		title = strings.TrimSpace(title)
-		return title, true
-	}
+func (t *TitleExtractor) traverse(n *html.Node) (title string, ok bool) {
+	walkHTML(n, func(n *html.Node) bool {
+		if !t.isTitleElement(n) || n.FirstChild == nil {
+			return false
+		}
+		title = strings.Replace(n.FirstChild.Data, "\n", "", -1)
+		title, ok = strings.TrimSpace(title), true
+		return true
+	})
+	return title, ok
+}
 
+func (t *TitleExtractor) ogImage(n *html.Node) (image string) {
+	walkHTML(n, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || n.Data != "meta" || htmlAttr(n, "property") != "og:image" {
+			return false
+		}
+		image = strings.TrimSpace(htmlAttr(n, "content"))
+		return image != ""
+	})
+	return image
+}
+
+// walkHTML traverses html nodes recursively, depth first, till fn returns true
+func walkHTML(n *html.Node, fn func(n *html.Node) bool) bool {
+	if fn(n) {
+		return true
+	}
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		result, ok := t.traverse(c)
-		if ok {
-			return result, ok
+		if walkHTML(c, fn) {
+			return true
 		}
 	}
-	return "", false
+	return false
 }
@@ -119,3 +119,29 @@ func TestTitle_GetFailed(t *testing.T) {
 	}
 	assert.Equal(t, int32(1), atomic.LoadInt32(&hits), "hit once, errors cached")
 }
+
+func TestTitle_Page(t *testing.T) {
+	ex := NewTitleExtractor(http.Client{Timeout: 5 * time.Second})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/og":
+			_, _ = w.Write([]byte(`<html><head><title>blah 123</title><meta property="og:image" content="/img/1.png"></head></html>`))
+		case "/no-image":
+			_, _ = w.Write([]byte(`<html><head><title>blah 345</title></head></html>`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	info, err := ex.Page(ts.URL + "/og")
+	require.NoError(t, err)
+	assert.Equal(t, PageInfo{Title: "blah 123", Image: ts.URL + "/img/1.png"}, info)
+
+	info, err = ex.Page(ts.URL + "/no-image")
+	require.NoError(t, err)
+	assert.Equal(t, PageInfo{Title: "blah 345"}, info)
+
+	_, err = ex.Page(ts.URL + "/bad")
+	assert.Error(t, err)
+}
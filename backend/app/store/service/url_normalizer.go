@@ -0,0 +1,109 @@
+package service
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/umputun/remark/backend/app/store"
+)
+
+// URLPolicy defines normalization of post urls, zero value keeps urls as is
+type URLPolicy struct {
+	Scheme string            // force scheme, http or https
+	Hosts  map[string]string // host aliases, alias -> canonical host
+	Slash  string            // trailing slash of the path, add or strip
+	Query  []string          // allowed query params, all others dropped. nil keeps all params
+	AMP    bool              // strip trailing amp element of the path and amp query param
+}
+
+// URLNormalizer keeps url normalization policies, per site with default one for sites without own policy
+type URLNormalizer struct {
+	Default URLPolicy
+	Sites   map[string]URLPolicy
+}
+
+// Policy returns url normalization policy for the site
+func (n *URLNormalizer) Policy(siteID string) URLPolicy {
+	if p, ok := n.Sites[siteID]; ok {
+		return p
+	}
+	return n.Default
+}
+
+// Locator returns locator with normalized url
+func (n *URLNormalizer) Locator(locator store.Locator) store.Locator {
+	if locator.URL == "" {
+		return locator
+	}
+	locator.URL = n.URL(locator.SiteID, locator.URL)
+	return locator
+}
+
+// URL normalizes post url with site's policy. Urls without host returned as is, as well as
+// all urls for nil normalizer
+func (n *URLNormalizer) URL(siteID, inp string) string {
+	if n == nil {
+		return inp
+	}
+	p := n.Policy(siteID)
+	if p.Empty() {
+		return inp
+	}
+	u, err := url.Parse(inp)
+	if err != nil || u.Host == "" {
+		return inp
+	}
+
+	u.Host = strings.ToLower(u.Host)
+	if canonical, ok := p.Hosts[u.Host]; ok {
+		u.Host = canonical
+	}
+	if p.Scheme != "" {
+		u.Scheme = p.Scheme
+	}
+
+	if p.AMP || p.Query != nil {
+		q := u.Query()
+		for k := range q {
+			if (p.AMP && k == "amp") || (p.Query != nil && !store.Contains(p.Query, k)) {
+				q.Del(k)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	if p.AMP {
+		u.Path, u.RawPath = trimAMP(u.Path), trimAMP(u.RawPath)
+	}
+
+	switch p.Slash {
+	case "strip":
+		u.Path, u.RawPath = strings.TrimSuffix(u.Path, "/"), strings.TrimSuffix(u.RawPath, "/")
+	case "add":
+		// file-like paths, i.e. /post.html, left without slash
+		if u.Path == "" || (!strings.HasSuffix(u.Path, "/") && !strings.Contains(path.Base(u.Path), ".")) {
+			u.Path += "/"
+			if u.RawPath != "" {
+				u.RawPath += "/"
+			}
+		}
+	}
+	return u.String()
+}
+
+// Empty checks if policy has no rules
+func (p URLPolicy) Empty() bool {
+	return p.Scheme == "" && len(p.Hosts) == 0 && p.Slash == "" && p.Query == nil && !p.AMP
+}
+
+// trimAMP removes trailing amp element of the path, keeps trailing slash
+func trimAMP(p string) string {
+	switch {
+	case strings.HasSuffix(p, "/amp"):
+		return strings.TrimSuffix(p, "amp")
+	case strings.HasSuffix(p, "/amp/"):
+		return strings.TrimSuffix(p, "amp/")
+	}
+	return p
+}
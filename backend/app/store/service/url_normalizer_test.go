@@ -0,0 +1,105 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark/backend/app/store"
+	"github.com/umputun/remark/backend/app/store/admin"
+)
+
+func TestURLNormalizer_URL(t *testing.T) {
+	n := URLNormalizer{
+		Default: URLPolicy{Scheme: "https", Hosts: map[string]string{"www.radio-t.com": "radio-t.com",
+			"amp.radio-t.com": "radio-t.com"}, Slash: "strip", Query: []string{"id"}, AMP: true},
+		Sites: map[string]URLPolicy{
+			"keep":  {},
+			"slash": {Slash: "add"},
+		},
+	}
+
+	tbl := []struct {
+		site, inp, out string
+	}{
+		{"radio-t", "https://radio-t.com/p/1", "https://radio-t.com/p/1"},
+		{"radio-t", "http://radio-t.com/p/1/", "https://radio-t.com/p/1"},
+		{"radio-t", "http://WWW.Radio-T.com/p/1", "https://radio-t.com/p/1"},
+		{"radio-t", "https://radio-t.com/p/1/?utm_source=tw&utm_medium=social", "https://radio-t.com/p/1"},
+		{"radio-t", "https://radio-t.com/p/1?utm_source=tw&id=123", "https://radio-t.com/p/1?id=123"},
+		{"radio-t", "https://amp.radio-t.com/p/1/amp/?amp=1", "https://radio-t.com/p/1"},
+		{"radio-t", "https://radio-t.com/p/amp-story", "https://radio-t.com/p/amp-story"},
+		{"radio-t", "https://radio-t.com/", "https://radio-t.com"},
+		{"radio-t", "https://radio-t.com/p/%D1%82%D0%B5%D1%81%D1%82/", "https://radio-t.com/p/%D1%82%D0%B5%D1%81%D1%82"},
+		{"radio-t", "/p/1/", "/p/1/"},
+		{"radio-t", "not a url %%", "not a url %%"},
+		{"keep", "http://www.radio-t.com/p/1/?utm_source=tw", "http://www.radio-t.com/p/1/?utm_source=tw"},
+		{"slash", "https://radio-t.com/p/1", "https://radio-t.com/p/1/"},
+		{"slash", "https://radio-t.com", "https://radio-t.com/"},
+		{"slash", "https://radio-t.com/p/1.html", "https://radio-t.com/p/1.html"},
+		{"slash", "https://radio-t.com/p/1/?x=1", "https://radio-t.com/p/1/?x=1"},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.out, n.URL(tt.site, tt.inp), "case #%d, %s", i, tt.inp)
+	}
+
+	var nilNormalizer *URLNormalizer
+	loc := store.Locator{SiteID: "radio-t", URL: "http://radio-t.com/p/1/"}
+	assert.Equal(t, loc, nilNormalizer.Locator(loc))
+	assert.Equal(t, store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p/1"}, n.Locator(loc))
+}
+
+func TestURLNormalizer_DropAllQuery(t *testing.T) {
+	n := URLNormalizer{Default: URLPolicy{Query: []string{""}}}
+	assert.Equal(t, "https://radio-t.com/p/1", n.URL("radio-t", "https://radio-t.com/p/1?id=1&utm_source=tw"))
+}
+
+func TestService_URLNormalizer(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"),
+		URLNormalizer: &URLNormalizer{Default: URLPolicy{Scheme: "https", Slash: "strip", Query: []string{}}}}
+
+	id, err := b.Create(store.Comment{Text: "text 1", User: store.User{ID: "u1"},
+		Locator: store.Locator{SiteID: "radio-t", URL: "http://radio-t.com/p/new/?utm_source=tw"}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{Text: "text 2", User: store.User{ID: "u2"},
+		Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p/new/"}})
+	require.NoError(t, err)
+
+	canonical := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p/new"}
+	res, err := b.Find(canonical, "time", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, canonical, res[0].Locator)
+	assert.Equal(t, canonical, res[1].Locator)
+	c, err := b.Get(canonical, id, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "text 1", c.Text)
+
+	counts, err := b.Counts("radio-t", []string{"http://radio-t.com/p/new/"})
+	require.NoError(t, err)
+	assert.Equal(t, []store.PostInfo{{URL: "http://radio-t.com/p/new/", Count: 2}}, counts, "requested url kept")
+
+	require.NoError(t, b.SetReadOnly(store.Locator{SiteID: "radio-t", URL: "http://radio-t.com/p/new/"}, true))
+	assert.True(t, b.IsReadOnly(canonical))
+
+	post, err := b.UpdatePost(canonical, store.Post{Title: "new post", Aliases: []string{"http://radio-t.com/p/old/"}})
+	require.NoError(t, err)
+	assert.Equal(t, canonical, post.Locator)
+	assert.Equal(t, []string{"https://radio-t.com/p/old"}, post.Aliases)
+	post, err = b.Post(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p/old"})
+	require.NoError(t, err)
+	assert.Equal(t, canonical, post.Locator)
+
+	// metas of imported posts normalized
+	err = b.SetMetas("radio-t", nil, []PostMetaData{{URL: "http://radio-t.com/p/2/", ReadOnly: true,
+		Image: "https://radio-t.com/2.png", Aliases: []string{"http://radio-t.com/p/2-old/"}}})
+	require.NoError(t, err)
+	post, err = b.Post(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p/2"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://radio-t.com/2.png", post.Image)
+	assert.Equal(t, []string{"https://radio-t.com/p/2-old"}, post.Aliases)
+	assert.True(t, post.ReadOnly)
+}
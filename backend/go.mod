@@ -10,6 +10,7 @@ replace github.com/go-pkgz/lgr v0.7.0 => github.com/ttys3/lgr v1.0.2
 require (
 	github.com/PuerkitoBio/goquery v1.5.1
 	github.com/alecthomas/chroma v0.7.2-0.20200305040604-4f3623dce67a
+	github.com/alicebob/miniredis/v2 v2.14.1
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/didip/tollbooth v4.0.2+incompatible
 	github.com/didip/tollbooth_chi v0.0.0-20170928041846-6ab5f3083f3d
@@ -23,8 +24,10 @@ require (
 	github.com/go-pkgz/repeater v1.1.3
 	github.com/go-pkgz/rest v1.5.0
 	github.com/go-pkgz/syncs v1.1.1
+	github.com/go-redis/redis/v7 v7.0.0-beta.4
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/feeds v1.1.1
+	github.com/gorilla/websocket v1.4.2
 	github.com/hashicorp/go-multierror v1.1.0
 	github.com/jessevdk/go-flags v1.4.1-0.20181221193153-c0795c8afcf4
 	github.com/kyokomi/emoji v2.2.1+incompatible
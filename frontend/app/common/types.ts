@@ -30,6 +30,15 @@ export interface Locator {
   url: string;
 }
 
+/** preview of the page linked from the comment */
+export interface LinkPreview {
+  url: string;
+  title: string;
+  description?: string;
+  image?: string;
+  site_name?: string;
+}
+
 export interface Comment {
   /** comment ID, read only */
   id: string;
@@ -67,6 +76,8 @@ export interface Comment {
   delete?: boolean;
   /** post title */
   title?: string;
+  /** previews of links from the text, added shortly after the comment saved, read only */
+  previews?: LinkPreview[];
   /**
    * @ClientOnly defines whether comments was hidden (deleted)
    *
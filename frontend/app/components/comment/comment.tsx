@@ -774,6 +774,21 @@ class Comment extends Component<Props, State> {
             />
           )}
 
+          {(!props.collapsed || props.view === 'pinned') && !o.delete && !!o.previews && o.previews.length > 0 && (
+            <div className={b('comment__previews', { mix: b('raw-content', {}, { theme: props.theme }) })}>
+              {o.previews.map(p => (
+                <div className="link-preview" key={p.url}>
+                  <a className="link-preview-link" href={p.url}>
+                    {!!p.image && <img className="link-preview-image" src={p.image} alt="" />}
+                    {!!p.site_name && <span className="link-preview-site">{p.site_name}</span>}
+                    <span className="link-preview-title">{p.title}</span>
+                    {!!p.description && <span className="link-preview-description">{p.description}</span>}
+                  </a>
+                </div>
+              ))}
+            </div>
+          )}
+
           {(!props.collapsed || props.view === 'pinned') && (
             <div className="comment__actions">
               {!props.data.delete && !props.isCommentsDisabled && !props.disabled && props.view === 'main' && (
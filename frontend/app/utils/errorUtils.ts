@@ -141,6 +141,62 @@ const messages = defineMessages({
       code: 18,
     },
   },
+  19: {
+    id: 'errors.19',
+    defaultMessage: `You are posting too often on this page. Please wait a bit before the next comment.`,
+    description: {
+      code: 19,
+    },
+  },
+  20: {
+    id: 'errors.20',
+    defaultMessage: `You have reached the daily limit of comments.`,
+    description: {
+      code: 20,
+    },
+  },
+  21: {
+    id: 'errors.21',
+    defaultMessage: `Links are not allowed for new users yet.`,
+    description: {
+      code: 21,
+    },
+  },
+  22: {
+    id: 'errors.22',
+    defaultMessage: `Too many links in the comment.`,
+    description: {
+      code: 22,
+    },
+  },
+  23: {
+    id: 'errors.23',
+    defaultMessage: `You have already posted the same comment.`,
+    description: {
+      code: 23,
+    },
+  },
+  24: {
+    id: 'errors.24',
+    defaultMessage: `You have reached the limit of uploaded images.`,
+    description: {
+      code: 24,
+    },
+  },
+  25: {
+    id: 'errors.25',
+    defaultMessage: `The site has reached the limit of uploaded images.`,
+    description: {
+      code: 25,
+    },
+  },
+  26: {
+    id: 'errors.26',
+    defaultMessage: `You have reached the daily limit of uploaded images.`,
+    description: {
+      code: 26,
+    },
+  },
 });
 
 /**